@@ -2,22 +2,21 @@ package aof
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	rdb "github.com/hdt3213/rdb/core"
-
 	"github.com/hdt3213/godis/config"
 
 	"github.com/hdt3213/godis/interface/database"
 	"github.com/hdt3213/godis/lib/logger"
 	"github.com/hdt3213/godis/lib/utils"
 	"github.com/hdt3213/godis/redis/connection"
-	"github.com/hdt3213/godis/redis/parser"
 	"github.com/hdt3213/godis/redis/protocol"
 )
 
@@ -50,6 +49,9 @@ type Listener interface {
 	Callback([]CmdLine)
 }
 
+// LatencyHook is called-back with duration of operations which may block command execution, such as fsync
+type LatencyHook func(event string, duration time.Duration)
+
 // Persister receive msgs from channel and write to AOF file
 type Persister struct {
 	ctx        context.Context
@@ -62,8 +64,8 @@ type Persister struct {
 	aofFile *os.File
 	// aofFilename is the path of aof file
 	aofFilename string
-	// aofFsync is the strategy of fsync
-	aofFsync string
+	// aofFsync is the strategy of fsync, it holds a string and could be changed by SetFsync
+	aofFsync atomic.Value
 	// aof goroutine will send msg to main goroutine through this channel when aof tasks finished and ready to shut down
 	aofFinished chan struct{}
 	// pause aof for start/finish aof rewrite progress
@@ -72,25 +74,55 @@ type Persister struct {
 	listeners  map[Listener]struct{}
 	// reuse cmdLine buffer
 	buffer []CmdLine
+	// size of aof file at startup or after the latest rewrite
+	baseSize int64
+	// size of aof file, updated atomically
+	currentSize int64
+	// 1 if the latest write failed, updated atomically
+	writeFailed int32
+	// 1 if aof rewrite is in progress, updated atomically
+	rewriting int32
+	// 1 if the latest rewrite failed, updated atomically
+	rewriteFailed int32
+	// unix time of the latest #TS annotation, see writeTimestamp
+	lastTimestamp int64
+	// latencyHook holds a LatencyHook, see SetLatencyHook
+	latencyHook atomic.Value
+}
+
+// Stats describes the state of aof persistence, see INFO persistence
+type Stats struct {
+	Fsync        string
+	BaseSize     int64
+	CurrentSize  int64
+	BufferLength int
+	LastWriteOK  bool
+	// RewriteInProgress is true if BGREWRITEAOF is running
+	RewriteInProgress bool
+	LastRewriteOK     bool
 }
 
 // NewPersister creates a new aof.Persister
 func NewPersister(db database.DBEngine, filename string, load bool, fsync string, tmpDBMaker func() database.DBEngine) (*Persister, error) {
 	persister := &Persister{}
 	persister.aofFilename = filename
-	persister.aofFsync = strings.ToLower(fsync)
+	persister.aofFsync.Store(strings.ToLower(fsync))
 	persister.db = db
 	persister.tmpDBMaker = tmpDBMaker
 	persister.currentDB = 0
 	// load aof file if needed
 	if load {
-		persister.LoadAof(0)
+		err := persister.LoadAof(0)
+		if err != nil {
+			return nil, err
+		}
 	}
 	aofFile, err := os.OpenFile(persister.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
 	if err != nil {
 		return nil, err
 	}
 	persister.aofFile = aofFile
+	persister.resetSize()
 	persister.aofChan = make(chan *payload, aofQueueSize)
 	persister.aofFinished = make(chan struct{})
 	persister.listeners = make(map[Listener]struct{})
@@ -101,10 +133,8 @@ func NewPersister(db database.DBEngine, filename string, load bool, fsync string
 	ctx, cancel := context.WithCancel(context.Background())
 	persister.ctx = ctx
 	persister.cancel = cancel
-	// fsync every second if needed
-	if persister.aofFsync == FsyncEverySec {
-		persister.fsyncEverySecond()
-	}
+	// fsync every second if needed, the ticker always runs since the strategy may be changed at runtime
+	persister.fsyncEverySecond()
 	return persister, nil
 }
 
@@ -122,7 +152,7 @@ func (persister *Persister) SaveCmdLine(dbIndex int, cmdLine CmdLine) {
 		return
 	}
 
-	if persister.aofFsync == FsyncAlways {
+	if persister.fsync() == FsyncAlways {
 		p := &payload{
 			cmdLine: cmdLine,
 			dbIndex: dbIndex,
@@ -150,13 +180,17 @@ func (persister *Persister) writeAof(p *payload) {
 	persister.buffer = persister.buffer[:0] // reuse underlying array
 	persister.pausingAof.Lock()             // prevent other goroutines from pausing aof
 	defer persister.pausingAof.Unlock()
+	if config.Properties.AofTimestampEnabled {
+		persister.writeTimestamp()
+	}
 	// ensure aof is in the right database
 	if p.dbIndex != persister.currentDB {
 		// select db
 		selectCmd := utils.ToCmdLine("SELECT", strconv.Itoa(p.dbIndex))
 		persister.buffer = append(persister.buffer, selectCmd)
 		data := protocol.MakeMultiBulkReply(selectCmd).ToBytes()
-		_, err := persister.aofFile.Write(data)
+		n, err := persister.aofFile.Write(data)
+		persister.recordWrite(n, err)
 		if err != nil {
 			logger.Warn(err)
 			return // skip this command
@@ -166,20 +200,74 @@ func (persister *Persister) writeAof(p *payload) {
 	// save command
 	data := protocol.MakeMultiBulkReply(p.cmdLine).ToBytes()
 	persister.buffer = append(persister.buffer, p.cmdLine)
-	_, err := persister.aofFile.Write(data)
+	n, err := persister.aofFile.Write(data)
+	persister.recordWrite(n, err)
 	if err != nil {
 		logger.Warn(err)
 	}
 	for listener := range persister.listeners {
 		listener.Callback(persister.buffer)
 	}
-	if persister.aofFsync == FsyncAlways {
+	if persister.fsync() == FsyncAlways {
+		start := time.Now()
 		_ = persister.aofFile.Sync()
+		persister.recordLatency("aof-fsync-always", start)
+	}
+}
+
+// writeTimestamp writes a #TS annotation if no annotation has been written in current second,
+// so aof file could be replayed until a point in time
+func (persister *Persister) writeTimestamp() {
+	now := time.Now().Unix()
+	if now <= persister.lastTimestamp {
+		return
+	}
+	n, err := persister.aofFile.Write(MakeTimestampAnnotation(now))
+	persister.recordWrite(n, err)
+	if err != nil {
+		logger.Warn(err)
+		return
+	}
+	persister.lastTimestamp = now
+}
+
+func (persister *Persister) recordWrite(n int, err error) {
+	atomic.AddInt64(&persister.currentSize, int64(n))
+	if err != nil {
+		atomic.StoreInt32(&persister.writeFailed, 1)
+	} else {
+		atomic.StoreInt32(&persister.writeFailed, 0)
+	}
+}
+
+// resetSize sets base size and current size to the size of aof file, after opening or rewriting it
+func (persister *Persister) resetSize() {
+	var size int64
+	if info, err := persister.aofFile.Stat(); err == nil {
+		size = info.Size()
+	}
+	atomic.StoreInt64(&persister.baseSize, size)
+	atomic.StoreInt64(&persister.currentSize, size)
+}
+
+// Stats returns current state of aof persistence
+func (persister *Persister) Stats() Stats {
+	return Stats{
+		Fsync:        persister.fsync(),
+		BaseSize:     atomic.LoadInt64(&persister.baseSize),
+		CurrentSize:  atomic.LoadInt64(&persister.currentSize),
+		BufferLength: len(persister.aofChan),
+		LastWriteOK:  atomic.LoadInt32(&persister.writeFailed) == 0,
+
+		RewriteInProgress: atomic.LoadInt32(&persister.rewriting) == 1,
+		LastRewriteOK:     atomic.LoadInt32(&persister.rewriteFailed) == 0,
 	}
 }
 
 // LoadAof read aof file, can only be used before Persister.listenCmd started
-func (persister *Persister) LoadAof(maxBytes int) {
+// If the file ends with an incomplete command, it is truncated to the last valid command when aof-load-truncated
+// is enabled, otherwise a *CorruptError is returned.
+func (persister *Persister) LoadAof(maxBytes int) error {
 	// persister.db.Exec may call persister.AddAof
 	// delete aofChan to prevent loaded commands back into aofChan
 	aofChan := persister.aofChan
@@ -191,72 +279,96 @@ func (persister *Persister) LoadAof(maxBytes int) {
 	file, err := os.Open(persister.aofFilename)
 	if err != nil {
 		if _, ok := err.(*os.PathError); ok {
-			return
+			return nil
 		}
-		logger.Warn(err)
-		return
+		return err
 	}
 	defer file.Close()
 
 	// load rdb preamble if needed
-	decoder := rdb.NewDecoder(file)
-	err = persister.db.LoadRDB(decoder)
+	preambleSize, err := loadPreamble(file, persister.db.LoadRDB)
 	if err != nil {
-		// no rdb preamble
-		file.Seek(0, io.SeekStart)
-	} else {
-		// has rdb preamble
-		_, _ = file.Seek(int64(decoder.GetReadCount())+1, io.SeekStart)
-		maxBytes = maxBytes - decoder.GetReadCount()
+		return err
 	}
-	var reader io.Reader
+	var reader io.Reader = file
 	if maxBytes > 0 {
-		reader = io.LimitReader(file, int64(maxBytes))
-	} else {
-		reader = file
+		if int64(maxBytes) <= preambleSize {
+			return nil
+		}
+		reader = io.LimitReader(file, int64(maxBytes)-preambleSize)
+	}
+	scanner := NewCmdScanner(reader, preambleSize)
+	until := config.Properties.AofLoadUntilTimestamp
+	if maxBytes <= 0 && until > 0 {
+		scanner.StopAt(until)
 	}
-	ch := parser.ParseStream(reader)
 	fakeConn := connection.NewFakeConn() // only used for save dbIndex
-	for p := range ch {
-		if p.Err != nil {
-			if p.Err == io.EOF {
-				break
-			}
-			logger.Error("parse error: " + p.Err.Error())
-			continue
+	for {
+		cmdLine, err := scanner.Next()
+		if err == io.EOF {
+			break
 		}
-		if p.Data == nil {
-			logger.Error("empty payload")
-			continue
+		if err == ErrTimestampReached {
+			// following commands will never be loaded, drop them to keep aof file consistent with memory
+			logger.Warn(fmt.Sprintf("stop loading append only file at timestamp %d, truncate it to offset %d",
+				until, scanner.Offset()))
+			return os.Truncate(persister.aofFilename, scanner.Offset())
 		}
-		r, ok := p.Data.(*protocol.MultiBulkReply)
-		if !ok {
-			logger.Error("require multi bulk protocol")
-			continue
+		if err != nil {
+			corrupt, ok := err.(*CorruptError)
+			if !ok || !corrupt.Truncated || maxBytes > 0 || !config.Properties.AofLoadTruncated {
+				return err
+			}
+			logger.Warn(corrupt.Error() + ", truncate append only file to the last valid command")
+			return os.Truncate(persister.aofFilename, corrupt.Offset)
 		}
-		ret := persister.db.Exec(fakeConn, r.Args)
+		ret := persister.db.Exec(fakeConn, cmdLine)
 		if protocol.IsErrorReply(ret) {
 			logger.Error("exec err", string(ret.ToBytes()))
 		}
-		if strings.ToLower(string(r.Args[0])) == "select" {
+		if strings.ToLower(string(cmdLine[0])) == "select" {
 			// execSelect success, here must be no error
-			dbIndex, err := strconv.Atoi(string(r.Args[1]))
+			dbIndex, err := strconv.Atoi(string(cmdLine[1]))
 			if err == nil {
 				persister.currentDB = dbIndex
 			}
 		}
 	}
+	return nil
+}
+
+func (persister *Persister) fsync() string {
+	fsync, _ := persister.aofFsync.Load().(string)
+	return fsync
+}
+
+// SetFsync changes the strategy of fsync, it takes effect on the next command
+func (persister *Persister) SetFsync(fsync string) {
+	persister.aofFsync.Store(strings.ToLower(fsync))
 }
 
 // Fsync flushes aof file to disk
 func (persister *Persister) Fsync() {
 	persister.pausingAof.Lock()
+	start := time.Now()
 	if err := persister.aofFile.Sync(); err != nil {
 		logger.Errorf("fsync failed: %v", err)
 	}
+	persister.recordLatency("aof-fsync", start)
 	persister.pausingAof.Unlock()
 }
 
+// SetLatencyHook sets the callback receiving durations of fsync and pausing aof for rewrite or rdb generation
+func (persister *Persister) SetLatencyHook(hook LatencyHook) {
+	persister.latencyHook.Store(hook)
+}
+
+func (persister *Persister) recordLatency(event string, start time.Time) {
+	if hook, _ := persister.latencyHook.Load().(LatencyHook); hook != nil {
+		hook(event, time.Since(start))
+	}
+}
+
 // Close gracefully stops aof persistence procedure
 func (persister *Persister) Close() {
 	if persister == nil {
@@ -265,6 +377,9 @@ func (persister *Persister) Close() {
 	if persister.aofFile != nil {
 		close(persister.aofChan)
 		<-persister.aofFinished // wait for aof finished
+		if err := persister.aofFile.Sync(); err != nil {
+			logger.Warn(err)
+		}
 		err := persister.aofFile.Close()
 		if err != nil {
 			logger.Warn(err)
@@ -280,7 +395,9 @@ func (persister *Persister) fsyncEverySecond() {
 		for {
 			select {
 			case <-ticker.C:
-				persister.Fsync()
+				if persister.fsync() == FsyncEverySec {
+					persister.Fsync()
+				}
 			case <-persister.ctx.Done():
 				return
 			}
@@ -293,7 +410,20 @@ func (persister *Persister) generateAof(ctx *RewriteCtx) error {
 	tmpFile := ctx.tmpFile
 	// load aof tmpFile
 	tmpAof := persister.newRewriteHandler()
-	tmpAof.LoadAof(int(ctx.fileSize))
+	err := tmpAof.LoadAof(int(ctx.fileSize))
+	if err != nil {
+		return err
+	}
+	// functions are not bound to any db
+	if dumper, ok := tmpAof.db.(database.FunctionDumper); ok {
+		for _, code := range dumper.DumpFunctions() {
+			data := protocol.MakeMultiBulkReply(utils.ToCmdLine("FUNCTION", "LOAD", "REPLACE", code)).ToBytes()
+			_, err := tmpFile.Write(data)
+			if err != nil {
+				return err
+			}
+		}
+	}
 	for i := 0; i < config.Properties.Databases; i++ {
 		// select db
 		data := protocol.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(i))).ToBytes()
@@ -307,6 +437,9 @@ func (persister *Persister) generateAof(ctx *RewriteCtx) error {
 			if cmd != nil {
 				_, _ = tmpFile.Write(cmd.ToBytes())
 			}
+			for _, cmd := range MakeFieldExpireCmds(key, entity) {
+				_, _ = tmpFile.Write(cmd.ToBytes())
+			}
 			if expiration != nil {
 				cmd := MakeExpireCmd(key, *expiration)
 				if cmd != nil {
@@ -0,0 +1,262 @@
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	rdb "github.com/hdt3213/rdb/core"
+	rdbparser "github.com/hdt3213/rdb/parser"
+)
+
+// CorruptError describes the first command of aof file which cannot be read
+type CorruptError struct {
+	// Offset is the position in aof file where the broken command begins
+	Offset int64
+	// Index is the sequence number of the broken command, starting from 1
+	Index int
+	// Truncated is true if the file ends in the middle of the command, otherwise the command is malformed
+	Truncated bool
+	Reason    string
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("bad file format reading the append only file at offset %d (command #%d): %s",
+		e.Offset, e.Index, e.Reason)
+}
+
+// ErrTimestampReached is returned by CmdScanner.Next when it meets a #TS annotation later than the one given to StopAt
+var ErrTimestampReached = errors.New("aof timestamp reached")
+
+var timestampPrefix = []byte("#TS:")
+
+// MakeTimestampAnnotation returns a #TS annotation of the given unix time, loader ignores it unless StopAt is set
+func MakeTimestampAnnotation(timestamp int64) []byte {
+	return []byte("#TS:" + strconv.FormatInt(timestamp, 10) + "\r\n")
+}
+
+// CmdScanner reads command lines from aof file and keeps track of their offsets
+type CmdScanner struct {
+	reader *bufio.Reader
+	// pos is the offset of next unread byte in aof file
+	pos int64
+	// count is the number of commands read
+	count int
+	// stopAt is the unix time to stop at, 0 means reading all commands
+	stopAt int64
+}
+
+// NewCmdScanner creates a CmdScanner, base is the offset in aof file where reader begins
+func NewCmdScanner(reader io.Reader, base int64) *CmdScanner {
+	return &CmdScanner{
+		reader: bufio.NewReader(reader),
+		pos:    base,
+	}
+}
+
+// Offset returns the offset in aof file right after the last command read
+func (scanner *CmdScanner) Offset() int64 {
+	return scanner.pos
+}
+
+// Count returns the number of commands read
+func (scanner *CmdScanner) Count() int {
+	return scanner.count
+}
+
+// StopAt makes Next return ErrTimestampReached at the first #TS annotation later than timestamp,
+// the offset is left before the annotation
+func (scanner *CmdScanner) StopAt(timestamp int64) {
+	scanner.stopAt = timestamp
+}
+
+// Next returns the next command line, io.EOF if all commands have been read, or a *CorruptError
+func (scanner *CmdScanner) Next() (CmdLine, error) {
+	start := scanner.pos
+	var header []byte
+	for {
+		line, err := scanner.reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil, io.EOF
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if err == io.EOF {
+			return nil, scanner.corrupt(start, true, "unexpected end of file")
+		}
+		scanner.pos += int64(len(line))
+		// skip empty lines, e.g. LF following rdb preamble
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			start = scanner.pos
+			continue
+		}
+		if line[0] == '#' {
+			if !bytes.HasSuffix(line, []byte("\r\n")) {
+				return nil, scanner.corrupt(start, false, "annotation is not terminated by CRLF")
+			}
+			if bytes.HasPrefix(line, timestampPrefix) {
+				timestamp, err := strconv.ParseInt(string(line[len(timestampPrefix):len(line)-2]), 10, 64)
+				if err != nil {
+					return nil, scanner.corrupt(start, false, "illegal timestamp annotation")
+				}
+				if scanner.stopAt > 0 && timestamp > scanner.stopAt {
+					scanner.pos = start
+					return nil, ErrTimestampReached
+				}
+			}
+			// unknown annotations are ignored
+			start = scanner.pos
+			continue
+		}
+		header = line
+		break
+	}
+	if header[0] != '*' || !bytes.HasSuffix(header, []byte("\r\n")) {
+		return nil, scanner.corrupt(start, false, "expect multi bulk header")
+	}
+	argc, err := strconv.ParseInt(string(header[1:len(header)-2]), 10, 64)
+	if err != nil || argc < 1 {
+		return nil, scanner.corrupt(start, false, "illegal multi bulk length")
+	}
+	cmdLine := make(CmdLine, 0, argc)
+	for i := int64(0); i < argc; i++ {
+		line, err := scanner.reader.ReadBytes('\n')
+		scanner.pos += int64(len(line))
+		if err == io.EOF {
+			return nil, scanner.corrupt(start, true, "unexpected end of file")
+		} else if err != nil {
+			return nil, err
+		}
+		if line[0] != '$' || !bytes.HasSuffix(line, []byte("\r\n")) {
+			return nil, scanner.corrupt(start, false, "expect bulk string header")
+		}
+		size, err := strconv.ParseInt(string(line[1:len(line)-2]), 10, 64)
+		if err != nil || size < 0 {
+			return nil, scanner.corrupt(start, false, "illegal bulk string length")
+		}
+		body := make([]byte, size+2)
+		n, err := io.ReadFull(scanner.reader, body)
+		scanner.pos += int64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, scanner.corrupt(start, true, "unexpected end of file")
+		} else if err != nil {
+			return nil, err
+		}
+		if body[size] != '\r' || body[size+1] != '\n' {
+			return nil, scanner.corrupt(start, false, "bulk string is not terminated by CRLF")
+		}
+		cmdLine = append(cmdLine, body[:size])
+	}
+	scanner.count++
+	return cmdLine, nil
+}
+
+func (scanner *CmdScanner) corrupt(offset int64, truncated bool, reason string) *CorruptError {
+	scanner.pos = offset
+	return &CorruptError{
+		Offset:    offset,
+		Index:     scanner.count + 1,
+		Truncated: truncated,
+		Reason:    reason,
+	}
+}
+
+var rdbMagic = []byte("REDIS")
+
+// loadPreamble loads rdb preamble at the head of aof file if exists,
+// returns the size of preamble and leaves file offset at the first command after it
+func loadPreamble(file *os.File, load func(dec *rdb.Decoder) error) (int64, error) {
+	magic := make([]byte, len(rdbMagic))
+	n, err := io.ReadFull(file, magic)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return 0, err
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(magic[:n], rdbMagic) {
+		// no rdb preamble
+		return 0, nil
+	}
+	decoder := rdb.NewDecoder(file)
+	err = load(decoder)
+	if err != nil {
+		return 0, fmt.Errorf("bad rdb preamble of append only file: %v", err)
+	}
+	size := int64(decoder.GetReadCount())
+	if _, err = file.Seek(size, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// CheckResult describes the state of an aof file, see CheckAof
+type CheckResult struct {
+	// Size is the size of aof file
+	Size int64
+	// PreambleSize is the size of rdb preamble, 0 if there is no preamble
+	PreambleSize int64
+	// Commands is the number of valid commands following preamble
+	Commands int
+	// ValidSize is the size of the valid prefix of aof file
+	ValidSize int64
+	// Err is the first corruption found, nil if the file is intact
+	Err *CorruptError
+	// TimestampReached is true if checking stopped at a #TS annotation later than the given timestamp,
+	// ValidSize is the offset of that annotation
+	TimestampReached bool
+}
+
+// CheckAof validates the given aof file with the same parser used by loading.
+// If untilTimestamp is positive, checking stops at the first #TS annotation later than it.
+func CheckAof(filename string, untilTimestamp int64) (*CheckResult, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	preambleSize, err := loadPreamble(file, func(dec *rdb.Decoder) error {
+		return dec.Parse(func(o rdbparser.RedisObject) bool {
+			return true
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := &CheckResult{
+		Size:         info.Size(),
+		PreambleSize: preambleSize,
+	}
+	scanner := NewCmdScanner(file, preambleSize)
+	scanner.StopAt(untilTimestamp)
+	for {
+		_, err := scanner.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == ErrTimestampReached {
+			result.TimestampReached = true
+			break
+		}
+		if corrupt, ok := err.(*CorruptError); ok {
+			result.Err = corrupt
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	result.Commands = scanner.Count()
+	result.ValidSize = scanner.Offset()
+	return result, nil
+}
@@ -0,0 +1,172 @@
+package aof
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/hdt3213/godis/datastruct/dict"
+	List "github.com/hdt3213/godis/datastruct/list"
+	"github.com/hdt3213/godis/datastruct/set"
+	SortedSet "github.com/hdt3213/godis/datastruct/sortedset"
+	"github.com/hdt3213/godis/interface/database"
+	"github.com/hdt3213/rdb/crc64jones"
+	rdb "github.com/hdt3213/rdb/encoder"
+	"github.com/hdt3213/rdb/parser"
+)
+
+// A dump payload has the same format as redis:
+// object type (1 byte) + object value in rdb format + rdb version (2 bytes, little endian)
+// + crc64 jones checksum of all previous bytes (8 bytes, little endian)
+
+// DumpRDBVersion is the rdb version written into footer of dump payloads
+const DumpRDBVersion = 9
+
+// maxRestoreRDBVersion is the latest rdb version which could be restored
+const maxRestoreRDBVersion = 12
+
+const dumpFooterSize = 10
+
+// ErrBadDumpPayload means the footer of payload is broken
+var ErrBadDumpPayload = errors.New("DUMP payload version or checksum are wrong")
+
+// ErrBadDumpData means the payload cannot be decoded
+var ErrBadDumpData = errors.New("Bad data format")
+
+// rdbOpCodeMin is the smallest rdb op code, object type must be lower than it
+const rdbOpCodeMin = 0xf5
+
+// DumpEntity serializes entity into a payload which is interchangeable with redis DUMP
+func DumpEntity(entity *database.DataEntity) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	encoder := rdb.NewEncoder(buf).EnableCompress()
+	// encoder only writes objects after headers, so write them and then cut them off
+	err := encoder.WriteHeader()
+	if err != nil {
+		return nil, err
+	}
+	err = encoder.WriteDBHeader(0, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	offset := buf.Len()
+	err = writeEntity(encoder, "", entity)
+	if err != nil {
+		return nil, err
+	}
+	obj := buf.Bytes()[offset:]
+	payload := make([]byte, 0, len(obj)-1+dumpFooterSize)
+	// skip the empty key between object type and value
+	payload = append(payload, obj[0])
+	payload = append(payload, obj[2:]...)
+	payload = append(payload, 0, 0)
+	binary.LittleEndian.PutUint16(payload[len(payload)-2:], DumpRDBVersion)
+	return append(payload, dumpChecksum(payload)...), nil
+}
+
+// SerializedLength returns the length of value of entity in rdb format, excluding object type and dump footer
+func SerializedLength(entity *database.DataEntity) (int, error) {
+	payload, err := DumpEntity(entity)
+	if err != nil {
+		return 0, err
+	}
+	return len(payload) - 1 - dumpFooterSize, nil
+}
+
+func dumpChecksum(data []byte) []byte {
+	crc := crc64jones.New()
+	_, _ = crc.Write(data)
+	sum := make([]byte, 8)
+	binary.LittleEndian.PutUint64(sum, crc.Sum64())
+	return sum
+}
+
+// RestoreEntity deserializes payload generated by DUMP of godis or redis
+func RestoreEntity(payload []byte) (*database.DataEntity, error) {
+	if len(payload) < dumpFooterSize+1 {
+		return nil, ErrBadDumpPayload
+	}
+	footer := len(payload) - dumpFooterSize
+	version := binary.LittleEndian.Uint16(payload[footer:])
+	if version > maxRestoreRDBVersion {
+		return nil, ErrBadDumpPayload
+	}
+	if !bytes.Equal(dumpChecksum(payload[:footer+2]), payload[footer+2:]) {
+		return nil, ErrBadDumpPayload
+	}
+	if payload[0] >= rdbOpCodeMin {
+		return nil, ErrBadDumpData
+	}
+	// wrap the object as a rdb file with an empty key so that the rdb decoder could parse it
+	buf := make([]byte, 0, len(rdbFileHeader)+footer+2)
+	buf = append(buf, rdbFileHeader...)
+	buf = append(buf, payload[0], 0)
+	buf = append(buf, payload[1:footer]...)
+	buf = append(buf, rdbOpCodeEOF)
+	var entity *database.DataEntity
+	decoder := parser.NewDecoder(bytes.NewReader(buf))
+	err := decoder.Parse(func(o parser.RedisObject) bool {
+		entity = RDBObjectToEntity(o)
+		return false
+	})
+	if err != nil || entity == nil {
+		return nil, ErrBadDumpData
+	}
+	// trailing bytes means the object is broken
+	if decoder.GetReadCount() != len(buf)-1 {
+		return nil, ErrBadDumpData
+	}
+	return entity, nil
+}
+
+var rdbFileHeader = []byte("REDIS0011")
+
+const rdbOpCodeEOF = 0xff
+
+// RDBObjectToEntity converts object parsed from rdb to DataEntity, returns nil for unsupported types
+func RDBObjectToEntity(o parser.RedisObject) *database.DataEntity {
+	switch o.GetType() {
+	case parser.StringType:
+		str := o.(*parser.StringObject)
+		return &database.DataEntity{
+			Data: str.Value,
+		}
+	case parser.ListType:
+		listObj := o.(*parser.ListObject)
+		list := List.NewQuickList()
+		for _, v := range listObj.Values {
+			list.Add(v)
+		}
+		return &database.DataEntity{
+			Data: list,
+		}
+	case parser.HashType:
+		hashObj := o.(*parser.HashObject)
+		hash := dict.MakeSimple()
+		for k, v := range hashObj.Hash {
+			hash.Put(k, v)
+		}
+		return &database.DataEntity{
+			Data: hash,
+		}
+	case parser.SetType:
+		setObj := o.(*parser.SetObject)
+		s := set.Make()
+		for _, mem := range setObj.Members {
+			s.Add(string(mem))
+		}
+		return &database.DataEntity{
+			Data: s,
+		}
+	case parser.ZSetType:
+		zsetObj := o.(*parser.ZSetObject)
+		zSet := SortedSet.Make()
+		for _, e := range zsetObj.Entries {
+			zSet.Add(e.Member, e.Score)
+		}
+		return &database.DataEntity{
+			Data: zSet,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package aof
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/datastruct/dict"
+	List "github.com/hdt3213/godis/datastruct/list"
+	"github.com/hdt3213/godis/datastruct/set"
+	SortedSet "github.com/hdt3213/godis/datastruct/sortedset"
+	"github.com/hdt3213/godis/interface/database"
+	"github.com/hdt3213/godis/redis/connection"
+	rdb "github.com/hdt3213/rdb/core"
+	rdbparser "github.com/hdt3213/rdb/parser"
+)
+
+// KeyInfo describes a key stored in rdb or aof file, see InspectRDB and InspectAof
+type KeyInfo struct {
+	DB   int
+	Key  string
+	Type string
+	// Size is the length of value in rdb format
+	Size int
+	// Elements is the number of elements of list, hash, set and zset, 1 for string
+	Elements int
+	// ExpireAt is nil if the key never expires
+	ExpireAt *time.Time
+}
+
+// InspectRDB calls cb for every key in rdb file until cb returns false, keys are not loaded into memory
+func InspectRDB(filename string, cb func(info *KeyInfo) bool) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	return rdb.NewDecoder(file).Parse(func(o rdbparser.RedisObject) bool {
+		if o.GetType() == rdbparser.AuxType || o.GetType() == rdbparser.DBSizeType {
+			return true
+		}
+		elements := o.GetElemCount()
+		if o.GetType() == rdbparser.StringType {
+			elements = 1
+		}
+		return cb(&KeyInfo{
+			DB:       o.GetDBIndex(),
+			Key:      o.GetKey(),
+			Type:     o.GetType(),
+			Size:     o.GetSize(),
+			Elements: elements,
+			ExpireAt: o.GetExpiration(),
+		})
+	})
+}
+
+// InspectAof replays aof file into db, which should be empty, and calls cb for every key until cb returns false.
+// Unlike LoadAof, the file is never modified. If the file is corrupted, keys loaded before the corruption are
+// inspected and the *CorruptError is returned
+func InspectAof(filename string, db database.DBEngine, cb func(info *KeyInfo) bool) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	preambleSize, err := loadPreamble(file, db.LoadRDB)
+	if err != nil {
+		return err
+	}
+	scanner := NewCmdScanner(file, preambleSize)
+	fakeConn := connection.NewFakeConn() // only used for save dbIndex
+	var corrupt error
+	for {
+		cmdLine, err := scanner.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*CorruptError); !ok {
+				return err
+			}
+			corrupt = err
+			break
+		}
+		db.Exec(fakeConn, cmdLine)
+	}
+
+	for i := 0; i < config.Properties.Databases; i++ {
+		stopped := false
+		db.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+			info, err := entityInfo(entity)
+			if err != nil {
+				return true // unknown types are skipped
+			}
+			info.DB = i
+			info.Key = key
+			info.ExpireAt = expiration
+			stopped = !cb(info)
+			return !stopped
+		})
+		if stopped {
+			break
+		}
+	}
+	return corrupt
+}
+
+// entityInfo returns type, size and elements of entity
+func entityInfo(entity *database.DataEntity) (*KeyInfo, error) {
+	size, err := SerializedLength(entity)
+	if err != nil {
+		return nil, err
+	}
+	info := &KeyInfo{
+		Size: size,
+	}
+	switch obj := entity.Data.(type) {
+	case []byte:
+		info.Type = rdbparser.StringType
+		info.Elements = 1
+	case List.List:
+		info.Type = rdbparser.ListType
+		info.Elements = obj.Len()
+	case dict.Dict:
+		info.Type = rdbparser.HashType
+		info.Elements = obj.Len()
+	case *set.Set:
+		info.Type = rdbparser.SetType
+		info.Elements = obj.Len()
+	case *SortedSet.SortedSet:
+		info.Type = rdbparser.ZSetType
+		info.Elements = int(obj.Len())
+	}
+	return info, nil
+}
@@ -116,3 +116,30 @@ func MakeExpireCmd(key string, expireAt time.Time) *protocol.MultiBulkReply {
 	args[2] = []byte(strconv.FormatInt(expireAt.UnixNano()/1e6, 10))
 	return protocol.MakeMultiBulkReply(args)
 }
+
+var hPExpireAtBytes = []byte("HPEXPIREAT")
+
+// MakeFieldExpireCmd generates command line to set expiration for the given hash fields
+func MakeFieldExpireCmd(key string, expireAt time.Time, fields ...string) *protocol.MultiBulkReply {
+	args := make([][]byte, 0, 5+len(fields))
+	args = append(args, hPExpireAtBytes, []byte(key), []byte(strconv.FormatInt(expireAt.UnixNano()/1e6, 10)),
+		[]byte("FIELDS"), []byte(strconv.Itoa(len(fields))))
+	for _, field := range fields {
+		args = append(args, []byte(field))
+	}
+	return protocol.MakeMultiBulkReply(args)
+}
+
+// MakeFieldExpireCmds generates command lines to restore expiration of hash fields, returns nil for other types
+func MakeFieldExpireCmds(key string, entity *database.DataEntity) []*protocol.MultiBulkReply {
+	hash, ok := entity.Data.(*dict.TTLDict)
+	if !ok {
+		return nil
+	}
+	var cmds []*protocol.MultiBulkReply
+	hash.ForEachExpire(func(field string, expireAt time.Time) bool {
+		cmds = append(cmds, MakeFieldExpireCmd(key, expireAt, field))
+		return true
+	})
+	return cmds
+}
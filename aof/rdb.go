@@ -1,6 +1,9 @@
 package aof
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"time"
@@ -11,20 +14,21 @@ import (
 	"github.com/hdt3213/godis/datastruct/set"
 	SortedSet "github.com/hdt3213/godis/datastruct/sortedset"
 	"github.com/hdt3213/godis/interface/database"
+	"github.com/hdt3213/godis/lib/clock"
 	"github.com/hdt3213/godis/lib/logger"
+	"github.com/hdt3213/godis/redis/parser"
+	"github.com/hdt3213/godis/redis/protocol"
 	rdb "github.com/hdt3213/rdb/encoder"
 	"github.com/hdt3213/rdb/model"
 )
 
-// todo: forbid concurrent rewrite
-
 // GenerateRDB generates rdb file from aof file
 func (persister *Persister) GenerateRDB(rdbFilename string) error {
 	ctx, err := persister.startGenerateRDB(nil, nil)
 	if err != nil {
 		return err
 	}
-	err = persister.generateRDB(ctx)
+	err = persister.generateRDB(ctx, false)
 	if err != nil {
 		return err
 	}
@@ -48,7 +52,7 @@ func (persister *Persister) GenerateRDBForReplication(rdbFilename string, listen
 		return err
 	}
 
-	err = persister.generateRDB(ctx)
+	err = persister.generateRDB(ctx, false)
 	if err != nil {
 		return err
 	}
@@ -66,6 +70,7 @@ func (persister *Persister) GenerateRDBForReplication(rdbFilename string, listen
 func (persister *Persister) startGenerateRDB(newListener Listener, hook func()) (*RewriteCtx, error) {
 	persister.pausingAof.Lock() // pausing aof
 	defer persister.pausingAof.Unlock()
+	defer persister.recordLatency("rdb-snapshot", time.Now())
 
 	err := persister.aofFile.Sync()
 	if err != nil {
@@ -94,13 +99,46 @@ func (persister *Persister) startGenerateRDB(newListener Listener, hook func())
 	}, nil
 }
 
-// generateRDB generates rdb file from aof file
-func (persister *Persister) generateRDB(ctx *RewriteCtx) error {
+// generateRDB generates rdb file from aof file, aofPreamble marks the rdb as the head of a rewritten aof file
+func (persister *Persister) generateRDB(ctx *RewriteCtx, aofPreamble bool) error {
 	// load aof tmpFile
 	tmpHandler := persister.newRewriteHandler()
-	tmpHandler.LoadAof(int(ctx.fileSize))
+	err := tmpHandler.LoadAof(int(ctx.fileSize))
+	if err != nil {
+		return err
+	}
+	return writeRDB(ctx.tmpFile, tmpHandler.db, aofPreamble)
+}
+
+// SaveRDB dumps all databases of the given engine into rdb file, used when aof is disabled.
+// Keys are read shard by shard, so each key is consistent but the dump is not a point-in-time snapshot.
+func SaveRDB(rdbFilename string, db database.DBEngine) error {
+	file, err := os.CreateTemp(config.GetTmpDir(), "*.rdb")
+	if err != nil {
+		return err
+	}
+	err = writeRDB(file, db, false)
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return err
+	}
+	err = file.Sync()
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), rdbFilename)
+}
 
-	encoder := rdb.NewEncoder(ctx.tmpFile).EnableCompress()
+// writeRDB encodes all databases of the given engine in rdb format
+func writeRDB(writer io.Writer, db database.DBEngine, aofPreamble bool) error {
+	encoder := rdb.NewEncoder(writer).EnableCompress()
 	err := encoder.WriteHeader()
 	if err != nil {
 		return err
@@ -113,7 +151,7 @@ func (persister *Persister) generateRDB(ctx *RewriteCtx) error {
 	}
 
 	// change aof preamble
-	if config.Properties.AofUseRdbPreamble {
+	if aofPreamble {
 		auxMap["aof-preamble"] = "1"
 	}
 
@@ -124,9 +162,28 @@ func (persister *Persister) generateRDB(ctx *RewriteCtx) error {
 		}
 	}
 
+	if dumper, ok := db.(database.FunctionDumper); ok {
+		for _, code := range dumper.DumpFunctions() {
+			err = encoder.WriteAux(FunctionLibraryAux, code)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	now := clock.Now()
+	ttlHashes := snapshotTTLHashes(db, now)
+	for i, hashes := range ttlHashes {
+		for _, hash := range hashes {
+			err = encoder.WriteAux(HashFieldTTLAux, makeHashFieldTTLAux(i, hash))
+			if err != nil {
+				return err
+			}
+		}
+	}
 	for i := 0; i < config.Properties.Databases; i++ {
-		keyCount, ttlCount := tmpHandler.db.GetDBSize(i)
-		if keyCount == 0 {
+		keyCount, ttlCount := db.GetDBSize(i)
+		if keyCount == 0 && len(ttlHashes[i]) == 0 {
 			continue
 		}
 		err = encoder.WriteDBHeader(uint(i), uint64(keyCount), uint64(ttlCount))
@@ -134,49 +191,27 @@ func (persister *Persister) generateRDB(ctx *RewriteCtx) error {
 			return err
 		}
 		// dump db
+		written := make(map[string]struct{}, len(ttlHashes[i]))
+		for _, hash := range ttlHashes[i] {
+			err = writeEntity(encoder, hash.key, &database.DataEntity{Data: hash.fields}, hash.opts...)
+			if err != nil {
+				return err
+			}
+			written[hash.key] = struct{}{}
+		}
 		var err2 error
-		tmpHandler.db.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+		db.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+			if _, ok := written[key]; ok {
+				return true
+			}
 			var opts []interface{}
 			if expiration != nil {
-				opts = append(opts, rdb.WithTTL(uint64(expiration.UnixNano()/1e6)))
-			}
-			switch obj := entity.Data.(type) {
-			case []byte:
-				err = encoder.WriteStringObject(key, obj, opts...)
-			case List.List:
-				vals := make([][]byte, 0, obj.Len())
-				obj.ForEach(func(i int, v interface{}) bool {
-					bytes, _ := v.([]byte)
-					vals = append(vals, bytes)
+				if expiration.Before(now) {
 					return true
-				})
-				err = encoder.WriteListObject(key, vals, opts...)
-			case *set.Set:
-				vals := make([][]byte, 0, obj.Len())
-				obj.ForEach(func(m string) bool {
-					vals = append(vals, []byte(m))
-					return true
-				})
-				err = encoder.WriteSetObject(key, vals, opts...)
-			case dict.Dict:
-				hash := make(map[string][]byte)
-				obj.ForEach(func(key string, val interface{}) bool {
-					bytes, _ := val.([]byte)
-					hash[key] = bytes
-					return true
-				})
-				err = encoder.WriteHashMapObject(key, hash, opts...)
-			case *SortedSet.SortedSet:
-				var entries []*model.ZSetEntry
-				obj.ForEachByRank(int64(0), obj.Len(), true, func(element *SortedSet.Element) bool {
-					entries = append(entries, &model.ZSetEntry{
-						Member: element.Member,
-						Score:  element.Score,
-					})
-					return true
-				})
-				err = encoder.WriteZSetObject(key, entries, opts...)
+				}
+				opts = append(opts, rdb.WithTTL(uint64(expiration.UnixNano()/1e6)))
 			}
+			err = writeEntity(encoder, key, entity, opts...)
 			if err != nil {
 				err2 = err
 				return false
@@ -187,9 +222,133 @@ func (persister *Persister) generateRDB(ctx *RewriteCtx) error {
 			return err2
 		}
 	}
-	err = encoder.WriteEnd()
+	return encoder.WriteEnd()
+}
+
+// HashFieldTTLAux is name of rdb aux fields carrying ttl of hash fields, since the rdb format in use has no type for it.
+// Each aux field holds db index, key and pairs of field and expiration time in unix milliseconds as a RESP array,
+// other rdb readers ignore unknown aux fields.
+const HashFieldTTLAux = "godis-hash-field-ttl"
+
+// FunctionLibraryAux is name of rdb aux fields carrying code of libraries loaded by FUNCTION LOAD,
+// the rdb format in use has no type for functions either
+const FunctionLibraryAux = "godis-function-library"
+
+// ttlHash is a copy of hash with field ttl, taken before writing any db since aux fields must precede them
+type ttlHash struct {
+	key     string
+	fields  dict.Dict
+	expires map[string]time.Time
+	opts    []interface{}
+}
+
+// snapshotTTLHashes copies live fields of hashes with field ttl in each db
+func snapshotTTLHashes(db database.DBEngine, now time.Time) [][]*ttlHash {
+	result := make([][]*ttlHash, config.Properties.Databases)
+	for i := range result {
+		db.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+			hash, ok := entity.Data.(*dict.TTLDict)
+			if !ok || (expiration != nil && expiration.Before(now)) {
+				return true
+			}
+			snapshot := &ttlHash{
+				key:     key,
+				fields:  dict.MakeSimple(),
+				expires: make(map[string]time.Time),
+			}
+			hash.Live(now).ForEach(func(field string, val interface{}) bool {
+				snapshot.fields.Put(field, val)
+				if expireAt, ok := hash.ExpireTime(field); ok {
+					snapshot.expires[field] = expireAt
+				}
+				return true
+			})
+			if snapshot.fields.Len() == 0 {
+				return true
+			}
+			if expiration != nil {
+				snapshot.opts = append(snapshot.opts, rdb.WithTTL(uint64(expiration.UnixNano()/1e6)))
+			}
+			result[i] = append(result[i], snapshot)
+			return true
+		})
+	}
+	return result
+}
+
+func makeHashFieldTTLAux(dbIndex int, hash *ttlHash) string {
+	args := make([][]byte, 0, 2+2*len(hash.expires))
+	args = append(args, []byte(strconv.Itoa(dbIndex)), []byte(hash.key))
+	for field, expireAt := range hash.expires {
+		args = append(args, []byte(field), []byte(strconv.FormatInt(expireAt.UnixNano()/1e6, 10)))
+	}
+	return string(protocol.MakeMultiBulkReply(args).ToBytes())
+}
+
+// ParseHashFieldTTLAux parses value of aux field named HashFieldTTLAux
+func ParseHashFieldTTLAux(value string) (dbIndex int, key string, expires map[string]time.Time, err error) {
+	reply, err := parser.ParseOne([]byte(value))
 	if err != nil {
-		return err
+		return 0, "", nil, err
 	}
-	return nil
+	multiBulk, ok := reply.(*protocol.MultiBulkReply)
+	if !ok || len(multiBulk.Args) < 2 || len(multiBulk.Args)%2 != 0 {
+		return 0, "", nil, errors.New("illegal hash field ttl aux")
+	}
+	args := multiBulk.Args
+	dbIndex, err = strconv.Atoi(string(args[0]))
+	if err != nil {
+		return 0, "", nil, errors.New("illegal db index in hash field ttl aux")
+	}
+	expires = make(map[string]time.Time, len(args)/2-1)
+	for i := 2; i < len(args); i += 2 {
+		ms, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil {
+			return 0, "", nil, errors.New("illegal expiration time in hash field ttl aux")
+		}
+		expires[string(args[i])] = time.Unix(0, ms*int64(time.Millisecond))
+	}
+	return dbIndex, string(args[1]), expires, nil
+}
+
+// writeEntity writes entity as a rdb object
+func writeEntity(encoder *rdb.Encoder, key string, entity *database.DataEntity, opts ...interface{}) error {
+	switch obj := entity.Data.(type) {
+	case []byte:
+		return encoder.WriteStringObject(key, obj, opts...)
+	case List.List:
+		vals := make([][]byte, 0, obj.Len())
+		obj.ForEach(func(i int, v interface{}) bool {
+			bytes, _ := v.([]byte)
+			vals = append(vals, bytes)
+			return true
+		})
+		return encoder.WriteListObject(key, vals, opts...)
+	case *set.Set:
+		vals := make([][]byte, 0, obj.Len())
+		obj.ForEach(func(m string) bool {
+			vals = append(vals, []byte(m))
+			return true
+		})
+		return encoder.WriteSetObject(key, vals, opts...)
+	case dict.Dict:
+		hash := make(map[string][]byte)
+		obj.ForEach(func(key string, val interface{}) bool {
+			bytes, _ := val.([]byte)
+			hash[key] = bytes
+			return true
+		})
+		return encoder.WriteHashMapObject(key, hash, opts...)
+	case *SortedSet.SortedSet:
+		var entries []*model.ZSetEntry
+		obj.ForEachByRank(int64(0), obj.Len(), true, func(element *SortedSet.Element) bool {
+			entries = append(entries, &model.ZSetEntry{
+				Member: element.Member,
+				Score:  element.Score,
+			})
+			return true
+		})
+		return encoder.WriteZSetObject(key, entries, opts...)
+	}
+	return fmt.Errorf("unknown data type %T", entity.Data)
 }
@@ -1,9 +1,12 @@
 package aof
 
 import (
+	"errors"
 	"io"
 	"os"
 	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/hdt3213/godis/config"
 	"github.com/hdt3213/godis/lib/logger"
@@ -25,19 +28,51 @@ type RewriteCtx struct {
 	dbIdx    int // selected db index when startRewrite
 }
 
+// ErrRewriteInProgress is returned if another rewriting has not finished
+var ErrRewriteInProgress = errors.New("Background append only file rewriting already in progress")
+
 // Rewrite carries out AOF rewrite
 func (persister *Persister) Rewrite() error {
+	if !atomic.CompareAndSwapInt32(&persister.rewriting, 0, 1) {
+		return ErrRewriteInProgress
+	}
+	defer atomic.StoreInt32(&persister.rewriting, 0)
+	return persister.rewrite()
+}
+
+// BackgroundRewrite starts AOF rewrite in a new goroutine, the result is reported by Stats
+func (persister *Persister) BackgroundRewrite() error {
+	if !atomic.CompareAndSwapInt32(&persister.rewriting, 0, 1) {
+		return ErrRewriteInProgress
+	}
+	go func() {
+		defer atomic.StoreInt32(&persister.rewriting, 0)
+		if err := persister.rewrite(); err != nil {
+			logger.Error("background aof rewrite failed: " + err.Error())
+		}
+	}()
+	return nil
+}
+
+func (persister *Persister) rewrite() (err error) {
+	defer func() {
+		if err != nil {
+			atomic.StoreInt32(&persister.rewriteFailed, 1)
+		} else {
+			atomic.StoreInt32(&persister.rewriteFailed, 0)
+		}
+	}()
 	ctx, err := persister.StartRewrite()
 	if err != nil {
 		return err
 	}
 	err = persister.DoRewrite(ctx)
 	if err != nil {
+		_ = ctx.tmpFile.Close()
+		_ = os.Remove(ctx.tmpFile.Name())
 		return err
 	}
-
-	persister.FinishRewrite(ctx)
-	return nil
+	return persister.FinishRewrite(ctx)
 }
 
 // DoRewrite actually rewrite aof file
@@ -49,7 +84,7 @@ func (persister *Persister) DoRewrite(ctx *RewriteCtx) (err error) {
 		err = persister.generateAof(ctx)
 	} else {
 		logger.Info("generate rdb preamble")
-		err = persister.generateRDB(ctx)
+		err = persister.generateRDB(ctx, true)
 	}
 	return err
 }
@@ -59,6 +94,7 @@ func (persister *Persister) StartRewrite() (*RewriteCtx, error) {
 	// pausing aof
 	persister.pausingAof.Lock()
 	defer persister.pausingAof.Unlock()
+	defer persister.recordLatency("aof-rewrite-start", time.Now())
 
 	err := persister.aofFile.Sync()
 	if err != nil {
@@ -83,19 +119,21 @@ func (persister *Persister) StartRewrite() (*RewriteCtx, error) {
 	}, nil
 }
 
-// FinishRewrite finish rewrite procedure
-func (persister *Persister) FinishRewrite(ctx *RewriteCtx) {
+// FinishRewrite finish rewrite procedure, commands executed during rewriting are appended to the new file
+// before it replaces the online aof file
+func (persister *Persister) FinishRewrite(ctx *RewriteCtx) error {
 	persister.pausingAof.Lock() // pausing aof
 	defer persister.pausingAof.Unlock()
+	defer persister.recordLatency("aof-rewrite-done", time.Now())
 	tmpFile := ctx.tmpFile
 
 	// copy commands executed during rewriting to tmpFile
-	errOccurs := func() bool {
+	copyErr := func() error {
 		/* read write commands executed during rewriting */
 		src, err := os.Open(persister.aofFilename)
 		if err != nil {
 			logger.Error("open aofFilename failed: " + err.Error())
-			return true
+			return err
 		}
 		defer func() {
 			_ = src.Close()
@@ -105,25 +143,26 @@ func (persister *Persister) FinishRewrite(ctx *RewriteCtx) {
 		_, err = src.Seek(ctx.fileSize, 0)
 		if err != nil {
 			logger.Error("seek failed: " + err.Error())
-			return true
+			return err
 		}
 		// sync tmpFile's db index with online aofFile
 		data := protocol.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(ctx.dbIdx))).ToBytes()
 		_, err = tmpFile.Write(data)
 		if err != nil {
 			logger.Error("tmp file rewrite failed: " + err.Error())
-			return true
+			return err
 		}
 		// copy data
 		_, err = io.Copy(tmpFile, src)
 		if err != nil {
 			logger.Error("copy aof filed failed: " + err.Error())
-			return true
+			return err
 		}
-		return false
+		return nil
 	}()
-	if errOccurs {
-		return
+	if copyErr != nil {
+		_ = os.Remove(tmpFile.Name())
+		return copyErr
 	}
 
 	// replace current aof file by tmp file
@@ -137,12 +176,15 @@ func (persister *Persister) FinishRewrite(ctx *RewriteCtx) {
 		panic(err)
 	}
 	persister.aofFile = aofFile
+	persister.resetSize()
 
 	// write select command again to resume aof file selected db
 	// it should have the same db index with  persister.currentDB
 	data := protocol.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(persister.currentDB))).ToBytes()
-	_, err = persister.aofFile.Write(data)
+	n, err := persister.aofFile.Write(data)
 	if err != nil {
 		panic(err)
 	}
+	persister.recordWrite(n, nil)
+	return nil
 }
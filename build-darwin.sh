@@ -1,3 +1,5 @@
 #!/usr/bin/env bash
 
 go build -o target/godis-darwin ./
+go build -o target/godis-check-aof-darwin ./cmd/godis-check-aof
+go build -o target/godis-check-dump-darwin ./cmd/godis-check-dump
@@ -1,3 +1,5 @@
 #!/usr/bin/env bash
 
-CGO_ENABLED=0  GOOS=linux GOARCH=amd64 go build -o target/godis-linux ./
\ No newline at end of file
+CGO_ENABLED=0  GOOS=linux GOARCH=amd64 go build -o target/godis-linux ./
+CGO_ENABLED=0  GOOS=linux GOARCH=amd64 go build -o target/godis-check-aof-linux ./cmd/godis-check-aof
+CGO_ENABLED=0  GOOS=linux GOARCH=amd64 go build -o target/godis-check-dump-linux ./cmd/godis-check-dump
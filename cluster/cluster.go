@@ -31,6 +31,8 @@ func MakeCluster() *Cluster {
 		StartAsSeed: config.Properties.ClusterAsSeed,
 		JoinAddress: config.Properties.ClusterSeed,
 		Master:      config.Properties.MasterInCluster,
+		TxLogPath:   path.Join(config.Properties.Dir, "tcc.log"),
+		Redirect:    config.Properties.ClusterRedirect,
 	})
 	if err != nil {
 		logger.Error(err.Error())
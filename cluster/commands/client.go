@@ -0,0 +1,8 @@
+package commands
+
+import "github.com/hdt3213/godis/cluster/core"
+
+func init() {
+	// connections are managed by each node, so CLIENT works on the node which client connected to
+	core.RegisterCmd("client", makeLocalFunc("client"))
+}
@@ -0,0 +1,8 @@
+package commands
+
+import "github.com/hdt3213/godis/cluster/core"
+
+func init() {
+	// each node has its own config file, so CONFIG works on the node which client connected to
+	core.RegisterCmd("config", makeLocalFunc("config"))
+}
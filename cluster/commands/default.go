@@ -8,11 +8,15 @@ func init() {
 		"expireAt",
 		"pExpire",
 		"pExpireAt",
+		"expireTime",
+		"pExpireTime",
 		"ttl",
 		"PTtl",
 		"persist",
 		"exists",
 		"type",
+		"dump",
+		"restore",
 		"set",
 		"setNx",
 		"setEx",
@@ -21,6 +25,7 @@ func init() {
 		"getEx",
 		"getSet",
 		"getDel",
+		"lcs",
 		"incr",
 		"incrBy",
 		"incrByFloat",
@@ -37,6 +42,7 @@ func init() {
 		"LIndex",
 		"LSet",
 		"LRange",
+		"LPos",
 		"HSet",
 		"HSetNx",
 		"HGet",
@@ -52,6 +58,15 @@ func init() {
 		"HIncrBy",
 		"HIncrByFloat",
 		"HRandField",
+		"HExpire",
+		"HPExpire",
+		"HExpireAt",
+		"HPExpireAt",
+		"HTTL",
+		"HPTTL",
+		"HExpireTime",
+		"HPExpireTime",
+		"HPersist",
 		"SAdd",
 		"SIsMember",
 		"SRem",
@@ -67,24 +82,51 @@ func init() {
 		"SRandMember",
 		"ZAdd",
 		"ZScore",
+		"ZMScore",
 		"ZIncrBy",
 		"ZRank",
 		"ZCount",
 		"ZRevRank",
 		"ZCard",
 		"ZRange",
+		"ZRangeStore",
 		"ZRevRange",
 		"ZRangeByScore",
 		"ZRevRangeByScore",
 		"ZRem",
 		"ZRemRangeByScore",
 		"ZRemRangeByRank",
+		"ZRandMember",
+		"ZUnionStore",
+		"ZInterStore",
+		"ZDiffStore",
 		"GeoAdd",
 		"GeoPos",
 		"GeoDist",
 		"GeoHash",
 		"GeoRadius",
 		"GeoRadiusByMember",
+		"BF.Reserve",
+		"BF.Add",
+		"BF.MAdd",
+		"BF.Exists",
+		"CF.Reserve",
+		"CF.Add",
+		"CF.Exists",
+		"CF.Del",
+		"CMS.InitByDim",
+		"CMS.InitByProb",
+		"CMS.IncrBy",
+		"CMS.Query",
+		"TopK.Reserve",
+		"TopK.Add",
+		"TopK.IncrBy",
+		"TopK.Query",
+		"TopK.List",
+		"TDigest.Create",
+		"TDigest.Add",
+		"TDigest.Quantile",
+		"SPublish",
 		"GetVer",
 		"DumpKey",
 	}
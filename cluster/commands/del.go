@@ -24,6 +24,9 @@ func execDel(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) redis.R
 	if len(cmdLine) < 2 {
 		return protocol.MakeArgNumErrReply("del")
 	}
+	if cluster.IsRedirectEnabled() {
+		return execWithinSlot(cluster, c, cmdLine)
+	}
 	var keys []string
 	for i := 1; i < len(cmdLine); i++ {
 		key := string(cmdLine[i])
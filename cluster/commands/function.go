@@ -0,0 +1,11 @@
+package commands
+
+import "github.com/hdt3213/godis/cluster/core"
+
+func init() {
+	// functions are loaded on each node like redis cluster, so FUNCTION is executed by the node which client connected to
+	core.RegisterCmd("function", makeLocalFunc("function"))
+	// FCALL and FCALL_RO are routed by keys after numkeys
+	core.RegisterCmd("fcall", core.KeysFunc)
+	core.RegisterCmd("fcall_ro", core.KeysFunc)
+}
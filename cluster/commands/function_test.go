@@ -0,0 +1,48 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/hdt3213/godis/cluster/core"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+const testLibrary = `#!lua name=mylib
+redis.register_function('myset', function(keys, args) return redis.call('set', keys[1], args[1]) end)
+redis.register_function{
+  function_name = 'myget',
+  callback = function(keys, args) return redis.call('get', keys[1]) end,
+  flags = {'no-writes'},
+}
+redis.register_function('myone', function(keys, args) return 1 end)`
+
+func TestFCall(t *testing.T) {
+	id1 := "1"
+	id2 := "2"
+	nodes := core.MakeTestCluster([]string{id1, id2})
+	node1 := nodes[id1]
+	node2 := nodes[id2]
+	c := connection.NewFakeConn()
+	for _, node := range nodes {
+		res := node.Exec(connection.NewFakeConn(), utils.ToCmdLine("function", "load", testLibrary))
+		asserts.AssertBulkReply(t, res, "mylib")
+	}
+
+	// key 1 is on node2 and key 2 is on node1, see MakeTestCluster
+	res := node1.Exec(c, utils.ToCmdLine("fcall", "myset", "1", "1", "a"))
+	asserts.AssertStatusReply(t, res, "OK")
+	res = node2.Exec(connection.NewFakeConn(), utils.ToCmdLine("get", "1"))
+	asserts.AssertBulkReply(t, res, "a")
+	res = node1.Exec(c, utils.ToCmdLine("fcall_ro", "myget", "1", "1"))
+	asserts.AssertBulkReply(t, res, "a")
+	res = node1.Exec(c, utils.ToCmdLine("fcall", "myset", "2", "1", "2", "b"))
+	asserts.AssertErrReply(t, res, "CROSSSLOT Keys in request don't hash to the same slot")
+	res = node1.Exec(c, utils.ToCmdLine("fcall_ro", "myget", "2", "1", "2"))
+	asserts.AssertErrReply(t, res, "CROSSSLOT Keys in request don't hash to the same slot")
+
+	// functions without keys are executed locally
+	res = node1.Exec(c, utils.ToCmdLine("fcall", "myone", "0"))
+	asserts.AssertIntReply(t, res, 1)
+}
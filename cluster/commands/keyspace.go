@@ -0,0 +1,170 @@
+package commands
+
+import (
+	"strconv"
+
+	"github.com/hdt3213/godis/cluster/core"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+/*
+Keyspace-wide commands are scattered to all masters and their results are gathered, so that the cluster looks like
+a standalone server. If cluster-redirect is enabled they are executed locally like redis cluster,
+smart clients send them to each master by themselves.
+*/
+
+// scanNodeShift is the position of node index in cursor of cluster scan, lower bits are cursor of the node
+const scanNodeShift = 32
+
+func init() {
+	core.RegisterCmd("keys_", makeLocalFunc("keys"))
+	core.RegisterCmd("keys", execKeys)
+	core.RegisterCmd("dbsize_", makeLocalFunc("dbsize"))
+	core.RegisterCmd("dbsize", execDBSize)
+	core.RegisterCmd("flushdb_", makeLocalFunc("flushdb"))
+	core.RegisterCmd("flushdb", execFlush)
+	core.RegisterCmd("flushall_", makeLocalFunc("flushall"))
+	core.RegisterCmd("flushall", execFlush)
+	core.RegisterCmd("scan_", execScanInLocal)
+	core.RegisterCmd("scan", execScan)
+}
+
+// makeLocalFunc creates a CmdFunc executing command at local node, which receives broadcast from other nodes
+func makeLocalFunc(name string) core.CmdFunc {
+	return func(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+		// do not modify cmdLine in place, it is shared by all nodes if broadcast is relayed to self
+		return cluster.LocalExec(c, append(utils.ToCmdLine(name), cmdLine[1:]...))
+	}
+}
+
+// broadcast executes cmdLine on all masters, returns replies in order of masters or the first error
+func broadcast(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) ([]redis.Reply, redis.Reply) {
+	masters := cluster.GetMasters()
+	replies := make([]redis.Reply, 0, len(masters))
+	for _, node := range masters {
+		reply := cluster.Relay(node, c, cmdLine)
+		if protocol.IsErrorReply(reply) {
+			return nil, reply
+		}
+		replies = append(replies, reply)
+	}
+	return replies, nil
+}
+
+func execKeys(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if len(cmdLine) != 2 {
+		return protocol.MakeArgNumErrReply("keys")
+	}
+	if cluster.IsRedirectEnabled() {
+		return cluster.LocalExec(c, cmdLine)
+	}
+	replies, errReply := broadcast(cluster, c, utils.ToCmdLine3("keys_", cmdLine[1]))
+	if errReply != nil {
+		return errReply
+	}
+	var keys [][]byte
+	for _, reply := range replies {
+		nodeKeys, ok := reply.(*protocol.MultiBulkReply)
+		if !ok {
+			return protocol.MakeErrReply("ERR illegal keys reply: " + string(reply.ToBytes()))
+		}
+		keys = append(keys, nodeKeys.Args...)
+	}
+	return protocol.MakeMultiBulkReply(keys)
+}
+
+func execDBSize(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if len(cmdLine) != 1 {
+		return protocol.MakeArgNumErrReply("dbsize")
+	}
+	if cluster.IsRedirectEnabled() {
+		return cluster.LocalExec(c, cmdLine)
+	}
+	replies, errReply := broadcast(cluster, c, utils.ToCmdLine("dbsize_"))
+	if errReply != nil {
+		return errReply
+	}
+	var size int64
+	for _, reply := range replies {
+		nodeSize, ok := reply.(*protocol.IntReply)
+		if !ok {
+			return protocol.MakeErrReply("ERR illegal dbsize reply: " + string(reply.ToBytes()))
+		}
+		size += nodeSize.Code
+	}
+	return protocol.MakeIntReply(size)
+}
+
+// execFlush handles FLUSHDB and FLUSHALL
+func execFlush(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if cluster.IsRedirectEnabled() {
+		return cluster.LocalExec(c, cmdLine)
+	}
+	localCmdLine := append(utils.ToCmdLine(string(cmdLine[0])+"_"), cmdLine[1:]...)
+	_, errReply := broadcast(cluster, c, localCmdLine)
+	if errReply != nil {
+		return errReply
+	}
+	return protocol.MakeOkReply()
+}
+
+// execScanInLocal scans local node and returns [cursor, keys...] in a flat array,
+// because peer client cannot parse nested arrays
+func execScanInLocal(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	reply := cluster.LocalExec(c, append(utils.ToCmdLine("scan"), cmdLine[1:]...))
+	scanReply, ok := reply.(*protocol.MultiRawReply)
+	if !ok || len(scanReply.Replies) != 2 {
+		return reply
+	}
+	cursor, ok1 := scanReply.Replies[0].(*protocol.BulkReply)
+	keys, ok2 := scanReply.Replies[1].(*protocol.MultiBulkReply)
+	if !ok1 || !ok2 {
+		return protocol.MakeErrReply("ERR illegal scan reply")
+	}
+	return protocol.MakeMultiBulkReply(append([][]byte{cursor.Arg}, keys.Args...))
+}
+
+// execScan scans masters one by one, the cursor embeds index of master (see scanNodeShift)
+func execScan(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if len(cmdLine) < 2 {
+		return protocol.MakeArgNumErrReply("scan")
+	}
+	if cluster.IsRedirectEnabled() {
+		return cluster.LocalExec(c, cmdLine)
+	}
+	cursor, err := strconv.ParseUint(string(cmdLine[1]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR invalid cursor")
+	}
+	masters := cluster.GetMasters()
+	nodeIndex := int(cursor >> scanNodeShift)
+	if nodeIndex >= len(masters) {
+		return protocol.MakeErrReply("ERR invalid cursor")
+	}
+	localCursor := cursor & (1<<scanNodeShift - 1)
+	localCmdLine := utils.ToCmdLine("scan_", strconv.FormatUint(localCursor, 10))
+	localCmdLine = append(localCmdLine, cmdLine[2:]...)
+	reply := cluster.Relay(masters[nodeIndex], c, localCmdLine)
+	if protocol.IsErrorReply(reply) {
+		return reply
+	}
+	result, ok := reply.(*protocol.MultiBulkReply)
+	if !ok || len(result.Args) == 0 {
+		return protocol.MakeErrReply("ERR illegal scan reply: " + string(reply.ToBytes()))
+	}
+	nextCursor, err := strconv.ParseUint(string(result.Args[0]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR illegal scan cursor: " + string(result.Args[0]))
+	}
+	if nextCursor == 0 {
+		// current master finished, continue with the next one or finish after the last master
+		nodeIndex = (nodeIndex + 1) % len(masters)
+	}
+	nextCursor |= uint64(nodeIndex) << scanNodeShift
+	return protocol.MakeMultiRawReply([]redis.Reply{
+		protocol.MakeBulkReply([]byte(strconv.FormatUint(nextCursor, 10))),
+		protocol.MakeMultiBulkReply(result.Args[1:]),
+	})
+}
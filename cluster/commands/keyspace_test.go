@@ -0,0 +1,68 @@
+package commands
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/hdt3213/godis/cluster/core"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestKeyspaceFanOut(t *testing.T) {
+	id1 := "1"
+	id2 := "2"
+	nodes := core.MakeTestCluster([]string{id1, id2})
+	node1 := nodes[id1]
+	c := connection.NewFakeConn()
+	var expected []string
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		node1.Exec(c, utils.ToCmdLine("set", key, key))
+		expected = append(expected, key)
+	}
+	sort.Strings(expected)
+
+	res := node1.Exec(c, utils.ToCmdLine("dbsize"))
+	asserts.AssertIntReply(t, res, 100)
+	res = node1.Exec(c, utils.ToCmdLine("keys", "*"))
+	asserts.AssertMultiBulkReplySize(t, res, 100)
+	res = node1.Exec(c, utils.ToCmdLine("keys", "1?"))
+	asserts.AssertMultiBulkReplySize(t, res, 10)
+
+	var scanned []string
+	cursor := "0"
+	for i := 0; i < 1000; i++ {
+		res = node1.Exec(c, utils.ToCmdLine("scan", cursor, "count", "7"))
+		scanReply, ok := res.(*protocol.MultiRawReply)
+		if !ok {
+			t.Fatalf("illegal scan reply: %s", string(res.ToBytes()))
+		}
+		cursor = string(scanReply.Replies[0].(*protocol.BulkReply).Arg)
+		for _, key := range scanReply.Replies[1].(*protocol.MultiBulkReply).Args {
+			scanned = append(scanned, string(key))
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	sort.Strings(scanned)
+	if len(scanned) != len(expected) {
+		t.Fatalf("expect %d keys scanned, actual %d", len(expected), len(scanned))
+	}
+	for i := range expected {
+		if scanned[i] != expected[i] {
+			t.Fatalf("expect %s, actual %s", expected[i], scanned[i])
+		}
+	}
+	res = node1.Exec(c, utils.ToCmdLine("scan", strconv.FormatUint(2<<scanNodeShift, 10)))
+	asserts.AssertErrReply(t, res, "ERR invalid cursor")
+
+	res = node1.Exec(c, utils.ToCmdLine("flushall"))
+	asserts.AssertStatusReply(t, res, "OK")
+	res = node1.Exec(c, utils.ToCmdLine("dbsize"))
+	asserts.AssertIntReply(t, res, 0)
+}
@@ -15,7 +15,7 @@ func init() {
 	core.RegisterCmd("mget_", execMGetInLocal)
 	core.RegisterCmd("mget", execMGet)
 	core.RegisterCmd("msetnx_", execMSetNxInLocal)
-	core.RegisterCmd("msetnx", execMSet)
+	core.RegisterCmd("msetnx", execMSetNx)
 }
 
 // execMSetInLocal executes msets in local node
@@ -49,6 +49,9 @@ func execMSet(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) redis.
 	if len(cmdLine) < 3 || len(cmdLine)%2 != 1 {
 		return protocol.MakeArgNumErrReply("mset")
 	}
+	if cluster.IsRedirectEnabled() {
+		return execWithinSlot(cluster, c, cmdLine)
+	}
 	var keys []string
 	keyValues := make(map[string][]byte)
 	for i := 1; i < len(cmdLine); i += 2 {
@@ -92,6 +95,9 @@ func execMGet(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) redis.
 	if len(cmdLine) < 2 {
 		return protocol.MakeArgNumErrReply("mget")
 	}
+	if cluster.IsRedirectEnabled() {
+		return execWithinSlot(cluster, c, cmdLine)
+	}
 	keys := make([]string, 0, len(cmdLine)-1)
 	for i := 1; i < len(cmdLine); i++ {
 		keys = append(keys, string(cmdLine[i]))
@@ -162,6 +168,9 @@ func execMSetNx(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) redi
 	if len(cmdLine) < 3 || len(cmdLine)%2 != 1 {
 		return protocol.MakeArgNumErrReply("mset")
 	}
+	if cluster.IsRedirectEnabled() {
+		return execWithinSlot(cluster, c, cmdLine)
+	}
 	var keys []string
 	keyValues := make(map[string][]byte)
 	for i := 1; i < len(cmdLine); i += 2 {
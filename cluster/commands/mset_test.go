@@ -39,4 +39,6 @@ func TestMsetNx(t *testing.T) {
 	core.RegisterDefaultCmd("get")
 	res = node1.Exec(c, utils.ToCmdLine("get", "3"))
 	asserts.AssertNullBulk(t, res)
+	res = node1.Exec(c, utils.ToCmdLine("msetnx", "3", "3", "2", "2"))
+	asserts.AssertIntReply(t, res, 0)
 }
\ No newline at end of file
@@ -0,0 +1,180 @@
+package commands
+
+import (
+	"github.com/hdt3213/godis/cluster/core"
+	"github.com/hdt3213/godis/database"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+func init() {
+	core.RegisterCmd("multi", execMulti)
+	core.RegisterCmd("exec", execExec)
+	core.RegisterCmd("discard", execDiscard)
+	core.RegisterCmd("watch", execWatch)
+	core.RegisterCmd("unwatch", execUnWatch)
+}
+
+// rawReply is a serialized reply returned by peers
+type rawReply []byte
+
+func (r rawReply) ToBytes() []byte {
+	return r
+}
+
+func execMulti(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if len(cmdLine) != 1 {
+		return protocol.MakeArgNumErrReply("multi")
+	}
+	return database.StartMulti(c)
+}
+
+func execDiscard(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if len(cmdLine) != 1 {
+		return protocol.MakeArgNumErrReply("discard")
+	}
+	return database.DiscardMulti(c)
+}
+
+func execUnWatch(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if len(cmdLine) != 1 {
+		return protocol.MakeArgNumErrReply("unwatch")
+	}
+	return database.UnWatch(c)
+}
+
+// execWatch records versions of keys from nodes they belong to
+func execWatch(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if len(cmdLine) < 2 {
+		return protocol.MakeArgNumErrReply("watch")
+	}
+	if c.InMultiState() {
+		return protocol.MakeErrReply("ERR WATCH inside MULTI is not allowed")
+	}
+	versions := make(map[string]uint32, len(cmdLine)-1)
+	for _, arg := range cmdLine[1:] {
+		key := string(arg)
+		node := cluster.PickNode(cluster.GetSlot(key))
+		reply := cluster.Relay(node, c, utils.ToCmdLine("getver", key))
+		version, ok := reply.(*protocol.IntReply)
+		if !ok {
+			if err := protocol.Try2ErrorReply(reply); err != nil {
+				return protocol.MakeErrReply("watch failed: " + err.Error())
+			}
+			return protocol.MakeErrReply("watch failed: illegal version of " + key)
+		}
+		versions[key] = uint32(version.Code)
+	}
+	watching := c.GetWatching()
+	for key, ver := range versions {
+		watching[key] = ver
+	}
+	return protocol.MakeOkReply()
+}
+
+// multiGroup is the part of transaction executed on one node
+type multiGroup struct {
+	indexes  []int // position of each command in the transaction
+	cmdLines []CmdLine
+	watching map[string]uint32
+}
+
+// execExec executes queued commands of MULTI which may access keys on different nodes.
+// Every node checks its watched keys and prepares its commands within locks,
+// then the commands are committed by TCC. Keys of each command must hash to a single slot.
+func execExec(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if len(cmdLine) != 1 {
+		return protocol.MakeArgNumErrReply("exec")
+	}
+	if !c.InMultiState() {
+		return protocol.MakeErrReply("ERR EXEC without MULTI")
+	}
+	cmdLines := c.GetQueuedCmdLine()
+	watching := c.GetWatching()
+	txErrors := c.GetTxErrors()
+	// quit multi state first, commands relayed to self will not be queued
+	c.SetMultiState(false)
+	if len(txErrors) > 0 {
+		return protocol.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+
+	groups := make(map[string]*multiGroup)
+	getGroup := func(node string) *multiGroup {
+		group := groups[node]
+		if group == nil {
+			group = &multiGroup{
+				watching: make(map[string]uint32),
+			}
+			groups[node] = group
+		}
+		return group
+	}
+	for i, line := range cmdLines {
+		write, read := database.GetRelatedKeys(line)
+		keys := append(write, read...)
+		node := cluster.SelfID()
+		if len(keys) > 0 {
+			// keys of queued command hash to one slot, see core.Cluster.Exec
+			node = cluster.PickNode(cluster.GetSlot(keys[0]))
+		}
+		group := getGroup(node)
+		group.indexes = append(group.indexes, i)
+		group.cmdLines = append(group.cmdLines, line)
+	}
+	for key, ver := range watching {
+		group := getGroup(cluster.PickNode(cluster.GetSlot(key)))
+		group.watching[key] = ver
+	}
+	if len(groups) == 0 {
+		return protocol.MakeEmptyMultiBulkReply()
+	}
+	if group, ok := groups[cluster.SelfID()]; ok && len(groups) == 1 {
+		// only local keys, do it fast
+		return cluster.LocalExecMulti(c, group.watching, group.cmdLines)
+	}
+
+	txId := utils.RandString(6)
+	nodes := make(RouteMap, len(groups))
+	for node := range groups {
+		nodes[node] = nil
+	}
+	if err := beginTx(cluster, txId, nodes); err != nil {
+		return err
+	}
+	for node, group := range groups {
+		prepareCmd := utils.ToCmdLine("preparemulti", txId)
+		prepareCmd = append(prepareCmd, core.EncodeMultiTx(group.watching, group.cmdLines)...)
+		reply := cluster.Relay(node, c, prepareCmd)
+		if err := protocol.Try2ErrorReply(reply); err != nil {
+			requestRollback(cluster, c, txId, nodes)
+			if err.Error() == core.ErrWatchChanged {
+				return &protocol.NullMultiBulkReply{}
+			}
+			return protocol.MakeErrReply("EXECABORT prepare failed: " + err.Error())
+		}
+	}
+
+	if err := decideCommit(cluster, c, txId, nodes); err != nil {
+		return err
+	}
+	results := make([]redis.Reply, len(cmdLines))
+	commitCmd := utils.ToCmdLine("commit", txId)
+	for node, group := range groups {
+		reply := cluster.Relay(node, c, commitCmd)
+		if err := protocol.Try2ErrorReply(reply); err != nil {
+			requestRollback(cluster, c, txId, nodes)
+			return protocol.MakeErrReply("EXECABORT commit failed: " + err.Error())
+		}
+		committed, ok := reply.(*protocol.MultiBulkReply)
+		if !ok || len(committed.Args) != len(group.indexes) {
+			requestRollback(cluster, c, txId, nodes)
+			return protocol.MakeErrReply("EXECABORT commit failed: illegal reply")
+		}
+		for i, index := range group.indexes {
+			results[index] = rawReply(committed.Args[i])
+		}
+	}
+	cluster.LogTxEnd(txId)
+	return protocol.MakeMultiRawReply(results)
+}
@@ -0,0 +1,101 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/hdt3213/godis/cluster/core"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestMultiExec(t *testing.T) {
+	id1 := "1"
+	id2 := "2"
+	nodes := core.MakeTestCluster([]string{id1, id2})
+	node1 := nodes[id1]
+	node2 := nodes[id2]
+	c := connection.NewFakeConn()
+	// key 1 is on node2 and key 2 is on node1, see MakeTestCluster
+	node1.Exec(c, utils.ToCmdLine("multi"))
+	res := node1.Exec(c, utils.ToCmdLine("set", "1", "a"))
+	asserts.AssertStatusReply(t, res, "QUEUED")
+	node1.Exec(c, utils.ToCmdLine("set", "2", "b"))
+	node1.Exec(c, utils.ToCmdLine("get", "1"))
+	res = node1.Exec(c, utils.ToCmdLine("exec"))
+	if string(res.ToBytes()) != "*3\r\n+OK\r\n+OK\r\n$1\r\na\r\n" {
+		t.Errorf("unexpected reply %s", res.ToBytes())
+	}
+	res = node2.Exec(connection.NewFakeConn(), utils.ToCmdLine("get", "1"))
+	asserts.AssertBulkReply(t, res, "a")
+
+	// watched key on remote node modified
+	node1.Exec(c, utils.ToCmdLine("watch", "1"))
+	node2.Exec(connection.NewFakeConn(), utils.ToCmdLine("set", "1", "a2"))
+	node1.Exec(c, utils.ToCmdLine("multi"))
+	node1.Exec(c, utils.ToCmdLine("set", "2", "c"))
+	res = node1.Exec(c, utils.ToCmdLine("exec"))
+	asserts.AssertNullMultiBulk(t, res)
+	res = node1.Exec(c, utils.ToCmdLine("get", "2"))
+	asserts.AssertBulkReply(t, res, "b")
+
+	// watched keys unchanged
+	node1.Exec(c, utils.ToCmdLine("watch", "1", "2"))
+	node1.Exec(c, utils.ToCmdLine("multi"))
+	node1.Exec(c, utils.ToCmdLine("set", "1", "x"))
+	res = node1.Exec(c, utils.ToCmdLine("exec"))
+	if string(res.ToBytes()) != "*1\r\n+OK\r\n" {
+		t.Errorf("unexpected reply %s", res.ToBytes())
+	}
+
+	// local transaction
+	node1.Exec(c, utils.ToCmdLine("watch", "2"))
+	node1.Exec(c, utils.ToCmdLine("multi"))
+	node1.Exec(c, utils.ToCmdLine("set", "2", "y"))
+	res = node1.Exec(c, utils.ToCmdLine("exec"))
+	if string(res.ToBytes()) != "*1\r\n+OK\r\n" {
+		t.Errorf("unexpected reply %s", res.ToBytes())
+	}
+}
+
+func TestMultiExecAbort(t *testing.T) {
+	id1 := "1"
+	id2 := "2"
+	nodes := core.MakeTestCluster([]string{id1, id2})
+	node1 := nodes[id1]
+	c := connection.NewFakeConn()
+	node1.Exec(c, utils.ToCmdLine("set", "1", "a"))
+	node1.Exec(c, utils.ToCmdLine("set", "2", "b"))
+
+	// runtime error rolls back all nodes
+	node1.Exec(c, utils.ToCmdLine("multi"))
+	node1.Exec(c, utils.ToCmdLine("set", "2", "c"))
+	node1.Exec(c, utils.ToCmdLine("lpush", "1", "v"))
+	res := node1.Exec(c, utils.ToCmdLine("exec"))
+	asserts.AssertErrReply(t, res, "EXECABORT commit failed: WRONGTYPE Operation against a key holding the wrong kind of value")
+	res = node1.Exec(c, utils.ToCmdLine("get", "2"))
+	asserts.AssertBulkReply(t, res, "b")
+
+	// command across slots
+	node1.Exec(c, utils.ToCmdLine("multi"))
+	res = node1.Exec(c, utils.ToCmdLine("mset", "1", "x", "2", "y"))
+	asserts.AssertErrReply(t, res, "CROSSSLOT Keys in request don't hash to the same slot")
+	res = node1.Exec(c, utils.ToCmdLine("exec"))
+	asserts.AssertErrReply(t, res, "EXECABORT Transaction discarded because of previous errors.")
+
+	// errors while queueing
+	node1.Exec(c, utils.ToCmdLine("multi"))
+	res = node1.Exec(c, utils.ToCmdLine("set", "1"))
+	asserts.AssertErrReply(t, res, "ERR wrong number of arguments for 'set' command")
+	res = node1.Exec(c, utils.ToCmdLine("watch", "1"))
+	asserts.AssertErrReply(t, res, "ERR WATCH inside MULTI is not allowed")
+	res = node1.Exec(c, utils.ToCmdLine("exec"))
+	asserts.AssertErrReply(t, res, "EXECABORT Transaction discarded because of previous errors.")
+
+	node1.Exec(c, utils.ToCmdLine("multi"))
+	node1.Exec(c, utils.ToCmdLine("set", "1", "z"))
+	res = node1.Exec(c, utils.ToCmdLine("discard"))
+	asserts.AssertStatusReply(t, res, "OK")
+	res = node1.Exec(c, utils.ToCmdLine("get", "1"))
+	asserts.AssertBulkReply(t, res, "a")
+}
@@ -0,0 +1,56 @@
+package commands
+
+import (
+	"strconv"
+
+	"github.com/hdt3213/godis/cluster/core"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+func init() {
+	core.RegisterCmd("ssubscribe", execSSubscribe)
+	core.RegisterCmd("sunsubscribe", execSUnSubscribe)
+	core.RegisterCmd("pubsub", execPubSub)
+	// spublish is routed by the slot of channel, see default.go
+}
+
+// checkShardChannels makes sure all shard channels belong to one slot served by current node,
+// because messages of a shard channel are only published in the node serving its slot
+func checkShardChannels(cluster *core.Cluster, channels [][]byte) redis.Reply {
+	if len(channels) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		keys = append(keys, string(channel))
+	}
+	if !cluster.IsSameSlot(keys) {
+		return core.MakeCrossSlotErrReply()
+	}
+	slot := cluster.GetSlot(keys[0])
+	if node := cluster.PickNode(slot); node != cluster.SelfID() {
+		return protocol.MakeErrReply("MOVED " + strconv.Itoa(int(slot)) + " " + node)
+	}
+	return nil
+}
+
+func execSSubscribe(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if len(cmdLine) < 2 {
+		return protocol.MakeArgNumErrReply("ssubscribe")
+	}
+	if errReply := checkShardChannels(cluster, cmdLine[1:]); errReply != nil {
+		return errReply
+	}
+	return cluster.LocalExec(c, cmdLine)
+}
+
+func execSUnSubscribe(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	// unsubscribing is always done in local node, which holds subscriptions of the connection
+	return cluster.LocalExec(c, cmdLine)
+}
+
+// execPubSub shows subscriptions in current node only
+func execPubSub(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	return cluster.LocalExec(c, cmdLine)
+}
@@ -0,0 +1,42 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/hdt3213/godis/cluster/core"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/parser"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestShardPubSub(t *testing.T) {
+	id1 := "1"
+	id2 := "2"
+	nodes := core.MakeTestCluster([]string{id1, id2})
+	node1 := nodes[id1]
+	node2 := nodes[id2]
+	sub := connection.NewFakeConn()
+
+	// 1, 2 will be routed to node2 and node1, see MakeTestCluster
+	res := node1.Exec(sub, utils.ToCmdLine("ssubscribe", "1"))
+	asserts.AssertErrReply(t, res, "MOVED 1 2")
+	res = node1.Exec(sub, utils.ToCmdLine("ssubscribe", "1", "2"))
+	asserts.AssertErrReply(t, res, "CROSSSLOT Keys in request don't hash to the same slot")
+	node1.Exec(sub, utils.ToCmdLine("ssubscribe", "2"))
+	sub.Clean()
+
+	// published from another node
+	c := connection.NewFakeConn()
+	res = node2.Exec(c, utils.ToCmdLine("spublish", "2", "hello"))
+	asserts.AssertIntReply(t, res, 1)
+	msg, err := parser.ParseOne(sub.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.AssertMultiBulkReply(t, msg, []string{"smessage", "2", "hello"})
+
+	node1.Exec(sub, utils.ToCmdLine("sunsubscribe"))
+	res = node2.Exec(c, utils.ToCmdLine("spublish", "2", "hello"))
+	asserts.AssertIntReply(t, res, 0)
+}
@@ -31,6 +31,9 @@ func execRename(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) redi
 	if len(cmdLine) != 3 {
 		return protocol.MakeArgNumErrReply("rename")
 	}
+	if cluster.IsRedirectEnabled() {
+		return execWithinSlot(cluster, c, cmdLine)
+	}
 	src := string(cmdLine[1])
 	target := string(cmdLine[2])
 	srcSlot := cluster.GetSlot(src)
@@ -47,6 +50,9 @@ func execRename(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) redi
 	}
 
 	txID := utils.RandString(10)
+	if err := beginTx(cluster, txID, routeMap); err != nil {
+		return err
+	}
 	srcPrepareResp := cluster.Relay(srcNode, c, utils.ToCmdLine("Prepare", txID, "RenameFrom", src))
 	if protocol.IsErrorReply(srcPrepareResp) {
 		// rollback src node
@@ -68,6 +74,9 @@ func execRename(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) redi
 	}
 
 	// commit
+	if err := decideCommit(cluster, c, txID, routeMap); err != nil {
+		return err
+	}
 	commitCmd := utils.ToCmdLine("commit", txID)
 	for node := range routeMap {
 		reply := cluster.Relay(node, c, commitCmd)
@@ -76,6 +85,7 @@ func execRename(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) redi
 			return protocol.MakeErrReply("commit failed: " + err.Error())
 		}
 	}
+	cluster.LogTxEnd(txID)
 	return protocol.MakeOkReply()
 }
 
@@ -83,6 +93,9 @@ func execRenameNx(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) re
 	if len(cmdLine) != 3 {
 		return protocol.MakeArgNumErrReply("rename")
 	}
+	if cluster.IsRedirectEnabled() {
+		return execWithinSlot(cluster, c, cmdLine)
+	}
 	src := string(cmdLine[1])
 	target := string(cmdLine[2])
 	srcSlot := cluster.GetSlot(src)
@@ -99,6 +112,9 @@ func execRenameNx(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) re
 	}
 
 	txID := utils.RandString(10)
+	if err := beginTx(cluster, txID, routeMap); err != nil {
+		return err
+	}
 	srcPrepareResp := cluster.Relay(srcNode, c, utils.ToCmdLine("Prepare", txID, "RenameFrom", src))
 	if protocol.IsErrorReply(srcPrepareResp) {
 		// rollback src node
@@ -123,6 +139,9 @@ func execRenameNx(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) re
 	}
 
 	// commit
+	if err := decideCommit(cluster, c, txID, routeMap); err != nil {
+		return err
+	}
 	commitCmd := utils.ToCmdLine("commit", txID)
 	for node := range routeMap {
 		reply := cluster.Relay(node, c, commitCmd)
@@ -131,6 +150,7 @@ func execRenameNx(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) re
 			return protocol.MakeErrReply("commit failed: " + err.Error())
 		}
 	}
+	cluster.LogTxEnd(txID)
 	return protocol.MakeIntReply(1)
 }
 
@@ -0,0 +1,8 @@
+package commands
+
+import "github.com/hdt3213/godis/cluster/core"
+
+func init() {
+	// SHUTDOWN stops the node which client connected to
+	core.RegisterCmd("shutdown", makeLocalFunc("shutdown"))
+}
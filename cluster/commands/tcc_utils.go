@@ -12,6 +12,13 @@ type CmdLine = [][]byte
 // node -> keys on the node
 type RouteMap map[string][]string
 
+// execWithinSlot is used instead of tcc if cluster-redirect is enabled.
+// Smart clients route commands by slot, so keys of multi-key commands must hash to the same slot,
+// then the command is served or redirected like single-key commands.
+func execWithinSlot(cluster *core.Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	return core.DefaultFunc(cluster, c, cmdLine)
+}
+
 func getRouteMap(cluster *core.Cluster, keys []string) RouteMap {
 	m := make(RouteMap)
 	for _, key := range keys {
@@ -32,6 +39,9 @@ type TccTx struct {
 // returns node->result map
 func doTcc(cluster *core.Cluster, c redis.Connection, tx *TccTx) (map[string]redis.Reply, protocol.ErrorReply) {
 	txId := utils.RandString(6)
+	if err := beginTx(cluster, txId, tx.routeMap); err != nil {
+		return nil, err
+	}
 
 	// send prepare request
 	for node, cmdLine := range tx.cmdLines {
@@ -45,6 +55,9 @@ func doTcc(cluster *core.Cluster, c redis.Connection, tx *TccTx) (map[string]red
 	}
 
 	// send commit request
+	if err := decideCommit(cluster, c, txId, tx.routeMap); err != nil {
+		return nil, err
+	}
 	commiteCmd := utils.ToCmdLine("commit", txId)
 	result := make(map[string]redis.Reply)
 	for node := range tx.routeMap {
@@ -55,13 +68,37 @@ func doTcc(cluster *core.Cluster, c redis.Connection, tx *TccTx) (map[string]red
 		}
 		result[node] = reply
 	}
+	cluster.LogTxEnd(txId)
 
 	return result, nil
 }
 
+// beginTx records participants in tcc log before sending prepare requests
+func beginTx(cluster *core.Cluster, txId string, routeMap RouteMap) protocol.ErrorReply {
+	nodes := make([]string, 0, len(routeMap))
+	for node := range routeMap {
+		nodes = append(nodes, node)
+	}
+	if err := cluster.LogTxBegin(txId, nodes); err != nil {
+		return protocol.MakeErrReply("ERR write tcc log failed: " + err.Error())
+	}
+	return nil
+}
+
+// decideCommit records the commit decision in tcc log, the transaction will be rolled back if failed
+func decideCommit(cluster *core.Cluster, c redis.Connection, txId string, routeMap RouteMap) protocol.ErrorReply {
+	if err := cluster.LogTxCommit(txId); err != nil {
+		requestRollback(cluster, c, txId, routeMap)
+		return protocol.MakeErrReply("ERR write tcc log failed: " + err.Error())
+	}
+	return nil
+}
+
 func requestRollback(cluster *core.Cluster, c redis.Connection, txId string, routeMap RouteMap) {
+	cluster.LogTxRollback(txId)
 	rollbackCmd := utils.ToCmdLine("rollback", txId)
 	for node := range routeMap {
 		cluster.Relay(node, c, rollbackCmd)
 	}
+	cluster.LogTxEnd(txId)
 }
\ No newline at end of file
@@ -3,12 +3,14 @@ package core
 import (
 	"fmt"
 	"runtime/debug"
+	"strconv"
 	"strings"
 
 	"github.com/hdt3213/godis/config"
 	"github.com/hdt3213/godis/database"
 	"github.com/hdt3213/godis/interface/redis"
 	"github.com/hdt3213/godis/lib/logger"
+	"github.com/hdt3213/godis/lib/utils"
 	"github.com/hdt3213/godis/redis/protocol"
 )
 
@@ -42,17 +44,48 @@ func (cluster *Cluster) Exec(c redis.Connection, cmdLine [][]byte) (result redis
 		return protocol.MakeErrReply("NOAUTH Authentication required")
 	}
 	cmdFunc, ok := commands[cmdName]
+	if !ok && database.IsModuleCommand(cmdName) {
+		cmdFunc, ok = KeysFunc, true
+	}
 	if !ok {
-		return protocol.MakeErrReply("ERR unknown command '" + cmdName + "', or not supported in cluster mode")
+		err := protocol.MakeErrReply("ERR unknown command '" + cmdName + "', or not supported in cluster mode")
+		if c.InMultiState() {
+			c.AddTxError(err)
+		}
+		return err
+	}
+	if c.InMultiState() && !txControlCommands[cmdName] {
+		// each command in transaction is executed by a single node, so its keys must be in one slot
+		write, read := database.GetRelatedKeys(cmdLine)
+		if !cluster.IsSameSlot(append(write, read...)) {
+			err := MakeCrossSlotErrReply()
+			c.AddTxError(err)
+			return err
+		}
+		return database.EnqueueCmd(c, cmdLine)
+	}
+	result = cmdFunc(cluster, c, cmdLine)
+	if cmdName != "asking" {
+		// ASKING only affects the next command
+		c.SetAsking(false)
 	}
-	return cmdFunc(cluster, c, cmdLine)
+	return result
+}
+
+// txControlCommands are executed immediately within MULTI, other commands are queued until EXEC
+var txControlCommands = map[string]bool{
+	"multi":   true,
+	"exec":    true,
+	"discard": true,
+	"watch":   true,
+	"unwatch": true,
 }
 
 func isAuthenticated(c redis.Connection) bool {
 	if config.Properties.RequirePass == "" {
 		return true
 	}
-	return c.GetPassword() == config.Properties.RequirePass
+	return utils.SecureCompare(c.GetPassword(), config.Properties.RequirePass)
 }
 
 func RegisterDefaultCmd(name string) {
@@ -60,14 +93,55 @@ func RegisterDefaultCmd(name string) {
 }
 
 // relay command to responsible peer, and return its protocol to client
+// or redirect client to the responsible peer if cluster-redirect is enabled
 func DefaultFunc(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
-	key := string(args[1])
+	write, read := database.GetRelatedKeys(args)
+	if !cluster.IsSameSlot(append(write, read...)) {
+		// all keys must be served by the node of the first key
+		return MakeCrossSlotErrReply()
+	}
+	return routeByKey(cluster, c, args, string(args[1]))
+}
+
+// KeysFunc routes commands by the first key returned by database.GetRelatedKeys, such as FCALL and
+// commands registered by database.RegisterCommand, commands without keys are executed locally
+func KeysFunc(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	write, read := database.GetRelatedKeys(args)
+	keys := append(write, read...)
+	if len(keys) == 0 {
+		return cluster.db.Exec(c, args)
+	}
+	if !cluster.IsSameSlot(keys) {
+		return MakeCrossSlotErrReply()
+	}
+	return routeByKey(cluster, c, args, keys[0])
+}
+
+// routeByKey executes command locally, or relays it to the node serving key
+func routeByKey(cluster *Cluster, c redis.Connection, args [][]byte, key string) redis.Reply {
 	slotId := cluster.GetSlot(key)
 	peer := cluster.PickNode(slotId)
 	if peer == cluster.SelfID() {
+		if target := cluster.getAskTarget(c, slotId, key); target != "" {
+			if cluster.config.Redirect {
+				return protocol.MakeErrReply("ASK " + strconv.Itoa(int(slotId)) + " " + target)
+			}
+			return cluster.Relay(target, c, append(utils.ToCmdLine(askingRelayCommand), args...))
+		}
 		// to self db
 		//return cluster.db.Exec(c, cmdLine)
 		return cluster.db.Exec(c, args)
 	}
+	if c.IsReadOnly() && database.IsReadOnlyCommand(string(args[0])) && cluster.isReplicaOf(peer) {
+		// client has sent READONLY, serve reads of master's slots by this replica
+		return cluster.db.Exec(c, args)
+	}
+	if c.IsAsking() && cluster.isImportingSlot(slotId) {
+		// client is redirected by ASK from the exporting node
+		return cluster.db.Exec(c, args)
+	}
+	if cluster.config.Redirect {
+		return protocol.MakeErrReply("MOVED " + strconv.Itoa(int(slotId)) + " " + peer)
+	}
 	return cluster.Relay(peer, c, args)
 }
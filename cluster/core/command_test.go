@@ -0,0 +1,83 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/hdt3213/godis/cluster/raft"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestRedirect(t *testing.T) {
+	RegisterDefaultCmd("get")
+	RegisterDefaultCmd("set")
+	nodes := MakeTestCluster([]string{"a", "b"})
+	nodeA, nodeB := nodes["a"], nodes["b"]
+	nodeA.config.Redirect = true
+	nodeB.config.Redirect = true
+	conn := connection.NewFakeConn()
+	// key "0" is routed to node a, key "1" is routed to node b, see MakeTestCluster
+	reply := nodeA.Exec(conn, utils.ToCmdLine("set", "1", "1"))
+	asserts.AssertErrReply(t, reply, "MOVED 1 b")
+	reply = nodeB.Exec(conn, utils.ToCmdLine("set", "1", "1"))
+	asserts.AssertStatusReply(t, reply, "OK")
+	reply = nodeA.Exec(conn, utils.ToCmdLine("set", "0", "0"))
+	asserts.AssertStatusReply(t, reply, "OK")
+
+	// slot 0 is migrating from a to b
+	task := &raft.MigratingTask{
+		ID:         "1",
+		SrcNode:    "a",
+		TargetNode: "b",
+		Slots:      []uint32{0},
+	}
+	nodeA.slotsManager.importingTask = task
+	nodeB.slotsManager.importingTask = task
+	if errReply := nodeA.slotsManager.getSlot(0).startExporting(); errReply != nil {
+		t.Fatal(errReply.Error())
+	}
+	// existing keys are still served by source node
+	reply = nodeA.Exec(conn, utils.ToCmdLine("get", "0"))
+	asserts.AssertBulkReply(t, reply, "0")
+	reply = nodeA.Exec(conn, utils.ToCmdLine("get", "{0}a"))
+	asserts.AssertErrReply(t, reply, "ASK 0 b")
+
+	reply = nodeB.Exec(conn, utils.ToCmdLine("set", "{0}a", "a"))
+	asserts.AssertErrReply(t, reply, "MOVED 0 a")
+	reply = nodeB.Exec(conn, utils.ToCmdLine("asking"))
+	asserts.AssertStatusReply(t, reply, "OK")
+	reply = nodeB.Exec(conn, utils.ToCmdLine("set", "{0}a", "a"))
+	asserts.AssertStatusReply(t, reply, "OK")
+	// ASKING only affects the next command
+	reply = nodeB.Exec(conn, utils.ToCmdLine("get", "{0}a"))
+	asserts.AssertErrReply(t, reply, "MOVED 0 a")
+
+	// relay mode
+	nodeA.config.Redirect = false
+	reply = nodeA.Exec(conn, utils.ToCmdLine("get", "1"))
+	asserts.AssertBulkReply(t, reply, "1")
+}
+
+func TestCrossSlot(t *testing.T) {
+	RegisterDefaultCmd("sadd")
+	RegisterDefaultCmd("sinterstore")
+	nodes := MakeTestCluster([]string{"a", "b"})
+	nodeA := nodes["a"]
+	conn := connection.NewFakeConn()
+	// slot 0 and slot 2 are both hosted by node a, see MakeTestCluster
+	reply := nodeA.Exec(conn, utils.ToCmdLine("sinterstore", "0", "2"))
+	asserts.AssertErrReply(t, reply, "CROSSSLOT Keys in request don't hash to the same slot")
+	reply = nodeA.Exec(conn, utils.ToCmdLine("sadd", "{0}a", "x"))
+	asserts.AssertIntReply(t, reply, 1)
+	reply = nodeA.Exec(conn, utils.ToCmdLine("sinterstore", "{0}b", "{0}a"))
+	asserts.AssertIntReply(t, reply, 1)
+
+	// cross slot commands are rejected while queueing
+	conn.SetMultiState(true)
+	reply = nodeA.Exec(conn, utils.ToCmdLine("sinterstore", "{0}b", "{2}a"))
+	asserts.AssertErrReply(t, reply, "CROSSSLOT Keys in request don't hash to the same slot")
+	if len(conn.GetTxErrors()) != 1 {
+		t.Error("expect cross slot command recorded as tx error")
+	}
+}
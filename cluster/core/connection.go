@@ -1,9 +1,11 @@
 package core
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
-	"net"
+	"sync"
+	"time"
 
 	"github.com/hdt3213/godis/config"
 	"github.com/hdt3213/godis/datastruct/dict"
@@ -37,47 +39,116 @@ type peerStream interface {
 
 type defaultClientFactory struct {
 	nodeConnections dict.Dict // map[string]*pool.Pool
+	breakers        dict.Dict // map[string]*peerBreaker
+	closeChan       chan struct{}
 }
 
 var connectionPoolConfig = pool.Config{
-	MaxIdle:   1,
-	MaxActive: 16,
+	MaxIdle:     1,
+	MaxActive:   16,
+	IdleTimeout: 5 * time.Minute,
 }
 
-func NewFactory() ConnectionFactory {
-	return &defaultClientFactory{
-		nodeConnections: dict.MakeSimple(),
+const (
+	peerHealthCheckInterval = 5 * time.Second
+	peerPingTimeout         = time.Second
+	minPeerBackoff          = 100 * time.Millisecond
+	maxPeerBackoff          = 10 * time.Second
+)
+
+// peerBreaker stops connecting an unreachable peer for a while, so that requests to it fail fast.
+// The duration is doubled on each failure until maxPeerBackoff
+type peerBreaker struct {
+	mu       sync.Mutex
+	failures int
+	retryAt  time.Time
+}
+
+// allow returns whether it is time to try connecting the peer
+func (b *peerBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !time.Now().Before(b.retryAt)
+}
+
+func (b *peerBreaker) onSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.retryAt = time.Time{}
+}
+
+func (b *peerBreaker) onFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	backoff := maxPeerBackoff
+	if b.failures < 16 && minPeerBackoff<<b.failures < maxPeerBackoff {
+		backoff = minPeerBackoff << b.failures
 	}
+	b.failures++
+	b.retryAt = time.Now().Add(backoff)
+}
+
+func NewFactory() ConnectionFactory {
+	return newDefaultClientFactory()
 }
 
 // NewPeerClient creats a new client, no need to return this client
+// peerTLSConfig returns tls config to connect other nodes, it returns nil if tls-cluster is disabled
+func peerTLSConfig() (*tls.Config, error) {
+	if !config.Properties.TLSCluster {
+		return nil, nil
+	}
+	return config.Properties.ClientTLSConfig()
+}
+
 func (factory *defaultClientFactory) NewPeerClient(peerAddr string) (peerClient, error) {
-	c, err := client.MakeClient(peerAddr)
+	opts, err := peerOptions()
 	if err != nil {
 		return nil, err
 	}
-	c.Start()
-	// all peers of cluster should use the same password
-	if config.Properties.RequirePass != "" {
-		authResp := c.Send(utils.ToCmdLine("AUTH", config.Properties.RequirePass))
-		if !protocol.IsOKReply(authResp) {
-			return nil, fmt.Errorf("auth failed, resp: %s", string(authResp.ToBytes()))
-		}
+	return client.Dial(peerAddr, opts)
+}
+
+// peerOptions returns options to connect other nodes, all peers of cluster should use the same password
+func peerOptions() (*client.Options, error) {
+	tlsConfig, err := peerTLSConfig()
+	if err != nil {
+		return nil, err
 	}
-	return c, nil
+	return &client.Options{
+		TLSConfig: tlsConfig,
+		Password:  config.Properties.RequirePass,
+	}, nil
+}
+
+func (factory *defaultClientFactory) getBreaker(peerAddr string) *peerBreaker {
+	factory.breakers.PutIfAbsent(peerAddr, &peerBreaker{})
+	raw, _ := factory.breakers.Get(peerAddr)
+	return raw.(*peerBreaker)
 }
 
 // GetPeerClient gets a client with peer form pool
 func (factory *defaultClientFactory) BorrowPeerClient(peerAddr string) (peerClient, error) {
+	breaker := factory.getBreaker(peerAddr)
 	var connectionPool *pool.Pool
 	raw, ok := factory.nodeConnections.Get(peerAddr)
 	if !ok {
 		creator := func() (interface{}, error) {
-			return factory.NewPeerClient(peerAddr)
+			if !breaker.allow() {
+				return nil, errors.New("CLUSTERDOWN peer " + peerAddr + " is unreachable")
+			}
+			cli, err := factory.NewPeerClient(peerAddr)
+			if err != nil {
+				breaker.onFailure()
+				return nil, err
+			}
+			breaker.onSuccess()
+			return cli, nil
 		}
 		finalizer := func(x interface{}) {
 			logger.Debug("destroy client")
-			cli, ok := x.(client.Client)
+			cli, ok := x.(*client.Client)
 			if !ok {
 				return
 			}
@@ -110,66 +181,76 @@ func (factory *defaultClientFactory) ReturnPeerClient(peerClient peerClient) err
 	if !ok {
 		return errors.New("connection pool not found")
 	}
+	if cli, ok := peerClient.(*client.Client); ok && cli.IsClosed() {
+		// client closes itself if reconnecting failed
+		raw.(*pool.Pool).Discard(peerClient)
+		return nil
+	}
 	raw.(*pool.Pool).Put(peerClient)
 	return nil
 }
 
-type tcpStream struct {
-	conn net.Conn
-	ch   <-chan *parser.Payload
-}
-
-func (s *tcpStream) Stream() <-chan *parser.Payload {
-	return s.ch
+// checkHealth pings idle clients, broken ones are evicted and their peers are marked as failed
+func (factory *defaultClientFactory) checkHealth() {
+	factory.nodeConnections.ForEach(func(peerAddr string, val interface{}) bool {
+		breaker := factory.getBreaker(peerAddr)
+		val.(*pool.Pool).CheckIdles(func(x interface{}) bool {
+			cli := x.(*client.Client)
+			reply, err := cli.SendWithTimeout(utils.ToCmdLine("PING"), peerPingTimeout)
+			if err != nil || protocol.IsErrorReply(reply) {
+				logger.Warn(fmt.Sprintf("peer %s is unhealthy: %v", peerAddr, err))
+				breaker.onFailure()
+				return false
+			}
+			return true
+		})
+		return true
+	})
 }
 
-func (s *tcpStream) Close() error {
-	return s.conn.Close()
+func (factory *defaultClientFactory) healthCheckLoop() {
+	ticker := time.NewTicker(peerHealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			factory.checkHealth()
+		case <-factory.closeChan:
+			return
+		}
+	}
 }
 
 func (factory *defaultClientFactory) NewStream(peerAddr string, cmdLine CmdLine) (peerStream, error) {
 	// todo: reuse connection
-	conn, err := net.Dial("tcp", peerAddr)
+	opts, err := peerOptions()
 	if err != nil {
-		return nil, fmt.Errorf("connect with %s failed: %v", peerAddr, err)
-	}
-	ch := parser.ParseStream(conn)
-	send2node := func(cmdLine CmdLine) redis.Reply {
-		req := protocol.MakeMultiBulkReply(cmdLine)
-		_, err := conn.Write(req.ToBytes())
-		if err != nil {
-			return protocol.MakeErrReply(err.Error())
-		}
-		resp := <-ch
-		if resp.Err != nil {
-			return protocol.MakeErrReply(resp.Err.Error())
-		}
-		return resp.Data
+		return nil, err
 	}
-	if config.Properties.RequirePass != "" {
-		authResp := send2node(utils.ToCmdLine("AUTH", config.Properties.RequirePass))
-		if !protocol.IsOKReply(authResp) {
-			return nil, fmt.Errorf("auth failed, resp: %s", string(authResp.ToBytes()))
-		}
+	stream, err := client.DialStream(peerAddr, opts)
+	if err != nil {
+		return nil, fmt.Errorf("connect with %s failed: %v", peerAddr, err)
 	}
-	req := protocol.MakeMultiBulkReply(cmdLine)
-	_, err = conn.Write(req.ToBytes())
+	err = stream.Send(cmdLine)
 	if err != nil {
+		_ = stream.Close()
 		return nil, protocol.MakeErrReply("send cmdLine failed: " + err.Error())
 	}
-	return &tcpStream{
-		conn: conn,
-		ch:   ch,
-	}, nil
+	return stream, nil
 }
 
 func newDefaultClientFactory() *defaultClientFactory {
-	return &defaultClientFactory{
+	factory := &defaultClientFactory{
 		nodeConnections: dict.MakeConcurrent(1),
+		breakers:        dict.MakeConcurrent(1),
+		closeChan:       make(chan struct{}),
 	}
+	go factory.healthCheckLoop()
+	return factory
 }
 
 func (factory *defaultClientFactory) Close() error {
+	close(factory.closeChan)
 	factory.nodeConnections.ForEach(func(key string, val interface{}) bool {
 		val.(*pool.Pool).Close()
 		return true
@@ -0,0 +1,28 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPeerBreaker(t *testing.T) {
+	factory := newDefaultClientFactory()
+	defer factory.Close()
+	// nothing listens on port 1
+	addr := "127.0.0.1:1"
+	_, err := factory.BorrowPeerClient(addr)
+	if err == nil || strings.HasPrefix(err.Error(), "CLUSTERDOWN") {
+		t.Errorf("expect dial error, actual: %v", err)
+		return
+	}
+	_, err = factory.BorrowPeerClient(addr)
+	if err == nil || !strings.HasPrefix(err.Error(), "CLUSTERDOWN") {
+		t.Errorf("expect CLUSTERDOWN error, actual: %v", err)
+	}
+
+	breaker := factory.getBreaker(addr)
+	breaker.onSuccess()
+	if !breaker.allow() {
+		t.Error("breaker should be closed after success")
+	}
+}
@@ -22,13 +22,16 @@ type Cluster struct {
 	rebalanceManger *rebalanceManager
 	transactions    *TransactionManager
 	replicaManager  *replicaManager
+	txLog           *txLog // nil if tcc log is disabled
 
 	closeChan chan struct{}
 
 	// allow inject route implementation
-	getSlotImpl  func(key string) uint32
-	pickNodeImpl func(slotID uint32) string
-	id_          string // for tests only
+	getSlotImpl     func(key string) uint32
+	pickNodeImpl    func(slotID uint32) string
+	getMasterImpl   func(id string) string
+	getTopologyImpl func() []*clusterNode
+	id_             string // for tests only
 }
 
 type Config struct {
@@ -36,6 +39,8 @@ type Config struct {
 	StartAsSeed    bool
 	JoinAddress    string
 	Master         string
+	TxLogPath      string // path of tcc log, transactions coordinated by this node will not be logged if it is empty
+	Redirect       bool   // reply MOVED and ASK instead of relaying commands of slots hosted by other nodes
 	connectionStub ConnectionFactory // for test
 	noCron         bool // for test
 }
@@ -56,6 +61,7 @@ func NewCluster(cfg *Config) (*Cluster, error) {
 		connections = newDefaultClientFactory()
 	}
 	db := dbimpl.NewStandaloneServer()
+	cfg.RaftConfig.SlotCount = SlotCount
 	raftNode, err := raft.StartNode(&cfg.RaftConfig)
 	if err != nil {
 		return nil, err
@@ -78,6 +84,18 @@ func NewCluster(cfg *Config) (*Cluster, error) {
 	cluster.getSlotImpl = func(key string) uint32 {
 		return defaultGetSlotImpl(cluster, key)
 	}
+	cluster.getMasterImpl = func(id string) string {
+		return cluster.raftNode.FSM.GetMaster(id)
+	}
+	cluster.getTopologyImpl = func() []*clusterNode {
+		return defaultGetTopologyImpl(cluster)
+	}
+	if cfg.TxLogPath != "" {
+		cluster.txLog, err = openTxLog(cfg.TxLogPath)
+		if err != nil {
+			return nil, err
+		}
+	}
 	cluster.injectInsertCallback()
 	cluster.injectDeleteCallback()
 	cluster.registerOnFailover()
@@ -119,7 +137,8 @@ func NewCluster(cfg *Config) (*Cluster, error) {
 			}
 		}
 	}
-	
+
+	go cluster.recoverTransactions()
 	go cluster.clusterCron()
 	return cluster, nil
 }
@@ -132,12 +151,31 @@ func (cluster *Cluster) AfterClientClose(c redis.Connection) {
 func (cluster *Cluster) Close() {
 	close(cluster.closeChan)
 	cluster.db.Close()
+	if cluster.txLog != nil {
+		_ = cluster.txLog.close()
+	}
 	err := cluster.raftNode.Close()
 	if err != nil {
 		panic(err)
 	}
 }
 
+// ShutdownChan returns a channel closed after SHUTDOWN succeeded on this node
+func (cluster *Cluster) ShutdownChan() <-chan struct{} {
+	if s, ok := cluster.db.(database.Shutdowner); ok {
+		return s.ShutdownChan()
+	}
+	return nil
+}
+
+// Shutdown saves data of this node if needed, then stops raft and other background jobs like Close
+func (cluster *Cluster) Shutdown() {
+	if s, ok := cluster.db.(database.Shutdowner); ok {
+		s.Shutdown()
+	}
+	cluster.Close()
+}
+
 // LoadRDB real implementation of loading rdb file
 func (cluster *Cluster) LoadRDB(dec *rdbcore.Decoder) error {
 	return cluster.db.LoadRDB(dec)
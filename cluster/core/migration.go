@@ -31,6 +31,9 @@ type slotsManager struct {
 	mu            *sync.RWMutex
 	slots         map[uint32]*slotStatus // 记录当前node上的 slot
 	importingTask *raft.MigratingTask
+	// migrating and importing are marked by CLUSTER SETSLOT during manual resharding, slot -> peer id
+	migrating map[uint32]string
+	importing map[uint32]string
 }
 
 const (
@@ -50,8 +53,10 @@ type slotStatus struct {
 
 func newSlotsManager() *slotsManager {
 	return &slotsManager{
-		mu:    &sync.RWMutex{},
-		slots: map[uint32]*slotStatus{},
+		mu:        &sync.RWMutex{},
+		slots:     map[uint32]*slotStatus{},
+		migrating: map[uint32]string{},
+		importing: map[uint32]string{},
 	}
 }
 
@@ -96,6 +101,51 @@ func (sm *slotStatus) finishExportingWithinLock() {
 	sm.exportSnapshot = nil
 }
 
+// getAskTarget returns the importing node if the slot is being exported and key is absent in current node,
+// returns empty string if the command should be executed locally.
+// Slots exported by rebalance are redirected only if cluster-redirect is enabled,
+// because they are served by source node until the migration finished.
+func (cluster *Cluster) getAskTarget(c redis.Connection, index uint32, key string) string {
+	cluster.slotsManager.mu.RLock()
+	target := cluster.slotsManager.migrating[index]
+	slot := cluster.slotsManager.slots[index]
+	task := cluster.slotsManager.importingTask
+	cluster.slotsManager.mu.RUnlock()
+	if target == "" && cluster.config.Redirect && slot != nil && task != nil && task.SrcNode == cluster.SelfID() {
+		slot.mu.RLock()
+		if slot.state == slotStateExporting {
+			target = task.TargetNode
+		}
+		slot.mu.RUnlock()
+	}
+	if target == "" {
+		return ""
+	}
+	if _, ok := cluster.db.GetEntity(c.GetDBIndex(), key); ok {
+		return ""
+	}
+	return target
+}
+
+// isImportingSlot returns whether the slot is being imported into current node
+func (cluster *Cluster) isImportingSlot(index uint32) bool {
+	cluster.slotsManager.mu.RLock()
+	defer cluster.slotsManager.mu.RUnlock()
+	if _, ok := cluster.slotsManager.importing[index]; ok {
+		return true
+	}
+	task := cluster.slotsManager.importingTask
+	if task == nil || task.TargetNode != cluster.SelfID() {
+		return false
+	}
+	for _, slotId := range task.Slots {
+		if slotId == index {
+			return true
+		}
+	}
+	return false
+}
+
 func (cluster *Cluster) dropSlot(index uint32) {
 	cluster.slotsManager.mu.RLock()
 	slot := cluster.slotsManager.slots[index]
@@ -117,6 +167,10 @@ func (cluster *Cluster) dropSlot(index uint32) {
 
 func (cluster *Cluster) injectInsertCallback() {
 	cb := func(dbIndex int, key string, entity *database.DataEntity) {
+		if dbIndex != 0 {
+			// only db 0 is indexed and migrated, same as redis cluster
+			return
+		}
 		slotIndex := cluster.GetSlot(key)
 		slotManager := cluster.slotsManager.getSlot(slotIndex)
 		slotManager.mu.Lock()
@@ -131,6 +185,9 @@ func (cluster *Cluster) injectInsertCallback() {
 
 func (cluster *Cluster) injectDeleteCallback() {
 	cb := func(dbIndex int, key string, entity *database.DataEntity) {
+		if dbIndex != 0 {
+			return
+		}
 		slotIndex := cluster.GetSlot(key)
 		slotManager := cluster.slotsManager.getSlot(slotIndex)
 		slotManager.mu.Lock()
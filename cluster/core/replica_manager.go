@@ -25,6 +25,41 @@ const heartbeatCommand = "cluster.heartbeat"
 
 func init() {
 	RegisterCmd(heartbeatCommand, execHeartbeat)
+	RegisterCmd("readonly", execReadOnly)
+	RegisterCmd("readwrite", execReadWrite)
+	RegisterCmd("asking", execAsking)
+}
+
+// execReadOnly allows the client to read keys of its master from this replica, the data may be stale
+func execReadOnly(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if len(cmdLine) != 1 {
+		return protocol.MakeArgNumErrReply("readonly")
+	}
+	c.SetReadOnly(true)
+	return protocol.MakeOkReply()
+}
+
+// execReadWrite cancels READONLY, reads will be redirected to master again
+func execReadWrite(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if len(cmdLine) != 1 {
+		return protocol.MakeArgNumErrReply("readwrite")
+	}
+	c.SetReadOnly(false)
+	return protocol.MakeOkReply()
+}
+
+// execAsking allows the next command to access the slot being imported into this node, see ASK redirection
+func execAsking(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if len(cmdLine) != 1 {
+		return protocol.MakeArgNumErrReply("asking")
+	}
+	c.SetAsking(true)
+	return protocol.MakeOkReply()
+}
+
+// isReplicaOf returns whether current node is a replica of the given node
+func (cluster *Cluster) isReplicaOf(masterId string) bool {
+	return cluster.getMasterImpl(cluster.SelfID()) == masterId
 }
 
 const (
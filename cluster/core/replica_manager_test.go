@@ -0,0 +1,50 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestReadOnly(t *testing.T) {
+	RegisterDefaultCmd("get")
+	RegisterDefaultCmd("set")
+	nodes := MakeTestCluster([]string{"a", "b"})
+	master, replica := nodes["a"], nodes["b"]
+	replica.getMasterImpl = func(id string) string {
+		if id == "b" {
+			return "a"
+		}
+		return ""
+	}
+	conn := connection.NewFakeConn()
+	// key "0" is routed to node a, see MakeTestCluster
+	master.db.Exec(conn, utils.ToCmdLine("set", "0", "master"))
+	replica.db.Exec(conn, utils.ToCmdLine("set", "0", "replica"))
+
+	reply := replica.Exec(conn, utils.ToCmdLine("get", "0"))
+	asserts.AssertBulkReply(t, reply, "master")
+
+	reply = replica.Exec(conn, utils.ToCmdLine("readonly"))
+	asserts.AssertStatusReply(t, reply, "OK")
+	reply = replica.Exec(conn, utils.ToCmdLine("get", "0"))
+	asserts.AssertBulkReply(t, reply, "replica")
+	// writes are always sent to master
+	reply = replica.Exec(conn, utils.ToCmdLine("set", "0", "new"))
+	asserts.AssertStatusReply(t, reply, "OK")
+	reply = master.db.Exec(conn, utils.ToCmdLine("get", "0"))
+	asserts.AssertBulkReply(t, reply, "new")
+	// master is not a replica of anyone
+	reply = master.Exec(conn, utils.ToCmdLine("readonly"))
+	asserts.AssertStatusReply(t, reply, "OK")
+	replica.db.Exec(conn, utils.ToCmdLine("set", "1", "replica"))
+	reply = master.Exec(conn, utils.ToCmdLine("get", "1"))
+	asserts.AssertBulkReply(t, reply, "replica")
+
+	reply = replica.Exec(conn, utils.ToCmdLine("readwrite"))
+	asserts.AssertStatusReply(t, reply, "OK")
+	reply = replica.Exec(conn, utils.ToCmdLine("get", "0"))
+	asserts.AssertBulkReply(t, reply, "new")
+}
@@ -0,0 +1,283 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/hdt3213/godis/cluster/raft"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/logger"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+/*
+**Manual Resharding Procedure** (same as redis-cli --cluster reshard)
+1. CLUSTER SETSLOT <slot> IMPORTING <source> on target node, it accepts commands with ASKING of the slot
+2. CLUSTER SETSLOT <slot> MIGRATING <target> on source node, it replies ASK (or relays to target) for absent keys
+3. CLUSTER GETKEYSINSLOT on source node, and MIGRATE the keys to target node in batches.
+   MIGRATE sends RESTORE-ASKING in cluster mode, so that target node accepts them.
+4. CLUSTER SETSLOT <slot> NODE <target> changes route through raft leader, and clears marks of the slot
+*/
+
+const (
+	setSlotNodeCommand = "cluster.setslot.node"
+	// askingRelayCommand relays a command to the importing node with ASKING flag, used if cluster-redirect is disabled
+	askingRelayCommand = "asking_"
+	rebalanceCommand   = "cluster.rebalance"
+)
+
+func init() {
+	RegisterCmd(setSlotNodeCommand, execSetSlotNode)
+	RegisterCmd(askingRelayCommand, execAskingRelay)
+	RegisterCmd(rebalanceCommand, execRebalance)
+	RegisterCmd("restore-asking", execRestoreAsking)
+	RegisterCmd("migrate", execMigrate)
+}
+
+// execAskingRelay executes the relayed command as if ASKING was sent before it
+// format: asking_ cmdName key args...
+func execAskingRelay(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if len(cmdLine) < 3 {
+		return protocol.MakeArgNumErrReply(askingRelayCommand)
+	}
+	slot := cluster.GetSlot(string(cmdLine[2]))
+	if cluster.PickNode(slot) != cluster.SelfID() && !cluster.isImportingSlot(slot) {
+		// avoid relaying back to source node
+		return protocol.MakeErrReply("ERR slot " + strconv.Itoa(int(slot)) + " is not importing")
+	}
+	c.SetAsking(true)
+	return DefaultFunc(cluster, c, cmdLine[1:])
+}
+
+// execRestoreAsking restores keys migrating to current node
+func execRestoreAsking(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	c.SetAsking(true)
+	return DefaultFunc(cluster, c, cmdLine)
+}
+
+// execMigrate moves keys in current node to another node, its first argument is host instead of key
+func execMigrate(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	return cluster.LocalExec(c, cmdLine)
+}
+
+// findNode finds node by redis advertise address or id shown in CLUSTER NODES
+func findNode(nodes []*clusterNode, id string) *clusterNode {
+	for _, node := range nodes {
+		if node.ID == id || makeNodeID(node.ID) == id {
+			return node
+		}
+	}
+	return nil
+}
+
+// countKeysInSlot returns count of keys in slot stored in current node
+func (cluster *Cluster) countKeysInSlot(index uint32) int {
+	cluster.slotsManager.mu.RLock()
+	slot := cluster.slotsManager.slots[index]
+	cluster.slotsManager.mu.RUnlock()
+	if slot == nil {
+		return 0
+	}
+	slot.mu.RLock()
+	defer slot.mu.RUnlock()
+	return slot.keys.Len()
+}
+
+// getKeysInSlot returns at most count keys in slot stored in current node
+func (cluster *Cluster) getKeysInSlot(index uint32, count int) []string {
+	cluster.slotsManager.mu.RLock()
+	slot := cluster.slotsManager.slots[index]
+	cluster.slotsManager.mu.RUnlock()
+	if slot == nil {
+		return nil
+	}
+	var keys []string
+	slot.mu.RLock()
+	defer slot.mu.RUnlock()
+	slot.keys.ForEach(func(key string) bool {
+		if len(keys) >= count {
+			return false
+		}
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+func parseSlot(arg []byte) (uint32, bool) {
+	slot, err := strconv.Atoi(string(arg))
+	if err != nil || slot < 0 || slot >= SlotCount {
+		return 0, false
+	}
+	return uint32(slot), true
+}
+
+// execCountKeysInSlot handles CLUSTER COUNTKEYSINSLOT slot
+func (cluster *Cluster) execCountKeysInSlot(args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return protocol.MakeArgNumErrReply("cluster|countkeysinslot")
+	}
+	slot, ok := parseSlot(args[0])
+	if !ok {
+		return protocol.MakeErrReply("ERR Invalid slot")
+	}
+	return protocol.MakeIntReply(int64(cluster.countKeysInSlot(slot)))
+}
+
+// execGetKeysInSlot handles CLUSTER GETKEYSINSLOT slot count
+func (cluster *Cluster) execGetKeysInSlot(args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return protocol.MakeArgNumErrReply("cluster|getkeysinslot")
+	}
+	slot, ok := parseSlot(args[0])
+	count, err := strconv.Atoi(string(args[1]))
+	if !ok || err != nil || count < 0 {
+		return protocol.MakeErrReply("ERR Invalid slot or number of keys")
+	}
+	keys := cluster.getKeysInSlot(slot, count)
+	return protocol.MakeMultiBulkReply(utils.ToCmdLine(keys...))
+}
+
+// execSetSlot handles CLUSTER SETSLOT slot IMPORTING|MIGRATING|STABLE|NODE [node-id]
+func (cluster *Cluster) execSetSlot(args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return protocol.MakeArgNumErrReply("cluster|setslot")
+	}
+	slot, ok := parseSlot(args[0])
+	if !ok {
+		return protocol.MakeErrReply("ERR Invalid or out of range slot")
+	}
+	action := strings.ToLower(string(args[1]))
+	if action == "stable" && len(args) == 2 {
+		cluster.clearSlotMarks(slot)
+		return protocol.MakeOkReply()
+	}
+	if len(args) != 3 || (action != "importing" && action != "migrating" && action != "node") {
+		return protocol.MakeErrReply("ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")
+	}
+	nodeId := string(args[2])
+	node := findNode(cluster.getTopology(), nodeId)
+	if node == nil {
+		return protocol.MakeErrReply("ERR I don't know about node " + nodeId)
+	}
+	if node.Master != "" {
+		return protocol.MakeErrReply("ERR Target node is not a master")
+	}
+	owner := cluster.PickNode(slot)
+	switch action {
+	case "migrating":
+		if owner != cluster.SelfID() {
+			return protocol.MakeErrReply("ERR I'm not the owner of hash slot " + strconv.Itoa(int(slot)))
+		}
+		if node.ID == cluster.SelfID() {
+			return protocol.MakeErrReply("ERR I'm already the owner of hash slot " + strconv.Itoa(int(slot)))
+		}
+		cluster.slotsManager.mu.Lock()
+		cluster.slotsManager.migrating[slot] = node.ID
+		cluster.slotsManager.mu.Unlock()
+	case "importing":
+		if owner == cluster.SelfID() {
+			return protocol.MakeErrReply("ERR I'm already the owner of hash slot " + strconv.Itoa(int(slot)))
+		}
+		cluster.slotsManager.mu.Lock()
+		cluster.slotsManager.importing[slot] = node.ID
+		cluster.slotsManager.mu.Unlock()
+	case "node":
+		if owner == cluster.SelfID() && node.ID != owner && cluster.countKeysInSlot(slot) > 0 {
+			return protocol.MakeErrReply("ERR Can't assign hashslot " + strconv.Itoa(int(slot)) +
+				" to a different node while I still hold keys for this hash slot.")
+		}
+		if owner != node.ID {
+			leaderConn, err := cluster.BorrowLeaderClient()
+			if err != nil {
+				return protocol.MakeErrReply(err.Error())
+			}
+			defer cluster.connections.ReturnPeerClient(leaderConn)
+			reply := leaderConn.Send(utils.ToCmdLine(setSlotNodeCommand, strconv.Itoa(int(slot)), node.ID))
+			if protocol.IsErrorReply(reply) {
+				return reply
+			}
+		}
+		cluster.clearSlotMarks(slot)
+	}
+	return protocol.MakeOkReply()
+}
+
+func (cluster *Cluster) clearSlotMarks(slot uint32) {
+	cluster.slotsManager.mu.Lock()
+	delete(cluster.slotsManager.migrating, slot)
+	delete(cluster.slotsManager.importing, slot)
+	cluster.slotsManager.mu.Unlock()
+}
+
+// execSetSlotNode should be executed at leader, it assigns a slot to the given node through raft
+// and returns until the 2 related nodes committed the change
+// format: cluster.setslot.node slot nodeId
+func execSetSlotNode(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if len(cmdLine) != 3 {
+		return protocol.MakeArgNumErrReply(setSlotNodeCommand)
+	}
+	if cluster.raftNode.State() != raft.Leader {
+		// I am not leader, forward request to leader
+		leaderConn, err := cluster.BorrowLeaderClient()
+		if err != nil {
+			return protocol.MakeErrReply(err.Error())
+		}
+		defer cluster.connections.ReturnPeerClient(leaderConn)
+		return leaderConn.Send(cmdLine)
+	}
+	slot, ok := parseSlot(cmdLine[1])
+	if !ok {
+		return protocol.MakeErrReply("ERR Invalid or out of range slot")
+	}
+	// validate target at leader, a slot committed to an unknown node or a slave would be unreachable
+	node := findNode(cluster.getTopology(), string(cmdLine[2]))
+	if node == nil {
+		return protocol.MakeErrReply("ERR I don't know about node " + string(cmdLine[2]))
+	}
+	if node.Master != "" {
+		return protocol.MakeErrReply("ERR Target node is not a master")
+	}
+	target := node.ID
+	owner := cluster.PickNode(slot)
+	if owner == target {
+		return protocol.MakeOkReply()
+	}
+	task := &raft.MigratingTask{
+		ID:         utils.RandString(20),
+		SrcNode:    owner,
+		TargetNode: target,
+		Slots:      []uint32{slot},
+	}
+	logIndex, err := cluster.raftNode.Propose(&raft.LogEntry{
+		Event:         raft.EventFinishMigrate,
+		MigratingTask: task,
+	})
+	if err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
+	logger.Infof("assign slot %d from %s to %s, raft proposed", slot, owner, target)
+	for _, peer := range []string{owner, target} {
+		if err := cluster.waitCommitted(peer, logIndex); err != nil {
+			return protocol.MakeErrReply("ERR " + err.Error())
+		}
+	}
+	return protocol.MakeOkReply()
+}
+
+// execRebalance moves slots among masters until each of them hosts nearly the same count of slots.
+// Rebalance is also triggered by leader periodically, this command makes it start immediately.
+// Migrating tasks run in background after it returns.
+func execRebalance(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if cluster.raftNode.State() != raft.Leader {
+		leaderConn, err := cluster.BorrowLeaderClient()
+		if err != nil {
+			return protocol.MakeErrReply(err.Error())
+		}
+		defer cluster.connections.ReturnPeerClient(leaderConn)
+		return leaderConn.Send(utils.ToCmdLine(rebalanceCommand))
+	}
+	cluster.doRebalance()
+	return protocol.MakeOkReply()
+}
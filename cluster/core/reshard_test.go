@@ -0,0 +1,144 @@
+package core
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hdt3213/godis/cluster/raft"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestManualReshard(t *testing.T) {
+	RegisterDefaultCmd("get")
+	RegisterDefaultCmd("set")
+	RegisterDefaultCmd("del")
+	RegisterDefaultCmd("dump")
+	nodes := MakeTestCluster([]string{"a", "b"})
+	nodeA, nodeB := nodes["a"], nodes["b"]
+	nodeA.config.Redirect = true
+	nodeB.config.Redirect = true
+	conn := connection.NewFakeConn()
+	// keys with hashtag {0} are routed to slot 0 of node a, see MakeTestCluster
+	for _, key := range []string{"{0}a", "{0}b", "{0}c"} {
+		reply := nodeA.Exec(conn, utils.ToCmdLine("set", key, key))
+		asserts.AssertStatusReply(t, reply, "OK")
+	}
+	reply := nodeA.Exec(conn, utils.ToCmdLine("cluster", "countkeysinslot", "0"))
+	asserts.AssertIntReply(t, reply, 3)
+	reply = nodeA.Exec(conn, utils.ToCmdLine("cluster", "getkeysinslot", "0", "2"))
+	asserts.AssertMultiBulkReplySize(t, reply, 2)
+	reply = nodeA.Exec(conn, utils.ToCmdLine("cluster", "getkeysinslot", "0", "x"))
+	asserts.AssertErrReply(t, reply, "ERR Invalid slot or number of keys")
+
+	reply = nodeA.Exec(conn, utils.ToCmdLine("cluster", "setslot", "0", "importing", "b"))
+	asserts.AssertErrReply(t, reply, "ERR I'm already the owner of hash slot 0")
+	reply = nodeA.Exec(conn, utils.ToCmdLine("cluster", "setslot", "0", "migrating", "c"))
+	asserts.AssertErrReply(t, reply, "ERR I don't know about node c")
+	reply = nodeA.Exec(conn, utils.ToCmdLine("cluster", "setslot", "0", "nosuch", "b"))
+	asserts.AssertErrReply(t, reply, "ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")
+	reply = nodeB.Exec(conn, utils.ToCmdLine("cluster", "setslot", "0", "importing", makeNodeID("a")))
+	asserts.AssertStatusReply(t, reply, "OK")
+	reply = nodeA.Exec(conn, utils.ToCmdLine("cluster", "setslot", "0", "migrating", "b"))
+	asserts.AssertStatusReply(t, reply, "OK")
+
+	// move {0}a to node b
+	reply = nodeA.Exec(conn, utils.ToCmdLine("dump", "{0}a"))
+	payload := reply.(*protocol.BulkReply).Arg
+	reply = nodeB.Exec(conn, utils.ToCmdLine("restore-asking", "{0}a", "0", string(payload)))
+	asserts.AssertStatusReply(t, reply, "OK")
+	reply = nodeA.Exec(conn, utils.ToCmdLine("del", "{0}a"))
+	asserts.AssertIntReply(t, reply, 1)
+
+	// existing keys are served by source node, absent keys are redirected to target node
+	reply = nodeA.Exec(conn, utils.ToCmdLine("get", "{0}b"))
+	asserts.AssertBulkReply(t, reply, "{0}b")
+	reply = nodeA.Exec(conn, utils.ToCmdLine("get", "{0}a"))
+	asserts.AssertErrReply(t, reply, "ASK 0 b")
+	reply = nodeB.Exec(conn, utils.ToCmdLine("get", "{0}a"))
+	asserts.AssertErrReply(t, reply, "MOVED 0 a")
+	nodeB.Exec(conn, utils.ToCmdLine("asking"))
+	reply = nodeB.Exec(conn, utils.ToCmdLine("get", "{0}a"))
+	asserts.AssertBulkReply(t, reply, "{0}a")
+
+	// relay mode
+	nodeA.config.Redirect = false
+	reply = nodeA.Exec(conn, utils.ToCmdLine("get", "{0}a"))
+	asserts.AssertBulkReply(t, reply, "{0}a")
+
+	reply = nodeA.Exec(conn, utils.ToCmdLine("cluster", "setslot", "0", "stable"))
+	asserts.AssertStatusReply(t, reply, "OK")
+	reply = nodeA.Exec(conn, utils.ToCmdLine("get", "{0}a"))
+	asserts.AssertNullBulk(t, reply)
+	reply = nodeA.Exec(conn, utils.ToCmdLine("cluster", "setslot", "0", "node", "b"))
+	asserts.AssertErrReply(t, reply, "ERR Can't assign hashslot 0 to a different node while I still hold keys for this hash slot.")
+}
+
+func TestSlotKeyIndex(t *testing.T) {
+	RegisterDefaultCmd("set")
+	RegisterDefaultCmd("del")
+	nodes := MakeTestCluster([]string{"a"})
+	cluster := nodes["a"]
+	conn := connection.NewFakeConn()
+	for _, key := range []string{"{1}a", "{1}b", "{2}a"} {
+		cluster.Exec(conn, utils.ToCmdLine("set", key, key))
+	}
+	reply := cluster.Exec(conn, utils.ToCmdLine("cluster", "countkeysinslot", "1"))
+	asserts.AssertIntReply(t, reply, 2)
+	reply = cluster.Exec(conn, utils.ToCmdLine("cluster", "getkeysinslot", "2", "10"))
+	asserts.AssertMultiBulkReply(t, reply, []string{"{2}a"})
+	cluster.Exec(conn, utils.ToCmdLine("del", "{1}a"))
+	reply = cluster.Exec(conn, utils.ToCmdLine("cluster", "countkeysinslot", "1"))
+	asserts.AssertIntReply(t, reply, 1)
+
+	// keys in other db are not indexed
+	conn2 := connection.NewFakeConn()
+	conn2.SelectDB(1)
+	cluster.LocalExec(conn2, utils.ToCmdLine("set", "{1}c", "c"))
+	cluster.LocalExec(conn2, utils.ToCmdLine("del", "{1}b"))
+	reply = cluster.Exec(conn, utils.ToCmdLine("cluster", "countkeysinslot", "1"))
+	asserts.AssertIntReply(t, reply, 1)
+
+	// index is kept after db replaced
+	cluster.LocalExec(conn, utils.ToCmdLine("flushdb"))
+	reply = cluster.Exec(conn, utils.ToCmdLine("cluster", "countkeysinslot", "2"))
+	asserts.AssertIntReply(t, reply, 0)
+	cluster.Exec(conn, utils.ToCmdLine("set", "{2}b", "b"))
+	reply = cluster.Exec(conn, utils.ToCmdLine("cluster", "getkeysinslot", "2", "10"))
+	asserts.AssertMultiBulkReply(t, reply, []string{"{2}b"})
+}
+
+func TestSetSlotNodeValidatesTarget(t *testing.T) {
+	dir := "test/setslot"
+	os.RemoveAll(dir)
+	os.MkdirAll(dir, 0777)
+	defer func() {
+		os.RemoveAll(dir)
+	}()
+	connections := NewInMemConnectionFactory()
+	cfg := &Config{
+		RaftConfig: raft.RaftConfig{
+			RedisAdvertiseAddr: "127.0.0.1:6599",
+			RaftListenAddr:     "127.0.0.1:36666",
+			RaftAdvertiseAddr:  "127.0.0.1:36666",
+			Dir:                dir,
+		},
+		StartAsSeed:    true,
+		connectionStub: connections,
+		noCron:         true,
+	}
+	leader, err := NewCluster(cfg)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	connections.nodes[cfg.RedisAdvertiseAddr] = leader
+
+	conn := connection.NewFakeConn()
+	reply := leader.Exec(conn, utils.ToCmdLine(setSlotNodeCommand, "0", "127.0.0.1:6600"))
+	asserts.AssertErrReply(t, reply, "ERR I don't know about node 127.0.0.1:6600")
+	reply = leader.Exec(conn, utils.ToCmdLine(setSlotNodeCommand, "0", makeNodeID(cfg.RedisAdvertiseAddr)))
+	asserts.AssertStatusReply(t, reply, "OK")
+}
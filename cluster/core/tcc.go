@@ -1,6 +1,8 @@
 package core
 
 import (
+	"errors"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -8,6 +10,7 @@ import (
 	"github.com/hdt3213/godis/database"
 	"github.com/hdt3213/godis/interface/redis"
 	"github.com/hdt3213/godis/lib/timewheel"
+	"github.com/hdt3213/godis/lib/utils"
 	"github.com/hdt3213/godis/redis/protocol"
 )
 
@@ -21,12 +24,18 @@ type TransactionManager struct {
 
 type TCC struct {
 	realCmdLine CmdLine
-	undoLogs    []CmdLine
+	cmdLines    []CmdLine   // queued commands of MULTI, realCmdLine is not used if it is set
+	undoLogs    [][]CmdLine // undo logs of each command
 	writeKeys   []string
 	readKeys    []string
 	hasLock     bool
+	committed   bool
+	result      redis.Reply // result of commit, returned again if the coordinator retries committing
 }
 
+// ErrWatchChanged is returned by preparemulti if any watched key has been modified
+const ErrWatchChanged = "WATCHCHANGED watched keys have been modified"
+
 func newTransactionManager() *TransactionManager {
 	return &TransactionManager{
 		txs: make(map[string]*TCC),
@@ -35,9 +44,21 @@ func newTransactionManager() *TransactionManager {
 
 func init() {
 	RegisterCmd("prepare", execPrepare)
+	RegisterCmd("preparemulti", execPrepareMulti)
 	RegisterCmd("commit", execCommit)
 	RegisterCmd("rollback", execRollback)
+}
 
+// createTransaction registers a new transaction, returns false if txId existed
+func (cluster *Cluster) createTransaction(txId string) (*TCC, bool) {
+	cluster.transactions.mu.Lock()
+	defer cluster.transactions.mu.Unlock()
+	if cluster.transactions.txs[txId] != nil {
+		return nil, false
+	}
+	tx := &TCC{}
+	cluster.transactions.txs[txId] = tx
+	return tx, true
 }
 
 // execPrepare executes prepare command
@@ -51,20 +72,15 @@ func execPrepare(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Re
 	realCmdLine := cmdLine[2:]
 
 	// create transaction
-	cluster.transactions.mu.Lock()
-	tx := cluster.transactions.txs[txId]
-	if tx != nil {
-		cluster.transactions.mu.Unlock()
+	tx, ok := cluster.createTransaction(txId)
+	if !ok {
 		return protocol.MakeErrReply("transaction existed")
 	}
-	tx = &TCC{}
-	cluster.transactions.txs[txId] = tx
-	cluster.transactions.mu.Unlock()
 
 	// prepare lock and undo locks
 	tx.writeKeys, tx.readKeys = database.GetRelatedKeys(realCmdLine)
 	cluster.db.RWLocks(0, tx.writeKeys, tx.readKeys)
-	tx.undoLogs = cluster.db.GetUndoLogs(0, realCmdLine)
+	tx.undoLogs = [][]CmdLine{cluster.db.GetUndoLogs(0, realCmdLine)}
 	tx.realCmdLine = realCmdLine
 	tx.hasLock = true
 
@@ -84,6 +100,65 @@ func execPrepare(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Re
 	return result
 }
 
+// execPrepareMulti prepares commands of a MULTI transaction which should be executed on this node
+// commandline: preparemulti txid encodedTx..., see EncodeMultiTx
+// it locks related keys and watched keys, the transaction fails with ErrWatchChanged if any watched key has been modified
+func execPrepareMulti(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if len(cmdLine) < 4 {
+		return protocol.MakeArgNumErrReply("preparemulti")
+	}
+	txId := string(cmdLine[1])
+	watching, cmdLines, err := decodeMultiTx(cmdLine[2:])
+	if err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
+
+	tx, ok := cluster.createTransaction(txId)
+	if !ok {
+		return protocol.MakeErrReply("transaction existed")
+	}
+	for _, line := range cmdLines {
+		write, read := database.GetRelatedKeys(line)
+		tx.writeKeys = append(tx.writeKeys, write...)
+		tx.readKeys = append(tx.readKeys, read...)
+	}
+	for key := range watching {
+		tx.readKeys = append(tx.readKeys, key)
+	}
+	cluster.db.RWLocks(0, tx.writeKeys, tx.readKeys)
+	tx.hasLock = true
+
+	for key, ver := range watching {
+		reply := cluster.db.ExecWithLock(c, utils.ToCmdLine("getver", key))
+		current, ok := reply.(*protocol.IntReply)
+		if !ok || uint32(current.Code) != ver {
+			cluster.db.RWUnLocks(0, tx.writeKeys, tx.readKeys)
+			tx.hasLock = false
+			return protocol.MakeErrReply(ErrWatchChanged)
+		}
+	}
+	// all undo logs are generated before executing, so that rolling back in reverse order restores the origin
+	for _, line := range cmdLines {
+		tx.undoLogs = append(tx.undoLogs, cluster.db.GetUndoLogs(0, line))
+	}
+	tx.cmdLines = cmdLines
+	return protocol.MakeOkReply()
+}
+
+// execTxCmdLines executes commands of MULTI transaction within lock, returns the first error if any command failed.
+// Otherwise, results are returned as serialized replies in a multi bulk reply, since peers can only parse flat arrays
+func execTxCmdLines(cluster *Cluster, c redis.Connection, cmdLines []CmdLine) redis.Reply {
+	results := make([][]byte, 0, len(cmdLines))
+	for _, line := range cmdLines {
+		reply := cluster.db.ExecWithLock(c, line)
+		if protocol.IsErrorReply(reply) {
+			return reply
+		}
+		results = append(results, reply.ToBytes())
+	}
+	return protocol.MakeMultiBulkReply(results)
+}
+
 func execCommit(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
 	if len(cmdLine) != 2 {
 		return protocol.MakeArgNumErrReply("commit")
@@ -94,10 +169,18 @@ func execCommit(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Rep
 	tx := cluster.transactions.txs[txId]
 	cluster.transactions.mu.Unlock()
 	if tx == nil {
-		return protocol.MakeErrReply("transaction not found")
+		return protocol.MakeErrReply(errTxNotFound)
+	}
+	if tx.committed {
+		return tx.result
 	}
 
-	resp := cluster.db.ExecWithLock(c, tx.realCmdLine)
+	var resp redis.Reply
+	if tx.cmdLines != nil {
+		resp = execTxCmdLines(cluster, c, tx.cmdLines)
+	} else {
+		resp = cluster.db.ExecWithLock(c, tx.realCmdLine)
+	}
 
 	// unlock regardless of result
 	cluster.db.RWUnLocks(0, tx.writeKeys, tx.readKeys)
@@ -108,6 +191,9 @@ func execCommit(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Rep
 		return resp
 	}
 
+	tx.committed = true
+	tx.result = resp
+
 	// delete transaction after deadline
 	timewheel.At(time.Now().Add(transactionTTL), txId, func() {
 		cluster.transactions.mu.Lock()
@@ -128,7 +214,7 @@ func execRollback(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.R
 	tx := cluster.transactions.txs[txId]
 	cluster.transactions.mu.Unlock()
 	if tx == nil {
-		return protocol.MakeErrReply("transaction not found")
+		return protocol.MakeErrReply(errTxNotFound)
 	}
 
 	// rollback
@@ -136,9 +222,11 @@ func execRollback(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.R
 		cluster.db.RWLocks(0, tx.writeKeys, tx.readKeys)
 		tx.hasLock = true
 	}
+	// undo commands in reverse order, while undo logs of the same command are executed in order
 	for i := len(tx.undoLogs) - 1; i >= 0; i-- {
-		cmdline := tx.undoLogs[i]
-		cluster.db.ExecWithLock(c, cmdline)
+		for _, cmdline := range tx.undoLogs[i] {
+			cluster.db.ExecWithLock(c, cmdline)
+		}
 	}
 	cluster.db.RWUnLocks(0, tx.writeKeys, tx.readKeys)
 
@@ -159,3 +247,42 @@ func RegisterPrepareFunc(name string, fn CmdFunc) {
 	name = strings.ToLower(name)
 	prepareFuncs[name] = fn
 }
+
+// EncodeMultiTx encodes watched keys and queued commands of MULTI into arguments of preparemulti:
+// numWatching [key version]... [argc arg...]...
+func EncodeMultiTx(watching map[string]uint32, cmdLines []CmdLine) CmdLine {
+	result := utils.ToCmdLine(strconv.Itoa(len(watching)))
+	for key, ver := range watching {
+		result = append(result, []byte(key), []byte(strconv.FormatUint(uint64(ver), 10)))
+	}
+	for _, line := range cmdLines {
+		result = append(result, []byte(strconv.Itoa(len(line))))
+		result = append(result, line...)
+	}
+	return result
+}
+
+func decodeMultiTx(args CmdLine) (map[string]uint32, []CmdLine, error) {
+	numWatching, err := strconv.Atoi(string(args[0]))
+	if err != nil || numWatching < 0 || 1+numWatching*2 > len(args) {
+		return nil, nil, errors.New("illegal watching keys")
+	}
+	watching := make(map[string]uint32, numWatching)
+	for i := 0; i < numWatching; i++ {
+		ver, err := strconv.ParseUint(string(args[2+i*2]), 10, 32)
+		if err != nil {
+			return nil, nil, errors.New("illegal version")
+		}
+		watching[string(args[1+i*2])] = uint32(ver)
+	}
+	cmdLines := make([]CmdLine, 0) // not nil even if only watching keys, see execCommit
+	for i := 1 + numWatching*2; i < len(args); {
+		argc, err := strconv.Atoi(string(args[i]))
+		if err != nil || argc <= 0 || i+argc >= len(args) {
+			return nil, nil, errors.New("illegal command line")
+		}
+		cmdLines = append(cmdLines, args[i+1:i+1+argc])
+		i += argc + 1
+	}
+	return watching, cmdLines, nil
+}
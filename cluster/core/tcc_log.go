@@ -0,0 +1,277 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/lib/logger"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/parser"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+/*
+ * txLog is a write-ahead log of cross-node transactions coordinated by this node.
+ * Each record is a command line in RESP format:
+ *   begin txId node...   written before preparing, lists participants of the transaction
+ *   commit txId          written before the commit phase, the transaction must be committed since then
+ *   rollback txId        written before rolling back
+ *   end txId             written after all participants committed or rolled back
+ * If the coordinator crashes, participants keep related keys locked. After restart, transactions without `end`
+ * are committed again if `commit` has been recorded, otherwise they are rolled back.
+ */
+type txLog struct {
+	mu       sync.Mutex
+	file     *os.File
+	filename string
+	size     int64
+	pending  map[string]*pendingTx
+}
+
+type pendingTx struct {
+	nodes     []string
+	committed bool
+}
+
+const (
+	txPhaseBegin    = "begin"
+	txPhaseCommit   = "commit"
+	txPhaseRollback = "rollback"
+	txPhaseEnd      = "end"
+
+	// the log will be truncated once it exceeds txLogCompactSize while no transaction is pending
+	txLogCompactSize = 1 << 20
+
+	errTxNotFound = "transaction not found"
+)
+
+// openTxLog loads pending transactions from file, and rewrites the file with them only
+func openTxLog(filename string) (*txLog, error) {
+	log := &txLog{
+		filename: filename,
+		pending:  make(map[string]*pendingTx),
+	}
+	file, err := os.Open(filename)
+	if err == nil {
+		log.load(file)
+		_ = file.Close()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	// rewriting also drops the broken tail left by crash
+	if err := log.rewrite(); err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+func (log *txLog) load(reader io.Reader) {
+	for p := range parser.ParseStream(reader) {
+		if p.Err != nil {
+			if p.Err != io.EOF {
+				logger.Warn("broken tcc log: " + p.Err.Error())
+			}
+			break
+		}
+		r, ok := p.Data.(*protocol.MultiBulkReply)
+		if !ok || len(r.Args) < 2 {
+			continue
+		}
+		txId := string(r.Args[1])
+		switch string(r.Args[0]) {
+		case txPhaseBegin:
+			tx := &pendingTx{}
+			for _, node := range r.Args[2:] {
+				tx.nodes = append(tx.nodes, string(node))
+			}
+			log.pending[txId] = tx
+		case txPhaseCommit:
+			if tx := log.pending[txId]; tx != nil {
+				tx.committed = true
+			}
+		case txPhaseRollback:
+			if tx := log.pending[txId]; tx != nil {
+				tx.committed = false
+			}
+		case txPhaseEnd:
+			delete(log.pending, txId)
+		}
+	}
+}
+
+// rewrite replaces the log file with records of pending transactions, invoker should hold the lock
+func (log *txLog) rewrite() error {
+	tmpFilename := log.filename + ".tmp"
+	tmpFile, err := os.OpenFile(tmpFilename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for txId, tx := range log.pending {
+		records := []CmdLine{append(utils.ToCmdLine(txPhaseBegin, txId), utils.ToCmdLine(tx.nodes...)...)}
+		if tx.committed {
+			records = append(records, utils.ToCmdLine(txPhaseCommit, txId))
+		}
+		for _, record := range records {
+			n, err := tmpFile.Write(protocol.MakeMultiBulkReply(record).ToBytes())
+			if err != nil {
+				_ = tmpFile.Close()
+				return err
+			}
+			size += int64(n)
+		}
+	}
+	if err := tmpFile.Sync(); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	_ = tmpFile.Close()
+	if err := os.Rename(tmpFilename, log.filename); err != nil {
+		return err
+	}
+	if log.file != nil {
+		_ = log.file.Close()
+	}
+	log.file, err = os.OpenFile(log.filename, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	log.size = size
+	return nil
+}
+
+// write appends a record and flushes it to disk
+func (log *txLog) write(phase string, txId string, args ...string) error {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	record := append(utils.ToCmdLine(phase, txId), utils.ToCmdLine(args...)...)
+	n, err := log.file.Write(protocol.MakeMultiBulkReply(record).ToBytes())
+	if err != nil {
+		return err
+	}
+	log.size += int64(n)
+	if err := log.file.Sync(); err != nil {
+		return err
+	}
+	switch phase {
+	case txPhaseBegin:
+		log.pending[txId] = &pendingTx{nodes: args}
+	case txPhaseCommit:
+		if tx := log.pending[txId]; tx != nil {
+			tx.committed = true
+		}
+	case txPhaseRollback:
+		if tx := log.pending[txId]; tx != nil {
+			tx.committed = false
+		}
+	case txPhaseEnd:
+		delete(log.pending, txId)
+		if len(log.pending) == 0 && log.size > txLogCompactSize {
+			return log.rewrite()
+		}
+	}
+	return nil
+}
+
+// pendingTxs returns a snapshot of transactions not finished
+func (log *txLog) pendingTxs() map[string]pendingTx {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	result := make(map[string]pendingTx, len(log.pending))
+	for txId, tx := range log.pending {
+		result[txId] = *tx
+	}
+	return result
+}
+
+func (log *txLog) close() error {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	return log.file.Close()
+}
+
+// LogTxBegin records participants of a transaction before preparing. All LogTx functions do nothing if tcc log is disabled
+func (cluster *Cluster) LogTxBegin(txId string, nodes []string) error {
+	if cluster.txLog == nil {
+		return nil
+	}
+	return cluster.txLog.write(txPhaseBegin, txId, nodes...)
+}
+
+// LogTxCommit records the decision of committing before sending commit requests
+func (cluster *Cluster) LogTxCommit(txId string) error {
+	if cluster.txLog == nil {
+		return nil
+	}
+	return cluster.txLog.write(txPhaseCommit, txId)
+}
+
+// LogTxRollback records the decision of rolling back before sending rollback requests
+func (cluster *Cluster) LogTxRollback(txId string) {
+	if cluster.txLog == nil {
+		return
+	}
+	if err := cluster.txLog.write(txPhaseRollback, txId); err != nil {
+		logger.Errorf("write tcc log failed: %v", err)
+	}
+}
+
+// LogTxEnd records that all participants have finished the transaction
+func (cluster *Cluster) LogTxEnd(txId string) {
+	if cluster.txLog == nil {
+		return
+	}
+	if err := cluster.txLog.write(txPhaseEnd, txId); err != nil {
+		logger.Errorf("write tcc log failed: %v", err)
+	}
+}
+
+// recoverTransactions finishes transactions interrupted by crash, retries until all of them finished
+func (cluster *Cluster) recoverTransactions() {
+	for !cluster.finishPendingTxs() {
+		select {
+		case <-time.After(time.Second):
+		case <-cluster.closeChan:
+			return
+		}
+	}
+}
+
+// finishPendingTxs commits or rolls back pending transactions in tcc log, returns whether all of them finished
+func (cluster *Cluster) finishPendingTxs() bool {
+	if cluster.txLog == nil {
+		return true
+	}
+	allFinished := true
+	c := connection.NewFakeConn()
+	c.SetPassword(config.Properties.RequirePass)
+	for txId, tx := range cluster.txLog.pendingTxs() {
+		cmdLine := utils.ToCmdLine("rollback", txId)
+		if tx.committed {
+			cmdLine = utils.ToCmdLine("commit", txId)
+		}
+		finished := true
+		for _, node := range tx.nodes {
+			reply := cluster.Relay(node, c, cmdLine)
+			err := protocol.Try2ErrorReply(reply)
+			// participant has finished or lost the transaction
+			if err != nil && !strings.HasSuffix(err.Error(), errTxNotFound) {
+				logger.Warn(fmt.Sprintf("recover transaction %s on %s failed: %v", txId, node, err))
+				finished = false
+			}
+		}
+		if finished {
+			logger.Infof("recovered transaction %s, committed: %t", txId, tx.committed)
+			cluster.LogTxEnd(txId)
+		} else {
+			allFinished = false
+		}
+	}
+	return allFinished
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"path"
+	"testing"
+
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestTxLogReload(t *testing.T) {
+	filename := path.Join(t.TempDir(), "tcc.log")
+	log, err := openTxLog(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = log.write(txPhaseBegin, "1", "a", "b")
+	_ = log.write(txPhaseBegin, "2", "a")
+	_ = log.write(txPhaseBegin, "3", "b")
+	_ = log.write(txPhaseCommit, "2")
+	_ = log.write(txPhaseCommit, "3")
+	_ = log.write(txPhaseEnd, "3")
+	_ = log.close()
+
+	log, err = openTxLog(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.close()
+	pending := log.pendingTxs()
+	if len(pending) != 2 {
+		t.Fatalf("expect 2 pending transactions, actual %d", len(pending))
+	}
+	if tx := pending["1"]; tx.committed || len(tx.nodes) != 2 {
+		t.Errorf("wrong state of transaction 1: %+v", tx)
+	}
+	if tx := pending["2"]; !tx.committed || len(tx.nodes) != 1 || tx.nodes[0] != "a" {
+		t.Errorf("wrong state of transaction 2: %+v", tx)
+	}
+}
+
+func TestRecoverTransactions(t *testing.T) {
+	nodes := MakeTestCluster([]string{"a", "b"})
+	coordinator := nodes["a"]
+	log, err := openTxLog(path.Join(t.TempDir(), "tcc.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	coordinator.txLog = log
+	defer log.close()
+	conn := connection.NewFakeConn()
+	for _, node := range nodes {
+		node.db.Exec(conn, utils.ToCmdLine("set", "k-"+node.SelfID(), "old"))
+	}
+
+	// coordinator crashed after deciding to commit tx1, and before preparing tx2 on b
+	for _, node := range nodes {
+		reply := node.Exec(conn, utils.ToCmdLine("prepare", "tx1", "set", "k-"+node.SelfID(), "new"))
+		asserts.AssertNotError(t, reply)
+	}
+	reply := nodes["a"].Exec(conn, utils.ToCmdLine("prepare", "tx2", "set", "k2", "new"))
+	asserts.AssertNotError(t, reply)
+	_ = coordinator.LogTxBegin("tx1", []string{"a", "b"})
+	_ = coordinator.LogTxCommit("tx1")
+	_ = coordinator.LogTxBegin("tx2", []string{"a", "b"})
+
+	if !coordinator.finishPendingTxs() {
+		t.Fatal("expect all transactions finished")
+	}
+	for _, node := range nodes {
+		reply := node.db.Exec(conn, utils.ToCmdLine("get", "k-"+node.SelfID()))
+		asserts.AssertBulkReply(t, reply, "new")
+	}
+	if len(log.pendingTxs()) != 0 {
+		t.Error("expect empty tcc log")
+	}
+	reply = nodes["a"].db.Exec(conn, utils.ToCmdLine("get", "k2"))
+	asserts.AssertNullBulk(t, reply)
+	// commit again returns the same result
+	reply = nodes["b"].Exec(conn, utils.ToCmdLine("commit", "tx1"))
+	asserts.AssertStatusReply(t, reply, "OK")
+}
@@ -28,13 +28,32 @@ func MakeTestCluster(ids []string) map[string]*Cluster {
 			return ids[index]
 		}
 		cluster.getSlotImpl = func(key string) uint32 {
-			// backdoor for test
-			i, err := strconv.Atoi(key)
+			// backdoor for test, key (or its hashtag) in number is routed to the slot of same id
+			i, err := strconv.Atoi(GetPartitionKey(key))
 			if err == nil && i < SlotCount {
 				return uint32(i)
 			}
 			return defaultGetSlotImpl(cluster, key)
 		}
+		cluster.getMasterImpl = func(id string) string {
+			// no replicas in test cluster unless injected
+			return ""
+		}
+		cluster.getTopologyImpl = func() []*clusterNode {
+			nodes := make(map[string]*clusterNode)
+			for _, id := range ids {
+				nodes[id] = &clusterNode{ID: id}
+			}
+			for i := 0; i < SlotCount; i++ {
+				node := nodes[cluster.pickNodeImpl(uint32(i))]
+				node.Slots = append(node.Slots, uint32(i))
+			}
+			var result []*clusterNode
+			for _, node := range nodes {
+				result = append(result, node)
+			}
+			return result
+		}
 		cluster.injectInsertCallback()
 		cluster.injectDeleteCallback()
 		nodes[id] = cluster
@@ -0,0 +1,313 @@
+package core
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hdt3213/godis/cluster/raft"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+/*
+CLUSTER subcommands expose topology in the same format as redis cluster, so that cluster-aware clients
+(such as go-redis and lettuce) can bootstrap against godis.
+Nodes are identified by redis advertise address in godis, and CLUSTER commands show them as 40-char ids (see makeNodeID)
+*/
+
+func init() {
+	RegisterCmd("cluster", execCluster)
+}
+
+// clusterNode is a node in the view of current node, see getTopology
+type clusterNode struct {
+	ID     string   // redis advertise address
+	Master string   // id of master, empty if node is master
+	Slots  []uint32 // slots hosted by the node in ascending order, empty if node is slave
+}
+
+// slotRange represents continuous slots, both Start and End are inclusive
+type slotRange struct {
+	Start uint32
+	End   uint32
+}
+
+func defaultGetTopologyImpl(cluster *Cluster) []*clusterNode {
+	var nodes []*clusterNode
+	cluster.raftNode.FSM.WithReadLock(func(fsm *raft.FSM) {
+		for masterId, ms := range fsm.MasterSlaves {
+			slots := make([]uint32, len(fsm.Node2Slot[masterId]))
+			copy(slots, fsm.Node2Slot[masterId])
+			nodes = append(nodes, &clusterNode{
+				ID:    masterId,
+				Slots: slots,
+			})
+			for _, slave := range ms.Slaves {
+				nodes = append(nodes, &clusterNode{
+					ID:     slave,
+					Master: masterId,
+				})
+			}
+		}
+	})
+	return nodes
+}
+
+// getTopology returns all nodes in cluster sorted by id
+func (cluster *Cluster) getTopology() []*clusterNode {
+	nodes := cluster.getTopologyImpl()
+	for _, node := range nodes {
+		sort.Slice(node.Slots, func(i, j int) bool {
+			return node.Slots[i] < node.Slots[j]
+		})
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID < nodes[j].ID
+	})
+	return nodes
+}
+
+// GetMasters returns ids of all masters in cluster sorted by id
+func (cluster *Cluster) GetMasters() []string {
+	var masters []string
+	for _, node := range cluster.getTopology() {
+		if node.Master == "" {
+			masters = append(masters, node.ID)
+		}
+	}
+	return masters
+}
+
+// makeNodeID converts node address to a 40-char id like redis cluster node id
+func makeNodeID(id string) string {
+	sum := sha1.Sum([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// splitNodeAddr returns host and port of node, port is 0 if id is not a valid address
+func splitNodeAddr(id string) (string, int) {
+	host, portStr, err := net.SplitHostPort(id)
+	if err != nil {
+		return id, 0
+	}
+	port, _ := strconv.Atoi(portStr)
+	return host, port
+}
+
+// toSlotRanges merges ascending slots into ranges
+func toSlotRanges(slots []uint32) []*slotRange {
+	var ranges []*slotRange
+	for _, slot := range slots {
+		if len(ranges) > 0 && ranges[len(ranges)-1].End+1 == slot {
+			ranges[len(ranges)-1].End = slot
+			continue
+		}
+		ranges = append(ranges, &slotRange{Start: slot, End: slot})
+	}
+	return ranges
+}
+
+// execCluster handles CLUSTER subcommands
+func execCluster(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if len(cmdLine) < 2 {
+		return protocol.MakeArgNumErrReply("cluster")
+	}
+	subCmd := strings.ToLower(string(cmdLine[1]))
+	args := cmdLine[2:]
+	switch subCmd {
+	case "keyslot":
+		if len(args) != 1 {
+			return protocol.MakeArgNumErrReply("cluster|keyslot")
+		}
+		return protocol.MakeIntReply(int64(cluster.GetSlot(string(args[0]))))
+	case "myid":
+		if len(args) != 0 {
+			return protocol.MakeArgNumErrReply("cluster|myid")
+		}
+		return protocol.MakeBulkReply([]byte(makeNodeID(cluster.SelfID())))
+	case "slots":
+		if len(args) != 0 {
+			return protocol.MakeArgNumErrReply("cluster|slots")
+		}
+		return cluster.execClusterSlots()
+	case "shards":
+		if len(args) != 0 {
+			return protocol.MakeArgNumErrReply("cluster|shards")
+		}
+		return cluster.execClusterShards()
+	case "nodes":
+		if len(args) != 0 {
+			return protocol.MakeArgNumErrReply("cluster|nodes")
+		}
+		return cluster.execClusterNodes()
+	case "info":
+		if len(args) != 0 {
+			return protocol.MakeArgNumErrReply("cluster|info")
+		}
+		return cluster.execClusterInfo()
+	case "setslot":
+		return cluster.execSetSlot(args)
+	case "getkeysinslot":
+		return cluster.execGetKeysInSlot(args)
+	case "countkeysinslot":
+		return cluster.execCountKeysInSlot(args)
+	case "rebalance":
+		if len(args) != 0 {
+			return protocol.MakeArgNumErrReply("cluster|rebalance")
+		}
+		return execRebalance(cluster, c, cmdLine)
+	}
+	return protocol.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try CLUSTER HELP.")
+}
+
+// getSlaves returns slaves of each master
+func getSlaves(nodes []*clusterNode) map[string][]*clusterNode {
+	slaves := make(map[string][]*clusterNode)
+	for _, node := range nodes {
+		if node.Master != "" {
+			slaves[node.Master] = append(slaves[node.Master], node)
+		}
+	}
+	return slaves
+}
+
+func makeSlotsNodeReply(node *clusterNode) redis.Reply {
+	host, port := splitNodeAddr(node.ID)
+	return protocol.MakeMultiRawReply([]redis.Reply{
+		protocol.MakeBulkReply([]byte(host)),
+		protocol.MakeIntReply(int64(port)),
+		protocol.MakeBulkReply([]byte(makeNodeID(node.ID))),
+	})
+}
+
+// execClusterSlots returns [start, end, master, slaves...] for each slot range
+func (cluster *Cluster) execClusterSlots() redis.Reply {
+	nodes := cluster.getTopology()
+	slaves := getSlaves(nodes)
+	var result []redis.Reply
+	for _, node := range nodes {
+		if node.Master != "" {
+			continue
+		}
+		for _, r := range toSlotRanges(node.Slots) {
+			item := []redis.Reply{
+				protocol.MakeIntReply(int64(r.Start)),
+				protocol.MakeIntReply(int64(r.End)),
+				makeSlotsNodeReply(node),
+			}
+			for _, slave := range slaves[node.ID] {
+				item = append(item, makeSlotsNodeReply(slave))
+			}
+			result = append(result, protocol.MakeMultiRawReply(item))
+		}
+	}
+	return protocol.MakeMultiRawReply(result)
+}
+
+func makeShardNodeReply(node *clusterNode) redis.Reply {
+	host, port := splitNodeAddr(node.ID)
+	role := "master"
+	if node.Master != "" {
+		role = "replica"
+	}
+	return protocol.MakeMultiRawReply([]redis.Reply{
+		protocol.MakeBulkReply([]byte("id")),
+		protocol.MakeBulkReply([]byte(makeNodeID(node.ID))),
+		protocol.MakeBulkReply([]byte("port")),
+		protocol.MakeIntReply(int64(port)),
+		protocol.MakeBulkReply([]byte("ip")),
+		protocol.MakeBulkReply([]byte(host)),
+		protocol.MakeBulkReply([]byte("endpoint")),
+		protocol.MakeBulkReply([]byte(host)),
+		protocol.MakeBulkReply([]byte("role")),
+		protocol.MakeBulkReply([]byte(role)),
+		protocol.MakeBulkReply([]byte("replication-offset")),
+		protocol.MakeIntReply(0),
+		protocol.MakeBulkReply([]byte("health")),
+		protocol.MakeBulkReply([]byte("online")),
+	})
+}
+
+// execClusterShards returns slots and nodes of each shard, a shard consists of a master and its slaves
+func (cluster *Cluster) execClusterShards() redis.Reply {
+	nodes := cluster.getTopology()
+	slaves := getSlaves(nodes)
+	var result []redis.Reply
+	for _, node := range nodes {
+		if node.Master != "" {
+			continue
+		}
+		var slots []redis.Reply
+		for _, r := range toSlotRanges(node.Slots) {
+			slots = append(slots, protocol.MakeIntReply(int64(r.Start)), protocol.MakeIntReply(int64(r.End)))
+		}
+		shardNodes := []redis.Reply{makeShardNodeReply(node)}
+		for _, slave := range slaves[node.ID] {
+			shardNodes = append(shardNodes, makeShardNodeReply(slave))
+		}
+		result = append(result, protocol.MakeMultiRawReply([]redis.Reply{
+			protocol.MakeBulkReply([]byte("slots")),
+			protocol.MakeMultiRawReply(slots),
+			protocol.MakeBulkReply([]byte("nodes")),
+			protocol.MakeMultiRawReply(shardNodes),
+		}))
+	}
+	return protocol.MakeMultiRawReply(result)
+}
+
+// execClusterNodes returns nodes in the format of redis nodes.conf:
+// <id> <ip:port@cport> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> <slot> ... <slot>
+func (cluster *Cluster) execClusterNodes() redis.Reply {
+	var sb strings.Builder
+	for _, node := range cluster.getTopology() {
+		host, port := splitNodeAddr(node.ID)
+		flags := "master"
+		master := "-"
+		if node.Master != "" {
+			flags = "slave"
+			master = makeNodeID(node.Master)
+		}
+		if node.ID == cluster.SelfID() {
+			flags = "myself," + flags
+		}
+		sb.WriteString(makeNodeID(node.ID) + " " + host + ":" + strconv.Itoa(port) + "@0 " + flags + " " + master +
+			" 0 0 0 connected")
+		for _, r := range toSlotRanges(node.Slots) {
+			if r.Start == r.End {
+				sb.WriteString(" " + strconv.Itoa(int(r.Start)))
+			} else {
+				sb.WriteString(" " + strconv.Itoa(int(r.Start)) + "-" + strconv.Itoa(int(r.End)))
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return protocol.MakeBulkReply([]byte(sb.String()))
+}
+
+func (cluster *Cluster) execClusterInfo() redis.Reply {
+	nodes := cluster.getTopology()
+	assigned := 0
+	size := 0
+	for _, node := range nodes {
+		assigned += len(node.Slots)
+		if len(node.Slots) > 0 {
+			size++
+		}
+	}
+	state := "ok"
+	if assigned < SlotCount {
+		state = "fail"
+	}
+	info := "cluster_state:" + state + "\r\n" +
+		"cluster_slots_assigned:" + strconv.Itoa(assigned) + "\r\n" +
+		"cluster_slots_ok:" + strconv.Itoa(assigned) + "\r\n" +
+		"cluster_slots_pfail:0\r\n" +
+		"cluster_slots_fail:0\r\n" +
+		"cluster_known_nodes:" + strconv.Itoa(len(nodes)) + "\r\n" +
+		"cluster_size:" + strconv.Itoa(size) + "\r\n"
+	return protocol.MakeBulkReply([]byte(info))
+}
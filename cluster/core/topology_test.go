@@ -0,0 +1,89 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestClusterCommand(t *testing.T) {
+	nodeA, nodeB, nodeC := "127.0.0.1:6399", "127.0.0.1:6400", "127.0.0.1:6401"
+	nodes := MakeTestCluster([]string{nodeA, nodeB})
+	cluster := nodes[nodeA]
+	cluster.getTopologyImpl = func() []*clusterNode {
+		var slotsA, slotsB []uint32
+		for i := 0; i < SlotCount; i++ {
+			if i < SlotCount/2 {
+				slotsA = append(slotsA, uint32(i))
+			} else {
+				slotsB = append(slotsB, uint32(i))
+			}
+		}
+		return []*clusterNode{
+			{ID: nodeB, Slots: slotsB},
+			{ID: nodeC, Master: nodeA},
+			{ID: nodeA, Slots: slotsA},
+		}
+	}
+	idA, idB, idC := makeNodeID(nodeA), makeNodeID(nodeB), makeNodeID(nodeC)
+	if len(idA) != 40 {
+		t.Errorf("expect 40-char node id, actual %s", idA)
+	}
+	conn := connection.NewFakeConn()
+
+	reply := cluster.Exec(conn, utils.ToCmdLine("cluster", "myid"))
+	asserts.AssertBulkReply(t, reply, idA)
+	reply = cluster.Exec(conn, utils.ToCmdLine("cluster", "keyslot", "foo"))
+	asserts.AssertIntReply(t, reply, 12182)
+	reply = cluster.Exec(conn, utils.ToCmdLine("cluster", "nosuch"))
+	asserts.AssertErrReply(t, reply, "ERR unknown subcommand 'nosuch'. Try CLUSTER HELP.")
+
+	reply = cluster.Exec(conn, utils.ToCmdLine("cluster", "slots"))
+	expected := "*2\r\n" +
+		"*4\r\n:0\r\n:8191\r\n" +
+		"*3\r\n$9\r\n127.0.0.1\r\n:6399\r\n$40\r\n" + idA + "\r\n" +
+		"*3\r\n$9\r\n127.0.0.1\r\n:6401\r\n$40\r\n" + idC + "\r\n" +
+		"*3\r\n:8192\r\n:16383\r\n" +
+		"*3\r\n$9\r\n127.0.0.1\r\n:6400\r\n$40\r\n" + idB + "\r\n"
+	if string(reply.ToBytes()) != expected {
+		t.Errorf("unexpected cluster slots: %s", reply.ToBytes())
+	}
+
+	reply = cluster.Exec(conn, utils.ToCmdLine("cluster", "shards"))
+	shards, ok := reply.(*protocol.MultiRawReply)
+	if !ok || len(shards.Replies) != 2 {
+		t.Fatalf("unexpected cluster shards: %s", reply.ToBytes())
+	}
+	shard := string(shards.Replies[0].ToBytes())
+	if !strings.Contains(shard, "$5\r\nslots\r\n*2\r\n:0\r\n:8191\r\n") ||
+		!strings.Contains(shard, "$4\r\nrole\r\n$6\r\nmaster\r\n") ||
+		!strings.Contains(shard, "$4\r\nrole\r\n$7\r\nreplica\r\n") ||
+		!strings.Contains(shard, idC) {
+		t.Errorf("unexpected shard: %s", shard)
+	}
+
+	reply = cluster.Exec(conn, utils.ToCmdLine("cluster", "nodes"))
+	asserts.AssertBulkReply(t, reply, idA+" 127.0.0.1:6399@0 myself,master - 0 0 0 connected 0-8191\n"+
+		idB+" 127.0.0.1:6400@0 master - 0 0 0 connected 8192-16383\n"+
+		idC+" 127.0.0.1:6401@0 slave "+idA+" 0 0 0 connected\n")
+
+	reply = cluster.Exec(conn, utils.ToCmdLine("cluster", "info"))
+	info := string(reply.(*protocol.BulkReply).Arg)
+	if !strings.Contains(info, "cluster_state:ok\r\n") ||
+		!strings.Contains(info, "cluster_slots_assigned:16384\r\n") ||
+		!strings.Contains(info, "cluster_known_nodes:3\r\n") ||
+		!strings.Contains(info, "cluster_size:2\r\n") {
+		t.Errorf("unexpected cluster info: %s", info)
+	}
+
+	// topology of test cluster
+	reply = nodes[nodeB].Exec(conn, utils.ToCmdLine("cluster", "info"))
+	info = string(reply.(*protocol.BulkReply).Arg)
+	if !strings.Contains(info, "cluster_state:ok\r\n") || !strings.Contains(info, "cluster_size:2\r\n") {
+		t.Errorf("unexpected cluster info: %s", info)
+	}
+}
@@ -2,17 +2,19 @@ package core
 
 import (
 	"errors"
-	"hash/crc32"
 	"net"
 	"strings"
 
 	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/crc16"
+	"github.com/hdt3213/godis/lib/tracing"
 	"github.com/hdt3213/godis/lib/utils"
 	"github.com/hdt3213/godis/redis/connection"
 	"github.com/hdt3213/godis/redis/protocol"
 )
 
-const SlotCount int = 1024
+// SlotCount is the number of slots in cluster, same as redis cluster so that smart clients can compute slot of keys
+const SlotCount int = 16384
 
 const getCommittedIndexCommand = "raft.committedindex"
 
@@ -35,7 +37,8 @@ func (cluster *Cluster) Relay(peerId string, c redis.Connection, cmdLine [][]byt
 	defer func() {
 		_ = cluster.connections.ReturnPeerClient(cli)
 	}()
-	return cli.Send(cmdLine)
+	// span of peer is a child of the span executing this command
+	return cli.Send(tracing.WrapCmdLine(c.Context(), cmdLine))
 }
 
 // LocalExec executes command at local node
@@ -48,6 +51,11 @@ func (cluster *Cluster) LocalExecWithinLock(c redis.Connection, cmdLine [][]byte
 	return cluster.db.ExecWithLock(c, cmdLine)
 }
 
+// LocalExecMulti executes MULTI transaction at local node
+func (cluster *Cluster) LocalExecMulti(c redis.Connection, watching map[string]uint32, cmdLines []CmdLine) redis.Reply {
+	return cluster.db.ExecMulti(c, watching, cmdLines)
+}
+
 func (cluster *Cluster) SlaveOf(master string) error {
 	host, port, err := net.SplitHostPort(master)
 	if err != nil {
@@ -61,28 +69,49 @@ func (cluster *Cluster) SlaveOf(master string) error {
 	return nil
 }
 
-// GetPartitionKey extract hashtag
+// GetPartitionKey extract hashtag, which is the content between the first `{` and the first `}` after it.
+// The whole key is used if hashtag is absent or empty
 func GetPartitionKey(key string) string {
 	beg := strings.Index(key, "{")
 	if beg == -1 {
 		return key
 	}
-	end := strings.Index(key, "}")
-	if end == -1 || end == beg+1 {
+	end := strings.Index(key[beg+1:], "}")
+	if end <= 0 {
 		return key
 	}
-	return key[beg+1 : end]
+	return key[beg+1 : beg+1+end]
 }
 
 func defaultGetSlotImpl(cluster *Cluster, key string) uint32 {
 	partitionKey := GetPartitionKey(key)
-	return crc32.ChecksumIEEE([]byte(partitionKey)) % uint32(SlotCount)
+	return uint32(crc16.Checksum([]byte(partitionKey))) % uint32(SlotCount)
 }
 
 func (cluster *Cluster) GetSlot(key string) uint32 {
 	return cluster.getSlotImpl(key)
 }
 
+// IsSameSlot returns whether all keys hash to the same slot
+func (cluster *Cluster) IsSameSlot(keys []string) bool {
+	for i := 1; i < len(keys); i++ {
+		if cluster.GetSlot(keys[i]) != cluster.GetSlot(keys[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+// MakeCrossSlotErrReply creates the error reply for commands whose keys hash to different slots
+func MakeCrossSlotErrReply() *protocol.StandardErrReply {
+	return protocol.MakeErrReply("CROSSSLOT Keys in request don't hash to the same slot")
+}
+
+// IsRedirectEnabled returns whether clients are redirected by MOVED and ASK instead of relaying
+func (cluster *Cluster) IsRedirectEnabled() bool {
+	return cluster.config.Redirect
+}
+
 func defaultPickNodeImpl(cluster *Cluster, slotID uint32) string {
 	return cluster.raftNode.FSM.PickNode(slotID)
 }
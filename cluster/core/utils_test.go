@@ -0,0 +1,25 @@
+package core
+
+import "testing"
+
+func TestGetSlot(t *testing.T) {
+	if slot := defaultGetSlotImpl(nil, "foo"); slot != 12182 {
+		t.Errorf("expect slot 12182, actual %d", slot)
+	}
+	if defaultGetSlotImpl(nil, "{user1000}.following") != defaultGetSlotImpl(nil, "{user1000}.followers") {
+		t.Error("keys with same hashtag should be in same slot")
+	}
+	// hashtag examples from redis cluster specification
+	for key, partitionKey := range map[string]string{
+		"{user1000}.following": "user1000",
+		"foo{}{bar}":           "foo{}{bar}",
+		"foo{{bar}}zap":        "{bar",
+		"foo{bar}{zap}":        "bar",
+		"foo{bar":              "foo{bar",
+		"}foo{bar}":            "bar",
+	} {
+		if actual := GetPartitionKey(key); actual != partitionKey {
+			t.Errorf("partition key of %s: expect %s, actual %s", key, partitionKey, actual)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package raft
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"sync"
 
@@ -25,6 +26,7 @@ type FSM struct {
 	SlaveMasters map[string]string         // slaveId -> masterId
 	Failovers    map[string]*FailoverTask  // taskId -> task
 	changed      func(*FSM)                // called while fsm changed, within readlock
+	slotCount    int                       // expected number of slots, 0 means no check
 }
 
 type MasterSlave struct {
@@ -101,6 +103,9 @@ func (fsm *FSM) Apply(log *raft.Log) interface{} {
 		fsm.addSlots(task.TargetNode, task.Slots)
 		fsm.removeSlots(task.SrcNode, task.Slots)
 	} else if entry.Event == EventSeedStart {
+		if err := fsm.checkSlotCount(entry.InitTask.SlotCount); err != nil {
+			panic(err)
+		}
 		slots := make([]uint32, int(entry.InitTask.SlotCount))
 		for i := 0; i < entry.InitTask.SlotCount; i++ {
 			fsm.Slot2Node[uint32(i)] = entry.InitTask.Leader
@@ -126,6 +131,18 @@ func (fsm *FSM) Apply(log *raft.Log) interface{} {
 	return nil
 }
 
+// checkSlotCount refuses raft state created with another number of slots.
+// Clusters before 16384 slots used 1024 slots hashed by crc32, keys in them would be routed to wrong slots now,
+// such a cluster has to be created again and its data imported by clients.
+func (fsm *FSM) checkSlotCount(slotCount int) error {
+	if fsm.slotCount == 0 || slotCount == fsm.slotCount {
+		return nil
+	}
+	return fmt.Errorf("raft state has %d slots but %d slots are expected, "+
+		"it may be created by an older version using 1024 slots and crc32, remove the raft dir to create a new cluster",
+		slotCount, fsm.slotCount)
+}
+
 // FSMSnapshot stores necessary data to restore FSM
 type FSMSnapshot struct {
 	Slot2Node    map[uint32]string // slotID -> nodeID
@@ -187,6 +204,11 @@ func (fsm *FSM) Restore(src io.ReadCloser) error {
 	if err != nil {
 		return err
 	}
+	if len(snapshot.Slot2Node) > 0 {
+		if err := fsm.checkSlotCount(len(snapshot.Slot2Node)); err != nil {
+			return err
+		}
+	}
 	fsm.Slot2Node = snapshot.Slot2Node
 	fsm.Migratings = snapshot.Migratings
 	fsm.MasterSlaves = snapshot.MasterSlaves
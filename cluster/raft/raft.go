@@ -35,6 +35,8 @@ type RaftConfig struct {
 	RaftListenAddr     string
 	RaftAdvertiseAddr  string
 	Dir                string
+	// SlotCount is the number of slots of cluster, raft state created with a different count is refused
+	SlotCount int
 }
 
 func (cfg *RaftConfig) ID() string {
@@ -86,6 +88,7 @@ func StartNode(cfg *RaftConfig) (*Node, error) {
 		MasterSlaves: make(map[string]*MasterSlave),
 		SlaveMasters: make(map[string]string),
 		Failovers:    make(map[string]*FailoverTask),
+		slotCount:    cfg.SlotCount,
 	}
 
 	logStore := boltDB
@@ -0,0 +1,66 @@
+// godis-check-aof validates an append only file and optionally truncates it to the last valid command,
+// or to the given point in time if the file has #TS annotations (see aof-timestamp-enabled)
+//
+// usage: godis-check-aof [--fix] [--truncate-to-timestamp <unix-time>] <file.aof>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/hdt3213/godis/aof"
+)
+
+func main() {
+	fix := flag.Bool("fix", false, "truncate the file to the last valid command")
+	until := flag.Int64("truncate-to-timestamp", 0, "truncate the file at the first #TS annotation later than the unix time")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [--fix] [--truncate-to-timestamp <unix-time>] <file.aof>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	filename := flag.Arg(0)
+
+	result, err := aof.CheckAof(filename, *until)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if result.PreambleSize > 0 {
+		fmt.Printf("RDB preamble is OK, %d bytes\n", result.PreambleSize)
+	}
+	fmt.Printf("%d valid commands, %d of %d bytes are valid\n", result.Commands, result.ValidSize, result.Size)
+	if result.TimestampReached {
+		err = os.Truncate(filename, result.ValidSize)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "truncate failed: "+err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Successfully truncated AOF to timestamp %d, %d bytes discarded\n", *until, result.Size-result.ValidSize)
+		return
+	}
+	if result.Err == nil {
+		if *until > 0 {
+			fmt.Printf("AOF is valid, no annotation later than timestamp %d\n", *until)
+			return
+		}
+		fmt.Println("AOF is valid")
+		return
+	}
+	fmt.Println(result.Err.Error())
+	if !*fix {
+		fmt.Println("AOF is not valid, use the --fix option to truncate it")
+		os.Exit(1)
+	}
+	err = os.Truncate(filename, result.ValidSize)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "truncate failed: "+err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("Successfully truncated AOF, %d bytes discarded\n", result.Size-result.ValidSize)
+}
@@ -0,0 +1,153 @@
+// godis-check-dump prints keys stored in a rdb file or an append only file with their type, size in rdb format
+// and ttl, followed by a report of the biggest keys. Append only files are replayed in memory, files are never modified
+//
+// usage: godis-check-dump [--format rdb|aof] [--keys=false] [--top <n>] [--databases <n>] <file>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hdt3213/godis/aof"
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/database"
+)
+
+// typeStats summarizes keys of a type
+type typeStats struct {
+	keys     int
+	size     int64
+	elements int64
+	biggest  *aof.KeyInfo
+}
+
+func main() {
+	format := flag.String("format", "", "rdb or aof, detected by file extension if it is empty")
+	printKeys := flag.Bool("keys", true, "print every key")
+	top := flag.Int("top", 10, "number of the biggest keys to report")
+	databases := flag.Int("databases", 16, "number of databases, used for append only files")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [--format rdb|aof] [--keys=false] [--top <n>] [--databases <n>] <file>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	filename := flag.Arg(0)
+	if *format == "" {
+		*format = "rdb"
+		if strings.HasSuffix(strings.ToLower(filename), ".aof") {
+			*format = "aof"
+		}
+	}
+
+	now := time.Now()
+	stats := make(map[string]*typeStats)
+	var biggest []*aof.KeyInfo
+	cb := func(info *aof.KeyInfo) bool {
+		if *printKeys {
+			fmt.Printf("db=%d key=%s type=%s size=%d elements=%d ttl=%s\n",
+				info.DB, strconv.Quote(info.Key), info.Type, info.Size, info.Elements, formatTTL(info.ExpireAt, now))
+		}
+		s := stats[info.Type]
+		if s == nil {
+			s = &typeStats{}
+			stats[info.Type] = s
+		}
+		s.keys++
+		s.size += int64(info.Size)
+		s.elements += int64(info.Elements)
+		if s.biggest == nil || info.Size > s.biggest.Size {
+			s.biggest = info
+		}
+		biggest = pushBiggest(biggest, info, *top)
+		return true
+	}
+
+	var err error
+	switch *format {
+	case "rdb":
+		err = aof.InspectRDB(filename, cb)
+	case "aof":
+		config.Properties.Databases = *databases
+		err = aof.InspectAof(filename, database.MakeAuxiliaryServer(), cb)
+		if corrupt, ok := err.(*aof.CorruptError); ok {
+			fmt.Fprintln(os.Stderr, corrupt.Error()+", keys loaded before it are reported, use godis-check-aof to fix it")
+			err = nil
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "unknown format: "+*format)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	printReport(stats, biggest)
+}
+
+func formatTTL(expireAt *time.Time, now time.Time) string {
+	if expireAt == nil {
+		return "-1"
+	}
+	ttl := expireAt.Sub(now)
+	if ttl <= 0 {
+		return "expired"
+	}
+	return ttl.Round(time.Second).String()
+}
+
+// pushBiggest keeps the n biggest keys sorted by size in descending order
+func pushBiggest(biggest []*aof.KeyInfo, info *aof.KeyInfo, n int) []*aof.KeyInfo {
+	if n <= 0 {
+		return biggest
+	}
+	i := sort.Search(len(biggest), func(i int) bool {
+		return biggest[i].Size < info.Size
+	})
+	if i >= n {
+		return biggest
+	}
+	if len(biggest) < n {
+		biggest = append(biggest, nil)
+	}
+	copy(biggest[i+1:], biggest[i:])
+	biggest[i] = info
+	return biggest
+}
+
+func printReport(stats map[string]*typeStats, biggest []*aof.KeyInfo) {
+	types := make([]string, 0, len(stats))
+	for t := range stats {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	fmt.Println()
+	fmt.Println("-------- summary --------")
+	var keys int
+	var size int64
+	for _, t := range types {
+		s := stats[t]
+		keys += s.keys
+		size += s.size
+		fmt.Printf("%d %s keys, %d bytes, %d elements, avg size %.2f, biggest %s (%d bytes)\n",
+			s.keys, t, s.size, s.elements, float64(s.size)/float64(s.keys), strconv.Quote(s.biggest.Key), s.biggest.Size)
+	}
+	fmt.Printf("%d keys, %d bytes in total\n", keys, size)
+	if len(biggest) == 0 {
+		return
+	}
+	fmt.Println()
+	fmt.Println("-------- biggest keys --------")
+	for i, info := range biggest {
+		fmt.Printf("%d) db=%d key=%s type=%s size=%d elements=%d\n",
+			i+1, info.DB, strconv.Quote(info.Key), info.Type, info.Size, info.Elements)
+	}
+}
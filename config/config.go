@@ -2,6 +2,9 @@ package config
 
 import (
 	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
@@ -32,18 +35,60 @@ type ServerProperties struct {
 	AppendFilename    string `cfg:"appendfilename"`
 	AppendFsync       string `cfg:"appendfsync"`
 	AofUseRdbPreamble bool   `cfg:"aof-use-rdb-preamble"`
-	MaxClients        int    `cfg:"maxclients"`
-	RequirePass       string `cfg:"requirepass"`
-	Databases         int    `cfg:"databases"`
-	RDBFilename       string `cfg:"dbfilename"`
-	MasterAuth        string `cfg:"masterauth"`
-	SlaveAnnouncePort int    `cfg:"slave-announce-port"`
-	SlaveAnnounceIP   string `cfg:"slave-announce-ip"`
-	ReplTimeout       int    `cfg:"repl-timeout"`
-	UseGnet           bool   `cfg:"use-gnet"`
+	// AofLoadTruncated allows to load aof file ending with an incomplete command by truncating it
+	AofLoadTruncated bool `cfg:"aof-load-truncated"`
+	// AofTimestampEnabled writes #TS annotations into aof file every second
+	AofTimestampEnabled bool `cfg:"aof-timestamp-enabled"`
+	// AofLoadUntilTimestamp stops loading aof file at the first #TS annotation later than it (unix seconds),
+	// and drops the rest of the file. 0 means loading the whole file.
+	AofLoadUntilTimestamp int64  `cfg:"aof-load-until-timestamp"`
+	MaxClients            int    `cfg:"maxclients"`
+	RequirePass           string `cfg:"requirepass"`
+	Databases             int    `cfg:"databases"`
+	RDBFilename           string `cfg:"dbfilename"`
+	MasterAuth            string `cfg:"masterauth"`
+	SlaveAnnouncePort     int    `cfg:"slave-announce-port"`
+	SlaveAnnounceIP       string `cfg:"slave-announce-ip"`
+	ReplTimeout           int    `cfg:"repl-timeout"`
+	// ReplicaReadOnly makes replicas reject write commands from clients other than master
+	ReplicaReadOnly bool `cfg:"replica-read-only"`
+	// ReplBacklogSize is the size in bytes of the circular backlog kept by master for partial resynchronization
+	ReplBacklogSize int  `cfg:"repl-backlog-size"`
+	UseGnet         bool `cfg:"use-gnet"`
+
+	// LogLevel is the minimum level of logs: debug, verbose, notice or warning
+	LogLevel string `cfg:"loglevel"`
+
+	// Timeout closes clients idle for more than Timeout seconds, 0 means never
+	Timeout int `cfg:"timeout"`
+	// ClientCommandRate limits commands per second of each client, commands beyond it are refused with THROTTLED.
+	// 0 means no limit
+	ClientCommandRate int `cfg:"client-command-rate"`
+	// TCPKeepalive is the period in seconds of tcp keep-alive of client connections, 0 disables keep-alive
+	TCPKeepalive int `cfg:"tcp-keepalive"`
+
+	// NotifyKeyspaceEvents selects classes of keyspace events to publish, such as "KEA", empty means disabled
+	NotifyKeyspaceEvents string `cfg:"notify-keyspace-events"`
 
 	SlowLogSlowerThan int64 `cfg:"slowlog-log-slower-than"`
 	SlowLogMaxLen     int   `cfg:"slowlog-max-len"`
+	// LatencyMonitorThreshold is the min latency in milliseconds of events sampled by LATENCY, 0 disables the monitor
+	LatencyMonitorThreshold int64 `cfg:"latency-monitor-threshold"`
+	// Tracing creates an OpenTelemetry span for each command, spans are exported by the global TracerProvider
+	// installed by applications embedding godis
+	Tracing bool `cfg:"tracing"`
+	// LuaTimeLimit is the max execution time in milliseconds of lua scripts, other clients are refused with BUSY
+	// after it until the script ends or is killed by SCRIPT KILL. 0 or negative means no limit
+	LuaTimeLimit int64 `cfg:"lua-time-limit"`
+	// DebugHTTPAddr serves net/http/pprof and expvar if it is not empty, such as "127.0.0.1:6060"
+	DebugHTTPAddr string `cfg:"debug-http-addr"`
+
+	// MaxMemoryPolicy selects access tracking of keys, LFU counters are used if it is allkeys-lfu or volatile-lfu
+	MaxMemoryPolicy string `cfg:"maxmemory-policy"`
+	LFULogFactor    int    `cfg:"lfu-log-factor"`
+	LFUDecayTime    int    `cfg:"lfu-decay-time"`
+	// MaxMemory is the memory limit in bytes, 0 means no limit. Units like 100mb are accepted
+	MaxMemory int64 `cfg:"maxmemory"`
 
 	ClusterEnable     bool   `cfg:"cluster-enable"`
 	ClusterAsSeed     bool   `cfg:"cluster-as-seed"`
@@ -53,6 +98,26 @@ type ServerProperties struct {
 	// If the node join the cluster as a replica of another node,
 	// set MasterInCluster as the RedisAdvertiseAddr of it's master node
 	MasterInCluster string `cfg:"master-in-cluster"`
+	// ClusterRedirect makes nodes reply MOVED/ASK like redis cluster instead of relaying commands to other nodes
+	ClusterRedirect bool `cfg:"cluster-redirect"`
+
+	// TLSPort accepts TLS connections if it is not 0, Port could be set to 0 to disable non-TLS connections
+	TLSPort       int    `cfg:"tls-port"`
+	TLSCertFile   string `cfg:"tls-cert-file"`
+	TLSKeyFile    string `cfg:"tls-key-file"`
+	TLSCACertFile string `cfg:"tls-ca-cert-file"`
+	// TLSAuthClients requires clients to present a certificate signed by tls-ca-cert-file
+	TLSAuthClients bool `cfg:"tls-auth-clients"`
+	// TLSReplication makes replicas connect their master through TLS
+	TLSReplication bool `cfg:"tls-replication"`
+	// TLSCluster makes nodes connect each other through TLS, and announce tls-port as the port of their address
+	TLSCluster bool `cfg:"tls-cluster"`
+
+	// RenameCommands holds rename-command directives such as `FLUSHALL ""` or `CONFIG b840fc02`,
+	// renaming a command to empty string disables it
+	RenameCommands []string `cfg:"rename-command"`
+	// LoadModules holds paths of go plugins providing custom commands, see database.LoadModules
+	LoadModules []string `cfg:"loadmodule"`
 }
 
 var configFilePath string
@@ -66,10 +131,14 @@ type ServerInfo struct {
 }
 
 func (p *ServerProperties) AnnounceAddress() string {
+	port := p.Port
+	if p.TLSCluster && p.TLSPort != 0 {
+		port = p.TLSPort
+	}
 	if p.AnnounceHost != "" {
-		return p.AnnounceHost + ":" + strconv.Itoa(p.Port)
+		return p.AnnounceHost + ":" + strconv.Itoa(port)
 	}
-	return p.Bind + ":" + strconv.Itoa(p.Port)
+	return p.Bind + ":" + strconv.Itoa(port)
 }
 
 func (p *ServerProperties) RaftAnnounceAddress() string {
@@ -91,18 +160,34 @@ func init() {
 
 	// default config
 	Properties = &ServerProperties{
-		Bind:       "127.0.0.1",
-		Port:       6379,
-		AppendOnly: false,
-		RunID:      utils.RandString(40),
+		Bind:             "127.0.0.1",
+		Port:             6379,
+		AppendOnly:       false,
+		RunID:            utils.RandSecureHexString(40),
+		AofLoadTruncated: true,
+		ReplicaReadOnly:  true,
+		LFULogFactor:     10,
+		LFUDecayTime:     1,
+		TCPKeepalive:     300,
+		LogLevel:         "notice",
+		LuaTimeLimit:     5000,
 	}
 }
 
 func parse(src io.Reader) *ServerProperties {
-	config := &ServerProperties{}
+	config := &ServerProperties{
+		AofLoadTruncated: true,
+		ReplicaReadOnly:  true,
+		LFULogFactor:     10,
+		LFUDecayTime:     1,
+		TCPKeepalive:     300,
+		LogLevel:         "notice",
+		LuaTimeLimit:     5000,
+	}
 
 	// read config file
 	rawMap := make(map[string]string)
+	multiMap := make(map[string][]string) // values of directives with flagMulti
 	scanner := bufio.NewScanner(src)
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -111,9 +196,12 @@ func parse(src io.Reader) *ServerProperties {
 		}
 		pivot := strings.IndexAny(line, " ")
 		if pivot > 0 && pivot < len(line)-1 { // separator found
-			key := line[0:pivot]
+			key := strings.ToLower(line[0:pivot])
 			value := strings.Trim(line[pivot+1:], " ")
-			rawMap[strings.ToLower(key)] = value
+			rawMap[key] = value
+			if paramFlags[key]&flagMulti > 0 {
+				multiMap[key] = append(multiMap[key], value)
+			}
 		}
 	}
 	if err := scanner.Err(); err != nil {
@@ -127,35 +215,15 @@ func parse(src io.Reader) *ServerProperties {
 	for i := 0; i < n; i++ {
 		field := t.Elem().Field(i)
 		fieldVal := v.Elem().Field(i)
-		key, ok := field.Tag.Lookup("cfg")
-		if !ok || strings.TrimLeft(key, " ") == "" {
-			key = field.Name
+		key := paramName(field)
+		if values, ok := multiMap[key]; ok {
+			fieldVal.Set(reflect.ValueOf(values))
+			continue
 		}
-		value, ok := rawMap[strings.ToLower(key)]
+		value, ok := rawMap[key]
 		if ok {
-			// fill config
-			switch field.Type.Kind() {
-			case reflect.String:
-				fieldVal.SetString(value)
-			case reflect.Int:
-				intValue, err := strconv.ParseInt(value, 10, 64)
-				if err == nil {
-					fieldVal.SetInt(intValue)
-				}
-			case reflect.Int64:
-				intValue, err := strconv.ParseInt(value, 10, 64)
-				if err == nil {
-					fieldVal.SetInt(intValue)
-				}
-			case reflect.Bool:
-				boolValue := "yes" == value
-				fieldVal.SetBool(boolValue)
-			case reflect.Slice:
-				if field.Type.Elem().Kind() == reflect.String {
-					slice := strings.Split(value, ",")
-					fieldVal.Set(reflect.ValueOf(slice))
-				}
-			}
+			// invalid values are ignored and defaults are kept
+			_ = setValue(fieldVal, value, paramFlags[key])
 		}
 	}
 	return config
@@ -169,7 +237,7 @@ func SetupConfig(configFilename string) {
 	}
 	defer file.Close()
 	Properties = parse(file)
-	Properties.RunID = utils.RandString(40)
+	Properties.RunID = utils.RandSecureHexString(40)
 	configFilePath, err = filepath.Abs(configFilename)
 	if err != nil {
 		return
@@ -179,6 +247,65 @@ func SetupConfig(configFilename string) {
 	}
 }
 
+// IsLFUEnabled returns whether access frequency instead of idle time of keys is tracked
+func (p *ServerProperties) IsLFUEnabled() bool {
+	return strings.HasSuffix(p.MaxMemoryPolicy, "-lfu")
+}
+
 func GetTmpDir() string {
 	return Properties.Dir + "/tmp"
 }
+
+// ServerTLSConfig creates tls config for the listener of tls-port
+func (p *ServerProperties) ServerTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(p.TLSCertFile, p.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+	if p.TLSCACertFile != "" {
+		tlsConfig.ClientCAs, err = loadCertPool(p.TLSCACertFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.TLSAuthClients {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// ClientTLSConfig creates tls config for connections to master or other nodes in cluster.
+// The certificate of tls-cert-file is presented to them, so that they could authenticate this node
+func (p *ServerProperties) ClientTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if p.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(p.TLSCertFile, p.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if p.TLSCACertFile != "" {
+		var err error
+		tlsConfig.RootCAs, err = loadCertPool(p.TLSCACertFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tlsConfig, nil
+}
+
+func loadCertPool(filename string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificate found in " + filename)
+	}
+	return pool, nil
+}
@@ -1,6 +1,9 @@
 package config
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -25,3 +28,159 @@ func TestParse(t *testing.T) {
 		t.Error("bool parse failed")
 	}
 }
+
+func TestParseTLS(t *testing.T) {
+	src := "bind 127.0.0.1\n" +
+		"port 6399\n" +
+		"tls-port 6400\n" +
+		"tls-cluster yes\n"
+	p := parse(strings.NewReader(src))
+	if p.TLSPort != 6400 || !p.TLSCluster {
+		t.Error("tls parse failed")
+	}
+	if p.AnnounceAddress() != "127.0.0.1:6400" {
+		t.Error("expect announcing tls-port, actual: " + p.AnnounceAddress())
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	p := parse(strings.NewReader("maxmemory 100mb\nrepl-backlog-size 2k\n"))
+	if p.MaxMemory != 100<<20 {
+		t.Errorf("expect 100mb, actual: %d", p.MaxMemory)
+	}
+	if p.ReplBacklogSize != 2000 {
+		t.Errorf("expect 2000, actual: %d", p.ReplBacklogSize)
+	}
+	if _, err := parseMemory("1x"); err == nil {
+		t.Error("expect error")
+	}
+}
+
+func TestGetSet(t *testing.T) {
+	backup := Properties
+	defer func() {
+		Properties = backup
+	}()
+	Properties = parse(strings.NewReader("port 6399\nappendfsync always\n"))
+
+	result := Get("appendf*")
+	if len(result) != 4 || result[0] != "appendfilename" || result[2] != "appendfsync" || result[3] != "always" {
+		t.Errorf("unexpected result: %v", result)
+	}
+	if result = Get("PORT"); len(result) != 2 || result[1] != "6399" {
+		t.Errorf("unexpected result: %v", result)
+	}
+	if result = Get("runid"); len(result) != 0 {
+		t.Error("runid should be hidden")
+	}
+
+	var notified string
+	unregister := RegisterCallback("appendfsync", func(value string) error {
+		notified = value
+		return nil
+	})
+	if err := Set("appendfsync", "EVERYSEC", "maxmemory", "1gb"); err != nil {
+		t.Error(err)
+	}
+	if Properties.AppendFsync != "everysec" || notified != "everysec" || Properties.MaxMemory != 1<<30 {
+		t.Errorf("set failed: %s %s %d", Properties.AppendFsync, notified, Properties.MaxMemory)
+	}
+	unregister()
+
+	if err := Set("port", "6400"); !errors.Is(err, ErrImmutable) {
+		t.Errorf("expect immutable error, actual: %v", err)
+	}
+	if err := Set("unknown", "1"); err == nil {
+		t.Error("expect error")
+	}
+	// nothing is modified if any parameter is invalid
+	if err := Set("maxmemory", "2gb", "appendfsync", "sometimes"); err == nil {
+		t.Error("expect error")
+	}
+	if Properties.MaxMemory != 1<<30 {
+		t.Error("maxmemory should not be modified")
+	}
+	if err := Set("replica-read-only", "maybe"); err == nil {
+		t.Error("expect error")
+	}
+
+	// modification is reverted if callback failed
+	unregister = RegisterCallback("slowlog-max-len", func(value string) error {
+		return errors.New("failed")
+	})
+	defer unregister()
+	if err := Set("maxmemory", "2gb", "slowlog-max-len", "10"); err == nil {
+		t.Error("expect error")
+	}
+	if Properties.MaxMemory != 1<<30 || Properties.SlowLogMaxLen != 0 {
+		t.Error("modification should be reverted")
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	backup := Properties
+	backupPath := configFilePath
+	defer func() {
+		Properties = backup
+		configFilePath = backupPath
+	}()
+	src := "# comment\n" +
+		"port 6399\n" +
+		"\n" +
+		"# another comment\n" +
+		"appendfsync always\n" +
+		"appendfsync no\n" +
+		"rename-command FLUSHALL \"\"\n" +
+		"rename-command CONFIG b840fc02\n" +
+		"unknown-param 1\n"
+	modified = make(map[string]struct{})
+	configFilePath = filepath.Join(t.TempDir(), "redis.conf")
+	if err := os.WriteFile(configFilePath, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	Properties = parse(strings.NewReader(src))
+	if err := Set("appendfsync", "everysec", "maxmemory", "1mb"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(configFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "# comment\n" +
+		"port 6399\n" +
+		"\n" +
+		"# another comment\n" +
+		"appendfsync everysec\n" +
+		"rename-command FLUSHALL \"\"\n" +
+		"rename-command CONFIG b840fc02\n" +
+		"unknown-param 1\n" +
+		rewriteMark + "\n" +
+		"maxmemory 1048576\n"
+	if string(content) != expected {
+		t.Errorf("unexpected content:\n%s", content)
+	}
+	// rewrite again without modification
+	if err := Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	content2, _ := os.ReadFile(configFilePath)
+	if string(content2) != expected {
+		t.Errorf("unexpected content:\n%s", content2)
+	}
+	p := parse(strings.NewReader(string(content)))
+	if p.AppendFsync != "everysec" || p.MaxMemory != 1<<20 {
+		t.Error("rewritten file cannot be parsed")
+	}
+}
+
+func TestParseMulti(t *testing.T) {
+	src := "rename-command FLUSHALL \"\"\n" +
+		"rename-command CONFIG b840fc02\n"
+	p := parse(strings.NewReader(src))
+	if len(p.RenameCommands) != 2 || p.RenameCommands[0] != `FLUSHALL ""` || p.RenameCommands[1] != "CONFIG b840fc02" {
+		t.Errorf("unexpected result: %v", p.RenameCommands)
+	}
+}
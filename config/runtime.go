@@ -0,0 +1,447 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hdt3213/godis/lib/wildcard"
+)
+
+// flags of config parameters
+const (
+	// flagMutable marks parameters which could be modified by CONFIG SET
+	flagMutable = 1 << iota
+	// flagMemory marks parameters in bytes, which accept units such as 100mb
+	flagMemory
+	// flagHidden marks parameters invisible to CONFIG GET/SET/REWRITE
+	flagHidden
+	// flagMulti marks directives which could appear multiple times, each line is an item of the slice
+	flagMulti
+)
+
+// paramFlags holds flags of parameters, parameters absent here are immutable,
+// since they are only used during startup
+var paramFlags = map[string]int{
+	"runid":                     flagHidden,
+	"appendfsync":               flagMutable,
+	"aof-load-truncated":        flagMutable,
+	"aof-timestamp-enabled":     flagMutable,
+	"maxclients":                flagMutable,
+	"timeout":                   flagMutable,
+	"client-command-rate":       flagMutable,
+	"loglevel":                  flagMutable,
+	"requirepass":               flagMutable,
+	"masterauth":                flagMutable,
+	"dbfilename":                flagMutable,
+	"repl-timeout":              flagMutable,
+	"replica-read-only":         flagMutable,
+	"repl-backlog-size":         flagMemory,
+	"notify-keyspace-events":    flagMutable,
+	"slowlog-log-slower-than":   flagMutable,
+	"slowlog-max-len":           flagMutable,
+	"latency-monitor-threshold": flagMutable,
+	"tracing":                   flagMutable,
+	"debug-http-addr":           flagMutable,
+	"lua-time-limit":            flagMutable,
+	"maxmemory":                 flagMutable | flagMemory,
+	"maxmemory-policy":          flagMutable,
+	"lfu-log-factor":            flagMutable,
+	"lfu-decay-time":            flagMutable,
+	"cluster-redirect":          flagMutable,
+	"rename-command":            flagMulti,
+	"loadmodule":                flagMulti,
+}
+
+// enumValues holds allowed values of enumerated parameters
+var enumValues = map[string][]string{
+	"appendfsync": {"always", "everysec", "no"},
+	"loglevel":    {"debug", "verbose", "notice", "warning"},
+	"maxmemory-policy": {"noeviction", "allkeys-lru", "volatile-lru", "allkeys-lfu", "volatile-lfu",
+		"allkeys-random", "volatile-random", "volatile-ttl"},
+}
+
+// rewriteMark separates parameters appended by CONFIG REWRITE from the original content
+const rewriteMark = "# Generated by CONFIG REWRITE"
+
+// Callback is invoked after the parameter is modified by Set, the modification is reverted if it returns an error
+type Callback func(value string) error
+
+type callbackEntry struct {
+	fn Callback
+}
+
+var (
+	// registryMu serializes Set, Rewrite and callback registration
+	registryMu sync.Mutex
+	callbacks  = make(map[string][]*callbackEntry)
+	// modified holds parameters changed by Set, Rewrite appends them to config file if absent
+	modified = make(map[string]struct{})
+)
+
+// ErrImmutable is returned when setting a parameter which could only be set in config file
+var ErrImmutable = errors.New("can't set immutable config")
+
+// ParamError describes why Set failed on a parameter
+type ParamError struct {
+	Name string
+	Err  error
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("CONFIG SET failed (possibly related to argument '%s') - %s", e.Name, e.Err.Error())
+}
+
+func (e *ParamError) Unwrap() error {
+	return e.Err
+}
+
+// paramName returns the name of field used in config file
+func paramName(field reflect.StructField) string {
+	key, ok := field.Tag.Lookup("cfg")
+	if !ok || strings.TrimLeft(key, " ") == "" {
+		key = field.Name
+	}
+	return strings.ToLower(key)
+}
+
+// lookupParam returns the field of Properties which holds the parameter
+func lookupParam(name string) (reflect.Value, bool) {
+	if paramFlags[name]&flagHidden > 0 {
+		return reflect.Value{}, false
+	}
+	t := reflect.TypeOf(Properties).Elem()
+	v := reflect.ValueOf(Properties).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		if paramName(t.Field(i)) == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// paramNames returns names of all visible parameters in order
+func paramNames() []string {
+	t := reflect.TypeOf(Properties).Elem()
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name := paramName(t.Field(i))
+		if paramFlags[name]&flagHidden == 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setValue parses value according to the kind of field and stores it
+func setValue(fieldVal reflect.Value, value string, flags int) error {
+	switch fieldVal.Kind() {
+	case reflect.String:
+		fieldVal.SetString(value)
+	case reflect.Int, reflect.Int64:
+		var intValue int64
+		var err error
+		if flags&flagMemory > 0 {
+			intValue, err = parseMemory(value)
+		} else {
+			intValue, err = strconv.ParseInt(value, 10, 64)
+		}
+		if err != nil {
+			return err
+		}
+		fieldVal.SetInt(intValue)
+	case reflect.Bool:
+		fieldVal.SetBool("yes" == value)
+	case reflect.Slice:
+		if fieldVal.Type().Elem().Kind() == reflect.String {
+			fieldVal.Set(reflect.ValueOf(strings.Split(value, ",")))
+		}
+	}
+	return nil
+}
+
+func formatValue(fieldVal reflect.Value) string {
+	switch fieldVal.Kind() {
+	case reflect.String:
+		return fieldVal.String()
+	case reflect.Int, reflect.Int64:
+		return strconv.FormatInt(fieldVal.Int(), 10)
+	case reflect.Bool:
+		if fieldVal.Bool() {
+			return "yes"
+		}
+		return "no"
+	case reflect.Slice:
+		if fieldVal.Type().Elem().Kind() == reflect.String {
+			return strings.Join(fieldVal.Interface().([]string), ",")
+		}
+	}
+	return ""
+}
+
+// parseMemory parses sizes such as 1024, 1k, 1kb, 100mb or 1gb, k means 1000 and kb means 1024 like redis
+func parseMemory(value string) (int64, error) {
+	s := strings.ToLower(value)
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000}, {"b", 1},
+	}
+	mul := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSuffix(s, unit.suffix)
+			mul = unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("argument must be a memory value")
+	}
+	return n * mul, nil
+}
+
+// validate checks value and returns the parsed value to be stored in fieldVal
+func validate(name string, fieldVal reflect.Value, value string) (reflect.Value, error) {
+	flags := paramFlags[name]
+	if flags&flagMutable == 0 {
+		return reflect.Value{}, ErrImmutable
+	}
+	if allowed, ok := enumValues[name]; ok {
+		value = strings.ToLower(value)
+		found := false
+		for _, v := range allowed {
+			found = found || v == value
+		}
+		if !found {
+			return reflect.Value{}, errors.New("argument(s) must be one of the following: " + strings.Join(allowed, ", "))
+		}
+	}
+	if fieldVal.Kind() == reflect.Bool {
+		value = strings.ToLower(value)
+		if value != "yes" && value != "no" {
+			return reflect.Value{}, errors.New("argument must be 'yes' or 'no'")
+		}
+	}
+	parsed := reflect.New(fieldVal.Type()).Elem()
+	if err := setValue(parsed, value, flags); err != nil {
+		if flags&flagMemory > 0 {
+			return reflect.Value{}, err
+		}
+		return reflect.Value{}, errors.New("argument couldn't be parsed into an integer")
+	}
+	return parsed, nil
+}
+
+// Get returns names and values of parameters matching the glob pattern, in the form of [name1, value1, name2, value2...]
+func Get(pattern string) []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	p, err := wildcard.CompilePattern(strings.ToLower(pattern))
+	if err != nil {
+		return nil
+	}
+	var result []string
+	for _, name := range paramNames() {
+		if !p.IsMatch(name) {
+			continue
+		}
+		fieldVal, _ := lookupParam(name)
+		result = append(result, name, formatValue(fieldVal))
+	}
+	return result
+}
+
+// Set modifies parameters given in the form of [name1, value1, name2, value2...] and invokes their callbacks.
+// Either all parameters are modified or none of them.
+func Set(nameValues ...string) error {
+	if len(nameValues)%2 != 0 {
+		return errors.New("wrong number of arguments")
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	type change struct {
+		name     string
+		value    string
+		fieldVal reflect.Value
+		parsed   reflect.Value
+		old      reflect.Value
+	}
+	changes := make([]*change, 0, len(nameValues)/2)
+	seen := make(map[string]struct{})
+	for i := 0; i < len(nameValues); i += 2 {
+		name := strings.ToLower(nameValues[i])
+		fieldVal, ok := lookupParam(name)
+		if !ok {
+			return fmt.Errorf("Unknown option or number of arguments for CONFIG SET - '%s'", name)
+		}
+		if _, ok := seen[name]; ok {
+			return &ParamError{Name: name, Err: errors.New("duplicate parameter")}
+		}
+		seen[name] = struct{}{}
+		parsed, err := validate(name, fieldVal, nameValues[i+1])
+		if err != nil {
+			return &ParamError{Name: name, Err: err}
+		}
+		old := reflect.New(fieldVal.Type()).Elem()
+		old.Set(fieldVal)
+		changes = append(changes, &change{
+			name:     name,
+			fieldVal: fieldVal,
+			parsed:   parsed,
+			old:      old,
+			value:    formatValue(parsed),
+		})
+	}
+
+	for i, ch := range changes {
+		ch.fieldVal.Set(ch.parsed)
+		if err := invokeCallbacks(ch.name, ch.value); err != nil {
+			// revert applied changes in reverse order
+			for j := i; j >= 0; j-- {
+				changes[j].fieldVal.Set(changes[j].old)
+				_ = invokeCallbacks(changes[j].name, formatValue(changes[j].old))
+			}
+			return &ParamError{Name: ch.name, Err: err}
+		}
+	}
+	for _, ch := range changes {
+		modified[ch.name] = struct{}{}
+	}
+	return nil
+}
+
+func invokeCallbacks(name, value string) error {
+	for _, entry := range callbacks[name] {
+		if err := entry.fn(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RegisterCallback registers a callback invoked after the parameter is modified by Set,
+// the returned function unregisters it.
+func RegisterCallback(name string, fn Callback) func() {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	entry := &callbackEntry{fn: fn}
+	callbacks[name] = append(callbacks[name], entry)
+	return func() {
+		registryMu.Lock()
+		defer registryMu.Unlock()
+		entries := callbacks[name]
+		for i, e := range entries {
+			if e == entry {
+				callbacks[name] = append(entries[:i:i], entries[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func formatLine(name, value string) string {
+	if value == "" {
+		// lines without value are ignored by parse, so the parameter keeps its default value
+		return name
+	}
+	return name + " " + value
+}
+
+// Rewrite writes current parameters into the config file.
+// Lines of known parameters are updated in place, comments and other lines are preserved,
+// and parameters modified by Set but absent in the file are appended.
+func Rewrite() error {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if configFilePath == "" {
+		return errors.New("The server is running without a config file")
+	}
+	var lines []string
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(configFilePath); err == nil {
+		mode = info.Mode().Perm()
+	}
+	content, err := os.ReadFile(configFilePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(content) > 0 {
+		lines = strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	}
+
+	written := make(map[string]struct{})
+	hasMark := false
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == rewriteMark {
+			hasMark = true
+		}
+		if trimmed == "" || trimmed[0] == '#' {
+			out = append(out, line)
+			continue
+		}
+		name := strings.ToLower(strings.Fields(trimmed)[0])
+		fieldVal, ok := lookupParam(name)
+		if !ok || paramFlags[name]&flagMulti > 0 {
+			// multi directives are immutable, keep them as is
+			out = append(out, line)
+			continue
+		}
+		if _, ok := written[name]; ok {
+			continue // the last line wins in parse, so drop duplicated ones
+		}
+		written[name] = struct{}{}
+		out = append(out, formatLine(name, formatValue(fieldVal)))
+	}
+
+	var appended []string
+	for name := range modified {
+		if _, ok := written[name]; !ok {
+			appended = append(appended, name)
+		}
+	}
+	sort.Strings(appended)
+	if len(appended) > 0 && !hasMark {
+		out = append(out, rewriteMark)
+	}
+	for _, name := range appended {
+		fieldVal, _ := lookupParam(name)
+		out = append(out, formatLine(name, formatValue(fieldVal)))
+	}
+
+	// write a temp file then rename it, so that the config file is never half written
+	tmp, err := os.CreateTemp(filepath.Dir(configFilePath), "godis-config-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	_, err = tmp.WriteString(strings.Join(out, "\n") + "\n")
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), configFilePath)
+}
@@ -5,6 +5,8 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -49,6 +51,28 @@ func makeTestData(db database.DB, dbIndex int, prefix string, size int) {
 		cursor++
 		db.Exec(conn, utils.ToCmdLine("ZADD", key, "10", key))
 	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		db.Exec(conn, utils.ToCmdLine("BF.ADD", key, key))
+	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		db.Exec(conn, utils.ToCmdLine("CF.ADD", key, key))
+	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		db.Exec(conn, utils.ToCmdLine("CMS.INITBYDIM", key, "10", "2"))
+		db.Exec(conn, utils.ToCmdLine("CMS.INCRBY", key, key, "3"))
+	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		db.Exec(conn, utils.ToCmdLine("TOPK.RESERVE", key, "1"))
+		db.Exec(conn, utils.ToCmdLine("TOPK.ADD", key, key))
+	}
 }
 
 func validateTestData(t *testing.T, db database.DB, dbIndex int, prefix string, size int) {
@@ -95,6 +119,32 @@ func validateTestData(t *testing.T, db database.DB, dbIndex int, prefix string,
 		ret = db.Exec(conn, utils.ToCmdLine("ZRANGE", key, "0", "-1"))
 		asserts.AssertMultiBulkReply(t, ret, []string{key})
 	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		ret = db.Exec(conn, utils.ToCmdLine("BF.EXISTS", key, key))
+		asserts.AssertIntReply(t, ret, 1)
+	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		ret = db.Exec(conn, utils.ToCmdLine("CF.EXISTS", key, key))
+		asserts.AssertIntReply(t, ret, 1)
+	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		ret = db.Exec(conn, utils.ToCmdLine("CMS.QUERY", key, key))
+		if string(ret.ToBytes()) != "*1\r\n:3\r\n" {
+			t.Errorf("unexpected cms.query reply: %s", ret.ToBytes())
+		}
+	}
+	for i := 0; i < size; i++ {
+		key := prefix + strconv.Itoa(cursor)
+		cursor++
+		ret = db.Exec(conn, utils.ToCmdLine("TOPK.LIST", key))
+		asserts.AssertMultiBulkReply(t, ret, []string{key})
+	}
 }
 
 func TestAof(t *testing.T) {
@@ -170,6 +220,45 @@ func TestRDB(t *testing.T) {
 	readDB.Close()
 }
 
+func TestBGSaveWithoutAof(t *testing.T) {
+	rdbFilename := path.Join(t.TempDir(), "dump.rdb")
+	properties := config.Properties
+	defer func() {
+		config.Properties = properties
+	}()
+	config.Properties = &config.ServerProperties{
+		RDBFilename: rdbFilename,
+	}
+	dbNum := 4
+	size := 10
+	var prefixes []string
+	conn := connection.NewFakeConn()
+	writeDB := NewStandaloneServer()
+	for i := 0; i < dbNum; i++ {
+		prefix := utils.RandString(8)
+		prefixes = append(prefixes, prefix)
+		makeTestData(writeDB, i, prefix, size)
+	}
+	ret := writeDB.Exec(conn, utils.ToCmdLine("BGSAVE"))
+	asserts.AssertStatusReply(t, ret, "Background saving started")
+	for i := 0; atomic.LoadInt32(&writeDB.rdbSaving) == 1; i++ {
+		if i > 100 {
+			t.Fatal("bgsave timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	ret = writeDB.Exec(conn, utils.ToCmdLine("INFO", "persistence"))
+	if !strings.Contains(string(ret.ToBytes()), "rdb_last_bgsave_status:ok\r\n") {
+		t.Errorf("expect bgsave succeed, got %s", string(ret.ToBytes()))
+	}
+	writeDB.Close()
+	readDB := NewStandaloneServer() // start new db and read rdb file
+	defer readDB.Close()
+	for i := 0; i < dbNum; i++ {
+		validateTestData(t, readDB, i, prefixes[i], size)
+	}
+}
+
 func TestRewriteAOF(t *testing.T) {
 	tmpFile, err := os.CreateTemp(config.GetTmpDir(), "*.aof")
 	if err != nil {
@@ -196,7 +285,8 @@ func TestRewriteAOF(t *testing.T) {
 		makeTestData(aofWriteDB, i, prefix, size)
 	}
 	//time.Sleep(2 * time.Second)
-	aofWriteDB.Exec(nil, utils.ToCmdLine("rewriteaof"))
+	ret := aofWriteDB.Exec(connection.NewFakeConn(), utils.ToCmdLine("rewriteaof"))
+	asserts.AssertStatusReply(t, ret, "OK")
 	time.Sleep(2 * time.Second)        // wait for async goroutine finish its job
 	aofWriteDB.Close()                 // wait for aof finished
 	aofReadDB := NewStandaloneServer() // start new db and read aof file
@@ -285,3 +375,346 @@ func TestRewriteAOF2(t *testing.T) {
 	}
 	aofReadDB.Close()
 }
+
+func TestRewriteWithRdbPreamble(t *testing.T) {
+	aofFilename := path.Join(t.TempDir(), "a.aof")
+	properties := config.Properties
+	defer func() {
+		config.Properties = properties
+	}()
+	config.Properties = &config.ServerProperties{
+		AppendOnly:        true,
+		AppendFilename:    aofFilename,
+		AppendFsync:       aof.FsyncAlways,
+		AofUseRdbPreamble: true,
+	}
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	// rewrite twice, so the second rewrite has to load the preamble written by the first one
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 100; i++ {
+			key := strconv.Itoa(round) + ":" + strconv.Itoa(i)
+			server.Exec(conn, utils.ToCmdLine("SET", key, key))
+			server.Exec(conn, utils.ToCmdLine("RPUSH", "list", key))
+		}
+		ret := server.Exec(conn, utils.ToCmdLine("REWRITEAOF"))
+		asserts.AssertStatusReply(t, ret, "OK")
+	}
+	server.Exec(conn, utils.ToCmdLine("SET", "tail", "tail"))
+	server.Close()
+
+	data, err := os.ReadFile(aofFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "REDIS") {
+		t.Error("expect rdb preamble in rewritten aof file")
+	}
+
+	server = NewStandaloneServer()
+	defer server.Close()
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 100; i++ {
+			key := strconv.Itoa(round) + ":" + strconv.Itoa(i)
+			ret := server.Exec(conn, utils.ToCmdLine("GET", key))
+			asserts.AssertBulkReply(t, ret, key)
+		}
+	}
+	ret := server.Exec(conn, utils.ToCmdLine("LLEN", "list"))
+	asserts.AssertIntReply(t, ret, 200)
+	ret = server.Exec(conn, utils.ToCmdLine("GET", "tail"))
+	asserts.AssertBulkReply(t, ret, "tail")
+}
+
+func TestPersistenceInfo(t *testing.T) {
+	aofFilename := path.Join(t.TempDir(), "a.aof")
+	properties := config.Properties
+	defer func() {
+		config.Properties = properties
+	}()
+	config.Properties = &config.ServerProperties{
+		AppendOnly:     true,
+		AppendFilename: aofFilename,
+		AppendFsync:    aof.FsyncAlways,
+	}
+	server := NewStandaloneServer()
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "a"))
+	info, err := os.Stat(aofFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := server.Exec(conn, utils.ToCmdLine("INFO", "persistence"))
+	expected := []string{
+		"aof_enabled:1",
+		"aof_fsync:always",
+		"aof_last_write_status:ok",
+		"aof_current_size:" + strconv.FormatInt(info.Size(), 10),
+		"aof_base_size:0",
+	}
+	for _, line := range expected {
+		if !strings.Contains(string(ret.ToBytes()), line+"\r\n") {
+			t.Errorf("expect %s in INFO persistence", line)
+		}
+	}
+}
+
+func TestBGRewriteAOF(t *testing.T) {
+	aofFilename := path.Join(t.TempDir(), "a.aof")
+	properties := config.Properties
+	defer func() {
+		config.Properties = properties
+	}()
+	config.Properties = &config.ServerProperties{
+		AppendOnly:     true,
+		AppendFilename: aofFilename,
+		AppendFsync:    aof.FsyncAlways,
+	}
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	for i := 0; i < 10; i++ {
+		server.Exec(conn, utils.ToCmdLine("SET", "a", strconv.Itoa(i)))
+	}
+	ret := server.Exec(conn, utils.ToCmdLine("BGREWRITEAOF"))
+	asserts.AssertStatusReply(t, ret, "Background append only file rewriting started")
+	for i := 0; server.persister.Stats().RewriteInProgress; i++ {
+		if i > 100 {
+			t.Fatal("rewrite timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stats := server.persister.Stats()
+	if !stats.LastRewriteOK || stats.BaseSize == 0 || stats.CurrentSize < stats.BaseSize {
+		t.Errorf("wrong stats after rewrite: %+v", stats)
+	}
+	server.Close()
+
+	server = NewStandaloneServer()
+	defer server.Close()
+	ret = server.Exec(conn, utils.ToCmdLine("GET", "a"))
+	asserts.AssertBulkReply(t, ret, "9")
+}
+
+func TestLoadTruncatedAof(t *testing.T) {
+	aofFilename := path.Join(t.TempDir(), "a.aof")
+	properties := config.Properties
+	defer func() {
+		config.Properties = properties
+	}()
+	config.Properties = &config.ServerProperties{
+		AppendOnly:     true,
+		AppendFilename: aofFilename,
+		AppendFsync:    aof.FsyncAlways,
+	}
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	for i := 0; i < 10; i++ {
+		server.Exec(conn, utils.ToCmdLine("SET", strconv.Itoa(i), strconv.Itoa(i)))
+	}
+	server.Close()
+	info, err := os.Stat(aofFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validSize := info.Size()
+	file, err := os.OpenFile(aofFilename, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = file.WriteString("*3\r\n$3\r\nSET\r\n$1\r\na\r\n$3\r\nab")
+	_ = file.Close()
+
+	result, err := aof.CheckAof(aofFilename, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Err == nil || !result.Err.Truncated || result.Err.Offset != validSize ||
+		result.Err.Index != 11 || result.Commands != 10 || result.ValidSize != validSize {
+		t.Errorf("wrong check result: %+v %+v", result, result.Err)
+	}
+
+	// refuse to load
+	_, err = NewPersister(MakeAuxiliaryServer(), aofFilename, true, aof.FsyncNo)
+	if corrupt, ok := err.(*aof.CorruptError); !ok || corrupt.Offset != validSize || corrupt.Index != 11 {
+		t.Errorf("expect corrupt error at offset %d, got %v", validSize, err)
+	}
+
+	// truncate and load
+	config.Properties.AofLoadTruncated = true
+	server = NewStandaloneServer()
+	defer server.Close()
+	for i := 0; i < 10; i++ {
+		ret := server.Exec(conn, utils.ToCmdLine("GET", strconv.Itoa(i)))
+		asserts.AssertBulkReply(t, ret, strconv.Itoa(i))
+	}
+	ret := server.Exec(conn, utils.ToCmdLine("GET", "a"))
+	asserts.AssertNullBulk(t, ret)
+	info, err = os.Stat(aofFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != validSize {
+		t.Errorf("expect aof file truncated to %d, actual %d", validSize, info.Size())
+	}
+}
+
+func TestLoadCorruptAof(t *testing.T) {
+	aofFilename := path.Join(t.TempDir(), "a.aof")
+	properties := config.Properties
+	defer func() {
+		config.Properties = properties
+	}()
+	config.Properties = &config.ServerProperties{
+		Databases:        16,
+		AppendOnly:       true,
+		AppendFilename:   aofFilename,
+		AofLoadTruncated: true,
+	}
+	valid := "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\na\r\n"
+	err := os.WriteFile(aofFilename, []byte(valid+"*3\r\n$3\r\nSET\r\nxx\r\n"+valid), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// corruption in the middle of file is never truncated
+	_, err = NewPersister(MakeAuxiliaryServer(), aofFilename, true, aof.FsyncNo)
+	corrupt, ok := err.(*aof.CorruptError)
+	if !ok || corrupt.Truncated || corrupt.Offset != int64(len(valid)) || corrupt.Index != 2 {
+		t.Errorf("expect corrupt error at offset %d, got %v", len(valid), err)
+	}
+}
+
+func TestAofTimestamp(t *testing.T) {
+	aofFilename := path.Join(t.TempDir(), "a.aof")
+	properties := config.Properties
+	defer func() {
+		config.Properties = properties
+	}()
+	config.Properties = &config.ServerProperties{
+		AppendOnly:          true,
+		AppendFilename:      aofFilename,
+		AppendFsync:         aof.FsyncAlways,
+		AofTimestampEnabled: true,
+	}
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "a"))
+	server.Exec(conn, utils.ToCmdLine("SET", "b", "b"))
+	server.Close()
+	data, err := os.ReadFile(aofFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "#TS:") {
+		t.Errorf("expect timestamp annotation, got %s", string(data))
+	}
+	server = NewStandaloneServer()
+	ret := server.Exec(conn, utils.ToCmdLine("GET", "b"))
+	asserts.AssertBulkReply(t, ret, "b")
+	server.Close()
+}
+
+func TestLoadAofUntilTimestamp(t *testing.T) {
+	aofFilename := path.Join(t.TempDir(), "a.aof")
+	properties := config.Properties
+	defer func() {
+		config.Properties = properties
+	}()
+	config.Properties = &config.ServerProperties{
+		AppendOnly:            true,
+		AppendFilename:        aofFilename,
+		AofLoadUntilTimestamp: 150,
+	}
+	set := string(protocol.MakeMultiBulkReply(utils.ToCmdLine("SET", "a", "a")).ToBytes())
+	flush := string(protocol.MakeMultiBulkReply(utils.ToCmdLine("FLUSHALL")).ToBytes())
+	before := string(aof.MakeTimestampAnnotation(100)) + set + string(aof.MakeTimestampAnnotation(150))
+	err := os.WriteFile(aofFilename, []byte(before+string(aof.MakeTimestampAnnotation(200))+flush), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := aof.CheckAof(aofFilename, 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.TimestampReached || result.ValidSize != int64(len(before)) || result.Commands != 1 {
+		t.Errorf("wrong check result: %+v", result)
+	}
+
+	server := NewStandaloneServer()
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	ret := server.Exec(conn, utils.ToCmdLine("GET", "a"))
+	asserts.AssertBulkReply(t, ret, "a")
+	info, err := os.Stat(aofFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(before)) {
+		t.Errorf("expect aof file truncated to %d, actual %d", len(before), info.Size())
+	}
+}
+
+func TestInspectAofAndRDB(t *testing.T) {
+	dir := t.TempDir()
+	aofFilename := path.Join(dir, "a.aof")
+	rdbFilename := path.Join(dir, "a.rdb")
+	properties := config.Properties
+	defer func() {
+		config.Properties = properties
+	}()
+	config.Properties = &config.ServerProperties{
+		AppendOnly:     true,
+		AppendFilename: aofFilename,
+		AppendFsync:    aof.FsyncAlways,
+		Databases:      16,
+	}
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "str", "hello"))
+	server.Exec(conn, utils.ToCmdLine("RPUSH", "list", "a", "b", "c"))
+	server.Exec(conn, utils.ToCmdLine("EXPIRE", "list", "1000"))
+	server.Exec(conn, utils.ToCmdLine("SELECT", "1"))
+	server.Exec(conn, utils.ToCmdLine("SADD", "set", "a", "b"))
+	if err := aof.SaveRDB(rdbFilename, server); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+	size, _ := os.Stat(aofFilename)
+
+	check := func(infos map[string]*aof.KeyInfo) {
+		if len(infos) != 3 {
+			t.Fatalf("expect 3 keys, actual %d", len(infos))
+		}
+		if info := infos["list"]; info.Type != "list" || info.Elements != 3 || info.ExpireAt == nil || info.DB != 0 {
+			t.Errorf("wrong info of list: %+v", info)
+		}
+		if info := infos["str"]; info.Type != "string" || info.Elements != 1 || info.ExpireAt != nil || info.Size == 0 {
+			t.Errorf("wrong info of string: %+v", info)
+		}
+		if info := infos["set"]; info.Type != "set" || info.Elements != 2 || info.DB != 1 {
+			t.Errorf("wrong info of set: %+v", info)
+		}
+	}
+	infos := make(map[string]*aof.KeyInfo)
+	err := aof.InspectAof(aofFilename, MakeAuxiliaryServer(), func(info *aof.KeyInfo) bool {
+		infos[info.Key] = info
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(infos)
+	if after, _ := os.Stat(aofFilename); after.Size() != size.Size() {
+		t.Error("aof file should not be modified")
+	}
+
+	infos = make(map[string]*aof.KeyInfo)
+	err = aof.InspectRDB(rdbFilename, func(info *aof.KeyInfo) bool {
+		infos[info.Key] = info
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(infos)
+}
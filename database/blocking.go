@@ -0,0 +1,226 @@
+package database
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/timewheel"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+// blockingWaiter is a connection suspended by a blocking command
+type blockingWaiter struct {
+	ready  chan struct{} // notified when one of the keys may be served
+	closed chan struct{} // closed when the connection is closed
+}
+
+// blockingRegistry records connections blocked on keys of a db.
+// Only the earliest waiter of a key is notified when the key is ready, so that waiters are served in FIFO order.
+// It is shared by all DB instances of the same index, so that blocked connections survive FLUSHDB
+type blockingRegistry struct {
+	mu      sync.Mutex
+	waiters map[string][]*blockingWaiter
+	count   int32 // number of registered keys, signal skips locking if it is 0
+}
+
+func makeBlockingRegistry() *blockingRegistry {
+	return &blockingRegistry{
+		waiters: make(map[string][]*blockingWaiter),
+	}
+}
+
+func (registry *blockingRegistry) add(keys []string, waiter *blockingWaiter) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	for _, key := range keys {
+		registry.waiters[key] = append(registry.waiters[key], waiter)
+		atomic.AddInt32(&registry.count, 1)
+	}
+}
+
+func (registry *blockingRegistry) remove(keys []string, waiter *blockingWaiter) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	for _, key := range keys {
+		waiters := registry.waiters[key]
+		for i, w := range waiters {
+			if w == waiter {
+				waiters = append(waiters[:i], waiters[i+1:]...)
+				atomic.AddInt32(&registry.count, -1)
+				break
+			}
+		}
+		if len(waiters) == 0 {
+			delete(registry.waiters, key)
+		} else {
+			registry.waiters[key] = waiters
+		}
+	}
+}
+
+// keys returns keys which connections are blocked on
+func (registry *blockingRegistry) keys() []string {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	keys := make([]string, 0, len(registry.waiters))
+	for key := range registry.waiters {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// signal wakes up the earliest waiter of key
+func (registry *blockingRegistry) signal(key string) {
+	if registry == nil || atomic.LoadInt32(&registry.count) == 0 {
+		return
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	waiters := registry.waiters[key]
+	if len(waiters) == 0 {
+		return
+	}
+	select {
+	case waiters[0].ready <- struct{}{}:
+	default: // already notified
+	}
+}
+
+// genBlockingTimeoutTask returns key of the timewheel task ending the blocking command of c,
+// a connection blocks on one command at most
+func genBlockingTimeoutTask(c redis.Connection) string {
+	return "blocking:" + strconv.FormatUint(c.ID(), 10)
+}
+
+// parseBlockingTimeout parses timeout in seconds of blocking commands, 0 means blocking indefinitely
+func parseBlockingTimeout(arg []byte) (time.Duration, protocol.ErrorReply) {
+	seconds, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, protocol.MakeErrReply("ERR timeout is not a float or out of range")
+	}
+	if seconds < 0 {
+		return 0, protocol.MakeErrReply("ERR timeout is negative")
+	}
+	if seconds > float64(math.MaxInt64/int64(time.Second)) {
+		return 0, protocol.MakeErrReply("ERR timeout is out of range")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// isBlockingNilReply returns whether a blocking command has nothing to serve and should block
+func isBlockingNilReply(reply redis.Reply) bool {
+	switch reply.(type) {
+	case *protocol.NullBulkReply, *protocol.NullMultiBulkReply:
+		return true
+	}
+	return false
+}
+
+// execBlocking executes a blocking command. The executor of the command is a non-blocking version which returns
+// nil if there is nothing to serve, in that case the connection waits for related keys being ready or timeout.
+// Within MULTI the executor is invoked directly, so blocking commands return nil immediately like redis.
+func (server *Server) execBlocking(c redis.Connection, cmd *command, cmdLine [][]byte) redis.Reply {
+	if !validateArity(cmd.arity, cmdLine) {
+		return protocol.MakeArgNumErrReply(cmd.name)
+	}
+	args := cmdLine[1:]
+	timeoutIndex := cmd.timeoutIndex
+	if timeoutIndex < 0 {
+		timeoutIndex += len(args)
+	}
+	timeout, errReply := parseBlockingTimeout(args[timeoutIndex])
+	if errReply != nil {
+		return errReply
+	}
+	var deadline chan struct{}
+	if timeout > 0 {
+		deadline = make(chan struct{})
+		taskKey := genBlockingTimeoutTask(c)
+		timewheel.Delay(timeout, taskKey, func() {
+			close(deadline)
+		})
+		defer timewheel.Cancel(taskKey)
+	}
+
+	waiter := &blockingWaiter{
+		ready:  make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+	server.blockedConns.Store(c, waiter)
+	defer server.blockedConns.Delete(c)
+	var registry *blockingRegistry
+	var keys []string
+	defer func() {
+		if registry == nil {
+			return
+		}
+		registry.remove(keys, waiter)
+		// the notification may be consumed by this waiter, pass it to the next one
+		for _, key := range keys {
+			registry.signal(key)
+		}
+	}()
+
+	for {
+		server.dbLock.RLock()
+		db, errReply := server.selectDB(c.GetDBIndex())
+		if errReply != nil {
+			server.dbLock.RUnlock()
+			return errReply
+		}
+		reply, served := db.tryServeBlocking(c, cmd, args, func(db *DB) {
+			if registry != nil {
+				return
+			}
+			registry = db.blocking
+			if cmd.blockingKeys != nil {
+				keys = cmd.blockingKeys(args)
+			} else {
+				keys, _ = cmd.prepare(args)
+			}
+			registry.add(keys, waiter)
+		})
+		server.dbLock.RUnlock()
+		if served {
+			return reply
+		}
+		select {
+		case <-waiter.ready:
+		case <-deadline:
+			return reply
+		case <-waiter.closed:
+			return reply
+		}
+	}
+}
+
+// tryServeBlocking executes the non-blocking executor of a blocking command with locks held.
+// If there is nothing to serve, register is called before releasing locks, so that pushes could not happen
+// before the connection is registered as a waiter
+func (db *DB) tryServeBlocking(c redis.Connection, cmd *command, args [][]byte, register func(db *DB)) (redis.Reply, bool) {
+	write, read := cmd.prepare(args)
+	db.RWLocks(write, read)
+	defer db.RWUnLocks(write, read)
+	states := db.beforeWrite(cmd, args)
+	reply := cmd.executor(db, args)
+	if isBlockingNilReply(reply) {
+		register(db)
+		return reply, false
+	}
+	// versions are bumped only when served, waiting changes nothing and must not abort transactions watching the keys
+	db.addVersion(write...)
+	db.afterWrite(cmd, states, reply)
+	db.tracking.invalidate(c, write)
+	return reply, true
+}
+
+// unblockClient wakes up the connection if it is blocked, the blocking command returns nil
+func (server *Server) unblockClient(c redis.Connection) {
+	if raw, ok := server.blockedConns.LoadAndDelete(c); ok {
+		close(raw.(*blockingWaiter).closed)
+	}
+}
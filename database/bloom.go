@@ -0,0 +1,161 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/hdt3213/godis/datastruct/bloom"
+	"github.com/hdt3213/godis/interface/database"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+// getAsBloomFilter returns filter bound to the given key, the filter is stored as a string payload.
+// If forUpdate is true, the filter is restored from a copy of payload so that it could be modified
+func (db *DB) getAsBloomFilter(key string, forUpdate bool) (*bloom.ScalableFilter, protocol.ErrorReply) {
+	getPayload := db.getAsString
+	if forUpdate {
+		getPayload = db.getAsStringForUpdate
+	}
+	payload, errReply := getPayload(key)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if payload == nil {
+		return nil, nil
+	}
+	filter, err := bloom.FromBytes(payload)
+	if err != nil {
+		return nil, &protocol.WrongTypeErrReply{}
+	}
+	return filter, nil
+}
+
+func (db *DB) getOrInitBloomFilter(key string) (*bloom.ScalableFilter, protocol.ErrorReply) {
+	filter, errReply := db.getAsBloomFilter(key, true)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if filter == nil {
+		filter, _ = bloom.Make(bloom.DefaultErrorRate, bloom.DefaultCapacity, bloom.DefaultExpansion, false)
+	}
+	return filter, nil
+}
+
+// execBFReserve creates an empty bloom filter with the given error rate and capacity
+func execBFReserve(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	errorRate, err := strconv.ParseFloat(string(args[1]), 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR bad error rate")
+	}
+	if errorRate <= 0 || errorRate >= 1 {
+		return protocol.MakeErrReply("ERR (0 < error rate range < 1)")
+	}
+	capacity, err := strconv.ParseUint(string(args[2]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR bad capacity")
+	}
+	if capacity == 0 {
+		return protocol.MakeErrReply("ERR (capacity should be larger than 0)")
+	}
+	var expansion uint64 = bloom.DefaultExpansion
+	nonScaling := false
+	for i := 3; i < len(args); i++ {
+		arg := strings.ToUpper(string(args[i]))
+		if arg == "NONSCALING" {
+			nonScaling = true
+		} else if arg == "EXPANSION" && i+1 < len(args) {
+			expansion, err = strconv.ParseUint(string(args[i+1]), 10, 32)
+			if err != nil || expansion == 0 {
+				return protocol.MakeErrReply("ERR bad expansion")
+			}
+			i++
+		} else {
+			return protocol.MakeSyntaxErrReply()
+		}
+	}
+
+	_, exists := db.GetEntity(key)
+	if exists {
+		return protocol.MakeErrReply("ERR item exists")
+	}
+	filter, err := bloom.Make(errorRate, capacity, uint32(expansion), nonScaling)
+	if err != nil {
+		return protocol.MakeErrReply(err.Error())
+	}
+	db.PutEntity(key, &database.DataEntity{Data: filter.ToBytes()})
+	db.addAof(utils.ToCmdLine3("bf.reserve", args...))
+	return protocol.MakeOkReply()
+}
+
+// execBFAdd adds an item into bloom filter, creates the filter with default options if not exists
+func execBFAdd(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	filter, errReply := db.getOrInitBloomFilter(key)
+	if errReply != nil {
+		return errReply
+	}
+	added, err := filter.Add(args[1])
+	if err != nil {
+		return protocol.MakeErrReply(err.Error())
+	}
+	db.PutEntity(key, &database.DataEntity{Data: filter.ToBytes()})
+	if added {
+		db.addAof(utils.ToCmdLine3("bf.add", args...))
+		return protocol.MakeIntReply(1)
+	}
+	return protocol.MakeIntReply(0)
+}
+
+// execBFMAdd adds multiple items into bloom filter
+func execBFMAdd(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	filter, errReply := db.getOrInitBloomFilter(key)
+	if errReply != nil {
+		return errReply
+	}
+	results := make([]redis.Reply, 0, len(args)-1)
+	anyAdded := false
+	for _, item := range args[1:] {
+		added, err := filter.Add(item)
+		if err != nil {
+			results = append(results, protocol.MakeErrReply(err.Error()))
+		} else if added {
+			anyAdded = true
+			results = append(results, protocol.MakeIntReply(1))
+		} else {
+			results = append(results, protocol.MakeIntReply(0))
+		}
+	}
+	db.PutEntity(key, &database.DataEntity{Data: filter.ToBytes()})
+	if anyAdded {
+		db.addAof(utils.ToCmdLine3("bf.madd", args...))
+	}
+	return protocol.MakeMultiRawReply(results)
+}
+
+// execBFExists checks whether an item may exist in bloom filter
+func execBFExists(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	filter, errReply := db.getAsBloomFilter(key, false)
+	if errReply != nil {
+		return errReply
+	}
+	if filter == nil || !filter.Exists(args[1]) {
+		return protocol.MakeIntReply(0)
+	}
+	return protocol.MakeIntReply(1)
+}
+
+func init() {
+	registerCommand("BF.Reserve", execBFReserve, writeFirstKey, rollbackFirstKey, -4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+	registerCommand("BF.Add", execBFAdd, writeFirstKey, rollbackInPlaceString, 3, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+	registerCommand("BF.MAdd", execBFMAdd, writeFirstKey, rollbackInPlaceString, -3, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+	registerCommand("BF.Exists", execBFExists, readFirstKey, nil, 3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestBFReserve(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	result := testDB.Exec(nil, utils.ToCmdLine("BF.RESERVE", key, "0.01", "1000"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testDB.Exec(nil, utils.ToCmdLine("BF.RESERVE", key, "0.01", "1000"))
+	asserts.AssertErrReply(t, result, "ERR item exists")
+	result = testDB.Exec(nil, utils.ToCmdLine("BF.RESERVE", utils.RandString(10), "1.5", "1000"))
+	asserts.AssertErrReply(t, result, "ERR (0 < error rate range < 1)")
+	result = testDB.Exec(nil, utils.ToCmdLine("BF.RESERVE", utils.RandString(10), "0.01", "0"))
+	asserts.AssertErrReply(t, result, "ERR (capacity should be larger than 0)")
+	result = testDB.Exec(nil, utils.ToCmdLine("BF.RESERVE", utils.RandString(10), "0.01", "10", "EXPANSION", "4", "NONSCALING"))
+	asserts.AssertStatusReply(t, result, "OK")
+}
+
+func TestBFAdd(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	result := testDB.Exec(nil, utils.ToCmdLine("BF.ADD", key, "a"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("BF.ADD", key, "a"))
+	asserts.AssertIntReply(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("BF.EXISTS", key, "a"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("BF.EXISTS", key, "b"))
+	asserts.AssertIntReply(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("BF.MADD", key, "a", "b", "c"))
+	if string(result.ToBytes()) != "*3\r\n:0\r\n:1\r\n:1\r\n" {
+		t.Errorf("unexpected reply: %s", result.ToBytes())
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("BF.EXISTS", utils.RandString(10), "a"))
+	asserts.AssertIntReply(t, result, 0)
+
+	// filter is persisted as a plain string
+	result = testDB.Exec(nil, utils.ToCmdLine("TYPE", key))
+	asserts.AssertStatusReply(t, result, "string")
+
+	strKey := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("SET", strKey, "hello"))
+	result = testDB.Exec(nil, utils.ToCmdLine("BF.ADD", strKey, "a"))
+	asserts.AssertErrReply(t, result, "WRONGTYPE Operation against a key holding the wrong kind of value")
+}
+
+func TestUndoBFAdd(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("BF.ADD", key, "a"))
+	undoCmdLines := rollbackInPlaceString(testDB, utils.ToCmdLine(key, "b"))
+	testDB.Exec(nil, utils.ToCmdLine("BF.ADD", key, "b"))
+	for _, cmdLine := range undoCmdLines {
+		testDB.Exec(nil, cmdLine)
+	}
+	result := testDB.Exec(nil, utils.ToCmdLine("BF.EXISTS", key, "b"))
+	asserts.AssertIntReply(t, result, 0)
+}
+
+func TestBFAddAfterCopy(t *testing.T) {
+	c := connection.NewFakeConn()
+	src, dest := utils.RandString(10), utils.RandString(10)
+	testServer.Exec(c, utils.ToCmdLine("BF.RESERVE", src, "0.01", "100"))
+	result := testServer.Exec(c, utils.ToCmdLine("COPY", src, dest))
+	asserts.AssertIntReply(t, result, 1)
+	result = testServer.Exec(c, utils.ToCmdLine("BF.ADD", dest, "x"))
+	asserts.AssertIntReply(t, result, 1)
+	// filters share nothing after COPY
+	result = testServer.Exec(c, utils.ToCmdLine("BF.EXISTS", src, "x"))
+	asserts.AssertIntReply(t, result, 0)
+	result = testServer.Exec(c, utils.ToCmdLine("BF.EXISTS", dest, "x"))
+	asserts.AssertIntReply(t, result, 1)
+	testServer.Exec(c, utils.ToCmdLine("DEL", src, dest))
+}
@@ -0,0 +1,293 @@
+package database
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+// execClient handles subcommands of CLIENT
+func (server *Server) execClient(c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.MakeArgNumErrReply("client")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "id":
+		if len(args) != 1 {
+			return protocol.MakeArgNumErrReply("client|id")
+		}
+		return protocol.MakeIntReply(int64(c.ID()))
+	case "setname":
+		if len(args) != 2 {
+			return protocol.MakeArgNumErrReply("client|setname")
+		}
+		return execClientSetName(c, string(args[1]))
+	case "getname":
+		if len(args) != 1 {
+			return protocol.MakeArgNumErrReply("client|getname")
+		}
+		name := c.GetName()
+		if name == "" {
+			return protocol.MakeNullBulkReply()
+		}
+		return protocol.MakeBulkReply([]byte(name))
+	case "info":
+		if len(args) != 1 {
+			return protocol.MakeArgNumErrReply("client|info")
+		}
+		return protocol.MakeBulkReply([]byte(server.clientInfo(c) + "\n"))
+	case "list":
+		return server.execClientList(args[1:])
+	case "kill":
+		return server.execClientKill(c, args[1:])
+	case "pause":
+		return server.execClientPause(args[1:])
+	case "unpause":
+		if len(args) != 1 {
+			return protocol.MakeArgNumErrReply("client|unpause")
+		}
+		server.pause.unpause()
+		return protocol.MakeOkReply()
+	case "tracking":
+		return server.execClientTracking(c, args[1:])
+	case "caching":
+		return server.execClientCaching(c, args[1:])
+	case "getredir":
+		if len(args) != 1 {
+			return protocol.MakeArgNumErrReply("client|getredir")
+		}
+		return server.execClientGetRedir(c)
+	}
+	return protocol.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try CLIENT HELP.")
+}
+
+func execClientSetName(c redis.Connection, name string) redis.Reply {
+	for _, ch := range name {
+		// same as redis, names are shown in CLIENT LIST separated by spaces
+		if ch < '!' || ch > '~' {
+			return protocol.MakeErrReply("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+	}
+	c.SetName(name)
+	return protocol.MakeOkReply()
+}
+
+// clientType returns type of client used by CLIENT LIST TYPE and CLIENT KILL TYPE
+func clientType(c redis.Connection) string {
+	if c.IsMaster() {
+		return "master"
+	}
+	if c.IsSlave() {
+		return "replica"
+	}
+	if c.SubsCount() > 0 || len(c.GetShardChannels()) > 0 {
+		return "pubsub"
+	}
+	return "normal"
+}
+
+func parseClientType(name string) (string, bool) {
+	name = strings.ToLower(name)
+	switch name {
+	case "normal", "master", "replica", "pubsub":
+		return name, true
+	case "slave":
+		return "replica", true
+	}
+	return "", false
+}
+
+// clientInfo returns a line of CLIENT LIST
+func (server *Server) clientInfo(c redis.Connection) string {
+	now := time.Now()
+	lastCmd, lastInteraction := c.GetLastCmd()
+	flags := ""
+	if c.IsSlave() {
+		flags += "S"
+	}
+	if c.IsMaster() {
+		flags += "M"
+	}
+	if c.SubsCount() > 0 || len(c.GetShardChannels()) > 0 {
+		flags += "P"
+	}
+	if c.InMultiState() {
+		flags += "x"
+	}
+	if c.IsReadOnly() {
+		flags += "r"
+	}
+	if server.tracking.getClient(c) != nil {
+		flags += "t"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	multi := -1
+	if c.InMultiState() {
+		multi = len(c.GetQueuedCmdLine())
+	}
+	return "id=" + strconv.FormatUint(c.ID(), 10) +
+		" addr=" + c.RemoteAddr() +
+		" laddr=" + c.LocalAddr() +
+		" name=" + c.GetName() +
+		" age=" + strconv.Itoa(int(now.Sub(c.CreatedAt()).Seconds())) +
+		" idle=" + strconv.Itoa(int(now.Sub(lastInteraction).Seconds())) +
+		" flags=" + flags +
+		" db=" + strconv.Itoa(c.GetDBIndex()) +
+		" sub=" + strconv.Itoa(len(c.GetChannels())) +
+		" psub=" + strconv.Itoa(len(c.GetPatterns())) +
+		" ssub=" + strconv.Itoa(len(c.GetShardChannels())) +
+		" multi=" + strconv.Itoa(multi) +
+		" cmd=" + lastCmd
+}
+
+// getClients returns all connected clients sorted by id
+func (server *Server) getClients() []redis.Connection {
+	var clients []redis.Connection
+	server.clients.Range(func(key, value interface{}) bool {
+		clients = append(clients, value.(redis.Connection))
+		return true
+	})
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ID() < clients[j].ID()
+	})
+	return clients
+}
+
+// execClientList handles CLIENT LIST [TYPE normal|master|replica|pubsub] [ID client-id ...]
+func (server *Server) execClientList(args [][]byte) redis.Reply {
+	var typ string
+	var ids map[uint64]struct{}
+	if len(args) > 0 {
+		switch strings.ToLower(string(args[0])) {
+		case "type":
+			if len(args) != 2 {
+				return &protocol.SyntaxErrReply{}
+			}
+			var ok bool
+			typ, ok = parseClientType(string(args[1]))
+			if !ok {
+				return protocol.MakeErrReply("ERR Unknown client type '" + string(args[1]) + "'")
+			}
+		case "id":
+			if len(args) < 2 {
+				return &protocol.SyntaxErrReply{}
+			}
+			ids = make(map[uint64]struct{})
+			for _, arg := range args[1:] {
+				id, err := strconv.ParseUint(string(arg), 10, 64)
+				if err != nil || id == 0 {
+					return protocol.MakeErrReply("ERR Invalid client ID")
+				}
+				ids[id] = struct{}{}
+			}
+		default:
+			return &protocol.SyntaxErrReply{}
+		}
+	}
+	var sb strings.Builder
+	for _, client := range server.getClients() {
+		if typ != "" && clientType(client) != typ {
+			continue
+		}
+		if ids != nil {
+			if _, ok := ids[client.ID()]; !ok {
+				continue
+			}
+		}
+		sb.WriteString(server.clientInfo(client))
+		sb.WriteString("\n")
+	}
+	return protocol.MakeBulkReply([]byte(sb.String()))
+}
+
+// killClient disconnects client, c is the client sending CLIENT KILL
+func killClient(c redis.Connection, client redis.Connection) {
+	// killing itself, send reply before closing connection
+	client.Kill(client == c)
+}
+
+// execClientKill handles CLIENT KILL addr:port, and
+// CLIENT KILL [ID client-id] [TYPE type] [ADDR addr:port] [LADDR addr:port] [SKIPME yes|no] [MAXAGE seconds]
+func (server *Server) execClientKill(c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.MakeArgNumErrReply("client|kill")
+	}
+	if len(args) == 1 {
+		// old form
+		addr := string(args[0])
+		for _, client := range server.getClients() {
+			if client.RemoteAddr() == addr {
+				killClient(c, client)
+				return protocol.MakeOkReply()
+			}
+		}
+		return protocol.MakeErrReply("ERR No such client")
+	}
+	if len(args)%2 != 0 {
+		return &protocol.SyntaxErrReply{}
+	}
+	var id uint64
+	var typ, addr, laddr string
+	var maxAge int64
+	skipMe := true
+	for i := 0; i < len(args); i += 2 {
+		value := string(args[i+1])
+		switch strings.ToLower(string(args[i])) {
+		case "id":
+			var err error
+			id, err = strconv.ParseUint(value, 10, 64)
+			if err != nil || id == 0 {
+				return protocol.MakeErrReply("ERR client-id should be greater than 0")
+			}
+		case "type":
+			var ok bool
+			typ, ok = parseClientType(value)
+			if !ok {
+				return protocol.MakeErrReply("ERR Unknown client type '" + value + "'")
+			}
+		case "addr":
+			addr = value
+		case "laddr":
+			laddr = value
+		case "skipme":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return &protocol.SyntaxErrReply{}
+			}
+		case "maxage":
+			var err error
+			maxAge, err = strconv.ParseInt(value, 10, 64)
+			if err != nil || maxAge <= 0 {
+				return &protocol.SyntaxErrReply{}
+			}
+		default:
+			return &protocol.SyntaxErrReply{}
+		}
+	}
+	killed := 0
+	now := time.Now()
+	for _, client := range server.getClients() {
+		if (id != 0 && client.ID() != id) ||
+			(typ != "" && clientType(client) != typ) ||
+			(addr != "" && client.RemoteAddr() != addr) ||
+			(laddr != "" && client.LocalAddr() != laddr) ||
+			(maxAge > 0 && int64(now.Sub(client.CreatedAt()).Seconds()) < maxAge) ||
+			(skipMe && client == c) {
+			continue
+		}
+		killClient(c, client)
+		killed++
+	}
+	return protocol.MakeIntReply(int64(killed))
+}
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestClientName(t *testing.T) {
+	c := connection.NewFakeConn()
+	defer testServer.AfterClientClose(c)
+	result := testServer.Exec(c, utils.ToCmdLine("client", "getname"))
+	asserts.AssertNullBulk(t, result)
+	result = testServer.Exec(c, utils.ToCmdLine("client", "setname", "a b"))
+	asserts.AssertErrReply(t, result, "ERR Client names cannot contain spaces, newlines or special characters.")
+	result = testServer.Exec(c, utils.ToCmdLine("client", "setname", "worker"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testServer.Exec(c, utils.ToCmdLine("client", "getname"))
+	asserts.AssertBulkReply(t, result, "worker")
+}
+
+func TestClientList(t *testing.T) {
+	c := connection.NewFakeConn()
+	sub := connection.NewFakeConn()
+	defer testServer.AfterClientClose(c)
+	defer testServer.AfterClientClose(sub)
+	testServer.Exec(c, utils.ToCmdLine("client", "setname", "worker"))
+	testServer.Exec(sub, utils.ToCmdLine("subscribe", "ch"))
+	id := strconv.FormatUint(c.ID(), 10)
+	subID := strconv.FormatUint(sub.ID(), 10)
+
+	result := testServer.Exec(c, utils.ToCmdLine("client", "info"))
+	info := string(result.(*protocol.BulkReply).Arg)
+	for _, field := range []string{"id=" + id + " ", "name=worker", "flags=N", "db=0", "cmd=client\n"} {
+		if !strings.Contains(info, field) {
+			t.Errorf("expect %s in client info: %s", field, info)
+		}
+	}
+
+	result = testServer.Exec(c, utils.ToCmdLine("client", "list", "id", id, subID))
+	lines := strings.Split(strings.TrimSpace(string(result.(*protocol.BulkReply).Arg)), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "id="+id+" ") || !strings.HasPrefix(lines[1], "id="+subID+" ") {
+		t.Errorf("wrong client list: %v", lines)
+	}
+	if !strings.Contains(lines[1], "flags=P") || !strings.Contains(lines[1], "sub=1") {
+		t.Errorf("wrong pubsub client info: %s", lines[1])
+	}
+	result = testServer.Exec(c, utils.ToCmdLine("client", "list", "type", "pubsub"))
+	if strings.Contains(string(result.(*protocol.BulkReply).Arg), "id="+id+" ") {
+		t.Error("normal client should not be listed as pubsub")
+	}
+	result = testServer.Exec(c, utils.ToCmdLine("client", "list", "type", "x"))
+	asserts.AssertErrReply(t, result, "ERR Unknown client type 'x'")
+}
+
+func TestClientKill(t *testing.T) {
+	c := connection.NewFakeConn()
+	victim := connection.NewFakeConn()
+	defer testServer.AfterClientClose(c)
+	defer testServer.AfterClientClose(victim)
+	testServer.Exec(victim, utils.ToCmdLine("ping"))
+	id := strconv.FormatUint(c.ID(), 10)
+	victimID := strconv.FormatUint(victim.ID(), 10)
+
+	result := testServer.Exec(c, utils.ToCmdLine("client", "kill", "1.1.1.1:1"))
+	asserts.AssertErrReply(t, result, "ERR No such client")
+	result = testServer.Exec(c, utils.ToCmdLine("client", "kill", "id", id))
+	asserts.AssertIntReply(t, result, 0) // skip itself by default
+	result = testServer.Exec(c, utils.ToCmdLine("client", "kill", "id", victimID, "type", "pubsub"))
+	asserts.AssertIntReply(t, result, 0)
+	result = testServer.Exec(c, utils.ToCmdLine("client", "kill", "id", victimID))
+	asserts.AssertIntReply(t, result, 1)
+	if _, err := victim.Write([]byte("+OK\r\n")); err == nil {
+		t.Error("victim should be closed")
+	}
+	result = testServer.Exec(c, utils.ToCmdLine("client", "kill", "id", "0"))
+	asserts.AssertErrReply(t, result, "ERR client-id should be greater than 0")
+	result = testServer.Exec(c, utils.ToCmdLine("client", "kill", "id", id, "skipme"))
+	asserts.AssertErrReply(t, result, "Err syntax error")
+}
+
+func TestClientPause(t *testing.T) {
+	c := connection.NewFakeConn()
+	defer testServer.AfterClientClose(c)
+	result := testServer.Exec(c, utils.ToCmdLine("client", "pause", "-1"))
+	asserts.AssertErrReply(t, result, "ERR timeout is negative")
+	result = testServer.Exec(c, utils.ToCmdLine("client", "pause", "100", "x"))
+	asserts.AssertErrReply(t, result, "Err syntax error")
+
+	// writes are paused until timeout
+	result = testServer.Exec(c, utils.ToCmdLine("client", "pause", "200", "write"))
+	asserts.AssertStatusReply(t, result, "OK")
+	start := time.Now()
+	testServer.Exec(c, utils.ToCmdLine("get", "a"))
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("reads should not be paused")
+	}
+	testServer.Exec(c, utils.ToCmdLine("set", "a", "a"))
+	if time.Since(start) < 150*time.Millisecond {
+		t.Error("writes should be paused")
+	}
+
+	// all commands are paused until CLIENT UNPAUSE
+	testServer.Exec(c, utils.ToCmdLine("client", "pause", "10000"))
+	done := make(chan struct{})
+	go func() {
+		testServer.Exec(c, utils.ToCmdLine("get", "a"))
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Error("reads should be paused")
+	case <-time.After(100 * time.Millisecond):
+	}
+	result = testServer.Exec(c, utils.ToCmdLine("client", "unpause"))
+	asserts.AssertStatusReply(t, result, "OK")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("commands should be resumed after unpause")
+	}
+}
+
+func TestClientPauseExec(t *testing.T) {
+	c := connection.NewFakeConn()
+	defer testServer.AfterClientClose(c)
+	admin := connection.NewFakeConn()
+	defer testServer.AfterClientClose(admin)
+	key := utils.RandString(10)
+
+	// transactions with reads only are not paused
+	testServer.Exec(c, utils.ToCmdLine("multi"))
+	testServer.Exec(c, utils.ToCmdLine("get", key))
+	testServer.Exec(admin, utils.ToCmdLine("client", "pause", "200", "write"))
+	start := time.Now()
+	testServer.Exec(c, utils.ToCmdLine("exec"))
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("read-only transactions should not be paused")
+	}
+	testServer.Exec(admin, utils.ToCmdLine("client", "unpause"))
+
+	// transactions queued before pause are paused if they write
+	testServer.Exec(c, utils.ToCmdLine("multi"))
+	testServer.Exec(c, utils.ToCmdLine("set", key, "a"))
+	testServer.Exec(admin, utils.ToCmdLine("client", "pause", "200", "write"))
+	start = time.Now()
+	result := testServer.Exec(c, utils.ToCmdLine("exec"))
+	if time.Since(start) < 150*time.Millisecond {
+		t.Error("transactions with writes should be paused")
+	}
+	if string(result.ToBytes()) != "*1\r\n+OK\r\n" {
+		t.Errorf("unexpected reply: %s", result.ToBytes())
+	}
+}
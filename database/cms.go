@@ -0,0 +1,217 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/hdt3213/godis/datastruct/cms"
+	"github.com/hdt3213/godis/interface/database"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+// getAsSketch returns count-min sketch bound to the given key, the sketch is stored as a string payload.
+// If forUpdate is true, the sketch is restored from a copy of payload so that it could be modified
+func (db *DB) getAsSketch(key string, forUpdate bool) (*cms.Sketch, protocol.ErrorReply) {
+	getPayload := db.getAsString
+	if forUpdate {
+		getPayload = db.getAsStringForUpdate
+	}
+	payload, errReply := getPayload(key)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if payload == nil {
+		return nil, nil
+	}
+	sketch, err := cms.FromBytes(payload)
+	if err != nil {
+		return nil, &protocol.WrongTypeErrReply{}
+	}
+	return sketch, nil
+}
+
+func (db *DB) mustGetSketch(key string, forUpdate bool) (*cms.Sketch, protocol.ErrorReply) {
+	sketch, errReply := db.getAsSketch(key, forUpdate)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if sketch == nil {
+		return nil, protocol.MakeErrReply("CMS: key does not exist")
+	}
+	return sketch, nil
+}
+
+func (db *DB) putSketch(key string, sketch *cms.Sketch, args [][]byte, cmdName string) redis.Reply {
+	_, exists := db.GetEntity(key)
+	if exists {
+		return protocol.MakeErrReply("CMS: key already exists")
+	}
+	db.PutEntity(key, &database.DataEntity{Data: sketch.ToBytes()})
+	db.addAof(utils.ToCmdLine3(cmdName, args...))
+	return protocol.MakeOkReply()
+}
+
+// execCMSInitByDim creates a count-min sketch with the given width and depth
+func execCMSInitByDim(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	width, err := strconv.ParseUint(string(args[1]), 10, 32)
+	if err != nil || width == 0 {
+		return protocol.MakeErrReply("CMS: invalid width")
+	}
+	depth, err := strconv.ParseUint(string(args[2]), 10, 32)
+	if err != nil || depth == 0 {
+		return protocol.MakeErrReply("CMS: invalid depth")
+	}
+	sketch, err := cms.Make(uint32(width), uint32(depth))
+	if err != nil {
+		return protocol.MakeErrReply(err.Error())
+	}
+	return db.putSketch(key, sketch, args, "cms.initbydim")
+}
+
+// execCMSInitByProb creates a count-min sketch with the given error rate and probability
+func execCMSInitByProb(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	errorRate, err := strconv.ParseFloat(string(args[1]), 64)
+	if err != nil || errorRate <= 0 || errorRate >= 1 {
+		return protocol.MakeErrReply("CMS: invalid overestimation value")
+	}
+	probability, err := strconv.ParseFloat(string(args[2]), 64)
+	if err != nil || probability <= 0 || probability >= 1 {
+		return protocol.MakeErrReply("CMS: invalid prob value")
+	}
+	sketch, err := cms.MakeByProb(errorRate, probability)
+	if err != nil {
+		return protocol.MakeErrReply(err.Error())
+	}
+	return db.putSketch(key, sketch, args, "cms.initbyprob")
+}
+
+// execCMSIncrBy increases count of items, returns estimated counts after increment
+func execCMSIncrBy(db *DB, args [][]byte) redis.Reply {
+	if len(args)%2 != 1 {
+		return protocol.MakeArgNumErrReply("cms.incrby")
+	}
+	key := string(args[0])
+	size := (len(args) - 1) / 2
+	increments := make([]uint32, size)
+	for i := 0; i < size; i++ {
+		increment, err := strconv.ParseUint(string(args[2*i+2]), 10, 32)
+		if err != nil {
+			return protocol.MakeErrReply("CMS: Cannot parse number")
+		}
+		increments[i] = uint32(increment)
+	}
+	sketch, errReply := db.mustGetSketch(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([]redis.Reply, size)
+	for i := 0; i < size; i++ {
+		count, err := sketch.IncrBy(args[2*i+1], increments[i])
+		if err != nil {
+			result[i] = protocol.MakeErrReply(err.Error())
+		} else {
+			result[i] = protocol.MakeIntReply(int64(count))
+		}
+	}
+	db.PutEntity(key, &database.DataEntity{Data: sketch.ToBytes()})
+	db.addAof(utils.ToCmdLine3("cms.incrby", args...))
+	return protocol.MakeMultiRawReply(result)
+}
+
+// execCMSQuery returns estimated counts of items
+func execCMSQuery(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	sketch, errReply := db.mustGetSketch(key, false)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([]redis.Reply, len(args)-1)
+	for i, item := range args[1:] {
+		result[i] = protocol.MakeIntReply(int64(sketch.Query(item)))
+	}
+	return protocol.MakeMultiRawReply(result)
+}
+
+// parseCMSMerge parses `destination numKeys source [source ...] [WEIGHTS weight [weight ...]]`
+func parseCMSMerge(args [][]byte) (sources []string, weights []int64, errReply protocol.ErrorReply) {
+	numKeys, err := strconv.Atoi(string(args[1]))
+	if err != nil || numKeys <= 0 {
+		return nil, nil, protocol.MakeErrReply("CMS: invalid numkeys")
+	}
+	if len(args) < 2+numKeys {
+		return nil, nil, protocol.MakeErrReply("CMS: wrong number of keys")
+	}
+	for _, arg := range args[2 : 2+numKeys] {
+		sources = append(sources, string(arg))
+	}
+	weights = make([]int64, numKeys)
+	rest := args[2+numKeys:]
+	if len(rest) == 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+		return sources, weights, nil
+	}
+	if strings.ToUpper(string(rest[0])) != "WEIGHTS" || len(rest) != numKeys+1 {
+		return nil, nil, protocol.MakeSyntaxErrReply()
+	}
+	for i, arg := range rest[1:] {
+		weights[i], err = strconv.ParseInt(string(arg), 10, 64)
+		if err != nil {
+			return nil, nil, protocol.MakeErrReply("CMS: invalid weight value")
+		}
+	}
+	return sources, weights, nil
+}
+
+func prepareCMSMerge(args [][]byte) ([]string, []string) {
+	sources, _, errReply := parseCMSMerge(args)
+	if errReply != nil {
+		return []string{string(args[0])}, nil
+	}
+	return []string{string(args[0])}, sources
+}
+
+// execCMSMerge overwrites destination with the weighted sum of sources
+func execCMSMerge(db *DB, args [][]byte) redis.Reply {
+	dest := string(args[0])
+	sourceKeys, weights, errReply := parseCMSMerge(args)
+	if errReply != nil {
+		return errReply
+	}
+	destSketch, errReply := db.mustGetSketch(dest, true)
+	if errReply != nil {
+		return errReply
+	}
+	sources := make([]*cms.Sketch, len(sourceKeys))
+	for i, key := range sourceKeys {
+		sources[i], errReply = db.mustGetSketch(key, false)
+		if errReply != nil {
+			return errReply
+		}
+	}
+	err := destSketch.Merge(sources, weights)
+	if err != nil {
+		return protocol.MakeErrReply(err.Error())
+	}
+	db.PutEntity(dest, &database.DataEntity{Data: destSketch.ToBytes()})
+	db.addAof(utils.ToCmdLine3("cms.merge", args...))
+	return protocol.MakeOkReply()
+}
+
+func init() {
+	registerCommand("CMS.InitByDim", execCMSInitByDim, writeFirstKey, rollbackFirstKey, 4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+	registerCommand("CMS.InitByProb", execCMSInitByProb, writeFirstKey, rollbackFirstKey, 4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+	registerCommand("CMS.IncrBy", execCMSIncrBy, writeFirstKey, rollbackInPlaceString, -4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+	registerCommand("CMS.Query", execCMSQuery, readFirstKey, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
+	registerCommand("CMS.Merge", execCMSMerge, prepareCMSMerge, rollbackInPlaceString, -4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagMovableKeys}, 1, 1, 1)
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestCMS(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	result := testDB.Exec(nil, utils.ToCmdLine("CMS.INITBYDIM", key, "100", "5"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testDB.Exec(nil, utils.ToCmdLine("CMS.INITBYDIM", key, "100", "5"))
+	asserts.AssertErrReply(t, result, "CMS: key already exists")
+	result = testDB.Exec(nil, utils.ToCmdLine("CMS.INCRBY", key, "a", "3", "b", "1"))
+	if string(result.ToBytes()) != "*2\r\n:3\r\n:1\r\n" {
+		t.Errorf("unexpected reply: %s", result.ToBytes())
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("CMS.INCRBY", key, "a", "2"))
+	if string(result.ToBytes()) != "*1\r\n:5\r\n" {
+		t.Errorf("unexpected reply: %s", result.ToBytes())
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("CMS.QUERY", key, "a", "b", "c"))
+	if string(result.ToBytes()) != "*3\r\n:5\r\n:1\r\n:0\r\n" {
+		t.Errorf("unexpected reply: %s", result.ToBytes())
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("CMS.QUERY", utils.RandString(10), "a"))
+	asserts.AssertErrReply(t, result, "CMS: key does not exist")
+	result = testDB.Exec(nil, utils.ToCmdLine("CMS.INCRBY", key, "a", "x"))
+	asserts.AssertErrReply(t, result, "CMS: Cannot parse number")
+
+	key2 := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("CMS.INITBYDIM", key2, "100", "5"))
+	testDB.Exec(nil, utils.ToCmdLine("CMS.INCRBY", key2, "a", "1"))
+	dest := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("CMS.INITBYDIM", dest, "100", "5"))
+	result = testDB.Exec(nil, utils.ToCmdLine("CMS.MERGE", dest, "2", key, key2, "WEIGHTS", "1", "3"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testDB.Exec(nil, utils.ToCmdLine("CMS.QUERY", dest, "a"))
+	if string(result.ToBytes()) != "*1\r\n:8\r\n" {
+		t.Errorf("unexpected reply: %s", result.ToBytes())
+	}
+
+	key3 := utils.RandString(10)
+	result = testDB.Exec(nil, utils.ToCmdLine("CMS.INITBYPROB", key3, "0.01", "0.01"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testDB.Exec(nil, utils.ToCmdLine("CMS.MERGE", dest, "1", key3))
+	asserts.AssertErrReply(t, result, "CMS: width/depth is not equal")
+}
+
+func TestCMSAfterCopy(t *testing.T) {
+	c := connection.NewFakeConn()
+	src, dest := utils.RandString(10), utils.RandString(10)
+	testServer.Exec(c, utils.ToCmdLine("CMS.INITBYDIM", src, "100", "5"))
+	result := testServer.Exec(c, utils.ToCmdLine("COPY", src, dest))
+	asserts.AssertIntReply(t, result, 1)
+	testServer.Exec(c, utils.ToCmdLine("CMS.INCRBY", dest, "x", "5"))
+	// sketches share nothing after COPY
+	result = testServer.Exec(c, utils.ToCmdLine("CMS.QUERY", src, "x"))
+	if string(result.ToBytes()) != "*1\r\n:0\r\n" {
+		t.Errorf("unexpected reply: %s", result.ToBytes())
+	}
+	result = testServer.Exec(c, utils.ToCmdLine("CMS.MERGE", src, "1", dest))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testServer.Exec(c, utils.ToCmdLine("CMS.QUERY", src, "x"))
+	if string(result.ToBytes()) != "*1\r\n:5\r\n" {
+		t.Errorf("unexpected reply: %s", result.ToBytes())
+	}
+	testServer.Exec(c, utils.ToCmdLine("DEL", src, dest))
+}
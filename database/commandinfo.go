@@ -96,10 +96,28 @@ func init() {
 		attachCommandExtra([]string{redisFlagRandom, redisFlagLoading, redisFlagStale}, 0, 0, 0)
 	registerSpecialCommand("SlaveOf", 3, 0).
 		attachCommandExtra([]string{redisFlagAdmin, redisFlagNoScript, redisFlagStale}, 0, 0, 0)
+	registerSpecialCommand("ReplicaOf", 3, 0).
+		attachCommandExtra([]string{redisFlagAdmin, redisFlagNoScript, redisFlagStale}, 0, 0, 0)
+	registerSpecialCommand("Failover", -1, 0).
+		attachCommandExtra([]string{redisFlagAdmin, redisFlagNoScript, redisFlagStale}, 0, 0, 0)
 	registerSpecialCommand("Subscribe", -2, 0).
 		attachCommandExtra([]string{redisFlagPubSub, redisFlagNoScript, redisFlagLoading, redisFlagStale}, 0, 0, 0)
+	registerSpecialCommand("PSubscribe", -2, 0).
+		attachCommandExtra([]string{redisFlagPubSub, redisFlagNoScript, redisFlagLoading, redisFlagStale}, 0, 0, 0)
+	registerSpecialCommand("PUnsubscribe", -1, 0).
+		attachCommandExtra([]string{redisFlagPubSub, redisFlagNoScript, redisFlagLoading, redisFlagStale}, 0, 0, 0)
 	registerSpecialCommand("Publish", 3, 0).
 		attachCommandExtra([]string{redisFlagPubSub, redisFlagNoScript, redisFlagLoading, redisFlagFast}, 0, 0, 0)
+	registerSpecialCommand("SSubscribe", -2, 0).
+		attachCommandExtra([]string{redisFlagPubSub, redisFlagNoScript, redisFlagLoading, redisFlagStale}, 1, -1, 1)
+	registerSpecialCommand("SUnsubscribe", -1, 0).
+		attachCommandExtra([]string{redisFlagPubSub, redisFlagNoScript, redisFlagLoading, redisFlagStale}, 1, -1, 1)
+	registerSpecialCommand("SPublish", 3, 0).
+		attachCommandExtra([]string{redisFlagPubSub, redisFlagNoScript, redisFlagLoading, redisFlagFast}, 1, 1, 1)
+	registerSpecialCommand("PubSub", -2, 0).
+		attachCommandExtra([]string{redisFlagPubSub, redisFlagRandom, redisFlagLoading, redisFlagStale}, 0, 0, 0)
+	registerSpecialCommand("Client", -2, 0).
+		attachCommandExtra([]string{redisFlagAdmin, redisFlagNoScript, redisFlagRandom, redisFlagLoading, redisFlagStale}, 0, 0, 0)
 	registerSpecialCommand("FlushAll", -1, 0).
 		attachCommandExtra([]string{redisFlagWrite}, 0, 0, 0)
 	registerSpecialCommand("FlushDB", -1, 0).
@@ -108,6 +126,16 @@ func init() {
 		attachCommandExtra([]string{redisFlagAdmin, redisFlagNoScript}, 0, 0, 0)
 	registerSpecialCommand("BgSave", 1, 0).
 		attachCommandExtra([]string{redisFlagAdmin, redisFlagNoScript}, 0, 0, 0)
+	registerSpecialCommand("Latency", -2, 0).
+		attachCommandExtra([]string{redisFlagAdmin, redisFlagNoScript, redisFlagLoading, redisFlagStale}, 0, 0, 0)
+	registerSpecialCommand("Debug", -2, 0).
+		attachCommandExtra([]string{redisFlagAdmin, redisFlagNoScript, redisFlagLoading, redisFlagStale}, 0, 0, 0)
+	registerSpecialCommand("Copy", -3, 0).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 2, 1)
+	registerSpecialCommand("Move", 3, 0).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
+	registerSpecialCommand("SwapDB", 3, 0).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 0, 0, 0)
 	registerSpecialCommand("Select", 2, 0).
 		attachCommandExtra([]string{redisFlagLoading, redisFlagFast}, 0, 0, 0)
 	registerSpecialCommand("ReplConf", -1, 0).
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/logger"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+// execConfig handles subcommands of CONFIG
+func (server *Server) execConfig(args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.MakeArgNumErrReply("config")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "get":
+		if len(args) < 2 {
+			return protocol.MakeArgNumErrReply("config|get")
+		}
+		return execConfigGet(args[1:])
+	case "set":
+		if len(args) < 3 || len(args)%2 == 0 {
+			return protocol.MakeArgNumErrReply("config|set")
+		}
+		nameValues := make([]string, len(args)-1)
+		for i, arg := range args[1:] {
+			nameValues[i] = string(arg)
+		}
+		if err := config.Set(nameValues...); err != nil {
+			return protocol.MakeErrReply("ERR " + err.Error())
+		}
+		return protocol.MakeOkReply()
+	case "rewrite":
+		if len(args) != 1 {
+			return protocol.MakeArgNumErrReply("config|rewrite")
+		}
+		if err := config.Rewrite(); err != nil {
+			return protocol.MakeErrReply("ERR Rewriting config file: " + err.Error())
+		}
+		return protocol.MakeOkReply()
+	case "resetstat":
+		if len(args) != 1 {
+			return protocol.MakeArgNumErrReply("config|resetstat")
+		}
+		server.stats.reset()
+		return protocol.MakeOkReply()
+	}
+	return protocol.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try CONFIG HELP.")
+}
+
+// execConfigGet returns parameters matching any of the patterns, each parameter appears once
+func execConfigGet(patterns [][]byte) redis.Reply {
+	seen := make(map[string]struct{})
+	var result [][]byte
+	for _, pattern := range patterns {
+		nameValues := config.Get(string(pattern))
+		for i := 0; i < len(nameValues); i += 2 {
+			if _, ok := seen[nameValues[i]]; ok {
+				continue
+			}
+			seen[nameValues[i]] = struct{}{}
+			result = append(result, []byte(nameValues[i]), []byte(nameValues[i+1]))
+		}
+	}
+	return protocol.MakeMultiBulkReply(result)
+}
+
+// registerConfigCallbacks applies modifications of parameters which are cached by server,
+// callbacks are unregistered on Close
+func (server *Server) registerConfigCallbacks() {
+	server.configCallbacks = append(server.configCallbacks,
+		config.RegisterCallback("appendfsync", func(value string) error {
+			if server.persister != nil {
+				server.persister.SetFsync(value)
+			}
+			return nil
+		}),
+		config.RegisterCallback("slowlog-log-slower-than", func(value string) error {
+			threshold, _ := strconv.ParseInt(value, 10, 64)
+			server.slogLogger.SetThreshold(threshold)
+			return nil
+		}),
+		config.RegisterCallback("slowlog-max-len", func(value string) error {
+			maxLen, _ := strconv.Atoi(value)
+			server.slogLogger.SetMaxEntries(maxLen)
+			return nil
+		}),
+		config.RegisterCallback("client-command-rate", func(value string) error {
+			rate, _ := strconv.Atoi(value)
+			server.throttle.setRate(rate)
+			return nil
+		}),
+		config.RegisterCallback("debug-http-addr", server.setDebugHTTPAddr),
+		config.RegisterCallback("loglevel", func(value string) error {
+			level, err := logger.ParseLevel(value)
+			if err != nil {
+				return err
+			}
+			logger.SetLevel(level)
+			return nil
+		}),
+	)
+}
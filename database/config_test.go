@@ -0,0 +1,85 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/lib/logger"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestConfigGetSet(t *testing.T) {
+	c := connection.NewFakeConn()
+	defer testServer.AfterClientClose(c)
+	threshold := config.Get("slowlog-log-slower-than")[1]
+	maxLen := config.Get("slowlog-max-len")[1]
+	defer testServer.Exec(c, utils.ToCmdLine("config", "set", "slowlog-log-slower-than", threshold, "slowlog-max-len", maxLen))
+
+	result := testServer.Exec(c, utils.ToCmdLine("config", "set", "slowlog-log-slower-than", "0", "slowlog-max-len", "2"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testServer.Exec(c, utils.ToCmdLine("config", "get", "slowlog-*", "slowlog-max-len"))
+	asserts.AssertMultiBulkReply(t, result, []string{"slowlog-log-slower-than", "0", "slowlog-max-len", "2"})
+
+	// takes effect immediately
+	testServer.Exec(c, utils.ToCmdLine("slowlog", "reset"))
+	testServer.Exec(c, utils.ToCmdLine("get", "config-slowlog"))
+	if testServer.slogLogger.Len() == 0 {
+		t.Error("expect slow log recorded")
+	}
+	testServer.Exec(c, utils.ToCmdLine("get", "config-slowlog"))
+	testServer.Exec(c, utils.ToCmdLine("get", "config-slowlog"))
+	if testServer.slogLogger.Len() != 2 {
+		t.Errorf("expect 2 entries, actual: %d", testServer.slogLogger.Len())
+	}
+
+	result = testServer.Exec(c, utils.ToCmdLine("config", "set", "port", "1"))
+	asserts.AssertErrReply(t, result, "ERR CONFIG SET failed (possibly related to argument 'port') - can't set immutable config")
+	result = testServer.Exec(c, utils.ToCmdLine("config", "set", "foo", "1"))
+	asserts.AssertErrReply(t, result, "ERR Unknown option or number of arguments for CONFIG SET - 'foo'")
+	result = testServer.Exec(c, utils.ToCmdLine("config", "set", "maxmemory"))
+	asserts.AssertErrReply(t, result, "ERR wrong number of arguments for 'config|set' command")
+	result = testServer.Exec(c, utils.ToCmdLine("config", "foo"))
+	asserts.AssertErrReply(t, result, "ERR unknown subcommand 'foo'. Try CONFIG HELP.")
+}
+
+func TestConfigResetStat(t *testing.T) {
+	c := connection.NewFakeConn()
+	defer testServer.AfterClientClose(c)
+	testServer.Exec(c, utils.ToCmdLine("get", "config-reset-stat"))
+	result := testServer.Exec(c, utils.ToCmdLine("config", "resetstat"))
+	asserts.AssertStatusReply(t, result, "OK")
+	if _, ok := testServer.stats.commands.Load("get"); ok {
+		t.Error("expect command stats reset")
+	}
+	if testServer.stats.keyspaceMisses.Load() != 0 {
+		t.Error("expect keyspace misses reset")
+	}
+}
+
+func TestConfigSetLogLevel(t *testing.T) {
+	c := connection.NewFakeConn()
+	defer testServer.AfterClientClose(c)
+	defaultLogger := logger.DefaultLogger
+	l := logger.NewStdoutLogger()
+	logger.DefaultLogger = l
+	level := config.Get("loglevel")[1]
+	defer func() {
+		testServer.Exec(c, utils.ToCmdLine("config", "set", "loglevel", level))
+		logger.DefaultLogger = defaultLogger
+	}()
+
+	result := testServer.Exec(c, utils.ToCmdLine("config", "set", "loglevel", "warning"))
+	asserts.AssertStatusReply(t, result, "OK")
+	if l.Enabled(logger.INFO) || !l.Enabled(logger.WARNING) {
+		t.Error("expect log level changed to warning")
+	}
+	result = testServer.Exec(c, utils.ToCmdLine("config", "set", "loglevel", "debug"))
+	asserts.AssertStatusReply(t, result, "OK")
+	if !l.Enabled(logger.DEBUG) {
+		t.Error("expect log level changed to debug")
+	}
+	result = testServer.Exec(c, utils.ToCmdLine("config", "set", "loglevel", "foo"))
+	asserts.AssertErrReply(t, result, "ERR CONFIG SET failed (possibly related to argument 'loglevel') - argument(s) must be one of the following: debug, verbose, notice, warning")
+}
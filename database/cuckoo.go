@@ -0,0 +1,113 @@
+package database
+
+import (
+	"strconv"
+
+	"github.com/hdt3213/godis/datastruct/cuckoo"
+	"github.com/hdt3213/godis/interface/database"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+// getAsCuckooFilter returns filter bound to the given key, the filter is stored as a string payload.
+// If forUpdate is true, the filter is restored from a copy of payload so that it could be modified
+func (db *DB) getAsCuckooFilter(key string, forUpdate bool) (*cuckoo.Filter, protocol.ErrorReply) {
+	getPayload := db.getAsString
+	if forUpdate {
+		getPayload = db.getAsStringForUpdate
+	}
+	payload, errReply := getPayload(key)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if payload == nil {
+		return nil, nil
+	}
+	filter, err := cuckoo.FromBytes(payload)
+	if err != nil {
+		return nil, &protocol.WrongTypeErrReply{}
+	}
+	return filter, nil
+}
+
+// execCFReserve creates an empty cuckoo filter with the given capacity
+func execCFReserve(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	capacity, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil || capacity == 0 {
+		return protocol.MakeErrReply("ERR Bad capacity")
+	}
+	_, exists := db.GetEntity(key)
+	if exists {
+		return protocol.MakeErrReply("ERR item exists")
+	}
+	filter, err := cuckoo.Make(capacity)
+	if err != nil {
+		return protocol.MakeErrReply(err.Error())
+	}
+	db.PutEntity(key, &database.DataEntity{Data: filter.ToBytes()})
+	db.addAof(utils.ToCmdLine3("cf.reserve", args...))
+	return protocol.MakeOkReply()
+}
+
+// execCFAdd adds an item into cuckoo filter, creates the filter with default capacity if not exists
+func execCFAdd(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	filter, errReply := db.getAsCuckooFilter(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	if filter == nil {
+		filter, _ = cuckoo.Make(cuckoo.DefaultCapacity)
+	}
+	err := filter.Add(args[1])
+	if err != nil {
+		return protocol.MakeErrReply(err.Error())
+	}
+	db.PutEntity(key, &database.DataEntity{Data: filter.ToBytes()})
+	db.addAof(utils.ToCmdLine3("cf.add", args...))
+	return protocol.MakeIntReply(1)
+}
+
+// execCFExists checks whether an item may exist in cuckoo filter
+func execCFExists(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	filter, errReply := db.getAsCuckooFilter(key, false)
+	if errReply != nil {
+		return errReply
+	}
+	if filter == nil || !filter.Exists(args[1]) {
+		return protocol.MakeIntReply(0)
+	}
+	return protocol.MakeIntReply(1)
+}
+
+// execCFDel removes one occurrence of an item from cuckoo filter
+func execCFDel(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	filter, errReply := db.getAsCuckooFilter(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	if filter == nil {
+		return protocol.MakeErrReply("ERR not found")
+	}
+	if !filter.Remove(args[1]) {
+		return protocol.MakeIntReply(0)
+	}
+	db.PutEntity(key, &database.DataEntity{Data: filter.ToBytes()})
+	db.addAof(utils.ToCmdLine3("cf.del", args...))
+	return protocol.MakeIntReply(1)
+}
+
+func init() {
+	registerCommand("CF.Reserve", execCFReserve, writeFirstKey, rollbackFirstKey, 3, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+	registerCommand("CF.Add", execCFAdd, writeFirstKey, rollbackInPlaceString, 3, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+	registerCommand("CF.Exists", execCFExists, readFirstKey, nil, 3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
+	registerCommand("CF.Del", execCFDel, writeFirstKey, rollbackInPlaceString, 3, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestCuckooFilter(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	result := testDB.Exec(nil, utils.ToCmdLine("CF.RESERVE", key, "100"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testDB.Exec(nil, utils.ToCmdLine("CF.RESERVE", key, "100"))
+	asserts.AssertErrReply(t, result, "ERR item exists")
+	result = testDB.Exec(nil, utils.ToCmdLine("CF.ADD", key, "a"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("CF.EXISTS", key, "a"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("CF.DEL", key, "a"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("CF.EXISTS", key, "a"))
+	asserts.AssertIntReply(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("CF.DEL", key, "a"))
+	asserts.AssertIntReply(t, result, 0)
+
+	key2 := utils.RandString(10)
+	result = testDB.Exec(nil, utils.ToCmdLine("CF.ADD", key2, "a"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("CF.EXISTS", key2, "a"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("CF.DEL", utils.RandString(10), "a"))
+	asserts.AssertErrReply(t, result, "ERR not found")
+
+	strKey := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("SET", strKey, "hello"))
+	result = testDB.Exec(nil, utils.ToCmdLine("CF.EXISTS", strKey, "a"))
+	asserts.AssertErrReply(t, result, "WRONGTYPE Operation against a key holding the wrong kind of value")
+}
+
+func TestCuckooFilterAfterCopy(t *testing.T) {
+	c := connection.NewFakeConn()
+	src, dest := utils.RandString(10), utils.RandString(10)
+	testServer.Exec(c, utils.ToCmdLine("CF.ADD", src, "a"))
+	result := testServer.Exec(c, utils.ToCmdLine("COPY", src, dest))
+	asserts.AssertIntReply(t, result, 1)
+	result = testServer.Exec(c, utils.ToCmdLine("CF.ADD", dest, "x"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testServer.Exec(c, utils.ToCmdLine("CF.DEL", dest, "a"))
+	asserts.AssertIntReply(t, result, 1)
+	// filters share nothing after COPY
+	result = testServer.Exec(c, utils.ToCmdLine("CF.EXISTS", src, "x"))
+	asserts.AssertIntReply(t, result, 0)
+	result = testServer.Exec(c, utils.ToCmdLine("CF.EXISTS", src, "a"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testServer.Exec(c, utils.ToCmdLine("CF.EXISTS", dest, "a"))
+	asserts.AssertIntReply(t, result, 0)
+	testServer.Exec(c, utils.ToCmdLine("DEL", src, dest))
+}
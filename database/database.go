@@ -2,14 +2,19 @@
 package database
 
 import (
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/hdt3213/godis/config"
 	"github.com/hdt3213/godis/datastruct/dict"
 	"github.com/hdt3213/godis/interface/database"
 	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/clock"
 	"github.com/hdt3213/godis/lib/logger"
 	"github.com/hdt3213/godis/lib/timewheel"
+	"github.com/hdt3213/godis/lib/utils"
 	"github.com/hdt3213/godis/redis/protocol"
 )
 
@@ -21,6 +26,9 @@ const (
 // DB stores data and execute user's commands
 type DB struct {
 	index int
+	// id names timewheel tasks of the dataset. It stays with the dataset when SWAPDB moves it to another index,
+	// and is inherited by the db replacing it at the same index, see loadDB
+	id int64
 	// key -> DataEntity
 	data *dict.ConcurrentDict
 	// key -> expireTime (time.Time)
@@ -30,10 +38,30 @@ type DB struct {
 
 	// addaof is used to add command to aof
 	addAof func(CmdLine)
+	// blocking records connections blocked on keys
+	blocking *blockingRegistry
 
 	// callbacks
 	insertCallback database.KeyEventCallback
 	deleteCallback database.KeyEventCallback
+	// notify publishes keyspace events, nil if db is not bound to a server
+	notify func(dbIndex int, class int, event string, key string)
+	// tracking is shared by all dbs of a server, nil if db is not bound to a server
+	tracking *trackingTable
+	// isReplica reports whether the server is a replica, nil if db is not bound to a server.
+	// Replicas never expire keys by themselves, they wait for DEL from master
+	isReplica func() bool
+	// activeExpire reports whether expire tasks remove keys, see DEBUG SET-ACTIVE-EXPIRE.
+	// nil if db is not bound to a server
+	activeExpire func() bool
+	// keyHooked reports whether the server has hooks of key events, nil if db is not bound to a server
+	keyHooked func() bool
+	// stats counts keyspace hits and expired keys, nil if db is not bound to a server
+	stats *serverStats
+	// latency records slow expire tasks, nil if db is not bound to a server
+	latency *latencyMonitor
+	// scripts caches and runs lua scripts of EVAL, nil if db is not bound to a server
+	scripts *scriptEngine
 }
 
 // ExecFunc is interface for command executor
@@ -51,13 +79,18 @@ type CmdLine = [][]byte
 // execute from head to tail when undo
 type UndoFunc func(db *DB, args [][]byte) []CmdLine
 
+// lastDBID is the latest id assigned to DB instances
+var lastDBID int64
+
 // makeDB create DB instance
 func makeDB() *DB {
 	db := &DB{
+		id:         atomic.AddInt64(&lastDBID, 1),
 		data:       dict.MakeConcurrent(dataDictSize),
 		ttlMap:     dict.MakeConcurrent(ttlDictSize),
 		versionMap: dict.MakeConcurrent(dataDictSize),
 		addAof:     func(line CmdLine) {},
+		blocking:   makeBlockingRegistry(),
 	}
 	return db
 }
@@ -65,10 +98,12 @@ func makeDB() *DB {
 // makeBasicDB create DB instance only with basic abilities.
 func makeBasicDB() *DB {
 	db := &DB{
+		id:         atomic.AddInt64(&lastDBID, 1),
 		data:       dict.MakeConcurrent(dataDictSize),
 		ttlMap:     dict.MakeConcurrent(ttlDictSize),
 		versionMap: dict.MakeConcurrent(dataDictSize),
 		addAof:     func(line CmdLine) {},
+		blocking:   makeBlockingRegistry(),
 	}
 	return db
 }
@@ -97,15 +132,20 @@ func (db *DB) Exec(c redis.Connection, cmdLine [][]byte) redis.Reply {
 			return protocol.MakeArgNumErrReply(cmdName)
 		}
 		return Watch(db, c, cmdLine[1:])
+	} else if cmdName == "unwatch" && !c.InMultiState() {
+		if len(cmdLine) != 1 {
+			return protocol.MakeArgNumErrReply(cmdName)
+		}
+		return UnWatch(c)
 	}
 	if c != nil && c.InMultiState() {
 		return EnqueueCmd(c, cmdLine)
 	}
 
-	return db.execNormalCommand(cmdLine)
+	return db.execNormalCommand(c, cmdLine)
 }
 
-func (db *DB) execNormalCommand(cmdLine [][]byte) redis.Reply {
+func (db *DB) execNormalCommand(c redis.Connection, cmdLine [][]byte) redis.Reply {
 	cmdName := strings.ToLower(string(cmdLine[0]))
 	cmd, ok := cmdTable[cmdName]
 	if !ok {
@@ -120,8 +160,16 @@ func (db *DB) execNormalCommand(cmdLine [][]byte) redis.Reply {
 	db.addVersion(write...)
 	db.RWLocks(write, read)
 	defer db.RWUnLocks(write, read)
+	states := db.beforeWrite(cmd, cmdLine[1:])
 	fun := cmd.executor
-	return fun(db, cmdLine[1:])
+	result := fun(db, cmdLine[1:])
+	db.afterWrite(cmd, states, result)
+	if cmd.flags&flagReadOnly > 0 {
+		db.tracking.trackRead(c, read, result)
+	} else {
+		db.tracking.invalidate(c, write)
+	}
+	return result
 }
 
 // execWithLock executes normal commands, invoker should provide locks
@@ -134,8 +182,15 @@ func (db *DB) execWithLock(cmdLine [][]byte) redis.Reply {
 	if !validateArity(cmd.arity, cmdLine) {
 		return protocol.MakeArgNumErrReply(cmdName)
 	}
+	states := db.beforeWrite(cmd, cmdLine[1:])
 	fun := cmd.executor
-	return fun(db, cmdLine[1:])
+	result := fun(db, cmdLine[1:])
+	db.afterWrite(cmd, states, result)
+	if cmd.flags&flagReadOnly == 0 && db.tracking.active() {
+		write, _ := cmd.prepare(cmdLine[1:])
+		db.tracking.invalidate(nil, write)
+	}
+	return result
 }
 
 func validateArity(arity int, cmdArgs [][]byte) bool {
@@ -148,8 +203,41 @@ func validateArity(arity int, cmdArgs [][]byte) bool {
 
 /* ---- Data Access ----- */
 
-// GetEntity returns DataEntity bind to given key
+// GetEntity returns DataEntity bind to given key and updates its access time
 func (db *DB) GetEntity(key string) (*database.DataEntity, bool) {
+	entity, ok := db.peekEntity(key)
+	db.stats.recordLookup(ok)
+	if ok {
+		touchEntity(entity)
+	}
+	return entity, ok
+}
+
+// touchEntity records an access of entity, either access time or access frequency depending on maxmemory-policy
+func touchEntity(entity *database.DataEntity) {
+	if config.Properties.IsLFUEnabled() {
+		entity.TouchLFU(config.Properties.LFULogFactor, config.Properties.LFUDecayTime)
+	} else {
+		entity.Touch()
+	}
+}
+
+// initEntityAccess sets access metadata of an entity which is going to be stored,
+// access frequency of the overwritten entity is retained since the write has been counted by GetEntity
+func (db *DB) initEntityAccess(key string, entity *database.DataEntity) {
+	if !config.Properties.IsLFUEnabled() {
+		entity.Touch()
+		return
+	}
+	if raw, ok := db.data.GetWithLock(key); ok {
+		entity.InheritAccess(raw.(*database.DataEntity))
+	} else {
+		entity.InitLFU()
+	}
+}
+
+// peekEntity returns DataEntity bind to given key without updating its access time
+func (db *DB) peekEntity(key string) (*database.DataEntity, bool) {
 	raw, ok := db.data.GetWithLock(key)
 	if !ok {
 		return nil, false
@@ -163,28 +251,35 @@ func (db *DB) GetEntity(key string) (*database.DataEntity, bool) {
 
 // PutEntity a DataEntity into DB
 func (db *DB) PutEntity(key string, entity *database.DataEntity) int {
+	db.initEntityAccess(key, entity)
 	ret := db.data.PutWithLock(key, entity)
 	// db.insertCallback may be set as nil, during `if` and actually callback
 	// so introduce a local variable `cb`
 	if cb := db.insertCallback; ret > 0 && cb != nil {
 		cb(db.index, key, entity)
 	}
+	db.blocking.signal(key)
 	return ret
 }
 
 // PutIfExists edit an existing DataEntity
 func (db *DB) PutIfExists(key string, entity *database.DataEntity) int {
+	db.initEntityAccess(key, entity)
 	return db.data.PutIfExistsWithLock(key, entity)
 }
 
 // PutIfAbsent insert an DataEntity only if the key not exists
 func (db *DB) PutIfAbsent(key string, entity *database.DataEntity) int {
+	db.initEntityAccess(key, entity)
 	ret := db.data.PutIfAbsentWithLock(key, entity)
 	// db.insertCallback may be set as nil, during `if` and actually callback
 	// so introduce a local variable `cb`
 	if cb := db.insertCallback; ret > 0 && cb != nil {
 		cb(db.index, key, entity)
 	}
+	if ret > 0 {
+		db.blocking.signal(key)
+	}
 	return ret
 }
 
@@ -192,7 +287,7 @@ func (db *DB) PutIfAbsent(key string, entity *database.DataEntity) int {
 func (db *DB) Remove(key string) {
 	raw, deleted := db.data.RemoveWithLock(key)
 	db.ttlMap.Remove(key)
-	taskKey := genExpireTask(key)
+	taskKey := genExpireTask(db.id, key)
 	timewheel.Cancel(taskKey)
 	if cb := db.deleteCallback; cb != nil {
 		var entity *database.DataEntity
@@ -238,15 +333,22 @@ func (db *DB) RWUnLocks(writeKeys []string, readKeys []string) {
 
 /* ---- TTL Functions ---- */
 
-func genExpireTask(key string) string {
-	return "expire:" + key
+func genExpireTask(dbID int64, key string) string {
+	return "expire:" + strconv.FormatInt(dbID, 10) + ":" + key
 }
 
 // Expire sets ttlCmd of key
 func (db *DB) Expire(key string, expireTime time.Time) {
 	db.ttlMap.Put(key, expireTime)
-	taskKey := genExpireTask(key)
-	timewheel.At(expireTime, taskKey, func() {
+	db.scheduleExpireTask(key, clock.Until(expireTime))
+}
+
+func (db *DB) scheduleExpireTask(key string, delay time.Duration) {
+	taskKey := genExpireTask(db.id, key)
+	timewheel.Delay(delay, taskKey, func() {
+		if db.replicaMode() || !db.activeExpireEnabled() {
+			return
+		}
 		keys := []string{key}
 		db.RWLocks(keys, nil)
 		defer db.RWUnLocks(keys, nil)
@@ -257,34 +359,100 @@ func (db *DB) Expire(key string, expireTime time.Time) {
 			return
 		}
 		expireTime, _ := rawExpireTime.(time.Time)
-		expired := time.Now().After(expireTime)
-		if expired {
-			db.Remove(key)
+		expired := clock.Now().After(expireTime)
+		if !expired {
+			// ticks of timewheel may drift from the wall clock, so the task may fire a little early
+			db.scheduleExpireTask(key, clock.Until(expireTime))
+			return
 		}
+		start := time.Now()
+		db.expireKey(key)
+		db.latency.addSampleIfNeeded("expire-cycle", time.Since(start))
 	})
 }
 
+// rescheduleExpireTasks schedules expire tasks for all keys with ttl again,
+// tasks fired while the server was a replica or active expiring was disabled have been ignored
+func (db *DB) rescheduleExpireTasks() {
+	expireTimes := make(map[string]time.Time)
+	db.ttlMap.ForEach(func(key string, val interface{}) bool {
+		expireTimes[key] = val.(time.Time)
+		return true
+	})
+	for key, expireTime := range expireTimes {
+		delay := clock.Until(expireTime)
+		if delay < 0 {
+			// timewheel drops tasks in the past
+			delay = 0
+		}
+		db.scheduleExpireTask(key, delay)
+	}
+}
+
+// expireKey removes an expired key, and propagates DEL to aof and slaves
+// so that replicas and aof loading never rely on their own clock
+func (db *DB) expireKey(key string) {
+	db.stats.incrExpired()
+	db.Remove(key)
+	db.addVersion(key)
+	db.addAof(utils.ToCmdLine("del", key))
+	db.notifyEvent(notifyExpired, "expired", key)
+	db.tracking.invalidate(nil, []string{key})
+}
+
+// takeOverExpireTasks schedules expire tasks of db which replaces oldDB at the same index,
+// tasks of oldDB are replaced or canceled, so that they never remove keys of db on behalf of oldDB
+func (db *DB) takeOverExpireTasks(oldDB *DB) {
+	db.rescheduleExpireTasks()
+	oldDB.ttlMap.ForEach(func(key string, val interface{}) bool {
+		if _, ok := db.ttlMap.Get(key); !ok {
+			timewheel.Cancel(genExpireTask(db.id, key))
+		}
+		return true
+	})
+	db.takeOverFieldExpireTasks(oldDB)
+}
+
+func (db *DB) replicaMode() bool {
+	return db.isReplica != nil && db.isReplica()
+}
+
+func (db *DB) activeExpireEnabled() bool {
+	return db.activeExpire == nil || db.activeExpire()
+}
+
 // Persist cancel ttlCmd of key
 func (db *DB) Persist(key string) {
 	db.ttlMap.Remove(key)
-	taskKey := genExpireTask(key)
+	taskKey := genExpireTask(db.id, key)
 	timewheel.Cancel(taskKey)
 }
 
-// IsExpired check whether a key is expired
+// IsExpired check whether a key is expired, expired key is removed unless the server is a replica
 func (db *DB) IsExpired(key string) bool {
 	rawExpireTime, ok := db.ttlMap.Get(key)
 	if !ok {
 		return false
 	}
 	expireTime, _ := rawExpireTime.(time.Time)
-	expired := time.Now().After(expireTime)
-	if expired {
-		db.Remove(key)
+	expired := clock.Now().After(expireTime)
+	if expired && !db.replicaMode() {
+		// replica keeps logically expired key until DEL from master arrives
+		db.expireKey(key)
 	}
 	return expired
 }
 
+// hasExpired checks whether key is expired without removing it, so it can be used without holding the write lock
+func (db *DB) hasExpired(key string) bool {
+	rawExpireTime, ok := db.ttlMap.Get(key)
+	if !ok {
+		return false
+	}
+	expireTime, _ := rawExpireTime.(time.Time)
+	return clock.Now().After(expireTime)
+}
+
 /* --- add version --- */
 
 func (db *DB) addVersion(keys ...string) {
@@ -0,0 +1,215 @@
+package database
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hdt3213/godis/aof"
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/logger"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/lib/wildcard"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+var debugHelp = []string{
+	"DEBUG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"CHANGE-REPL-ID",
+	"    Change the replication IDs of the instance.",
+	"    Dangerous: should be used only for testing the replication subsystem.",
+	"JMAP",
+	"    Write a heap profile of the process into the tmp dir.",
+	"OBJECT <key>",
+	"    Show low level info about the key and associated value.",
+	"RELOAD",
+	"    Save the RDB on disk and reload it back to memory.",
+	"SET-ACTIVE-EXPIRE <0|1>",
+	"    Setting it to 0 disables expiring keys in background when they are not",
+	"    accessed (otherwise the Redis behavior). Setting it to 1 reenables back the",
+	"    default.",
+	"SLEEP <seconds>",
+	"    Stop the server for <seconds>. Decimals allowed.",
+	"STRINGMATCH-LEN",
+	"    Run a fuzz tester against the glob pattern matcher.",
+	"HELP",
+	"    Print this help.",
+}
+
+// execDebug handles DEBUG subcommands which are used by tests
+func (server *Server) execDebug(c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.MakeArgNumErrReply("debug")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "help":
+		if len(args) != 1 {
+			return protocol.MakeErrReply("ERR wrong number of arguments for 'debug|help' command")
+		}
+		lines := make([]redis.Reply, len(debugHelp))
+		for i, line := range debugHelp {
+			lines[i] = protocol.MakeStatusReply(line)
+		}
+		return protocol.MakeMultiRawReply(lines)
+	case "reload":
+		if len(args) != 1 {
+			return &protocol.SyntaxErrReply{}
+		}
+		err := server.debugReload()
+		if err != nil {
+			return protocol.MakeErrReply("ERR Error trying to reload the RDB dump: " + err.Error())
+		}
+		return protocol.MakeOkReply()
+	case "sleep":
+		if len(args) != 2 {
+			return &protocol.SyntaxErrReply{}
+		}
+		seconds, err := strconv.ParseFloat(string(args[1]), 64)
+		if err != nil {
+			return protocol.MakeErrReply("ERR value is not a valid float")
+		}
+		time.Sleep(time.Duration(seconds * float64(time.Second)))
+		return protocol.MakeOkReply()
+	case "object":
+		if len(args) != 2 {
+			return &protocol.SyntaxErrReply{}
+		}
+		return server.debugObject(c, string(args[1]))
+	case "set-active-expire":
+		if len(args) != 2 {
+			return &protocol.SyntaxErrReply{}
+		}
+		enabled, err := strconv.Atoi(string(args[1]))
+		if err != nil {
+			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		server.setActiveExpire(enabled != 0)
+		return protocol.MakeOkReply()
+	case "jmap":
+		if len(args) != 1 {
+			return &protocol.SyntaxErrReply{}
+		}
+		filename, err := writeHeapProfile()
+		if err != nil {
+			return protocol.MakeErrReply("ERR " + err.Error())
+		}
+		logger.Info("heap profile written to " + filename)
+		return protocol.MakeOkReply()
+	case "stringmatch-len":
+		if len(args) != 1 {
+			return &protocol.SyntaxErrReply{}
+		}
+		if err := stringMatchFuzz(); err != nil {
+			return protocol.MakeErrReply("ERR " + err.Error())
+		}
+		return protocol.MakeStatusReply("Apparently Godis did not crash: test passed")
+	case "change-repl-id":
+		if len(args) != 1 {
+			return &protocol.SyntaxErrReply{}
+		}
+		server.changeReplId()
+		return protocol.MakeOkReply()
+	}
+	return protocol.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try DEBUG HELP.")
+}
+
+// debugObject shows internals of the value bound to key, it does not update access time of the key
+func (server *Server) debugObject(c redis.Connection, key string) redis.Reply {
+	db, errReply := server.selectDB(c.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+	keys := []string{key}
+	db.RWLocks(nil, keys)
+	defer db.RWUnLocks(nil, keys)
+	entity, exists := db.peekEntity(key)
+	if !exists {
+		return protocol.MakeErrReply("ERR no such key")
+	}
+	serializedLength, err := aof.SerializedLength(entity)
+	if err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
+	return protocol.MakeStatusReply(fmt.Sprintf("Value at:%p refcount:%d encoding:%s serializedlength:%d lru_seconds_idle:%d",
+		entity, getRefCount(entity.Data), getEncoding(entity.Data), serializedLength, int64(entity.IdleTime().Seconds())))
+}
+
+func (server *Server) activeExpireEnabled() bool {
+	return atomic.LoadInt32(&server.activeExpireDisabled) == 0
+}
+
+// setActiveExpire enables or disables expire tasks, keys are still expired when they are accessed
+func (server *Server) setActiveExpire(enabled bool) {
+	if !enabled {
+		atomic.StoreInt32(&server.activeExpireDisabled, 1)
+		return
+	}
+	if atomic.CompareAndSwapInt32(&server.activeExpireDisabled, 1, 0) && !server.isReplica() {
+		// tasks fired while disabled have been dropped
+		for i := range server.dbSet {
+			server.mustSelectDB(i).rescheduleExpireTasks()
+			server.mustSelectDB(i).rescheduleFieldExpireTasks()
+		}
+	}
+}
+
+// changeReplId gives the master a new replication id, so that slaves have to do full resync
+func (server *Server) changeReplId() {
+	server.masterStatus.mu.Lock()
+	defer server.masterStatus.mu.Unlock()
+	server.masterStatus.replId = utils.RandSecureHexString(40)
+}
+
+// writeHeapProfile writes heap profile into tmp dir and returns its filename
+func writeHeapProfile() (string, error) {
+	filename := filepath.Join(config.GetTmpDir(), fmt.Sprintf("heap-%d.pprof", time.Now().UnixNano()))
+	file, err := os.Create(filename)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	if err := pprof.WriteHeapProfile(file); err != nil {
+		return "", err
+	}
+	return filename, nil
+}
+
+// stringMatchFuzzTimes is the number of random patterns tried by DEBUG STRINGMATCH-LEN
+const stringMatchFuzzTimes = 100000
+
+// stringMatchFuzz matches random strings against random patterns to make sure the matcher never panics
+func stringMatchFuzz() (err error) {
+	const charset = "abc*?[]^-\\"
+	randString := func() string {
+		buf := make([]byte, rand.Intn(32))
+		for i := range buf {
+			buf[i] = charset[rand.Intn(len(charset))]
+		}
+		return string(buf)
+	}
+	var pattern, str string
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("matching %q against pattern %q panics: %v", str, pattern, e)
+		}
+	}()
+	for i := 0; i < stringMatchFuzzTimes; i++ {
+		pattern, str = randString(), randString()
+		p, compileErr := wildcard.CompilePattern(pattern)
+		if compileErr != nil {
+			continue
+		}
+		p.IsMatch(str)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestDebugObject(t *testing.T) {
+	backup := config.Properties
+	defer func() {
+		config.Properties = backup
+	}()
+	config.Properties = &config.ServerProperties{}
+	server := NewStandaloneServer()
+	defer server.Close()
+	c := connection.NewFakeConn()
+	server.Exec(c, utils.ToCmdLine("set", "str", "hello"))
+	server.Exec(c, utils.ToCmdLine("rpush", "list", "a", "b"))
+
+	result := server.Exec(c, utils.ToCmdLine("debug", "object", "str"))
+	status, ok := result.(*protocol.StatusReply)
+	if !ok {
+		t.Fatalf("expect status reply, actual: %s", result.ToBytes())
+	}
+	if !strings.Contains(status.Status, "encoding:embstr") || !strings.Contains(status.Status, "serializedlength:6") {
+		t.Errorf("unexpected debug object: %s", status.Status)
+	}
+	result = server.Exec(c, utils.ToCmdLine("debug", "object", "list"))
+	if _, ok := result.(*protocol.StatusReply); !ok {
+		t.Errorf("expect status reply, actual: %s", result.ToBytes())
+	}
+	result = server.Exec(c, utils.ToCmdLine("debug", "object", "none"))
+	asserts.AssertErrReply(t, result, "ERR no such key")
+}
+
+func TestDebugSleep(t *testing.T) {
+	server := NewStandaloneServer()
+	defer server.Close()
+	c := connection.NewFakeConn()
+	start := time.Now()
+	result := server.Exec(c, utils.ToCmdLine("debug", "sleep", "0.2"))
+	asserts.AssertStatusReply(t, result, "OK")
+	if time.Since(start) < 200*time.Millisecond {
+		t.Error("debug sleep returned too early")
+	}
+	result = server.Exec(c, utils.ToCmdLine("debug", "sleep", "abc"))
+	asserts.AssertErrReply(t, result, "ERR value is not a valid float")
+}
+
+func TestDebugSetActiveExpire(t *testing.T) {
+	server := NewStandaloneServer()
+	defer server.Close()
+	c := connection.NewFakeConn()
+	result := server.Exec(c, utils.ToCmdLine("debug", "set-active-expire", "0"))
+	asserts.AssertStatusReply(t, result, "OK")
+	server.Exec(c, utils.ToCmdLine("set", "k", "v", "px", "100"))
+	time.Sleep(1500 * time.Millisecond)
+	db := server.mustSelectDB(0)
+	if _, ok := db.data.Get("k"); !ok {
+		t.Fatal("key should not be expired in background")
+	}
+
+	result = server.Exec(c, utils.ToCmdLine("debug", "set-active-expire", "1"))
+	asserts.AssertStatusReply(t, result, "OK")
+	time.Sleep(1500 * time.Millisecond)
+	if _, ok := db.data.Get("k"); ok {
+		t.Error("key should be expired after active expire enabled")
+	}
+}
+
+func TestDebugMisc(t *testing.T) {
+	server := NewStandaloneServer()
+	defer server.Close()
+	c := connection.NewFakeConn()
+	result := server.Exec(c, utils.ToCmdLine("debug", "stringmatch-len"))
+	asserts.AssertStatusReply(t, result, "Apparently Godis did not crash: test passed")
+
+	replId := server.masterStatus.replId
+	result = server.Exec(c, utils.ToCmdLine("debug", "change-repl-id"))
+	asserts.AssertStatusReply(t, result, "OK")
+	if server.masterStatus.replId == replId {
+		t.Error("repl id should be changed")
+	}
+
+	result = server.Exec(c, utils.ToCmdLine("debug", "help"))
+	asserts.AssertNotError(t, result)
+	result = server.Exec(c, utils.ToCmdLine("debug", "set-active-expire"))
+	asserts.AssertErrReply(t, result, "Err syntax error")
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"expvar"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hdt3213/godis/lib/debughttp"
+	"github.com/hdt3213/godis/tcp"
+)
+
+// debugHTTP serves pprof and expvar on debug-http-addr
+type debugHTTP struct {
+	mu     sync.Mutex
+	addr   string
+	server *debughttp.Server
+}
+
+var (
+	publishVarsOnce sync.Once
+	varsMu          sync.Mutex
+	// varsServer is the latest server listening on debug-http-addr, its stats are published as expvar "godis"
+	varsServer *Server
+)
+
+// setDebugHTTPAddr moves the debug http server to addr, it stops if addr is empty.
+// The previous listener is kept if listening on the new addr failed
+func (server *Server) setDebugHTTPAddr(addr string) error {
+	d := &server.debugHTTP
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if addr == d.addr {
+		return nil
+	}
+	var started *debughttp.Server
+	if addr != "" {
+		var err error
+		started, err = debughttp.Start(addr)
+		if err != nil {
+			return err
+		}
+	}
+	if d.server != nil {
+		_ = d.server.Close()
+	}
+	d.addr = addr
+	d.server = started
+
+	varsMu.Lock()
+	if started != nil {
+		varsServer = server
+	} else if varsServer == server {
+		varsServer = nil
+	}
+	varsMu.Unlock()
+	if started != nil {
+		publishVarsOnce.Do(func() {
+			expvar.Publish("godis", expvar.Func(publishedVars))
+		})
+	}
+	return nil
+}
+
+func publishedVars() interface{} {
+	varsMu.Lock()
+	server := varsServer
+	varsMu.Unlock()
+	if server == nil {
+		return nil
+	}
+	stats := &server.stats
+	commands := make(map[string]int64)
+	names, cmdStats := stats.sortedCommands()
+	for i, name := range names {
+		commands[name] = cmdStats[i].calls.Load()
+	}
+	return map[string]interface{}{
+		"connected_clients":          atomic.LoadInt32(&tcp.ClientCounter),
+		"total_connections_received": stats.connectionsReceived.Load(),
+		"total_commands_processed":   stats.commandsProcessed.Load(),
+		"instantaneous_ops_per_sec":  stats.instantaneousOps(),
+		"expired_keys":               stats.expiredKeys.Load(),
+		"keyspace_hits":              stats.keyspaceHits.Load(),
+		"keyspace_misses":            stats.keyspaceMisses.Load(),
+		"commands":                   commands,
+	}
+}
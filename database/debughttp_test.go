@@ -0,0 +1,57 @@
+package database
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestDebugHTTP(t *testing.T) {
+	c := connection.NewFakeConn()
+	defer testServer.AfterClientClose(c)
+	result := testServer.Exec(c, utils.ToCmdLine("config", "set", "debug-http-addr", "127.0.0.1:0"))
+	asserts.AssertStatusReply(t, result, "OK")
+	defer testServer.Exec(c, utils.ToCmdLine("config", "set", "debug-http-addr", ""))
+	testServer.Exec(c, utils.ToCmdLine("set", "a", "a"))
+	baseURL := "http://" + testServer.debugHTTP.server.Addr()
+
+	resp, err := http.Get(baseURL + "/debug/vars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vars struct {
+		Godis struct {
+			Processed int64            `json:"total_commands_processed"`
+			Commands  map[string]int64 `json:"commands"`
+		} `json:"godis"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&vars)
+	_ = resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars.Godis.Processed == 0 || vars.Godis.Commands["set"] == 0 {
+		t.Errorf("unexpected vars %+v", vars.Godis)
+	}
+
+	resp, err = http.Get(baseURL + "/debug/pprof/goroutine?debug=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(body) == 0 {
+		t.Errorf("unexpected pprof response %d", resp.StatusCode)
+	}
+
+	result = testServer.Exec(c, utils.ToCmdLine("config", "set", "debug-http-addr", ""))
+	asserts.AssertStatusReply(t, result, "OK")
+	if _, err = http.Get(baseURL + "/debug/vars"); err == nil {
+		t.Error("debug http server should be closed")
+	}
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hdt3213/godis/aof"
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/clock"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+// execDump serializes value bound to key in redis DUMP format
+func execDump(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return protocol.MakeNullBulkReply()
+	}
+	payload, err := aof.DumpEntity(entity)
+	if err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
+	return protocol.MakeBulkReply(payload)
+}
+
+// execRestore usage: RESTORE key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency]
+func execRestore(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if ttl < 0 {
+		return protocol.MakeErrReply("ERR Invalid TTL value, must be >= 0")
+	}
+	replace := false
+	absTTL := false
+	idleTime := int64(-1)
+	freq := int64(-1)
+	for i := 3; i < len(args); i++ {
+		arg := strings.ToLower(string(args[i]))
+		switch {
+		case arg == "replace":
+			replace = true
+		case arg == "absttl":
+			absTTL = true
+		case arg == "idletime" && i+1 < len(args) && freq < 0:
+			idleTime, err = strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if idleTime < 0 {
+				return protocol.MakeErrReply("ERR Invalid IDLETIME value, must be >= 0")
+			}
+			i++
+		case arg == "freq" && i+1 < len(args) && idleTime < 0:
+			freq, err = strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if freq < 0 || freq > 255 {
+				return protocol.MakeErrReply("ERR Invalid FREQ value, must be >= 0 and <= 255")
+			}
+			i++
+		default:
+			return &protocol.SyntaxErrReply{}
+		}
+	}
+
+	_, exists := db.GetEntity(key)
+	if exists && !replace {
+		return protocol.MakeErrReply("BUSYKEY Target key name already exists.")
+	}
+	entity, err := aof.RestoreEntity(args[2])
+	if err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
+
+	var expireAt time.Time
+	if ttl > 0 {
+		if absTTL {
+			expireAt = time.UnixMilli(ttl)
+		} else {
+			expireAt = clock.Now().Add(time.Duration(ttl) * time.Millisecond)
+		}
+		if !expireAt.After(clock.Now()) {
+			// the key would be expired at once, just remove the old one
+			if exists {
+				db.Remove(key)
+				db.addAof(utils.ToCmdLine3("del", args[0]))
+			}
+			return protocol.MakeOkReply()
+		}
+	}
+	db.PutEntity(key, entity)
+	if ttl > 0 {
+		db.Expire(key, expireAt)
+	} else {
+		db.Persist(key)
+	}
+	lfu := config.Properties.IsLFUEnabled()
+	if idleTime >= 0 && !lfu {
+		entity.SetIdleTime(time.Duration(idleTime) * time.Second)
+	} else if freq >= 0 && lfu {
+		entity.SetLFUCounter(uint8(freq))
+	}
+
+	// use absolute ttl so that replaying aof won't prolong lifetime of key
+	cmdLine := utils.ToCmdLine3("restore", args[0], []byte("0"), args[2], []byte("REPLACE"))
+	if ttl > 0 {
+		cmdLine[2] = []byte(strconv.FormatInt(expireAt.UnixMilli(), 10))
+		cmdLine = append(cmdLine, []byte("ABSTTL"))
+	}
+	db.addAof(cmdLine)
+	return protocol.MakeOkReply()
+}
+
+func init() {
+	registerCommand("Dump", execDump, readFirstKey, nil, 2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom}, 1, 1, 1)
+	registerCommand("Restore", execRestore, writeFirstKey, rollbackFirstKey, -4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+	// RESTORE-ASKING is sent by MIGRATE in cluster mode, it is allowed to write importing slots
+	registerCommand("Restore-Asking", execRestore, writeFirstKey, rollbackFirstKey, -4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/protocol"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func dumpKey(t *testing.T, key string) []byte {
+	result := testDB.Exec(nil, utils.ToCmdLine("DUMP", key))
+	bulk, ok := result.(*protocol.BulkReply)
+	if !ok {
+		t.Fatalf("expect bulk reply for %s, actually %s", key, result.ToBytes())
+	}
+	return bulk.Arg
+}
+
+func TestDumpRedisCompatible(t *testing.T) {
+	testDB.Flush()
+	// payload generated by redis: SET mykey 10; DUMP mykey
+	redisPayload := "\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"
+	testDB.Exec(nil, utils.ToCmdLine("SET", "mykey", "10"))
+	payload := dumpKey(t, "mykey")
+	if string(payload) != redisPayload {
+		t.Errorf("expect %q, actually %q", redisPayload, payload)
+	}
+	result := testDB.Exec(nil, utils.ToCmdLine("RESTORE", "restored", "0", redisPayload))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testDB.Exec(nil, utils.ToCmdLine("GET", "restored"))
+	asserts.AssertBulkReply(t, result, "10")
+
+	result = testDB.Exec(nil, utils.ToCmdLine("DUMP", "nosuchkey"))
+	asserts.AssertNullBulk(t, result)
+}
+
+func TestDumpRestore(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("SET", "str", utils.RandString(100)))
+	testDB.Exec(nil, utils.ToCmdLine("RPUSH", "list", "a", "b", "1", "c"))
+	testDB.Exec(nil, utils.ToCmdLine("HMSET", "hash", "f1", "v1", "f2", "2"))
+	testDB.Exec(nil, utils.ToCmdLine("SADD", "set", "a", "b", "c"))
+	testDB.Exec(nil, utils.ToCmdLine("SADD", "intset", "1", "2", "3"))
+	testDB.Exec(nil, utils.ToCmdLine("ZADD", "zset", "1", "a", "2.5", "b"))
+	for i := 0; i < 1000; i++ {
+		testDB.Exec(nil, utils.ToCmdLine("RPUSH", "biglist", strconv.Itoa(i)))
+		testDB.Exec(nil, utils.ToCmdLine("HSET", "bighash", strconv.Itoa(i), strconv.Itoa(i)))
+	}
+	for _, key := range []string{"str", "list", "hash", "set", "intset", "zset", "biglist", "bighash"} {
+		payload := dumpKey(t, key)
+		result := testDB.Exec(nil, utils.ToCmdLine("RESTORE", key, "0", string(payload)))
+		asserts.AssertErrReply(t, result, "BUSYKEY Target key name already exists.")
+		restored := key + ":restored"
+		result = testDB.Exec(nil, utils.ToCmdLine("RESTORE", restored, "0", string(payload)))
+		asserts.AssertStatusReply(t, result, "OK")
+		if string(dumpKey(t, restored)) != string(payload) && key != "hash" && key != "set" && key != "bighash" {
+			t.Errorf("restored %s is different from origin", key)
+		}
+	}
+	result := testDB.Exec(nil, utils.ToCmdLine("LRANGE", "list:restored", "0", "-1"))
+	asserts.AssertMultiBulkReply(t, result, []string{"a", "b", "1", "c"})
+	result = testDB.Exec(nil, utils.ToCmdLine("LLEN", "biglist:restored"))
+	asserts.AssertIntReply(t, result, 1000)
+	result = testDB.Exec(nil, utils.ToCmdLine("HGET", "hash:restored", "f2"))
+	asserts.AssertBulkReply(t, result, "2")
+	result = testDB.Exec(nil, utils.ToCmdLine("HLEN", "bighash:restored"))
+	asserts.AssertIntReply(t, result, 1000)
+	result = testDB.Exec(nil, utils.ToCmdLine("SISMEMBER", "intset:restored", "2"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("ZSCORE", "zset:restored", "b"))
+	asserts.AssertBulkReply(t, result, "2.5")
+}
+
+func TestRestoreOptions(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("SET", "src", "value"))
+	payload := string(dumpKey(t, "src"))
+
+	result := testDB.Exec(nil, utils.ToCmdLine("RESTORE", "k", "10000", payload))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testDB.Exec(nil, utils.ToCmdLine("PTTL", "k"))
+	asserts.AssertIntReplyGreaterThan(t, result, 9000)
+
+	// REPLACE without ttl removes the old ttl
+	result = testDB.Exec(nil, utils.ToCmdLine("RESTORE", "k", "0", payload, "REPLACE"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testDB.Exec(nil, utils.ToCmdLine("TTL", "k"))
+	asserts.AssertIntReply(t, result, -1)
+
+	expireAt := time.Now().Add(time.Minute).UnixMilli()
+	result = testDB.Exec(nil, utils.ToCmdLine("RESTORE", "k", strconv.FormatInt(expireAt, 10), payload, "REPLACE", "ABSTTL"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testDB.Exec(nil, utils.ToCmdLine("PEXPIRETIME", "k"))
+	asserts.AssertIntReply(t, result, int(expireAt))
+
+	// expired absolute ttl removes the key
+	result = testDB.Exec(nil, utils.ToCmdLine("RESTORE", "k", "1", payload, "REPLACE", "ABSTTL"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testDB.Exec(nil, utils.ToCmdLine("EXISTS", "k"))
+	asserts.AssertIntReply(t, result, 0)
+
+	result = testDB.Exec(nil, utils.ToCmdLine("RESTORE", "k", "0", payload, "IDLETIME", "1000"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testDB.Exec(nil, utils.ToCmdLine("OBJECT", "IDLETIME", "k"))
+	asserts.AssertIntReplyGreaterThan(t, result, 999)
+
+	result = testDB.Exec(nil, utils.ToCmdLine("RESTORE", "k", "-1", payload))
+	asserts.AssertErrReply(t, result, "ERR Invalid TTL value, must be >= 0")
+	result = testDB.Exec(nil, utils.ToCmdLine("RESTORE", "k", "0", payload, "REPLACE", "IDLETIME", "1", "FREQ", "1"))
+	asserts.AssertErrReply(t, result, "Err syntax error")
+	result = testDB.Exec(nil, utils.ToCmdLine("RESTORE", "k", "0", payload, "REPLACE", "FREQ", "256"))
+	asserts.AssertErrReply(t, result, "ERR Invalid FREQ value, must be >= 0 and <= 255")
+
+	broken := []byte(payload)
+	broken[len(broken)-1]++
+	result = testDB.Exec(nil, utils.ToCmdLine("RESTORE", "k2", "0", string(broken)))
+	asserts.AssertErrReply(t, result, "ERR DUMP payload version or checksum are wrong")
+	result = testDB.Exec(nil, utils.ToCmdLine("EXISTS", "k2"))
+	asserts.AssertIntReply(t, result, 0)
+}
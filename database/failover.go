@@ -0,0 +1,264 @@
+package database
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/logger"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/client"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+/*
+`execFailover` promotes a slave to master gracefully:
+1. Master pauses writes from clients, and sends `REPLCONF GETACK *` to slaves periodically (waiting-for-sync)
+2. Once the target slave acks the latest offset, master sends `REPLICAOF NO ONE` to it (failover-in-progress)
+3. Master becomes slave of the target, paused writes resume and get READONLY error
+Failover is aborted by FAILOVER ABORT, timeout or any error during promoting, then master resumes writes.
+*/
+
+const (
+	failoverNone = int32(iota)
+	failoverWaitForSync
+	failoverInProgress
+)
+
+var failoverStateNames = map[int32]string{
+	failoverNone:        "no-failover",
+	failoverWaitForSync: "waiting-for-sync",
+	failoverInProgress:  "failover-in-progress",
+}
+
+// failoverCheckInterval is the interval of requesting acks from slaves during failover
+var failoverCheckInterval = 100 * time.Millisecond
+
+type failoverStatus struct {
+	mu    sync.Mutex
+	state int32
+	// abort is closed by FAILOVER ABORT
+	abort chan struct{}
+	// done is closed after failover finished or aborted, paused writes are waiting on it
+	done chan struct{}
+}
+
+var getAckBytes = protocol.MakeMultiBulkReply(utils.ToCmdLine("REPLCONF", "GETACK", "*")).ToBytes()
+
+// execFailover handles FAILOVER [TO host port [FORCE]] [ABORT] [TIMEOUT milliseconds]
+func (server *Server) execFailover(args [][]byte) redis.Reply {
+	var host string
+	var port int
+	var timeout time.Duration
+	var force, abort bool
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToLower(string(args[i]))
+		if arg == "to" && i+2 < len(args) {
+			host = string(args[i+1])
+			p, err := strconv.Atoi(string(args[i+2]))
+			if err != nil {
+				return protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			port = p
+			i += 2
+		} else if arg == "timeout" && i+1 < len(args) {
+			ms, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if ms <= 0 {
+				return protocol.MakeErrReply("ERR FAILOVER timeout must be greater than 0")
+			}
+			timeout = time.Duration(ms) * time.Millisecond
+			i++
+		} else if arg == "force" {
+			force = true
+		} else if arg == "abort" {
+			abort = true
+		} else {
+			return protocol.MakeSyntaxErrReply()
+		}
+	}
+	if abort {
+		if host != "" || force || timeout > 0 {
+			return protocol.MakeErrReply("ERR FAILOVER ABORT can not be used with other arguments")
+		}
+		return server.abortFailover()
+	}
+	if force && (host == "" || timeout == 0) {
+		return protocol.MakeErrReply("ERR FAILOVER with force option requires both a timeout and target HOST and IP")
+	}
+	if atomic.LoadInt32(&server.role) == slaveRole {
+		return protocol.MakeErrReply("ERR FAILOVER is not valid when server is a replica")
+	}
+
+	failover := server.failover
+	failover.mu.Lock()
+	defer failover.mu.Unlock()
+	if failover.state != failoverNone {
+		return protocol.MakeErrReply("ERR FAILOVER already in progress")
+	}
+	target, errReply := server.pickFailoverTarget(host, port)
+	if errReply != nil {
+		return errReply
+	}
+	host, port = target.addr()
+	failover.state = failoverWaitForSync
+	failover.abort = make(chan struct{})
+	failover.done = make(chan struct{})
+	logger.Infof("failover to %s:%d started", host, port)
+	go server.doFailover(target, host, port, timeout, force)
+	return protocol.MakeOkReply()
+}
+
+// pickFailoverTarget returns the online slave listening on host:port, or any online slave if host is empty
+func (server *Server) pickFailoverTarget(host string, port int) (*slaveClient, redis.Reply) {
+	server.masterStatus.mu.RLock()
+	defer server.masterStatus.mu.RUnlock()
+	for slave := range server.masterStatus.onlineSlaves {
+		slaveHost, slavePort := slave.addr()
+		if slavePort == 0 {
+			// address is unknown without REPLCONF listening-port
+			continue
+		}
+		if host == "" || (slaveHost == host && slavePort == port) {
+			return slave, nil
+		}
+	}
+	if host == "" {
+		return nil, protocol.MakeErrReply("ERR FAILOVER requires connected replicas")
+	}
+	return nil, protocol.MakeErrReply("ERR FAILOVER target HOST and PORT is not a replica")
+}
+
+func (server *Server) abortFailover() redis.Reply {
+	failover := server.failover
+	failover.mu.Lock()
+	defer failover.mu.Unlock()
+	if failover.state == failoverNone {
+		return protocol.MakeErrReply("ERR No failover in progress")
+	}
+	if failover.state == failoverInProgress {
+		return protocol.MakeErrReply("ERR FAILOVER is in progress and can not be aborted")
+	}
+	select {
+	case <-failover.abort:
+	default:
+		close(failover.abort)
+	}
+	return protocol.MakeOkReply()
+}
+
+func (server *Server) doFailover(target *slaveClient, host string, port int, timeout time.Duration, force bool) {
+	failover := server.failover
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Errorf("panic: %v", err)
+		}
+		failover.mu.Lock()
+		failover.state = failoverNone
+		close(failover.done)
+		failover.mu.Unlock()
+	}()
+	err := server.waitFailoverTarget(target, timeout, force)
+	if err != nil {
+		logger.Errorf("failover to %s:%d aborted: %v", host, port, err)
+		return
+	}
+
+	failover.mu.Lock()
+	failover.state = failoverInProgress
+	failover.mu.Unlock()
+	addr := host + ":" + strconv.Itoa(port)
+	err = promoteSlave(addr)
+	if err != nil {
+		logger.Errorf("failover to %s aborted, promote slave failed: %v", addr, err)
+		return
+	}
+	// demote self before resuming writes
+	server.slaveOf(host, port)
+	logger.Infof("failover to %s finished", addr)
+}
+
+// waitFailoverTarget waits until target has received all updates
+func (server *Server) waitFailoverTarget(target *slaveClient, timeout time.Duration, force bool) error {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	ticker := time.NewTicker(failoverCheckInterval)
+	defer ticker.Stop()
+	startTime := time.Now()
+	for {
+		server.masterStatus.mu.Lock()
+		if _, ok := server.masterStatus.onlineSlaves[target]; !ok {
+			server.masterStatus.mu.Unlock()
+			return errors.New("target replica disconnected")
+		}
+		// slave should ack after failover started, its offset may be stale otherwise
+		if target.lastAckTime.After(startTime) && target.ackOffset >= server.masterStatus.backlog.currentOffset {
+			server.masterStatus.mu.Unlock()
+			return nil
+		}
+		server.masterStatus.backlog.appendBytes(getAckBytes)
+		server.masterStatus.mu.Unlock()
+		if err := server.masterSendUpdatesToSlave(); err != nil {
+			return err
+		}
+		select {
+		case <-server.failover.abort:
+			return errors.New("aborted by user")
+		case <-deadline:
+			if force {
+				return nil
+			}
+			return errors.New("timeout waiting for replica to catch up")
+		case <-ticker.C:
+		}
+	}
+}
+
+// promoteSlave sends `REPLICAOF NO ONE` to the slave listening on addr
+func promoteSlave(addr string) error {
+	cli, err := client.Dial(addr, nil)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+	reply, err := cli.Do("REPLICAOF", "NO", "ONE")
+	if err != nil {
+		return err
+	}
+	return protocol.Try2ErrorReply(reply)
+}
+
+// waitFailover pauses writes until in-progress failover finished
+func (server *Server) waitFailover() {
+	failover := server.failover
+	if failover == nil {
+		return
+	}
+	failover.mu.Lock()
+	if failover.state == failoverNone {
+		failover.mu.Unlock()
+		return
+	}
+	done := failover.done
+	failover.mu.Unlock()
+	<-done
+}
+
+func (server *Server) getFailoverState() string {
+	if server.failover == nil {
+		return failoverStateNames[failoverNone]
+	}
+	server.failover.mu.Lock()
+	defer server.failover.mu.Unlock()
+	return failoverStateNames[server.failover.state]
+}
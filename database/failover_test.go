@@ -0,0 +1,192 @@
+package database
+
+import (
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/godis/aof"
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/parser"
+	"github.com/hdt3213/godis/redis/protocol"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+// mockReplicaListener accepts connections, replies OK to all commands and sends received command names to the returned channel
+func mockReplicaListener(t *testing.T) (int, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	received := make(chan string, 100)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				for payload := range parser.ParseStream(conn) {
+					if payload.Err != nil {
+						return
+					}
+					if cmdLine, ok := payload.Data.(*protocol.MultiBulkReply); ok {
+						select {
+						case received <- strings.ToLower(string(cmdLine.Args[0])):
+						default:
+						}
+					}
+					_, _ = conn.Write(protocol.MakeOkReply().ToBytes())
+				}
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port, received
+}
+
+// makeFailoverMaster returns a master with an online slave announcing the given port
+func makeFailoverMaster(t *testing.T, port int) (*Server, *connection.FakeConn, <-chan *parser.Payload) {
+	oldProperties := config.Properties
+	t.Cleanup(func() {
+		config.Properties = oldProperties
+	})
+	config.Properties = &config.ServerProperties{
+		Databases:       16,
+		AppendOnly:      true,
+		AppendFilename:  path.Join(t.TempDir(), "a.aof"),
+		ReplicaReadOnly: true,
+	}
+	master := mockServer()
+	aofHandler, err := NewPersister(master, config.Properties.AppendFilename, true, aof.FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	master.bindPersister(aofHandler)
+	t.Cleanup(master.Close)
+
+	replConn := connection.NewFakeConn()
+	ret := master.Exec(replConn, utils.ToCmdLine("REPLCONF", "listening-port", strconv.Itoa(port), "ip-address", "127.0.0.1"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	master.Exec(replConn, utils.ToCmdLine("PSYNC", "?", "-1"))
+	masterChan := parser.ParseStream(replConn)
+	<-masterChan // FULLRESYNC header
+	<-masterChan // rdb
+	for i := 0; i < 50; i++ {
+		if strings.Contains(getInfoReplication(master), "connected_slaves:1\r\n") {
+			return master, replConn, masterChan
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("slave is not online")
+	return nil, nil, nil
+}
+
+func getInfoReplication(server *Server) string {
+	ret := server.Exec(connection.NewFakeConn(), utils.ToCmdLine("INFO", "replication"))
+	return string(ret.(*protocol.BulkReply).Arg)
+}
+
+func TestFailover(t *testing.T) {
+	port, received := mockReplicaListener(t)
+	master, replConn, masterChan := makeFailoverMaster(t, port)
+	conn := connection.NewFakeConn()
+	ret := master.Exec(conn, utils.ToCmdLine("SET", "a", "a"))
+	asserts.AssertStatusReply(t, ret, "OK")
+
+	ret = master.Exec(conn, utils.ToCmdLine("FAILOVER", "TO", "127.0.0.1", strconv.Itoa(port+1)))
+	asserts.AssertErrReply(t, ret, "ERR FAILOVER target HOST and PORT is not a replica")
+	ret = master.Exec(conn, utils.ToCmdLine("FAILOVER", "TO", "127.0.0.1", strconv.Itoa(port), "TIMEOUT", "5000"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	if !strings.Contains(getInfoReplication(master), "master_failover_state:waiting-for-sync\r\n") {
+		t.Error("expect waiting-for-sync")
+	}
+	ret = master.Exec(conn, utils.ToCmdLine("FAILOVER"))
+	asserts.AssertErrReply(t, ret, "ERR FAILOVER already in progress")
+
+	// writes are paused
+	writeResult := make(chan redis.Reply, 1)
+	go func() {
+		writeResult <- master.Exec(connection.NewFakeConn(), utils.ToCmdLine("SET", "b", "b"))
+	}()
+	select {
+	case <-writeResult:
+		t.Fatal("expect write paused")
+	case <-time.After(200 * time.Millisecond):
+	}
+	ret = master.Exec(conn, utils.ToCmdLine("GET", "a"))
+	asserts.AssertBulkReply(t, ret, "a")
+
+	// slave acks the latest offset
+	for payload := range masterChan {
+		cmdLine, ok := payload.Data.(*protocol.MultiBulkReply)
+		if ok && isGetAck(cmdLine.Args) {
+			break
+		}
+	}
+	master.masterStatus.mu.RLock()
+	offset := master.masterStatus.backlog.currentOffset
+	master.masterStatus.mu.RUnlock()
+	master.Exec(replConn, utils.ToCmdLine("REPLCONF", "ACK", strconv.FormatInt(offset, 10)))
+
+	select {
+	case cmd := <-received:
+		if cmd != "replicaof" {
+			t.Errorf("expect replicaof, actual %s", cmd)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("slave is not promoted")
+	}
+	select {
+	case ret = <-writeResult:
+		asserts.AssertErrReply(t, ret, "READONLY You can't write against a read only slave.")
+	case <-time.After(3 * time.Second):
+		t.Fatal("paused write is not resumed")
+	}
+	info := getInfoReplication(master)
+	if !strings.Contains(info, "role:slave\r\n") ||
+		!strings.Contains(info, "master_port:"+strconv.Itoa(port)+"\r\n") ||
+		!strings.Contains(info, "master_failover_state:no-failover\r\n") {
+		t.Errorf("unexpected replication info: %s", info)
+	}
+	ret = master.Exec(conn, utils.ToCmdLine("FAILOVER"))
+	asserts.AssertErrReply(t, ret, "ERR FAILOVER is not valid when server is a replica")
+}
+
+func TestFailoverAbort(t *testing.T) {
+	port, _ := mockReplicaListener(t)
+	master, _, _ := makeFailoverMaster(t, port)
+	conn := connection.NewFakeConn()
+	ret := master.Exec(conn, utils.ToCmdLine("FAILOVER", "ABORT"))
+	asserts.AssertErrReply(t, ret, "ERR No failover in progress")
+	ret = master.Exec(conn, utils.ToCmdLine("FAILOVER", "TO", "127.0.0.1", strconv.Itoa(port), "FORCE"))
+	asserts.AssertErrReply(t, ret, "ERR FAILOVER with force option requires both a timeout and target HOST and IP")
+
+	ret = master.Exec(conn, utils.ToCmdLine("FAILOVER"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = master.Exec(conn, utils.ToCmdLine("FAILOVER", "ABORT"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = master.Exec(conn, utils.ToCmdLine("SET", "a", "a"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	info := getInfoReplication(master)
+	if !strings.Contains(info, "role:master\r\n") || !strings.Contains(info, "master_failover_state:no-failover\r\n") {
+		t.Errorf("unexpected replication info: %s", info)
+	}
+
+	// slave does not catch up in time
+	ret = master.Exec(conn, utils.ToCmdLine("FAILOVER", "TIMEOUT", "100"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = master.Exec(conn, utils.ToCmdLine("SET", "a", "b"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	if !strings.Contains(getInfoReplication(master), "role:master\r\n") {
+		t.Error("expect failover aborted")
+	}
+}
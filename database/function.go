@@ -0,0 +1,610 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/lib/wildcard"
+	"github.com/hdt3213/godis/redis/protocol"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// functionLoadTimeout limits the time to run library code in FUNCTION LOAD, which should only register functions
+const functionLoadTimeout = 500 * time.Millisecond
+
+// flags which could be given to redis.register_function
+var functionFlags = map[string]struct{}{
+	"no-writes":             {},
+	"allow-oom":             {},
+	"allow-stale":           {},
+	"no-cluster":            {},
+	"allow-cross-slot-keys": {},
+}
+
+// luaLibrary is a library loaded by FUNCTION LOAD, it is immutable once loaded
+type luaLibrary struct {
+	name string
+	// code is the source including metadata line, it is saved in aof and rdb
+	code  string
+	proto *lua.FunctionProto
+	// function name -> *luaFunction
+	functions map[string]*luaFunction
+}
+
+// luaFunction is a function registered by redis.register_function
+type luaFunction struct {
+	name        string
+	library     *luaLibrary
+	description string
+	flags       []string
+	// noWrites functions could be called by FCALL_RO, they are not allowed to call write commands
+	noWrites bool
+}
+
+// registeredFunction is the arguments of redis.register_function
+type registeredFunction struct {
+	name        string
+	callback    *lua.LFunction
+	description string
+	flags       []string
+}
+
+// parseLibraryMetadata parses the first line of library code, such as `#!lua name=mylib`, returns library name
+func parseLibraryMetadata(code string) (string, error) {
+	if !strings.HasPrefix(code, "#!") {
+		return "", errors.New("ERR Missing library metadata")
+	}
+	line := code[2:]
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	parts := strings.Fields(line)
+	if len(parts) == 0 || strings.ToLower(parts[0]) != "lua" {
+		engine := ""
+		if len(parts) > 0 {
+			engine = parts[0]
+		}
+		return "", errors.New("ERR Engine '" + engine + "' not found")
+	}
+	name := ""
+	for _, part := range parts[1:] {
+		if !strings.HasPrefix(part, "name=") {
+			return "", errors.New("ERR Invalid metadata value given: " + part)
+		}
+		name = part[len("name="):]
+	}
+	if name == "" {
+		return "", errors.New("ERR Library name was not given")
+	}
+	if !isValidFunctionName(name) {
+		return "", errors.New("ERR Library names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	return name, nil
+}
+
+func isValidFunctionName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// raiseReply raises an error which is returned to the client as is
+func raiseReply(L *lua.LState, msg string) {
+	L.Error(makeLuaReplyTable(L, "err", msg), 1)
+}
+
+// parseRegisterFunction parses arguments of redis.register_function, which could be
+// (name, callback) or a table with function_name, callback, flags and description
+func parseRegisterFunction(L *lua.LState) *registeredFunction {
+	fn := &registeredFunction{}
+	switch L.GetTop() {
+	case 2:
+		name, ok := L.Get(1).(lua.LString)
+		if !ok {
+			raiseReply(L, "ERR wrong argument given to redis.register_function")
+			return nil
+		}
+		fn.name = string(name)
+		fn.callback, ok = L.Get(2).(*lua.LFunction)
+		if !ok {
+			raiseReply(L, "ERR wrong argument given to redis.register_function")
+			return nil
+		}
+	case 1:
+		tbl, ok := L.Get(1).(*lua.LTable)
+		if !ok {
+			raiseReply(L, "ERR calling redis.register_function with a single argument is only applicable to Lua table")
+			return nil
+		}
+		var errMsg string
+		tbl.ForEach(func(key lua.LValue, value lua.LValue) {
+			if errMsg != "" {
+				return
+			}
+			switch key.String() {
+			case "function_name":
+				if name, ok := value.(lua.LString); ok {
+					fn.name = string(name)
+					return
+				}
+				errMsg = "ERR function_name argument given to redis.register_function must be a string"
+			case "callback":
+				if callback, ok := value.(*lua.LFunction); ok {
+					fn.callback = callback
+					return
+				}
+				errMsg = "ERR callback argument given to redis.register_function must be a function"
+			case "description":
+				if desc, ok := value.(lua.LString); ok {
+					fn.description = string(desc)
+					return
+				}
+				errMsg = "ERR description argument given to redis.register_function must be a string"
+			case "flags":
+				flags, ok := value.(*lua.LTable)
+				if !ok {
+					errMsg = "ERR flags argument to redis.register_function must be a table representing function flags"
+					return
+				}
+				for i := 1; i <= flags.Len(); i++ {
+					flag := flags.RawGetInt(i).String()
+					if _, ok := functionFlags[flag]; !ok {
+						errMsg = "ERR Unknown flag given: " + flag
+						return
+					}
+					fn.flags = append(fn.flags, flag)
+				}
+			default:
+				errMsg = "ERR unknown argument given to redis.register_function"
+			}
+		})
+		if errMsg != "" {
+			raiseReply(L, errMsg)
+			return nil
+		}
+		if fn.name == "" || fn.callback == nil {
+			raiseReply(L, "ERR redis.register_function must get a function name argument and a callback argument")
+			return nil
+		}
+	default:
+		raiseReply(L, "ERR wrong number of arguments to redis.register_function")
+		return nil
+	}
+	if !isValidFunctionName(fn.name) {
+		raiseReply(L, "ERR Function names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+		return nil
+	}
+	return fn
+}
+
+// compileLibrary compiles library code and runs it to collect registered functions
+func compileLibrary(code string) (*luaLibrary, redis.Reply) {
+	name, err := parseLibraryMetadata(code)
+	if err != nil {
+		return nil, protocol.MakeErrReply(err.Error())
+	}
+	// the metadata line is not lua, keep the line break so that line numbers in error messages are right
+	body := code[strings.IndexByte(code+"\n", '\n'):]
+	proto, err := compileLua(body, "@user_function")
+	if err != nil {
+		return nil, protocol.MakeErrReply("ERR Error compiling function: " + err.Error())
+	}
+	lib := &luaLibrary{
+		name:      name,
+		code:      code,
+		proto:     proto,
+		functions: make(map[string]*luaFunction),
+	}
+	L := newLuaState()
+	defer L.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), functionLoadTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+	// redis.call is not available while loading, library code should only register functions
+	redisLib := L.NewTable()
+	redisLib.RawSetString("register_function", L.NewFunction(func(L *lua.LState) int {
+		registered := parseRegisterFunction(L)
+		if _, ok := lib.functions[registered.name]; ok {
+			raiseReply(L, "ERR Function already exists in the library")
+			return 0
+		}
+		fn := &luaFunction{
+			name:        registered.name,
+			library:     lib,
+			description: registered.description,
+			flags:       registered.flags,
+		}
+		for _, flag := range fn.flags {
+			if flag == "no-writes" {
+				fn.noWrites = true
+			}
+		}
+		lib.functions[fn.name] = fn
+		return 0
+	}))
+	L.SetGlobal("redis", redisLib)
+	L.Push(L.NewFunctionFromProto(proto))
+	if err := L.PCall(0, 0, nil); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, protocol.MakeErrReply("ERR FUNCTION LOAD timeout")
+		}
+		if apiErr, ok := err.(*lua.ApiError); ok {
+			if tbl, ok := apiErr.Object.(*lua.LTable); ok {
+				// error raised by redis.register_function
+				if reply := luaToReply(tbl); protocol.IsErrorReply(reply) {
+					return nil, reply
+				}
+			}
+			return nil, protocol.MakeErrReply("ERR Error registering functions: " + apiErr.Object.String())
+		}
+		return nil, protocol.MakeErrReply("ERR Error registering functions: " + err.Error())
+	}
+	if len(lib.functions) == 0 {
+		return nil, protocol.MakeErrReply("ERR No functions registered")
+	}
+	return lib, nil
+}
+
+// loadLibrary compiles and registers a library, returns name of the library
+func (engine *scriptEngine) loadLibrary(code string, replace bool) (string, redis.Reply) {
+	lib, errReply := compileLibrary(code)
+	if errReply != nil {
+		return "", errReply
+	}
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	old, exists := engine.libraries[lib.name]
+	if exists && !replace {
+		return "", protocol.MakeErrReply("ERR Library '" + lib.name + "' already exists")
+	}
+	for name := range lib.functions {
+		if fn, ok := engine.functions[name]; ok && fn.library != old {
+			return "", protocol.MakeErrReply("ERR Function " + name + " already exists")
+		}
+	}
+	if exists {
+		engine.removeLibrary(old)
+	}
+	if engine.libraries == nil {
+		engine.libraries = make(map[string]*luaLibrary)
+		engine.functions = make(map[string]*luaFunction)
+	}
+	engine.libraries[lib.name] = lib
+	for name, fn := range lib.functions {
+		engine.functions[name] = fn
+	}
+	return lib.name, nil
+}
+
+// removeLibrary removes lib and its functions, engine.mu must be held
+func (engine *scriptEngine) removeLibrary(lib *luaLibrary) {
+	delete(engine.libraries, lib.name)
+	for name := range lib.functions {
+		delete(engine.functions, name)
+	}
+}
+
+func (engine *scriptEngine) deleteLibrary(name string) bool {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	lib, ok := engine.libraries[name]
+	if !ok {
+		return false
+	}
+	engine.removeLibrary(lib)
+	return true
+}
+
+func (engine *scriptEngine) flushLibraries() {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	engine.libraries = nil
+	engine.functions = nil
+}
+
+// copyLibraries replaces libraries of engine with the ones of src, it is used after full resynchronization
+func (engine *scriptEngine) copyLibraries(src *scriptEngine) {
+	src.mu.Lock()
+	libraries := make(map[string]*luaLibrary, len(src.libraries))
+	functions := make(map[string]*luaFunction, len(src.functions))
+	for name, lib := range src.libraries {
+		libraries[name] = lib
+	}
+	for name, fn := range src.functions {
+		functions[name] = fn
+	}
+	src.mu.Unlock()
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	engine.libraries = libraries
+	engine.functions = functions
+}
+
+func (engine *scriptEngine) getFunction(name string) (*luaFunction, bool) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	fn, ok := engine.functions[name]
+	return fn, ok
+}
+
+// sortedLibraries returns all libraries sorted by name
+func (engine *scriptEngine) sortedLibraries() []*luaLibrary {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	libraries := make([]*luaLibrary, 0, len(engine.libraries))
+	for _, lib := range engine.libraries {
+		libraries = append(libraries, lib)
+	}
+	sort.Slice(libraries, func(i, j int) bool {
+		return libraries[i].name < libraries[j].name
+	})
+	return libraries
+}
+
+// DumpFunctions returns code of all libraries loaded by FUNCTION LOAD, they are saved along with dataset
+func (server *Server) DumpFunctions() []string {
+	libraries := server.scripts.sortedLibraries()
+	codes := make([]string, len(libraries))
+	for i, lib := range libraries {
+		codes[i] = lib.code
+	}
+	return codes
+}
+
+// fcall runs a function: FCALL function numkeys [key ...] [arg ...]
+func fcall(db *DB, args [][]byte, readOnly bool) redis.Reply {
+	if db.scripts == nil {
+		return protocol.MakeErrReply("ERR scripting is not available")
+	}
+	fn, ok := db.scripts.getFunction(string(args[0]))
+	if !ok {
+		return protocol.MakeErrReply("ERR Function not found")
+	}
+	if readOnly && !fn.noWrites {
+		return protocol.MakeErrReply("ERR Can not execute a script with write flag using *_ro command.")
+	}
+	keys, argv, errReply := parseScriptKeys(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	run, ctx := db.scripts.begin(db, keys, readOnly || fn.noWrites, fn.name)
+	defer db.scripts.end(run)
+	L := newLuaState()
+	defer L.Close()
+	L.SetContext(ctx)
+	// lua states are not shared by concurrent calls, so the library runs again to register callbacks in this state
+	callbacks := make(map[string]*lua.LFunction, len(fn.library.functions))
+	redisLib := run.openRedisLib(L)
+	redisLib.RawSetString("register_function", L.NewFunction(func(L *lua.LState) int {
+		registered := parseRegisterFunction(L)
+		callbacks[registered.name] = registered.callback
+		return 0
+	}))
+	reply := run.call(L, L.NewFunctionFromProto(fn.library.proto), fn.library.name)
+	if protocol.IsErrorReply(reply) {
+		return reply
+	}
+	callback, ok := callbacks[fn.name]
+	if !ok {
+		return protocol.MakeErrReply("ERR Function not found")
+	}
+	return run.call(L, callback, fn.name, makeLuaStringArray(L, keys), makeLuaStringArray(L, argv))
+}
+
+// isNoWritesFCall returns whether cmdLine calls a function declared with no-writes flag by FCALL
+func (server *Server) isNoWritesFCall(cmdLine [][]byte) bool {
+	if len(cmdLine) < 2 || strings.ToLower(string(cmdLine[0])) != "fcall" {
+		return false
+	}
+	fn, ok := server.scripts.getFunction(string(cmdLine[1]))
+	return ok && fn.noWrites
+}
+
+// execFCall runs a function registered by FUNCTION LOAD
+func execFCall(db *DB, args [][]byte) redis.Reply {
+	return fcall(db, args, false)
+}
+
+// execFCallRO runs a function with no-writes flag
+func execFCallRO(db *DB, args [][]byte) redis.Reply {
+	return fcall(db, args, true)
+}
+
+func makeLibraryReply(lib *luaLibrary, withCode bool) redis.Reply {
+	names := make([]string, 0, len(lib.functions))
+	for name := range lib.functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	functions := make([]redis.Reply, len(names))
+	for i, name := range names {
+		fn := lib.functions[name]
+		var description redis.Reply = protocol.MakeNullBulkReply()
+		if fn.description != "" {
+			description = protocol.MakeBulkReply([]byte(fn.description))
+		}
+		flags := make([][]byte, len(fn.flags))
+		for j, flag := range fn.flags {
+			flags[j] = []byte(flag)
+		}
+		functions[i] = protocol.MakeMultiRawReply([]redis.Reply{
+			protocol.MakeBulkReply([]byte("name")),
+			protocol.MakeBulkReply([]byte(fn.name)),
+			protocol.MakeBulkReply([]byte("description")),
+			description,
+			protocol.MakeBulkReply([]byte("flags")),
+			protocol.MakeMultiBulkReply(flags),
+		})
+	}
+	result := []redis.Reply{
+		protocol.MakeBulkReply([]byte("library_name")),
+		protocol.MakeBulkReply([]byte(lib.name)),
+		protocol.MakeBulkReply([]byte("engine")),
+		protocol.MakeBulkReply([]byte("LUA")),
+		protocol.MakeBulkReply([]byte("functions")),
+		protocol.MakeMultiRawReply(functions),
+	}
+	if withCode {
+		result = append(result,
+			protocol.MakeBulkReply([]byte("library_code")),
+			protocol.MakeBulkReply([]byte(lib.code)))
+	}
+	return protocol.MakeMultiRawReply(result)
+}
+
+// execFunctionList returns libraries: FUNCTION LIST [LIBRARYNAME pattern] [WITHCODE]
+func (server *Server) execFunctionList(args [][]byte) redis.Reply {
+	var pattern *wildcard.Pattern
+	withCode := false
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToLower(string(args[i]))
+		if arg == "withcode" && !withCode {
+			withCode = true
+		} else if arg == "libraryname" && pattern == nil && i+1 < len(args) {
+			var err error
+			pattern, err = wildcard.CompilePattern(string(args[i+1]))
+			if err != nil {
+				return protocol.MakeErrReply("ERR illegal wildcard")
+			}
+			i++
+		} else {
+			return protocol.MakeErrReply("ERR Unknown argument " + string(args[i]))
+		}
+	}
+	result := make([]redis.Reply, 0)
+	for _, lib := range server.scripts.sortedLibraries() {
+		if pattern != nil && !pattern.IsMatch(lib.name) {
+			continue
+		}
+		result = append(result, makeLibraryReply(lib, withCode))
+	}
+	return protocol.MakeMultiRawReply(result)
+}
+
+// execFunctionStats returns the running function and count of libraries
+func (server *Server) execFunctionStats() redis.Reply {
+	engine := &server.scripts
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	var running redis.Reply = protocol.MakeNullBulkReply()
+	for run := range engine.running {
+		if run.function == "" {
+			continue
+		}
+		running = protocol.MakeMultiRawReply([]redis.Reply{
+			protocol.MakeBulkReply([]byte("name")),
+			protocol.MakeBulkReply([]byte(run.function)),
+			protocol.MakeBulkReply([]byte("duration_ms")),
+			protocol.MakeIntReply(time.Since(run.start).Milliseconds()),
+		})
+		break
+	}
+	return protocol.MakeMultiRawReply([]redis.Reply{
+		protocol.MakeBulkReply([]byte("running_script")),
+		running,
+		protocol.MakeBulkReply([]byte("engines")),
+		protocol.MakeMultiRawReply([]redis.Reply{
+			protocol.MakeBulkReply([]byte("LUA")),
+			protocol.MakeMultiRawReply([]redis.Reply{
+				protocol.MakeBulkReply([]byte("libraries_count")),
+				protocol.MakeIntReply(int64(len(engine.libraries))),
+				protocol.MakeBulkReply([]byte("functions_count")),
+				protocol.MakeIntReply(int64(len(engine.functions))),
+			}),
+		}),
+	})
+}
+
+// execFunction handles FUNCTION LOAD, DELETE, FLUSH, LIST, KILL and STATS.
+// Libraries are shared by all dbs, changes of them are saved in aof and propagated to replicas.
+func (server *Server) execFunction(c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.MakeArgNumErrReply("function")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "load", "delete", "flush":
+		role := atomic.LoadInt32(&server.role)
+		if role == slaveRole && !c.IsMaster() && config.Properties.ReplicaReadOnly {
+			return protocol.MakeErrReply("READONLY You can't write against a read only slave.")
+		}
+	}
+	switch subCmd {
+	case "load":
+		if len(args) != 2 && len(args) != 3 {
+			return protocol.MakeArgNumErrReply("function|load")
+		}
+		replace := false
+		if len(args) == 3 {
+			if strings.ToLower(string(args[1])) != "replace" {
+				return protocol.MakeErrReply("ERR Unknown option given: " + string(args[1]))
+			}
+			replace = true
+		}
+		code := string(args[len(args)-1])
+		name, errReply := server.scripts.loadLibrary(code, replace)
+		if errReply != nil {
+			return errReply
+		}
+		// the library may not exist on replicas or in aof after rewriting, so always replace
+		server.AddAof(0, utils.ToCmdLine("FUNCTION", "LOAD", "REPLACE", code))
+		return protocol.MakeBulkReply([]byte(name))
+	case "delete":
+		if len(args) != 2 {
+			return protocol.MakeArgNumErrReply("function|delete")
+		}
+		if !server.scripts.deleteLibrary(string(args[1])) {
+			return protocol.MakeErrReply("ERR Library not found")
+		}
+		server.AddAof(0, utils.ToCmdLine("FUNCTION", "DELETE", string(args[1])))
+		return protocol.MakeOkReply()
+	case "flush":
+		if len(args) > 2 {
+			return protocol.MakeArgNumErrReply("function|flush")
+		}
+		if len(args) == 2 {
+			mode := strings.ToLower(string(args[1]))
+			if mode != "async" && mode != "sync" {
+				return protocol.MakeErrReply("ERR FUNCTION FLUSH only supports SYNC|ASYNC option")
+			}
+		}
+		server.scripts.flushLibraries()
+		server.AddAof(0, utils.ToCmdLine("FUNCTION", "FLUSH"))
+		return protocol.MakeOkReply()
+	case "list":
+		return server.execFunctionList(args[1:])
+	case "kill":
+		if len(args) != 1 {
+			return protocol.MakeArgNumErrReply("function|kill")
+		}
+		return server.scripts.kill(true)
+	case "stats":
+		if len(args) != 1 {
+			return protocol.MakeArgNumErrReply("function|stats")
+		}
+		return server.execFunctionStats()
+	}
+	return protocol.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try FUNCTION HELP.")
+}
+
+func init() {
+	registerCommand("FCall", execFCall, prepareEval, undoEval, -3, flagWrite).
+		attachCommandExtra([]string{redisFlagNoScript, redisFlagMovableKeys}, 0, 0, 0)
+	registerCommand("FCall_RO", execFCallRO, prepareEvalRO, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagNoScript, redisFlagReadonly, redisFlagMovableKeys}, 0, 0, 0)
+	registerSpecialCommand("Function", -2, 0).
+		attachCommandExtra([]string{redisFlagNoScript}, 0, 0, 0)
+}
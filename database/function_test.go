@@ -0,0 +1,254 @@
+package database
+
+import (
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/godis/aof"
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+const testLibrary = `#!lua name=mylib
+local function myset(keys, args)
+  return redis.call('set', keys[1], args[1])
+end
+redis.register_function('myset', myset)
+redis.register_function{
+  function_name = 'myget',
+  callback = function(keys, args) return redis.call('get', keys[1]) end,
+  flags = {'no-writes'},
+  description = 'get a key',
+}`
+
+func TestFunctionLoad(t *testing.T) {
+	c := connection.NewFakeConn()
+	testServer.Exec(c, utils.ToCmdLine("function", "flush"))
+	result := testServer.Exec(c, utils.ToCmdLine("function", "load", testLibrary))
+	asserts.AssertBulkReply(t, result, "mylib")
+	result = testServer.Exec(c, utils.ToCmdLine("function", "load", testLibrary))
+	asserts.AssertErrReply(t, result, "ERR Library 'mylib' already exists")
+	result = testServer.Exec(c, utils.ToCmdLine("function", "load", "replace", testLibrary))
+	asserts.AssertBulkReply(t, result, "mylib")
+
+	result = testServer.Exec(c, utils.ToCmdLine("function", "list"))
+	expected := "*1\r\n*6\r\n$12\r\nlibrary_name\r\n$5\r\nmylib\r\n$6\r\nengine\r\n$3\r\nLUA\r\n$9\r\nfunctions\r\n*2\r\n" +
+		"*6\r\n$4\r\nname\r\n$5\r\nmyget\r\n$11\r\ndescription\r\n$9\r\nget a key\r\n$5\r\nflags\r\n*1\r\n$9\r\nno-writes\r\n" +
+		"*6\r\n$4\r\nname\r\n$5\r\nmyset\r\n$11\r\ndescription\r\n$-1\r\n$5\r\nflags\r\n*0\r\n"
+	if string(result.ToBytes()) != expected {
+		t.Errorf("unexpected reply: %s", result.ToBytes())
+	}
+	result = testServer.Exec(c, utils.ToCmdLine("function", "list", "libraryname", "other*"))
+	if string(result.ToBytes()) != "*0\r\n" {
+		t.Errorf("unexpected reply: %s", result.ToBytes())
+	}
+	result = testServer.Exec(c, utils.ToCmdLine("function", "list", "withcode"))
+	if !strings.Contains(string(result.ToBytes()), "library_code") {
+		t.Errorf("expect library code, actual: %s", result.ToBytes())
+	}
+
+	// errors
+	result = testServer.Exec(c, utils.ToCmdLine("function", "load", "return 1"))
+	asserts.AssertErrReply(t, result, "ERR Missing library metadata")
+	result = testServer.Exec(c, utils.ToCmdLine("function", "load", "#!js name=a\nreturn 1"))
+	asserts.AssertErrReply(t, result, "ERR Engine 'js' not found")
+	result = testServer.Exec(c, utils.ToCmdLine("function", "load", "#!lua\nreturn 1"))
+	asserts.AssertErrReply(t, result, "ERR Library name was not given")
+	result = testServer.Exec(c, utils.ToCmdLine("function", "load", "#!lua name=a foo=bar\nreturn 1"))
+	asserts.AssertErrReply(t, result, "ERR Invalid metadata value given: foo=bar")
+	result = testServer.Exec(c, utils.ToCmdLine("function", "load", "#!lua name=a\nreturn 1"))
+	asserts.AssertErrReply(t, result, "ERR No functions registered")
+	result = testServer.Exec(c, utils.ToCmdLine("function", "load",
+		"#!lua name=other\nredis.register_function('myset', function() end)"))
+	asserts.AssertErrReply(t, result, "ERR Function myset already exists")
+	result = testServer.Exec(c, utils.ToCmdLine("function", "load",
+		"#!lua name=a\nredis.register_function('f', function() end)\nredis.register_function('f', function() end)"))
+	asserts.AssertErrReply(t, result, "ERR Function already exists in the library")
+	result = testServer.Exec(c, utils.ToCmdLine("function", "load",
+		"#!lua name=a\nredis.register_function{function_name='f', callback=function() end, flags={'bad'}}"))
+	asserts.AssertErrReply(t, result, "ERR Unknown flag given: bad")
+	result = testServer.Exec(c, utils.ToCmdLine("function", "load",
+		"#!lua name=a\nredis.call('set', 'a', '1')\nredis.register_function('f', function() end)"))
+	if !protocol.IsErrorReply(result) {
+		t.Errorf("redis.call should not be available while loading, actual: %s", result.ToBytes())
+	}
+	result = testServer.Exec(c, utils.ToCmdLine("function", "load", "#!lua name=a\nwhile true do end"))
+	asserts.AssertErrReply(t, result, "ERR FUNCTION LOAD timeout")
+
+	// delete and flush
+	result = testServer.Exec(c, utils.ToCmdLine("function", "delete", "none"))
+	asserts.AssertErrReply(t, result, "ERR Library not found")
+	result = testServer.Exec(c, utils.ToCmdLine("function", "delete", "mylib"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testServer.Exec(c, utils.ToCmdLine("fcall", "myset", "1", "a", "1"))
+	asserts.AssertErrReply(t, result, "ERR Function not found")
+	testServer.Exec(c, utils.ToCmdLine("function", "load", testLibrary))
+	result = testServer.Exec(c, utils.ToCmdLine("function", "flush"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testServer.Exec(c, utils.ToCmdLine("function", "list"))
+	if string(result.ToBytes()) != "*0\r\n" {
+		t.Errorf("unexpected reply: %s", result.ToBytes())
+	}
+}
+
+func TestFCall(t *testing.T) {
+	c := connection.NewFakeConn()
+	testServer.Exec(c, utils.ToCmdLine("flushall"))
+	testServer.Exec(c, utils.ToCmdLine("function", "flush"))
+	testServer.Exec(c, utils.ToCmdLine("function", "load", testLibrary))
+	result := testServer.Exec(c, utils.ToCmdLine("fcall", "myset", "1", "a", "1"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testServer.Exec(c, utils.ToCmdLine("fcall", "myget", "1", "a"))
+	asserts.AssertBulkReply(t, result, "1")
+	result = testServer.Exec(c, utils.ToCmdLine("fcall_ro", "myget", "1", "a"))
+	asserts.AssertBulkReply(t, result, "1")
+	result = testServer.Exec(c, utils.ToCmdLine("fcall_ro", "myset", "1", "a", "2"))
+	asserts.AssertErrReply(t, result, "ERR Can not execute a script with write flag using *_ro command.")
+	result = testServer.Exec(c, utils.ToCmdLine("fcall", "myset", "1", "b", "1"))
+	asserts.AssertStatusReply(t, result, "OK")
+
+	// functions with no-writes flag could not write even if called by FCALL
+	testServer.Exec(c, utils.ToCmdLine("function", "load",
+		"#!lua name=ro\nredis.register_function{function_name='mydel', callback=function(keys) return redis.call('del', keys[1]) end, flags={'no-writes'}}"))
+	result = testServer.Exec(c, utils.ToCmdLine("fcall", "mydel", "1", "a"))
+	asserts.AssertErrReply(t, result, "ERR Write commands are not allowed from read-only scripts.")
+	testServer.Exec(c, utils.ToCmdLine("function", "flush"))
+}
+
+func TestFCallOnReplica(t *testing.T) {
+	config.Properties = &config.ServerProperties{
+		Databases:       16,
+		ReplicaReadOnly: true,
+	}
+	server := NewStandaloneServer()
+	defer server.Close()
+	c := connection.NewFakeConn()
+	server.Exec(c, utils.ToCmdLine("set", "a", "1"))
+	server.Exec(c, utils.ToCmdLine("function", "load", testLibrary))
+	atomic.StoreInt32(&server.role, slaveRole)
+
+	// functions declared with no-writes are allowed on read only replicas
+	result := server.Exec(c, utils.ToCmdLine("fcall", "myget", "1", "a"))
+	asserts.AssertBulkReply(t, result, "1")
+	result = server.Exec(c, utils.ToCmdLine("fcall_ro", "myget", "1", "a"))
+	asserts.AssertBulkReply(t, result, "1")
+	result = server.Exec(c, utils.ToCmdLine("fcall", "myset", "1", "a", "2"))
+	asserts.AssertErrReply(t, result, "READONLY You can't write against a read only slave.")
+	result = server.Exec(c, utils.ToCmdLine("fcall", "unknown", "0"))
+	asserts.AssertErrReply(t, result, "READONLY You can't write against a read only slave.")
+}
+
+func TestFunctionKill(t *testing.T) {
+	c := connection.NewFakeConn()
+	testServer.Exec(c, utils.ToCmdLine("function", "flush"))
+	testServer.Exec(c, utils.ToCmdLine("function", "load",
+		"#!lua name=slow\nredis.register_function('spin', function(keys) redis.call('get', keys[1]) while true do end end)"))
+	defer testServer.Exec(c, utils.ToCmdLine("function", "flush"))
+	result := testServer.Exec(c, utils.ToCmdLine("config", "set", "lua-time-limit", "50"))
+	asserts.AssertStatusReply(t, result, "OK")
+	defer testServer.Exec(c, utils.ToCmdLine("config", "set", "lua-time-limit", "5000"))
+
+	ch := make(chan redis.Reply, 1)
+	go func() {
+		ch <- testServer.Exec(connection.NewFakeConn(), utils.ToCmdLine("fcall", "spin", "1", "slow"))
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for testServer.scripts.busy() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("server is not busy")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	result = testServer.Exec(c, utils.ToCmdLine("get", "a"))
+	asserts.AssertErrReply(t, result, "BUSY Redis is busy running a script. You can only call FUNCTION KILL or SHUTDOWN NOSAVE.")
+	result = testServer.Exec(c, utils.ToCmdLine("function", "stats"))
+	if !strings.Contains(string(result.ToBytes()), "spin") {
+		t.Errorf("expect running function in stats, actual: %s", result.ToBytes())
+	}
+	result = testServer.Exec(c, utils.ToCmdLine("script", "kill"))
+	asserts.AssertErrReply(t, result, "NOTBUSY No scripts in execution right now.")
+	result = testServer.Exec(c, utils.ToCmdLine("function", "kill"))
+	asserts.AssertStatusReply(t, result, "OK")
+	select {
+	case reply := <-ch:
+		asserts.AssertErrReply(t, reply, "ERR Script killed by user with FUNCTION KILL...")
+	case <-time.After(5 * time.Second):
+		t.Fatal("function is not killed")
+	}
+}
+
+func TestFunctionPersistence(t *testing.T) {
+	properties := config.Properties
+	defer func() {
+		config.Properties = properties
+	}()
+	tests := []struct {
+		name       string
+		properties *config.ServerProperties
+		persist    string
+	}{
+		{
+			name:    "aof",
+			persist: "",
+			properties: &config.ServerProperties{
+				AppendOnly:  true,
+				AppendFsync: aof.FsyncAlways,
+			},
+		},
+		{
+			name:    "rewrite aof",
+			persist: "rewriteaof",
+			properties: &config.ServerProperties{
+				AppendOnly:  true,
+				AppendFsync: aof.FsyncAlways,
+			},
+		},
+		{
+			name:    "rewrite aof with rdb preamble",
+			persist: "rewriteaof",
+			properties: &config.ServerProperties{
+				AppendOnly:        true,
+				AppendFsync:       aof.FsyncAlways,
+				AofUseRdbPreamble: true,
+			},
+		},
+		{
+			name:       "rdb",
+			persist:    "save",
+			properties: &config.ServerProperties{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			config.Properties = tt.properties
+			config.Properties.AppendFilename = path.Join(dir, "a.aof")
+			config.Properties.RDBFilename = path.Join(dir, "dump.rdb")
+			c := connection.NewFakeConn()
+			server := NewStandaloneServer()
+			server.Exec(c, utils.ToCmdLine("function", "load", "#!lua name=deleted\nredis.register_function('f', function() end)"))
+			server.Exec(c, utils.ToCmdLine("function", "load", testLibrary))
+			server.Exec(c, utils.ToCmdLine("function", "delete", "deleted"))
+			server.Exec(c, utils.ToCmdLine("fcall", "myset", "1", "a", "1"))
+			if tt.persist != "" {
+				result := server.Exec(c, utils.ToCmdLine(tt.persist))
+				asserts.AssertNotError(t, result)
+			}
+			server.Close()
+
+			server = NewStandaloneServer()
+			defer server.Close()
+			result := server.Exec(c, utils.ToCmdLine("fcall", "myget", "1", "a"))
+			asserts.AssertBulkReply(t, result, "1")
+			result = server.Exec(c, utils.ToCmdLine("fcall", "f", "0"))
+			asserts.AssertErrReply(t, result, "ERR Function not found")
+		})
+	}
+}
@@ -4,8 +4,10 @@ import (
 	Dict "github.com/hdt3213/godis/datastruct/dict"
 	"github.com/hdt3213/godis/interface/database"
 	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/clock"
 	"github.com/hdt3213/godis/lib/utils"
 	"github.com/hdt3213/godis/redis/protocol"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -19,6 +21,30 @@ func (db *DB) getAsDict(key string) (Dict.Dict, protocol.ErrorReply) {
 	if !ok {
 		return nil, &protocol.WrongTypeErrReply{}
 	}
+	if hash, ok := dict.(*Dict.TTLDict); ok {
+		// remove expired fields lazily
+		return db.removeExpiredFields(key, hash), nil
+	}
+	return dict, nil
+}
+
+// peekAsDict is getAsDict for read only commands which hold a shared lock, expired fields are hidden instead of removed.
+// It returns nil if all fields are expired
+func (db *DB) peekAsDict(key string) (Dict.Dict, protocol.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	dict, ok := entity.Data.(Dict.Dict)
+	if !ok {
+		return nil, &protocol.WrongTypeErrReply{}
+	}
+	if hash, ok := dict.(*Dict.TTLDict); ok {
+		dict = hash.Live(clock.Now())
+		if dict.Len() == 0 {
+			return nil, nil
+		}
+	}
 	return dict, nil
 }
 
@@ -52,6 +78,7 @@ func execHSet(db *DB, args [][]byte) redis.Reply {
 	}
 
 	result := dict.Put(field, value)
+	db.persistFields(key, dict, field)
 	db.addAof(utils.ToCmdLine3("hset", args...))
 	return protocol.MakeIntReply(int64(result))
 }
@@ -89,7 +116,7 @@ func execHGet(db *DB, args [][]byte) redis.Reply {
 	field := string(args[1])
 
 	// get entity
-	dict, errReply := db.getAsDict(key)
+	dict, errReply := db.peekAsDict(key)
 	if errReply != nil {
 		return errReply
 	}
@@ -112,7 +139,7 @@ func execHExists(db *DB, args [][]byte) redis.Reply {
 	field := string(args[1])
 
 	// get entity
-	dict, errReply := db.getAsDict(key)
+	dict, errReply := db.peekAsDict(key)
 	if errReply != nil {
 		return errReply
 	}
@@ -176,7 +203,7 @@ func execHLen(db *DB, args [][]byte) redis.Reply {
 	// parse args
 	key := string(args[0])
 
-	dict, errReply := db.getAsDict(key)
+	dict, errReply := db.peekAsDict(key)
 	if errReply != nil {
 		return errReply
 	}
@@ -192,7 +219,7 @@ func execHStrlen(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	field := string(args[1])
 
-	dict, errReply := db.getAsDict(key)
+	dict, errReply := db.peekAsDict(key)
 	if errReply != nil {
 		return errReply
 	}
@@ -234,6 +261,7 @@ func execHMSet(db *DB, args [][]byte) redis.Reply {
 		value := values[i]
 		dict.Put(field, value)
 	}
+	db.persistFields(key, dict, fields...)
 	db.addAof(utils.ToCmdLine3("hmset", args...))
 	return &protocol.OkReply{}
 }
@@ -259,7 +287,7 @@ func execHMGet(db *DB, args [][]byte) redis.Reply {
 
 	// get entity
 	result := make([][]byte, size)
-	dict, errReply := db.getAsDict(key)
+	dict, errReply := db.peekAsDict(key)
 	if errReply != nil {
 		return errReply
 	}
@@ -283,7 +311,7 @@ func execHMGet(db *DB, args [][]byte) redis.Reply {
 func execHKeys(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 
-	dict, errReply := db.getAsDict(key)
+	dict, errReply := db.peekAsDict(key)
 	if errReply != nil {
 		return errReply
 	}
@@ -306,7 +334,7 @@ func execHVals(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 
 	// get entity
-	dict, errReply := db.getAsDict(key)
+	dict, errReply := db.peekAsDict(key)
 	if errReply != nil {
 		return errReply
 	}
@@ -329,7 +357,7 @@ func execHGetAll(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 
 	// get entity
-	dict, errReply := db.getAsDict(key)
+	dict, errReply := db.peekAsDict(key)
 	if errReply != nil {
 		return errReply
 	}
@@ -421,130 +449,86 @@ func execHIncrByFloat(db *DB, args [][]byte) redis.Reply {
 	return protocol.MakeBulkReply(resultBytes)
 }
 
-// execHRandField return a random field(or field-value) from the hash value stored at key.
+// execHRandField usage: HRANDFIELD key [count [WITHVALUES]]
+// positive count returns distinct fields, negative count allows the same field to be returned multiple times
 func execHRandField(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
-	count := 1
-	withvalues := 0
-
 	if len(args) > 3 {
 		return protocol.MakeErrReply("ERR wrong number of arguments for 'hrandfield' command")
 	}
-
+	withValues := false
 	if len(args) == 3 {
-		if strings.ToLower(string(args[2])) == "withvalues" {
-			withvalues = 1
-		} else {
+		if strings.ToLower(string(args[2])) != "withvalues" {
 			return protocol.MakeSyntaxErrReply()
 		}
+		withValues = true
 	}
-
+	count := int64(1)
 	if len(args) >= 2 {
-		count64, err := strconv.ParseInt(string(args[1]), 10, 64)
+		var err error
+		count, err = strconv.ParseInt(string(args[1]), 10, 64)
 		if err != nil {
 			return protocol.MakeErrReply("ERR value is not an integer or out of range")
 		}
-		count = int(count64)
+		if count < -math.MaxInt64/2 {
+			return protocol.MakeErrReply("ERR value is out of range")
+		}
 	}
 
-	dict, errReply := db.getAsDict(key)
+	dict, errReply := db.peekAsDict(key)
 	if errReply != nil {
 		return errReply
 	}
-	if dict == nil {
+	if len(args) == 1 {
+		// without count returns a single field
+		if dict == nil {
+			return &protocol.NullBulkReply{}
+		}
+		return protocol.MakeBulkReply([]byte(dict.RandomKeys(1)[0]))
+	}
+	if dict == nil || count == 0 {
 		return &protocol.EmptyMultiBulkReply{}
 	}
 
+	var fields []string
 	if count > 0 {
-		fields := dict.RandomDistinctKeys(count)
-		Numfield := len(fields)
-		if withvalues == 0 {
-			result := make([][]byte, Numfield)
-			for i, v := range fields {
-				result[i] = []byte(v)
-			}
-			return protocol.MakeMultiBulkReply(result)
-		} else {
-			result := make([][]byte, 2*Numfield)
-			for i, v := range fields {
-				result[2*i] = []byte(v)
-				raw, _ := dict.Get(v)
-				result[2*i+1] = raw.([]byte)
-			}
-			return protocol.MakeMultiBulkReply(result)
-		}
-	} else if count < 0 {
-		fields := dict.RandomKeys(-count)
-		Numfield := len(fields)
-		if withvalues == 0 {
-			result := make([][]byte, Numfield)
-			for i, v := range fields {
-				result[i] = []byte(v)
-			}
-			return protocol.MakeMultiBulkReply(result)
-		} else {
-			result := make([][]byte, 2*Numfield)
-			for i, v := range fields {
-				result[2*i] = []byte(v)
-				raw, _ := dict.Get(v)
-				result[2*i+1] = raw.([]byte)
-			}
-			return protocol.MakeMultiBulkReply(result)
+		fields = dict.RandomDistinctKeys(int(count))
+	} else {
+		fields = dict.RandomKeys(int(-count))
+	}
+	if !withValues {
+		result := make([][]byte, len(fields))
+		for i, field := range fields {
+			result[i] = []byte(field)
 		}
+		return protocol.MakeMultiBulkReply(result)
 	}
-
-	// 'count' is 0 will reach.
-	return &protocol.EmptyMultiBulkReply{}
+	result := make([][]byte, 0, 2*len(fields))
+	for _, field := range fields {
+		raw, _ := dict.Get(field)
+		value, _ := raw.([]byte)
+		result = append(result, []byte(field), value)
+	}
+	return protocol.MakeMultiBulkReply(result)
 }
 
+// execHScan iterates fields and values of a hash with cursor
 func execHScan(db *DB, args [][]byte) redis.Reply {
-	var count int = 10
-	var pattern string = "*"
-	if len(args) > 2 {
-		for i := 2; i < len(args); i++ {
-			arg := strings.ToLower(string(args[i]))
-			if arg == "count" {
-				count0, err := strconv.Atoi(string(args[i+1]))
-				if err != nil {
-					return &protocol.SyntaxErrReply{}
-				}
-				count = count0
-				i++
-			} else if arg == "match" {
-				pattern = string(args[i+1])
-				i++
-			} else {
-				return &protocol.SyntaxErrReply{}
-			}
-		}
-	}
-	if len(args) < 2 {
-		return &protocol.SyntaxErrReply{}
+	opts, errReply := parseScanOptions(args[1:], false, true)
+	if errReply != nil {
+		return errReply
 	}
-	key := string(args[0])
-	// get entity
-	dict, errReply := db.getAsDict(key)
+	dict, errReply := db.peekAsDict(string(args[0]))
 	if errReply != nil {
 		return errReply
 	}
 	if dict == nil {
-		return &protocol.NullBulkReply{}
-	}
-	cursor, err := strconv.Atoi(string(args[1]))
-	if err != nil {
-		return protocol.MakeErrReply("ERR invalid cursor")
+		return makeScanReply(nil, 0)
 	}
-
-	keysReply, nextCursor := dict.DictScan(cursor, count, pattern)
-	if nextCursor < 0 {
-		return protocol.MakeErrReply("Invalid argument")
+	if opts.noValues {
+		return makeScanReply(dict.KeyScan(opts.cursor, opts.count, opts.pattern))
 	}
-
-	result := make([]redis.Reply, 2)
-	result[0] = protocol.MakeBulkReply([]byte(strconv.FormatInt(int64(nextCursor), 10)))
-	result[1] = protocol.MakeMultiBulkReply(keysReply)
-
-	return protocol.MakeMultiRawReply(result)
+	return makeScanReply(dict.DictScan(opts.cursor, opts.count, opts.pattern))
 }
 
 func init() {
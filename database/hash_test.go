@@ -230,6 +230,34 @@ func TestHGetAll(t *testing.T) {
 	if 2*(len(fields)+10) != len(multiBulk.Args) {
 		t.Errorf("expected %d items , actually %d ", 2*(len(fields)+10), len(multiBulk.Args))
 	}
+
+	// test HRandField without count returns a single field
+	result = testDB.Exec(nil, utils.ToCmdLine("hrandfield", key))
+	bulk, ok := result.(*protocol.BulkReply)
+	if !ok {
+		t.Errorf("expected BulkReply, actually %s", string(result.ToBytes()))
+	} else if _, exists := valueMap[string(bulk.Arg)]; !exists {
+		t.Errorf("unexpected field %s", string(bulk.Arg))
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("hrandfield", "nosuchkey"))
+	asserts.AssertNullBulk(t, result)
+
+	// test HRandField returns distinct fields with matched values
+	result = testDB.Exec(nil, utils.ToCmdLine("hrandfield", key, strconv.Itoa(size/2), "withvalues"))
+	multiBulk, _ = result.(*protocol.MultiBulkReply)
+	distinct := make(map[string]struct{})
+	for i := 0; i+1 < len(multiBulk.Args); i += 2 {
+		field := string(multiBulk.Args[i])
+		if valueMap[field] != string(multiBulk.Args[i+1]) {
+			t.Errorf("wrong value of field %s", field)
+		}
+		distinct[field] = struct{}{}
+	}
+	if len(distinct) != size/2 {
+		t.Errorf("expected %d distinct fields, actually %d", size/2, len(distinct))
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("hrandfield", key, "1", "values"))
+	asserts.AssertErrReply(t, result, "Err syntax error")
 }
 
 func TestHIncrBy(t *testing.T) {
@@ -391,3 +419,48 @@ func TestHScan(t *testing.T) {
 		}
 	}
 }
+
+func TestHScanLargeHash(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	size := 1000
+	for i := 0; i < size; i++ {
+		field := strconv.Itoa(i)
+		testDB.Exec(nil, utils.ToCmdLine("hset", key, field, "v"+field))
+	}
+	result := testDB.Exec(nil, utils.ToCmdLine("hscan", key, "0"))
+	if string(result.(*protocol.MultiRawReply).Replies[0].(*protocol.BulkReply).Arg) == "0" {
+		t.Error("large hash should not be scanned in one call")
+	}
+
+	items := scanAll(t, func(cursor string) [][]byte {
+		return utils.ToCmdLine("hscan", key, cursor, "count", "50")
+	})
+	fields := make(map[string]string)
+	for i := 0; i+1 < len(items); i += 2 {
+		fields[string(items[i])] = string(items[i+1])
+	}
+	if len(fields) != size {
+		t.Errorf("expect %d fields, actually %d", size, len(fields))
+	}
+	for field, value := range fields {
+		if value != "v"+field {
+			t.Errorf("expect value v%s, actually %s", field, value)
+		}
+	}
+
+	items = scanAll(t, func(cursor string) [][]byte {
+		return utils.ToCmdLine("hscan", key, cursor, "match", "1*", "novalues")
+	})
+	// 1, 10-19, 100-199
+	if len(utils.RemoveDuplicates(items)) != 111 {
+		t.Errorf("expect 111 fields, actually %d", len(items))
+	}
+
+	result = testDB.Exec(nil, utils.ToCmdLine("hscan", utils.RandString(10), "0"))
+	if string(result.ToBytes()) != "*2\r\n$1\r\n0\r\n*0\r\n" {
+		t.Errorf("expect empty scan result, actually %s", result.ToBytes())
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("hscan", key, "0", "type", "string"))
+	asserts.AssertErrReply(t, result, "Err syntax error")
+}
@@ -0,0 +1,411 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hdt3213/godis/aof"
+	Dict "github.com/hdt3213/godis/datastruct/dict"
+	"github.com/hdt3213/godis/interface/database"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/clock"
+	"github.com/hdt3213/godis/lib/timewheel"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+// Hash fields with ttl are stored in a Dict.TTLDict which is still a Dict.Dict, so that other hash commands
+// need not to know about field ttl. Read commands hide expired fields through peekAsDict, expired fields are removed
+// lazily by getAsDict of write commands, and actively by a timewheel task scheduled at the earliest expiration time
+// of each hash. Like expired keys, a replica never removes expired fields by itself but waits for HDEL from master.
+// Field ttl is persisted by AOF, and by aux fields of RDB since the RDB format in use has no type for it.
+
+func genFieldExpireTask(dbID int64, key string) string {
+	return "hexpire:" + strconv.FormatInt(dbID, 10) + ":" + key
+}
+
+// removeExpiredFields removes expired fields of hash, the key will be removed if all fields are expired.
+// It returns nil if the key has been removed
+func (db *DB) removeExpiredFields(key string, hash *Dict.TTLDict) Dict.Dict {
+	if db.replicaMode() {
+		return hash
+	}
+	removed := hash.RemoveExpired(clock.Now())
+	if len(removed) == 0 {
+		return hash
+	}
+	db.addAof(utils.ToCmdLine2("hdel", append([]string{key}, removed...)...))
+	if hash.Len() == 0 {
+		db.Remove(key)
+		return nil
+	}
+	return hash
+}
+
+// persistFields clears ttl of the given fields since they are overwritten
+func (db *DB) persistFields(key string, dict Dict.Dict, fields ...string) {
+	hash, ok := dict.(*Dict.TTLDict)
+	if !ok {
+		return
+	}
+	for _, field := range fields {
+		hash.Persist(field)
+	}
+	db.compactTTLDict(key, hash)
+}
+
+// scheduleFieldExpire schedules a task to remove expired fields at the earliest expiration time of the hash
+func (db *DB) scheduleFieldExpire(key string, hash *Dict.TTLDict) {
+	taskKey := genFieldExpireTask(db.id, key)
+	next, ok := hash.NextExpireTime()
+	if !ok {
+		timewheel.Cancel(taskKey)
+		return
+	}
+	delay := clock.Until(next)
+	if delay < 0 {
+		// timewheel drops tasks in the past
+		delay = 0
+	}
+	timewheel.Delay(delay, taskKey, func() {
+		if db.replicaMode() || !db.activeExpireEnabled() {
+			return
+		}
+		keys := []string{key}
+		db.RWLocks(keys, nil)
+		defer db.RWUnLocks(keys, nil)
+		entity, ok := db.peekEntity(key)
+		if !ok {
+			return
+		}
+		// the hash may be replaced during waiting lock
+		hash, ok := entity.Data.(*Dict.TTLDict)
+		if !ok {
+			return
+		}
+		if db.removeExpiredFields(key, hash) != nil {
+			db.scheduleFieldExpire(key, hash)
+		}
+	})
+}
+
+// takeOverFieldExpireTasks is like takeOverExpireTasks, but works for tasks removing expired hash fields
+func (db *DB) takeOverFieldExpireTasks(oldDB *DB) {
+	db.data.ForEach(func(key string, val interface{}) bool {
+		if hash, ok := val.(*database.DataEntity).Data.(*Dict.TTLDict); ok {
+			db.scheduleFieldExpire(key, hash)
+		}
+		return true
+	})
+	oldDB.data.ForEach(func(key string, val interface{}) bool {
+		if !isTTLDict(val.(*database.DataEntity)) {
+			return true
+		}
+		if entity, ok := db.data.Get(key); !ok || !isTTLDict(entity.(*database.DataEntity)) {
+			timewheel.Cancel(genFieldExpireTask(db.id, key))
+		}
+		return true
+	})
+}
+
+// rescheduleFieldExpireTasks is like rescheduleExpireTasks, but works for tasks removing expired hash fields
+func (db *DB) rescheduleFieldExpireTasks() {
+	var keys []string
+	db.data.ForEach(func(key string, val interface{}) bool {
+		if isTTLDict(val.(*database.DataEntity)) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	for _, key := range keys {
+		db.rescheduleFieldExpireTask(key)
+	}
+}
+
+func (db *DB) rescheduleFieldExpireTask(key string) {
+	keys := []string{key}
+	db.RWLocks(keys, nil)
+	defer db.RWUnLocks(keys, nil)
+	entity, ok := db.peekEntity(key)
+	if !ok {
+		return
+	}
+	if hash, ok := entity.Data.(*Dict.TTLDict); ok {
+		db.scheduleFieldExpire(key, hash)
+	}
+}
+
+func isTTLDict(entity *database.DataEntity) bool {
+	_, ok := entity.Data.(*Dict.TTLDict)
+	return ok
+}
+
+// getAsTTLDict returns hash of the given key which supports field ttl, a plain hash will be converted
+func (db *DB) getAsTTLDict(key string) (*Dict.TTLDict, protocol.ErrorReply) {
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil || dict == nil {
+		return nil, errReply
+	}
+	if hash, ok := dict.(*Dict.TTLDict); ok {
+		return hash, nil
+	}
+	hash := Dict.MakeTTL(dict)
+	entity, _ := db.peekEntity(key)
+	entity.Data = hash
+	return hash, nil
+}
+
+// compactTTLDict converts the hash back to a plain one if no field has ttl
+func (db *DB) compactTTLDict(key string, hash *Dict.TTLDict) {
+	if hash.ExpireCount() > 0 {
+		return
+	}
+	if entity, ok := db.peekEntity(key); ok && entity.Data == hash {
+		entity.Data = hash.Dict
+	}
+	timewheel.Cancel(genFieldExpireTask(db.id, key))
+}
+
+// parseHashFields parses FIELDS numfields field [field ...] at the end of args
+func parseHashFields(args [][]byte) ([]string, protocol.ErrorReply) {
+	if len(args) < 2 || strings.ToLower(string(args[0])) != "fields" {
+		return nil, protocol.MakeErrReply("ERR Mandatory argument FIELDS is missing or not at the right position")
+	}
+	numFields, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil || numFields <= 0 {
+		return nil, protocol.MakeErrReply("ERR Parameter `numFields` should be greater than 0")
+	}
+	if numFields != int64(len(args)-2) {
+		return nil, protocol.MakeErrReply("ERR The `numfields` parameter must match the number of arguments")
+	}
+	fields := make([]string, numFields)
+	for i := range fields {
+		fields[i] = string(args[i+2])
+	}
+	return fields, nil
+}
+
+func makeFieldsReply(codes []int64) redis.Reply {
+	replies := make([]redis.Reply, len(codes))
+	for i, code := range codes {
+		replies[i] = protocol.MakeIntReply(code)
+	}
+	return protocol.MakeMultiRawReply(replies)
+}
+
+// hexpireGeneric implements HEXPIRE, HPEXPIRE, HEXPIREAT and HPEXPIREAT key when [NX | XX | GT | LT]
+// FIELDS numfields field [field ...]. Replies for each field: -2 if the field does not exist,
+// 0 if the condition is not met, 1 if the ttl is set, 2 if the field is deleted since the time is in the past
+func hexpireGeneric(db *DB, args [][]byte, cmdName string, unit time.Duration, relative bool) redis.Reply {
+	key := string(args[0])
+	expireAt, errReply := parseExpireAt(args[1], cmdName, unit, relative)
+	if errReply != nil {
+		return errReply
+	}
+	rest := args[2:]
+	flags := 0
+	if len(rest) > 0 {
+		switch strings.ToLower(string(rest[0])) {
+		case "nx", "xx", "gt", "lt":
+			flags, _ = parseExpireFlags(rest[:1])
+			rest = rest[1:]
+		}
+	}
+	fields, errReply := parseHashFields(rest)
+	if errReply != nil {
+		return errReply
+	}
+
+	hash, errReply := db.getAsTTLDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	codes := make([]int64, len(fields))
+	if hash == nil {
+		for i := range codes {
+			codes[i] = -2
+		}
+		return makeFieldsReply(codes)
+	}
+	expired := !expireAt.After(clock.Now())
+	var updated, deleted []string
+	for i, field := range fields {
+		if _, ok := hash.Get(field); !ok {
+			codes[i] = -2
+			continue
+		}
+		current, hasTTL := hash.ExpireTime(field)
+		if !checkExpireFlags(flags, expireAt, current, hasTTL) {
+			codes[i] = 0
+			continue
+		}
+		if expired {
+			hash.Remove(field)
+			deleted = append(deleted, field)
+			codes[i] = 2
+			continue
+		}
+		hash.Expire(field, expireAt)
+		updated = append(updated, field)
+		codes[i] = 1
+	}
+	if len(updated) > 0 {
+		db.addAof(aof.MakeFieldExpireCmd(key, expireAt, updated...).Args)
+		db.scheduleFieldExpire(key, hash)
+	}
+	if len(deleted) > 0 {
+		db.addAof(utils.ToCmdLine2("hdel", append([]string{key}, deleted...)...))
+		if hash.Len() == 0 {
+			db.Remove(key)
+			return makeFieldsReply(codes)
+		}
+	}
+	db.compactTTLDict(key, hash)
+	return makeFieldsReply(codes)
+}
+
+// execHExpire usage: HEXPIRE key seconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func execHExpire(db *DB, args [][]byte) redis.Reply {
+	return hexpireGeneric(db, args, "hexpire", time.Second, true)
+}
+
+// execHPExpire usage: HPEXPIRE key milliseconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func execHPExpire(db *DB, args [][]byte) redis.Reply {
+	return hexpireGeneric(db, args, "hpexpire", time.Millisecond, true)
+}
+
+// execHExpireAt usage: HEXPIREAT key unix-time-seconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func execHExpireAt(db *DB, args [][]byte) redis.Reply {
+	return hexpireGeneric(db, args, "hexpireat", time.Second, false)
+}
+
+// execHPExpireAt usage: HPEXPIREAT key unix-time-milliseconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func execHPExpireAt(db *DB, args [][]byte) redis.Reply {
+	return hexpireGeneric(db, args, "hpexpireat", time.Millisecond, false)
+}
+
+// fieldTTLGeneric replies ttl information of each field: -2 if the field does not exist, -1 if it has no ttl,
+// otherwise the value returned by ttlOf
+func fieldTTLGeneric(db *DB, args [][]byte, ttlOf func(expireAt time.Time) int64) redis.Reply {
+	key := string(args[0])
+	fields, errReply := parseHashFields(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	dict, errReply := db.peekAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	// dict may be a view of Dict.TTLDict hiding expired fields
+	hash, _ := dict.(interface {
+		ExpireTime(field string) (time.Time, bool)
+	})
+	codes := make([]int64, len(fields))
+	for i, field := range fields {
+		if dict == nil {
+			codes[i] = -2
+			continue
+		}
+		if _, ok := dict.Get(field); !ok {
+			codes[i] = -2
+			continue
+		}
+		codes[i] = -1
+		if hash != nil {
+			if expireAt, ok := hash.ExpireTime(field); ok {
+				codes[i] = ttlOf(expireAt)
+			}
+		}
+	}
+	return makeFieldsReply(codes)
+}
+
+// execHTTL usage: HTTL key FIELDS numfields field [field ...], returns remaining ttl of fields in seconds
+func execHTTL(db *DB, args [][]byte) redis.Reply {
+	return fieldTTLGeneric(db, args, func(expireAt time.Time) int64 {
+		return int64(clock.Until(expireAt).Round(time.Second) / time.Second)
+	})
+}
+
+// execHPTTL usage: HPTTL key FIELDS numfields field [field ...], returns remaining ttl of fields in milliseconds
+func execHPTTL(db *DB, args [][]byte) redis.Reply {
+	return fieldTTLGeneric(db, args, func(expireAt time.Time) int64 {
+		return clock.Until(expireAt).Milliseconds()
+	})
+}
+
+// execHExpireTime usage: HEXPIRETIME key FIELDS numfields field [field ...], returns unix timestamp in seconds
+func execHExpireTime(db *DB, args [][]byte) redis.Reply {
+	return fieldTTLGeneric(db, args, func(expireAt time.Time) int64 {
+		return expireAt.Unix()
+	})
+}
+
+// execHPExpireTime usage: HPEXPIRETIME key FIELDS numfields field [field ...], returns unix timestamp in milliseconds
+func execHPExpireTime(db *DB, args [][]byte) redis.Reply {
+	return fieldTTLGeneric(db, args, func(expireAt time.Time) int64 {
+		return expireAt.UnixMilli()
+	})
+}
+
+// execHPersist usage: HPERSIST key FIELDS numfields field [field ...]
+// Replies for each field: -2 if the field does not exist, -1 if it has no ttl, 1 if the ttl is removed
+func execHPersist(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	fields, errReply := parseHashFields(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	codes := make([]int64, len(fields))
+	hash, _ := dict.(*Dict.TTLDict)
+	var persisted []string
+	for i, field := range fields {
+		if dict == nil {
+			codes[i] = -2
+			continue
+		}
+		if _, ok := dict.Get(field); !ok {
+			codes[i] = -2
+			continue
+		}
+		if hash == nil || !hash.Persist(field) {
+			codes[i] = -1
+			continue
+		}
+		persisted = append(persisted, field)
+		codes[i] = 1
+	}
+	if len(persisted) > 0 {
+		cmdLine := utils.ToCmdLine("hpersist", key, "FIELDS", strconv.Itoa(len(persisted)))
+		db.addAof(append(cmdLine, utils.ToCmdLine(persisted...)...))
+		db.scheduleFieldExpire(key, hash)
+		db.compactTTLDict(key, hash)
+	}
+	return makeFieldsReply(codes)
+}
+
+func init() {
+	registerCommand("HExpire", execHExpire, writeFirstKey, rollbackFirstKey, -6, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
+	registerCommand("HPExpire", execHPExpire, writeFirstKey, rollbackFirstKey, -6, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
+	registerCommand("HExpireAt", execHExpireAt, writeFirstKey, rollbackFirstKey, -6, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
+	registerCommand("HPExpireAt", execHPExpireAt, writeFirstKey, rollbackFirstKey, -6, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
+	registerCommand("HTTL", execHTTL, readFirstKey, nil, -5, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom, redisFlagFast}, 1, 1, 1)
+	registerCommand("HPTTL", execHPTTL, readFirstKey, nil, -5, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom, redisFlagFast}, 1, 1, 1)
+	registerCommand("HExpireTime", execHExpireTime, readFirstKey, nil, -5, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom, redisFlagFast}, 1, 1, 1)
+	registerCommand("HPExpireTime", execHPExpireTime, readFirstKey, nil, -5, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom, redisFlagFast}, 1, 1, 1)
+	registerCommand("HPersist", execHPersist, writeFirstKey, rollbackFirstKey, -5, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
+}
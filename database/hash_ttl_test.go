@@ -0,0 +1,189 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	Dict "github.com/hdt3213/godis/datastruct/dict"
+	"github.com/hdt3213/godis/interface/database"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func assertIntsReply(t *testing.T, actual interface{ ToBytes() []byte }, expected string) {
+	t.Helper()
+	if string(actual.ToBytes()) != expected {
+		t.Errorf("expect %q, actually %q", expected, actual.ToBytes())
+	}
+}
+
+func TestHashFieldTTL(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("HMSET", key, "f1", "v1", "f2", "v2", "f3", "v3"))
+
+	result := testDB.Exec(nil, utils.ToCmdLine("HEXPIRE", key, "100", "FIELDS", "2", "f1", "nosuchfield"))
+	assertIntsReply(t, result, "*2\r\n:1\r\n:-2\r\n")
+	result = testDB.Exec(nil, utils.ToCmdLine("HTTL", key, "FIELDS", "3", "f1", "f2", "nosuchfield"))
+	assertIntsReply(t, result, "*3\r\n:100\r\n:-1\r\n:-2\r\n")
+	result = testDB.Exec(nil, utils.ToCmdLine("HEXPIRE", key, "50", "NX", "FIELDS", "1", "f1"))
+	assertIntsReply(t, result, "*1\r\n:0\r\n")
+	result = testDB.Exec(nil, utils.ToCmdLine("HEXPIRE", key, "200", "GT", "FIELDS", "1", "f1"))
+	assertIntsReply(t, result, "*1\r\n:1\r\n")
+	expireAt := time.Now().Add(time.Hour).UnixMilli()
+	result = testDB.Exec(nil, utils.ToCmdLine("HPEXPIREAT", key, strconv.FormatInt(expireAt, 10), "FIELDS", "1", "f3"))
+	assertIntsReply(t, result, "*1\r\n:1\r\n")
+	result = testDB.Exec(nil, utils.ToCmdLine("HPEXPIRETIME", key, "FIELDS", "1", "f3"))
+	assertIntsReply(t, result, "*1\r\n:"+strconv.FormatInt(expireAt, 10)+"\r\n")
+
+	// expired fields are hidden from readers
+	result = testDB.Exec(nil, utils.ToCmdLine("HPEXPIRE", key, "50", "FIELDS", "1", "f2"))
+	assertIntsReply(t, result, "*1\r\n:1\r\n")
+	time.Sleep(100 * time.Millisecond)
+	result = testDB.Exec(nil, utils.ToCmdLine("HGET", key, "f2"))
+	asserts.AssertNullBulk(t, result)
+	result = testDB.Exec(nil, utils.ToCmdLine("HLEN", key))
+	asserts.AssertIntReply(t, result, 2)
+
+	// undo logs restore field ttl
+	undoLogs := testDB.GetUndoLogs(utils.ToCmdLine("HDEL", key, "f1"))
+	found := false
+	for _, cmdLine := range undoLogs {
+		if strings.ToLower(string(cmdLine[0])) == "hpexpireat" && string(cmdLine[len(cmdLine)-1]) == "f1" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("field ttl is missing in undo logs")
+	}
+
+	result = testDB.Exec(nil, utils.ToCmdLine("HPERSIST", key, "FIELDS", "2", "f1", "nosuchfield"))
+	assertIntsReply(t, result, "*2\r\n:1\r\n:-2\r\n")
+	result = testDB.Exec(nil, utils.ToCmdLine("HPERSIST", key, "FIELDS", "1", "f1"))
+	assertIntsReply(t, result, "*1\r\n:-1\r\n")
+	// overwriting a field clears its ttl
+	testDB.Exec(nil, utils.ToCmdLine("HSET", key, "f3", "new"))
+	result = testDB.Exec(nil, utils.ToCmdLine("HTTL", key, "FIELDS", "1", "f3"))
+	assertIntsReply(t, result, "*1\r\n:-1\r\n")
+	entity, _ := testDB.GetEntity(key)
+	if _, ok := entity.Data.(*Dict.TTLDict); ok {
+		t.Error("expect hash without field ttl to be converted back")
+	}
+
+	// time in the past deletes fields
+	result = testDB.Exec(nil, utils.ToCmdLine("HEXPIREAT", key, "1", "FIELDS", "2", "f1", "f3"))
+	assertIntsReply(t, result, "*2\r\n:2\r\n:2\r\n")
+	result = testDB.Exec(nil, utils.ToCmdLine("EXISTS", key))
+	asserts.AssertIntReply(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("HTTL", key, "FIELDS", "1", "f1"))
+	assertIntsReply(t, result, "*1\r\n:-2\r\n")
+
+	result = testDB.Exec(nil, utils.ToCmdLine("HEXPIRE", key, "100", "FIELD", "1", "f1"))
+	asserts.AssertErrReply(t, result, "ERR Mandatory argument FIELDS is missing or not at the right position")
+	result = testDB.Exec(nil, utils.ToCmdLine("HEXPIRE", key, "100", "FIELDS", "2", "f1"))
+	asserts.AssertErrReply(t, result, "ERR The `numfields` parameter must match the number of arguments")
+	result = testDB.Exec(nil, utils.ToCmdLine("HEXPIRE", key, "100", "FIELDS", "0", "f1"))
+	asserts.AssertErrReply(t, result, "ERR Parameter `numFields` should be greater than 0")
+	result = testDB.Exec(nil, utils.ToCmdLine("HEXPIRE", key, "100", "NX", "XX", "FIELDS", "1", "f1"))
+	asserts.AssertErrReply(t, result, "ERR Mandatory argument FIELDS is missing or not at the right position")
+}
+
+func TestHashFieldActiveExpire(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("HMSET", key, "f1", "v1", "f2", "v2"))
+	testDB.Exec(nil, utils.ToCmdLine("HPEXPIRE", key, "10", "FIELDS", "1", "f1"))
+	testDB.Exec(nil, utils.ToCmdLine("HPEXPIRE", key, "20", "FIELDS", "1", "f2"))
+	// check entity directly, since readers only hide expired fields
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := testDB.data.Get(key); !ok {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Error("expired fields are not removed actively")
+}
+
+func TestHashFieldTTLConcurrentRead(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	args := []string{key}
+	var expiring []string
+	for i := 0; i < 100; i++ {
+		args = append(args, strconv.Itoa(i), strconv.Itoa(i))
+		if i%2 == 0 {
+			expiring = append(expiring, strconv.Itoa(i))
+		}
+	}
+	testDB.Exec(nil, utils.ToCmdLine2("HMSET", args...))
+	testDB.Exec(nil, utils.ToCmdLine2("HPEXPIRE", append([]string{key, "1", "FIELDS", "50"}, expiring...)...))
+	// readers share the lock, run with -race to check they never modify the hash
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				testDB.Exec(nil, utils.ToCmdLine("HLEN", key))
+				testDB.Exec(nil, utils.ToCmdLine("HGETALL", key))
+				testDB.Exec(nil, utils.ToCmdLine("HRANDFIELD", key, "10"))
+				testDB.Exec(nil, utils.ToCmdLine("HSCAN", key, "0"))
+			}
+		}()
+	}
+	wg.Wait()
+	time.Sleep(10 * time.Millisecond)
+	result := testDB.Exec(nil, utils.ToCmdLine("HLEN", key))
+	asserts.AssertIntReply(t, result, 50)
+}
+
+func TestHashFieldTTLOnReplica(t *testing.T) {
+	db := makeTestDB()
+	var isReplica int32 = 1
+	db.isReplica = func() bool {
+		return atomic.LoadInt32(&isReplica) == 1
+	}
+	key := utils.RandString(10)
+	db.Exec(nil, utils.ToCmdLine("HMSET", key, "f1", "v1", "f2", "v2"))
+	db.Exec(nil, utils.ToCmdLine("HPEXPIRE", key, "10", "FIELDS", "1", "f1"))
+	time.Sleep(200 * time.Millisecond)
+
+	result := db.Exec(nil, utils.ToCmdLine("HGET", key, "f1"))
+	asserts.AssertNullBulk(t, result)
+	result = db.Exec(nil, utils.ToCmdLine("HGETALL", key))
+	asserts.AssertMultiBulkReply(t, result, []string{"f2", "v2"})
+	result = db.Exec(nil, utils.ToCmdLine("HTTL", key, "FIELDS", "1", "f1"))
+	assertIntsReply(t, result, "*1\r\n:-2\r\n")
+	// replica waits for HDEL from master, neither timewheel nor write commands remove the field
+	db.Exec(nil, utils.ToCmdLine("HSET", key, "f2", "v3"))
+	countFields := func() int {
+		keys := []string{key}
+		db.RWLocks(nil, keys)
+		defer db.RWUnLocks(nil, keys)
+		raw, ok := db.data.GetWithLock(key)
+		if !ok {
+			return 0
+		}
+		return raw.(*database.DataEntity).Data.(Dict.Dict).Len()
+	}
+	if n := countFields(); n != 2 {
+		t.Errorf("expect expired field kept by replica, actually %d fields", n)
+	}
+
+	// promoted to master
+	atomic.StoreInt32(&isReplica, 0)
+	db.rescheduleFieldExpireTasks()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if countFields() == 1 {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Error("expired field is not removed after promoted")
+}
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"time"
+
+	"github.com/hdt3213/godis/interface/redis"
+)
+
+// Hooks are callbacks for applications embedding godis, such as cache invalidation bridges, CDC export or custom metrics.
+// Callbacks are called synchronously in the goroutine executing the command, key callbacks are called with keys locked,
+// so they should return quickly and must not execute commands on the server. Nil callbacks are skipped.
+type Hooks struct {
+	// BeforeCommand is called before an authenticated command is executed,
+	// the command is rejected with the returned reply unless it is nil
+	BeforeCommand func(c redis.Connection, cmdLine [][]byte) redis.Reply
+	// OnCommand is called after a command returned, including commands rejected by BeforeCommand.
+	// Commands within MULTI are reported when they are queued, and EXEC is reported as a whole
+	OnCommand func(c redis.Connection, cmdLine [][]byte, result redis.Reply, duration time.Duration)
+	// OnKeyModified is called after a write command changed a key, event is the name of keyspace event such as
+	// "set", "lpush", "del" or "expire", see notify-keyspace-events
+	OnKeyModified func(dbIndex int, event string, key string)
+	// OnKeyExpired is called after an expired key is removed
+	OnKeyExpired func(dbIndex int, key string)
+	// OnEvicted is called after a key is evicted by maxmemory-policy.
+	// Keys are never evicted for now, since maxmemory is not enforced yet
+	OnEvicted func(dbIndex int, key string)
+}
+
+// hasKeyHooks returns whether any callback of key events is set, it is safe on nil
+func (hooks *Hooks) hasKeyHooks() bool {
+	return hooks != nil && (hooks.OnKeyModified != nil || hooks.OnKeyExpired != nil || hooks.OnEvicted != nil)
+}
+
+// SetHooks replaces hooks of the server, nil removes all hooks. It is safe to call while serving
+func (server *Server) SetHooks(hooks *Hooks) {
+	server.hooks.Store(hooks)
+}
+
+func (server *Server) loadHooks() *Hooks {
+	hooks, _ := server.hooks.Load().(*Hooks)
+	return hooks
+}
+
+// keyHooked reports whether DBs should collect key events for hooks even if keyspace notification is disabled
+func (server *Server) keyHooked() bool {
+	return server.loadHooks().hasKeyHooks()
+}
+
+// beforeCommand runs BeforeCommand hook, it returns nil if the command could be executed
+func (server *Server) beforeCommand(c redis.Connection, cmdLine [][]byte) redis.Reply {
+	hooks := server.loadHooks()
+	if hooks == nil || hooks.BeforeCommand == nil {
+		return nil
+	}
+	return hooks.BeforeCommand(c, cmdLine)
+}
+
+// afterCommand runs OnCommand hook
+func (server *Server) afterCommand(c redis.Connection, cmdLine [][]byte, result redis.Reply, duration time.Duration) {
+	if hooks := server.loadHooks(); hooks != nil && hooks.OnCommand != nil {
+		hooks.OnCommand(c, cmdLine, result, duration)
+	}
+}
+
+// fireKeyHooks dispatches a keyspace event to hooks by its class
+func (server *Server) fireKeyHooks(dbIndex int, class int, event string, key string) {
+	hooks := server.loadHooks()
+	if hooks == nil {
+		return
+	}
+	switch {
+	case class == notifyExpired:
+		if hooks.OnKeyExpired != nil {
+			hooks.OnKeyExpired(dbIndex, key)
+		}
+	case class == notifyEvicted:
+		if hooks.OnEvicted != nil {
+			hooks.OnEvicted(dbIndex, key)
+		}
+	default:
+		if hooks.OnKeyModified != nil {
+			hooks.OnKeyModified(dbIndex, event, key)
+		}
+	}
+}
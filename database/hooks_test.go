@@ -0,0 +1,65 @@
+package database
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestHooks(t *testing.T) {
+	var mu sync.Mutex
+	var commands, modified, expired []string
+	testServer.SetHooks(&Hooks{
+		BeforeCommand: func(c redis.Connection, cmdLine [][]byte) redis.Reply {
+			if string(cmdLine[0]) == "flushall" {
+				return protocol.MakeErrReply("ERR rejected by hook")
+			}
+			return nil
+		},
+		OnCommand: func(c redis.Connection, cmdLine [][]byte, result redis.Reply, duration time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			commands = append(commands, string(cmdLine[0]))
+		},
+		OnKeyModified: func(dbIndex int, event string, key string) {
+			mu.Lock()
+			defer mu.Unlock()
+			modified = append(modified, event+" "+key)
+		},
+		OnKeyExpired: func(dbIndex int, key string) {
+			mu.Lock()
+			defer mu.Unlock()
+			expired = append(expired, key)
+		},
+	})
+	defer testServer.SetHooks(nil)
+
+	c := connection.NewFakeConn()
+	defer testServer.AfterClientClose(c)
+	key := utils.RandString(10)
+	result := testServer.Exec(c, utils.ToCmdLine("flushall"))
+	asserts.AssertErrReply(t, result, "ERR rejected by hook")
+	testServer.Exec(c, utils.ToCmdLine("set", key, "1"))
+	testServer.Exec(c, utils.ToCmdLine("get", key))
+	testServer.Exec(c, utils.ToCmdLine("setnx", key, "2")) // changes nothing
+	testServer.Exec(c, utils.ToCmdLine("pexpire", key, "1"))
+	time.Sleep(10 * time.Millisecond)
+	result = testServer.Exec(c, utils.ToCmdLine("get", key))
+	asserts.AssertNullBulk(t, result)
+
+	mu.Lock()
+	defer mu.Unlock()
+	asserts.AssertMultiBulkReply(t, protocol.MakeMultiBulkReply(utils.ToCmdLine(commands...)),
+		[]string{"flushall", "set", "get", "setnx", "pexpire", "get"})
+	asserts.AssertMultiBulkReply(t, protocol.MakeMultiBulkReply(utils.ToCmdLine(modified...)),
+		[]string{"set " + key, "expire " + key})
+	if len(expired) != 1 || expired[0] != key {
+		t.Errorf("unexpected expired keys: %v", expired)
+	}
+}
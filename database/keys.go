@@ -6,7 +6,10 @@ import (
 	"github.com/hdt3213/godis/datastruct/list"
 	"github.com/hdt3213/godis/datastruct/set"
 	"github.com/hdt3213/godis/datastruct/sortedset"
+	"github.com/hdt3213/godis/interface/database"
 	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/clock"
+	"github.com/hdt3213/godis/lib/timewheel"
 	"github.com/hdt3213/godis/lib/utils"
 	"github.com/hdt3213/godis/lib/wildcard"
 	"github.com/hdt3213/godis/redis/protocol"
@@ -65,7 +68,12 @@ func getType(db *DB, key string) string {
 	if !exists {
 		return "none"
 	}
-	switch entity.Data.(type) {
+	return getTypeName(entity.Data)
+}
+
+// getTypeName returns type name of data, returns empty string if type is unknown
+func getTypeName(data interface{}) string {
+	switch data.(type) {
 	case []byte:
 		return "string"
 	case list.List:
@@ -94,7 +102,8 @@ func execType(db *DB, args [][]byte) redis.Reply {
 func prepareRename(args [][]byte) ([]string, []string) {
 	src := string(args[0])
 	dest := string(args[1])
-	return []string{dest}, []string{src}
+	// source key is removed by renaming
+	return []string{src, dest}, nil
 }
 
 // execRename a key
@@ -155,47 +164,128 @@ func execRenameNx(db *DB, args [][]byte) redis.Reply {
 	return protocol.MakeIntReply(1)
 }
 
-// execExpire sets a key's time to live in seconds
-func execExpire(db *DB, args [][]byte) redis.Reply {
-	key := string(args[0])
+const (
+	expireFlagNX = 1 << iota
+	expireFlagXX
+	expireFlagGT
+	expireFlagLT
+)
 
-	ttlArg, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
-		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+// parseExpireFlags parses NX, XX, GT and LT options of the expire command family
+func parseExpireFlags(args [][]byte) (int, protocol.ErrorReply) {
+	flags := 0
+	for _, arg := range args {
+		switch strings.ToLower(string(arg)) {
+		case "nx":
+			flags |= expireFlagNX
+		case "xx":
+			flags |= expireFlagXX
+		case "gt":
+			flags |= expireFlagGT
+		case "lt":
+			flags |= expireFlagLT
+		default:
+			return 0, protocol.MakeErrReply("ERR Unsupported option " + string(arg))
+		}
+	}
+	if flags&expireFlagNX > 0 && flags&(expireFlagXX|expireFlagGT|expireFlagLT) > 0 {
+		return 0, protocol.MakeErrReply("ERR NX and XX, GT or LT options at the same time are not compatible")
 	}
-	ttl := time.Duration(ttlArg) * time.Second
+	if flags&expireFlagGT > 0 && flags&expireFlagLT > 0 {
+		return 0, protocol.MakeErrReply("ERR GT and LT options at the same time are not compatible")
+	}
+	return flags, nil
+}
 
-	_, exists := db.GetEntity(key)
-	if !exists {
-		return protocol.MakeIntReply(0)
+// parseExpireAt parses the time argument of the expire command family into an absolute time.
+// unit is the unit of when, relative means when is a ttl instead of a unix timestamp
+func parseExpireAt(arg []byte, cmdName string, unit time.Duration, relative bool) (time.Time, protocol.ErrorReply) {
+	when, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return time.Time{}, protocol.MakeErrReply("ERR value is not an integer or out of range")
 	}
+	var whenMs int64
+	if unit == time.Second {
+		if when > math.MaxInt64/1000 || when < math.MinInt64/1000 {
+			return time.Time{}, protocol.MakeErrReply("ERR invalid expire time in '" + cmdName + "' command")
+		}
+		whenMs = when * 1000
+	} else {
+		whenMs = when
+	}
+	if relative {
+		now := clock.Now().UnixMilli()
+		if (whenMs > 0 && now > math.MaxInt64-whenMs) || (whenMs < 0 && now < math.MinInt64-whenMs) {
+			return time.Time{}, protocol.MakeErrReply("ERR invalid expire time in '" + cmdName + "' command")
+		}
+		whenMs += now
+	}
+	return time.UnixMilli(whenMs), nil
+}
 
-	expireAt := time.Now().Add(ttl)
-	db.Expire(key, expireAt)
-	db.addAof(aof.MakeExpireCmd(key, expireAt).Args)
-	return protocol.MakeIntReply(1)
+// checkExpireFlags returns whether the new expiration time could be set under NX, XX, GT and LT options
+func checkExpireFlags(flags int, expireAt time.Time, current time.Time, hasTTL bool) bool {
+	if flags&expireFlagNX > 0 && hasTTL {
+		return false
+	}
+	if flags&expireFlagXX > 0 && !hasTTL {
+		return false
+	}
+	// a key without ttl is considered as an infinite ttl
+	if flags&expireFlagGT > 0 && (!hasTTL || !expireAt.After(current)) {
+		return false
+	}
+	if flags&expireFlagLT > 0 && hasTTL && !expireAt.Before(current) {
+		return false
+	}
+	return true
 }
 
-// execExpireAt sets a key's expiration in unix timestamp
-func execExpireAt(db *DB, args [][]byte) redis.Reply {
+// expireGeneric implements EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT key when [NX | XX | GT | LT].
+// unit is the unit of when, relative means when is a ttl instead of a unix timestamp
+func expireGeneric(db *DB, args [][]byte, cmdName string, unit time.Duration, relative bool) redis.Reply {
 	key := string(args[0])
-
-	raw, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
-		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	expireAt, errReply := parseExpireAt(args[1], cmdName, unit, relative)
+	if errReply != nil {
+		return errReply
+	}
+	flags, errReply := parseExpireFlags(args[2:])
+	if errReply != nil {
+		return errReply
 	}
-	expireAt := time.Unix(raw, 0)
 
 	_, exists := db.GetEntity(key)
 	if !exists {
 		return protocol.MakeIntReply(0)
 	}
 
+	raw, hasTTL := db.ttlMap.Get(key)
+	current, _ := raw.(time.Time)
+	if !checkExpireFlags(flags, expireAt, current, hasTTL) {
+		return protocol.MakeIntReply(0)
+	}
+
+	if !expireAt.After(clock.Now()) {
+		// remove key at once like redis
+		db.Remove(key)
+		db.addAof(utils.ToCmdLine("del", key))
+		return protocol.MakeIntReply(1)
+	}
 	db.Expire(key, expireAt)
 	db.addAof(aof.MakeExpireCmd(key, expireAt).Args)
 	return protocol.MakeIntReply(1)
 }
 
+// execExpire sets a key's time to live in seconds
+func execExpire(db *DB, args [][]byte) redis.Reply {
+	return expireGeneric(db, args, "expire", time.Second, true)
+}
+
+// execExpireAt sets a key's expiration in unix timestamp
+func execExpireAt(db *DB, args [][]byte) redis.Reply {
+	return expireGeneric(db, args, "expireat", time.Second, false)
+}
+
 // execExpireTime returns the absolute Unix expiration timestamp in seconds at which the given key will expire.
 func execExpireTime(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
@@ -215,44 +305,12 @@ func execExpireTime(db *DB, args [][]byte) redis.Reply {
 
 // execPExpire sets a key's time to live in milliseconds
 func execPExpire(db *DB, args [][]byte) redis.Reply {
-	key := string(args[0])
-
-	ttlArg, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
-		return protocol.MakeErrReply("ERR value is not an integer or out of range")
-	}
-	ttl := time.Duration(ttlArg) * time.Millisecond
-
-	_, exists := db.GetEntity(key)
-	if !exists {
-		return protocol.MakeIntReply(0)
-	}
-
-	expireAt := time.Now().Add(ttl)
-	db.Expire(key, expireAt)
-	db.addAof(aof.MakeExpireCmd(key, expireAt).Args)
-	return protocol.MakeIntReply(1)
+	return expireGeneric(db, args, "pexpire", time.Millisecond, true)
 }
 
 // execPExpireAt sets a key's expiration in unix timestamp specified in milliseconds
 func execPExpireAt(db *DB, args [][]byte) redis.Reply {
-	key := string(args[0])
-
-	raw, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
-		return protocol.MakeErrReply("ERR value is not an integer or out of range")
-	}
-	expireAt := time.Unix(0, raw*int64(time.Millisecond))
-
-	_, exists := db.GetEntity(key)
-	if !exists {
-		return protocol.MakeIntReply(0)
-	}
-
-	db.Expire(key, expireAt)
-
-	db.addAof(aof.MakeExpireCmd(key, expireAt).Args)
-	return protocol.MakeIntReply(1)
+	return expireGeneric(db, args, "pexpireat", time.Millisecond, false)
 }
 
 // execPExpireTime returns the absolute Unix expiration timestamp in milliseconds at which the given key will expire.
@@ -285,7 +343,7 @@ func execTTL(db *DB, args [][]byte) redis.Reply {
 		return protocol.MakeIntReply(-1)
 	}
 	expireTime, _ := raw.(time.Time)
-	ttl := expireTime.Sub(time.Now()).Seconds()
+	ttl := expireTime.Sub(clock.Now()).Seconds()
 	return protocol.MakeIntReply(int64(math.Round(ttl)))
 }
 
@@ -302,7 +360,7 @@ func execPTTL(db *DB, args [][]byte) redis.Reply {
 		return protocol.MakeIntReply(-1)
 	}
 	expireTime, _ := raw.(time.Time)
-	ttl := expireTime.Sub(time.Now()).Milliseconds()
+	ttl := expireTime.Sub(clock.Now()).Milliseconds()
 	return protocol.MakeIntReply(int64(math.Round(float64(ttl))))
 }
 
@@ -423,72 +481,168 @@ func execCopy(mdb *Server, conn redis.Connection, args [][]byte) redis.Reply {
 	return protocol.MakeIntReply(1)
 }
 
-// execScan return the result of the scan
-func execScan(db *DB, args [][]byte) redis.Reply {
-	var count int = 10
-	var pattern string = "*"
-	var scanType string = ""
-	if len(args) > 1 {
-		for i := 1; i < len(args); i++ {
-			arg := strings.ToLower(string(args[i]))
-			if arg == "count" {
-				count0, err := strconv.Atoi(string(args[i+1]))
-				if err != nil {
-					return &protocol.SyntaxErrReply{}
-				}
-				count = count0
-				i++
-			} else if arg == "match" {
-				pattern = string(args[i+1])
-				i++
-			} else if arg == "type" {
-				scanType = strings.ToLower(string(args[i+1]))
-				i++
-			} else {
-				return &protocol.SyntaxErrReply{}
-			}
-		}
-	}
-	cursor, err := strconv.Atoi(string(args[0]))
+// execMove usage: MOVE key db
+// It moves key from the selected database to the given database, with its ttl.
+// Nothing happens if key does not exist in the selected database or exists in the destination database
+func execMove(mdb *Server, conn redis.Connection, args [][]byte) redis.Reply {
+	key := string(args[0])
+	srcIndex := conn.GetDBIndex()
+	destIndex, err := strconv.Atoi(string(args[1]))
 	if err != nil {
-		return protocol.MakeErrReply("ERR invalid cursor")
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
 	}
-	keysReply, nextCursor := db.data.DictScan(cursor, count, pattern)
-	if nextCursor < 0 {
-		return protocol.MakeErrReply("Invalid argument")
+	if destIndex < 0 || destIndex >= len(mdb.dbSet) {
+		return protocol.MakeErrReply("ERR DB index is out of range")
 	}
+	if destIndex == srcIndex {
+		return protocol.MakeErrReply("ERR source and destination objects are the same")
+	}
+	srcDB := mdb.mustSelectDB(srcIndex)
+	destDB := mdb.mustSelectDB(destIndex)
+	// lock in order of db index, so that concurrent MOVE in reverse direction would not cause deadlock
+	keys := []string{key}
+	first, second := srcDB, destDB
+	if destIndex < srcIndex {
+		first, second = destDB, srcDB
+	}
+	first.RWLocks(keys, nil)
+	defer first.RWUnLocks(keys, nil)
+	second.RWLocks(keys, nil)
+	defer second.RWUnLocks(keys, nil)
 
-	if len(scanType) != 0 {
-		for i := 0; i < len(keysReply); {
-			if getType(db, string(keysReply[i])) != scanType {
-				keysReply = append(keysReply[:i], keysReply[i+1:]...)
-			} else {
-				i++
+	entity, exists := srcDB.peekEntity(key)
+	if !exists {
+		return protocol.MakeIntReply(0)
+	}
+	if _, exists = destDB.peekEntity(key); exists {
+		return protocol.MakeIntReply(0)
+	}
+	var expireTime *time.Time
+	if raw, ok := srcDB.ttlMap.Get(key); ok {
+		t := raw.(time.Time)
+		expireTime = &t
+	}
+	srcDB.Remove(key)
+	srcDB.addVersion(key)
+	destDB.PutEntity(key, entity)
+	destDB.addVersion(key)
+	if expireTime != nil {
+		destDB.Expire(key, *expireTime)
+	}
+	if hash, ok := entity.Data.(*dict.TTLDict); ok {
+		timewheel.Cancel(genFieldExpireTask(srcDB.id, key))
+		destDB.scheduleFieldExpire(key, hash)
+	}
+	srcDB.notifyEvent(notifyGeneric, "move_from", key)
+	destDB.notifyEvent(notifyGeneric, "move_to", key)
+	srcDB.tracking.invalidate(conn, keys)
+	destDB.tracking.invalidate(conn, keys)
+	mdb.AddAof(srcIndex, utils.ToCmdLine3("move", args...))
+	return protocol.MakeIntReply(1)
+}
+
+type scanOptions struct {
+	cursor   int
+	count    int
+	pattern  string
+	scanType string // TYPE option of SCAN
+	noValues bool   // NOVALUES option of HSCAN
+}
+
+// parseScanOptions parses args of scan commands starting from cursor
+func parseScanOptions(args [][]byte, allowType bool, allowNoValues bool) (*scanOptions, protocol.ErrorReply) {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 32)
+	if err != nil {
+		return nil, protocol.MakeErrReply("ERR invalid cursor")
+	}
+	opts := &scanOptions{
+		cursor:  int(cursor),
+		count:   10,
+		pattern: "*",
+	}
+	for i := 1; i < len(args); i++ {
+		arg := strings.ToLower(string(args[i]))
+		if arg == "novalues" && allowNoValues {
+			opts.noValues = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return nil, &protocol.SyntaxErrReply{}
+		}
+		if arg == "count" {
+			count, err := strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if count < 1 {
+				return nil, &protocol.SyntaxErrReply{}
 			}
+			opts.count = count
+		} else if arg == "match" {
+			opts.pattern = string(args[i+1])
+		} else if arg == "type" && allowType {
+			opts.scanType = strings.ToLower(string(args[i+1]))
+		} else {
+			return nil, &protocol.SyntaxErrReply{}
 		}
+		i++
+	}
+	return opts, nil
+}
+
+func makeScanReply(items [][]byte, nextCursor int) redis.Reply {
+	if nextCursor < 0 {
+		return protocol.MakeErrReply("ERR illegal wildcard")
 	}
 	result := make([]redis.Reply, 2)
 	result[0] = protocol.MakeBulkReply([]byte(strconv.FormatInt(int64(nextCursor), 10)))
-	result[1] = protocol.MakeMultiBulkReply(keysReply)
-
+	result[1] = protocol.MakeMultiBulkReply(items)
 	return protocol.MakeMultiRawReply(result)
 }
 
+// execScan return the result of the scan
+func execScan(db *DB, args [][]byte) redis.Reply {
+	opts, errReply := parseScanOptions(args, true, false)
+	if errReply != nil {
+		return errReply
+	}
+	keysReply, nextCursor := db.data.DictScan(opts.cursor, opts.count, opts.pattern)
+	if nextCursor < 0 {
+		return makeScanReply(nil, nextCursor)
+	}
+
+	// filter out expired keys and keys of other types, scan does not hold any lock so keys are not removed here
+	filtered := keysReply[:0]
+	for _, key := range keysReply {
+		if db.hasExpired(string(key)) {
+			continue
+		}
+		if len(opts.scanType) != 0 {
+			raw, ok := db.data.Get(string(key))
+			if !ok || getTypeName(raw.(*database.DataEntity).Data) != opts.scanType {
+				continue
+			}
+		}
+		filtered = append(filtered, key)
+	}
+	return makeScanReply(filtered, nextCursor)
+}
+
 func init() {
 	registerCommand("Del", execDel, writeAllKeys, undoDel, -2, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite}, 1, -1, 1)
-	registerCommand("Expire", execExpire, writeFirstKey, undoExpire, 3, flagWrite).
+	registerCommand("Expire", execExpire, writeFirstKey, rollbackFirstKey, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
-	registerCommand("ExpireAt", execExpireAt, writeFirstKey, undoExpire, 3, flagWrite).
+	registerCommand("ExpireAt", execExpireAt, writeFirstKey, rollbackFirstKey, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
 	registerCommand("ExpireTime", execExpireTime, readFirstKey, nil, 2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
+	registerCommand("PExpire", execPExpire, writeFirstKey, rollbackFirstKey, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
-	registerCommand("PExpire", execPExpire, writeFirstKey, undoExpire, 3, flagWrite).
-		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
-	registerCommand("PExpireAt", execPExpireAt, writeFirstKey, undoExpire, 3, flagWrite).
+	registerCommand("PExpireAt", execPExpireAt, writeFirstKey, rollbackFirstKey, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
 	registerCommand("PExpireTime", execPExpireTime, readFirstKey, nil, 2, flagReadOnly).
-		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
 	registerCommand("TTL", execTTL, readFirstKey, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom, redisFlagFast}, 1, 1, 1)
 	registerCommand("PTTL", execPTTL, readFirstKey, nil, 2, flagReadOnly).
@@ -499,9 +653,9 @@ func init() {
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
 	registerCommand("Type", execType, readFirstKey, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
-	registerCommand("Rename", execRename, prepareRename, undoRename, 3, flagReadOnly).
+	registerCommand("Rename", execRename, prepareRename, undoRename, 3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite}, 1, 1, 1)
-	registerCommand("RenameNx", execRenameNx, prepareRename, undoRename, 3, flagReadOnly).
+	registerCommand("RenameNx", execRenameNx, prepareRename, undoRename, 3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
 	registerCommand("Keys", execKeys, noPrepare, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagSortForScript}, 1, 1, 1)
@@ -1,11 +1,14 @@
 package database
 
 import (
+	"github.com/hdt3213/godis/lib/clock"
+	"github.com/hdt3213/godis/lib/timewheel"
 	"github.com/hdt3213/godis/lib/utils"
 	"github.com/hdt3213/godis/redis/connection"
 	"github.com/hdt3213/godis/redis/protocol"
 	"github.com/hdt3213/godis/redis/protocol/asserts"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 )
@@ -190,6 +193,47 @@ func TestExpireAt(t *testing.T) {
 	}
 }
 
+func TestExpireOptions(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("SET", key, key))
+
+	result := testDB.Exec(nil, utils.ToCmdLine("EXPIRE", key, "100", "XX"))
+	asserts.AssertIntReply(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("EXPIRE", key, "100", "GT"))
+	asserts.AssertIntReply(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("EXPIRE", key, "100", "NX"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("EXPIRE", key, "200", "NX"))
+	asserts.AssertIntReply(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("EXPIRE", key, "200", "LT"))
+	asserts.AssertIntReply(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("PEXPIRE", key, "200000", "gt"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("TTL", key))
+	asserts.AssertIntReplyGreaterThan(t, result, 150)
+	expireAt := time.Now().Add(time.Minute).Unix()
+	result = testDB.Exec(nil, utils.ToCmdLine("EXPIREAT", key, strconv.FormatInt(expireAt, 10), "XX", "LT"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("EXPIRETIME", key))
+	asserts.AssertIntReply(t, result, int(expireAt))
+
+	result = testDB.Exec(nil, utils.ToCmdLine("EXPIRE", key, "100", "NX", "XX"))
+	asserts.AssertErrReply(t, result, "ERR NX and XX, GT or LT options at the same time are not compatible")
+	result = testDB.Exec(nil, utils.ToCmdLine("EXPIRE", key, "100", "GT", "LT"))
+	asserts.AssertErrReply(t, result, "ERR GT and LT options at the same time are not compatible")
+	result = testDB.Exec(nil, utils.ToCmdLine("EXPIRE", key, "100", "FOO"))
+	asserts.AssertErrReply(t, result, "ERR Unsupported option FOO")
+	result = testDB.Exec(nil, utils.ToCmdLine("EXPIRE", key, "9223372036854775807"))
+	asserts.AssertErrReply(t, result, "ERR invalid expire time in 'expire' command")
+
+	// key would be removed at once if expire time is in the past
+	result = testDB.Exec(nil, utils.ToCmdLine("PEXPIREAT", key, "1"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("EXISTS", key))
+	asserts.AssertIntReply(t, result, 0)
+}
+
 func TestExpiredTime(t *testing.T) {
 	testDB.Flush()
 	key := utils.RandString(10)
@@ -394,4 +438,207 @@ func TestScan(t *testing.T) {
 		t.Errorf("expect result num 100, actually %d", len(resultByte))
 		return
 	}
+
+	// expired keys and keys of other types are filtered
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("set", "str", "1"))
+	testDB.Exec(nil, utils.ToCmdLine("rpush", "list", "1"))
+	testDB.Exec(nil, utils.ToCmdLine("set", "expired", "1", "PX", "1"))
+	time.Sleep(5 * time.Millisecond)
+	result = testDB.Exec(nil, utils.ToCmdLine("scan", "0", "count", "100"))
+	asserts.AssertMultiBulkReplySize(t, result.(*protocol.MultiRawReply).Replies[1], 2)
+	result = testDB.Exec(nil, utils.ToCmdLine("scan", "0", "count", "100", "type", "list"))
+	asserts.AssertMultiBulkReply(t, result.(*protocol.MultiRawReply).Replies[1], []string{"list"})
+
+	result = testDB.Exec(nil, utils.ToCmdLine("scan", "0", "count", "0"))
+	asserts.AssertErrReply(t, result, "Err syntax error")
+	result = testDB.Exec(nil, utils.ToCmdLine("scan", "0", "count"))
+	asserts.AssertErrReply(t, result, "Err syntax error")
+	result = testDB.Exec(nil, utils.ToCmdLine("scan", "-1"))
+	asserts.AssertErrReply(t, result, "ERR invalid cursor")
+}
+
+// scanAll runs a scan command until the cursor returns to 0, and returns all items
+func scanAll(t *testing.T, makeCmdLine func(cursor string) [][]byte) [][]byte {
+	var items [][]byte
+	cursor := "0"
+	for rounds := 0; ; rounds++ {
+		if rounds > 10000 {
+			t.Fatal("scan does not finish")
+		}
+		result, ok := testDB.Exec(nil, makeCmdLine(cursor)).(*protocol.MultiRawReply)
+		if !ok {
+			t.Fatal("expect multi raw reply")
+		}
+		cursor = string(result.Replies[0].(*protocol.BulkReply).Arg)
+		items = append(items, result.Replies[1].(*protocol.MultiBulkReply).Args...)
+		if cursor == "0" {
+			return items
+		}
+	}
+}
+
+func TestMove(t *testing.T) {
+	testMDB := NewStandaloneServer()
+	defer testMDB.Close()
+	key := utils.RandString(10)
+	value := utils.RandString(10)
+	conn := new(connection.FakeConn)
+
+	result := testMDB.Exec(conn, utils.ToCmdLine("move", key, "1"))
+	asserts.AssertIntReply(t, result, 0)
+	testMDB.Exec(conn, utils.ToCmdLine("set", key, value, "ex", "1000"))
+	result = testMDB.Exec(conn, utils.ToCmdLine("move", key, "0"))
+	asserts.AssertErrReply(t, result, "ERR source and destination objects are the same")
+	result = testMDB.Exec(conn, utils.ToCmdLine("move", key, "100"))
+	asserts.AssertErrReply(t, result, "ERR DB index is out of range")
+
+	result = testMDB.Exec(conn, utils.ToCmdLine("move", key, "1"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testMDB.Exec(conn, utils.ToCmdLine("exists", key))
+	asserts.AssertIntReply(t, result, 0)
+	testMDB.Exec(conn, utils.ToCmdLine("select", "1"))
+	result = testMDB.Exec(conn, utils.ToCmdLine("get", key))
+	asserts.AssertBulkReply(t, result, value)
+	result = testMDB.Exec(conn, utils.ToCmdLine("ttl", key))
+	asserts.AssertIntReplyGreaterThan(t, result, 0)
+
+	// key exists in destination
+	testMDB.Exec(conn, utils.ToCmdLine("select", "0"))
+	testMDB.Exec(conn, utils.ToCmdLine("set", key, value))
+	result = testMDB.Exec(conn, utils.ToCmdLine("move", key, "1"))
+	asserts.AssertIntReply(t, result, 0)
+	result = testMDB.Exec(conn, utils.ToCmdLine("exists", key))
+	asserts.AssertIntReply(t, result, 1)
+}
+
+func TestSwapDB(t *testing.T) {
+	testMDB := NewStandaloneServer()
+	defer testMDB.Close()
+	conn := new(connection.FakeConn)
+	testMDB.Exec(conn, utils.ToCmdLine("set", "a", "0"))
+	testMDB.Exec(conn, utils.ToCmdLine("set", "ttl", "0", "px", "1000"))
+	testMDB.Exec(conn, utils.ToCmdLine("select", "1"))
+	testMDB.Exec(conn, utils.ToCmdLine("set", "b", "1"))
+
+	result := testMDB.Exec(conn, utils.ToCmdLine("swapdb", "0", "1"))
+	asserts.AssertStatusReply(t, result, "OK")
+	// connection stays in db 1 which holds data of db 0 now
+	result = testMDB.Exec(conn, utils.ToCmdLine("get", "a"))
+	asserts.AssertBulkReply(t, result, "0")
+	result = testMDB.Exec(conn, utils.ToCmdLine("exists", "b"))
+	asserts.AssertIntReply(t, result, 0)
+	testMDB.Exec(conn, utils.ToCmdLine("select", "0"))
+	result = testMDB.Exec(conn, utils.ToCmdLine("get", "b"))
+	asserts.AssertBulkReply(t, result, "1")
+
+	// expire tasks follow the swapped data
+	time.Sleep(2 * time.Second)
+	if _, ok := testMDB.mustSelectDB(1).data.Get("ttl"); ok {
+		t.Error("key should be expired in background after swapping")
+	}
+
+	// transactions watching keys of swapped databases are aborted
+	testMDB.Exec(conn, utils.ToCmdLine("watch", "b"))
+	testMDB.Exec(connection.NewFakeConn(), utils.ToCmdLine("swapdb", "0", "2"))
+	testMDB.Exec(conn, utils.ToCmdLine("multi"))
+	testMDB.Exec(conn, utils.ToCmdLine("set", "b", "2"))
+	result = testMDB.Exec(conn, utils.ToCmdLine("exec"))
+	asserts.AssertNullMultiBulk(t, result)
+
+	result = testMDB.Exec(conn, utils.ToCmdLine("swapdb", "0", "100"))
+	asserts.AssertErrReply(t, result, "ERR DB index is out of range")
+	result = testMDB.Exec(conn, utils.ToCmdLine("swapdb", "a", "1"))
+	asserts.AssertErrReply(t, result, "ERR invalid first DB index")
+}
+
+func TestSwapDBConcurrently(t *testing.T) {
+	testMDB := NewStandaloneServer()
+	defer testMDB.Close()
+	const dbCount = 3
+	for i := 0; i < dbCount; i++ {
+		conn := connection.NewFakeConn()
+		conn.SelectDB(i)
+		testMDB.Exec(conn, utils.ToCmdLine("set", "db", strconv.Itoa(i)))
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < dbCount; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn := connection.NewFakeConn()
+			for j := 0; j < 200; j++ {
+				index1, index2 := strconv.Itoa(i), strconv.Itoa((i+1)%dbCount)
+				testMDB.Exec(conn, utils.ToCmdLine("swapdb", index1, index2))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// every dataset is in exactly one database
+	seen := make(map[string]bool)
+	for i := 0; i < dbCount; i++ {
+		conn := connection.NewFakeConn()
+		conn.SelectDB(i)
+		result := testMDB.Exec(conn, utils.ToCmdLine("dbsize"))
+		asserts.AssertIntReply(t, result, 1)
+		result = testMDB.Exec(conn, utils.ToCmdLine("get", "db"))
+		value := string(result.(*protocol.BulkReply).Arg)
+		if seen[value] {
+			t.Errorf("dataset %s is in more than one database", value)
+		}
+		seen[value] = true
+	}
+}
+
+// useFakeClock replaces the default clock and time wheel until the test finishes,
+// background jobs of the test run when the fake clock is advanced
+func useFakeClock(t *testing.T) *clock.Fake {
+	fake := clock.NewFake(time.Now())
+	tw := timewheel.NewWithClock(100*time.Millisecond, 600, fake)
+	tw.Start()
+	restoreClock := clock.Set(fake)
+	restoreTimeWheel := timewheel.Set(tw)
+	t.Cleanup(func() {
+		restoreTimeWheel()
+		tw.Stop()
+		restoreClock()
+	})
+	return fake
+}
+
+func TestExpireWithFakeClock(t *testing.T) {
+	fake := useFakeClock(t)
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("set", key, "v", "ex", "100"))
+	fake.Advance(40 * time.Second)
+	result := testDB.Exec(nil, utils.ToCmdLine("ttl", key))
+	asserts.AssertIntReply(t, result, 60)
+	result = testDB.Exec(nil, utils.ToCmdLine("pttl", key))
+	asserts.AssertIntReply(t, result, 60000)
+
+	fake.Advance(61 * time.Second)
+	result = testDB.Exec(nil, utils.ToCmdLine("get", key))
+	asserts.AssertNullBulk(t, result)
+	result = testDB.Exec(nil, utils.ToCmdLine("ttl", key))
+	asserts.AssertIntReply(t, result, -2)
+}
+
+func TestExpireAtWithFakeClock(t *testing.T) {
+	fake := useFakeClock(t)
+	fake.Advance(time.Hour)
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("set", key, "v"))
+	// the deadline has passed in real time but not in the fake clock
+	at := fake.Now().Add(100 * time.Second).Unix()
+	result := testDB.Exec(nil, utils.ToCmdLine("expireat", key, strconv.FormatInt(at, 10)))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("exists", key))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("expireat", key, strconv.FormatInt(at-200, 10), "lt"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("exists", key))
+	asserts.AssertIntReply(t, result, 0)
 }
@@ -0,0 +1,318 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+// latencyTsLen is the count of samples kept for each event, same as redis
+const latencyTsLen = 160
+
+type latencySample struct {
+	// unix time in seconds
+	time int64
+	// latency in milliseconds
+	latency int64
+}
+
+// latencyTimeSeries is a ring of samples of an event, at most one sample per second is kept
+type latencyTimeSeries struct {
+	idx     int
+	max     int64
+	samples [latencyTsLen]latencySample
+}
+
+// latencyMonitor records events slower than latency-monitor-threshold, all methods are safe on nil
+type latencyMonitor struct {
+	mu sync.Mutex
+	// event name -> *latencyTimeSeries
+	events map[string]*latencyTimeSeries
+}
+
+func makeLatencyMonitor() *latencyMonitor {
+	return &latencyMonitor{
+		events: make(map[string]*latencyTimeSeries),
+	}
+}
+
+// addSampleIfNeeded records a sample of event if monitor is enabled and duration reaches the threshold
+func (m *latencyMonitor) addSampleIfNeeded(event string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	threshold := config.Properties.LatencyMonitorThreshold
+	latency := duration.Milliseconds()
+	if threshold <= 0 || latency < threshold {
+		return
+	}
+	m.addSample(event, time.Now().Unix(), latency)
+}
+
+func (m *latencyMonitor) addSample(event string, now int64, latency int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ts := m.events[event]
+	if ts == nil {
+		ts = &latencyTimeSeries{}
+		m.events[event] = ts
+	}
+	if latency > ts.max {
+		ts.max = latency
+	}
+	// keep the worst latency if there is a sample in the same second
+	prev := (ts.idx + latencyTsLen - 1) % latencyTsLen
+	if ts.samples[prev].time == now {
+		if latency > ts.samples[prev].latency {
+			ts.samples[prev].latency = latency
+		}
+		return
+	}
+	ts.samples[ts.idx] = latencySample{time: now, latency: latency}
+	ts.idx = (ts.idx + 1) % latencyTsLen
+}
+
+// history returns samples of event from the oldest to the latest
+func (m *latencyMonitor) history(event string) []latencySample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ts := m.events[event]
+	if ts == nil {
+		return nil
+	}
+	var result []latencySample
+	for i := 0; i < latencyTsLen; i++ {
+		sample := ts.samples[(ts.idx+i)%latencyTsLen]
+		if sample.time != 0 {
+			result = append(result, sample)
+		}
+	}
+	return result
+}
+
+type latencyLatest struct {
+	event  string
+	sample latencySample
+	max    int64
+}
+
+// latest returns the latest sample of each event, sorted by event name
+func (m *latencyMonitor) latest() []latencyLatest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]latencyLatest, 0, len(m.events))
+	for event, ts := range m.events {
+		prev := (ts.idx + latencyTsLen - 1) % latencyTsLen
+		result = append(result, latencyLatest{
+			event:  event,
+			sample: ts.samples[prev],
+			max:    ts.max,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].event < result[j].event
+	})
+	return result
+}
+
+// reset removes samples of given events, or all events if none is given, and returns count of removed events
+func (m *latencyMonitor) reset(events []string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(events) == 0 {
+		n := len(m.events)
+		m.events = make(map[string]*latencyTimeSeries)
+		return n
+	}
+	n := 0
+	for _, event := range events {
+		if _, ok := m.events[event]; ok {
+			delete(m.events, event)
+			n++
+		}
+	}
+	return n
+}
+
+var latencyHelp = []string{
+	"LATENCY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"DOCTOR",
+	"    Return a human readable latency analysis report.",
+	"HISTORY <event>",
+	"    Return time-latency samples for the <event> class.",
+	"LATEST",
+	"    Return the latest latency samples for all events.",
+	"HISTOGRAM [<command> ...]",
+	"    Return a cumulative distribution of latencies in the format of a histogram for the specified command names.",
+	"    If no commands are specified then all histograms are replied.",
+	"RESET [<event> ...]",
+	"    Reset latency data of one or more <event> classes.",
+	"    (default: reset all data for all event classes)",
+	"HELP",
+	"    Print this help.",
+}
+
+// execLatency handles LATENCY DOCTOR, HISTORY, LATEST, HISTOGRAM and RESET
+func (server *Server) execLatency(args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.MakeArgNumErrReply("latency")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "help":
+		if len(args) != 1 {
+			return protocol.MakeArgNumErrReply("latency|help")
+		}
+		lines := make([]redis.Reply, len(latencyHelp))
+		for i, line := range latencyHelp {
+			lines[i] = protocol.MakeStatusReply(line)
+		}
+		return protocol.MakeMultiRawReply(lines)
+	case "history":
+		if len(args) != 2 {
+			return protocol.MakeArgNumErrReply("latency|history")
+		}
+		samples := server.latency.history(string(args[1]))
+		result := make([]redis.Reply, len(samples))
+		for i, sample := range samples {
+			result[i] = protocol.MakeMultiRawReply([]redis.Reply{
+				protocol.MakeIntReply(sample.time),
+				protocol.MakeIntReply(sample.latency),
+			})
+		}
+		return protocol.MakeMultiRawReply(result)
+	case "latest":
+		if len(args) != 1 {
+			return protocol.MakeArgNumErrReply("latency|latest")
+		}
+		latest := server.latency.latest()
+		result := make([]redis.Reply, len(latest))
+		for i, item := range latest {
+			result[i] = protocol.MakeMultiRawReply([]redis.Reply{
+				protocol.MakeBulkReply([]byte(item.event)),
+				protocol.MakeIntReply(item.sample.time),
+				protocol.MakeIntReply(item.sample.latency),
+				protocol.MakeIntReply(item.max),
+			})
+		}
+		return protocol.MakeMultiRawReply(result)
+	case "histogram":
+		return server.latencyHistogram(args[1:])
+	case "reset":
+		events := make([]string, len(args)-1)
+		for i, arg := range args[1:] {
+			events[i] = string(arg)
+		}
+		return protocol.MakeIntReply(int64(server.latency.reset(events)))
+	case "doctor":
+		if len(args) != 1 {
+			return protocol.MakeArgNumErrReply("latency|doctor")
+		}
+		return protocol.MakeBulkReply([]byte(server.latency.doctor()))
+	}
+	return protocol.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try LATENCY HELP.")
+}
+
+// latencyAdvices explains causes of latency spikes of each event
+var latencyAdvices = map[string]string{
+	"command": "Check your slow log with SLOWLOG GET, commands with O(N) complexity such as KEYS or " +
+		"SMEMBERS against big keys are the usual suspects.",
+	"expire-cycle": "Deleting many keys expiring at the same time is slow, consider spreading their TTLs.",
+	"aof-fsync-always": "The disk is slow to fsync, consider appendfsync everysec " +
+		"if you could afford losing one second of writes.",
+	"aof-fsync": "The disk is slow to fsync, commands are blocked while it is in progress. " +
+		"Check whether other processes are doing heavy I/O on the same disk.",
+	"aof-rewrite-start": "Writes are blocked while flushing aof file before rewriting, check the disk.",
+	"aof-rewrite-done":  "Writes are blocked while appending updates during rewriting to the new aof file, check the disk.",
+	"rdb-snapshot": "Writes are blocked while the snapshot point is taken, " +
+		"avoid calling SAVE or BGSAVE too often.",
+}
+
+// doctor returns a human readable report about latency spikes
+func (m *latencyMonitor) doctor() string {
+	latest := m.latest()
+	if len(latest) == 0 {
+		if config.Properties.LatencyMonitorThreshold <= 0 {
+			return "Latency monitoring is disabled in this Godis instance. " +
+				"You may use \"CONFIG SET latency-monitor-threshold <milliseconds>.\" in order to enable it.\n"
+		}
+		return "No latency spike was observed during the lifetime of this Godis instance.\n"
+	}
+	var sb strings.Builder
+	sb.WriteString("Latency spikes are observed in this Godis instance.\n\n")
+	for i, item := range latest {
+		samples := m.history(item.event)
+		var sum int64
+		for _, sample := range samples {
+			sum += sample.latency
+		}
+		avg := float64(sum) / float64(len(samples))
+		var deviation float64
+		for _, sample := range samples {
+			d := float64(sample.latency) - avg
+			if d < 0 {
+				d = -d
+			}
+			deviation += d
+		}
+		deviation /= float64(len(samples))
+		period := int64(0)
+		if len(samples) > 1 {
+			period = (samples[len(samples)-1].time - samples[0].time) / int64(len(samples)-1)
+		}
+		sb.WriteString(fmt.Sprintf("%d. %s: %d latency spikes (average %.0fms, mean deviation %.0fms, period %d sec). "+
+			"Worst all time event %dms.\n", i+1, item.event, len(samples), avg, deviation,
+			period, item.max))
+	}
+	sb.WriteString("\nI have a few advices for you:\n\n")
+	for _, item := range latest {
+		if advice, ok := latencyAdvices[item.event]; ok {
+			sb.WriteString("- " + item.event + ": " + advice + "\n")
+		}
+	}
+	return sb.String()
+}
+
+// latencyHistogram returns calls and cumulative latency histogram of the given commands or all recorded commands,
+// commands never called are omitted
+func (server *Server) latencyHistogram(args [][]byte) redis.Reply {
+	names, stats := server.stats.sortedCommands()
+	if len(args) > 0 {
+		wanted := make(map[string]bool, len(args))
+		for _, arg := range args {
+			wanted[strings.ToLower(string(arg))] = true
+		}
+		n := 0
+		for i, name := range names {
+			if wanted[name] {
+				names[n], stats[n] = name, stats[i]
+				n++
+			}
+		}
+		names, stats = names[:n], stats[:n]
+	}
+	result := make([]redis.Reply, 0, 2*len(names))
+	for i, name := range names {
+		bounds, counts := stats[i].cumulativeHistogram()
+		histogram := make([]redis.Reply, 0, 2*len(bounds))
+		for j := range bounds {
+			histogram = append(histogram, protocol.MakeIntReply(bounds[j]), protocol.MakeIntReply(counts[j]))
+		}
+		result = append(result,
+			protocol.MakeBulkReply([]byte(name)),
+			protocol.MakeMultiRawReply([]redis.Reply{
+				protocol.MakeBulkReply([]byte("calls")),
+				protocol.MakeIntReply(stats[i].calls.Load()),
+				protocol.MakeBulkReply([]byte("histogram_usec")),
+				protocol.MakeMultiRawReply(histogram),
+			}),
+		)
+	}
+	return protocol.MakeMultiRawReply(result)
+}
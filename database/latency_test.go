@@ -0,0 +1,123 @@
+package database
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestLatencyMonitor(t *testing.T) {
+	m := makeLatencyMonitor()
+	m.addSample("command", 100, 5)
+	m.addSample("command", 100, 8)
+	m.addSample("command", 101, 3)
+	history := m.history("command")
+	if len(history) != 2 || history[0].latency != 8 || history[1].latency != 3 {
+		t.Errorf("unexpected history: %v", history)
+	}
+	latest := m.latest()
+	if len(latest) != 1 || latest[0].sample.time != 101 || latest[0].max != 8 {
+		t.Errorf("unexpected latest: %v", latest)
+	}
+	for i := 0; i < latencyTsLen+10; i++ {
+		m.addSample("command", int64(200+i), 1)
+	}
+	history = m.history("command")
+	if len(history) != latencyTsLen || history[0].time != 210 {
+		t.Errorf("expect %d samples from 210, actual: %d from %d", latencyTsLen, len(history), history[0].time)
+	}
+}
+
+func TestLatencyCommand(t *testing.T) {
+	backup := config.Properties
+	defer func() {
+		config.Properties = backup
+	}()
+	config.Properties = &config.ServerProperties{}
+	server := NewStandaloneServer()
+	defer server.Close()
+	c := connection.NewFakeConn()
+
+	result := server.Exec(c, utils.ToCmdLine("debug", "sleep", "0.02"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = server.Exec(c, utils.ToCmdLine("latency", "latest"))
+	asserts.AssertMultiBulkReplySize(t, result, 0)
+	result = server.Exec(c, utils.ToCmdLine("latency", "doctor"))
+	if !strings.Contains(string(result.ToBytes()), "disabled") {
+		t.Errorf("expect monitor disabled, actual: %s", result.ToBytes())
+	}
+
+	result = server.Exec(c, utils.ToCmdLine("config", "set", "latency-monitor-threshold", "10"))
+	asserts.AssertStatusReply(t, result, "OK")
+	server.Exec(c, utils.ToCmdLine("debug", "sleep", "0.02"))
+	server.Exec(c, utils.ToCmdLine("get", "a"))
+	result = server.Exec(c, utils.ToCmdLine("latency", "latest"))
+	multi, ok := result.(*protocol.MultiRawReply)
+	if !ok || len(multi.Replies) != 1 {
+		t.Fatalf("expect 1 event, actual: %s", result.ToBytes())
+	}
+	item := multi.Replies[0].(*protocol.MultiRawReply)
+	asserts.AssertBulkReply(t, item.Replies[0], "command")
+	if item.Replies[2].(*protocol.IntReply).Code < 20 {
+		t.Errorf("expect latency >= 20ms, actual: %s", item.ToBytes())
+	}
+	result = server.Exec(c, utils.ToCmdLine("latency", "history", "command"))
+	if multi, ok := result.(*protocol.MultiRawReply); !ok || len(multi.Replies) != 1 {
+		t.Errorf("expect 1 sample, actual: %s", result.ToBytes())
+	}
+	result = server.Exec(c, utils.ToCmdLine("latency", "doctor"))
+	if !strings.Contains(string(result.ToBytes()), "1. command: 1 latency spikes") {
+		t.Errorf("unexpected doctor report: %s", result.ToBytes())
+	}
+
+	result = server.Exec(c, utils.ToCmdLine("latency", "reset", "nosuch"))
+	asserts.AssertIntReply(t, result, 0)
+	result = server.Exec(c, utils.ToCmdLine("latency", "reset"))
+	asserts.AssertIntReply(t, result, 1)
+	result = server.Exec(c, utils.ToCmdLine("latency", "history", "command"))
+	asserts.AssertMultiBulkReplySize(t, result, 0)
+	result = server.Exec(c, utils.ToCmdLine("latency", "nosuch"))
+	asserts.AssertErrReply(t, result, "ERR unknown subcommand 'nosuch'. Try LATENCY HELP.")
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	backup := config.Properties
+	defer func() {
+		config.Properties = backup
+	}()
+	config.Properties = &config.ServerProperties{}
+	server := NewStandaloneServer()
+	defer server.Close()
+	c := connection.NewFakeConn()
+	server.Exec(c, utils.ToCmdLine("set", "a", "1"))
+	server.Exec(c, utils.ToCmdLine("set", "a", "2"))
+	server.Exec(c, utils.ToCmdLine("get", "a"))
+
+	result := server.Exec(c, utils.ToCmdLine("latency", "histogram", "SET", "nosuch"))
+	multi, ok := result.(*protocol.MultiRawReply)
+	if !ok || len(multi.Replies) != 2 {
+		t.Fatalf("expect histogram of set, actual: %s", result.ToBytes())
+	}
+	asserts.AssertBulkReply(t, multi.Replies[0], "set")
+	detail := multi.Replies[1].(*protocol.MultiRawReply)
+	asserts.AssertBulkReply(t, detail.Replies[0], "calls")
+	asserts.AssertIntReply(t, detail.Replies[1], 2)
+	asserts.AssertBulkReply(t, detail.Replies[2], "histogram_usec")
+	histogram := detail.Replies[3].(*protocol.MultiRawReply).Replies
+	if len(histogram) == 0 || histogram[len(histogram)-1].(*protocol.IntReply).Code != 2 {
+		t.Errorf("cumulative count should end with calls, actual: %s", detail.ToBytes())
+	}
+
+	result = server.Exec(c, utils.ToCmdLine("latency", "histogram"))
+	if multi, ok := result.(*protocol.MultiRawReply); !ok || len(multi.Replies) < 4 {
+		t.Errorf("expect histograms of all commands, actual: %s", result.ToBytes())
+	}
+	server.Exec(c, utils.ToCmdLine("config", "resetstat"))
+	result = server.Exec(c, utils.ToCmdLine("latency", "histogram", "set"))
+	asserts.AssertMultiBulkReplySize(t, result, 0)
+}
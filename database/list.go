@@ -2,6 +2,7 @@ package database
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 
@@ -324,6 +325,92 @@ func execLRem(db *DB, args [][]byte) redis.Reply {
 	return protocol.MakeIntReply(int64(removed))
 }
 
+// execLPos usage: LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+// returns index of matched elements, negative rank means searching from tail
+func execLPos(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	element := args[1]
+	rank, count, maxLen := int64(1), int64(-1), int64(0) // count -1 means COUNT is not given
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return &protocol.SyntaxErrReply{}
+		}
+		val, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil {
+			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "rank":
+			if val == 0 {
+				return protocol.MakeErrReply("ERR RANK can't be zero: use 1 to start from the first match, " +
+					"2 from the second ... or use negative to start from the end of the list")
+			}
+			if val == math.MinInt64 {
+				return protocol.MakeErrReply("ERR value is out of range")
+			}
+			rank = val
+		case "count":
+			if val < 0 {
+				return protocol.MakeErrReply("ERR COUNT can't be negative")
+			}
+			count = val
+		case "maxlen":
+			if val < 0 {
+				return protocol.MakeErrReply("ERR MAXLEN can't be negative")
+			}
+			maxLen = val
+		default:
+			return &protocol.SyntaxErrReply{}
+		}
+	}
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	var indexes []int64
+	if list != nil {
+		skip := rank - 1 // matches to skip before collecting
+		if rank < 0 {
+			skip = -rank - 1
+		}
+		var compared int64
+		consumer := func(i int, v interface{}) bool {
+			if maxLen > 0 && compared >= maxLen {
+				return false
+			}
+			compared++
+			if !utils.Equals(v, element) {
+				return true
+			}
+			if skip > 0 {
+				skip--
+				return true
+			}
+			indexes = append(indexes, int64(i))
+			// count 0 means returning all matches, not given means returning the first one
+			return count == 0 || (count > 0 && int64(len(indexes)) < count)
+		}
+		if rank > 0 {
+			list.ForEach(consumer)
+		} else {
+			list.ReverseForEach(consumer)
+		}
+	}
+
+	if count < 0 {
+		if len(indexes) == 0 {
+			return &protocol.NullBulkReply{}
+		}
+		return protocol.MakeIntReply(indexes[0])
+	}
+	result := make([]redis.Reply, len(indexes))
+	for i, index := range indexes {
+		result[i] = protocol.MakeIntReply(index)
+	}
+	return protocol.MakeMultiRawReply(result)
+}
+
 // execLSet puts element at specified index of list
 func execLSet(db *DB, args [][]byte) redis.Reply {
 	// parse args
@@ -473,42 +560,48 @@ func undoRPop(db *DB, args [][]byte) []CmdLine {
 	}
 }
 
-func prepareRPopLPush(args [][]byte) ([]string, []string) {
-	return []string{
-		string(args[0]),
-		string(args[1]),
-	}, nil
+func prepareLMove(args [][]byte) ([]string, []string) {
+	return []string{string(args[0]), string(args[1])}, nil
 }
 
-// execRPopLPush pops last element of list-A then insert it to the head of list-B
-func execRPopLPush(db *DB, args [][]byte) redis.Reply {
-	sourceKey := string(args[0])
-	destKey := string(args[1])
+// blockOnSource returns the source list of BLMOVE and BRPOPLPUSH, pushes to the destination can't unblock them
+func blockOnSource(args [][]byte) []string {
+	return []string{string(args[0])}
+}
+
+func undoLMove(db *DB, args [][]byte) []CmdLine {
+	return rollbackGivenKeys(db, string(args[0]), string(args[1]))
+}
 
-	// get source entity
-	sourceList, errReply := db.getAsList(sourceKey)
+// execRPopLPush pops last element of list-A then insert it to the head of list-B, equals to LMOVE A B RIGHT LEFT
+func execRPopLPush(db *DB, args [][]byte) redis.Reply {
+	val, errReply := db.lmove(string(args[0]), string(args[1]), false, true)
 	if errReply != nil {
 		return errReply
 	}
-	if sourceList == nil {
+	if val == nil {
 		return &protocol.NullBulkReply{}
 	}
+	return protocol.MakeBulkReply(val)
+}
 
-	// get dest entity
-	destList, _, errReply := db.getOrInitList(destKey)
+// execLMove usage: LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func execLMove(db *DB, args [][]byte) redis.Reply {
+	srcLeft, ok := parseListDirection(args[2])
+	if !ok {
+		return &protocol.SyntaxErrReply{}
+	}
+	destLeft, ok := parseListDirection(args[3])
+	if !ok {
+		return &protocol.SyntaxErrReply{}
+	}
+	val, errReply := db.lmove(string(args[0]), string(args[1]), srcLeft, destLeft)
 	if errReply != nil {
 		return errReply
 	}
-
-	// pop and push
-	val, _ := sourceList.RemoveLast().([]byte)
-	destList.Insert(0, val)
-
-	if sourceList.Len() == 0 {
-		db.Remove(sourceKey)
+	if val == nil {
+		return &protocol.NullBulkReply{}
 	}
-
-	db.addAof(utils.ToCmdLine3("rpoplpush", args...))
 	return protocol.MakeBulkReply(val)
 }
 
@@ -683,6 +776,253 @@ func execLInsert(db *DB, args [][]byte) redis.Reply {
 	return protocol.MakeIntReply(int64(list.Len()))
 }
 
+// popFromList pops an element from head or tail of list, removes the key if list becomes empty
+func (db *DB) popFromList(key string, list List.List, left bool) []byte {
+	var val []byte
+	if left {
+		val, _ = list.Remove(0).([]byte)
+		db.addAof(utils.ToCmdLine("lpop", key))
+	} else {
+		val, _ = list.RemoveLast().([]byte)
+		db.addAof(utils.ToCmdLine("rpop", key))
+	}
+	if list.Len() == 0 {
+		db.Remove(key)
+	}
+	return val
+}
+
+// lmove pops an element from source and pushes it to destination atomically,
+// returns nil if source does not exist
+func (db *DB) lmove(srcKey, destKey string, srcLeft, destLeft bool) ([]byte, protocol.ErrorReply) {
+	srcList, errReply := db.getAsList(srcKey)
+	if errReply != nil || srcList == nil {
+		return nil, errReply
+	}
+	destList, errReply := db.getAsList(destKey)
+	if errReply != nil {
+		return nil, errReply
+	}
+	var val []byte
+	if srcLeft {
+		val, _ = srcList.Remove(0).([]byte)
+	} else {
+		val, _ = srcList.RemoveLast().([]byte)
+	}
+	if srcKey == destKey {
+		// rotate the list, the key must not be removed even if it has only one element
+		destList = srcList
+	} else {
+		if srcList.Len() == 0 {
+			db.Remove(srcKey)
+		}
+		if destList == nil {
+			destList, _, _ = db.getOrInitList(destKey)
+		}
+	}
+	if destLeft {
+		destList.Insert(0, val)
+	} else {
+		destList.Add(val)
+	}
+	db.addAof(utils.ToCmdLine("lmove", srcKey, destKey, listDirectionName(srcLeft), listDirectionName(destLeft)))
+	return val, nil
+}
+
+func listDirectionName(left bool) string {
+	if left {
+		return "LEFT"
+	}
+	return "RIGHT"
+}
+
+// prepareBlockingPop returns keys of BLPOP key [key ...] timeout
+func prepareBlockingPop(args [][]byte) ([]string, []string) {
+	keys := make([]string, len(args)-1)
+	for i, arg := range args[:len(args)-1] {
+		keys[i] = string(arg)
+	}
+	return keys, nil
+}
+
+func undoBlockingPop(db *DB, args [][]byte) []CmdLine {
+	keys, _ := prepareBlockingPop(args)
+	return rollbackGivenKeys(db, keys...)
+}
+
+// blockingPopGeneric pops an element from the first non-empty list, returns nil if all lists are empty
+func blockingPopGeneric(db *DB, args [][]byte, left bool) redis.Reply {
+	if _, errReply := parseBlockingTimeout(args[len(args)-1]); errReply != nil {
+		return errReply
+	}
+	for _, arg := range args[:len(args)-1] {
+		key := string(arg)
+		list, errReply := db.getAsList(key)
+		if errReply != nil {
+			return errReply
+		}
+		if list == nil {
+			continue
+		}
+		val := db.popFromList(key, list, left)
+		return protocol.MakeMultiBulkReply([][]byte{arg, val})
+	}
+	return protocol.MakeNullMultiBulkReply()
+}
+
+// execBLPop is the blocking version of LPOP
+func execBLPop(db *DB, args [][]byte) redis.Reply {
+	return blockingPopGeneric(db, args, true)
+}
+
+// execBRPop is the blocking version of RPOP
+func execBRPop(db *DB, args [][]byte) redis.Reply {
+	return blockingPopGeneric(db, args, false)
+}
+
+// parseListDirection parses LEFT or RIGHT, returns true for LEFT
+func parseListDirection(arg []byte) (bool, bool) {
+	switch strings.ToLower(string(arg)) {
+	case "left":
+		return true, true
+	case "right":
+		return false, true
+	}
+	return false, false
+}
+
+// execBRPopLPush is the blocking version of RPOPLPUSH
+func execBRPopLPush(db *DB, args [][]byte) redis.Reply {
+	if _, errReply := parseBlockingTimeout(args[2]); errReply != nil {
+		return errReply
+	}
+	return execRPopLPush(db, args[:2])
+}
+
+// execBLMove usage: BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+func execBLMove(db *DB, args [][]byte) redis.Reply {
+	if _, errReply := parseBlockingTimeout(args[4]); errReply != nil {
+		return errReply
+	}
+	return execLMove(db, args[:4])
+}
+
+type lmpopArgs struct {
+	keys  []string
+	left  bool
+	count int
+}
+
+// parseLMPopArgs parses numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func parseLMPopArgs(args [][]byte) (*lmpopArgs, protocol.ErrorReply) {
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return nil, protocol.MakeErrReply("ERR numkeys should be greater than 0")
+	}
+	if numKeys <= 0 {
+		return nil, protocol.MakeErrReply("ERR numkeys should be greater than 0")
+	}
+	if numKeys > len(args)-2 {
+		return nil, &protocol.SyntaxErrReply{}
+	}
+	result := &lmpopArgs{
+		keys:  make([]string, numKeys),
+		count: 1,
+	}
+	for i := 0; i < numKeys; i++ {
+		result.keys[i] = string(args[i+1])
+	}
+	var ok bool
+	result.left, ok = parseListDirection(args[numKeys+1])
+	if !ok {
+		return nil, &protocol.SyntaxErrReply{}
+	}
+	rest := args[numKeys+2:]
+	if len(rest) == 0 {
+		return result, nil
+	}
+	if len(rest) != 2 || strings.ToLower(string(rest[0])) != "count" {
+		return nil, &protocol.SyntaxErrReply{}
+	}
+	result.count, err = strconv.Atoi(string(rest[1]))
+	if err != nil || result.count <= 0 {
+		return nil, protocol.MakeErrReply("ERR count should be greater than 0")
+	}
+	return result, nil
+}
+
+func prepareLMPop(args [][]byte) ([]string, []string) {
+	parsed, errReply := parseLMPopArgs(args)
+	if errReply != nil {
+		return nil, nil
+	}
+	return parsed.keys, nil
+}
+
+func undoLMPop(db *DB, args [][]byte) []CmdLine {
+	keys, _ := prepareLMPop(args)
+	return rollbackGivenKeys(db, keys...)
+}
+
+// execLMPop usage: LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+// pops up to count elements from the first non-empty list
+func execLMPop(db *DB, args [][]byte) redis.Reply {
+	parsed, errReply := parseLMPopArgs(args)
+	if errReply != nil {
+		return errReply
+	}
+	for _, key := range parsed.keys {
+		list, errReply := db.getAsList(key)
+		if errReply != nil {
+			return errReply
+		}
+		if list == nil {
+			continue
+		}
+		count := parsed.count
+		if count > list.Len() {
+			count = list.Len()
+		}
+		vals := make([][]byte, count)
+		for i := range vals {
+			if parsed.left {
+				vals[i], _ = list.Remove(0).([]byte)
+			} else {
+				vals[i], _ = list.RemoveLast().([]byte)
+			}
+		}
+		if list.Len() == 0 {
+			db.Remove(key)
+		}
+		popCmd := "rpop"
+		if parsed.left {
+			popCmd = "lpop"
+		}
+		db.addAof(utils.ToCmdLine(popCmd, key, strconv.Itoa(count)))
+		return protocol.MakeMultiRawReply([]redis.Reply{
+			protocol.MakeBulkReply([]byte(key)),
+			protocol.MakeMultiBulkReply(vals),
+		})
+	}
+	return protocol.MakeNullMultiBulkReply()
+}
+
+// execBLMPop usage: BLMPOP timeout numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func execBLMPop(db *DB, args [][]byte) redis.Reply {
+	if _, errReply := parseBlockingTimeout(args[0]); errReply != nil {
+		return errReply
+	}
+	return execLMPop(db, args[1:])
+}
+
+func prepareBLMPop(args [][]byte) ([]string, []string) {
+	return prepareLMPop(args[1:])
+}
+
+func undoBLMPop(db *DB, args [][]byte) []CmdLine {
+	return undoLMPop(db, args[1:])
+}
+
 func init() {
 	registerCommand("LPush", execLPush, writeFirstKey, undoLPush, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
@@ -696,10 +1036,14 @@ func init() {
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
 	registerCommand("RPop", execRPop, writeFirstKey, undoRPop, -2, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
-	registerCommand("RPopLPush", execRPopLPush, prepareRPopLPush, undoRPopLPush, 3, flagWrite).
-		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+	registerCommand("RPopLPush", execRPopLPush, prepareLMove, undoRPopLPush, 3, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 2, 1)
+	registerCommand("LMove", execLMove, prepareLMove, undoLMove, 5, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 2, 1)
 	registerCommand("LRem", execLRem, writeFirstKey, rollbackFirstKey, 4, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite}, 1, 1, 1)
+	registerCommand("LPos", execLPos, readFirstKey, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
 	registerCommand("LLen", execLLen, readFirstKey, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
 	registerCommand("LIndex", execLIndex, readFirstKey, nil, 3, flagReadOnly).
@@ -712,4 +1056,21 @@ func init() {
 		attachCommandExtra([]string{redisFlagWrite}, 1, 1, 1)
 	registerCommand("LInsert", execLInsert, writeFirstKey, rollbackFirstKey, 5, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+	registerCommand("BLPop", execBLPop, prepareBlockingPop, undoBlockingPop, -3, flagWrite).
+		attachBlocking(-1, nil).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagNoScript}, 1, -2, 1)
+	registerCommand("BRPop", execBRPop, prepareBlockingPop, undoBlockingPop, -3, flagWrite).
+		attachBlocking(-1, nil).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagNoScript}, 1, -2, 1)
+	registerCommand("BRPopLPush", execBRPopLPush, prepareLMove, undoLMove, 4, flagWrite).
+		attachBlocking(-1, blockOnSource).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagNoScript}, 1, 2, 1)
+	registerCommand("LMPop", execLMPop, prepareLMPop, undoLMPop, -4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagMovableKeys}, 0, 0, 0)
+	registerCommand("BLMPop", execBLMPop, prepareBLMPop, undoBLMPop, -5, flagWrite).
+		attachBlocking(0, nil).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagMovableKeys, redisFlagNoScript}, 0, 0, 0)
+	registerCommand("BLMove", execBLMove, prepareLMove, undoLMove, 6, flagWrite).
+		attachBlocking(-1, blockOnSource).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagNoScript}, 1, 2, 1)
 }
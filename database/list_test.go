@@ -3,8 +3,11 @@ package database
 import (
 	"strconv"
 	"testing"
+	"time"
 
+	"github.com/hdt3213/godis/interface/redis"
 	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
 	"github.com/hdt3213/godis/redis/protocol"
 	"github.com/hdt3213/godis/redis/protocol/asserts"
 )
@@ -366,6 +369,35 @@ func TestRPopLPush(t *testing.T) {
 	}
 }
 
+func TestLMove(t *testing.T) {
+	testDB.Flush()
+	src := utils.RandString(10)
+	dest := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("rpush", src, "a", "b", "c"))
+	result := testDB.Exec(nil, utils.ToCmdLine("lmove", src, dest, "LEFT", "RIGHT"))
+	asserts.AssertBulkReply(t, result, "a")
+	result = testDB.Exec(nil, utils.ToCmdLine("lmove", src, dest, "right", "left"))
+	asserts.AssertBulkReply(t, result, "c")
+	result = testDB.Exec(nil, utils.ToCmdLine("lrange", dest, "0", "-1"))
+	asserts.AssertMultiBulkReply(t, result, []string{"c", "a"})
+
+	// rotating a list with single element keeps the key
+	result = testDB.Exec(nil, utils.ToCmdLine("rpoplpush", src, src))
+	asserts.AssertBulkReply(t, result, "b")
+	result = testDB.Exec(nil, utils.ToCmdLine("lrange", src, "0", "-1"))
+	asserts.AssertMultiBulkReply(t, result, []string{"b"})
+
+	result = testDB.Exec(nil, utils.ToCmdLine("lmove", "nosuchkey", dest, "LEFT", "RIGHT"))
+	asserts.AssertNullBulk(t, result)
+	result = testDB.Exec(nil, utils.ToCmdLine("lmove", src, dest, "LEFT", "UP"))
+	asserts.AssertErrReply(t, result, "Err syntax error")
+	testDB.Exec(nil, utils.ToCmdLine("set", "str", "1"))
+	result = testDB.Exec(nil, utils.ToCmdLine("lmove", src, "str", "LEFT", "RIGHT"))
+	asserts.AssertErrReply(t, result, "WRONGTYPE Operation against a key holding the wrong kind of value")
+	result = testDB.Exec(nil, utils.ToCmdLine("llen", src))
+	asserts.AssertIntReply(t, result, 1)
+}
+
 func TestRPushX(t *testing.T) {
 	testDB.Flush()
 	key := utils.RandString(10)
@@ -619,3 +651,239 @@ func TestUndoRPopLPush(t *testing.T) {
 	result = testDB.Exec(nil, utils.ToCmdLine("llen", key2))
 	asserts.AssertIntReply(t, result, 0)
 }
+
+// execAsync executes command in another goroutine, the reply will be sent to the returned channel
+func execAsync(conn redis.Connection, cmdLine [][]byte) <-chan redis.Reply {
+	ch := make(chan redis.Reply, 1)
+	go func() {
+		ch <- testServer.Exec(conn, cmdLine)
+	}()
+	return ch
+}
+
+func TestBLPop(t *testing.T) {
+	conn := connection.NewFakeConn()
+	key := utils.RandString(10)
+	testServer.Exec(conn, utils.ToCmdLine("RPUSH", key, "a", "b"))
+	result := testServer.Exec(conn, utils.ToCmdLine("BLPOP", "nosuchkey", key, "0"))
+	asserts.AssertMultiBulkReply(t, result, []string{key, "a"})
+	result = testServer.Exec(conn, utils.ToCmdLine("BRPOP", key, "0"))
+	asserts.AssertMultiBulkReply(t, result, []string{key, "b"})
+	result = testServer.Exec(conn, utils.ToCmdLine("EXISTS", key))
+	asserts.AssertIntReply(t, result, 0)
+
+	start := time.Now()
+	result = testServer.Exec(conn, utils.ToCmdLine("BLPOP", key, "0.1"))
+	if string(result.ToBytes()) != "*-1\r\n" {
+		t.Errorf("expect nil, actually %s", result.ToBytes())
+	}
+	if time.Since(start) < 100*time.Millisecond {
+		t.Error("BLPOP returns before timeout")
+	}
+
+	result = testServer.Exec(conn, utils.ToCmdLine("BLPOP", key, "-1"))
+	asserts.AssertErrReply(t, result, "ERR timeout is negative")
+	result = testServer.Exec(conn, utils.ToCmdLine("BLPOP", key, "abc"))
+	asserts.AssertErrReply(t, result, "ERR timeout is not a float or out of range")
+
+	// waiters are served in FIFO order
+	conn1 := connection.NewFakeConn()
+	conn2 := connection.NewFakeConn()
+	ch1 := execAsync(conn1, utils.ToCmdLine("BLPOP", key, "0"))
+	time.Sleep(50 * time.Millisecond)
+	ch2 := execAsync(conn2, utils.ToCmdLine("BRPOP", "otherkey", key, "0"))
+	time.Sleep(50 * time.Millisecond)
+	testServer.Exec(conn, utils.ToCmdLine("RPUSH", key, "1", "2"))
+	asserts.AssertMultiBulkReply(t, <-ch1, []string{key, "1"})
+	asserts.AssertMultiBulkReply(t, <-ch2, []string{key, "2"})
+
+	// blocking commands within multi return nil at once
+	testServer.Exec(conn, utils.ToCmdLine("MULTI"))
+	testServer.Exec(conn, utils.ToCmdLine("BLPOP", key, "0"))
+	result = testServer.Exec(conn, utils.ToCmdLine("EXEC"))
+	if string(result.ToBytes()) != "*1\r\n*-1\r\n" {
+		t.Errorf("expect nil, actually %s", result.ToBytes())
+	}
+
+	// closing connection unblocks it
+	ch := execAsync(conn1, utils.ToCmdLine("BLPOP", key, "0"))
+	time.Sleep(50 * time.Millisecond)
+	testServer.AfterClientClose(conn1)
+	select {
+	case result = <-ch:
+		if string(result.ToBytes()) != "*-1\r\n" {
+			t.Errorf("expect nil, actually %s", result.ToBytes())
+		}
+	case <-time.After(time.Second):
+		t.Error("connection is not unblocked")
+	}
+}
+
+func TestBLPopKeepsWatch(t *testing.T) {
+	conn := connection.NewFakeConn()
+	blocker := connection.NewFakeConn()
+	key := utils.RandString(10)
+	testServer.Exec(conn, utils.ToCmdLine("WATCH", key))
+	ch := execAsync(blocker, utils.ToCmdLine("BLPOP", key, "0"))
+	time.Sleep(50 * time.Millisecond)
+	// the blocked BLPOP modifies nothing, so the transaction is not aborted
+	testServer.Exec(conn, utils.ToCmdLine("MULTI"))
+	testServer.Exec(conn, utils.ToCmdLine("SET", utils.RandString(10), "a"))
+	result := testServer.Exec(conn, utils.ToCmdLine("EXEC"))
+	if string(result.ToBytes()) != "*1\r\n+OK\r\n" {
+		t.Errorf("expect EXEC to succeed, actually %s", result.ToBytes())
+	}
+	testServer.Exec(conn, utils.ToCmdLine("RPUSH", key, "a"))
+	asserts.AssertMultiBulkReply(t, <-ch, []string{key, "a"})
+}
+
+func TestBLPopTimeoutWithFakeClock(t *testing.T) {
+	fake := useFakeClock(t)
+	conn := connection.NewFakeConn()
+	defer testServer.AfterClientClose(conn)
+	ch := execAsync(conn, utils.ToCmdLine("BLPOP", utils.RandString(10), "100"))
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case <-ch:
+		t.Error("BLPOP returns before timeout")
+	default:
+	}
+	// timewheel rounds the deadline up to its interval
+	fake.Advance(101 * time.Second)
+	select {
+	case result := <-ch:
+		if string(result.ToBytes()) != "*-1\r\n" {
+			t.Errorf("expect nil, actually %s", result.ToBytes())
+		}
+	case <-time.After(time.Second):
+		t.Error("BLPOP should time out with the fake clock")
+	}
+}
+
+func TestBLMove(t *testing.T) {
+	conn := connection.NewFakeConn()
+	src := utils.RandString(10)
+	dest := utils.RandString(10)
+	ch := execAsync(conn, utils.ToCmdLine("BLMOVE", src, dest, "LEFT", "RIGHT", "0"))
+	time.Sleep(50 * time.Millisecond)
+	testServer.Exec(connection.NewFakeConn(), utils.ToCmdLine("RPUSH", src, "a", "b"))
+	asserts.AssertBulkReply(t, <-ch, "a")
+	result := testServer.Exec(conn, utils.ToCmdLine("BRPOPLPUSH", src, dest, "0"))
+	asserts.AssertBulkReply(t, result, "b")
+	result = testServer.Exec(conn, utils.ToCmdLine("LRANGE", dest, "0", "-1"))
+	asserts.AssertMultiBulkReply(t, result, []string{"b", "a"})
+	result = testServer.Exec(conn, utils.ToCmdLine("EXISTS", src))
+	asserts.AssertIntReply(t, result, 0)
+
+	// rotate list
+	result = testServer.Exec(conn, utils.ToCmdLine("BLMOVE", dest, dest, "LEFT", "RIGHT", "0"))
+	asserts.AssertBulkReply(t, result, "b")
+	result = testServer.Exec(conn, utils.ToCmdLine("LRANGE", dest, "0", "-1"))
+	asserts.AssertMultiBulkReply(t, result, []string{"a", "b"})
+
+	result = testServer.Exec(conn, utils.ToCmdLine("BLMOVE", src, dest, "UP", "RIGHT", "0"))
+	asserts.AssertErrReply(t, result, "Err syntax error")
+	result = testServer.Exec(conn, utils.ToCmdLine("BRPOPLPUSH", src, dest, "0.05"))
+	asserts.AssertNullBulk(t, result)
+}
+
+func TestBLMoveNotWokenByDestination(t *testing.T) {
+	mover := connection.NewFakeConn()
+	popper := connection.NewFakeConn()
+	src := utils.RandString(10)
+	dest := utils.RandString(10)
+	moveCh := execAsync(mover, utils.ToCmdLine("BLMOVE", src, dest, "LEFT", "RIGHT", "0"))
+	time.Sleep(50 * time.Millisecond)
+	popCh := execAsync(popper, utils.ToCmdLine("BLPOP", dest, "0"))
+	time.Sleep(50 * time.Millisecond)
+	// BLMOVE waits for its source only, the push must wake BLPOP up
+	testServer.Exec(connection.NewFakeConn(), utils.ToCmdLine("RPUSH", dest, "a"))
+	select {
+	case result := <-popCh:
+		asserts.AssertMultiBulkReply(t, result, []string{dest, "a"})
+	case <-time.After(time.Second):
+		t.Error("BLPOP on destination is not woken up")
+	}
+	testServer.AfterClientClose(mover)
+	asserts.AssertNullBulk(t, <-moveCh)
+}
+
+func TestLMPop(t *testing.T) {
+	conn := connection.NewFakeConn()
+	key1 := utils.RandString(10)
+	key2 := utils.RandString(10)
+	result := testServer.Exec(conn, utils.ToCmdLine("LMPOP", "2", key1, key2, "LEFT"))
+	if string(result.ToBytes()) != "*-1\r\n" {
+		t.Errorf("expect nil, actually %s", result.ToBytes())
+	}
+	testServer.Exec(conn, utils.ToCmdLine("RPUSH", key2, "a", "b", "c"))
+	result = testServer.Exec(conn, utils.ToCmdLine("LMPOP", "2", key1, key2, "RIGHT", "COUNT", "2"))
+	expected := "*2\r\n$10\r\n" + key2 + "\r\n*2\r\n$1\r\nc\r\n$1\r\nb\r\n"
+	if string(result.ToBytes()) != expected {
+		t.Errorf("expect %q, actually %q", expected, result.ToBytes())
+	}
+	result = testServer.Exec(conn, utils.ToCmdLine("LMPOP", "1", key2, "LEFT", "COUNT", "10"))
+	expected = "*2\r\n$10\r\n" + key2 + "\r\n*1\r\n$1\r\na\r\n"
+	if string(result.ToBytes()) != expected {
+		t.Errorf("expect %q, actually %q", expected, result.ToBytes())
+	}
+	result = testServer.Exec(conn, utils.ToCmdLine("EXISTS", key2))
+	asserts.AssertIntReply(t, result, 0)
+
+	result = testServer.Exec(conn, utils.ToCmdLine("LMPOP", "0", key1, "LEFT"))
+	asserts.AssertErrReply(t, result, "ERR numkeys should be greater than 0")
+	result = testServer.Exec(conn, utils.ToCmdLine("LMPOP", "3", key1, "LEFT"))
+	asserts.AssertErrReply(t, result, "Err syntax error")
+	result = testServer.Exec(conn, utils.ToCmdLine("LMPOP", "1", key1, "LEFT", "COUNT", "0"))
+	asserts.AssertErrReply(t, result, "ERR count should be greater than 0")
+
+	ch := execAsync(conn, utils.ToCmdLine("BLMPOP", "0", "2", key1, key2, "LEFT", "COUNT", "2"))
+	time.Sleep(50 * time.Millisecond)
+	testServer.Exec(connection.NewFakeConn(), utils.ToCmdLine("RPUSH", key1, "x", "y", "z"))
+	expected = "*2\r\n$10\r\n" + key1 + "\r\n*2\r\n$1\r\nx\r\n$1\r\ny\r\n"
+	if result = <-ch; string(result.ToBytes()) != expected {
+		t.Errorf("expect %q, actually %q", expected, result.ToBytes())
+	}
+	result = testServer.Exec(conn, utils.ToCmdLine("BLMPOP", "0.05", "1", key2, "LEFT"))
+	if string(result.ToBytes()) != "*-1\r\n" {
+		t.Errorf("expect nil, actually %s", result.ToBytes())
+	}
+}
+
+func TestLPos(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("RPUSH", key, "a", "b", "c", "1", "2", "3", "c", "c"))
+	result := testDB.Exec(nil, utils.ToCmdLine("LPOS", key, "c"))
+	asserts.AssertIntReply(t, result, 2)
+	result = testDB.Exec(nil, utils.ToCmdLine("LPOS", key, "c", "RANK", "2"))
+	asserts.AssertIntReply(t, result, 6)
+	result = testDB.Exec(nil, utils.ToCmdLine("LPOS", key, "c", "RANK", "-1"))
+	asserts.AssertIntReply(t, result, 7)
+	result = testDB.Exec(nil, utils.ToCmdLine("LPOS", key, "c", "COUNT", "2"))
+	if string(result.ToBytes()) != "*2\r\n:2\r\n:6\r\n" {
+		t.Errorf("unexpected reply %q", result.ToBytes())
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("LPOS", key, "c", "RANK", "-1", "COUNT", "0"))
+	if string(result.ToBytes()) != "*3\r\n:7\r\n:6\r\n:2\r\n" {
+		t.Errorf("unexpected reply %q", result.ToBytes())
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("LPOS", key, "c", "COUNT", "0", "MAXLEN", "7"))
+	if string(result.ToBytes()) != "*2\r\n:2\r\n:6\r\n" {
+		t.Errorf("unexpected reply %q", result.ToBytes())
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("LPOS", key, "x"))
+	asserts.AssertNullBulk(t, result)
+	result = testDB.Exec(nil, utils.ToCmdLine("LPOS", "nosuchkey", "x", "COUNT", "1"))
+	if string(result.ToBytes()) != "*0\r\n" {
+		t.Errorf("expect empty array, actually %q", result.ToBytes())
+	}
+
+	result = testDB.Exec(nil, utils.ToCmdLine("LPOS", key, "c", "RANK", "0"))
+	asserts.AssertErrReply(t, result, "ERR RANK can't be zero: use 1 to start from the first match, "+
+		"2 from the second ... or use negative to start from the end of the list")
+	result = testDB.Exec(nil, utils.ToCmdLine("LPOS", key, "c", "COUNT", "-1"))
+	asserts.AssertErrReply(t, result, "ERR COUNT can't be negative")
+	result = testDB.Exec(nil, utils.ToCmdLine("LPOS", key, "c", "MAXLEN"))
+	asserts.AssertErrReply(t, result, "Err syntax error")
+}
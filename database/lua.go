@@ -0,0 +1,308 @@
+package database
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/redis/parser"
+	"github.com/hdt3213/godis/redis/protocol"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+const (
+	// runRunning is the initial state of scriptRun, the script could be killed
+	runRunning = iota
+	// runWritten means the script has called write commands, killing it would leave the dataset half modified
+	runWritten
+	// runKilled means the script is interrupted by SCRIPT KILL or FUNCTION KILL
+	runKilled
+)
+
+// scriptRun is a script in execution, commands called by redis.call are executed through it
+type scriptRun struct {
+	db *DB
+	// keys declared by the caller, the script could access only these keys which have been locked
+	keys map[string]struct{}
+	// readOnly scripts, such as EVAL_RO, refuse write commands
+	readOnly bool
+	// function is the name of function called by FCALL, empty for EVAL
+	function string
+	start    time.Time
+	cancel   context.CancelFunc
+	// runRunning, runWritten or runKilled, updated atomically
+	state int32
+	// 1 if the script has been reported as slow, updated atomically
+	slowLogged int32
+}
+
+// killCommand returns the command which could interrupt the script
+func (run *scriptRun) killCommand() string {
+	if run.function != "" {
+		return "FUNCTION KILL"
+	}
+	return "SCRIPT KILL"
+}
+
+func (run *scriptRun) killedReply() redis.Reply {
+	return protocol.MakeErrReply("ERR Script killed by user with " + run.killCommand() + "...")
+}
+
+// compileLua compiles lua source, name is the chunk name shown in error messages
+func compileLua(source string, name string) (*lua.FunctionProto, error) {
+	chunk, err := parse.Parse(strings.NewReader(source), name)
+	if err != nil {
+		return nil, err
+	}
+	return lua.Compile(chunk, name)
+}
+
+// newLuaState returns a lua state with base, table, string and math libraries
+func newLuaState() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	libs := []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	}
+	for _, lib := range libs {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	// scripts must not access the file system
+	L.SetGlobal("dofile", lua.LNil)
+	L.SetGlobal("loadfile", lua.LNil)
+	return L
+}
+
+// openRedisLib sets global table `redis` whose call and pcall execute commands by run
+func (run *scriptRun) openRedisLib(L *lua.LState) *lua.LTable {
+	lib := L.NewTable()
+	L.SetFuncs(lib, map[string]lua.LGFunction{
+		"call": func(L *lua.LState) int {
+			return run.luaCall(L, true)
+		},
+		"pcall": func(L *lua.LState) int {
+			return run.luaCall(L, false)
+		},
+		"error_reply": func(L *lua.LState) int {
+			L.Push(makeLuaReplyTable(L, "err", L.CheckString(1)))
+			return 1
+		},
+		"status_reply": func(L *lua.LState) int {
+			L.Push(makeLuaReplyTable(L, "ok", L.CheckString(1)))
+			return 1
+		},
+		"sha1hex": func(L *lua.LState) int {
+			L.Push(lua.LString(sha1Hex(L.CheckString(1))))
+			return 1
+		},
+	})
+	L.SetGlobal("redis", lib)
+	return lib
+}
+
+// luaCall implements redis.call and redis.pcall, error replies are raised by redis.call and returned by redis.pcall
+func (run *scriptRun) luaCall(L *lua.LState, raise bool) int {
+	n := L.GetTop()
+	if n == 0 {
+		L.RaiseError("Please specify at least one argument for redis.call()")
+		return 0
+	}
+	cmdLine := make([][]byte, n)
+	for i := 1; i <= n; i++ {
+		switch arg := L.Get(i).(type) {
+		case lua.LString:
+			cmdLine[i-1] = []byte(arg)
+		case lua.LNumber:
+			cmdLine[i-1] = []byte(arg.String())
+		default:
+			L.RaiseError("Lua redis lib command arguments must be strings or integers")
+			return 0
+		}
+	}
+	result := run.exec(cmdLine)
+	if errReply, ok := result.(protocol.ErrorReply); ok && raise {
+		L.Error(makeLuaReplyTable(L, "err", errReply.Error()), 1)
+		return 0
+	}
+	L.Push(replyToLua(L, result))
+	return 1
+}
+
+// exec executes a command called by script, keys have been locked by the caller of script
+func (run *scriptRun) exec(cmdLine [][]byte) redis.Reply {
+	name := strings.ToLower(string(cmdLine[0]))
+	// scripts call commands by names given by rename-command like clients
+	cmdName, ok := translateCommand(name)
+	if !ok {
+		return protocol.MakeErrReply("ERR Unknown Redis command called from script")
+	}
+	cmd, ok := cmdTable[cmdName]
+	if !ok {
+		return protocol.MakeErrReply("ERR Unknown Redis command called from script")
+	}
+	if cmdName != name {
+		cmdLine = translateCmdLine(cmdLine, cmdName)
+	}
+	if cmd.flags&flagSpecial > 0 || cmd.prepare == nil || cmd.hasSign(redisFlagNoScript) {
+		return protocol.MakeErrReply("ERR This Redis command is not allowed from script")
+	}
+	if !validateArity(cmd.arity, cmdLine) {
+		return protocol.MakeErrReply("ERR Wrong number of args calling Redis command from script")
+	}
+	write, read := cmd.prepare(cmdLine[1:])
+	for _, key := range append(write, read...) {
+		if _, ok := run.keys[key]; !ok {
+			return protocol.MakeErrReply("ERR Script attempted to access a key not declared in KEYS: " + key)
+		}
+	}
+	if cmd.flags&flagReadOnly == 0 {
+		if run.readOnly {
+			return protocol.MakeErrReply("ERR Write commands are not allowed from read-only scripts.")
+		}
+		if !atomic.CompareAndSwapInt32(&run.state, runRunning, runWritten) &&
+			atomic.LoadInt32(&run.state) == runKilled {
+			return run.killedReply()
+		}
+	}
+	result := run.db.execWithLock(cmdLine)
+	if result == nil {
+		// blocking commands never block within scripts
+		return protocol.MakeNullBulkReply()
+	}
+	return result
+}
+
+// call calls fn with args in L and converts its return value to reply
+func (run *scriptRun) call(L *lua.LState, fn *lua.LFunction, name string, args ...lua.LValue) redis.Reply {
+	L.Push(fn)
+	for _, arg := range args {
+		L.Push(arg)
+	}
+	if err := L.PCall(len(args), 1, nil); err != nil {
+		if atomic.LoadInt32(&run.state) == runKilled {
+			return run.killedReply()
+		}
+		if apiErr, ok := err.(*lua.ApiError); ok {
+			if tbl, ok := apiErr.Object.(*lua.LTable); ok {
+				// error raised by redis.call or redis.error_reply
+				return luaToReply(tbl)
+			}
+			return protocol.MakeErrReply("ERR Error running script (call to " + name + "): " + apiErr.Object.String())
+		}
+		return protocol.MakeErrReply("ERR Error running script (call to " + name + "): " + err.Error())
+	}
+	result := L.Get(-1)
+	L.Pop(1)
+	return luaToReply(result)
+}
+
+func makeLuaReplyTable(L *lua.LState, field string, msg string) *lua.LTable {
+	tbl := L.NewTable()
+	tbl.RawSetString(field, lua.LString(msg))
+	return tbl
+}
+
+// makeLuaStringArray converts args to a lua array, such as KEYS and ARGV
+func makeLuaStringArray(L *lua.LState, args [][]byte) *lua.LTable {
+	tbl := L.CreateTable(len(args), 0)
+	for _, arg := range args {
+		tbl.Append(lua.LString(arg))
+	}
+	return tbl
+}
+
+// replyToLua converts reply of command to lua value like redis:
+// nil is false, status and error are tables with a single ok or err field
+func replyToLua(L *lua.LState, reply redis.Reply) lua.LValue {
+	switch r := reply.(type) {
+	case *protocol.IntReply:
+		return lua.LNumber(r.Code)
+	case *protocol.BulkReply:
+		if r.Arg == nil {
+			return lua.LFalse
+		}
+		return lua.LString(r.Arg)
+	case *protocol.NullBulkReply, *protocol.NullMultiBulkReply:
+		return lua.LFalse
+	case *protocol.OkReply:
+		return makeLuaReplyTable(L, "ok", "OK")
+	case *protocol.StatusReply:
+		return makeLuaReplyTable(L, "ok", r.Status)
+	case *protocol.EmptyMultiBulkReply:
+		return L.NewTable()
+	case *protocol.MultiBulkReply:
+		tbl := L.CreateTable(len(r.Args), 0)
+		for _, arg := range r.Args {
+			if arg == nil {
+				tbl.Append(lua.LFalse)
+			} else {
+				tbl.Append(lua.LString(arg))
+			}
+		}
+		return tbl
+	case *protocol.MultiRawReply:
+		tbl := L.CreateTable(len(r.Replies), 0)
+		for _, elem := range r.Replies {
+			tbl.Append(replyToLua(L, elem))
+		}
+		return tbl
+	case protocol.ErrorReply:
+		return makeLuaReplyTable(L, "err", r.Error())
+	}
+	// other replies are converted through protocol, such as PongReply
+	parsed, err := parser.ParseOne(reply.ToBytes())
+	if err != nil {
+		return lua.LFalse
+	}
+	return replyToLua(L, parsed)
+}
+
+// luaToReply converts value returned by script to reply like redis:
+// numbers are truncated to integers, false is nil, true is 1, arrays end at the first nil
+func luaToReply(value lua.LValue) redis.Reply {
+	switch v := value.(type) {
+	case lua.LString:
+		return protocol.MakeBulkReply([]byte(v))
+	case lua.LNumber:
+		return protocol.MakeIntReply(int64(v))
+	case lua.LBool:
+		if v {
+			return protocol.MakeIntReply(1)
+		}
+		return protocol.MakeNullBulkReply()
+	case *lua.LTable:
+		if msg, ok := v.RawGetString("err").(lua.LString); ok {
+			return protocol.MakeErrReply(string(msg))
+		}
+		if status, ok := v.RawGetString("ok").(lua.LString); ok {
+			return protocol.MakeStatusReply(string(status))
+		}
+		replies := make([]redis.Reply, 0, v.Len())
+		for i := 1; ; i++ {
+			elem := v.RawGetInt(i)
+			if elem == lua.LNil {
+				break
+			}
+			replies = append(replies, luaToReply(elem))
+		}
+		return protocol.MakeMultiRawReply(replies)
+	}
+	return protocol.MakeNullBulkReply()
+}
+
+func sha1Hex(source string) string {
+	sum := sha1.Sum([]byte(source))
+	return hex.EncodeToString(sum[:])
+}
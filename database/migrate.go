@@ -0,0 +1,221 @@
+package database
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hdt3213/godis/aof"
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/datastruct/dict"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/clock"
+	"github.com/hdt3213/godis/lib/logger"
+	"github.com/hdt3213/godis/lib/pool"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/client"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+// migrateConnections caches connections to migration targets, map[string]*pool.Pool
+var migrateConnections = dict.MakeConcurrent(16)
+
+var migratePoolConfig = pool.Config{
+	MaxIdle:     1,
+	MaxActive:   16,
+	IdleTimeout: 5 * time.Minute,
+}
+
+const defaultMigrateTimeout = time.Second
+
+func borrowMigrateClient(addr string) (*client.Client, *pool.Pool, error) {
+	raw, ok := migrateConnections.Get(addr)
+	if !ok {
+		creator := func() (interface{}, error) {
+			return client.Dial(addr, nil)
+		}
+		finalizer := func(x interface{}) {
+			cli, ok := x.(*client.Client)
+			if !ok {
+				return
+			}
+			cli.Close()
+		}
+		migrateConnections.PutIfAbsent(addr, pool.New(creator, finalizer, migratePoolConfig))
+		raw, _ = migrateConnections.Get(addr)
+	}
+	connectionPool := raw.(*pool.Pool)
+	x, err := connectionPool.Get()
+	if err != nil {
+		return nil, nil, err
+	}
+	cli, ok := x.(*client.Client)
+	if !ok {
+		return nil, nil, errors.New("connection pool make wrong type")
+	}
+	return cli, connectionPool, nil
+}
+
+type migrateOptions struct {
+	copy     bool
+	replace  bool
+	authArgs []string // AUTH or AUTH2 command line
+	keys     []string
+}
+
+// parseMigrateOptions parses args of MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE]
+// [AUTH password] [AUTH2 username password] [KEYS key [key ...]]
+func parseMigrateOptions(args [][]byte) (*migrateOptions, protocol.ErrorReply) {
+	opts := &migrateOptions{}
+	i := 5
+	for ; i < len(args); i++ {
+		arg := strings.ToLower(string(args[i]))
+		if arg == "copy" {
+			opts.copy = true
+		} else if arg == "replace" {
+			opts.replace = true
+		} else if arg == "auth" && i+1 < len(args) {
+			opts.authArgs = []string{"AUTH", string(args[i+1])}
+			i++
+		} else if arg == "auth2" && i+2 < len(args) {
+			opts.authArgs = []string{"AUTH", string(args[i+1]), string(args[i+2])}
+			i += 2
+		} else if arg == "keys" {
+			if len(args[2]) != 0 {
+				return nil, protocol.MakeErrReply("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			for _, key := range args[i+1:] {
+				opts.keys = append(opts.keys, string(key))
+			}
+			break
+		} else {
+			return nil, &protocol.SyntaxErrReply{}
+		}
+	}
+	if len(opts.keys) == 0 {
+		opts.keys = []string{string(args[2])}
+	}
+	return opts, nil
+}
+
+func prepareMigrate(args [][]byte) ([]string, []string) {
+	opts, err := parseMigrateOptions(args)
+	if err != nil {
+		return nil, nil
+	}
+	return opts.keys, nil
+}
+
+func undoMigrate(db *DB, args [][]byte) []CmdLine {
+	opts, err := parseMigrateOptions(args)
+	if err != nil {
+		return nil
+	}
+	return rollbackGivenKeys(db, opts.keys...)
+}
+
+// execMigrate transfers keys to another redis instance by DUMP and RESTORE,
+// keys successfully restored on target will be removed unless COPY is given
+func execMigrate(db *DB, args [][]byte) redis.Reply {
+	addr := net.JoinHostPort(string(args[0]), string(args[1]))
+	destDB, err := strconv.Atoi(string(args[3]))
+	if err != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	timeoutMs, err := strconv.ParseInt(string(args[4]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultMigrateTimeout
+	}
+	opts, errReply := parseMigrateOptions(args)
+	if errReply != nil {
+		return errReply
+	}
+
+	// dump keys before connecting target, so that nothing will be sent if there is no key
+	keys := make([]string, 0, len(opts.keys))
+	restoreCmds := make([]CmdLine, 0, len(opts.keys))
+	restoreCmd := "RESTORE"
+	if config.Properties.ClusterEnable {
+		// target may be importing the slot from this node
+		restoreCmd = "RESTORE-ASKING"
+	}
+	for _, key := range opts.keys {
+		entity, exists := db.GetEntity(key)
+		if !exists {
+			continue
+		}
+		payload, err := aof.DumpEntity(entity)
+		if err != nil {
+			return protocol.MakeErrReply("ERR " + err.Error())
+		}
+		ttl := int64(0)
+		if raw, ok := db.ttlMap.Get(key); ok {
+			ttl = clock.Until(raw.(time.Time)).Milliseconds()
+			if ttl < 1 {
+				ttl = 1
+			}
+		}
+		cmdLine := utils.ToCmdLine(restoreCmd, key, strconv.FormatInt(ttl, 10))
+		cmdLine = append(cmdLine, payload)
+		if opts.replace {
+			cmdLine = append(cmdLine, []byte("REPLACE"))
+		}
+		keys = append(keys, key)
+		restoreCmds = append(restoreCmds, cmdLine)
+	}
+	if len(keys) == 0 {
+		return protocol.MakeStatusReply("NOKEY")
+	}
+
+	cli, connectionPool, err := borrowMigrateClient(addr)
+	if err != nil {
+		logger.Warn("migrate: connect " + addr + " failed: " + err.Error())
+		return protocol.MakeErrReply("IOERR error or timeout connecting to the client")
+	}
+	defer connectionPool.Put(cli)
+	send := func(cmdLine CmdLine) (redis.Reply, protocol.ErrorReply) {
+		reply, err := cli.SendWithTimeout(cmdLine, timeout)
+		if err != nil {
+			return nil, protocol.MakeErrReply("IOERR error or timeout reading to target instance")
+		}
+		if errReply, ok := reply.(protocol.ErrorReply); ok {
+			return nil, protocol.MakeErrReply("ERR Target instance replied with error: " + errReply.Error())
+		}
+		return reply, nil
+	}
+	if len(opts.authArgs) > 0 {
+		if _, errReply := send(utils.ToCmdLine(opts.authArgs...)); errReply != nil {
+			return errReply
+		}
+	}
+	if _, errReply := send(utils.ToCmdLine("SELECT", strconv.Itoa(destDB))); errReply != nil {
+		return errReply
+	}
+	var restored []string
+	for i, cmdLine := range restoreCmds {
+		_, errReply = send(cmdLine)
+		if errReply != nil {
+			break
+		}
+		restored = append(restored, keys[i])
+	}
+	if !opts.copy && len(restored) > 0 {
+		db.Removes(restored...)
+		db.addAof(utils.ToCmdLine2("del", restored...))
+	}
+	if errReply != nil {
+		return errReply
+	}
+	return protocol.MakeOkReply()
+}
+
+func init() {
+	registerCommand("Migrate", execMigrate, prepareMigrate, undoMigrate, -6, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagRandom, redisFlagMovableKeys}, 3, 3, 1)
+}
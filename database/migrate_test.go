@@ -0,0 +1,98 @@
+package database
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/parser"
+	"github.com/hdt3213/godis/redis/protocol"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+// serveMigrateTarget starts a standalone server on a random port as migration target
+func serveMigrateTarget(t *testing.T) (string, *Server) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	target := NewStandaloneServer()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				client := connection.NewConn(conn)
+				for payload := range parser.ParseStream(conn) {
+					if payload.Err != nil {
+						return
+					}
+					cmdLine := payload.Data.(*protocol.MultiBulkReply).Args
+					_, _ = conn.Write(target.Exec(client, cmdLine).ToBytes())
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String(), target
+}
+
+func TestMigrate(t *testing.T) {
+	addr, target := serveMigrateTarget(t)
+	host, port, _ := net.SplitHostPort(addr)
+	conn := connection.NewFakeConn()
+	testServer.Exec(conn, utils.ToCmdLine("FLUSHALL"))
+	t.Cleanup(func() {
+		testServer.Exec(conn, utils.ToCmdLine("FLUSHALL"))
+	})
+
+	result := testServer.Exec(conn, utils.ToCmdLine("MIGRATE", host, port, "nosuchkey", "0", "1000"))
+	asserts.AssertStatusReply(t, result, "NOKEY")
+
+	testServer.Exec(conn, utils.ToCmdLine("SET", "k1", "v1", "EX", "100"))
+	result = testServer.Exec(conn, utils.ToCmdLine("MIGRATE", host, port, "k1", "2", "1000"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testServer.Exec(conn, utils.ToCmdLine("EXISTS", "k1"))
+	asserts.AssertIntReply(t, result, 0)
+	targetConn := connection.NewFakeConn()
+	targetConn.SelectDB(2)
+	result = target.Exec(targetConn, utils.ToCmdLine("GET", "k1"))
+	asserts.AssertBulkReply(t, result, "v1")
+	result = target.Exec(targetConn, utils.ToCmdLine("TTL", "k1"))
+	asserts.AssertIntReplyGreaterThan(t, result, 90)
+
+	// migrate an existing key without REPLACE
+	testServer.Exec(conn, utils.ToCmdLine("SET", "k1", "v2"))
+	result = testServer.Exec(conn, utils.ToCmdLine("MIGRATE", host, port, "k1", "2", "1000"))
+	asserts.AssertErrReply(t, result, "ERR Target instance replied with error: BUSYKEY Target key name already exists.")
+	result = testServer.Exec(conn, utils.ToCmdLine("EXISTS", "k1"))
+	asserts.AssertIntReply(t, result, 1)
+
+	for i := 0; i < 3; i++ {
+		testServer.Exec(conn, utils.ToCmdLine("RPUSH", "list"+strconv.Itoa(i), "a", "b"))
+	}
+	result = testServer.Exec(conn, utils.ToCmdLine("MIGRATE", host, port, "", "2", "1000", "COPY", "REPLACE",
+		"KEYS", "k1", "list0", "list1", "list2", "nosuchkey"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testServer.Exec(conn, utils.ToCmdLine("EXISTS", "k1", "list0", "list1", "list2"))
+	asserts.AssertIntReply(t, result, 4)
+	result = target.Exec(targetConn, utils.ToCmdLine("GET", "k1"))
+	asserts.AssertBulkReply(t, result, "v2")
+	result = target.Exec(targetConn, utils.ToCmdLine("LRANGE", "list2", "0", "-1"))
+	asserts.AssertMultiBulkReply(t, result, []string{"a", "b"})
+
+	result = testServer.Exec(conn, utils.ToCmdLine("MIGRATE", host, port, "k1", "2", "1000", "KEYS", "list0"))
+	asserts.AssertErrReply(t, result, "ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+
+	// no server is listening on port 1 of localhost
+	result = testServer.Exec(conn, utils.ToCmdLine("MIGRATE", "127.0.0.1", "1", "k1", "0", "1000"))
+	asserts.AssertErrReply(t, result, "IOERR error or timeout connecting to the client")
+	result = testServer.Exec(conn, utils.ToCmdLine("EXISTS", "k1"))
+	asserts.AssertIntReply(t, result, 1)
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"errors"
+	"plugin"
+	"strings"
+
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/logger"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+// CommandSpec describes a custom command registered by RegisterCommand
+type CommandSpec struct {
+	Name string
+	// Arity is the allowed number of arguments including command name, -N means at least N arguments
+	Arity int
+	// ReadOnly commands could be served by replicas and are never written to aof
+	ReadOnly bool
+	// Keys returns keys written and read by the command, args exclude command name.
+	// Keys are locked during execution, restored if MULTI is rolled back and used to route the command in cluster mode.
+	// Nil means the command has no keys
+	Keys PreFunc
+	// Handler executes the command within the selected db, args exclude command name.
+	// Values should be built-in types such as []byte or List.List, so that they could be persisted and replicated
+	Handler ExecFunc
+}
+
+// moduleCommands holds names of commands registered by RegisterCommand
+var moduleCommands = make(map[string]bool)
+
+// RegisterCommand adds a custom command, it must be called before serving clients, such as in init of the package
+// implementing commands or Init of a plugin loaded by loadmodule.
+// If the command is not ReadOnly, it is appended to aof and propagated to replicas as is unless it replies an error,
+// therefore Handler should be deterministic
+func RegisterCommand(spec *CommandSpec) error {
+	name := strings.ToLower(spec.Name)
+	if name == "" || strings.ContainsAny(name, " \r\n") {
+		return errors.New("invalid command name: " + spec.Name)
+	}
+	if spec.Handler == nil {
+		return errors.New("handler of " + name + " is nil")
+	}
+	if spec.Arity == 0 {
+		return errors.New("arity of " + name + " is 0")
+	}
+	if _, ok := cmdTable[name]; ok {
+		return errors.New("command " + name + " already exists")
+	}
+	prepare := spec.Keys
+	if prepare == nil {
+		prepare = noPrepare
+	}
+	handler := spec.Handler
+	executor := handler
+	if !spec.ReadOnly {
+		executor = func(db *DB, args [][]byte) redis.Reply {
+			result := handler(db, args)
+			if !protocol.IsErrorReply(result) {
+				db.addAof(utils.ToCmdLine3(name, args...))
+			}
+			return result
+		}
+	}
+	undo := func(db *DB, args [][]byte) []CmdLine {
+		write, _ := prepare(args)
+		return rollbackGivenKeys(db, write...)
+	}
+	flags := flagWrite
+	sign := redisFlagWrite
+	if spec.ReadOnly {
+		flags = flagReadOnly
+		sign = redisFlagReadonly
+	}
+	registerCommand(name, executor, prepare, undo, spec.Arity, flags).
+		attachCommandExtra([]string{sign}, 0, 0, 0)
+	moduleCommands[name] = true
+	return nil
+}
+
+// IsModuleCommand returns whether the command is registered by RegisterCommand
+func IsModuleCommand(name string) bool {
+	return moduleCommands[strings.ToLower(name)]
+}
+
+// moduleInitSymbol is the function exported by plugins, such as `func Init() error`, which calls RegisterCommand
+const moduleInitSymbol = "Init"
+
+// LoadModules opens go plugins built by `go build -buildmode=plugin` and calls their Init function,
+// see loadmodule in config. Plugins must be built with the same version of go and godis
+func LoadModules(paths []string) error {
+	for _, path := range paths {
+		p, err := plugin.Open(path)
+		if err != nil {
+			return errors.New("load module " + path + " failed: " + err.Error())
+		}
+		symbol, err := p.Lookup(moduleInitSymbol)
+		if err != nil {
+			return errors.New("load module " + path + " failed: " + err.Error())
+		}
+		initFunc, ok := symbol.(func() error)
+		if !ok {
+			return errors.New("load module " + path + " failed: " + moduleInitSymbol + " should be func() error")
+		}
+		if err := initFunc(); err != nil {
+			return errors.New("init module " + path + " failed: " + err.Error())
+		}
+		logger.Info("module loaded: " + path)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/hdt3213/godis/interface/database"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+// execDouble implements `test.double key`, which appends the string value to itself
+func execDouble(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	val, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	val = append(val, val...)
+	db.PutEntity(key, &database.DataEntity{Data: val})
+	return protocol.MakeIntReply(int64(len(val)))
+}
+
+func TestRegisterCommand(t *testing.T) {
+	err := RegisterCommand(&CommandSpec{
+		Name:    "TEST.DOUBLE",
+		Arity:   2,
+		Keys:    writeFirstKey,
+		Handler: execDouble,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// commands are registered globally, remove it so that the test could run again, such as with -count
+	t.Cleanup(func() {
+		delete(cmdTable, "test.double")
+		delete(moduleCommands, "test.double")
+	})
+	if !IsModuleCommand("test.double") || IsModuleCommand("get") {
+		t.Error("IsModuleCommand failed")
+	}
+	write, read := GetRelatedKeys(utils.ToCmdLine("test.double", "k"))
+	if len(write) != 1 || write[0] != "k" || len(read) != 0 {
+		t.Errorf("unexpected related keys: %v %v", write, read)
+	}
+
+	c := connection.NewFakeConn()
+	defer testServer.AfterClientClose(c)
+	key := utils.RandString(10)
+	testServer.Exec(c, utils.ToCmdLine("set", key, "ab"))
+	result := testServer.Exec(c, utils.ToCmdLine("test.double", key))
+	asserts.AssertIntReply(t, result, 4)
+	result = testServer.Exec(c, utils.ToCmdLine("get", key))
+	asserts.AssertBulkReply(t, result, "abab")
+	result = testServer.Exec(c, utils.ToCmdLine("test.double"))
+	asserts.AssertErrReply(t, result, "ERR wrong number of arguments for 'test.double' command")
+
+	// rolled back when a command in transaction fails
+	testServer.Exec(c, utils.ToCmdLine("multi"))
+	testServer.Exec(c, utils.ToCmdLine("test.double", key))
+	testServer.Exec(c, utils.ToCmdLine("lpush", key, "a"))
+	result = testServer.Exec(c, utils.ToCmdLine("exec"))
+	if !protocol.IsErrorReply(result) {
+		t.Error("expect transaction to be aborted")
+	}
+	result = testServer.Exec(c, utils.ToCmdLine("get", key))
+	asserts.AssertBulkReply(t, result, "abab")
+
+	result = testServer.Exec(c, utils.ToCmdLine("command", "info", "test.double"))
+	asserts.AssertNotError(t, result)
+	testServer.Exec(c, utils.ToCmdLine("del", key))
+}
+
+func TestRegisterCommandInvalid(t *testing.T) {
+	specs := []*CommandSpec{
+		{Name: "", Arity: 1, Handler: execDouble},
+		{Name: "test.nohandler", Arity: 1},
+		{Name: "test.noarity", Handler: execDouble},
+		{Name: "get", Arity: 2, Handler: execDouble},
+	}
+	for _, spec := range specs {
+		if err := RegisterCommand(spec); err == nil {
+			t.Errorf("expect error when registering %q", spec.Name)
+		}
+	}
+}
+
+func TestLoadModulesError(t *testing.T) {
+	if err := LoadModules([]string{"/nonexistent/module.so"}); err == nil {
+		t.Error("expect error when loading nonexistent module")
+	}
+}
@@ -0,0 +1,223 @@
+package database
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/pubsub"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+// classes of keyspace events, see notify-keyspace-events in redis.conf
+const (
+	notifyKeyspace = 1 << iota // K
+	notifyKeyevent             // E
+	notifyGeneric              // g
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZSet                 // z
+	notifyExpired              // x
+	notifyEvicted              // e
+
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash | notifyZSet | notifyExpired | notifyEvicted // A
+)
+
+var errIllegalNotifyFlags = errors.New("ERR Invalid event class character. Use 'Ag$lshzxeKE'.")
+
+// parseNotifyFlags converts notify-keyspace-events to flags, it returns 0 if neither K nor E is set
+func parseNotifyFlags(classes string) (int, error) {
+	flags := 0
+	for _, c := range classes {
+		switch c {
+		case 'A':
+			flags |= notifyAll
+		case 'g':
+			flags |= notifyGeneric
+		case '$':
+			flags |= notifyString
+		case 'l':
+			flags |= notifyList
+		case 's':
+			flags |= notifySet
+		case 'h':
+			flags |= notifyHash
+		case 'z':
+			flags |= notifyZSet
+		case 'x':
+			flags |= notifyExpired
+		case 'e':
+			flags |= notifyEvicted
+		case 'K':
+			flags |= notifyKeyspace
+		case 'E':
+			flags |= notifyKeyevent
+		default:
+			return 0, errIllegalNotifyFlags
+		}
+	}
+	if flags&(notifyKeyspace|notifyKeyevent) == 0 {
+		return 0, nil
+	}
+	return flags, nil
+}
+
+type notifyConfig struct {
+	classes string
+	flags   int
+}
+
+// parsed notify-keyspace-events, it will be parsed again once config changed
+var notifyConfigCache atomic.Value // *notifyConfig
+
+func getNotifyFlags() int {
+	classes := config.Properties.NotifyKeyspaceEvents
+	if cached, _ := notifyConfigCache.Load().(*notifyConfig); cached != nil && cached.classes == classes {
+		return cached.flags
+	}
+	flags, _ := parseNotifyFlags(classes)
+	notifyConfigCache.Store(&notifyConfig{
+		classes: classes,
+		flags:   flags,
+	})
+	return flags
+}
+
+// notifyKeyspaceEvent publishes keyspace event to __keyspace@<db>__:<key> and __keyevent@<db>__:<event>,
+// and passes it to hooks
+func (server *Server) notifyKeyspaceEvent(dbIndex int, class int, event string, key string) {
+	server.fireKeyHooks(dbIndex, class, event, key)
+	flags := getNotifyFlags()
+	if flags&class == 0 {
+		return
+	}
+	index := strconv.Itoa(dbIndex)
+	if flags&notifyKeyspace > 0 {
+		pubsub.Publish(server.hub, utils.ToCmdLine("__keyspace@"+index+"__:"+key, event))
+	}
+	if flags&notifyKeyevent > 0 {
+		pubsub.Publish(server.hub, utils.ToCmdLine("__keyevent@"+index+"__:"+event, key))
+	}
+}
+
+// notifyEvent emits a keyspace event of the given key if keyspace notification is enabled
+func (db *DB) notifyEvent(class int, event string, key string) {
+	if db.notify != nil {
+		db.notify(db.index, class, event, key)
+	}
+}
+
+func (db *DB) keyHooksEnabled() bool {
+	return db.keyHooked != nil && db.keyHooked()
+}
+
+var typeNotifyClasses = map[string]int{
+	"string": notifyString,
+	"list":   notifyList,
+	"hash":   notifyHash,
+	"set":    notifySet,
+	"zset":   notifyZSet,
+}
+
+// genericEvents maps commands operating keys regardless of their type to the name of events
+var genericEvents = map[string]string{
+	"del":       "del",
+	"unlink":    "del",
+	"getdel":    "del",
+	"expire":    "expire",
+	"pexpire":   "expire",
+	"expireat":  "expire",
+	"pexpireat": "expire",
+	"persist":   "persist",
+	"copy":      "copy_to",
+	"restore":   "restore",
+}
+
+// blocking commands emit the same event as their non-blocking version
+var eventAliases = map[string]string{
+	"blpop":      "lpop",
+	"brpop":      "rpop",
+	"blmove":     "lmove",
+	"brpoplpush": "rpoplpush",
+	"bzpopmin":   "zpopmin",
+	"bzpopmax":   "zpopmax",
+}
+
+// keyState is the type of a key before a write command executed
+type keyState struct {
+	key      string
+	typeName string // empty if key not exists
+}
+
+// beforeWrite records types of keys to be written, it returns nil if neither keyspace notification nor key hooks is enabled
+func (db *DB) beforeWrite(cmd *command, args [][]byte) []keyState {
+	if db.notify == nil || cmd.flags&flagReadOnly > 0 || (getNotifyFlags() == 0 && !db.keyHooksEnabled()) {
+		return nil
+	}
+	writeKeys, _ := cmd.prepare(args)
+	states := make([]keyState, len(writeKeys))
+	for i, key := range writeKeys {
+		states[i].key = key
+		if entity, ok := db.peekEntity(key); ok {
+			states[i].typeName = getTypeName(entity.Data)
+		}
+	}
+	return states
+}
+
+// afterWrite emits keyspace events of keys recorded by beforeWrite, if the command changed anything
+func (db *DB) afterWrite(cmd *command, states []keyState, result redis.Reply) {
+	if len(states) == 0 || !isEffectiveReply(result) {
+		return
+	}
+	if cmd.name == "rename" || cmd.name == "renamenx" {
+		db.notifyEvent(notifyGeneric, "rename_from", states[0].key)
+		db.notifyEvent(notifyGeneric, "rename_to", states[1].key)
+		return
+	}
+	for _, state := range states {
+		typeName := ""
+		if entity, ok := db.peekEntity(state.key); ok {
+			typeName = getTypeName(entity.Data)
+		}
+		if typeName == "" && state.typeName == "" {
+			// nothing happened
+			continue
+		}
+		if event, ok := genericEvents[cmd.name]; ok {
+			db.notifyEvent(notifyGeneric, event, state.key)
+			continue
+		}
+		class := typeNotifyClasses[typeName]
+		if typeName == "" {
+			class = typeNotifyClasses[state.typeName]
+		}
+		event := cmd.name
+		if alias, ok := eventAliases[event]; ok {
+			event = alias
+		}
+		db.notifyEvent(class, event, state.key)
+		if typeName == "" {
+			// the last element has been removed
+			db.notifyEvent(notifyGeneric, "del", state.key)
+		}
+	}
+}
+
+// isEffectiveReply returns false if the reply shows the command did nothing, such as 0 from SETNX or nil from SPOP
+func isEffectiveReply(result redis.Reply) bool {
+	switch r := result.(type) {
+	case protocol.ErrorReply:
+		return false
+	case *protocol.IntReply:
+		return r.Code != 0
+	case *protocol.NullBulkReply, *protocol.NullMultiBulkReply:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/parser"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+func TestParseNotifyFlags(t *testing.T) {
+	flags, err := parseNotifyFlags("KEA")
+	if err != nil || flags != notifyKeyspace|notifyKeyevent|notifyAll {
+		t.Errorf("wrong flags of KEA: %b", flags)
+	}
+	flags, _ = parseNotifyFlags("g$")
+	if flags != 0 {
+		t.Error("expect disabled without K or E")
+	}
+	if _, err = parseNotifyFlags("Kq"); err == nil {
+		t.Error("expect error")
+	}
+}
+
+// readMessages returns [channel, message] of published messages written to conn
+func readMessages(conn *connection.FakeConn) [][2]string {
+	var messages [][2]string
+	for payload := range parser.ParseStream(bytes.NewReader(conn.Bytes())) {
+		if payload.Err != nil {
+			break
+		}
+		reply, ok := payload.Data.(*protocol.MultiBulkReply)
+		if !ok || len(reply.Args) != 3 || string(reply.Args[0]) != "message" {
+			continue
+		}
+		messages = append(messages, [2]string{string(reply.Args[1]), string(reply.Args[2])})
+	}
+	conn.Clean()
+	return messages
+}
+
+func assertMessages(t *testing.T, actual [][2]string, expected ...[2]string) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Errorf("expect messages %v, actual %v", expected, actual)
+		return
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("expect messages %v, actual %v", expected, actual)
+			return
+		}
+	}
+}
+
+func TestKeyspaceNotification(t *testing.T) {
+	classes := config.Properties.NotifyKeyspaceEvents
+	config.Properties.NotifyKeyspaceEvents = "KEA"
+	defer func() {
+		config.Properties.NotifyKeyspaceEvents = classes
+	}()
+	key := utils.RandString(10)
+	key2 := utils.RandString(10)
+	sub := connection.NewFakeConn()
+	testServer.Exec(sub, utils.ToCmdLine("subscribe", "__keyspace@0__:"+key, "__keyevent@0__:del"))
+	sub.Clean()
+	defer testServer.AfterClientClose(sub)
+	c := connection.NewFakeConn()
+	defer testServer.Exec(c, utils.ToCmdLine("del", key, key2))
+
+	testServer.Exec(c, utils.ToCmdLine("set", key, "a"))
+	assertMessages(t, readMessages(sub), [2]string{"__keyspace@0__:" + key, "set"})
+
+	// nothing changed
+	testServer.Exec(c, utils.ToCmdLine("setnx", key, "b"))
+	testServer.Exec(c, utils.ToCmdLine("get", key))
+	assertMessages(t, readMessages(sub))
+
+	testServer.Exec(c, utils.ToCmdLine("del", key, key2))
+	assertMessages(t, readMessages(sub),
+		[2]string{"__keyspace@0__:" + key, "del"},
+		[2]string{"__keyevent@0__:del", key},
+	)
+
+	// popping the last element also deletes the key
+	testServer.Exec(c, utils.ToCmdLine("rpush", key, "a"))
+	testServer.Exec(c, utils.ToCmdLine("lpop", key))
+	assertMessages(t, readMessages(sub),
+		[2]string{"__keyspace@0__:" + key, "rpush"},
+		[2]string{"__keyspace@0__:" + key, "lpop"},
+		[2]string{"__keyspace@0__:" + key, "del"},
+		[2]string{"__keyevent@0__:del", key},
+	)
+
+	testServer.Exec(c, utils.ToCmdLine("hset", key, "f", "v"))
+	testServer.Exec(c, utils.ToCmdLine("rename", key, key2))
+	assertMessages(t, readMessages(sub),
+		[2]string{"__keyspace@0__:" + key, "hset"},
+		[2]string{"__keyspace@0__:" + key, "rename_from"},
+	)
+
+	// only selected classes are published
+	config.Properties.NotifyKeyspaceEvents = "Kl"
+	testServer.Exec(c, utils.ToCmdLine("set", key, "a"))
+	assertMessages(t, readMessages(sub))
+
+	config.Properties.NotifyKeyspaceEvents = "Kx"
+	testServer.Exec(c, utils.ToCmdLine("pexpire", key, "50"))
+	time.Sleep(100 * time.Millisecond)
+	// expired key is removed lazily while accessing
+	testServer.Exec(c, utils.ToCmdLine("get", key))
+	assertMessages(t, readMessages(sub), [2]string{"__keyspace@0__:" + key, "expired"})
+}
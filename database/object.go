@@ -0,0 +1,112 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+func prepareObject(args [][]byte) ([]string, []string) {
+	if len(args) < 2 {
+		return nil, nil
+	}
+	return nil, []string{string(args[1])}
+}
+
+var objectHelp = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value",
+	"    associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is",
+	"    proportional to the logarithm of the recent access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of",
+	"    seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified",
+	"    <key>.",
+	"HELP",
+	"    Print this help.",
+}
+
+// embstrSizeLimit is the max length of strings which redis stores in embstr encoding
+const embstrSizeLimit = 44
+
+// getEncoding returns name of the internal representation of data like redis does
+func getEncoding(data interface{}) string {
+	switch val := data.(type) {
+	case []byte:
+		if len(val) <= 20 {
+			if n, err := strconv.ParseInt(string(val), 10, 64); err == nil && strconv.FormatInt(n, 10) == string(val) {
+				return "int"
+			}
+		}
+		if len(val) <= embstrSizeLimit {
+			return "embstr"
+		}
+		return "raw"
+	case interface{ Encoding() string }:
+		return val.Encoding()
+	}
+	return "unknown"
+}
+
+// getRefCount returns refcount of data like redis does, values are never shared between keys except small integers
+func getRefCount(data interface{}) int64 {
+	if val, ok := data.([]byte); ok && isSharedValue(val) {
+		return sharedRefCount
+	}
+	return 1
+}
+
+// execObject inspects the internals of the value bound to a key, it does not update access time of the key
+func execObject(db *DB, args [][]byte) redis.Reply {
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "help":
+		if len(args) != 1 {
+			return protocol.MakeErrReply("ERR wrong number of arguments for 'object|help' command")
+		}
+		lines := make([]redis.Reply, len(objectHelp))
+		for i, line := range objectHelp {
+			lines[i] = protocol.MakeStatusReply(line)
+		}
+		return protocol.MakeMultiRawReply(lines)
+	case "encoding", "refcount", "idletime", "freq":
+		if len(args) != 2 {
+			return protocol.MakeErrReply("ERR wrong number of arguments for 'object|" + subCmd + "' command")
+		}
+		entity, exists := db.peekEntity(string(args[1]))
+		if !exists {
+			return protocol.MakeNullBulkReply()
+		}
+		switch subCmd {
+		case "encoding":
+			return protocol.MakeBulkReply([]byte(getEncoding(entity.Data)))
+		case "refcount":
+			return protocol.MakeIntReply(getRefCount(entity.Data))
+		}
+		lfu := config.Properties.IsLFUEnabled()
+		if subCmd == "idletime" {
+			if lfu {
+				return protocol.MakeErrReply("ERR An LFU maxmemory policy is selected, idle time not tracked.")
+			}
+			return protocol.MakeIntReply(int64(entity.IdleTime().Seconds()))
+		}
+		if !lfu {
+			return protocol.MakeErrReply("ERR An LFU maxmemory policy is not selected, access frequency not tracked.")
+		}
+		return protocol.MakeIntReply(int64(entity.LFUCounter(config.Properties.LFUDecayTime)))
+	}
+	return protocol.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try OBJECT HELP.")
+}
+
+func init() {
+	registerCommand("Object", execObject, prepareObject, nil, -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly}, 2, 2, 1)
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/interface/database"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/protocol"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestObjectIdleTime(t *testing.T) {
+	fake := useFakeClock(t)
+	testDB.Flush()
+	key := utils.RandString(10)
+	result := testDB.Exec(nil, utils.ToCmdLine("OBJECT", "IDLETIME", key))
+	asserts.AssertNullBulk(t, result)
+	testDB.Exec(nil, utils.ToCmdLine("SET", key, key))
+	result = testDB.Exec(nil, utils.ToCmdLine("OBJECT", "IDLETIME", key))
+	asserts.AssertIntReply(t, result, 0)
+
+	fake.Advance(2 * time.Second)
+	// OBJECT itself does not touch the key
+	result = testDB.Exec(nil, utils.ToCmdLine("OBJECT", "IDLETIME", key))
+	asserts.AssertIntReply(t, result, 2)
+	testDB.Exec(nil, utils.ToCmdLine("GET", key))
+	result = testDB.Exec(nil, utils.ToCmdLine("OBJECT", "IDLETIME", key))
+	asserts.AssertIntReply(t, result, 0)
+
+	result = testDB.Exec(nil, utils.ToCmdLine("OBJECT", "NOSUCH", key))
+	asserts.AssertErrReply(t, result, "ERR unknown subcommand 'NOSUCH'. Try OBJECT HELP.")
+}
+
+func TestObjectFreq(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("SET", key, key))
+	result := testDB.Exec(nil, utils.ToCmdLine("OBJECT", "FREQ", key))
+	asserts.AssertErrReply(t, result, "ERR An LFU maxmemory policy is not selected, access frequency not tracked.")
+
+	policy, logFactor := config.Properties.MaxMemoryPolicy, config.Properties.LFULogFactor
+	config.Properties.MaxMemoryPolicy, config.Properties.LFULogFactor = "allkeys-lfu", 10
+	defer func() {
+		config.Properties.MaxMemoryPolicy, config.Properties.LFULogFactor = policy, logFactor
+	}()
+	result = testDB.Exec(nil, utils.ToCmdLine("OBJECT", "IDLETIME", key))
+	asserts.AssertErrReply(t, result, "ERR An LFU maxmemory policy is selected, idle time not tracked.")
+
+	// keys created before switching policy take some time to adjust, so use a new key
+	key = utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("SET", key, key))
+	result = testDB.Exec(nil, utils.ToCmdLine("OBJECT", "FREQ", key))
+	asserts.AssertIntReply(t, result, database.LFUInitVal)
+
+	// counter grows logarithmically, early hits always increase it
+	for i := 0; i < 1000; i++ {
+		testDB.Exec(nil, utils.ToCmdLine("GET", key))
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("OBJECT", "FREQ", key))
+	asserts.AssertIntReplyGreaterThan(t, result, database.LFUInitVal)
+	freq := result.(*protocol.IntReply).Code
+	if freq >= 255 {
+		t.Errorf("counter should not saturate after 1000 hits, got %d", freq)
+	}
+
+	// overwriting a key retains its access frequency
+	testDB.Exec(nil, utils.ToCmdLine("SET", key, "v2"))
+	result = testDB.Exec(nil, utils.ToCmdLine("OBJECT", "FREQ", key))
+	asserts.AssertIntReplyGreaterThan(t, result, int(freq)-1)
+}
+
+func TestObjectEncoding(t *testing.T) {
+	testDB.Flush()
+	cases := []struct {
+		cmdLine  [][]byte
+		encoding string
+	}{
+		{utils.ToCmdLine("SET", "int", "12345"), "int"},
+		{utils.ToCmdLine("SET", "leading-zero", "012"), "embstr"},
+		{utils.ToCmdLine("SET", "embstr", "hello"), "embstr"},
+		{utils.ToCmdLine("SET", "raw", utils.RandString(45)), "raw"},
+		{utils.ToCmdLine("RPUSH", "list", "a"), "quicklist"},
+		{utils.ToCmdLine("HSET", "hash", "a", "b"), "hashtable"},
+		{utils.ToCmdLine("SADD", "set", "a"), "hashtable"},
+		{utils.ToCmdLine("ZADD", "zset", "1", "a"), "skiplist"},
+	}
+	for _, c := range cases {
+		testDB.Exec(nil, c.cmdLine)
+		key := string(c.cmdLine[1])
+		result := testDB.Exec(nil, utils.ToCmdLine("OBJECT", "ENCODING", key))
+		asserts.AssertBulkReply(t, result, c.encoding)
+		result = testDB.Exec(nil, utils.ToCmdLine("OBJECT", "REFCOUNT", key))
+		asserts.AssertIntReply(t, result, 1)
+	}
+	result := testDB.Exec(nil, utils.ToCmdLine("OBJECT", "ENCODING", "missing"))
+	asserts.AssertNullBulk(t, result)
+	result = testDB.Exec(nil, utils.ToCmdLine("OBJECT", "ENCODING"))
+	asserts.AssertErrReply(t, result, "ERR wrong number of arguments for 'object|encoding' command")
+
+	result = testDB.Exec(nil, utils.ToCmdLine("OBJECT", "HELP"))
+	if prefix := "*" + strconv.Itoa(len(objectHelp)) + "\r\n+OBJECT"; !strings.HasPrefix(string(result.ToBytes()), prefix) {
+		t.Errorf("expected help lines, actually %s", result.ToBytes())
+	}
+}
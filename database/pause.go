@@ -0,0 +1,120 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+// clientPause suspends commands from clients until timeout or CLIENT UNPAUSE, see CLIENT PAUSE.
+// Its zero value is not paused.
+type clientPause struct {
+	mu sync.Mutex
+	// all is true if all commands are paused, otherwise only writes are paused
+	all bool
+	end time.Time
+	// resume is closed when pause ends, paused commands are waiting on it. It is nil if not paused
+	resume chan struct{}
+	timer  *time.Timer
+}
+
+// pause suspends commands for timeout, a pause in progress is extended or changed to ALL mode if needed
+func (p *clientPause) pause(timeout time.Duration, all bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	end := time.Now().Add(timeout)
+	if p.resume == nil {
+		p.resume = make(chan struct{})
+		p.all = all
+		p.end = end
+	} else {
+		p.all = p.all || all
+		if end.After(p.end) {
+			p.end = end
+		}
+		p.timer.Stop()
+	}
+	p.timer = time.AfterFunc(time.Until(p.end), p.expire)
+}
+
+func (p *clientPause) expire() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// the pause may be extended after the timer fired
+	if p.resume != nil && !time.Now().Before(p.end) {
+		p.unpause0()
+	}
+}
+
+func (p *clientPause) unpause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.unpause0()
+}
+
+func (p *clientPause) unpause0() {
+	if p.resume == nil {
+		return
+	}
+	p.timer.Stop()
+	close(p.resume)
+	p.resume = nil
+}
+
+// wait blocks until the command is not paused
+func (p *clientPause) wait(isWrite bool) {
+	for {
+		p.mu.Lock()
+		resume := p.resume
+		paused := resume != nil && (p.all || isWrite)
+		p.mu.Unlock()
+		if !paused {
+			return
+		}
+		<-resume
+	}
+}
+
+// isPausedAsWrite tells whether the command is paused by CLIENT PAUSE WRITE.
+// Like Redis, EXEC is treated as a write if any of the queued commands is a write
+func isPausedAsWrite(c redis.Connection, cmdName string) bool {
+	if cmdName != "exec" || !c.InMultiState() {
+		return isWriteCommand(cmdName)
+	}
+	for _, cmdLine := range c.GetQueuedCmdLine() {
+		if isWriteCommand(string(cmdLine[0])) {
+			return true
+		}
+	}
+	return false
+}
+
+// execClientPause handles CLIENT PAUSE timeout [WRITE|ALL]
+func (server *Server) execClientPause(args [][]byte) redis.Reply {
+	if len(args) != 1 && len(args) != 2 {
+		return protocol.MakeArgNumErrReply("client|pause")
+	}
+	ms, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR timeout is not an integer or out of range")
+	}
+	if ms < 0 {
+		return protocol.MakeErrReply("ERR timeout is negative")
+	}
+	all := true
+	if len(args) == 2 {
+		switch strings.ToLower(string(args[1])) {
+		case "all":
+		case "write":
+			all = false
+		default:
+			return &protocol.SyntaxErrReply{}
+		}
+	}
+	server.pause.pause(time.Duration(ms)*time.Millisecond, all)
+	return protocol.MakeOkReply()
+}
@@ -1,17 +1,20 @@
 package database
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"sync/atomic"
+	"time"
 
 	"github.com/hdt3213/godis/aof"
 	"github.com/hdt3213/godis/config"
 	"github.com/hdt3213/godis/datastruct/dict"
-	List "github.com/hdt3213/godis/datastruct/list"
-	HashSet "github.com/hdt3213/godis/datastruct/set"
-	SortedSet "github.com/hdt3213/godis/datastruct/sortedset"
 	"github.com/hdt3213/godis/interface/database"
+	"github.com/hdt3213/godis/lib/clock"
+	"github.com/hdt3213/godis/lib/logger"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/protocol"
 	"github.com/hdt3213/rdb/core"
 	rdb "github.com/hdt3213/rdb/parser"
 )
@@ -35,64 +38,88 @@ func (server *Server) loadRdbFile() error {
 
 // LoadRDB real implementation of loading rdb file
 func (server *Server) LoadRDB(dec *core.Decoder) error {
-	return dec.Parse(func(o rdb.RedisObject) bool {
-		db := server.mustSelectDB(o.GetDBIndex())
-		var entity *database.DataEntity
-		switch o.GetType() {
-		case rdb.StringType:
-			str := o.(*rdb.StringObject)
-			entity = &database.DataEntity{
-				Data: str.Value,
-			}
-		case rdb.ListType:
-			listObj := o.(*rdb.ListObject)
-			list := List.NewQuickList()
-			for _, v := range listObj.Values {
-				list.Add(v)
-			}
-			entity = &database.DataEntity{
-				Data: list,
-			}
-		case rdb.HashType:
-			hashObj := o.(*rdb.HashObject)
-			hash := dict.MakeSimple()
-			for k, v := range hashObj.Hash {
-				hash.Put(k, v)
-			}
-			entity = &database.DataEntity{
-				Data: hash,
+	now := clock.Now()
+	// aux fields carrying field ttl precede all hashes, keep them until the hash is loaded
+	fieldTTLs := make(map[int]map[string]map[string]time.Time)
+	return dec.WithSpecialOpCode().Parse(func(o rdb.RedisObject) bool {
+		if aux, ok := o.(*rdb.AuxObject); ok {
+			if aux.Key == aof.FunctionLibraryAux {
+				if _, errReply := server.scripts.loadLibrary(aux.Value, true); errReply != nil {
+					logger.Warn("ignore function library: " + errReply.(protocol.ErrorReply).Error())
+					return true
+				}
+				server.AddAof(0, utils.ToCmdLine("FUNCTION", "LOAD", "REPLACE", aux.Value))
+				return true
 			}
-		case rdb.SetType:
-			setObj := o.(*rdb.SetObject)
-			set := HashSet.Make()
-			for _, mem := range setObj.Members {
-				set.Add(string(mem))
+			if aux.Key != aof.HashFieldTTLAux {
+				return true
 			}
-			entity = &database.DataEntity{
-				Data: set,
+			dbIndex, key, expires, err := aof.ParseHashFieldTTLAux(aux.Value)
+			if err != nil {
+				logger.Warn("ignore hash field ttl: " + err.Error())
+				return true
 			}
-		case rdb.ZSetType:
-			zsetObj := o.(*rdb.ZSetObject)
-			zSet := SortedSet.Make()
-			for _, e := range zsetObj.Entries {
-				zSet.Add(e.Member, e.Score)
-			}
-			entity = &database.DataEntity{
-				Data: zSet,
+			if fieldTTLs[dbIndex] == nil {
+				fieldTTLs[dbIndex] = make(map[string]map[string]time.Time)
 			}
+			fieldTTLs[dbIndex][key] = expires
+			return true
+		}
+		if o.GetExpiration() != nil && o.GetExpiration().Before(now) {
+			return true
 		}
+		db := server.mustSelectDB(o.GetDBIndex())
+		entity := aof.RDBObjectToEntity(o)
 		if entity != nil {
+			if val, ok := entity.Data.([]byte); ok {
+				entity.Data = tryEncodeString(val)
+			}
+			if expires, ok := fieldTTLs[o.GetDBIndex()][o.GetKey()]; ok {
+				if !restoreFieldTTL(entity, expires, now) {
+					return true
+				}
+			}
 			db.PutEntity(o.GetKey(), entity)
 			if o.GetExpiration() != nil {
 				db.Expire(o.GetKey(), *o.GetExpiration())
 			}
+			if hash, ok := entity.Data.(*dict.TTLDict); ok {
+				db.scheduleFieldExpire(o.GetKey(), hash)
+			}
 			// add to aof
 			db.addAof(aof.EntityToCmd(o.GetKey(), entity).Args)
+			for _, cmd := range aof.MakeFieldExpireCmds(o.GetKey(), entity) {
+				db.addAof(cmd.Args)
+			}
 		}
 		return true
 	})
 }
 
+// restoreFieldTTL sets ttl of hash fields loaded from rdb, fields expired already are removed.
+// It returns false if all fields are expired
+func restoreFieldTTL(entity *database.DataEntity, expires map[string]time.Time, now time.Time) bool {
+	fields, ok := entity.Data.(dict.Dict)
+	if !ok {
+		return true
+	}
+	hash := dict.MakeTTL(fields)
+	for field, expireAt := range expires {
+		if expireAt.Before(now) {
+			hash.Remove(field)
+			continue
+		}
+		hash.Expire(field, expireAt)
+	}
+	if hash.Len() == 0 {
+		return false
+	}
+	if hash.ExpireCount() > 0 {
+		entity.Data = hash
+	}
+	return true
+}
+
 func NewPersister(db database.DBEngine, filename string, load bool, fsync string) (*aof.Persister, error) {
 	return aof.NewPersister(db, filename, load, fsync, func() database.DBEngine {
 		return MakeAuxiliaryServer()
@@ -107,12 +134,15 @@ func (server *Server) AddAof(dbIndex int, cmdLine CmdLine) {
 
 func (server *Server) bindPersister(persister *aof.Persister) {
 	server.persister = persister
+	persister.SetLatencyHook(server.latency.addSampleIfNeeded)
 	// bind SaveCmdLine
-	for _, db := range server.dbSet {
+	for i, db := range server.dbSet {
+		// addAof is bound to the index rather than the dataset, SWAPDB leaves it in place
+		dbIndex := i
 		singleDB := db.Load().(*DB)
 		singleDB.addAof = func(line CmdLine) {
 			if config.Properties.AppendOnly { // config may be changed during runtime
-				server.persister.SaveCmdLine(singleDB.index, line)
+				server.persister.SaveCmdLine(dbIndex, line)
 			}
 		}
 	}
@@ -129,3 +159,39 @@ func MakeAuxiliaryServer() *Server {
 	}
 	return mdb
 }
+
+// debugReload saves dataset into rdb file and replaces all databases by the ones loaded from it,
+// so that the dataset has gone through a persistence round trip
+func (server *Server) debugReload() error {
+	if !atomic.CompareAndSwapInt32(&server.rdbSaving, 0, 1) {
+		return errors.New("background save already in progress")
+	}
+	defer atomic.StoreInt32(&server.rdbSaving, 0)
+	rdbFilename := config.Properties.RDBFilename
+	if rdbFilename == "" {
+		rdbFilename = "dump.rdb"
+	}
+	// dump memory directly even if aof is enabled, the rdb generated from aof does not reflect memory
+	err := aof.SaveRDB(rdbFilename, server)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt64(&server.lastSaveTime, time.Now().Unix())
+
+	rdbFile, err := os.Open(rdbFilename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	loader := MakeAuxiliaryServer()
+	err = loader.LoadRDB(rdb.NewDecoder(rdbFile))
+	if err != nil {
+		return err
+	}
+	for i, h := range loader.dbSet {
+		server.loadDB(i, h.Load().(*DB))
+	}
+	return nil
+}
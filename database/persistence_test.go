@@ -12,6 +12,7 @@ import (
 	"github.com/hdt3213/godis/config"
 	"github.com/hdt3213/godis/lib/utils"
 	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol"
 	"github.com/hdt3213/godis/redis/protocol/asserts"
 )
 
@@ -84,3 +85,61 @@ func TestServerFsyncEverySec(t *testing.T) {
 	ret = reader.Exec(conn, utils.ToCmdLine("get", "1"))
 	asserts.AssertBulkReply(t, ret, "1")
 }
+
+func TestDebugReload(t *testing.T) {
+	properties := config.Properties
+	defer func() {
+		config.Properties = properties
+	}()
+	config.Properties = &config.ServerProperties{
+		RDBFilename: filepath.Join(t.TempDir(), "dump.rdb"),
+	}
+	server := NewStandaloneServer()
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "str", "str"))
+	server.Exec(conn, utils.ToCmdLine("SET", "ttl", "ttl", "EX", "1000"))
+	server.Exec(conn, utils.ToCmdLine("RPUSH", "list", "1", "2", "3"))
+	server.Exec(conn, utils.ToCmdLine("SADD", "set", "a", "b"))
+	server.Exec(conn, utils.ToCmdLine("HSET", "hash", "f", "v"))
+	server.Exec(conn, utils.ToCmdLine("HMSET", "hashttl", "f1", "v1", "f2", "v2", "f3", "v3"))
+	server.Exec(conn, utils.ToCmdLine("HEXPIRE", "hashttl", "1000", "FIELDS", "1", "f1"))
+	server.Exec(conn, utils.ToCmdLine("HPEXPIRE", "hashttl", "10", "FIELDS", "1", "f3"))
+	server.Exec(conn, utils.ToCmdLine("ZADD", "zset", "1.5", "a", "2", "b"))
+	server.Exec(conn, utils.ToCmdLine("BF.ADD", "bloom", "a"))
+	time.Sleep(50 * time.Millisecond)
+	conn.SelectDB(1)
+	server.Exec(conn, utils.ToCmdLine("SET", "db1", "db1"))
+
+	ret := server.Exec(conn, utils.ToCmdLine("DEBUG", "RELOAD"))
+	asserts.AssertStatusReply(t, ret, "OK")
+
+	ret = server.Exec(conn, utils.ToCmdLine("GET", "db1"))
+	asserts.AssertBulkReply(t, ret, "db1")
+	conn.SelectDB(0)
+	ret = server.Exec(conn, utils.ToCmdLine("GET", "str"))
+	asserts.AssertBulkReply(t, ret, "str")
+	ret = server.Exec(conn, utils.ToCmdLine("TTL", "ttl"))
+	if intReply, ok := ret.(*protocol.IntReply); !ok || intReply.Code <= 0 || intReply.Code > 1000 {
+		t.Errorf("expect ttl kept, got %s", string(ret.ToBytes()))
+	}
+	ret = server.Exec(conn, utils.ToCmdLine("LRANGE", "list", "0", "-1"))
+	asserts.AssertMultiBulkReply(t, ret, []string{"1", "2", "3"})
+	ret = server.Exec(conn, utils.ToCmdLine("SISMEMBER", "set", "b"))
+	asserts.AssertIntReply(t, ret, 1)
+	ret = server.Exec(conn, utils.ToCmdLine("HGET", "hash", "f"))
+	asserts.AssertBulkReply(t, ret, "v")
+	ret = server.Exec(conn, utils.ToCmdLine("HTTL", "hashttl", "FIELDS", "3", "f1", "f2", "f3"))
+	if multi, ok := ret.(*protocol.MultiRawReply); !ok || len(multi.Replies) != 3 ||
+		multi.Replies[0].(*protocol.IntReply).Code <= 0 || multi.Replies[0].(*protocol.IntReply).Code > 1000 ||
+		multi.Replies[1].(*protocol.IntReply).Code != -1 || multi.Replies[2].(*protocol.IntReply).Code != -2 {
+		t.Errorf("expect field ttl kept and expired field dropped, got %q", string(ret.ToBytes()))
+	}
+	ret = server.Exec(conn, utils.ToCmdLine("ZRANGE", "zset", "0", "-1", "WITHSCORES"))
+	asserts.AssertMultiBulkReply(t, ret, []string{"a", "1.5", "b", "2"})
+	ret = server.Exec(conn, utils.ToCmdLine("BF.EXISTS", "bloom", "a"))
+	asserts.AssertIntReply(t, ret, 1)
+
+	ret = server.Exec(conn, utils.ToCmdLine("DEBUG", "NOSUCH"))
+	asserts.AssertErrReply(t, ret, "ERR unknown subcommand 'NOSUCH'. Try DEBUG HELP.")
+}
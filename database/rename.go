@@ -0,0 +1,108 @@
+package database
+
+import (
+	"errors"
+	"strings"
+
+	idatabase "github.com/hdt3213/godis/interface/database"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+// renamedCommands maps names given by rename-command to original names, empty string means the command is disabled.
+// It is shared by the dispatcher and scripts, so that redis.call could not reach commands hidden from clients
+var renamedCommands map[string]string
+
+// translateCommand returns the original name of command called by name, ok is false if the command is disabled
+func translateCommand(name string) (original string, ok bool) {
+	original, renamed := renamedCommands[name]
+	if !renamed {
+		return name, true
+	}
+	return original, original != ""
+}
+
+// translateCmdLine returns a copy of cmdLine calling the original command, cmdLine is shared and never modified in place
+func translateCmdLine(cmdLine [][]byte, original string) [][]byte {
+	translated := make([][]byte, len(cmdLine))
+	copy(translated, cmdLine)
+	translated[0] = []byte(original)
+	return translated
+}
+
+// renamedDB translates names of commands renamed by rename-command before dispatching them,
+// so that commands such as FLUSHALL could be hidden from clients
+type renamedDB struct {
+	idatabase.DB
+}
+
+// WithRenamedCommands wraps db to serve commands under names given by rename-command directives,
+// db is returned as is if there is no directive
+func WithRenamedCommands(db idatabase.DB, directives []string) (idatabase.DB, error) {
+	if len(directives) == 0 {
+		return db, nil
+	}
+	names := make(map[string]string)
+	renames := make(map[string]string)
+	for _, directive := range directives {
+		fields := strings.Fields(directive)
+		if len(fields) != 2 {
+			return nil, errors.New("invalid rename-command directive: " + directive)
+		}
+		original := strings.ToLower(fields[0])
+		newName := strings.ToLower(fields[1])
+		if newName == `""` {
+			newName = ""
+		}
+		renames[original] = newName
+		// original names are no longer available unless other commands are renamed to them
+		names[original] = ""
+	}
+	for original, newName := range renames {
+		if newName == "" {
+			continue
+		}
+		if target, ok := names[newName]; ok && target != "" {
+			return nil, errors.New("rename-command: name " + newName + " is used twice")
+		}
+		names[newName] = original
+	}
+	// directives are applied at startup, before any command is served
+	renamedCommands = names
+	return &renamedDB{
+		DB: db,
+	}, nil
+}
+
+func (db *renamedDB) Exec(c redis.Connection, cmdLine [][]byte) redis.Reply {
+	name := strings.ToLower(string(cmdLine[0]))
+	original, ok := translateCommand(name)
+	if !ok {
+		err := protocol.MakeErrReply("ERR unknown command '" + name + "'")
+		if c != nil && c.InMultiState() {
+			c.AddTxError(err)
+		}
+		return err
+	}
+	if original != name {
+		cmdLine = translateCmdLine(cmdLine, original)
+	}
+	return db.DB.Exec(c, cmdLine)
+}
+
+// Shutdown implements idatabase.Shutdowner if the wrapped db does
+func (db *renamedDB) Shutdown() {
+	if s, ok := db.DB.(idatabase.Shutdowner); ok {
+		s.Shutdown()
+	} else {
+		db.DB.Close()
+	}
+}
+
+// ShutdownChan implements idatabase.Shutdowner if the wrapped db does
+func (db *renamedDB) ShutdownChan() <-chan struct{} {
+	if s, ok := db.DB.(idatabase.Shutdowner); ok {
+		return s.ShutdownChan()
+	}
+	return nil
+}
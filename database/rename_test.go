@@ -0,0 +1,65 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestRenameCommand(t *testing.T) {
+	db, err := WithRenamedCommands(testServer, []string{
+		`FLUSHALL ""`,
+		`INCR ""`,
+		"config b840fc02",
+		"get set",
+		"set get",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		renamedCommands = nil
+	}()
+	c := connection.NewFakeConn()
+	defer testServer.AfterClientClose(c)
+	result := db.Exec(c, utils.ToCmdLine("flushall"))
+	asserts.AssertErrReply(t, result, "ERR unknown command 'flushall'")
+	result = db.Exec(c, utils.ToCmdLine("config", "get", "maxclients"))
+	asserts.AssertErrReply(t, result, "ERR unknown command 'config'")
+	result = db.Exec(c, utils.ToCmdLine("B840FC02", "get", "databases"))
+	asserts.AssertMultiBulkReplySize(t, result, 2)
+
+	// names could be swapped
+	result = db.Exec(c, utils.ToCmdLine("get", "rename-key", "a"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = db.Exec(c, utils.ToCmdLine("set", "rename-key"))
+	asserts.AssertBulkReply(t, result, "a")
+	testServer.Exec(c, utils.ToCmdLine("del", "rename-key"))
+
+	// disabled commands are rejected in transaction
+	db.Exec(c, utils.ToCmdLine("multi"))
+	result = db.Exec(c, utils.ToCmdLine("flushall"))
+	asserts.AssertErrReply(t, result, "ERR unknown command 'flushall'")
+	result = db.Exec(c, utils.ToCmdLine("exec"))
+	asserts.AssertErrReply(t, result, "EXECABORT Transaction discarded because of previous errors.")
+
+	// scripts could not call disabled commands, and call renamed commands by their new names
+	result = db.Exec(c, utils.ToCmdLine("eval", "return redis.call('incr', KEYS[1])", "1", "rename-key"))
+	asserts.AssertErrReply(t, result, "ERR Unknown Redis command called from script")
+	result = testServer.Exec(c, utils.ToCmdLine("exists", "rename-key"))
+	asserts.AssertIntReply(t, result, 0)
+	result = db.Exec(c, utils.ToCmdLine("eval", "return redis.call('get', KEYS[1], 'a')", "1", "rename-key"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = db.Exec(c, utils.ToCmdLine("eval", "return redis.call('set', KEYS[1])", "1", "rename-key"))
+	asserts.AssertBulkReply(t, result, "a")
+	testServer.Exec(c, utils.ToCmdLine("del", "rename-key"))
+
+	if _, err := WithRenamedCommands(testServer, []string{"flushall"}); err == nil {
+		t.Error("expect error")
+	}
+	if _, err := WithRenamedCommands(testServer, []string{"flushall a", "flushdb a"}); err == nil {
+		t.Error("expect error")
+	}
+}
@@ -5,15 +5,16 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/hdt3213/godis/aof"
+	"github.com/hdt3213/godis/config"
 	"github.com/hdt3213/godis/interface/redis"
-	"github.com/hdt3213/godis/lib/logger"
-	"github.com/hdt3213/godis/lib/sync/atomic"
 	"github.com/hdt3213/godis/lib/utils"
 	"github.com/hdt3213/godis/redis/protocol"
 )
@@ -28,7 +29,8 @@ Branch A: rdb is not ready
 4. After the rdb generation is done, `saveForReplication` calls `masterFullReSyncWithSlave` to send rdb to `waitSlaves`
 
 Branch B: rdb is ready
-runs `masterTryPartialSyncWithSlave` if cannot go partial sync, go to `masterFullReSyncWithSlave`
+runs `masterTryPartialSyncWithSlave` if cannot go partial sync, go to `masterFullReSyncWithSlave`.
+If the updates following the rdb have been overwritten in the circular backlog, go to Branch A for a new rdb
 */
 
 const (
@@ -55,35 +57,68 @@ type slaveClient struct {
 	conn         redis.Connection
 	state        uint8
 	offset       int64
+	ackOffset    int64 // offset slave has processed, reported by REPLCONF ACK
 	lastAckTime  time.Time
 	announceIp   string
 	announcePort int
 	capacity     uint8
 }
 
-// aofListener is currently only responsible for updating the backlog
+// replBacklog is a circular buffer holding the latest bytes of replication stream,
+// slaves whose offset is still in the backlog could continue replication without full resync
 type replBacklog struct {
-	buf           []byte
-	beginOffset   int64
+	buf  []byte
+	size int
+	// beginOffset is the replication offset of the oldest byte in backlog
+	beginOffset int64
+	// currentOffset is the replication offset of the next byte to append
 	currentOffset int64
 }
 
-func (backlog *replBacklog) appendBytes(bin []byte) {
-	backlog.buf = append(backlog.buf, bin...)
-	backlog.currentOffset += int64(len(bin))
+const defaultReplBacklogSize = 1024 * 1024 // 1MB
+
+func makeReplBacklog() *replBacklog {
+	size := config.Properties.ReplBacklogSize
+	if size <= 0 {
+		size = defaultReplBacklogSize
+	}
+	return &replBacklog{
+		size: size,
+	}
 }
 
-func (backlog *replBacklog) getSnapshot() ([]byte, int64) {
-	return backlog.buf[:], backlog.currentOffset
+func (backlog *replBacklog) appendBytes(bin []byte) {
+	if backlog.buf == nil {
+		backlog.buf = make([]byte, backlog.size)
+	}
+	backlog.currentOffset += int64(len(bin))
+	if len(bin) > backlog.size {
+		bin = bin[len(bin)-backlog.size:]
+	}
+	pos := int((backlog.currentOffset - int64(len(bin))) % int64(backlog.size))
+	n := copy(backlog.buf[pos:], bin)
+	copy(backlog.buf, bin[n:])
+	if backlog.currentOffset-backlog.beginOffset > int64(backlog.size) {
+		backlog.beginOffset = backlog.currentOffset - int64(backlog.size)
+	}
 }
 
+// getSnapshotAfter returns a copy of bytes from beginOffset to the end of backlog, and the current offset.
+// invoker should make sure beginOffset is valid
 func (backlog *replBacklog) getSnapshotAfter(beginOffset int64) ([]byte, int64) {
-	beg := beginOffset - backlog.beginOffset
-	return backlog.buf[beg:], backlog.currentOffset
+	result := make([]byte, backlog.currentOffset-beginOffset)
+	if len(result) == 0 {
+		return result, backlog.currentOffset
+	}
+	pos := int(beginOffset % int64(backlog.size))
+	n := copy(result, backlog.buf[pos:])
+	copy(result[n:], backlog.buf)
+	return result, backlog.currentOffset
 }
 
+// isValidOffset returns whether the stream after offset is still in backlog
 func (backlog *replBacklog) isValidOffset(offset int64) bool {
-	return offset >= backlog.beginOffset && offset < backlog.currentOffset
+	return offset >= backlog.beginOffset && offset <= backlog.currentOffset
 }
 
 type masterStatus struct {
@@ -95,8 +130,10 @@ type masterStatus struct {
 	onlineSlaves map[*slaveClient]struct{}
 	bgSaveState  uint8
 	rdbFilename  string
-	aofListener  *replAofListener
-	rewriting    atomic.Boolean
+	// rdbOffset is the replication offset at which rdbFilename was taken,
+	// full resync sends rdb and then backlog after rdbOffset
+	rdbOffset   int64
+	aofListener *replAofListener
 }
 
 // bgSaveForReplication does bg-save and send rdb to waiting slaves
@@ -104,111 +141,157 @@ func (server *Server) bgSaveForReplication() {
 	go func() {
 		defer func() {
 			if e := recover(); e != nil {
-				logger.Errorf("panic: %v", e)
+				replLogger.Errorf("panic: %v", e)
 			}
 		}()
 		if err := server.saveForReplication(); err != nil {
-			logger.Errorf("save for replication error: %v", err)
+			replLogger.Errorf("save for replication error: %v", err)
 		}
 	}()
 
 }
 
-// saveForReplication does bg-save and send rdb to waiting slaves
+// saveForReplication does bg-save and send rdb to waiting slaves.
+// It is called at the first full resync, or when the stream following current rdb has been overwritten in backlog
 func (server *Server) saveForReplication() error {
 	rdbFile, err := ioutil.TempFile("", "*.rdb")
 	if err != nil {
+		server.abortWaitSlaves()
 		return fmt.Errorf("create temp rdb failed: %v", err)
 	}
 	rdbFilename := rdbFile.Name()
+	_ = rdbFile.Close()
+	var aofListener *replAofListener
 	server.masterStatus.mu.Lock()
 	server.masterStatus.bgSaveState = bgSaveRunning
-	server.masterStatus.rdbFilename = rdbFilename // todo: can reuse config.Properties.RDBFilename?
-	aofListener := &replAofListener{
-		mdb:     server,
-		backlog: server.masterStatus.backlog,
+	if server.masterStatus.aofListener == nil {
+		// the listener keeps appending updates to backlog until master stopped
+		aofListener = &replAofListener{
+			mdb: server,
+		}
+		server.masterStatus.aofListener = aofListener
 	}
-	server.masterStatus.aofListener = aofListener
 	server.masterStatus.mu.Unlock()
 
-	err = server.persister.GenerateRDBForReplication(rdbFilename, aofListener, nil)
+	var rdbOffset int64
+	hook := func() {
+		// pausing aof first, then lock masterStatus.
+		// use the same order as replAofListener to avoid dead lock
+		server.masterStatus.mu.RLock()
+		defer server.masterStatus.mu.RUnlock()
+		rdbOffset = server.masterStatus.backlog.currentOffset
+	}
+	// Passing a nil listener if it has been registered
+	var listener aof.Listener
+	if aofListener != nil {
+		listener = aofListener
+	}
+	err = server.persister.GenerateRDBForReplication(rdbFilename, listener, hook)
 	if err != nil {
+		_ = os.Remove(rdbFilename)
+		server.abortWaitSlaves()
 		return err
 	}
-	aofListener.readyToSend = true
+	if aofListener != nil {
+		aofListener.readyToSend = true
+	}
 
 	// change bgSaveState and get waitSlaves for sending
 	waitSlaves := make(map[*slaveClient]struct{})
 	server.masterStatus.mu.Lock()
+	oldRdbFilename := server.masterStatus.rdbFilename
+	server.masterStatus.rdbFilename = rdbFilename
+	server.masterStatus.rdbOffset = rdbOffset
 	server.masterStatus.bgSaveState = bgSaveFinish
 	for slave := range server.masterStatus.waitSlaves {
 		waitSlaves[slave] = struct{}{}
 	}
-	server.masterStatus.waitSlaves = nil
+	server.masterStatus.waitSlaves = make(map[*slaveClient]struct{})
 	server.masterStatus.mu.Unlock()
+	if oldRdbFilename != "" {
+		// slaves in the middle of sending have opened the file, so it is safe to remove
+		_ = os.Remove(oldRdbFilename)
+	}
 
 	// send rdb to waiting slaves
 	for slave := range waitSlaves {
-		err = server.masterFullReSyncWithSlave(slave)
-		if err != nil {
-			server.removeSlave(slave)
-			logger.Errorf("masterFullReSyncWithSlave error: %v", err)
-			continue
-		}
+		server.fullReSyncWithSlave(slave)
 	}
 	return nil
 }
 
-func (server *Server) rewriteRDB() error {
-	rdbFile, err := ioutil.TempFile("", "*.rdb")
-	if err != nil {
-		return fmt.Errorf("create temp rdb failed: %v", err)
+// abortWaitSlaves disconnects slaves waiting for rdb after bg-save failed, they will retry later
+func (server *Server) abortWaitSlaves() {
+	server.masterStatus.mu.Lock()
+	if server.masterStatus.bgSaveState == bgSaveRunning {
+		if server.masterStatus.rdbFilename != "" {
+			server.masterStatus.bgSaveState = bgSaveFinish
+		} else {
+			server.masterStatus.bgSaveState = bgSaveIdle
+		}
 	}
-	rdbFilename := rdbFile.Name()
-	newBacklog := &replBacklog{}
-	aofListener := &replAofListener{
-		backlog: newBacklog,
-		mdb:     server,
+	waitSlaves := server.masterStatus.waitSlaves
+	server.masterStatus.waitSlaves = make(map[*slaveClient]struct{})
+	server.masterStatus.mu.Unlock()
+	for slave := range waitSlaves {
+		server.removeSlave(slave)
 	}
-	hook := func() {
-		// pausing aof first, then lock masterStatus.
-		// use the same order as replAofListener to avoid dead lock
+}
+
+var errRdbOutOfBacklog = errors.New("stream after rdb is out of backlog")
+
+// fullReSyncWithSlave sends current rdb to slave, or makes it wait for a new rdb if current one is outdated
+func (server *Server) fullReSyncWithSlave(slave *slaveClient) {
+	err := server.masterFullReSyncWithSlave(slave)
+	if err == errRdbOutOfBacklog {
 		server.masterStatus.mu.Lock()
-		defer server.masterStatus.mu.Unlock()
-		newBacklog.beginOffset = server.masterStatus.backlog.currentOffset
+		slave.state = slaveStateWaitSaveEnd
+		server.masterStatus.waitSlaves[slave] = struct{}{}
+		if server.masterStatus.bgSaveState != bgSaveRunning {
+			server.masterStatus.bgSaveState = bgSaveRunning
+			server.bgSaveForReplication()
+		}
+		server.masterStatus.mu.Unlock()
+		return
 	}
-	err = server.persister.GenerateRDBForReplication(rdbFilename, aofListener, hook)
-	if err != nil { // wait rdb result
-		return err
+	if err != nil {
+		server.removeSlave(slave)
+		replLogger.Errorf("masterFullReSyncWithSlave error: %v", err)
 	}
-	server.masterStatus.mu.Lock()
-	server.masterStatus.rdbFilename = rdbFilename
-	server.masterStatus.backlog = newBacklog
-	server.persister.RemoveListener(server.masterStatus.aofListener)
-	server.masterStatus.aofListener = aofListener
-	server.masterStatus.mu.Unlock()
-	// It is ok to know that new backlog is ready later, so we change readyToSend without sync
-	// But setting readyToSend=true must after new backlog is really ready (that means master.mu.Unlock)
-	aofListener.readyToSend = true
-	return nil
 }
 
 // masterFullReSyncWithSlave send replication header, rdb file and all backlogs to slave
 func (server *Server) masterFullReSyncWithSlave(slave *slaveClient) error {
+	server.masterStatus.mu.RLock()
+	replId := server.masterStatus.replId
+	rdbFilename := server.masterStatus.rdbFilename
+	rdbOffset := server.masterStatus.rdbOffset
+	if !server.masterStatus.backlog.isValidOffset(rdbOffset) {
+		server.masterStatus.mu.RUnlock()
+		return errRdbOutOfBacklog
+	}
+	// open rdb file before it could be replaced
+	rdbFile, err := os.Open(rdbFilename)
+	server.masterStatus.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("open rdb file %s for replication error: %v", rdbFilename, err)
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+
 	// write replication header
-	header := "+FULLRESYNC " + server.masterStatus.replId + " " +
-		strconv.FormatInt(server.masterStatus.backlog.beginOffset, 10) + protocol.CRLF
-	_, err := slave.conn.Write([]byte(header))
+	header := "+FULLRESYNC " + replId + " " + strconv.FormatInt(rdbOffset, 10) + protocol.CRLF
+	_, err = slave.conn.Write([]byte(header))
 	if err != nil {
 		return fmt.Errorf("write replication header to slave failed: %v", err)
 	}
 	// send rdb
-	rdbFile, err := os.Open(server.masterStatus.rdbFilename)
+	slave.state = slaveStateSendingRDB
+	rdbInfo, err := rdbFile.Stat()
 	if err != nil {
-		return fmt.Errorf("open rdb file %s for replication error: %v", server.masterStatus.rdbFilename, err)
+		return fmt.Errorf("stat rdb file %s for replication error: %v", rdbFilename, err)
 	}
-	slave.state = slaveStateSendingRDB
-	rdbInfo, _ := os.Stat(server.masterStatus.rdbFilename)
 	rdbSize := rdbInfo.Size()
 	rdbHeader := "$" + strconv.FormatInt(rdbSize, 10) + protocol.CRLF
 	_, err = slave.conn.Write([]byte(rdbHeader))
@@ -222,7 +305,12 @@ func (server *Server) masterFullReSyncWithSlave(slave *slaveClient) error {
 
 	// send backlog
 	server.masterStatus.mu.RLock()
-	backlog, currentOffset := server.masterStatus.backlog.getSnapshot()
+	if !server.masterStatus.backlog.isValidOffset(rdbOffset) {
+		// too many updates during sending rdb, slave has to start over
+		server.masterStatus.mu.RUnlock()
+		return fmt.Errorf("full resync failed: %v", errRdbOutOfBacklog)
+	}
+	backlog, currentOffset := server.masterStatus.backlog.getSnapshotAfter(rdbOffset)
 	server.masterStatus.mu.RUnlock()
 	_, err = slave.conn.Write(backlog)
 	if err != nil {
@@ -268,20 +356,32 @@ func (server *Server) masterTryPartialSyncWithSlave(slave *slaveClient, replId s
 
 // masterSendUpdatesToSlave only sends data to online slaves after bgSave is finished
 // if bgSave is running, updates will be sent after the saving finished
+// slaves fall behind the backlog will be disconnected, and they could reconnect with a full resync
 func (server *Server) masterSendUpdatesToSlave() error {
-	onlineSlaves := make(map[*slaveClient]struct{})
+	updates := make(map[*slaveClient][]byte)
+	var lagging []*slaveClient
 	server.masterStatus.mu.RLock()
-	beginOffset := server.masterStatus.backlog.beginOffset
-	backlog, currentOffset := server.masterStatus.backlog.getSnapshot()
+	backlog := server.masterStatus.backlog
+	currentOffset := backlog.currentOffset
 	for slave := range server.masterStatus.onlineSlaves {
-		onlineSlaves[slave] = struct{}{}
+		if slave.offset == currentOffset {
+			continue
+		}
+		if !backlog.isValidOffset(slave.offset) {
+			lagging = append(lagging, slave)
+			continue
+		}
+		updates[slave], _ = backlog.getSnapshotAfter(slave.offset)
 	}
 	server.masterStatus.mu.RUnlock()
-	for slave := range onlineSlaves {
-		slaveBeginOffset := slave.offset - beginOffset
-		_, err := slave.conn.Write(backlog[slaveBeginOffset:])
+	for _, slave := range lagging {
+		replLogger.Errorf("slave %s is out of backlog at offset %d", slave.conn.RemoteAddr(), slave.offset)
+		server.removeSlave(slave)
+	}
+	for slave, data := range updates {
+		_, err := slave.conn.Write(data)
 		if err != nil {
-			logger.Errorf("send updates backlog to slave failed: %v", err)
+			replLogger.Errorf("send updates backlog to slave failed: %v", err)
 			server.removeSlave(slave)
 			continue
 		}
@@ -291,6 +391,13 @@ func (server *Server) masterSendUpdatesToSlave() error {
 }
 
 func (server *Server) execPSync(c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return protocol.MakeArgNumErrReply("psync")
+	}
+	if server.persister == nil {
+		// updates are propagated to slaves through the aof listener
+		return protocol.MakeErrReply("ERR replication requires appendonly to be enabled on master")
+	}
 	replId := string(args[0])
 	replOffset, err := strconv.ParseInt(string(args[1]), 10, 64)
 	if err != nil {
@@ -298,14 +405,7 @@ func (server *Server) execPSync(c redis.Connection, args [][]byte) redis.Reply {
 	}
 	server.masterStatus.mu.Lock()
 	defer server.masterStatus.mu.Unlock()
-	slave := server.masterStatus.slaveMap[c]
-	if slave == nil {
-		slave = &slaveClient{
-			conn: c,
-		}
-		c.SetSlave()
-		server.masterStatus.slaveMap[c] = slave
-	}
+	slave := server.getOrCreateSlaveWithLock(c)
 	if server.masterStatus.bgSaveState == bgSaveIdle {
 		slave.state = slaveStateWaitSaveEnd
 		server.masterStatus.waitSlaves[slave] = struct{}{}
@@ -317,7 +417,7 @@ func (server *Server) execPSync(c redis.Connection, args [][]byte) redis.Reply {
 		go func() {
 			defer func() {
 				if e := recover(); e != nil {
-					logger.Errorf("panic: %v", e)
+					replLogger.Errorf("panic: %v", e)
 				}
 			}()
 			err := server.masterTryPartialSyncWithSlave(slave, replId, replOffset)
@@ -326,15 +426,11 @@ func (server *Server) execPSync(c redis.Connection, args [][]byte) redis.Reply {
 			}
 			if err != cannotPartialSync {
 				server.removeSlave(slave)
-				logger.Errorf("masterTryPartialSyncWithSlave error: %v", err)
+				replLogger.Errorf("masterTryPartialSyncWithSlave error: %v", err)
 				return
 			}
 			// assert err == cannotPartialSync
-			if err := server.masterFullReSyncWithSlave(slave); err != nil {
-				server.removeSlave(slave)
-				logger.Errorf("masterFullReSyncWithSlave error: %v", err)
-				return
-			}
+			server.fullReSyncWithSlave(slave)
 		}()
 	}
 	return &protocol.NoReply{}
@@ -344,9 +440,6 @@ func (server *Server) execReplConf(c redis.Connection, args [][]byte) redis.Repl
 	if len(args)%2 != 0 {
 		return protocol.MakeSyntaxErrReply()
 	}
-	server.masterStatus.mu.RLock()
-	slave := server.masterStatus.slaveMap[c]
-	server.masterStatus.mu.RUnlock()
 	for i := 0; i < len(args); i += 2 {
 		key := strings.ToLower(string(args[i]))
 		value := string(args[i+1])
@@ -356,14 +449,56 @@ func (server *Server) execReplConf(c redis.Connection, args [][]byte) redis.Repl
 			if err != nil {
 				return protocol.MakeErrReply("ERR value is not an integer or out of range")
 			}
-			slave.offset = offset
-			slave.lastAckTime = time.Now()
+			server.masterStatus.mu.Lock()
+			if slave := server.masterStatus.slaveMap[c]; slave != nil {
+				// slave.offset is the offset sent to slave, do not resend data between acked offset and it
+				slave.ackOffset = offset
+				slave.lastAckTime = time.Now()
+			}
+			server.masterStatus.mu.Unlock()
 			return &protocol.NoReply{}
+		case "getack":
+			// master requests ack within replication stream, slave answers it in receiveAOF
+			return &protocol.NoReply{}
+		case "listening-port":
+			port, err := strconv.Atoi(value)
+			if err != nil {
+				return protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			server.masterStatus.mu.Lock()
+			server.getOrCreateSlaveWithLock(c).announcePort = port
+			server.masterStatus.mu.Unlock()
+		case "ip-address":
+			server.masterStatus.mu.Lock()
+			server.getOrCreateSlaveWithLock(c).announceIp = value
+			server.masterStatus.mu.Unlock()
 		}
 	}
 	return protocol.MakeOkReply()
 }
 
+// getOrCreateSlaveWithLock returns the slaveClient of connection, invoker should hold masterStatus.mu
+func (server *Server) getOrCreateSlaveWithLock(c redis.Connection) *slaveClient {
+	slave := server.masterStatus.slaveMap[c]
+	if slave == nil {
+		slave = &slaveClient{
+			conn: c,
+		}
+		c.SetSlave()
+		server.masterStatus.slaveMap[c] = slave
+	}
+	return slave
+}
+
+// addr returns the address slave is listening on, which is announced by REPLCONF
+func (slave *slaveClient) addr() (string, int) {
+	host := slave.announceIp
+	if host == "" {
+		host, _, _ = net.SplitHostPort(slave.conn.RemoteAddr())
+	}
+	return host, slave.announcePort
+}
+
 func (server *Server) removeSlave(slave *slaveClient) {
 	server.masterStatus.mu.Lock()
 	defer server.masterStatus.mu.Unlock()
@@ -371,7 +506,7 @@ func (server *Server) removeSlave(slave *slaveClient) {
 	delete(server.masterStatus.slaveMap, slave.conn)
 	delete(server.masterStatus.waitSlaves, slave)
 	delete(server.masterStatus.onlineSlaves, slave)
-	logger.Info("disconnect with slave " + slave.conn.Name())
+	replLogger.Info("disconnect with slave " + slave.conn.Name())
 }
 
 func (server *Server) setSlaveOnline(slave *slaveClient, currentOffset int64) {
@@ -384,8 +519,6 @@ func (server *Server) setSlaveOnline(slave *slaveClient, currentOffset int64) {
 
 var pingBytes = protocol.MakeMultiBulkReply(utils.ToCmdLine("ping")).ToBytes()
 
-const maxBacklogSize = 10 * 1024 * 1024 // 10MB
-
 func (server *Server) masterCron() {
 	server.masterStatus.mu.Lock()
 	if len(server.masterStatus.slaveMap) == 0 { // no slaves, do nothing
@@ -395,27 +528,15 @@ func (server *Server) masterCron() {
 	if server.masterStatus.bgSaveState == bgSaveFinish {
 		server.masterStatus.backlog.appendBytes(pingBytes)
 	}
-	backlogSize := len(server.masterStatus.backlog.buf)
 	server.masterStatus.mu.Unlock()
 	if err := server.masterSendUpdatesToSlave(); err != nil {
-		logger.Errorf("masterSendUpdatesToSlave error: %v", err)
-	}
-	if backlogSize > maxBacklogSize && !server.masterStatus.rewriting.Get() {
-		go func() {
-			server.masterStatus.rewriting.Set(true)
-			defer server.masterStatus.rewriting.Set(false)
-			if err := server.rewriteRDB(); err != nil {
-				server.masterStatus.rewriting.Set(false)
-				logger.Errorf("rewrite error: %v", err)
-			}
-		}()
+		replLogger.Errorf("masterSendUpdatesToSlave error: %v", err)
 	}
 }
 
 // replAofListener is an implementation for aof.Listener
 type replAofListener struct {
 	mdb         *Server
-	backlog     *replBacklog // may NOT be mdb.masterStatus.backlog
 	readyToSend bool
 }
 
@@ -423,14 +544,14 @@ func (listener *replAofListener) Callback(cmdLines []CmdLine) {
 	listener.mdb.masterStatus.mu.Lock()
 	for _, cmdLine := range cmdLines {
 		reply := protocol.MakeMultiBulkReply(cmdLine)
-		listener.backlog.appendBytes(reply.ToBytes())
+		listener.mdb.masterStatus.backlog.appendBytes(reply.ToBytes())
 	}
 	listener.mdb.masterStatus.mu.Unlock()
 	// listener could receive updates generated during rdb saving in progress
 	// Do not send updates to slave before rdb saving is finished
 	if listener.readyToSend {
 		if err := listener.mdb.masterSendUpdatesToSlave(); err != nil {
-			logger.Errorf("masterSendUpdatesToSlave after receive aof error: %v", err)
+			replLogger.Errorf("masterSendUpdatesToSlave after receive aof error: %v", err)
 		}
 	}
 }
@@ -438,14 +559,15 @@ func (listener *replAofListener) Callback(cmdLines []CmdLine) {
 func (server *Server) initMasterStatus() {
 	server.masterStatus = &masterStatus{
 		mu:           sync.RWMutex{},
-		replId:       utils.RandHexString(40),
-		backlog:      &replBacklog{},
+		replId:       utils.RandSecureHexString(40),
+		backlog:      makeReplBacklog(),
 		slaveMap:     make(map[redis.Connection]*slaveClient),
 		waitSlaves:   make(map[*slaveClient]struct{}),
 		onlineSlaves: make(map[*slaveClient]struct{}),
 		bgSaveState:  bgSaveIdle,
 		rdbFilename:  "",
 	}
+	server.failover = &failoverStatus{}
 }
 
 func (server *Server) stopMaster() {
@@ -461,13 +583,15 @@ func (server *Server) stopMaster() {
 	}
 
 	// clean master status
-	if server.persister != nil {
+	if server.persister != nil && server.masterStatus.aofListener != nil {
 		server.persister.RemoveListener(server.masterStatus.aofListener)
 	}
+	server.masterStatus.aofListener = nil
 	_ = os.Remove(server.masterStatus.rdbFilename)
 	server.masterStatus.rdbFilename = ""
+	server.masterStatus.rdbOffset = 0
 	server.masterStatus.replId = ""
-	server.masterStatus.backlog = &replBacklog{}
+	server.masterStatus.backlog = makeReplBacklog()
 	server.masterStatus.slaveMap = make(map[redis.Connection]*slaveClient)
 	server.masterStatus.waitSlaves = make(map[*slaveClient]struct{})
 	server.masterStatus.onlineSlaves = make(map[*slaveClient]struct{})
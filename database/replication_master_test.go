@@ -22,11 +22,14 @@ import (
 )
 
 func mockServer() *Server {
-	server := &Server{}
+	server := &Server{
+		done: make(chan struct{}),
+	}
 	server.dbSet = make([]*atomic.Value, 16)
 	for i := range server.dbSet {
 		singleDB := makeDB()
 		singleDB.index = i
+		singleDB.isReplica = server.isReplica
 		holder := &atomic.Value{}
 		holder.Store(singleDB)
 		server.dbSet[i] = holder
@@ -199,7 +202,7 @@ func TestReplicationMasterSide(t *testing.T) {
 	asserts.AssertBulkReply(t, resp, "c")
 }
 
-func TestReplicationMasterRewriteRDB(t *testing.T) {
+func TestReplicationBacklogOverflow(t *testing.T) {
 	tmpDir, err := ioutil.TempDir("", "godis")
 	if err != nil {
 		t.Error(err)
@@ -210,10 +213,11 @@ func TestReplicationMasterRewriteRDB(t *testing.T) {
 		_ = os.Remove(aofFilename)
 	}()
 	config.Properties = &config.ServerProperties{
-		Databases:      16,
-		AppendOnly:     true,
-		AppendFilename: aofFilename,
-		AppendFsync:    aof.FsyncAlways,
+		Databases:       16,
+		AppendOnly:      true,
+		AppendFilename:  aofFilename,
+		AppendFsync:     aof.FsyncAlways,
+		ReplBacklogSize: 256,
 	}
 	master := mockServer()
 	aofHandler, err := NewPersister(master, config.Properties.AppendFilename, true, config.Properties.AppendFsync)
@@ -225,21 +229,9 @@ func TestReplicationMasterRewriteRDB(t *testing.T) {
 	masterConn := connection.NewFakeConn()
 	resp := master.Exec(masterConn, utils.ToCmdLine("SET", "a", "a"))
 	asserts.AssertNotError(t, resp)
-	resp = master.Exec(masterConn, utils.ToCmdLine("SET", "b", "b"))
-	asserts.AssertNotError(t, resp)
 	time.Sleep(time.Millisecond * 100) // wait write aof
 
-	err = master.rewriteRDB()
-	if err != nil {
-		t.Error(err)
-		return
-	}
-	resp = master.Exec(masterConn, utils.ToCmdLine("SET", "c", "c"))
-	asserts.AssertNotError(t, resp)
-	time.Sleep(time.Millisecond * 100) // wait write aof
-
-	// set slave
-	slave := mockServer()
+	// full re-sync
 	replConn := connection.NewFakeConn()
 	master.Exec(replConn, utils.ToCmdLine("psync", "?", "-1"))
 	masterChan := parser.ParseStream(replConn)
@@ -248,68 +240,100 @@ func TestReplicationMasterRewriteRDB(t *testing.T) {
 		t.Errorf("master bad protocol: %v", psyncPayload.Err)
 		return
 	}
-	psyncHeader, ok := psyncPayload.Data.(*protocol.StatusReply)
-	if !ok {
-		t.Error("psync header is not a status reply")
+	headers := strings.Split(psyncPayload.Data.(*protocol.StatusReply).Status, " ")
+	if len(headers) != 3 || headers[0] != "FULLRESYNC" {
+		t.Errorf("illegal psync header: %v", headers)
 		return
 	}
-	headers := strings.Split(psyncHeader.Status, " ")
-	if len(headers) != 3 {
-		t.Errorf("illegal psync header: %s", psyncHeader.Status)
-		return
-	}
-
 	replId := headers[1]
 	replOffset, err := strconv.ParseInt(headers[2], 10, 64)
 	if err != nil {
 		t.Errorf("illegal offset: %s", headers[2])
 		return
 	}
-	t.Logf("repl id: %s, offset: %d", replId, replOffset)
+	// skip rdb
+	<-masterChan
+	_ = replConn.Close() // mock disconnect
 
-	rdbPayload := <-masterChan
-	if rdbPayload.Err != nil {
-		t.Error("read response failed: " + rdbPayload.Err.Error())
+	// overwrite the stream after slave offset in backlog
+	for i := 0; i < 20; i++ {
+		resp = master.Exec(masterConn, utils.ToCmdLine("SET", "key"+strconv.Itoa(i), "value"))
+		asserts.AssertNotError(t, resp)
+	}
+	time.Sleep(time.Millisecond * 100) // wait write aof
+
+	// slave cannot continue, and the old rdb is out of backlog too, so master generates a new one
+	replConn = connection.NewFakeConn()
+	master.Exec(replConn, utils.ToCmdLine("psync", replId, strconv.FormatInt(replOffset, 10)))
+	masterChan = parser.ParseStream(replConn)
+	psyncPayload = <-masterChan
+	if psyncPayload.Err != nil {
+		t.Errorf("master bad protocol: %v", psyncPayload.Err)
 		return
 	}
+	headers = strings.Split(psyncPayload.Data.(*protocol.StatusReply).Status, " ")
+	if len(headers) != 3 || headers[0] != "FULLRESYNC" {
+		t.Errorf("expect FULLRESYNC, actual: %v", headers)
+		return
+	}
+	newOffset, err := strconv.ParseInt(headers[2], 10, 64)
+	if err != nil {
+		t.Errorf("illegal offset: %s", headers[2])
+		return
+	}
+	if newOffset <= replOffset+256 {
+		t.Errorf("expect a new rdb after offset %d, actual %d", replOffset+256, newOffset)
+		return
+	}
+	rdbPayload := <-masterChan
 	rdbReply, ok := rdbPayload.Data.(*protocol.BulkReply)
 	if !ok {
 		t.Error("illegal payload header: " + string(rdbPayload.Data.ToBytes()))
 		return
 	}
-
-	rdbDec := rdb.NewDecoder(bytes.NewReader(rdbReply.Arg))
-	err = slave.LoadRDB(rdbDec)
+	slave := mockServer()
+	err = slave.LoadRDB(rdb.NewDecoder(bytes.NewReader(rdbReply.Arg)))
 	if err != nil {
 		t.Error("import rdb failed: " + err.Error())
 		return
 	}
-
 	slaveConn := connection.NewFakeConn()
 	resp = slave.Exec(slaveConn, utils.ToCmdLine("get", "a"))
 	asserts.AssertBulkReply(t, resp, "a")
-	resp = slave.Exec(slaveConn, utils.ToCmdLine("get", "b"))
-	asserts.AssertBulkReply(t, resp, "b")
+	resp = slave.Exec(slaveConn, utils.ToCmdLine("get", "key19"))
+	asserts.AssertBulkReply(t, resp, "value")
 
-	master.masterCron()
-	for {
-		payload := <-masterChan
-		if payload.Err != nil {
-			t.Error(payload.Err)
-			return
-		}
-		cmdLine, ok := payload.Data.(*protocol.MultiBulkReply)
-		if !ok {
-			t.Error("unexpected payload: " + string(payload.Data.ToBytes()))
-			return
-		}
-		slave.Exec(replConn, cmdLine.Args)
-		n := len(cmdLine.ToBytes())
-		slave.slaveStatus.replOffset += int64(n)
-		if string(cmdLine.Args[0]) != "ping" {
-			break
-		}
+	resp = master.Exec(masterConn, utils.ToCmdLine("info", "replication"))
+	info := string(resp.(*protocol.BulkReply).Arg)
+	if !strings.Contains(info, "repl_backlog_size:256\r\n") || !strings.Contains(info, "repl_backlog_histlen:256\r\n") {
+		t.Errorf("unexpected replication info: %s", info)
+	}
+}
+
+func TestReplBacklog(t *testing.T) {
+	backlog := &replBacklog{size: 8}
+	backlog.appendBytes([]byte("abcde"))
+	data, offset := backlog.getSnapshotAfter(1)
+	if string(data) != "bcde" || offset != 5 {
+		t.Errorf("unexpected snapshot %s at %d", data, offset)
+	}
+	// wrap around
+	backlog.appendBytes([]byte("fghij"))
+	if backlog.isValidOffset(1) || !backlog.isValidOffset(2) || !backlog.isValidOffset(10) {
+		t.Errorf("unexpected begin offset %d", backlog.beginOffset)
+	}
+	data, offset = backlog.getSnapshotAfter(2)
+	if string(data) != "cdefghij" || offset != 10 {
+		t.Errorf("unexpected snapshot %s at %d", data, offset)
+	}
+	// larger than backlog
+	backlog.appendBytes([]byte("0123456789"))
+	data, _ = backlog.getSnapshotAfter(backlog.beginOffset)
+	if string(data) != "23456789" || backlog.beginOffset != 12 {
+		t.Errorf("unexpected snapshot %s at %d", data, backlog.beginOffset)
+	}
+	data, _ = backlog.getSnapshotAfter(backlog.currentOffset)
+	if len(data) != 0 {
+		t.Errorf("expect empty snapshot")
 	}
-	resp = slave.Exec(slaveConn, utils.ToCmdLine("get", "c"))
-	asserts.AssertBulkReply(t, resp, "c")
 }
@@ -20,6 +20,7 @@ import (
 	"github.com/hdt3213/godis/lib/logger"
 	rdb "github.com/hdt3213/rdb/parser"
 	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/client"
 	"github.com/hdt3213/godis/redis/connection"
 	"github.com/hdt3213/godis/redis/parser"
 	"github.com/hdt3213/godis/redis/protocol"
@@ -51,8 +52,13 @@ type slaveStatus struct {
 	running      sync.WaitGroup
 }
 
+// replLogger tags logs of both master and slave side of replication
+var replLogger = logger.Named("replication")
+
 var configChangedErr = errors.New("slaveStatus config changed")
 
+var errMasterClosed = errors.New("master closed connection")
+
 func initReplSlaveStatus() *slaveStatus {
 	return &slaveStatus{}
 }
@@ -68,14 +74,19 @@ func (server *Server) execSlaveOf(c redis.Connection, args [][]byte) redis.Reply
 	if err != nil {
 		return protocol.MakeErrReply("ERR value is not an integer or out of range")
 	}
+	server.slaveOf(host, port)
+	return protocol.MakeOkReply()
+}
+
+// slaveOf makes server a slave of the given master, and starts replication in background
+func (server *Server) slaveOf(host string, port int) {
 	server.slaveStatus.mutex.Lock()
 	atomic.StoreInt32(&server.role, slaveRole)
 	server.slaveStatus.masterHost = host
 	server.slaveStatus.masterPort = port
-	atomic.AddInt32(&server.slaveStatus.configVersion, 1)
+	configVersion := atomic.AddInt32(&server.slaveStatus.configVersion, 1)
 	server.slaveStatus.mutex.Unlock()
-	go server.setupMaster()
-	return protocol.MakeOkReply()
+	go server.setupMaster(configVersion)
 }
 
 func (server *Server) slaveOfNone() {
@@ -86,7 +97,17 @@ func (server *Server) slaveOfNone() {
 	server.slaveStatus.replId = ""
 	server.slaveStatus.replOffset = -1
 	server.slaveStatus.stopSlaveWithMutex()
-	server.role = masterRole
+	atomic.StoreInt32(&server.role, masterRole)
+	// expire tasks are ignored by replica, schedule them again
+	for i := range server.dbSet {
+		server.mustSelectDB(i).rescheduleExpireTasks()
+		server.mustSelectDB(i).rescheduleFieldExpireTasks()
+	}
+}
+
+// isReplica reports whether server is a slave, replica does not expire keys by itself
+func (server *Server) isReplica() bool {
+	return atomic.LoadInt32(&server.role) == slaveRole
 }
 
 // stopSlaveWithMutex stops in-progress connectWithMaster/fullSync/receiveAOF
@@ -115,93 +136,133 @@ func (repl *slaveStatus) close() error {
 	return nil
 }
 
-// setupMaster connects to master and starts full sync
-func (server *Server) setupMaster() {
+// setupMaster connects to master and starts full sync,
+// configVersion is captured by invoker while holding slaveStatus mutex, the procedure stops once it changes
+func (server *Server) setupMaster(configVersion int32) {
 	defer func() {
 		if err := recover(); err != nil {
-			logger.Error(err)
+			replLogger.Error(err)
 		}
 	}()
-	var configVersion int32
 	ctx, cancel := context.WithCancel(context.Background())
 	server.slaveStatus.mutex.Lock()
+	if server.slaveStatus.configVersion != configVersion || server.closedWithMutex() {
+		server.slaveStatus.mutex.Unlock()
+		cancel()
+		return
+	}
 	server.slaveStatus.ctx = ctx
 	server.slaveStatus.cancel = cancel
-	configVersion = server.slaveStatus.configVersion
 	server.slaveStatus.mutex.Unlock()
 	isFullReSync, err := server.connectWithMaster(configVersion)
 	if err != nil {
-		// connect failed, abort master
-		logger.Error(err)
-		server.slaveOfNone()
+		// connect failed, try again later
+		replLogger.Error(err)
+		server.retrySetupMaster(configVersion)
 		return
 	}
 	if isFullReSync {
 		err = server.loadMasterRDB(configVersion)
 		if err != nil {
-			// load failed, abort master
-			logger.Error(err)
-			server.slaveOfNone()
+			// load failed, try again later
+			replLogger.Error(err)
+			server.retrySetupMaster(configVersion)
 			return
 		}
 	}
 	err = server.receiveAOF(ctx, configVersion)
 	if err != nil {
-		// full sync failed, abort
-		logger.Error(err)
+		// lost connection with master, try again later
+		replLogger.Error(err)
+		server.retrySetupMaster(configVersion)
 		return
 	}
 }
 
+// replRetryInterval is the delay before reconnecting with master after a failed attempt
+var replRetryInterval = time.Second
+
+// retrySetupMaster reconnects with master after replRetryInterval,
+// unless slaveStatus config has changed since the failed attempt (e.g. `slaveof no one`) or server has been closed
+func (server *Server) retrySetupMaster(configVersion int32) {
+	time.AfterFunc(replRetryInterval, func() {
+		server.slaveStatus.mutex.Lock()
+		if server.slaveStatus.configVersion != configVersion ||
+			atomic.LoadInt32(&server.role) != slaveRole ||
+			server.closedWithMutex() {
+			server.slaveStatus.mutex.Unlock()
+			return
+		}
+		replLogger.Info("reconnecting with master")
+		server.slaveStatus.stopSlaveWithMutex()
+		// stopSlaveWithMutex increases configVersion, the new attempt goes on with it
+		configVersion = atomic.LoadInt32(&server.slaveStatus.configVersion)
+		server.slaveStatus.mutex.Unlock()
+		server.setupMaster(configVersion)
+	})
+}
+
+// closedWithMutex reports whether server has been closed, invoker should have slaveStatus mutex
+// which is held by Close while closing done, so that no reconnecting would start after closing
+func (server *Server) closedWithMutex() bool {
+	select {
+	case <-server.done:
+		return true
+	default:
+		return false
+	}
+}
+
 // connectWithMaster finishes handshake with master
 // returns: isFullReSync, error
-func (server *Server) connectWithMaster(configVersion int32) (bool, error) {
+func (server *Server) connectWithMaster(configVersion int32) (isFullReSync bool, err error) {
+	server.slaveStatus.mutex.Lock()
 	addr := server.slaveStatus.masterHost + ":" + strconv.Itoa(server.slaveStatus.masterPort)
-	conn, err := net.Dial("tcp", addr)
+	server.slaveStatus.mutex.Unlock()
+	opts := &client.Options{}
+	if config.Properties.TLSReplication {
+		opts.TLSConfig, err = config.Properties.ClientTLSConfig()
+		if err != nil {
+			return false, errors.New("load tls config failed " + err.Error())
+		}
+	}
+	stream, err := client.DialStream(addr, opts)
 	if err != nil {
-		server.slaveOfNone() // abort
 		return false, errors.New("connect master failed " + err.Error())
 	}
-	masterChan := parser.ParseStream(conn)
+	defer func() {
+		if err != nil {
+			_ = stream.Close()
+		}
+	}()
 
 	// ping
-	pingCmdLine := utils.ToCmdLine("ping")
-	pingReq := protocol.MakeMultiBulkReply(pingCmdLine)
-	_, err = conn.Write(pingReq.ToBytes())
-	if err != nil {
-		return false, errors.New("send failed " + err.Error())
+	pingResp, err := stream.Do(utils.ToCmdLine("ping"))
+	if err == client.ErrClosed {
+		return false, errMasterClosed
 	}
-	pingResp := <-masterChan
-	if pingResp.Err != nil {
-		return false, errors.New("read response failed: " + pingResp.Err.Error())
+	if err != nil {
+		return false, errors.New("ping master failed: " + err.Error())
 	}
-	switch reply := pingResp.Data.(type) {
-	case *protocol.StandardErrReply:
+	if reply, ok := pingResp.(*protocol.StandardErrReply); ok {
 		if !strings.HasPrefix(reply.Error(), "NOAUTH") &&
 			!strings.HasPrefix(reply.Error(), "NOPERM") &&
 			!strings.HasPrefix(reply.Error(), "ERR operation not permitted") {
-			logger.Error("Error reply to PING from master: " + string(reply.ToBytes()))
-			server.slaveOfNone() // abort
-			return false, nil
+			return false, errors.New("error reply to PING from master: " + reply.Error())
 		}
 	}
 
 	// just to reduce duplication of code
-	sendCmdToMaster := func(conn net.Conn, cmdLine CmdLine, masterChan <-chan *parser.Payload) error {
-		req := protocol.MakeMultiBulkReply(cmdLine)
-		_, err := conn.Write(req.ToBytes())
+	sendCmdToMaster := func(cmdLine CmdLine) error {
+		resp, err := stream.Do(cmdLine)
+		if err == client.ErrClosed {
+			return errMasterClosed
+		}
 		if err != nil {
-			server.slaveOfNone() // abort
 			return errors.New("send failed " + err.Error())
 		}
-		resp := <-masterChan
-		if resp.Err != nil {
-			server.slaveOfNone() // abort
-			return errors.New("read response failed: " + resp.Err.Error())
-		}
-		if !protocol.IsOKReply(resp.Data) {
-			server.slaveOfNone() // abort
-			return errors.New("unexpected auth response: " + string(resp.Data.ToBytes()))
+		if !protocol.IsOKReply(resp) {
+			return errors.New("unexpected auth response: " + string(resp.ToBytes()))
 		}
 		return nil
 	}
@@ -209,7 +270,7 @@ func (server *Server) connectWithMaster(configVersion int32) (bool, error) {
 	// auth
 	if config.Properties.MasterAuth != "" {
 		authCmdLine := utils.ToCmdLine("auth", config.Properties.MasterAuth)
-		err = sendCmdToMaster(conn, authCmdLine, masterChan)
+		err = sendCmdToMaster(authCmdLine)
 		if err != nil {
 			return false, err
 		}
@@ -219,11 +280,13 @@ func (server *Server) connectWithMaster(configVersion int32) (bool, error) {
 	var port int
 	if config.Properties.SlaveAnnouncePort != 0 {
 		port = config.Properties.SlaveAnnouncePort
+	} else if config.Properties.TLSReplication && config.Properties.TLSPort != 0 {
+		port = config.Properties.TLSPort
 	} else {
 		port = config.Properties.Port
 	}
 	portCmdLine := utils.ToCmdLine("REPLCONF", "listening-port", strconv.Itoa(port))
-	err = sendCmdToMaster(conn, portCmdLine, masterChan)
+	err = sendCmdToMaster(portCmdLine)
 	if err != nil {
 		return false, err
 	}
@@ -231,7 +294,7 @@ func (server *Server) connectWithMaster(configVersion int32) (bool, error) {
 	// announce ip
 	if config.Properties.SlaveAnnounceIP != "" {
 		ipCmdLine := utils.ToCmdLine("REPLCONF", "ip-address", config.Properties.SlaveAnnounceIP)
-		err = sendCmdToMaster(conn, ipCmdLine, masterChan)
+		err = sendCmdToMaster(ipCmdLine)
 		if err != nil {
 			return false, err
 		}
@@ -239,7 +302,7 @@ func (server *Server) connectWithMaster(configVersion int32) (bool, error) {
 
 	// announce capacity
 	capaCmdLine := utils.ToCmdLine("REPLCONF", "capa", "psync2")
-	err = sendCmdToMaster(conn, capaCmdLine, masterChan)
+	err = sendCmdToMaster(capaCmdLine)
 	if err != nil {
 		return false, err
 	}
@@ -251,8 +314,8 @@ func (server *Server) connectWithMaster(configVersion int32) (bool, error) {
 		// slaveStatus conf changed during connecting and waiting mutex
 		return false, configChangedErr
 	}
-	server.slaveStatus.masterConn = conn
-	server.slaveStatus.masterChan = masterChan
+	server.slaveStatus.masterConn = stream.Conn()
+	server.slaveStatus.masterChan = stream.Stream()
 	server.slaveStatus.lastRecvTime = time.Now()
 	return server.psyncHandshake()
 }
@@ -277,7 +340,10 @@ func (server *Server) psyncHandshake() (bool, error) {
 
 func (server *Server) parsePsyncHandshake() (bool, error) {
 	var err error
-	psyncPayload := <-server.slaveStatus.masterChan
+	psyncPayload, ok := <-server.slaveStatus.masterChan
+	if !ok {
+		return false, errMasterClosed
+	}
 	if psyncPayload.Err != nil {
 		return false, errors.New("read response failed: " + psyncPayload.Err.Error())
 	}
@@ -290,15 +356,15 @@ func (server *Server) parsePsyncHandshake() (bool, error) {
 		return false, errors.New("illegal payload header: " + psyncHeader.Status)
 	}
 
-	logger.Info("receive psync header from master")
+	replLogger.Info("receive psync header from master")
 	var isFullReSync bool
 	if headers[0] == "FULLRESYNC" {
-		logger.Info("full re-sync with master")
+		replLogger.Info("full re-sync with master")
 		server.slaveStatus.replId = headers[1]
 		server.slaveStatus.replOffset, err = strconv.ParseInt(headers[2], 10, 64)
 		isFullReSync = true
 	} else if headers[0] == "CONTINUE" {
-		logger.Info("continue partial sync")
+		replLogger.Info("continue partial sync")
 		server.slaveStatus.replId = headers[1]
 		isFullReSync = false
 	} else {
@@ -308,7 +374,7 @@ func (server *Server) parsePsyncHandshake() (bool, error) {
 	if err != nil {
 		return false, errors.New("get illegal repl offset: " + headers[2])
 	}
-	logger.Info(fmt.Sprintf("repl id: %s, current offset: %d", server.slaveStatus.replId, server.slaveStatus.replOffset))
+	replLogger.Info(fmt.Sprintf("repl id: %s, current offset: %d", server.slaveStatus.replId, server.slaveStatus.replOffset))
 	return isFullReSync, nil
 }
 
@@ -333,7 +399,10 @@ func makeRdbLoader(upgradeAof bool) (*Server, string, error) {
 
 // loadMasterRDB downloads rdb after handshake has been done
 func (server *Server) loadMasterRDB(configVersion int32) error {
-	rdbPayload := <-server.slaveStatus.masterChan
+	rdbPayload, ok := <-server.slaveStatus.masterChan
+	if !ok {
+		return errMasterClosed
+	}
 	if rdbPayload.Err != nil {
 		return errors.New("read response failed: " + rdbPayload.Err.Error())
 	}
@@ -342,7 +411,7 @@ func (server *Server) loadMasterRDB(configVersion int32) error {
 		return errors.New("illegal payload header: " + string(rdbPayload.Data.ToBytes()))
 	}
 
-	logger.Info(fmt.Sprintf("receive %d bytes of rdb from master", len(rdbReply.Arg)))
+	replLogger.Info(fmt.Sprintf("receive %d bytes of rdb from master", len(rdbReply.Arg)))
 	rdbDec := rdb.NewDecoder(bytes.NewReader(rdbReply.Arg))
 
 	rdbLoader, newAofFilename, err := makeRdbLoader(config.Properties.AppendOnly)
@@ -364,6 +433,7 @@ func (server *Server) loadMasterRDB(configVersion int32) error {
 		newDB := h.Load().(*DB)
 		server.loadDB(i, newDB)
 	}
+	server.scripts.copyLibraries(&rdbLoader.scripts)
 
 	if config.Properties.AppendOnly {
 		// use new aof file
@@ -403,14 +473,21 @@ func (server *Server) receiveAOF(ctx context.Context, configVersion int32) error
 			server.slaveStatus.mutex.Lock()
 			if server.slaveStatus.configVersion != configVersion {
 				// slaveStatus conf changed during connecting and waiting mutex
+				server.slaveStatus.mutex.Unlock()
 				return configChangedErr
 			}
 			server.Exec(conn, cmdLine.Args)
 			n := len(cmdLine.ToBytes()) // todo: directly get size from socket
 			server.slaveStatus.replOffset += int64(n)
 			server.slaveStatus.lastRecvTime = time.Now()
-			logger.Info(fmt.Sprintf("receive %d bytes from master, current offset %d, %s",
+			replLogger.Info(fmt.Sprintf("receive %d bytes from master, current offset %d, %s",
 				n, server.slaveStatus.replOffset, strconv.Quote(string(cmdLine.ToBytes()))))
+			if isGetAck(cmdLine.Args) {
+				// master is waiting for our offset, e.g. during failover
+				if err := server.slaveStatus.sendAck2Master(); err != nil {
+					replLogger.Error("send ack failed " + err.Error())
+				}
+			}
 			server.slaveStatus.mutex.Unlock()
 		case <-ctx.Done():
 			_ = conn.Close()
@@ -435,32 +512,38 @@ func (server *Server) slaveCron() {
 		// reconnect with master
 		err := server.reconnectWithMaster()
 		if err != nil {
-			logger.Error("send failed " + err.Error())
+			replLogger.Error("send failed " + err.Error())
 		}
 		return
 	}
 	// send ack to master
 	err := repl.sendAck2Master()
 	if err != nil {
-		logger.Error("send failed " + err.Error())
+		replLogger.Error("send failed " + err.Error())
 	}
 }
 
+func isGetAck(cmdLine CmdLine) bool {
+	return len(cmdLine) >= 2 &&
+		strings.ToLower(string(cmdLine[0])) == "replconf" &&
+		strings.ToLower(string(cmdLine[1])) == "getack"
+}
+
 // Send a REPLCONF ACK command to the master to inform it about the current processed offset
 func (repl *slaveStatus) sendAck2Master() error {
 	psyncCmdLine := utils.ToCmdLine("REPLCONF", "ACK",
 		strconv.FormatInt(repl.replOffset, 10))
 	psyncReq := protocol.MakeMultiBulkReply(psyncCmdLine)
 	_, err := repl.masterConn.Write(psyncReq.ToBytes())
-	// logger.Info("send ack to master")
+	// replLogger.Info("send ack to master")
 	return err
 }
 
 func (server *Server) reconnectWithMaster() error {
-	logger.Info("reconnecting with master")
+	replLogger.Info("reconnecting with master")
 	server.slaveStatus.mutex.Lock()
 	defer server.slaveStatus.mutex.Unlock()
 	server.slaveStatus.stopSlaveWithMutex()
-	go server.setupMaster()
+	go server.setupMaster(atomic.LoadInt32(&server.slaveStatus.configVersion))
 	return nil
 }
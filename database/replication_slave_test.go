@@ -6,6 +6,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -248,4 +251,182 @@ func TestReplicationFailover(t *testing.T) {
 	asserts.AssertBulkReply(t, ret, "1")
 	ret = serverB.Exec(conn, utils.ToCmdLine("get", "2"))
 	asserts.AssertBulkReply(t, ret, "2")
-}
\ No newline at end of file
+}
+func TestReplicaOfRetry(t *testing.T) {
+	config.Properties = &config.ServerProperties{
+		Databases:       16,
+		ReplicaReadOnly: true,
+	}
+	replRetryInterval = 100 * time.Millisecond
+	defer func() {
+		replRetryInterval = time.Second
+	}()
+	conn := connection.NewFakeConn()
+	server := mockServer()
+	defer server.Close()
+	ret := server.Exec(conn, utils.ToCmdLine("REPLICAOF", "127.0.0.1", "1"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	// failed connection should not abort replication
+	time.Sleep(500 * time.Millisecond)
+	ret = server.Exec(conn, utils.ToCmdLine("INFO", "replication"))
+	info := string(ret.(*protocol.BulkReply).Arg)
+	if !strings.Contains(info, "role:slave\r\n") ||
+		!strings.Contains(info, "master_port:1\r\n") ||
+		!strings.Contains(info, "master_link_status:down\r\n") {
+		t.Errorf("unexpected replication info: %s", info)
+	}
+	ret = server.Exec(conn, utils.ToCmdLine("SET", "a", "a"))
+	asserts.AssertErrReply(t, ret, "READONLY You can't write against a read only slave.")
+
+	ret = server.Exec(conn, utils.ToCmdLine("REPLICAOF", "NO", "ONE"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = server.Exec(conn, utils.ToCmdLine("INFO", "replication"))
+	info = string(ret.(*protocol.BulkReply).Arg)
+	if !strings.Contains(info, "role:master\r\n") || !strings.Contains(info, "connected_slaves:0\r\n") {
+		t.Errorf("unexpected replication info: %s", info)
+	}
+	ret = server.Exec(conn, utils.ToCmdLine("SET", "a", "a"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = server.Exec(conn, utils.ToCmdLine("REPLICAOF", "127.0.0.1"))
+	asserts.AssertErrReply(t, ret, "ERR wrong number of arguments for 'replicaof' command")
+}
+
+func TestReplicaOfRetryStopsAfterClose(t *testing.T) {
+	config.Properties = &config.ServerProperties{
+		Databases:       16,
+		ReplicaReadOnly: true,
+	}
+	replRetryInterval = 10 * time.Millisecond
+	defer func() {
+		replRetryInterval = time.Second
+	}()
+	server := mockServer()
+	ret := server.Exec(connection.NewFakeConn(), utils.ToCmdLine("REPLICAOF", "127.0.0.1", "1"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	time.Sleep(100 * time.Millisecond)
+	server.Close()
+	// every reconnecting increases configVersion
+	configVersion := atomic.LoadInt32(&server.slaveStatus.configVersion)
+	// an attempt failed after closing has seen the latest configVersion, it must stop because of closed server
+	server.retrySetupMaster(configVersion)
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt32(&server.slaveStatus.configVersion) != configVersion {
+		t.Error("expect no reconnecting after server closed")
+	}
+}
+
+func TestPSyncWithoutAof(t *testing.T) {
+	config.Properties = &config.ServerProperties{
+		Databases: 16,
+	}
+	server := mockServer()
+	defer server.Close()
+	ret := server.Exec(connection.NewFakeConn(), utils.ToCmdLine("PSYNC", "?", "-1"))
+	asserts.AssertErrReply(t, ret, "ERR replication requires appendonly to be enabled on master")
+}
+
+func TestReplicaReadOnly(t *testing.T) {
+	config.Properties = &config.ServerProperties{
+		Databases:       16,
+		ReplicaReadOnly: true,
+	}
+	server := NewStandaloneServer()
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "a"))
+	atomic.StoreInt32(&server.role, slaveRole)
+
+	for _, cmdLine := range [][][]byte{
+		utils.ToCmdLine("SET", "a", "b"),
+		utils.ToCmdLine("DEL", "a"),
+		utils.ToCmdLine("COPY", "a", "b"),
+		utils.ToCmdLine("FLUSHALL"),
+	} {
+		ret := server.Exec(conn, cmdLine)
+		asserts.AssertErrReply(t, ret, "READONLY You can't write against a read only slave.")
+	}
+	ret := server.Exec(conn, utils.ToCmdLine("GET", "a"))
+	asserts.AssertBulkReply(t, ret, "a")
+	ret = server.Exec(conn, utils.ToCmdLine("SELECT", "1"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = server.Exec(conn, utils.ToCmdLine("SELECT", "0"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = server.Exec(connection.NewFakeConn(), utils.ToCmdLine("SUBSCRIBE", "ch"))
+	if _, ok := ret.(*protocol.StandardErrReply); ok {
+		t.Errorf("subscribe on replica failed: %s", ret.ToBytes())
+	}
+	ret = server.Exec(conn, utils.ToCmdLine("READONLY"))
+	asserts.AssertErrReply(t, ret, "ERR This instance has cluster support disabled")
+
+	// updates from master
+	masterConn := connection.NewFakeConn()
+	masterConn.SetMaster()
+	ret = server.Exec(masterConn, utils.ToCmdLine("SET", "a", "b"))
+	asserts.AssertStatusReply(t, ret, "OK")
+
+	config.Properties.ReplicaReadOnly = false
+	ret = server.Exec(conn, utils.ToCmdLine("SET", "a", "c"))
+	asserts.AssertStatusReply(t, ret, "OK")
+	ret = server.Exec(conn, utils.ToCmdLine("GET", "a"))
+	asserts.AssertBulkReply(t, ret, "c")
+}
+
+func TestReplicaExpire(t *testing.T) {
+	config.Properties = &config.ServerProperties{
+		Databases: 16,
+	}
+	server := mockServer()
+	db := server.mustSelectDB(0)
+	var mu sync.Mutex
+	var aofLines []string
+	db.addAof = func(line CmdLine) {
+		mu.Lock()
+		aofLines = append(aofLines, string(bytes.Join(line, []byte(" "))))
+		mu.Unlock()
+	}
+	atomic.StoreInt32(&server.role, slaveRole)
+	conn := connection.NewFakeConn()
+	masterConn := connection.NewFakeConn()
+	masterConn.SetMaster()
+	server.Exec(masterConn, utils.ToCmdLine("SET", "a", "a", "PX", "100"))
+	server.Exec(masterConn, utils.ToCmdLine("SET", "b", "b", "PX", "100"))
+	time.Sleep(2 * time.Second)
+
+	// logically expired keys are invisible but kept until DEL from master arrives
+	ret := server.Exec(conn, utils.ToCmdLine("GET", "a"))
+	asserts.AssertNullBulk(t, ret)
+	ret = server.Exec(conn, utils.ToCmdLine("TTL", "a"))
+	asserts.AssertIntReply(t, ret, -2)
+	if _, ok := db.data.Get("a"); !ok {
+		t.Error("replica should not remove expired key by itself")
+	}
+	server.Exec(masterConn, utils.ToCmdLine("DEL", "a"))
+	if _, ok := db.data.Get("a"); ok {
+		t.Error("expect key removed by DEL from master")
+	}
+	mu.Lock()
+	for _, line := range aofLines {
+		if strings.HasPrefix(line, "del b") {
+			t.Error("replica should not propagate expiration")
+		}
+	}
+	mu.Unlock()
+
+	// promoted replica expires keys and propagates DEL
+	server.slaveOfNone()
+	time.Sleep(2 * time.Second)
+	if _, ok := db.data.Get("b"); ok {
+		t.Error("expect expired key removed after promoted")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	found := false
+	for _, line := range aofLines {
+		if line == "del b" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expect del propagated, actual: %v", aofLines)
+	}
+}
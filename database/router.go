@@ -20,6 +20,10 @@ type command struct {
 	arity int
 	flags int
 	extra *commandExtra
+	// timeoutIndex is the position of timeout in args of blocking commands, negative index counts from the end
+	timeoutIndex int
+	// blockingKeys returns keys a blocking command waits for, nil means all of its write keys
+	blockingKeys func(args [][]byte) []string
 }
 
 type commandExtra struct {
@@ -34,6 +38,7 @@ const flagWrite = 0
 const (
 	flagReadOnly = 1 << iota
 	flagSpecial  // command invoked in Exec
+	flagBlocking // command may suspend connection until timeout
 )
 
 // registerCommand registers a normal command, which only read or modify a limited number of keys
@@ -51,6 +56,15 @@ func registerCommand(name string, executor ExecFunc, prepare PreFunc, rollback U
 	return cmd
 }
 
+// attachBlocking marks command as a blocking command, its executor should return nil reply if it needs to block.
+// blockingKeys returns keys which may unblock the command, nil means all of its write keys
+func (cmd *command) attachBlocking(timeoutIndex int, blockingKeys func(args [][]byte) []string) *command {
+	cmd.flags |= flagBlocking
+	cmd.timeoutIndex = timeoutIndex
+	cmd.blockingKeys = blockingKeys
+	return cmd
+}
+
 // registerSpecialCommand registers a special command, such as publish, select, keys, flushAll
 func registerSpecialCommand(name string, arity int, flags int) *command {
 	name = strings.ToLower(name)
@@ -73,6 +87,33 @@ func isReadOnlyCommand(name string) bool {
 	return cmd.flags&flagReadOnly > 0
 }
 
+// IsReadOnlyCommand returns whether the command only reads keys, so it could be served by replicas
+func IsReadOnlyCommand(name string) bool {
+	return isReadOnlyCommand(name)
+}
+
+// isWriteCommand returns whether the command modifies dataset, which is rejected by read only replicas
+func isWriteCommand(name string) bool {
+	name = strings.ToLower(name)
+	cmd := cmdTable[name]
+	if cmd == nil {
+		return false
+	}
+	if cmd.flags&flagSpecial > 0 {
+		// special commands are not marked by flagReadOnly, such as select and subscribe
+		if cmd.extra == nil {
+			return false
+		}
+		for _, sign := range cmd.extra.signs {
+			if sign == redisFlagWrite {
+				return true
+			}
+		}
+		return false
+	}
+	return cmd.flags&flagReadOnly == 0
+}
+
 func (cmd *command) toDescReply() redis.Reply {
 	args := make([]redis.Reply, 0, 6)
 	args = append(args,
@@ -101,3 +142,16 @@ func (cmd *command) attachCommandExtra(signs []string, firstKey int, lastKey int
 		keyStep:  keyStep,
 	}
 }
+
+// hasSign returns whether the command has the flag shown in COMMAND INFO, such as noscript
+func (cmd *command) hasSign(sign string) bool {
+	if cmd.extra == nil {
+		return false
+	}
+	for _, s := range cmd.extra.signs {
+		if s == sign {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package database
+
+import (
+	"syscall"
+	"time"
+)
+
+// getCPUUsage returns system and user cpu time consumed by current process
+func getCPUUsage() (sys time.Duration, user time.Duration) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0, 0
+	}
+	return time.Duration(usage.Stime.Nano()), time.Duration(usage.Utime.Nano())
+}
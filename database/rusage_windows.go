@@ -0,0 +1,8 @@
+package database
+
+import "time"
+
+// getCPUUsage is not supported on windows
+func getCPUUsage() (sys time.Duration, user time.Duration) {
+	return 0, 0
+}
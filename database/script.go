@@ -0,0 +1,342 @@
+package database
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/logger"
+	"github.com/hdt3213/godis/redis/protocol"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// scriptEngine caches scripts of EVAL and SCRIPT LOAD, holds libraries of FUNCTION LOAD,
+// and tracks running scripts for SCRIPT KILL and FUNCTION KILL.
+// Its zero value is ready to use.
+type scriptEngine struct {
+	mu sync.Mutex
+	// sha1 -> *lua.FunctionProto
+	cache map[string]*lua.FunctionProto
+	// library name -> *luaLibrary
+	libraries map[string]*luaLibrary
+	// function name -> *luaFunction, names of functions are unique across libraries
+	functions map[string]*luaFunction
+	// scripts in execution, there may be several since scripts with different keys run concurrently
+	running map[*scriptRun]struct{}
+}
+
+// load compiles source and caches it, returns sha1 of source
+func (engine *scriptEngine) load(source string) (string, *lua.FunctionProto, error) {
+	sha := sha1Hex(source)
+	engine.mu.Lock()
+	proto, ok := engine.cache[sha]
+	engine.mu.Unlock()
+	if ok {
+		return sha, proto, nil
+	}
+	proto, err := compileLua(source, "@user_script")
+	if err != nil {
+		return "", nil, err
+	}
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if engine.cache == nil {
+		engine.cache = make(map[string]*lua.FunctionProto)
+	}
+	engine.cache[sha] = proto
+	return sha, proto, nil
+}
+
+func (engine *scriptEngine) get(sha string) (*lua.FunctionProto, bool) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	proto, ok := engine.cache[strings.ToLower(sha)]
+	return proto, ok
+}
+
+func (engine *scriptEngine) flush() {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	engine.cache = nil
+}
+
+// begin registers a script which is going to run on db, keys are the declared keys which have been locked,
+// function is the name of function called by FCALL, empty for EVAL
+func (engine *scriptEngine) begin(db *DB, keys [][]byte, readOnly bool, function string) (*scriptRun, context.Context) {
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &scriptRun{
+		db:       db,
+		keys:     make(map[string]struct{}, len(keys)),
+		readOnly: readOnly,
+		function: function,
+		start:    time.Now(),
+		cancel:   cancel,
+	}
+	for _, key := range keys {
+		run.keys[string(key)] = struct{}{}
+	}
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if engine.running == nil {
+		engine.running = make(map[*scriptRun]struct{})
+	}
+	engine.running[run] = struct{}{}
+	return run, ctx
+}
+
+func (engine *scriptEngine) end(run *scriptRun) {
+	run.cancel()
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	delete(engine.running, run)
+}
+
+// busy returns a script which has run longer than lua-time-limit, or nil if there is none.
+// 0 or negative limit means never busy.
+func (engine *scriptEngine) busy() *scriptRun {
+	limit := time.Duration(config.Properties.LuaTimeLimit) * time.Millisecond
+	if limit <= 0 {
+		return nil
+	}
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	var slow *scriptRun
+	for run := range engine.running {
+		elapsed := time.Since(run.start)
+		if elapsed < limit {
+			continue
+		}
+		slow = run
+		if atomic.CompareAndSwapInt32(&run.slowLogged, 0, 1) {
+			logger.Warn("slow script detected: still in execution after " + strconv.FormatInt(elapsed.Milliseconds(), 10) +
+				" milliseconds, you can try killing the script using the " + run.killCommand() + " command")
+		}
+	}
+	return slow
+}
+
+// kill interrupts running scripts which have not written the dataset,
+// function chooses between functions called by FCALL and scripts of EVAL
+func (engine *scriptEngine) kill(function bool) redis.Reply {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	found := false
+	killed := false
+	for run := range engine.running {
+		if (run.function != "") != function {
+			continue
+		}
+		found = true
+		if atomic.CompareAndSwapInt32(&run.state, runRunning, runKilled) {
+			run.cancel()
+			killed = true
+		}
+	}
+	if !found {
+		return protocol.MakeErrReply("NOTBUSY No scripts in execution right now.")
+	}
+	if !killed {
+		return protocol.MakeErrReply("UNKILLABLE Sorry the script already executed write commands against the dataset. " +
+			"You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command.")
+	}
+	return protocol.MakeOkReply()
+}
+
+// stopAll interrupts all running scripts no matter they have written or not, it is used when server is closed
+func (engine *scriptEngine) stopAll() {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	for run := range engine.running {
+		atomic.StoreInt32(&run.state, runKilled)
+		run.cancel()
+	}
+}
+
+// busyReply refuses commands while a script has run longer than lua-time-limit,
+// only SCRIPT KILL, FUNCTION KILL, FUNCTION STATS and SHUTDOWN NOSAVE are allowed then
+func (server *Server) busyReply(c redis.Connection, cmdLine [][]byte) redis.Reply {
+	if c.IsMaster() || c.IsSlave() {
+		return nil
+	}
+	slow := server.scripts.busy()
+	if slow == nil {
+		return nil
+	}
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if len(cmdLine) >= 2 {
+		subCmd := strings.ToLower(string(cmdLine[1]))
+		if cmdName == "script" && subCmd == "kill" {
+			return nil
+		}
+		if cmdName == "function" && (subCmd == "kill" || subCmd == "stats") {
+			return nil
+		}
+		if cmdName == "shutdown" && subCmd == "nosave" {
+			return nil
+		}
+	}
+	return protocol.MakeErrReply("BUSY Redis is busy running a script. You can only call " + slow.killCommand() +
+		" or SHUTDOWN NOSAVE.")
+}
+
+// parseScriptKeys splits args after script or sha1 of EVAL into keys and argv
+func parseScriptKeys(args [][]byte) ([][]byte, [][]byte, redis.Reply) {
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return nil, nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if numKeys < 0 {
+		return nil, nil, protocol.MakeErrReply("ERR Number of keys can't be negative")
+	}
+	if numKeys > len(args)-1 {
+		return nil, nil, protocol.MakeErrReply("ERR Number of keys can't be greater than number of args")
+	}
+	return args[1 : 1+numKeys], args[1+numKeys:], nil
+}
+
+// prepareEval returns keys declared by EVAL, EVALSHA and FCALL, all of them are locked for writing
+func prepareEval(args [][]byte) ([]string, []string) {
+	keys, _, errReply := parseScriptKeys(args[1:])
+	if errReply != nil {
+		return nil, nil
+	}
+	return writeAllKeys(keys)
+}
+
+// prepareEvalRO returns keys declared by EVAL_RO, EVALSHA_RO and FCALL_RO, all of them are locked for reading
+func prepareEvalRO(args [][]byte) ([]string, []string) {
+	write, _ := prepareEval(args)
+	return nil, write
+}
+
+func undoEval(db *DB, args [][]byte) []CmdLine {
+	write, _ := prepareEval(args)
+	return rollbackGivenKeys(db, write...)
+}
+
+// runScript runs compiled script with KEYS and ARGV, commands called by the script are executed on db
+func (db *DB) runScript(sha string, proto *lua.FunctionProto, args [][]byte, readOnly bool) redis.Reply {
+	keys, argv, errReply := parseScriptKeys(args)
+	if errReply != nil {
+		return errReply
+	}
+	run, ctx := db.scripts.begin(db, keys, readOnly, "")
+	defer db.scripts.end(run)
+	L := newLuaState()
+	defer L.Close()
+	L.SetContext(ctx)
+	run.openRedisLib(L)
+	L.SetGlobal("KEYS", makeLuaStringArray(L, keys))
+	L.SetGlobal("ARGV", makeLuaStringArray(L, argv))
+	return run.call(L, L.NewFunctionFromProto(proto), "f_"+sha)
+}
+
+func evalScript(db *DB, args [][]byte, readOnly bool) redis.Reply {
+	if db.scripts == nil {
+		return protocol.MakeErrReply("ERR scripting is not available")
+	}
+	sha, proto, err := db.scripts.load(string(args[0]))
+	if err != nil {
+		return protocol.MakeErrReply("ERR Error compiling script (new function): " + err.Error())
+	}
+	return db.runScript(sha, proto, args[1:], readOnly)
+}
+
+func evalSha(db *DB, args [][]byte, readOnly bool) redis.Reply {
+	if db.scripts == nil {
+		return protocol.MakeErrReply("ERR scripting is not available")
+	}
+	sha := strings.ToLower(string(args[0]))
+	proto, ok := db.scripts.get(sha)
+	if !ok {
+		return protocol.MakeErrReply("NOSCRIPT No matching script. Please use EVAL.")
+	}
+	return db.runScript(sha, proto, args[1:], readOnly)
+}
+
+// execEval runs a lua script: EVAL script numkeys [key ...] [arg ...]
+func execEval(db *DB, args [][]byte) redis.Reply {
+	return evalScript(db, args, false)
+}
+
+// execEvalRO runs a lua script which could only call read-only commands
+func execEvalRO(db *DB, args [][]byte) redis.Reply {
+	return evalScript(db, args, true)
+}
+
+// execEvalSha runs a lua script cached by SCRIPT LOAD or EVAL: EVALSHA sha1 numkeys [key ...] [arg ...]
+func execEvalSha(db *DB, args [][]byte) redis.Reply {
+	return evalSha(db, args, false)
+}
+
+func execEvalShaRO(db *DB, args [][]byte) redis.Reply {
+	return evalSha(db, args, true)
+}
+
+// execScript handles SCRIPT LOAD, EXISTS, FLUSH and KILL
+func (server *Server) execScript(args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.MakeArgNumErrReply("script")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "load":
+		if len(args) != 2 {
+			return protocol.MakeArgNumErrReply("script|load")
+		}
+		sha, _, err := server.scripts.load(string(args[1]))
+		if err != nil {
+			return protocol.MakeErrReply("ERR Error compiling script (new function): " + err.Error())
+		}
+		return protocol.MakeBulkReply([]byte(sha))
+	case "exists":
+		if len(args) < 2 {
+			return protocol.MakeArgNumErrReply("script|exists")
+		}
+		result := make([]redis.Reply, len(args)-1)
+		for i, sha := range args[1:] {
+			if _, ok := server.scripts.get(string(sha)); ok {
+				result[i] = protocol.MakeIntReply(1)
+			} else {
+				result[i] = protocol.MakeIntReply(0)
+			}
+		}
+		return protocol.MakeMultiRawReply(result)
+	case "flush":
+		if len(args) > 2 {
+			return protocol.MakeArgNumErrReply("script|flush")
+		}
+		if len(args) == 2 {
+			mode := strings.ToLower(string(args[1]))
+			if mode != "async" && mode != "sync" {
+				return protocol.MakeErrReply("ERR SCRIPT FLUSH only support SYNC|ASYNC option")
+			}
+		}
+		server.scripts.flush()
+		return protocol.MakeOkReply()
+	case "kill":
+		if len(args) != 1 {
+			return protocol.MakeArgNumErrReply("script|kill")
+		}
+		return server.scripts.kill(false)
+	}
+	return protocol.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try SCRIPT HELP.")
+}
+
+func init() {
+	registerCommand("Eval", execEval, prepareEval, undoEval, -3, flagWrite).
+		attachCommandExtra([]string{redisFlagNoScript, redisFlagMovableKeys}, 0, 0, 0)
+	registerCommand("EvalSha", execEvalSha, prepareEval, undoEval, -3, flagWrite).
+		attachCommandExtra([]string{redisFlagNoScript, redisFlagMovableKeys}, 0, 0, 0)
+	registerCommand("Eval_RO", execEvalRO, prepareEvalRO, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagNoScript, redisFlagReadonly, redisFlagMovableKeys}, 0, 0, 0)
+	registerCommand("EvalSha_RO", execEvalShaRO, prepareEvalRO, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagNoScript, redisFlagReadonly, redisFlagMovableKeys}, 0, 0, 0)
+	registerSpecialCommand("Script", -2, 0).
+		attachCommandExtra([]string{redisFlagNoScript}, 0, 0, 0)
+}
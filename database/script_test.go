@@ -0,0 +1,159 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestEval(t *testing.T) {
+	c := connection.NewFakeConn()
+	testServer.Exec(c, utils.ToCmdLine("flushall"))
+	result := testServer.Exec(c, utils.ToCmdLine("eval", "return redis.call('set', KEYS[1], ARGV[1])", "1", "a", "1"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testServer.Exec(c, utils.ToCmdLine("eval", "return redis.call('incrby', KEYS[1], ARGV[1])", "1", "a", "2"))
+	asserts.AssertIntReply(t, result, 3)
+	result = testServer.Exec(c, utils.ToCmdLine("eval", "return {KEYS[1], ARGV[1], 3.7, false, nil, 'unreachable'}", "1", "k", "v"))
+	if string(result.ToBytes()) != "*4\r\n$1\r\nk\r\n$1\r\nv\r\n:3\r\n$-1\r\n" {
+		t.Errorf("unexpected reply: %s", result.ToBytes())
+	}
+	result = testServer.Exec(c, utils.ToCmdLine("eval", "return redis.call('get', KEYS[1]) == false", "1", "none"))
+	asserts.AssertIntReply(t, result, 1)
+
+	// errors
+	result = testServer.Exec(c, utils.ToCmdLine("eval", "return redis.call('lpush', KEYS[1], 'x')", "1", "a"))
+	asserts.AssertErrReply(t, result, "WRONGTYPE Operation against a key holding the wrong kind of value")
+	result = testServer.Exec(c, utils.ToCmdLine("eval", "local r = redis.pcall('lpush', KEYS[1], 'x'); return r.err", "1", "a"))
+	asserts.AssertBulkReply(t, result, "WRONGTYPE Operation against a key holding the wrong kind of value")
+	result = testServer.Exec(c, utils.ToCmdLine("eval", "return redis.error_reply('MY error')", "0"))
+	asserts.AssertErrReply(t, result, "MY error")
+	result = testServer.Exec(c, utils.ToCmdLine("eval", "return redis.call('get', 'b')", "0"))
+	asserts.AssertErrReply(t, result, "ERR Script attempted to access a key not declared in KEYS: b")
+	result = testServer.Exec(c, utils.ToCmdLine("eval", "return redis.call('eval', 'return 1', '0')", "0"))
+	asserts.AssertErrReply(t, result, "ERR This Redis command is not allowed from script")
+	result = testServer.Exec(c, utils.ToCmdLine("eval", "return 1", "2", "a"))
+	asserts.AssertErrReply(t, result, "ERR Number of keys can't be greater than number of args")
+	result = testServer.Exec(c, utils.ToCmdLine("eval", "return (", "0"))
+	if !protocol.IsErrorReply(result) {
+		t.Errorf("expect compile error, actual: %s", result.ToBytes())
+	}
+
+	// read only scripts
+	result = testServer.Exec(c, utils.ToCmdLine("eval_ro", "return redis.call('get', KEYS[1])", "1", "a"))
+	asserts.AssertBulkReply(t, result, "3")
+	result = testServer.Exec(c, utils.ToCmdLine("eval_ro", "return redis.call('del', KEYS[1])", "1", "a"))
+	asserts.AssertErrReply(t, result, "ERR Write commands are not allowed from read-only scripts.")
+}
+
+func TestEvalSha(t *testing.T) {
+	c := connection.NewFakeConn()
+	testServer.Exec(c, utils.ToCmdLine("flushall"))
+	script := "return redis.call('set', KEYS[1], ARGV[1])"
+	sha := sha1Hex(script)
+	testServer.Exec(c, utils.ToCmdLine("script", "flush"))
+	result := testServer.Exec(c, utils.ToCmdLine("evalsha", sha, "1", "a", "1"))
+	asserts.AssertErrReply(t, result, "NOSCRIPT No matching script. Please use EVAL.")
+	result = testServer.Exec(c, utils.ToCmdLine("script", "load", script))
+	asserts.AssertBulkReply(t, result, sha)
+	result = testServer.Exec(c, utils.ToCmdLine("script", "exists", sha, "ffff"))
+	if string(result.ToBytes()) != "*2\r\n:1\r\n:0\r\n" {
+		t.Errorf("unexpected reply: %s", result.ToBytes())
+	}
+	result = testServer.Exec(c, utils.ToCmdLine("evalsha", sha, "1", "a", "1"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testServer.Exec(c, utils.ToCmdLine("get", "a"))
+	asserts.AssertBulkReply(t, result, "1")
+
+	// scripts are cached by EVAL as well
+	testServer.Exec(c, utils.ToCmdLine("script", "flush"))
+	testServer.Exec(c, utils.ToCmdLine("eval", "return 1", "0"))
+	result = testServer.Exec(c, utils.ToCmdLine("evalsha", sha1Hex("return 1"), "0"))
+	asserts.AssertIntReply(t, result, 1)
+}
+
+func TestEvalInMulti(t *testing.T) {
+	c := connection.NewFakeConn()
+	testServer.Exec(c, utils.ToCmdLine("flushall"))
+	testServer.Exec(c, utils.ToCmdLine("set", "a", "1"))
+	testServer.Exec(c, utils.ToCmdLine("multi"))
+	testServer.Exec(c, utils.ToCmdLine("eval", "return redis.call('set', KEYS[1], '2')", "1", "a"))
+	testServer.Exec(c, utils.ToCmdLine("lpush", "a", "x"))
+	result := testServer.Exec(c, utils.ToCmdLine("exec"))
+	asserts.AssertErrReply(t, result, "EXECABORT Transaction discarded because of previous errors.")
+	// writes of script are rolled back
+	result = testServer.Exec(c, utils.ToCmdLine("get", "a"))
+	asserts.AssertBulkReply(t, result, "1")
+}
+
+// startSlowScript runs script in background and waits until the server is busy
+func startSlowScript(t *testing.T, server *Server, script string) <-chan redis.Reply {
+	ch := make(chan redis.Reply, 1)
+	go func() {
+		ch <- server.Exec(connection.NewFakeConn(), utils.ToCmdLine("eval", script, "1", "slow"))
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for server.scripts.busy() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("server is not busy")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return ch
+}
+
+func TestScriptKill(t *testing.T) {
+	c := connection.NewFakeConn()
+	result := testServer.Exec(c, utils.ToCmdLine("script", "kill"))
+	asserts.AssertErrReply(t, result, "NOTBUSY No scripts in execution right now.")
+	result = testServer.Exec(c, utils.ToCmdLine("config", "set", "lua-time-limit", "50"))
+	asserts.AssertStatusReply(t, result, "OK")
+	defer testServer.Exec(c, utils.ToCmdLine("config", "set", "lua-time-limit", "5000"))
+
+	ch := startSlowScript(t, testServer, "redis.call('get', KEYS[1]) while true do end")
+	result = testServer.Exec(c, utils.ToCmdLine("get", "a"))
+	asserts.AssertErrReply(t, result, "BUSY Redis is busy running a script. You can only call SCRIPT KILL or SHUTDOWN NOSAVE.")
+	result = testServer.Exec(c, utils.ToCmdLine("script", "kill"))
+	asserts.AssertStatusReply(t, result, "OK")
+	select {
+	case result = <-ch:
+		asserts.AssertErrReply(t, result, "ERR Script killed by user with SCRIPT KILL...")
+	case <-time.After(5 * time.Second):
+		t.Fatal("script is not killed")
+	}
+	result = testServer.Exec(c, utils.ToCmdLine("ping"))
+	asserts.AssertStatusReply(t, result, "PONG")
+}
+
+func TestScriptUnkillable(t *testing.T) {
+	backup := config.Properties
+	defer func() {
+		config.Properties = backup
+	}()
+	config.Properties = &config.ServerProperties{
+		LuaTimeLimit: 50,
+	}
+	server := NewStandaloneServer()
+	c := connection.NewFakeConn()
+	ch := startSlowScript(t, server, "redis.call('set', KEYS[1], '1') while true do end")
+	result := server.Exec(c, utils.ToCmdLine("script", "kill"))
+	asserts.AssertErrReply(t, result, "UNKILLABLE Sorry the script already executed write commands against the dataset. "+
+		"You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command.")
+	result = server.Exec(c, utils.ToCmdLine("shutdown"))
+	if protocol.IsOKReply(result) {
+		t.Error("shutdown without nosave should be refused")
+	}
+	result = server.Exec(c, utils.ToCmdLine("shutdown", "nosave"))
+	asserts.AssertStatusReply(t, result, "OK")
+	server.Shutdown()
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("script is not stopped by shutdown")
+	}
+}
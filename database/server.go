@@ -6,6 +6,7 @@ import (
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -13,6 +14,7 @@ import (
 	"github.com/hdt3213/godis/config"
 	"github.com/hdt3213/godis/interface/database"
 	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/clock"
 	"github.com/hdt3213/godis/lib/logger"
 	"github.com/hdt3213/godis/lib/utils"
 	"github.com/hdt3213/godis/pubsub"
@@ -24,6 +26,9 @@ var godisVersion = "1.2.8" // do not modify
 // Server is a redis-server with full capabilities including multiple database, rdb loader, replication
 type Server struct {
 	dbSet []*atomic.Value // *DB
+	// SWAPDB holds dbLock exclusively while exchanging databases, commands accessing databases hold it shared,
+	// so that they never see a half-swapped server
+	dbLock sync.RWMutex
 
 	// handle publish/subscribe
 	hub *pubsub.Hub
@@ -34,13 +39,53 @@ type Server struct {
 	role         int32
 	slaveStatus  *slaveStatus
 	masterStatus *masterStatus
+	failover     *failoverStatus
 
 	// hooks
 	insertCallback database.KeyEventCallback
 	deleteCallback database.KeyEventCallback
+	// *Hooks set by embedding applications, see SetHooks
+	hooks atomic.Value
 
 	// slow log record
 	slogLogger *SlowLogger
+	// samples of latency spikes, see LATENCY
+	latency *latencyMonitor
+	// lua scripts of EVAL and SCRIPT, and libraries of FUNCTION
+	scripts scriptEngine
+
+	// connection -> *blockingWaiter, connections blocked by blocking commands
+	blockedConns sync.Map
+
+	// id -> redis.Connection, connections which have sent commands
+	clients sync.Map
+	// keys cached by clients, see CLIENT TRACKING
+	tracking *trackingTable
+	// commands from clients are suspended during CLIENT PAUSE
+	pause clientPause
+	// limits command rate of clients, see client-command-rate
+	throttle commandThrottle
+	// runtime counters shown in INFO
+	stats serverStats
+	// pprof and expvar, see debug-http-addr
+	debugHTTP debugHTTP
+	// functions to unregister callbacks of CONFIG SET
+	configCallbacks []func()
+
+	// 1 if SAVE or BGSAVE is running, updated atomically
+	rdbSaving int32
+	// 1 if the latest rdb saving failed, updated atomically
+	rdbSaveFailed int32
+	// unix time of the latest successful rdb saving, updated atomically
+	lastSaveTime int64
+	// 1 if expire tasks are disabled by DEBUG SET-ACTIVE-EXPIRE 0, updated atomically
+	activeExpireDisabled int32
+
+	// closed by Close to stop background jobs
+	done      chan struct{}
+	closeOnce sync.Once
+	// see shutdown.go
+	shutdown shutdownStatus
 }
 
 func fileExists(filename string) bool {
@@ -50,7 +95,13 @@ func fileExists(filename string) bool {
 
 // NewStandaloneServer creates a standalone redis server, with multi database and all other funtions
 func NewStandaloneServer() *Server {
-	server := &Server{}
+	server := &Server{
+		lastSaveTime: time.Now().Unix(),
+		done:         make(chan struct{}),
+		shutdown:     shutdownStatus{ch: make(chan struct{})},
+		latency:      makeLatencyMonitor(),
+	}
+	server.tracking = makeTrackingTable(&server.clients)
 	if config.Properties.Databases == 0 {
 		config.Properties.Databases = 16
 	}
@@ -64,6 +115,14 @@ func NewStandaloneServer() *Server {
 	for i := range server.dbSet {
 		singleDB := makeDB()
 		singleDB.index = i
+		singleDB.notify = server.notifyKeyspaceEvent
+		singleDB.tracking = server.tracking
+		singleDB.isReplica = server.isReplica
+		singleDB.activeExpire = server.activeExpireEnabled
+		singleDB.keyHooked = server.keyHooked
+		singleDB.stats = &server.stats
+		singleDB.latency = server.latency
+		singleDB.scripts = &server.scripts
 		holder := &atomic.Value{}
 		holder.Store(singleDB)
 		server.dbSet[i] = holder
@@ -90,10 +149,18 @@ func NewStandaloneServer() *Server {
 	server.slaveStatus = initReplSlaveStatus()
 	server.initMasterStatus()
 	server.startReplCron()
+	go server.stats.statsCron(server.done)
+	go server.clientsCron()
 	server.role = masterRole // The initialization process does not require atomicity
 
 	// record slow log
 	server.slogLogger = NewSlowLogger(config.Properties.SlowLogMaxLen, config.Properties.SlowLogSlowerThan)
+	server.registerConfigCallbacks()
+	if config.Properties.DebugHTTPAddr != "" {
+		if err := server.setDebugHTTPAddr(config.Properties.DebugHTTPAddr); err != nil {
+			logger.Error("start debug http server failed: " + err.Error())
+		}
+	}
 
 	return server
 }
@@ -108,9 +175,30 @@ func (server *Server) Exec(c redis.Connection, cmdLine [][]byte) (result redis.R
 		}
 	}()
 	// Record the start time of command execution
-	GodisExecCommandStartUnixTime := time.Now()
+	GodisExecCommandStartUnixTime := clock.Now()
 
 	cmdName := strings.ToLower(string(cmdLine[0]))
+	if _, loaded := server.clients.LoadOrStore(c.ID(), c); !loaded {
+		server.stats.incrConnections()
+	}
+	c.SetLastCmd(cmdName)
+	if !server.throttle.allow(c) {
+		return protocol.MakeErrReply("THROTTLED client command rate limit exceeded")
+	}
+	if !c.IsMaster() && !c.IsSlave() && cmdName != "client" {
+		// replication and CLIENT commands are not paused, so that CLIENT UNPAUSE works
+		server.pause.wait(isPausedAsWrite(c, cmdName))
+	}
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		server.stats.recordCommand(cmdName, duration, result)
+		server.afterCommand(c, cmdLine, result, duration)
+		// blocking commands are slow by design
+		if cmd, ok := cmdTable[cmdName]; !ok || cmd.flags&flagBlocking == 0 {
+			server.latency.addSampleIfNeeded("command", duration)
+		}
+	}()
 	// ping
 	if cmdName == "ping" {
 		return Ping(c, cmdLine[1:])
@@ -122,6 +210,12 @@ func (server *Server) Exec(c redis.Connection, cmdLine [][]byte) (result redis.R
 	if !isAuthenticated(c) {
 		return protocol.MakeErrReply("NOAUTH Authentication required")
 	}
+	if reply := server.busyReply(c, cmdLine); reply != nil {
+		return reply
+	}
+	if reply := server.beforeCommand(c, cmdLine); reply != nil {
+		return reply
+	}
 	// info
 	if cmdName == "info" {
 		return Info(server, cmdLine[1:])
@@ -131,31 +225,69 @@ func (server *Server) Exec(c redis.Connection, cmdLine [][]byte) (result redis.R
 	if cmdName == "slowlog" {
 		return server.slogLogger.HandleSlowlogCommand(cmdLine)
 	}
+	if cmdName == "latency" {
+		return server.execLatency(cmdLine[1:])
+	}
 
 	if cmdName == "dbsize" {
 		return DbSize(c, server)
 	}
-	if cmdName == "slaveof" {
+	if cmdName == "slaveof" || cmdName == "replicaof" {
 		if c != nil && c.InMultiState() {
 			return protocol.MakeErrReply("cannot use slave of database within multi")
 		}
 		if len(cmdLine) != 3 {
-			return protocol.MakeArgNumErrReply("SLAVEOF")
+			return protocol.MakeArgNumErrReply(cmdName)
 		}
 		return server.execSlaveOf(c, cmdLine[1:])
 	} else if cmdName == "command" {
 		return execCommand(cmdLine[1:])
+	} else if cmdName == "client" {
+		return server.execClient(c, cmdLine[1:])
+	} else if cmdName == "config" {
+		return server.execConfig(cmdLine[1:])
+	} else if cmdName == "script" {
+		return server.execScript(cmdLine[1:])
+	} else if cmdName == "function" {
+		return server.execFunction(c, cmdLine[1:])
+	} else if cmdName == "shutdown" {
+		if c != nil && c.InMultiState() {
+			return protocol.MakeErrReply("ERR command 'SHUTDOWN' cannot be used in MULTI")
+		}
+		return server.execShutdown(cmdLine[1:])
+	} else if cmdName == "readonly" || cmdName == "readwrite" || cmdName == "asking" || cmdName == "cluster" {
+		return protocol.MakeErrReply("ERR This instance has cluster support disabled")
+	} else if cmdName == "failover" {
+		if c != nil && c.InMultiState() {
+			return protocol.MakeErrReply("ERR command 'FAILOVER' cannot be used in MULTI")
+		}
+		return server.execFailover(cmdLine[1:])
+	}
+
+	if !c.IsMaster() && isWriteCommand(cmdName) {
+		// writes are paused during failover
+		server.waitFailover()
 	}
 
 	// read only slave
 	role := atomic.LoadInt32(&server.role)
-	if role == slaveRole && !c.IsMaster() {
-		// only allow read only command, forbid all special commands except `auth` and `slaveof`
-		if !isReadOnlyCommand(cmdName) {
+	if role == slaveRole && !c.IsMaster() && config.Properties.ReplicaReadOnly {
+		// only updates from master could modify dataset
+		// functions declared with no-writes could be called by FCALL on replicas
+		if isWriteCommand(cmdName) && !server.isNoWritesFCall(cmdLine) {
 			return protocol.MakeErrReply("READONLY You can't write against a read only slave.")
 		}
 	}
 
+	if cmd, ok := cmdTable[cmdName]; ok && cmd.flags&flagBlocking > 0 && !c.InMultiState() {
+		// blocking commands hold dbLock only while trying to serve, not while waiting
+		return server.execBlocking(c, cmd, cmdLine)
+	}
+	if cmdName != "swapdb" {
+		server.dbLock.RLock()
+		defer server.dbLock.RUnlock()
+	}
+
 	// special commands which cannot execute within transaction
 	if cmdName == "subscribe" {
 		if len(cmdLine) < 2 {
@@ -166,6 +298,24 @@ func (server *Server) Exec(c redis.Connection, cmdLine [][]byte) (result redis.R
 		return pubsub.Publish(server.hub, cmdLine[1:])
 	} else if cmdName == "unsubscribe" {
 		return pubsub.UnSubscribe(server.hub, c, cmdLine[1:])
+	} else if cmdName == "psubscribe" {
+		if len(cmdLine) < 2 {
+			return protocol.MakeArgNumErrReply("psubscribe")
+		}
+		return pubsub.PSubscribe(server.hub, c, cmdLine[1:])
+	} else if cmdName == "punsubscribe" {
+		return pubsub.PUnSubscribe(server.hub, c, cmdLine[1:])
+	} else if cmdName == "ssubscribe" {
+		if len(cmdLine) < 2 {
+			return protocol.MakeArgNumErrReply("ssubscribe")
+		}
+		return pubsub.SSubscribe(server.hub, c, cmdLine[1:])
+	} else if cmdName == "sunsubscribe" {
+		return pubsub.SUnSubscribe(server.hub, c, cmdLine[1:])
+	} else if cmdName == "spublish" {
+		return pubsub.SPublish(server.hub, cmdLine[1:])
+	} else if cmdName == "pubsub" {
+		return pubsub.Introspect(server.hub, cmdLine[1:])
 	} else if cmdName == "bgrewriteaof" {
 		if !config.Properties.AppendOnly {
 			return protocol.MakeErrReply("AppendOnly is false, you can't rewrite aof file")
@@ -191,6 +341,27 @@ func (server *Server) Exec(c redis.Connection, cmdLine [][]byte) (result redis.R
 		return SaveRDB(server, cmdLine[1:])
 	} else if cmdName == "bgsave" {
 		return BGSaveRDB(server, cmdLine[1:])
+	} else if cmdName == "debug" {
+		if c != nil && c.InMultiState() {
+			return protocol.MakeErrReply("ERR command 'DEBUG' cannot be used in MULTI")
+		}
+		return server.execDebug(c, cmdLine[1:])
+	} else if cmdName == "swapdb" {
+		if c != nil && c.InMultiState() {
+			return protocol.MakeErrReply("ERR command 'SWAPDB' cannot be used in MULTI")
+		}
+		if len(cmdLine) != 3 {
+			return protocol.MakeArgNumErrReply(cmdName)
+		}
+		return server.execSwapDB(cmdLine[1:])
+	} else if cmdName == "move" {
+		if c != nil && c.InMultiState() {
+			return protocol.MakeErrReply("ERR command 'MOVE' cannot be used in MULTI")
+		}
+		if len(cmdLine) != 3 {
+			return protocol.MakeArgNumErrReply(cmdName)
+		}
+		return execMove(server, c, cmdLine[1:])
 	} else if cmdName == "select" {
 		if c != nil && c.InMultiState() {
 			return protocol.MakeErrReply("cannot select database within multi")
@@ -219,6 +390,7 @@ func (server *Server) Exec(c redis.Connection, cmdLine [][]byte) (result redis.R
 	}
 
 	exec := selectedDB.Exec(c, cmdLine)
+	server.tracking.afterCommand(c)
 	// Record slow query logs
 	server.slogLogger.Record(GodisExecCommandStartUnixTime, cmdLine, c.Name())
 	return exec
@@ -227,12 +399,33 @@ func (server *Server) Exec(c redis.Connection, cmdLine [][]byte) (result redis.R
 // AfterClientClose does some clean after client close connection
 func (server *Server) AfterClientClose(c redis.Connection) {
 	pubsub.UnsubscribeAll(server.hub, c)
+	server.unblockClient(c)
+	server.tracking.disable(c)
+	server.throttle.remove(c)
+	server.clients.Delete(c.ID())
 }
 
 // Close graceful shutdown database
 func (server *Server) Close() {
-	// stop slaveStatus first
-	server.slaveStatus.close()
+	server.closeOnce.Do(server.close)
+}
+
+func (server *Server) close() {
+	server.pause.unpause()
+	server.scripts.stopAll()
+	for _, unregister := range server.configCallbacks {
+		unregister()
+	}
+	_ = server.setDebugHTTPAddr("")
+	// stop slaveStatus first, done is closed with its mutex held so that pending retries see it
+	server.slaveStatus.mutex.Lock()
+	// servers made by MakeAuxiliaryServer have no background jobs
+	if server.done != nil {
+		close(server.done)
+	}
+	server.slaveStatus.stopSlaveWithMutex()
+	server.slaveStatus.mutex.Unlock()
+	// aof buffer is flushed and propagated to replicas before they are disconnected
 	if server.persister != nil {
 		server.persister.Close()
 	}
@@ -274,11 +467,116 @@ func (server *Server) loadDB(dbIndex int, newDB *DB) redis.Reply {
 	}
 	oldDB := server.mustSelectDB(dbIndex)
 	newDB.index = dbIndex
+	// expire tasks of oldDB are replaced by ones of newDB with the same name
+	newDB.id = oldDB.id
 	newDB.addAof = oldDB.addAof // inherit oldDB
+	newDB.blocking = oldDB.blocking
+	newDB.notify = oldDB.notify
+	newDB.tracking = oldDB.tracking
+	newDB.isReplica = oldDB.isReplica
+	newDB.activeExpire = oldDB.activeExpire
+	newDB.keyHooked = oldDB.keyHooked
+	newDB.stats = oldDB.stats
+	newDB.latency = oldDB.latency
+	newDB.scripts = oldDB.scripts
+	newDB.insertCallback = oldDB.insertCallback
+	newDB.deleteCallback = oldDB.deleteCallback
+	// keys of both dbs are modified by replacing, so that transactions watching them would be aborted
+	newDB.versionMap = oldDB.versionMap
+	oldDB.data.ForEach(func(key string, val interface{}) bool {
+		newDB.addVersion(key)
+		if cb := newDB.deleteCallback; cb != nil {
+			cb(dbIndex, key, val.(*database.DataEntity))
+		}
+		return true
+	})
+	newDB.data.ForEach(func(key string, val interface{}) bool {
+		newDB.addVersion(key)
+		if cb := newDB.insertCallback; cb != nil {
+			cb(dbIndex, key, val.(*database.DataEntity))
+		}
+		return true
+	})
 	server.dbSet[dbIndex].Store(newDB)
+	newDB.takeOverExpireTasks(oldDB)
+	newDB.tracking.invalidateAll()
 	return &protocol.OkReply{}
 }
 
+// execSwapDB handles SWAPDB index1 index2
+func (server *Server) execSwapDB(args [][]byte) redis.Reply {
+	index1, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return protocol.MakeErrReply("ERR invalid first DB index")
+	}
+	index2, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return protocol.MakeErrReply("ERR invalid second DB index")
+	}
+	if index1 < 0 || index1 >= len(server.dbSet) || index2 < 0 || index2 >= len(server.dbSet) {
+		return protocol.MakeErrReply("ERR DB index is out of range")
+	}
+	server.dbLock.Lock()
+	defer server.dbLock.Unlock()
+	if index1 != index2 {
+		server.swapDB(index1, index2)
+	}
+	// appended with dbLock held, so that commands executed after swapping are replayed after it
+	server.AddAof(0, utils.ToCmdLine3("swapdb", args...))
+	return protocol.MakeOkReply()
+}
+
+// swapDB exchanges datasets of two databases, connections stay in the databases they selected.
+// Datasets are moved with their expire tasks, while aof, blocked connections and versions stay with the index.
+// dbLock must be held exclusively
+func (server *Server) swapDB(index1, index2 int) {
+	db1 := server.mustSelectDB(index1)
+	db2 := server.mustSelectDB(index2)
+	db1.index, db2.index = db2.index, db1.index
+	db1.addAof, db2.addAof = db2.addAof, db1.addAof
+	db1.blocking, db2.blocking = db2.blocking, db1.blocking
+	db1.versionMap, db2.versionMap = db2.versionMap, db1.versionMap
+	server.dbSet[index1].Store(db2)
+	server.dbSet[index2].Store(db1)
+
+	if db1.insertCallback != nil || db1.deleteCallback != nil {
+		// keys are moved between indexes, callbacks need to know it
+		for _, db := range []*DB{db1, db2} {
+			from := index1 + index2 - db.index
+			db.data.ForEach(func(key string, val interface{}) bool {
+				entity := val.(*database.DataEntity)
+				if cb := db.deleteCallback; cb != nil {
+					cb(from, key, entity)
+				}
+				if cb := db.insertCallback; cb != nil {
+					cb(db.index, key, entity)
+				}
+				return true
+			})
+		}
+	}
+	// transactions watching keys of both databases are aborted
+	server.clients.Range(func(_, raw interface{}) bool {
+		c := raw.(redis.Connection)
+		if dbIndex := c.GetDBIndex(); dbIndex == index1 || dbIndex == index2 {
+			db := server.mustSelectDB(dbIndex)
+			for key := range c.GetWatching() {
+				db.addVersion(key)
+			}
+		}
+		return true
+	})
+	db1.tracking.invalidateAll()
+	// wake up clients blocked on keys which are brought in by swapping
+	for _, db := range []*DB{db1, db2} {
+		for _, key := range db.blocking.keys() {
+			if _, ok := db.data.Get(key); ok {
+				db.blocking.signal(key)
+			}
+		}
+	}
+}
+
 // flushAll flushes all databases.
 func (server *Server) flushAll() redis.Reply {
 	for i := range server.dbSet {
@@ -356,17 +654,28 @@ func (server *Server) ExecWithLock(conn redis.Connection, cmdLine [][]byte) redi
 	if errReply != nil {
 		return errReply
 	}
+	// let transactions watching the keys know they are modified
+	write, _ := GetRelatedKeys(cmdLine)
+	db.addVersion(write...)
 	return db.execWithLock(cmdLine)
 }
 
 // BGRewriteAOF asynchronously rewrites Append-Only-File
 func BGRewriteAOF(db *Server, args [][]byte) redis.Reply {
-	go db.persister.Rewrite()
+	if db.persister == nil {
+		return protocol.MakeErrReply("please enable aof before using bgrewriteaof")
+	}
+	if err := db.persister.BackgroundRewrite(); err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
 	return protocol.MakeStatusReply("Background append only file rewriting started")
 }
 
 // RewriteAOF start Append-Only-File rewriting and blocked until it finished
 func RewriteAOF(db *Server, args [][]byte) redis.Reply {
+	if db.persister == nil {
+		return protocol.MakeErrReply("please enable aof before using rewriteaof")
+	}
 	err := db.persister.Rewrite()
 	if err != nil {
 		return protocol.MakeErrReply(err.Error())
@@ -376,14 +685,11 @@ func RewriteAOF(db *Server, args [][]byte) redis.Reply {
 
 // SaveRDB start RDB writing and blocked until it finished
 func SaveRDB(db *Server, args [][]byte) redis.Reply {
-	if db.persister == nil {
-		return protocol.MakeErrReply("please enable aof before using save")
-	}
-	rdbFilename := config.Properties.RDBFilename
-	if rdbFilename == "" {
-		rdbFilename = "dump.rdb"
+	if !atomic.CompareAndSwapInt32(&db.rdbSaving, 0, 1) {
+		return protocol.MakeErrReply("ERR Background save already in progress")
 	}
-	err := db.persister.GenerateRDB(rdbFilename)
+	defer atomic.StoreInt32(&db.rdbSaving, 0)
+	err := db.saveRDB()
 	if err != nil {
 		return protocol.MakeErrReply(err.Error())
 	}
@@ -392,20 +698,17 @@ func SaveRDB(db *Server, args [][]byte) redis.Reply {
 
 // BGSaveRDB asynchronously save RDB
 func BGSaveRDB(db *Server, args [][]byte) redis.Reply {
-	if db.persister == nil {
-		return protocol.MakeErrReply("please enable aof before using save")
+	if !atomic.CompareAndSwapInt32(&db.rdbSaving, 0, 1) {
+		return protocol.MakeErrReply("ERR Background save already in progress")
 	}
 	go func() {
 		defer func() {
 			if err := recover(); err != nil {
 				logger.Error(err)
 			}
+			atomic.StoreInt32(&db.rdbSaving, 0)
 		}()
-		rdbFilename := config.Properties.RDBFilename
-		if rdbFilename == "" {
-			rdbFilename = "dump.rdb"
-		}
-		err := db.persister.GenerateRDB(rdbFilename)
+		err := db.saveRDB()
 		if err != nil {
 			logger.Error(err)
 		}
@@ -413,6 +716,29 @@ func BGSaveRDB(db *Server, args [][]byte) redis.Reply {
 	return protocol.MakeStatusReply("Background saving started")
 }
 
+// saveRDB writes rdb file from aof file if aof is enabled, otherwise dumps keyspace directly
+func (server *Server) saveRDB() (err error) {
+	defer func() {
+		if err != nil {
+			atomic.StoreInt32(&server.rdbSaveFailed, 1)
+			return
+		}
+		atomic.StoreInt32(&server.rdbSaveFailed, 0)
+		atomic.StoreInt64(&server.lastSaveTime, time.Now().Unix())
+	}()
+	rdbFilename := config.Properties.RDBFilename
+	if rdbFilename == "" {
+		rdbFilename = "dump.rdb"
+	}
+	if server.persister != nil {
+		return server.persister.GenerateRDB(rdbFilename)
+	}
+	start := time.Now()
+	err = aof.SaveRDB(rdbFilename, server)
+	server.latency.addSampleIfNeeded("rdb-snapshot", time.Since(start))
+	return err
+}
+
 // GetDBSize returns keys count and ttl key count
 func (server *Server) GetDBSize(dbIndex int) (int, int) {
 	db := server.mustSelectDB(dbIndex)
@@ -421,32 +747,42 @@ func (server *Server) GetDBSize(dbIndex int) (int, int) {
 
 func (server *Server) startReplCron() {
 	go func(mdb *Server) {
-		ticker := time.Tick(time.Second * 10)
-		for range ticker {
-			mdb.slaveCron()
-			mdb.masterCron()
+		ticker := time.NewTicker(time.Second * 10)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				mdb.slaveCron()
+				mdb.masterCron()
+			case <-mdb.done:
+				return
+			}
 		}
 	}(server)
 }
 
-// GetAvgTTL Calculate the average expiration time of keys
+// GetAvgTTL estimates the average ttl in milliseconds of keys with ttl by sampling, like avg_ttl in INFO keyspace
 func (server *Server) GetAvgTTL(dbIndex, randomKeyCount int) int64 {
-	var ttlCount int64
+	var ttlSum, ttlCount int64
 	db := server.mustSelectDB(dbIndex)
-	keys := db.data.RandomKeys(randomKeyCount)
+	keys := db.ttlMap.RandomKeys(randomKeyCount)
+	now := clock.Now()
 	for _, k := range keys {
-		t := time.Now()
 		rawExpireTime, ok := db.ttlMap.Get(k)
 		if !ok {
 			continue
 		}
 		expireTime, _ := rawExpireTime.(time.Time)
 		// if the key has already reached its expiration time during calculation, ignore it
-		if expireTime.Sub(t).Microseconds() > 0 {
-			ttlCount += expireTime.Sub(t).Microseconds()
+		if ttl := expireTime.Sub(now).Milliseconds(); ttl > 0 {
+			ttlSum += ttl
+			ttlCount++
 		}
 	}
-	return ttlCount / int64(len(keys))
+	if ttlCount == 0 {
+		return 0
+	}
+	return ttlSum / ttlCount
 }
 
 func (server *Server) SetKeyInsertedCallback(cb database.KeyEventCallback) {
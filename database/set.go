@@ -6,8 +6,8 @@ import (
 	"github.com/hdt3213/godis/interface/redis"
 	"github.com/hdt3213/godis/lib/utils"
 	"github.com/hdt3213/godis/redis/protocol"
+	"math"
 	"strconv"
-	"strings"
 )
 
 func (db *DB) getAsSet(key string) (*HashSet.Set, protocol.ErrorReply) {
@@ -108,38 +108,91 @@ func execSPop(db *DB, args [][]byte) redis.Reply {
 		return protocol.MakeErrReply("ERR wrong number of arguments for 'spop' command")
 	}
 	key := string(args[0])
+	count := 1
+	if len(args) == 2 {
+		count64, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || count64 < 0 {
+			return protocol.MakeErrReply("ERR value is out of range, must be positive")
+		}
+		count = int(count64)
+	}
 
 	set, errReply := db.getAsSet(key)
 	if errReply != nil {
 		return errReply
 	}
 	if set == nil {
+		if len(args) == 2 {
+			return &protocol.EmptyMultiBulkReply{}
+		}
 		return &protocol.NullBulkReply{}
 	}
+	if count == 0 {
+		return &protocol.EmptyMultiBulkReply{}
+	}
 
-	count := 1
-	if len(args) == 2 {
-		count64, err := strconv.ParseInt(string(args[1]), 10, 64)
-		if err != nil || count64 <= 0 {
-			return protocol.MakeErrReply("ERR value is out of range, must be positive")
+	var members []string
+	if count >= set.Len() {
+		// pop all members
+		members = set.ToSlice()
+		db.Remove(key)
+	} else {
+		members = set.RandomDistinctMembers(count)
+		for _, member := range members {
+			set.Remove(member)
 		}
-		count = int(count64)
 	}
-	if count > set.Len() {
-		count = set.Len()
+	result := make([][]byte, len(members))
+	for i, member := range members {
+		result[i] = []byte(member)
 	}
+	// popped members are random, so replay them as SREM to get the same result
+	db.addAof(utils.ToCmdLine2("srem", append([]string{key}, members...)...))
+	return protocol.MakeMultiBulkReply(result)
+}
 
-	members := set.RandomDistinctMembers(count)
-	result := make([][]byte, len(members))
-	for i, v := range members {
-		set.Remove(v)
-		result[i] = []byte(v)
+func prepareSMove(args [][]byte) ([]string, []string) {
+	return []string{string(args[0]), string(args[1])}, nil
+}
+
+func undoSMove(db *DB, args [][]byte) []CmdLine {
+	member := string(args[2])
+	undoCmdLines := rollbackSetMembers(db, string(args[0]), member)
+	return append(undoCmdLines, rollbackSetMembers(db, string(args[1]), member)...)
+}
+
+// execSMove usage: SMOVE source destination member
+// moves member from source to destination atomically, returns 0 if member is not in source
+func execSMove(db *DB, args [][]byte) redis.Reply {
+	srcKey := string(args[0])
+	destKey := string(args[1])
+	member := string(args[2])
+
+	srcSet, errReply := db.getAsSet(srcKey)
+	if errReply != nil {
+		return errReply
+	}
+	destSet, errReply := db.getAsSet(destKey)
+	if errReply != nil {
+		return errReply
+	}
+	if srcSet == nil || !srcSet.Has(member) {
+		return protocol.MakeIntReply(0)
+	}
+	if srcKey == destKey {
+		return protocol.MakeIntReply(1)
 	}
 
-	if count > 0 {
-		db.addAof(utils.ToCmdLine3("spop", args...))
+	srcSet.Remove(member)
+	if srcSet.Len() == 0 {
+		db.Remove(srcKey)
 	}
-	return protocol.MakeMultiBulkReply(result)
+	if destSet == nil {
+		destSet, _, _ = db.getOrInitSet(destKey)
+	}
+	destSet.Add(member)
+	db.addAof(utils.ToCmdLine3("smove", args...))
+	return protocol.MakeIntReply(1)
 }
 
 // execSCard gets the number of members in a set
@@ -312,94 +365,67 @@ func execSDiffStore(db *DB, args [][]byte) redis.Reply {
 	return protocol.MakeIntReply(int64(result.Len()))
 }
 
-// execSRandMember gets random members from set
+// execSRandMember gets random members from set,
+// positive count returns distinct members, negative count allows the same member to be returned multiple times
 func execSRandMember(db *DB, args [][]byte) redis.Reply {
 	if len(args) != 1 && len(args) != 2 {
 		return protocol.MakeErrReply("ERR wrong number of arguments for 'srandmember' command")
 	}
 	key := string(args[0])
+	count := 1
+	if len(args) == 2 {
+		count64, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if count64 < -math.MaxInt64/2 {
+			return protocol.MakeErrReply("ERR value is out of range")
+		}
+		count = int(count64)
+	}
 
-	// get or init entity
 	set, errReply := db.getAsSet(key)
 	if errReply != nil {
 		return errReply
 	}
-	if set == nil {
-		return &protocol.NullBulkReply{}
-	}
 	if len(args) == 1 {
 		// get a random member
+		if set == nil {
+			return &protocol.NullBulkReply{}
+		}
 		members := set.RandomMembers(1)
 		return protocol.MakeBulkReply([]byte(members[0]))
 	}
-	count64, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
-		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	if set == nil || count == 0 {
+		return &protocol.EmptyMultiBulkReply{}
 	}
-	count := int(count64)
+	var members []string
 	if count > 0 {
-		members := set.RandomDistinctMembers(count)
-		result := make([][]byte, len(members))
-		for i, v := range members {
-			result[i] = []byte(v)
-		}
-		return protocol.MakeMultiBulkReply(result)
-	} else if count < 0 {
-		members := set.RandomMembers(-count)
-		result := make([][]byte, len(members))
-		for i, v := range members {
-			result[i] = []byte(v)
-		}
-		return protocol.MakeMultiBulkReply(result)
+		members = set.RandomDistinctMembers(count)
+	} else {
+		members = set.RandomMembers(-count)
+	}
+	result := make([][]byte, len(members))
+	for i, member := range members {
+		result[i] = []byte(member)
 	}
-	return &protocol.EmptyMultiBulkReply{}
+	return protocol.MakeMultiBulkReply(result)
 }
 
+// execSScan iterates members of a set with cursor
 func execSScan(db *DB, args [][]byte) redis.Reply {
-	var count int = 10
-	var pattern string = "*"
-	if len(args) > 2 {
-		for i := 2; i < len(args); i++ {
-			arg := strings.ToLower(string(args[i]))
-			if arg == "count" {
-				count0, err := strconv.Atoi(string(args[i+1]))
-				if err != nil {
-					return &protocol.SyntaxErrReply{}
-				}
-				count = count0
-				i++
-			} else if arg == "match" {
-				pattern = string(args[i+1])
-				i++
-			} else {
-				return &protocol.SyntaxErrReply{}
-			}
-		}
+	opts, errReply := parseScanOptions(args[1:], false, false)
+	if errReply != nil {
+		return errReply
 	}
-	key := string(args[0])
-	// get entity
-	set, errReply := db.getAsSet(key)
+	set, errReply := db.getAsSet(string(args[0]))
 	if errReply != nil {
 		return errReply
 	}
 	if set == nil {
-		return &protocol.EmptyMultiBulkReply{}
-	}
-	cursor, err := strconv.Atoi(string(args[1]))
-	if err != nil {
-		return protocol.MakeErrReply("ERR invalid cursor")
+		return makeScanReply(nil, 0)
 	}
-
-	keysReply, nextCursor := set.SetScan(cursor, count, pattern)
-	if nextCursor < 0 {
-		return protocol.MakeErrReply("Invalid argument")
-	}
-
-	result := make([]redis.Reply, 2)
-	result[0] = protocol.MakeBulkReply([]byte(strconv.FormatInt(int64(nextCursor), 10)))
-	result[1] = protocol.MakeMultiBulkReply(keysReply)
-
-	return protocol.MakeMultiRawReply(result)
+	return makeScanReply(set.SetScan(opts.cursor, opts.count, opts.pattern))
 }
 
 func init() {
@@ -409,7 +435,9 @@ func init() {
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
 	registerCommand("SRem", execSRem, writeFirstKey, undoSetChange, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
-	registerCommand("SPop", execSPop, writeFirstKey, undoSetChange, -2, flagWrite).
+	registerCommand("SMove", execSMove, prepareSMove, undoSMove, 4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 2, 1)
+	registerCommand("SPop", execSPop, writeFirstKey, rollbackFirstKey, -2, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagRandom, redisFlagFast}, 1, 1, 1)
 	registerCommand("SCard", execSCard, readFirstKey, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
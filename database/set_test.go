@@ -97,6 +97,46 @@ func TestSPop(t *testing.T) {
 	}
 }
 
+func TestSPopCount(t *testing.T) {
+	db := makeTestDB()
+	var aofLines []CmdLine
+	db.addAof = func(line CmdLine) {
+		aofLines = append(aofLines, line)
+	}
+	key := utils.RandString(10)
+	db.Exec(nil, utils.ToCmdLine("sadd", key, "a", "b", "c", "d"))
+	aofLines = nil
+
+	result := db.Exec(nil, utils.ToCmdLine("spop", key, "2"))
+	asserts.AssertMultiBulkReplySize(t, result, 2)
+	popped := result.(*protocol.MultiBulkReply).Args
+	// popped members are propagated as SREM
+	if len(aofLines) != 1 || string(aofLines[0][0]) != "srem" || len(aofLines[0]) != 4 ||
+		string(aofLines[0][2]) != string(popped[0]) || string(aofLines[0][3]) != string(popped[1]) {
+		t.Errorf("unexpected aof %q", aofLines)
+	}
+	result = db.Exec(nil, utils.ToCmdLine("spop", key, "0"))
+	asserts.AssertMultiBulkReplySize(t, result, 0)
+	result = db.Exec(nil, utils.ToCmdLine("spop", key, "10"))
+	asserts.AssertMultiBulkReplySize(t, result, 2)
+	result = db.Exec(nil, utils.ToCmdLine("exists", key))
+	asserts.AssertIntReply(t, result, 0)
+	result = db.Exec(nil, utils.ToCmdLine("spop", key, "1"))
+	asserts.AssertMultiBulkReplySize(t, result, 0)
+	result = db.Exec(nil, utils.ToCmdLine("spop", key))
+	asserts.AssertNullBulk(t, result)
+	result = db.Exec(nil, utils.ToCmdLine("spop", key, "-1"))
+	asserts.AssertErrReply(t, result, "ERR value is out of range, must be positive")
+
+	result = db.Exec(nil, utils.ToCmdLine("srandmember", key, "3"))
+	asserts.AssertMultiBulkReplySize(t, result, 0)
+	result = db.Exec(nil, utils.ToCmdLine("srandmember", key))
+	asserts.AssertNullBulk(t, result)
+	db.Exec(nil, utils.ToCmdLine("sadd", key, "a"))
+	result = db.Exec(nil, utils.ToCmdLine("srandmember", key, "-3"))
+	asserts.AssertMultiBulkReply(t, result, []string{"a", "a", "a"})
+}
+
 func TestSInter(t *testing.T) {
 	testDB.Flush()
 	size := 100
@@ -285,3 +325,66 @@ func TestSScan(t *testing.T) {
 		}
 	}
 }
+
+func TestSScanLargeSet(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	size := 1000
+	for i := 0; i < size; i++ {
+		testDB.Exec(nil, utils.ToCmdLine("sadd", key, strconv.Itoa(i)))
+	}
+	members := scanAll(t, func(cursor string) [][]byte {
+		return utils.ToCmdLine("sscan", key, cursor, "count", "50")
+	})
+	if len(utils.RemoveDuplicates(members)) != size {
+		t.Errorf("expect %d members, actually %d", size, len(members))
+	}
+}
+
+func TestSMove(t *testing.T) {
+	testDB.Flush()
+	src := utils.RandString(10)
+	dest := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("sadd", src, "a", "b"))
+	result := testDB.Exec(nil, utils.ToCmdLine("smove", src, dest, "a"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("sismember", src, "a"))
+	asserts.AssertIntReply(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("sismember", dest, "a"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("smove", src, dest, "nosuchmember"))
+	asserts.AssertIntReply(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("smove", src, src, "b"))
+	asserts.AssertIntReply(t, result, 1)
+
+	// source is removed after its last member is moved
+	result = testDB.Exec(nil, utils.ToCmdLine("smove", src, dest, "b"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("exists", src))
+	asserts.AssertIntReply(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("scard", dest))
+	asserts.AssertIntReply(t, result, 2)
+
+	testDB.Exec(nil, utils.ToCmdLine("set", "str", "1"))
+	result = testDB.Exec(nil, utils.ToCmdLine("smove", dest, "str", "a"))
+	asserts.AssertErrReply(t, result, "WRONGTYPE Operation against a key holding the wrong kind of value")
+	result = testDB.Exec(nil, utils.ToCmdLine("scard", dest))
+	asserts.AssertIntReply(t, result, 2)
+}
+
+func TestUndoSMove(t *testing.T) {
+	testDB.Flush()
+	src := utils.RandString(10)
+	dest := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("sadd", src, "a", "b"))
+	cmdLine := utils.ToCmdLine("smove", src, dest, "a")
+	undoCmdLines := undoSMove(testDB, cmdLine[1:])
+	testDB.Exec(nil, cmdLine)
+	for _, cmdLine := range undoCmdLines {
+		testDB.Exec(nil, cmdLine)
+	}
+	result := testDB.Exec(nil, utils.ToCmdLine("sismember", src, "a"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("exists", dest))
+	asserts.AssertIntReply(t, result, 0)
+}
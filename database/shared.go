@@ -0,0 +1,62 @@
+package database
+
+import (
+	"math"
+	"strconv"
+)
+
+// sharedIntegers is the count of small integers shared by string values, same as OBJ_SHARED_INTEGERS of redis
+const sharedIntegers = 10000
+
+// sharedRefCount is the refcount reported for shared values, same as OBJ_SHARED_REFCOUNT of redis
+const sharedRefCount = math.MaxInt32
+
+// sharedIntegerBytes holds string representation of 0 ~ sharedIntegers-1.
+// Counters and integer-looking values refer to them instead of allocating their own []byte,
+// so that shared values must never be modified in place
+var sharedIntegerBytes [sharedIntegers][]byte
+
+func init() {
+	for i := range sharedIntegerBytes {
+		val := []byte(strconv.Itoa(i))
+		// cap equals len, so that append always allocates a new array
+		sharedIntegerBytes[i] = val[:len(val):len(val)]
+	}
+}
+
+// makeIntString returns string representation of n, using shared one if n is small
+func makeIntString(n int64) []byte {
+	if n >= 0 && n < sharedIntegers {
+		return sharedIntegerBytes[n]
+	}
+	return []byte(strconv.FormatInt(n, 10))
+}
+
+// parseSharedInteger returns index of val in sharedIntegerBytes if val is the canonical form of a small integer
+func parseSharedInteger(val []byte) (int, bool) {
+	if len(val) == 0 || len(val) > 4 || (val[0] == '0' && len(val) > 1) {
+		return 0, false
+	}
+	n := 0
+	for _, c := range val {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, true
+}
+
+// tryEncodeString replaces small integer with the shared one, other values are returned as is
+func tryEncodeString(val []byte) []byte {
+	if n, ok := parseSharedInteger(val); ok {
+		return sharedIntegerBytes[n]
+	}
+	return val
+}
+
+// isSharedValue returns whether val refers to a shared integer
+func isSharedValue(val []byte) bool {
+	n, ok := parseSharedInteger(val)
+	return ok && &val[0] == &sharedIntegerBytes[n][0]
+}
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestSharedIntegers(t *testing.T) {
+	testDB.Flush()
+	key1 := utils.RandString(10)
+	key2 := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("SET", key1, "1"))
+	testDB.Exec(nil, utils.ToCmdLine("INCR", key2))
+	result := testDB.Exec(nil, utils.ToCmdLine("OBJECT", "REFCOUNT", key1))
+	asserts.AssertIntReply(t, result, sharedRefCount)
+	result = testDB.Exec(nil, utils.ToCmdLine("OBJECT", "ENCODING", key2))
+	asserts.AssertBulkReply(t, result, "int")
+
+	// values out of range or not in canonical form are not shared
+	for _, val := range []string{"10000", "-1", "01", "+1", "a"} {
+		testDB.Exec(nil, utils.ToCmdLine("SET", key1, val))
+		result = testDB.Exec(nil, utils.ToCmdLine("OBJECT", "REFCOUNT", key1))
+		asserts.AssertIntReply(t, result, 1)
+	}
+
+	// modifying a key must not affect others sharing the same value
+	testDB.Exec(nil, utils.ToCmdLine("SET", key1, "1"))
+	testDB.Exec(nil, utils.ToCmdLine("SETBIT", key1, "0", "1"))
+	testDB.Exec(nil, utils.ToCmdLine("APPEND", key1, "0"))
+	result = testDB.Exec(nil, utils.ToCmdLine("GET", key2))
+	asserts.AssertBulkReply(t, result, "1")
+	if string(sharedIntegerBytes[1]) != "1" {
+		t.Errorf("shared integer is modified: %s", sharedIntegerBytes[1])
+	}
+}
+
+func TestMakeIntString(t *testing.T) {
+	for _, n := range []int64{0, 9999, 10000, -1, 1 << 40} {
+		val := makeIntString(n)
+		if string(val) != strconv.FormatInt(n, 10) {
+			t.Errorf("expect %d, actual %s", n, val)
+		}
+		if isSharedValue(val) != (n >= 0 && n < sharedIntegers) {
+			t.Errorf("unexpected sharing of %d", n)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/logger"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+const (
+	// shutdownDefault saves rdb if rdb persistence is in use, that is dbfilename is set and appendonly is off
+	shutdownDefault = iota
+	shutdownSave
+	shutdownNoSave
+)
+
+type shutdownStatus struct {
+	// ch is closed after SHUTDOWN succeeded, nil for servers made by MakeAuxiliaryServer
+	ch   chan struct{}
+	once sync.Once
+	// 1 if data has been saved by SHUTDOWN, accessed atomically
+	saved int32
+}
+
+// execShutdown handles SHUTDOWN [NOSAVE|SAVE], the network server stops after it succeeded and then calls Shutdown
+func (server *Server) execShutdown(args [][]byte) redis.Reply {
+	mode := shutdownDefault
+	for _, arg := range args {
+		switch strings.ToLower(string(arg)) {
+		case "save":
+			if mode == shutdownNoSave {
+				return &protocol.SyntaxErrReply{}
+			}
+			mode = shutdownSave
+		case "nosave":
+			if mode == shutdownSave {
+				return &protocol.SyntaxErrReply{}
+			}
+			mode = shutdownNoSave
+		default:
+			return &protocol.SyntaxErrReply{}
+		}
+	}
+	if err := server.saveBeforeShutdown(mode); err != nil {
+		logger.Error("save before shutdown failed: " + err.Error())
+		return protocol.MakeErrReply("ERR Errors trying to SHUTDOWN. Check logs.")
+	}
+	atomic.StoreInt32(&server.shutdown.saved, 1)
+	logger.Info("user requested shutdown...")
+	server.shutdown.once.Do(func() {
+		if server.shutdown.ch != nil {
+			close(server.shutdown.ch)
+		}
+	})
+	return protocol.MakeOkReply()
+}
+
+func (server *Server) saveBeforeShutdown(mode int) error {
+	switch mode {
+	case shutdownNoSave:
+		return nil
+	case shutdownDefault:
+		if config.Properties.RDBFilename == "" || config.Properties.AppendOnly {
+			return nil
+		}
+	}
+	// wait for running BGSAVE
+	for !atomic.CompareAndSwapInt32(&server.rdbSaving, 0, 1) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer atomic.StoreInt32(&server.rdbSaving, 0)
+	logger.Info("saving the final rdb snapshot before exiting")
+	return server.saveRDB()
+}
+
+// ShutdownChan returns a channel closed after SHUTDOWN succeeded
+func (server *Server) ShutdownChan() <-chan struct{} {
+	return server.shutdown.ch
+}
+
+// Shutdown saves data like SHUTDOWN without arguments unless SHUTDOWN has done it, then closes server.
+// AOF buffer is flushed and replicas are disconnected by Close, logs are flushed at last
+func (server *Server) Shutdown() {
+	if atomic.LoadInt32(&server.shutdown.saved) == 0 {
+		if err := server.saveBeforeShutdown(shutdownDefault); err != nil {
+			logger.Error("save before shutdown failed: " + err.Error())
+		}
+	}
+	server.Close()
+	_ = logger.Flush()
+}
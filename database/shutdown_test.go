@@ -0,0 +1,57 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestShutdown(t *testing.T) {
+	backup := config.Properties
+	defer func() {
+		config.Properties = backup
+	}()
+	rdbFilename := filepath.Join(t.TempDir(), "dump.rdb")
+	config.Properties = &config.ServerProperties{
+		RDBFilename: rdbFilename,
+	}
+	server := NewStandaloneServer()
+	c := connection.NewFakeConn()
+	server.Exec(c, utils.ToCmdLine("set", "a", "a"))
+
+	result := server.Exec(c, utils.ToCmdLine("shutdown", "save", "nosave"))
+	asserts.AssertErrReply(t, result, "Err syntax error")
+	select {
+	case <-server.ShutdownChan():
+		t.Error("server should not be shut down")
+	default:
+	}
+
+	result = server.Exec(c, utils.ToCmdLine("shutdown"))
+	if !protocol.IsOKReply(result) {
+		t.Errorf("expect ok, actual: %s", result.ToBytes())
+	}
+	select {
+	case <-server.ShutdownChan():
+	default:
+		t.Error("shutdown chan should be closed")
+	}
+	if _, err := os.Stat(rdbFilename); err != nil {
+		t.Errorf("expect rdb file saved: %v", err)
+	}
+	server.Shutdown()
+	// Close is idempotent
+	server.Close()
+
+	config.Properties.RDBFilename = rdbFilename
+	loaded := NewStandaloneServer()
+	defer loaded.Close()
+	result = loaded.Exec(c, utils.ToCmdLine("get", "a"))
+	asserts.AssertBulkReply(t, result, "a")
+}
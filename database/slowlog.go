@@ -2,10 +2,12 @@ package database
 
 import (
 	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/clock"
 	"github.com/hdt3213/godis/redis/protocol"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,6 +27,7 @@ type SlowLogger struct {
 	nextIdx    int
 	maxEntries int
 
+	// threshold in microseconds, accessed atomically
 	threshold   int64
 	nextID      int64
 	logCommands [][]byte
@@ -42,18 +45,21 @@ func NewSlowLogger(maxEntries int, threshold int64) *SlowLogger {
 }
 
 func (sl *SlowLogger) Record(start time.Time, args [][]byte, client string) {
-	if sl == nil || len(sl.entries) == 0 {
+	if sl == nil {
 		return
 	}
-	duration := time.Since(start)
+	duration := clock.Since(start)
 	micros := duration.Microseconds()
 
-	if micros < sl.threshold {
+	if micros < atomic.LoadInt64(&sl.threshold) {
 		return
 	}
 
 	sl.mu.Lock()
 	defer sl.mu.Unlock()
+	if sl.maxEntries == 0 {
+		return
+	}
 
 	entry := &SlowLogEntry{
 		ID:        sl.nextID,
@@ -116,6 +122,32 @@ func (sl *SlowLogger) Reset() {
 	sl.nextID = 1
 }
 
+// SetThreshold changes the threshold in microseconds of slow commands
+func (sl *SlowLogger) SetThreshold(threshold int64) {
+	atomic.StoreInt64(&sl.threshold, threshold)
+}
+
+// SetMaxEntries changes the capacity of slow log, the latest entries are kept
+func (sl *SlowLogger) SetMaxEntries(maxEntries int) {
+	if maxEntries < 0 {
+		maxEntries = 0
+	}
+	latest := sl.GetEntries(maxEntries)
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.entries = make([]*SlowLogEntry, maxEntries)
+	sl.maxEntries = maxEntries
+	sl.count = len(latest)
+	// GetEntries returns entries from the newest to the oldest
+	for i, entry := range latest {
+		sl.entries[len(latest)-1-i] = entry
+	}
+	sl.nextIdx = 0
+	if maxEntries > 0 {
+		sl.nextIdx = len(latest) % maxEntries
+	}
+}
+
 // HandleSlowlogCommand Process SLOWLOG command
 func (sl *SlowLogger) HandleSlowlogCommand(args [][]byte) redis.Reply {
 	argsLen := len(args)
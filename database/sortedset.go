@@ -110,6 +110,27 @@ func execZScore(db *DB, args [][]byte) redis.Reply {
 	return protocol.MakeBulkReply([]byte(value))
 }
 
+// execZMScore gets scores of multiple members in sortedset, nil for members not existed
+func execZMScore(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([][]byte, len(args)-1)
+	if sortedSet == nil {
+		return protocol.MakeMultiBulkReply(result)
+	}
+	for i, arg := range args[1:] {
+		element, exists := sortedSet.Get(string(arg))
+		if !exists {
+			continue
+		}
+		result[i] = []byte(strconv.FormatFloat(element.Score, 'f', -1, 64))
+	}
+	return protocol.MakeMultiBulkReply(result)
+}
+
 // execZRank gets index of a member in sortedset, ascending order, start from 0
 func execZRank(db *DB, args [][]byte) redis.Reply {
 	// parse args
@@ -171,29 +192,212 @@ func execZCard(db *DB, args [][]byte) redis.Reply {
 	return protocol.MakeIntReply(sortedSet.Len())
 }
 
-// execZRange gets members in range, sort by score in ascending order
-func execZRange(db *DB, args [][]byte) redis.Reply {
-	// parse args
-	if len(args) != 3 && len(args) != 4 {
-		return protocol.MakeErrReply("ERR wrong number of arguments for 'zrange' command")
+// zrangeSpec is the parsed range of unified ZRANGE syntax:
+// start stop [BYSCORE | BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+type zrangeSpec struct {
+	start      string
+	stop       string
+	byScore    bool
+	byLex      bool
+	rev        bool
+	offset     int64
+	limit      int64 // negative means no limit
+	hasLimit   bool
+	withScores bool
+}
+
+// parseZRangeSpec parses args after key, WITHSCORES is not allowed if store is true
+func parseZRangeSpec(args [][]byte, store bool) (*zrangeSpec, protocol.ErrorReply) {
+	spec := &zrangeSpec{
+		start: string(args[0]),
+		stop:  string(args[1]),
+		limit: -1,
+	}
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "BYSCORE":
+			spec.byScore = true
+		case "BYLEX":
+			spec.byLex = true
+		case "REV":
+			spec.rev = true
+		case "WITHSCORES":
+			if store {
+				return nil, &protocol.SyntaxErrReply{}
+			}
+			spec.withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return nil, &protocol.SyntaxErrReply{}
+			}
+			var err error
+			spec.offset, err = strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			spec.limit, err = strconv.ParseInt(string(args[i+2]), 10, 64)
+			if err != nil {
+				return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			spec.hasLimit = true
+			i += 2
+		default:
+			return nil, &protocol.SyntaxErrReply{}
+		}
 	}
-	withScores := false
-	if len(args) == 4 {
-		if strings.ToUpper(string(args[3])) != "WITHSCORES" {
-			return protocol.MakeErrReply("syntax error")
+	if spec.byScore && spec.byLex {
+		return nil, &protocol.SyntaxErrReply{}
+	}
+	if spec.hasLimit && !spec.byScore && !spec.byLex {
+		return nil, protocol.MakeErrReply("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if spec.withScores && spec.byLex {
+		return nil, protocol.MakeErrReply("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	return spec, nil
+}
+
+// normalizeRankRange converts start and stop of ZRANGE into [start, stop), returns false if the range is empty
+func normalizeRankRange(start int64, stop int64, size int64) (int64, int64, bool) {
+	if start < 0 {
+		start += size
+		if start < 0 {
+			start = 0
 		}
-		withScores = true
 	}
-	key := string(args[0])
-	start, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
-		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	if stop < 0 {
+		stop += size
 	}
-	stop, err := strconv.ParseInt(string(args[2]), 10, 64)
-	if err != nil {
-		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	if stop >= size {
+		stop = size - 1
+	}
+	if start >= size || start > stop {
+		return 0, 0, false
+	}
+	return start, stop + 1, true
+}
+
+// selectZRange returns elements of sorted set selected by spec
+func selectZRange(sortedSet *SortedSet.SortedSet, spec *zrangeSpec) ([]*SortedSet.Element, protocol.ErrorReply) {
+	if !spec.byScore && !spec.byLex {
+		start, err := strconv.ParseInt(spec.start, 10, 64)
+		if err != nil {
+			return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		stop, err := strconv.ParseInt(spec.stop, 10, 64)
+		if err != nil {
+			return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if sortedSet == nil {
+			return nil, nil
+		}
+		start, stop, ok := normalizeRankRange(start, stop, sortedSet.Len())
+		if !ok {
+			return nil, nil
+		}
+		return sortedSet.RangeByRank(start, stop, spec.rev), nil
+	}
+
+	// borders are given as max and min in reversed order
+	minArg, maxArg := spec.start, spec.stop
+	if spec.rev {
+		minArg, maxArg = spec.stop, spec.start
+	}
+	var min, max SortedSet.Border
+	var err error
+	if spec.byScore {
+		if min, err = SortedSet.ParseScoreBorder(minArg); err != nil {
+			return nil, protocol.MakeErrReply(err.Error())
+		}
+		if max, err = SortedSet.ParseScoreBorder(maxArg); err != nil {
+			return nil, protocol.MakeErrReply(err.Error())
+		}
+	} else {
+		if min, err = SortedSet.ParseLexBorder(minArg); err != nil {
+			return nil, protocol.MakeErrReply(err.Error())
+		}
+		if max, err = SortedSet.ParseLexBorder(maxArg); err != nil {
+			return nil, protocol.MakeErrReply(err.Error())
+		}
+	}
+	if sortedSet == nil {
+		return nil, nil
 	}
-	return range0(db, key, start, stop, withScores, false)
+	return sortedSet.Range(min, max, spec.offset, spec.limit, spec.rev), nil
+}
+
+func makeZRangeReply(elements []*SortedSet.Element, withScores bool) redis.Reply {
+	if withScores {
+		result := make([][]byte, 0, len(elements)*2)
+		for _, element := range elements {
+			scoreStr := strconv.FormatFloat(element.Score, 'f', -1, 64)
+			result = append(result, []byte(element.Member), []byte(scoreStr))
+		}
+		return protocol.MakeMultiBulkReply(result)
+	}
+	result := make([][]byte, 0, len(elements))
+	for _, element := range elements {
+		result = append(result, []byte(element.Member))
+	}
+	return protocol.MakeMultiBulkReply(result)
+}
+
+// execZRange usage: ZRANGE key start stop [BYSCORE | BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func execZRange(db *DB, args [][]byte) redis.Reply {
+	spec, errReply := parseZRangeSpec(args[1:], false)
+	if errReply != nil {
+		return errReply
+	}
+	sortedSet, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	elements, errReply := selectZRange(sortedSet, spec)
+	if errReply != nil {
+		return errReply
+	}
+	return makeZRangeReply(elements, spec.withScores)
+}
+
+func prepareZRangeStore(args [][]byte) ([]string, []string) {
+	return []string{string(args[0])}, []string{string(args[1])}
+}
+
+// execZRangeStore usage: ZRANGESTORE dst src min max [BYSCORE | BYLEX] [REV] [LIMIT offset count]
+// it replaces dst with the selected range, dst will be removed if the range is empty
+func execZRangeStore(db *DB, args [][]byte) redis.Reply {
+	dest := string(args[0])
+	spec, errReply := parseZRangeSpec(args[2:], true)
+	if errReply != nil {
+		return errReply
+	}
+	sortedSet, errReply := db.getAsSortedSet(string(args[1]))
+	if errReply != nil {
+		return errReply
+	}
+	elements, errReply := selectZRange(sortedSet, spec)
+	if errReply != nil {
+		return errReply
+	}
+	db.storeSortedSet(dest, elements)
+	db.addAof(utils.ToCmdLine3("zrangestore", args...))
+	return protocol.MakeIntReply(int64(len(elements)))
+}
+
+// storeSortedSet replaces dest with a sorted set made of the given elements, dest will be removed if no element given
+func (db *DB) storeSortedSet(dest string, elements []*SortedSet.Element) {
+	if len(elements) == 0 {
+		db.Remove(dest)
+		return
+	}
+	result := SortedSet.Make()
+	for _, element := range elements {
+		result.Add(element.Member, element.Score)
+	}
+	db.Persist(dest)
+	db.PutEntity(dest, &database.DataEntity{
+		Data: result,
+	})
 }
 
 // execZRevRange gets members in range, sort by score in descending order
@@ -662,7 +866,7 @@ func execZRangeByLex(db *DB, args [][]byte) redis.Reply {
 		return errReply
 	}
 	if sortedSet == nil {
-		return protocol.MakeIntReply(0)
+		return protocol.MakeEmptyMultiBulkReply()
 	}
 
 	minEle, maxEle := string(args[1]), string(args[2])
@@ -732,7 +936,9 @@ func execZRemRangeByLex(db *DB, args [][]byte) redis.Reply {
 	}
 
 	count := sortedSet.RemoveRange(min, max)
-
+	if count > 0 {
+		db.addAof(utils.ToCmdLine3("zremrangebylex", args...))
+	}
 	return protocol.MakeIntReply(count)
 }
 
@@ -751,7 +957,7 @@ func execZRevRangeByLex(db *DB, args [][]byte) redis.Reply {
 		return errReply
 	}
 	if sortedSet == nil {
-		return protocol.MakeIntReply(0)
+		return protocol.MakeEmptyMultiBulkReply()
 	}
 
 	minEle, maxEle := string(args[2]), string(args[1])
@@ -795,51 +1001,394 @@ func execZRevRangeByLex(db *DB, args [][]byte) redis.Reply {
 	return protocol.MakeMultiBulkReply(result)
 }
 
-func execZScan(db *DB, args [][]byte) redis.Reply {
-	var count int = 10
-	var pattern string = "*"
-	if len(args) > 2 {
-		for i := 2; i < len(args); i++ {
-			arg := strings.ToLower(string(args[i]))
-			if arg == "count" {
-				count0, err := strconv.Atoi(string(args[i+1]))
-				if err != nil {
-					return &protocol.SyntaxErrReply{}
-				}
-				count = count0
-				i++
-			} else if arg == "match" {
-				pattern = string(args[i+1])
-				i++
-			} else {
-				return &protocol.SyntaxErrReply{}
-			}
+// execZRandMember gets random members from sorted set,
+// positive count returns distinct members, while negative count allows the same member appearing more than once
+func execZRandMember(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	if len(args) > 3 {
+		return protocol.MakeErrReply("ERR wrong number of arguments for 'zrandmember' command")
+	}
+	withScores := false
+	if len(args) == 3 {
+		if strings.ToLower(string(args[2])) != "withscores" {
+			return protocol.MakeSyntaxErrReply()
 		}
+		withScores = true
 	}
-	key := string(args[0])
-	// get entity
-	set, errReply := db.getAsSortedSet(key)
+	count := int64(1)
+	if len(args) >= 2 {
+		var err error
+		count, err = strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if count < -math.MaxInt64/2 {
+			return protocol.MakeErrReply("ERR value is out of range")
+		}
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
 	if errReply != nil {
 		return errReply
 	}
-	if set == nil {
+	if len(args) == 1 {
+		// without count returns a single member
+		if sortedSet == nil {
+			return &protocol.NullBulkReply{}
+		}
+		return protocol.MakeBulkReply([]byte(sortedSet.RandomElements(1)[0].Member))
+	}
+	if sortedSet == nil || count == 0 {
 		return &protocol.EmptyMultiBulkReply{}
 	}
-	cursor, err := strconv.Atoi(string(args[1]))
+
+	var elements []*SortedSet.Element
+	if count > 0 {
+		elements = sortedSet.RandomDistinctElements(int(count))
+	} else {
+		elements = sortedSet.RandomElements(int(-count))
+	}
+	return makeZRangeReply(elements, withScores)
+}
+
+// zsetOpArgs is parsed arguments of ZUNION, ZINTER, ZDIFF, ZINTERCARD and their STORE variants
+type zsetOpArgs struct {
+	keys       []string
+	weights    []float64
+	aggregate  string // sum, min or max
+	withScores bool
+	limit      int64 // LIMIT of ZINTERCARD, 0 means unlimited
+}
+
+const (
+	zsetOpAllowWeights = 1 << iota
+	zsetOpAllowWithScores
+	zsetOpAllowLimit
+)
+
+// parseZSetOpArgs parses `numkeys key [key ...]` and the options allowed by flags
+func parseZSetOpArgs(cmdName string, args [][]byte, flags int) (*zsetOpArgs, protocol.ErrorReply) {
+	numKeys, err := strconv.Atoi(string(args[0]))
 	if err != nil {
-		return protocol.MakeErrReply("ERR invalid cursor")
+		return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if numKeys <= 0 {
+		if flags&zsetOpAllowLimit > 0 {
+			return nil, protocol.MakeErrReply("ERR numkeys should be greater than 0")
+		}
+		return nil, protocol.MakeErrReply("ERR at least 1 input key is needed for '" + cmdName + "' command")
+	}
+	if numKeys > len(args)-1 {
+		return nil, &protocol.SyntaxErrReply{}
+	}
+	result := &zsetOpArgs{
+		keys:      make([]string, numKeys),
+		aggregate: "sum",
+	}
+	for i := 0; i < numKeys; i++ {
+		result.keys[i] = string(args[i+1])
+	}
+	for i := numKeys + 1; i < len(args); i++ {
+		arg := strings.ToLower(string(args[i]))
+		switch {
+		case arg == "weights" && flags&zsetOpAllowWeights > 0:
+			if i+numKeys >= len(args) {
+				return nil, &protocol.SyntaxErrReply{}
+			}
+			result.weights = make([]float64, numKeys)
+			for j := 0; j < numKeys; j++ {
+				result.weights[j], err = strconv.ParseFloat(string(args[i+1+j]), 64)
+				if err != nil || math.IsNaN(result.weights[j]) {
+					return nil, protocol.MakeErrReply("ERR weight value is not a float")
+				}
+			}
+			i += numKeys
+		case arg == "aggregate" && flags&zsetOpAllowWeights > 0:
+			if i+1 >= len(args) {
+				return nil, &protocol.SyntaxErrReply{}
+			}
+			result.aggregate = strings.ToLower(string(args[i+1]))
+			if result.aggregate != "sum" && result.aggregate != "min" && result.aggregate != "max" {
+				return nil, &protocol.SyntaxErrReply{}
+			}
+			i++
+		case arg == "withscores" && flags&zsetOpAllowWithScores > 0:
+			result.withScores = true
+		case arg == "limit" && flags&zsetOpAllowLimit > 0:
+			if i+1 >= len(args) {
+				return nil, &protocol.SyntaxErrReply{}
+			}
+			result.limit, err = strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return nil, protocol.MakeErrReply("ERR LIMIT can't be negative")
+			}
+			if result.limit < 0 {
+				return nil, protocol.MakeErrReply("ERR LIMIT can't be negative")
+			}
+			i++
+		default:
+			return nil, &protocol.SyntaxErrReply{}
+		}
+	}
+	return result, nil
+}
+
+// zsetOpKeys returns source keys of a ZUNION like command, returns nil if arguments are illegal
+func zsetOpKeys(args [][]byte) []string {
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil || numKeys <= 0 || numKeys > len(args)-1 {
+		return nil
+	}
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = string(args[i+1])
+	}
+	return keys
+}
+
+func prepareZSetOp(args [][]byte) ([]string, []string) {
+	return nil, zsetOpKeys(args)
+}
+
+func prepareZSetOpStore(args [][]byte) ([]string, []string) {
+	return []string{string(args[0])}, zsetOpKeys(args[1:])
+}
+
+// getSortedSets returns sorted sets of the given keys, missing keys are returned as nil
+func (db *DB) getSortedSets(keys []string) ([]*SortedSet.SortedSet, protocol.ErrorReply) {
+	sets := make([]*SortedSet.SortedSet, len(keys))
+	for i, key := range keys {
+		sortedSet, errReply := db.getAsSortedSet(key)
+		if errReply != nil {
+			return nil, errReply
+		}
+		sets[i] = sortedSet
+	}
+	return sets, nil
+}
+
+// aggregateScore combines scores like redis, sum of +inf and -inf is 0 rather than NaN
+func aggregateScore(aggregate string, a float64, b float64) float64 {
+	switch aggregate {
+	case "min":
+		return math.Min(a, b)
+	case "max":
+		return math.Max(a, b)
+	}
+	sum := a + b
+	if math.IsNaN(sum) {
+		return 0
+	}
+	return sum
+}
+
+func weightedScore(score float64, i int, weights []float64) float64 {
+	if weights == nil {
+		return score
+	}
+	score *= weights[i]
+	if math.IsNaN(score) {
+		// 0 * inf
+		return 0
+	}
+	return score
+}
+
+func zsetUnion(sets []*SortedSet.SortedSet, weights []float64, aggregate string) *SortedSet.SortedSet {
+	result := SortedSet.Make()
+	for i, set := range sets {
+		if set == nil {
+			continue
+		}
+		set.ForEachByRank(0, set.Len(), false, func(element *SortedSet.Element) bool {
+			score := weightedScore(element.Score, i, weights)
+			if existed, ok := result.Get(element.Member); ok {
+				score = aggregateScore(aggregate, existed.Score, score)
+			}
+			result.Add(element.Member, score)
+			return true
+		})
+	}
+	return result
+}
+
+func zsetInter(sets []*SortedSet.SortedSet, weights []float64, aggregate string) *SortedSet.SortedSet {
+	result := SortedSet.Make()
+	for _, set := range sets {
+		if set == nil {
+			return result
+		}
+	}
+	first := sets[0]
+	first.ForEachByRank(0, first.Len(), false, func(element *SortedSet.Element) bool {
+		score := weightedScore(element.Score, 0, weights)
+		for i := 1; i < len(sets); i++ {
+			other, ok := sets[i].Get(element.Member)
+			if !ok {
+				return true
+			}
+			score = aggregateScore(aggregate, score, weightedScore(other.Score, i, weights))
+		}
+		result.Add(element.Member, score)
+		return true
+	})
+	return result
+}
+
+func zsetDiff(sets []*SortedSet.SortedSet) *SortedSet.SortedSet {
+	result := SortedSet.Make()
+	first := sets[0]
+	if first == nil {
+		return result
 	}
+	first.ForEachByRank(0, first.Len(), false, func(element *SortedSet.Element) bool {
+		for _, other := range sets[1:] {
+			if other == nil {
+				continue
+			}
+			if _, ok := other.Get(element.Member); ok {
+				return true
+			}
+		}
+		result.Add(element.Member, element.Score)
+		return true
+	})
+	return result
+}
+
+// zsetElements returns all elements of sorted set in ascending order
+func zsetElements(sortedSet *SortedSet.SortedSet) []*SortedSet.Element {
+	if sortedSet.Len() == 0 {
+		return nil
+	}
+	return sortedSet.RangeByRank(0, sortedSet.Len(), false)
+}
+
+// execZSetOp executes ZUNION, ZINTER and ZDIFF
+func execZSetOp(db *DB, cmdName string, args [][]byte, flags int,
+	calculate func(sets []*SortedSet.SortedSet, parsed *zsetOpArgs) *SortedSet.SortedSet) redis.Reply {
+	parsed, errReply := parseZSetOpArgs(cmdName, args, flags)
+	if errReply != nil {
+		return errReply
+	}
+	sets, errReply := db.getSortedSets(parsed.keys)
+	if errReply != nil {
+		return errReply
+	}
+	return makeZRangeReply(zsetElements(calculate(sets, parsed)), parsed.withScores)
+}
 
-	keysReply, nextCursor := set.ZSetScan(cursor, count, pattern)
-	if nextCursor < 0 {
-		return protocol.MakeErrReply("Invalid argument")
+// execZSetOpStore executes ZUNIONSTORE, ZINTERSTORE and ZDIFFSTORE, destination is replaced by the result
+func execZSetOpStore(db *DB, cmdName string, args [][]byte, flags int,
+	calculate func(sets []*SortedSet.SortedSet, parsed *zsetOpArgs) *SortedSet.SortedSet) redis.Reply {
+	dest := string(args[0])
+	parsed, errReply := parseZSetOpArgs(cmdName, args[1:], flags)
+	if errReply != nil {
+		return errReply
+	}
+	sets, errReply := db.getSortedSets(parsed.keys)
+	if errReply != nil {
+		return errReply
 	}
+	elements := zsetElements(calculate(sets, parsed))
+	db.storeSortedSet(dest, elements)
+	db.addAof(utils.ToCmdLine3(cmdName, args...))
+	return protocol.MakeIntReply(int64(len(elements)))
+}
+
+func calculateZUnion(sets []*SortedSet.SortedSet, parsed *zsetOpArgs) *SortedSet.SortedSet {
+	return zsetUnion(sets, parsed.weights, parsed.aggregate)
+}
+
+func calculateZInter(sets []*SortedSet.SortedSet, parsed *zsetOpArgs) *SortedSet.SortedSet {
+	return zsetInter(sets, parsed.weights, parsed.aggregate)
+}
+
+func calculateZDiff(sets []*SortedSet.SortedSet, parsed *zsetOpArgs) *SortedSet.SortedSet {
+	return zsetDiff(sets)
+}
+
+// execZUnion usage: ZUNION numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func execZUnion(db *DB, args [][]byte) redis.Reply {
+	return execZSetOp(db, "zunion", args, zsetOpAllowWeights|zsetOpAllowWithScores, calculateZUnion)
+}
+
+// execZUnionStore usage: ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func execZUnionStore(db *DB, args [][]byte) redis.Reply {
+	return execZSetOpStore(db, "zunionstore", args, zsetOpAllowWeights, calculateZUnion)
+}
 
-	result := make([]redis.Reply, 2)
-	result[0] = protocol.MakeBulkReply([]byte(strconv.FormatInt(int64(nextCursor), 10)))
-	result[1] = protocol.MakeMultiBulkReply(keysReply)
+// execZInter usage: ZINTER numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func execZInter(db *DB, args [][]byte) redis.Reply {
+	return execZSetOp(db, "zinter", args, zsetOpAllowWeights|zsetOpAllowWithScores, calculateZInter)
+}
 
-	return protocol.MakeMultiRawReply(result)
+// execZInterStore usage: ZINTERSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func execZInterStore(db *DB, args [][]byte) redis.Reply {
+	return execZSetOpStore(db, "zinterstore", args, zsetOpAllowWeights, calculateZInter)
+}
+
+// execZDiff usage: ZDIFF numkeys key [key ...] [WITHSCORES]
+func execZDiff(db *DB, args [][]byte) redis.Reply {
+	return execZSetOp(db, "zdiff", args, zsetOpAllowWithScores, calculateZDiff)
+}
+
+// execZDiffStore usage: ZDIFFSTORE destination numkeys key [key ...]
+func execZDiffStore(db *DB, args [][]byte) redis.Reply {
+	return execZSetOpStore(db, "zdiffstore", args, 0, calculateZDiff)
+}
+
+// execZInterCard usage: ZINTERCARD numkeys key [key ...] [LIMIT limit]
+// returns the size of intersection without building it, stops counting once limit is reached
+func execZInterCard(db *DB, args [][]byte) redis.Reply {
+	parsed, errReply := parseZSetOpArgs("zintercard", args, zsetOpAllowLimit)
+	if errReply != nil {
+		return errReply
+	}
+	sets, errReply := db.getSortedSets(parsed.keys)
+	if errReply != nil {
+		return errReply
+	}
+	// iterate the smallest set and lookup the others
+	smallest := 0
+	for i, set := range sets {
+		if set == nil {
+			return protocol.MakeIntReply(0)
+		}
+		if set.Len() < sets[smallest].Len() {
+			smallest = i
+		}
+	}
+	count := int64(0)
+	sets[smallest].ForEachByRank(0, sets[smallest].Len(), false, func(element *SortedSet.Element) bool {
+		for i, set := range sets {
+			if i == smallest {
+				continue
+			}
+			if _, ok := set.Get(element.Member); !ok {
+				return true
+			}
+		}
+		count++
+		return parsed.limit == 0 || count < parsed.limit
+	})
+	return protocol.MakeIntReply(count)
+}
+
+// execZScan iterates members and scores of a sorted set with cursor
+func execZScan(db *DB, args [][]byte) redis.Reply {
+	opts, errReply := parseScanOptions(args[1:], false, false)
+	if errReply != nil {
+		return errReply
+	}
+	sortedSet, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return makeScanReply(nil, 0)
+	}
+	return makeScanReply(sortedSet.ZSetScan(opts.cursor, opts.count, opts.pattern))
 }
 
 func init() {
@@ -847,6 +1396,8 @@ func init() {
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
 	registerCommand("ZScore", execZScore, readFirstKey, nil, 3, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
+	registerCommand("ZMScore", execZMScore, readFirstKey, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
 	registerCommand("ZIncrBy", execZIncrBy, writeFirstKey, undoZIncr, 4, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
 	registerCommand("ZRank", execZRank, readFirstKey, nil, 3, flagReadOnly).
@@ -859,6 +1410,8 @@ func init() {
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
 	registerCommand("ZRange", execZRange, readFirstKey, nil, -4, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
+	registerCommand("ZRangeStore", execZRangeStore, prepareZRangeStore, rollbackFirstKey, -5, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 2, 1)
 	registerCommand("ZRangeByScore", execZRangeByScore, readFirstKey, nil, -4, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
 	registerCommand("ZRevRange", execZRevRange, readFirstKey, nil, -4, flagReadOnly).
@@ -881,6 +1434,22 @@ func init() {
 		attachCommandExtra([]string{redisFlagWrite}, 1, 1, 1)
 	registerCommand("ZRevRangeByLex", execZRevRangeByLex, readFirstKey, nil, -4, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
+	registerCommand("ZRandMember", execZRandMember, readFirstKey, nil, -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom}, 1, 1, 1)
+	registerCommand("ZUnion", execZUnion, prepareZSetOp, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagMovableKeys}, 0, 0, 0)
+	registerCommand("ZUnionStore", execZUnionStore, prepareZSetOpStore, rollbackFirstKey, -4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagMovableKeys}, 1, 1, 1)
+	registerCommand("ZInter", execZInter, prepareZSetOp, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagMovableKeys}, 0, 0, 0)
+	registerCommand("ZInterStore", execZInterStore, prepareZSetOpStore, rollbackFirstKey, -4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagMovableKeys}, 1, 1, 1)
+	registerCommand("ZInterCard", execZInterCard, prepareZSetOp, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagMovableKeys}, 0, 0, 0)
+	registerCommand("ZDiff", execZDiff, prepareZSetOp, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagMovableKeys}, 0, 0, 0)
+	registerCommand("ZDiffStore", execZDiffStore, prepareZSetOpStore, rollbackFirstKey, -4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagMovableKeys}, 1, 1, 1)
 	registerCommand("ZScan", execZScan, readFirstKey, nil, -2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
 }
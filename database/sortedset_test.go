@@ -561,6 +561,21 @@ func TestZRangeByLex(t *testing.T) {
 	result31 := testDB.Exec(nil, utils.ToCmdLine("ZRangeByLex", key, "-", "+", "limit", "2", "2"))
 	asserts.AssertMultiBulkReply(t, result31, []string{"c", "d"})
 
+	// case32
+	result32 := testDB.Exec(nil, utils.ToCmdLine("ZRangeByLex", key, "[b", "+"))
+	asserts.AssertMultiBulkReply(t, result32, []string{"b", "c", "d", "e"})
+
+	// case33
+	result33 := testDB.Exec(nil, utils.ToCmdLine("ZRangeByLex", key, "[a", "[b", "limit", "3", "10"))
+	asserts.AssertMultiBulkReplySize(t, result33, 0)
+
+	// case34
+	result34 := testDB.Exec(nil, utils.ToCmdLine("ZRangeByLex", key, "", "+"))
+	asserts.AssertErrReply(t, result34, "ERR min or max not valid string range item")
+
+	// case35
+	result35 := testDB.Exec(nil, utils.ToCmdLine("ZRangeByLex", utils.RandString(10), "-", "+"))
+	asserts.AssertMultiBulkReplySize(t, result35, 0)
 }
 
 func TestZRemRangeByLex(t *testing.T) {
@@ -761,6 +776,10 @@ func TestZRevRangeByLex(t *testing.T) {
 	// case30
 	result30 := testDB.Exec(nil, utils.ToCmdLine("ZRevRangeByLex", key, "+", "-", "limit", "2", "2"))
 	asserts.AssertMultiBulkReply(t, result30, []string{"c", "b"})
+
+	// case31
+	result31 := testDB.Exec(nil, utils.ToCmdLine("ZRevRangeByLex", key, "+", "[b"))
+	asserts.AssertMultiBulkReply(t, result31, []string{"e", "d", "c", "b"})
 }
 
 func TestZScan(t *testing.T) {
@@ -808,3 +827,196 @@ func TestZScan(t *testing.T) {
 		}
 	}
 }
+
+func TestZScanLargeSortedSet(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	size := 1000
+	for i := 0; i < size; i++ {
+		testDB.Exec(nil, utils.ToCmdLine("zadd", key, strconv.Itoa(i), strconv.Itoa(i)))
+	}
+	items := scanAll(t, func(cursor string) [][]byte {
+		return utils.ToCmdLine("zscan", key, cursor, "count", "50")
+	})
+	scores := make(map[string]string)
+	for i := 0; i+1 < len(items); i += 2 {
+		scores[string(items[i])] = string(items[i+1])
+	}
+	if len(scores) != size {
+		t.Errorf("expect %d members, actually %d", size, len(scores))
+	}
+	for member, score := range scores {
+		if member != score {
+			t.Errorf("expect score %s, actually %s", member, score)
+		}
+	}
+}
+
+func TestZRangeUnified(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("ZAdd", key, "1", "a", "2", "b", "3", "c", "4", "d", "5", "e"))
+
+	result := testDB.Exec(nil, utils.ToCmdLine("ZRange", key, "0", "1", "REV"))
+	asserts.AssertMultiBulkReply(t, result, []string{"e", "d"})
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRange", key, "(1", "4", "BYSCORE", "LIMIT", "1", "2", "WITHSCORES"))
+	asserts.AssertMultiBulkReply(t, result, []string{"c", "3", "d", "4"})
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRange", key, "4", "(1", "BYSCORE", "REV"))
+	asserts.AssertMultiBulkReply(t, result, []string{"d", "c", "b"})
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRange", key, "0", "-1", "LIMIT", "0", "1"))
+	asserts.AssertErrReply(t, result, "ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRange", key, "-", "+", "BYLEX", "WITHSCORES"))
+	asserts.AssertErrReply(t, result, "ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRange", key, "0", "-1", "FOO"))
+	asserts.AssertErrReply(t, result, "Err syntax error")
+
+	lexKey := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("ZAdd", lexKey, "0", "a", "0", "b", "0", "c"))
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRange", lexKey, "[c", "(a", "BYLEX", "REV"))
+	asserts.AssertMultiBulkReply(t, result, []string{"c", "b"})
+}
+
+func TestZRangeStore(t *testing.T) {
+	testDB.Flush()
+	src := utils.RandString(10)
+	dest := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("ZAdd", src, "1", "a", "2", "b", "3", "c"))
+
+	// destination is overwritten regardless of its type
+	testDB.Exec(nil, utils.ToCmdLine("Set", dest, "v"))
+	result := testDB.Exec(nil, utils.ToCmdLine("ZRangeStore", dest, src, "1", "-1"))
+	asserts.AssertIntReply(t, result, 2)
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRange", dest, "0", "-1", "WITHSCORES"))
+	asserts.AssertMultiBulkReply(t, result, []string{"b", "2", "c", "3"})
+
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRangeStore", dest, src, "+inf", "2", "BYSCORE", "REV", "LIMIT", "0", "1"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRange", dest, "0", "-1"))
+	asserts.AssertMultiBulkReply(t, result, []string{"c"})
+
+	// empty range removes destination
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRangeStore", dest, src, "5", "10"))
+	asserts.AssertIntReply(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("Exists", dest))
+	asserts.AssertIntReply(t, result, 0)
+
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRangeStore", dest, src, "0", "-1", "WITHSCORES"))
+	asserts.AssertErrReply(t, result, "Err syntax error")
+}
+
+func TestZRandMember(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	result := testDB.Exec(nil, utils.ToCmdLine("ZRandMember", key))
+	asserts.AssertNullBulk(t, result)
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRandMember", key, "3"))
+	asserts.AssertMultiBulkReplySize(t, result, 0)
+
+	for i := 0; i < 100; i++ {
+		testDB.Exec(nil, utils.ToCmdLine("ZAdd", key, strconv.Itoa(i), strconv.Itoa(i)))
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRandMember", key))
+	if _, ok := result.(*protocol.BulkReply); !ok {
+		t.Errorf("expected bulk protocol, actually %s", result.ToBytes())
+		return
+	}
+
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRandMember", key, "10", "WITHSCORES"))
+	asserts.AssertMultiBulkReplySize(t, result, 20)
+	args := result.(*protocol.MultiBulkReply).Args
+	m := make(map[string]struct{})
+	for i := 0; i < len(args); i += 2 {
+		if string(args[i]) != string(args[i+1]) {
+			t.Errorf("member %s has wrong score %s", args[i], args[i+1])
+		}
+		m[string(args[i])] = struct{}{}
+	}
+	if len(m) != 10 {
+		t.Errorf("expected 10 members, actually %d", len(m))
+		return
+	}
+
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRandMember", key, "110"))
+	asserts.AssertMultiBulkReplySize(t, result, 100)
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRandMember", key, "-110"))
+	asserts.AssertMultiBulkReplySize(t, result, 110)
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRandMember", key, "0"))
+	asserts.AssertMultiBulkReplySize(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRandMember", key, "1", "foo"))
+	asserts.AssertErrReply(t, result, "Err syntax error")
+}
+
+func TestZSetAlgebra(t *testing.T) {
+	testDB.Flush()
+	key1 := utils.RandString(10)
+	key2 := utils.RandString(10)
+	dest := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("ZAdd", key1, "1", "a", "2", "b", "3", "c"))
+	testDB.Exec(nil, utils.ToCmdLine("ZAdd", key2, "10", "b", "20", "c", "30", "d"))
+
+	result := testDB.Exec(nil, utils.ToCmdLine("ZUnion", "2", key1, key2, "WITHSCORES"))
+	asserts.AssertMultiBulkReply(t, result, []string{"a", "1", "b", "12", "c", "23", "d", "30"})
+	result = testDB.Exec(nil, utils.ToCmdLine("ZUnion", "2", key1, key2, "WEIGHTS", "2", "1", "AGGREGATE", "MIN", "WITHSCORES"))
+	asserts.AssertMultiBulkReply(t, result, []string{"a", "2", "b", "4", "c", "6", "d", "30"})
+	result = testDB.Exec(nil, utils.ToCmdLine("ZInter", "2", key1, key2, "AGGREGATE", "MAX", "WITHSCORES"))
+	asserts.AssertMultiBulkReply(t, result, []string{"b", "10", "c", "20"})
+	result = testDB.Exec(nil, utils.ToCmdLine("ZInter", "2", key1, "nosuchkey"))
+	asserts.AssertMultiBulkReplySize(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("ZDiff", "2", key1, key2, "WITHSCORES"))
+	asserts.AssertMultiBulkReply(t, result, []string{"a", "1"})
+	result = testDB.Exec(nil, utils.ToCmdLine("ZDiff", "2", key1, "nosuchkey"))
+	asserts.AssertMultiBulkReply(t, result, []string{"a", "b", "c"})
+
+	result = testDB.Exec(nil, utils.ToCmdLine("ZInterCard", "2", key1, key2))
+	asserts.AssertIntReply(t, result, 2)
+	result = testDB.Exec(nil, utils.ToCmdLine("ZInterCard", "2", key1, key2, "LIMIT", "1"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("ZInterCard", "2", key1, key2, "LIMIT", "-1"))
+	asserts.AssertErrReply(t, result, "ERR LIMIT can't be negative")
+	result = testDB.Exec(nil, utils.ToCmdLine("ZInterCard", "0", key1))
+	asserts.AssertErrReply(t, result, "ERR numkeys should be greater than 0")
+
+	result = testDB.Exec(nil, utils.ToCmdLine("ZUnionStore", dest, "2", key1, key2, "WEIGHTS", "1", "0"))
+	asserts.AssertIntReply(t, result, 4)
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRange", dest, "0", "-1", "WITHSCORES"))
+	asserts.AssertMultiBulkReply(t, result, []string{"d", "0", "a", "1", "b", "2", "c", "3"})
+	result = testDB.Exec(nil, utils.ToCmdLine("ZInterStore", dest, "2", key1, key2))
+	asserts.AssertIntReply(t, result, 2)
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRange", dest, "0", "-1"))
+	asserts.AssertMultiBulkReply(t, result, []string{"b", "c"})
+	result = testDB.Exec(nil, utils.ToCmdLine("ZDiffStore", dest, "2", key1, key1))
+	asserts.AssertIntReply(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("Exists", dest))
+	asserts.AssertIntReply(t, result, 0)
+
+	result = testDB.Exec(nil, utils.ToCmdLine("ZUnion", "0", key1))
+	asserts.AssertErrReply(t, result, "ERR at least 1 input key is needed for 'zunion' command")
+	result = testDB.Exec(nil, utils.ToCmdLine("ZUnion", "3", key1, key2))
+	asserts.AssertErrReply(t, result, "Err syntax error")
+	result = testDB.Exec(nil, utils.ToCmdLine("ZUnion", "2", key1, key2, "WEIGHTS", "1", "x"))
+	asserts.AssertErrReply(t, result, "ERR weight value is not a float")
+	result = testDB.Exec(nil, utils.ToCmdLine("ZDiff", "2", key1, key2, "WEIGHTS", "1", "1"))
+	asserts.AssertErrReply(t, result, "Err syntax error")
+	result = testDB.Exec(nil, utils.ToCmdLine("ZUnionStore", dest, "2", key1, key2, "WITHSCORES"))
+	asserts.AssertErrReply(t, result, "Err syntax error")
+	testDB.Exec(nil, utils.ToCmdLine("Set", dest, "v"))
+	result = testDB.Exec(nil, utils.ToCmdLine("ZUnion", "2", key1, dest))
+	asserts.AssertErrReply(t, result, "WRONGTYPE Operation against a key holding the wrong kind of value")
+}
+
+func TestZMScore(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	result := testDB.Exec(nil, utils.ToCmdLine("ZMScore", key, "a", "b"))
+	if string(result.ToBytes()) != "*2\r\n$-1\r\n$-1\r\n" {
+		t.Errorf("expected nil scores, actually %s", result.ToBytes())
+	}
+	testDB.Exec(nil, utils.ToCmdLine("ZAdd", key, "1.5", "a", "2", "c"))
+	result = testDB.Exec(nil, utils.ToCmdLine("ZMScore", key, "a", "b", "c"))
+	if string(result.ToBytes()) != "*3\r\n$3\r\n1.5\r\n$-1\r\n$1\r\n2\r\n" {
+		t.Errorf("unexpected reply %s", result.ToBytes())
+	}
+	testDB.Exec(nil, utils.ToCmdLine("Set", key, "v"))
+	result = testDB.Exec(nil, utils.ToCmdLine("ZMScore", key, "a"))
+	asserts.AssertErrReply(t, result, "WRONGTYPE Operation against a key holding the wrong kind of value")
+}
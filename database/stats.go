@@ -0,0 +1,227 @@
+package database
+
+import (
+	"math/bits"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/sync/atomic"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+// latencyBuckets is the count of buckets of latency histogram, bucket i counts latencies in [2^(i-1), 2^i) microseconds
+const latencyBuckets = 40
+
+// opsSamples is the count of samples used to calculate instantaneous_ops_per_sec, same as redis
+const opsSamples = 16
+
+var opsSampleInterval = 100 * time.Millisecond
+
+// serverStats collects runtime counters shown in INFO, all methods are safe on nil
+type serverStats struct {
+	connectionsReceived atomic.Int64
+	commandsProcessed   atomic.Int64
+	keyspaceHits        atomic.Int64
+	keyspaceMisses      atomic.Int64
+	expiredKeys         atomic.Int64
+	// memoryPeak is the max used memory observed by INFO
+	memoryPeak atomic.Uint64
+
+	// name -> *commandStats
+	commands sync.Map
+
+	// ring of ops per second samples, only accessed by statsCron and INFO
+	opsMu        sync.Mutex
+	opsSamples   [opsSamples]int64
+	opsIndex     int
+	lastOpsTime  time.Time
+	lastOpsCount int64
+}
+
+type commandStats struct {
+	calls  atomic.Int64
+	usec   atomic.Int64
+	failed atomic.Int64
+	// maxUsec is the longest call in microseconds
+	maxUsec atomic.Uint64
+	// latency histogram, see latencyBuckets
+	histogram [latencyBuckets]atomic.Int64
+}
+
+func (s *serverStats) incrConnections() {
+	if s != nil {
+		s.connectionsReceived.Add(1)
+	}
+}
+
+func (s *serverStats) incrExpired() {
+	if s != nil {
+		s.expiredKeys.Add(1)
+	}
+}
+
+// recordLookup counts keyspace hits and misses
+func (s *serverStats) recordLookup(hit bool) {
+	if s == nil {
+		return
+	}
+	if hit {
+		s.keyspaceHits.Add(1)
+	} else {
+		s.keyspaceMisses.Add(1)
+	}
+}
+
+// recordCommand records a command and its latency, only commands in cmdTable are recorded
+func (s *serverStats) recordCommand(cmdName string, duration time.Duration, result redis.Reply) {
+	if s == nil {
+		return
+	}
+	s.commandsProcessed.Add(1)
+	if cmdTable[cmdName] == nil {
+		return
+	}
+	raw, ok := s.commands.Load(cmdName)
+	if !ok {
+		raw, _ = s.commands.LoadOrStore(cmdName, &commandStats{})
+	}
+	stats := raw.(*commandStats)
+	usec := duration.Microseconds()
+	stats.calls.Add(1)
+	stats.usec.Add(usec)
+	stats.maxUsec.StoreMax(uint64(usec))
+	if protocol.IsErrorReply(result) {
+		stats.failed.Add(1)
+	}
+	bucket := bits.Len64(uint64(usec))
+	if bucket >= latencyBuckets {
+		bucket = latencyBuckets - 1
+	}
+	stats.histogram[bucket].Add(1)
+}
+
+// updateMemoryPeak records used memory and returns the peak
+func (s *serverStats) updateMemoryPeak(used uint64) uint64 {
+	return s.memoryPeak.StoreMax(used)
+}
+
+// sampleOps records ops per second since the last sample
+func (s *serverStats) sampleOps() {
+	s.opsMu.Lock()
+	defer s.opsMu.Unlock()
+	now := time.Now()
+	count := s.commandsProcessed.Load()
+	if !s.lastOpsTime.IsZero() {
+		elapsed := now.Sub(s.lastOpsTime)
+		if elapsed > 0 {
+			s.opsSamples[s.opsIndex] = (count - s.lastOpsCount) * int64(time.Second) / int64(elapsed)
+			s.opsIndex = (s.opsIndex + 1) % opsSamples
+		}
+	}
+	s.lastOpsTime = now
+	s.lastOpsCount = count
+}
+
+// instantaneousOps returns the average of ops per second samples
+func (s *serverStats) instantaneousOps() int64 {
+	s.opsMu.Lock()
+	defer s.opsMu.Unlock()
+	var sum int64
+	for _, sample := range s.opsSamples {
+		sum += sample
+	}
+	return sum / opsSamples
+}
+
+func (s *serverStats) statsCron(done <-chan struct{}) {
+	ticker := time.NewTicker(opsSampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.sampleOps()
+		case <-done:
+			return
+		}
+	}
+}
+
+// reset clears counters for CONFIG RESETSTAT, memory peak is kept like redis
+func (s *serverStats) reset() {
+	s.connectionsReceived.Store(0)
+	s.commandsProcessed.Store(0)
+	s.keyspaceHits.Store(0)
+	s.keyspaceMisses.Store(0)
+	s.expiredKeys.Store(0)
+	s.commands.Range(func(key, value interface{}) bool {
+		s.commands.Delete(key)
+		return true
+	})
+	s.opsMu.Lock()
+	s.opsSamples = [opsSamples]int64{}
+	s.lastOpsTime = time.Time{}
+	s.opsMu.Unlock()
+}
+
+// sortedCommands returns recorded commands and their stats sorted by name
+func (s *serverStats) sortedCommands() ([]string, []*commandStats) {
+	var names []string
+	s.commands.Range(func(key, value interface{}) bool {
+		names = append(names, key.(string))
+		return true
+	})
+	sort.Strings(names)
+	stats := make([]*commandStats, len(names))
+	for i, name := range names {
+		raw, _ := s.commands.Load(name)
+		stats[i] = raw.(*commandStats)
+	}
+	return names, stats
+}
+
+// cumulativeHistogram returns upper bounds in microseconds of non-empty buckets and the count of calls within them
+func (cs *commandStats) cumulativeHistogram() (bounds []int64, counts []int64) {
+	var count int64
+	for i := range cs.histogram {
+		n := cs.histogram[i].Load()
+		if n == 0 {
+			continue
+		}
+		count += n
+		bounds = append(bounds, int64(1)<<uint(i))
+		counts = append(counts, count)
+	}
+	return bounds, counts
+}
+
+// percentile returns the upper bound in microseconds of bucket containing the p-th percentile latency
+func (cs *commandStats) percentile(p float64) float64 {
+	var total int64
+	var histogram [latencyBuckets]int64
+	for i := range cs.histogram {
+		histogram[i] = cs.histogram[i].Load()
+		total += histogram[i]
+	}
+	if total == 0 {
+		return 0
+	}
+	target := int64(float64(total)*p/100 + 0.5)
+	if target < 1 {
+		target = 1
+	}
+	var count int64
+	for i, n := range histogram {
+		count += n
+		if count >= target {
+			return float64(uint64(1) << uint(i))
+		}
+	}
+	return float64(uint64(1) << uint(latencyBuckets-1))
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 3, 64)
+}
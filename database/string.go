@@ -1,6 +1,7 @@
 package database
 
 import (
+	"math"
 	"math/bits"
 	"strconv"
 	"strings"
@@ -10,6 +11,7 @@ import (
 	"github.com/hdt3213/godis/datastruct/bitmap"
 	"github.com/hdt3213/godis/interface/database"
 	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/clock"
 	"github.com/hdt3213/godis/lib/utils"
 	"github.com/hdt3213/godis/redis/protocol"
 )
@@ -26,6 +28,17 @@ func (db *DB) getAsString(key string) ([]byte, protocol.ErrorReply) {
 	return bytes, nil
 }
 
+// getAsStringForUpdate is like getAsString, but returns a copy of payload for values modified in place such as
+// bloom filters. The stored payload may be shared by keys made by COPY or by replies not sent yet, so it must not
+// be modified, the caller puts the modified copy back instead
+func (db *DB) getAsStringForUpdate(key string) ([]byte, protocol.ErrorReply) {
+	payload, errReply := db.getAsString(key)
+	if errReply != nil || payload == nil {
+		return nil, errReply
+	}
+	return append([]byte(nil), payload...), nil
+}
+
 // execGet returns string value bound to the given key
 func execGet(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
@@ -50,137 +63,138 @@ const unlimitedTTL int64 = 0
 // execGetEX Get the value of key and optionally set its expiration
 func execGetEX(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
-	bytes, err := db.getAsString(key)
-	ttl := unlimitedTTL
-	if err != nil {
-		return err
-	}
-	if bytes == nil {
-		return &protocol.NullBulkReply{}
-	}
-
+	var expireTime time.Time
+	hasTTL := false
+	persist := false
 	for i := 1; i < len(args); i++ {
 		arg := strings.ToUpper(string(args[i]))
-		if arg == "EX" { // ttl in seconds
-			if ttl != unlimitedTTL {
-				// ttl has been set
-				return &protocol.SyntaxErrReply{}
-			}
-			if i+1 >= len(args) {
-				return &protocol.SyntaxErrReply{}
-			}
-			ttlArg, err := strconv.ParseInt(string(args[i+1]), 10, 64)
-			if err != nil {
+		switch arg {
+		case "EX", "PX", "EXAT", "PXAT":
+			if hasTTL || persist || i+1 >= len(args) {
 				return &protocol.SyntaxErrReply{}
 			}
-			if ttlArg <= 0 {
-				return protocol.MakeErrReply("ERR invalid expire time in getex")
+			var errReply protocol.ErrorReply
+			expireTime, errReply = parseExpireArg(arg, args[i+1], "getex")
+			if errReply != nil {
+				return errReply
 			}
-			ttl = ttlArg * 1000
+			hasTTL = true
 			i++ // skip next arg
-		} else if arg == "PX" { // ttl in milliseconds
-			if ttl != unlimitedTTL {
-				return &protocol.SyntaxErrReply{}
-			}
-			if i+1 >= len(args) {
+		case "PERSIST":
+			if hasTTL { // PERSIST Cannot be used with EX | PX | EXAT | PXAT
 				return &protocol.SyntaxErrReply{}
 			}
-			ttlArg, err := strconv.ParseInt(string(args[i+1]), 10, 64)
-			if err != nil {
-				return &protocol.SyntaxErrReply{}
-			}
-			if ttlArg <= 0 {
-				return protocol.MakeErrReply("ERR invalid expire time in getex")
-			}
-			ttl = ttlArg
-			i++ // skip next arg
-		} else if arg == "PERSIST" {
-			if ttl != unlimitedTTL { // PERSIST Cannot be used with EX | PX
-				return &protocol.SyntaxErrReply{}
-			}
-			if i+1 > len(args) {
-				return &protocol.SyntaxErrReply{}
-			}
-			db.Persist(key)
+			persist = true
+		default:
+			return &protocol.SyntaxErrReply{}
 		}
 	}
 
-	if len(args) > 1 {
-		if ttl != unlimitedTTL { // EX | PX
-			expireTime := time.Now().Add(time.Duration(ttl) * time.Millisecond)
-			db.Expire(key, expireTime)
-			db.addAof(aof.MakeExpireCmd(key, expireTime).Args)
-		} else { // PERSIST
-			db.Persist(key) // override ttl
-			// we convert to persist command to write aof
-			db.addAof(utils.ToCmdLine3("persist", args[0]))
-		}
+	bytes, err := db.getAsString(key)
+	if err != nil {
+		return err
+	}
+	if bytes == nil {
+		return &protocol.NullBulkReply{}
+	}
+
+	if hasTTL {
+		db.Expire(key, expireTime)
+		db.addAof(aof.MakeExpireCmd(key, expireTime).Args)
+	} else if persist {
+		db.Persist(key)
+		// we convert to persist command to write aof
+		db.addAof(utils.ToCmdLine3("persist", args[0]))
 	}
 	return protocol.MakeBulkReply(bytes)
 }
 
+// parseExpireArg parses the argument of EX/PX/EXAT/PXAT option into an absolute expire time
+func parseExpireArg(option string, arg []byte, cmdName string) (time.Time, protocol.ErrorReply) {
+	val, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return time.Time{}, protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if val <= 0 {
+		return time.Time{}, protocol.MakeErrReply("ERR invalid expire time in '" + cmdName + "' command")
+	}
+	switch option {
+	case "EX":
+		if val > math.MaxInt64/int64(time.Second) {
+			return time.Time{}, protocol.MakeErrReply("ERR invalid expire time in '" + cmdName + "' command")
+		}
+		return clock.Now().Add(time.Duration(val) * time.Second), nil
+	case "PX":
+		if val > math.MaxInt64/int64(time.Millisecond) {
+			return time.Time{}, protocol.MakeErrReply("ERR invalid expire time in '" + cmdName + "' command")
+		}
+		return clock.Now().Add(time.Duration(val) * time.Millisecond), nil
+	case "EXAT":
+		return time.Unix(val, 0), nil
+	default: // PXAT
+		return time.UnixMilli(val), nil
+	}
+}
+
 // execSet sets string value and time to live to the given key
 func execSet(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	value := args[1]
 	policy := upsertPolicy
-	ttl := unlimitedTTL
+	var expireTime time.Time
+	hasTTL := false
+	keepTTL := false
+	returnOld := false
 
 	// parse options
-	if len(args) > 2 {
-		for i := 2; i < len(args); i++ {
-			arg := strings.ToUpper(string(args[i]))
-			if arg == "NX" { // insert
-				if policy == updatePolicy {
-					return &protocol.SyntaxErrReply{}
-				}
-				policy = insertPolicy
-			} else if arg == "XX" { // update policy
-				if policy == insertPolicy {
-					return &protocol.SyntaxErrReply{}
-				}
-				policy = updatePolicy
-			} else if arg == "EX" { // ttl in seconds
-				if ttl != unlimitedTTL {
-					// ttl has been set
-					return &protocol.SyntaxErrReply{}
-				}
-				if i+1 >= len(args) {
-					return &protocol.SyntaxErrReply{}
-				}
-				ttlArg, err := strconv.ParseInt(string(args[i+1]), 10, 64)
-				if err != nil {
-					return &protocol.SyntaxErrReply{}
-				}
-				if ttlArg <= 0 {
-					return protocol.MakeErrReply("ERR invalid expire time in set")
-				}
-				ttl = ttlArg * 1000
-				i++ // skip next arg
-			} else if arg == "PX" { // ttl in milliseconds
-				if ttl != unlimitedTTL {
-					return &protocol.SyntaxErrReply{}
-				}
-				if i+1 >= len(args) {
-					return &protocol.SyntaxErrReply{}
-				}
-				ttlArg, err := strconv.ParseInt(string(args[i+1]), 10, 64)
-				if err != nil {
-					return &protocol.SyntaxErrReply{}
-				}
-				if ttlArg <= 0 {
-					return protocol.MakeErrReply("ERR invalid expire time in set")
-				}
-				ttl = ttlArg
-				i++ // skip next arg
-			} else {
+	for i := 2; i < len(args); i++ {
+		arg := strings.ToUpper(string(args[i]))
+		switch arg {
+		case "NX": // insert
+			if policy == updatePolicy {
+				return &protocol.SyntaxErrReply{}
+			}
+			policy = insertPolicy
+		case "XX": // update policy
+			if policy == insertPolicy {
+				return &protocol.SyntaxErrReply{}
+			}
+			policy = updatePolicy
+		case "GET":
+			returnOld = true
+		case "KEEPTTL":
+			if hasTTL {
+				return &protocol.SyntaxErrReply{}
+			}
+			keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if hasTTL || keepTTL || i+1 >= len(args) {
+				// ttl has been set or option has no argument
 				return &protocol.SyntaxErrReply{}
 			}
+			var errReply protocol.ErrorReply
+			expireTime, errReply = parseExpireArg(arg, args[i+1], "set")
+			if errReply != nil {
+				return errReply
+			}
+			hasTTL = true
+			i++ // skip next arg
+		default:
+			return &protocol.SyntaxErrReply{}
+		}
+	}
+
+	var old []byte
+	if returnOld {
+		var errReply protocol.ErrorReply
+		old, errReply = db.getAsString(key)
+		if errReply != nil {
+			return errReply
 		}
 	}
 
 	entity := &database.DataEntity{
-		Data: value,
+		Data: tryEncodeString(value),
 	}
 
 	var result int
@@ -194,21 +208,24 @@ func execSet(db *DB, args [][]byte) redis.Reply {
 		result = db.PutIfExists(key, entity)
 	}
 	if result > 0 {
-		if ttl != unlimitedTTL {
-			expireTime := time.Now().Add(time.Duration(ttl) * time.Millisecond)
+		if hasTTL {
 			db.Expire(key, expireTime)
-			db.addAof(CmdLine{
-				[]byte("SET"),
-				args[0],
-				args[1],
-			})
+			db.addAof(utils.ToCmdLine3("set", args[0], args[1]))
 			db.addAof(aof.MakeExpireCmd(key, expireTime).Args)
+		} else if keepTTL {
+			db.addAof(utils.ToCmdLine3("set", args[0], args[1], []byte("KEEPTTL")))
 		} else {
 			db.Persist(key) // override ttl
-			db.addAof(utils.ToCmdLine3("set", args...))
+			db.addAof(utils.ToCmdLine3("set", args[0], args[1]))
 		}
 	}
 
+	if returnOld {
+		if old == nil {
+			return &protocol.NullBulkReply{}
+		}
+		return protocol.MakeBulkReply(old)
+	}
 	if result > 0 {
 		return &protocol.OkReply{}
 	}
@@ -220,7 +237,7 @@ func execSetNX(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	value := args[1]
 	entity := &database.DataEntity{
-		Data: value,
+		Data: tryEncodeString(value),
 	}
 	result := db.PutIfAbsent(key, entity)
 	db.addAof(utils.ToCmdLine3("setnx", args...))
@@ -242,11 +259,11 @@ func execSetEX(db *DB, args [][]byte) redis.Reply {
 	ttl := ttlArg * 1000
 
 	entity := &database.DataEntity{
-		Data: value,
+		Data: tryEncodeString(value),
 	}
 
 	db.PutEntity(key, entity)
-	expireTime := time.Now().Add(time.Duration(ttl) * time.Millisecond)
+	expireTime := clock.Now().Add(time.Duration(ttl) * time.Millisecond)
 	db.Expire(key, expireTime)
 	db.addAof(utils.ToCmdLine3("setex", args...))
 	db.addAof(aof.MakeExpireCmd(key, expireTime).Args)
@@ -267,11 +284,11 @@ func execPSetEX(db *DB, args [][]byte) redis.Reply {
 	}
 
 	entity := &database.DataEntity{
-		Data: value,
+		Data: tryEncodeString(value),
 	}
 
 	db.PutEntity(key, entity)
-	expireTime := time.Now().Add(time.Duration(ttlArg) * time.Millisecond)
+	expireTime := clock.Now().Add(time.Duration(ttlArg) * time.Millisecond)
 	db.Expire(key, expireTime)
 	db.addAof(utils.ToCmdLine3("setex", args...))
 	db.addAof(aof.MakeExpireCmd(key, expireTime).Args)
@@ -309,7 +326,7 @@ func execMSet(db *DB, args [][]byte) redis.Reply {
 
 	for i, key := range keys {
 		value := values[i]
-		db.PutEntity(key, &database.DataEntity{Data: value})
+		db.PutEntity(key, &database.DataEntity{Data: tryEncodeString(value)})
 	}
 	db.addAof(utils.ToCmdLine3("mset", args...))
 	return &protocol.OkReply{}
@@ -371,7 +388,7 @@ func execMSetNX(db *DB, args [][]byte) redis.Reply {
 
 	for i, key := range keys {
 		value := values[i]
-		db.PutEntity(key, &database.DataEntity{Data: value})
+		db.PutEntity(key, &database.DataEntity{Data: tryEncodeString(value)})
 	}
 	db.addAof(utils.ToCmdLine3("msetnx", args...))
 	return protocol.MakeIntReply(1)
@@ -387,7 +404,7 @@ func execGetSet(db *DB, args [][]byte) redis.Reply {
 		return err
 	}
 
-	db.PutEntity(key, &database.DataEntity{Data: value})
+	db.PutEntity(key, &database.DataEntity{Data: tryEncodeString(value)})
 	db.Persist(key) // override ttl
 	db.addAof(utils.ToCmdLine3("set", args...))
 	if old == nil {
@@ -428,13 +445,13 @@ func execIncr(db *DB, args [][]byte) redis.Reply {
 			return protocol.MakeErrReply("ERR value is not an integer or out of range")
 		}
 		db.PutEntity(key, &database.DataEntity{
-			Data: []byte(strconv.FormatInt(val+1, 10)),
+			Data: makeIntString(val+1),
 		})
 		db.addAof(utils.ToCmdLine3("incr", args...))
 		return protocol.MakeIntReply(val + 1)
 	}
 	db.PutEntity(key, &database.DataEntity{
-		Data: []byte("1"),
+		Data: makeIntString(1),
 	})
 	db.addAof(utils.ToCmdLine3("incr", args...))
 	return protocol.MakeIntReply(1)
@@ -460,13 +477,13 @@ func execIncrBy(db *DB, args [][]byte) redis.Reply {
 			return protocol.MakeErrReply("ERR value is not an integer or out of range")
 		}
 		db.PutEntity(key, &database.DataEntity{
-			Data: []byte(strconv.FormatInt(val+delta, 10)),
+			Data: makeIntString(val+delta),
 		})
 		db.addAof(utils.ToCmdLine3("incrby", args...))
 		return protocol.MakeIntReply(val + delta)
 	}
 	db.PutEntity(key, &database.DataEntity{
-		Data: args[1],
+		Data: makeIntString(delta),
 	})
 	db.addAof(utils.ToCmdLine3("incrby", args...))
 	return protocol.MakeIntReply(delta)
@@ -518,7 +535,7 @@ func execDecr(db *DB, args [][]byte) redis.Reply {
 			return protocol.MakeErrReply("ERR value is not an integer or out of range")
 		}
 		db.PutEntity(key, &database.DataEntity{
-			Data: []byte(strconv.FormatInt(val-1, 10)),
+			Data: makeIntString(val-1),
 		})
 		db.addAof(utils.ToCmdLine3("decr", args...))
 		return protocol.MakeIntReply(val - 1)
@@ -550,14 +567,13 @@ func execDecrBy(db *DB, args [][]byte) redis.Reply {
 			return protocol.MakeErrReply("ERR value is not an integer or out of range")
 		}
 		db.PutEntity(key, &database.DataEntity{
-			Data: []byte(strconv.FormatInt(val-delta, 10)),
+			Data: makeIntString(val-delta),
 		})
 		db.addAof(utils.ToCmdLine3("decrby", args...))
 		return protocol.MakeIntReply(val - delta)
 	}
-	valueStr := strconv.FormatInt(-delta, 10)
 	db.PutEntity(key, &database.DataEntity{
-		Data: []byte(valueStr),
+		Data: makeIntString(-delta),
 	})
 	db.addAof(utils.ToCmdLine3("decrby", args...))
 	return protocol.MakeIntReply(-delta)
@@ -591,41 +607,48 @@ func execAppend(db *DB, args [][]byte) redis.Reply {
 	return protocol.MakeIntReply(int64(len(bytes)))
 }
 
+// maxStringSize is the max length of a string value, same as proto-max-bulk-len of redis
+const maxStringSize = 512 * 1024 * 1024
+
 // execSetRange overwrites part of the string stored at key, starting at the specified offset.
 // If the offset is larger than the current length of the string at key, the string is padded with zero-bytes.
 func execSetRange(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	offset, errNative := strconv.ParseInt(string(args[1]), 10, 64)
 	if errNative != nil {
-		return protocol.MakeErrReply(errNative.Error())
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if offset < 0 {
+		return protocol.MakeErrReply("ERR offset is out of range")
 	}
 	value := args[2]
 	bytes, err := db.getAsString(key)
 	if err != nil {
 		return err
 	}
-	bytesLen := int64(len(bytes))
-	if bytesLen < offset {
-		diff := offset - bytesLen
-		diffArray := make([]byte, diff)
-		bytes = append(bytes, diffArray...)
-		bytesLen = int64(len(bytes))
-	}
-	for i := 0; i < len(value); i++ {
-		idx := offset + int64(i)
-		if idx >= bytesLen {
-			bytes = append(bytes, value[i])
-		} else {
-			bytes[idx] = value[i]
-		}
+	if len(value) == 0 {
+		// nothing to write, do not create key or pad the string
+		return protocol.MakeIntReply(int64(len(bytes)))
 	}
+	if offset+int64(len(value)) > maxStringSize {
+		return protocol.MakeErrReply("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	}
+	size := int64(len(bytes))
+	if end := offset + int64(len(value)); end > size {
+		size = end
+	}
+	// do not modify bytes in place, undo log of transaction still holds it
+	result := make([]byte, size)
+	copy(result, bytes)
+	copy(result[offset:], value)
 	db.PutEntity(key, &database.DataEntity{
-		Data: bytes,
+		Data: result,
 	})
 	db.addAof(utils.ToCmdLine3("setRange", args...))
-	return protocol.MakeIntReply(int64(len(bytes)))
+	return protocol.MakeIntReply(int64(len(result)))
 }
 
+// execGetRange returns the substring of the string stored at key, negative offsets count from the end
 func execGetRange(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	startIdx, err2 := strconv.ParseInt(string(args[1]), 10, 64)
@@ -641,12 +664,8 @@ func execGetRange(db *DB, args [][]byte) redis.Reply {
 	if err != nil {
 		return err
 	}
-	if bs == nil {
-		return protocol.MakeNullBulkReply()
-	}
-	bytesLen := int64(len(bs))
-	beg, end := utils.ConvertRange(startIdx, endIdx, bytesLen)
-	if beg < 0 {
+	beg, end := utils.ConvertStringRange(startIdx, endIdx, int64(len(bs)))
+	if beg == end {
 		return protocol.MakeNullBulkReply()
 	}
 	return protocol.MakeBulkReply(bs[beg:end])
@@ -671,6 +690,10 @@ func execSetBit(db *DB, args [][]byte) redis.Reply {
 	if errReply != nil {
 		return errReply
 	}
+	if isSharedValue(bs) {
+		// shared values must not be modified in place
+		bs = append([]byte(nil), bs...)
+	}
 	bm := bitmap.FromBytes(bs)
 	former := bm.GetBit(offset)
 	bm.SetBit(offset, v)
@@ -825,16 +848,159 @@ func execBitPos(db *DB, args [][]byte) redis.Reply {
 	return protocol.MakeIntReply(offset)
 }
 
-// GetRandomKey Randomly return (do not delete) a key from the godis
+func prepareLCS(args [][]byte) ([]string, []string) {
+	return nil, []string{string(args[0]), string(args[1])}
+}
+
+// execLCS finds the longest common subsequence of two strings
+func execLCS(db *DB, args [][]byte) redis.Reply {
+	getLen, getIdx, withMatchLen := false, false, false
+	var minMatchLen int64
+	for i := 2; i < len(args); i++ {
+		arg := strings.ToUpper(string(args[i]))
+		switch {
+		case arg == "LEN":
+			getLen = true
+		case arg == "IDX":
+			getIdx = true
+		case arg == "WITHMATCHLEN":
+			withMatchLen = true
+		case arg == "MINMATCHLEN" && i+1 < len(args):
+			val, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if val > 0 {
+				minMatchLen = val
+			}
+			i++
+		default:
+			return &protocol.SyntaxErrReply{}
+		}
+	}
+	if getLen && getIdx {
+		return protocol.MakeErrReply("ERR If you want both the length and indexes, please just use IDX.")
+	}
+	a, errReply := db.getAsString(string(args[0]))
+	if errReply != nil {
+		return protocol.MakeErrReply("ERR The specified keys must contain string values")
+	}
+	b, errReply := db.getAsString(string(args[1]))
+	if errReply != nil {
+		return protocol.MakeErrReply("ERR The specified keys must contain string values")
+	}
+	if int64(len(a)+1)*int64(len(b)+1) > maxStringSize {
+		return protocol.MakeErrReply("ERR Insufficient memory, transient memory for LCS exceeds proto-max-bulk-len")
+	}
+
+	// dp[i*(len(b)+1)+j] is the length of lcs of a[:i] and b[:j]
+	width := len(b) + 1
+	dp := make([]uint32, (len(a)+1)*width)
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				dp[i*width+j] = dp[(i-1)*width+j-1] + 1
+			} else if dp[(i-1)*width+j] > dp[i*width+j-1] {
+				dp[i*width+j] = dp[(i-1)*width+j]
+			} else {
+				dp[i*width+j] = dp[i*width+j-1]
+			}
+		}
+	}
+	lcsLen := int(dp[len(a)*width+len(b)])
+	if getLen {
+		return protocol.MakeIntReply(int64(lcsLen))
+	}
+
+	// walk back from the end, collecting lcs and contiguous matched ranges
+	result := make([]byte, lcsLen)
+	var matches []redis.Reply
+	idx := lcsLen
+	aStart, aEnd, bStart, bEnd := len(a), 0, 0, 0 // aStart == len(a) means no range in progress
+	for i, j := len(a), len(b); i > 0 && j > 0; {
+		emit := false
+		if a[i-1] == b[j-1] {
+			result[idx-1] = a[i-1]
+			if aStart == len(a) {
+				aStart, aEnd, bStart, bEnd = i-1, i-1, j-1, j-1
+			} else if aStart == i && bStart == j {
+				// extend the range backward since it is contiguous
+				aStart--
+				bStart--
+			} else {
+				emit = true
+			}
+			if aStart == 0 || bStart == 0 {
+				emit = true
+			}
+			idx--
+			i--
+			j--
+		} else {
+			if dp[(i-1)*width+j] > dp[i*width+j-1] {
+				i--
+			} else {
+				j--
+			}
+			if aStart != len(a) {
+				emit = true
+			}
+		}
+		if emit {
+			matchLen := int64(aEnd - aStart + 1)
+			if getIdx && matchLen >= minMatchLen {
+				match := []redis.Reply{
+					protocol.MakeMultiRawReply([]redis.Reply{
+						protocol.MakeIntReply(int64(aStart)),
+						protocol.MakeIntReply(int64(aEnd)),
+					}),
+					protocol.MakeMultiRawReply([]redis.Reply{
+						protocol.MakeIntReply(int64(bStart)),
+						protocol.MakeIntReply(int64(bEnd)),
+					}),
+				}
+				if withMatchLen {
+					match = append(match, protocol.MakeIntReply(matchLen))
+				}
+				matches = append(matches, protocol.MakeMultiRawReply(match))
+			}
+			aStart = len(a)
+		}
+	}
+	if getIdx {
+		return protocol.MakeMultiRawReply([]redis.Reply{
+			protocol.MakeBulkReply([]byte("matches")),
+			protocol.MakeMultiRawReply(matches),
+			protocol.MakeBulkReply([]byte("len")),
+			protocol.MakeIntReply(int64(lcsLen)),
+		})
+	}
+	return protocol.MakeBulkReply(result)
+}
+
+// randomKeyMaxTries is the max number of expired keys RANDOMKEY could meet before giving up
+const randomKeyMaxTries = 100
+
+// getRandomKey Randomly return (do not delete) a key which is not expired from the godis
 func getRandomKey(db *DB, args [][]byte) redis.Reply {
-	k := db.data.RandomKeys(1)
-	if len(k) == 0 {
-		return &protocol.NullBulkReply{}
+	var key string
+	for i := 0; i < randomKeyMaxTries; i++ {
+		keys := db.data.RandomKeys(1)
+		if len(keys) == 0 {
+			return &protocol.NullBulkReply{}
+		}
+		key = keys[0]
+		if !db.hasExpired(key) {
+			break
+		}
 	}
-	return protocol.MakeBulkReply([]byte(k[0]))
+	// returns an expired key anyway if there are too many expired keys
+	return protocol.MakeBulkReply([]byte(key))
 }
 
 func init() {
+	registerCommand("LCS", execLCS, prepareLCS, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly}, 1, 2, 1)
 	registerCommand("Set", execSet, writeFirstKey, rollbackFirstKey, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
 	registerCommand("SetNx", execSetNX, writeFirstKey, rollbackFirstKey, 3, flagWrite).
@@ -851,8 +1017,8 @@ func init() {
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
 	registerCommand("Get", execGet, readFirstKey, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
-	registerCommand("GetEX", execGetEX, writeFirstKey, rollbackFirstKey, -2, flagReadOnly).
-		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
+	registerCommand("GetEX", execGetEX, writeFirstKey, rollbackFirstKey, -2, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
 	registerCommand("GetSet", execGetSet, writeFirstKey, rollbackFirstKey, 3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
 	registerCommand("GetDel", execGetDel, writeFirstKey, rollbackFirstKey, 2, flagWrite).
@@ -8,6 +8,7 @@ import (
 	"math"
 	"strconv"
 	"testing"
+	"time"
 )
 
 var testDB = makeTestDB()
@@ -120,6 +121,60 @@ func TestSet(t *testing.T) {
 	}
 }
 
+func TestSetOptions(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	value := utils.RandString(10)
+
+	// GET returns old value
+	actual := testDB.Exec(nil, utils.ToCmdLine("SET", key, value, "GET"))
+	asserts.AssertNullBulk(t, actual)
+	actual = testDB.Exec(nil, utils.ToCmdLine("SET", key, "v2", "GET"))
+	asserts.AssertBulkReply(t, actual, value)
+	actual = testDB.Exec(nil, utils.ToCmdLine("SET", key, "v3", "NX", "GET"))
+	asserts.AssertBulkReply(t, actual, "v2")
+	actual = testDB.Exec(nil, utils.ToCmdLine("GET", key))
+	asserts.AssertBulkReply(t, actual, "v2")
+
+	// KEEPTTL
+	testDB.Exec(nil, utils.ToCmdLine("SET", key, value, "EX", "1000"))
+	testDB.Exec(nil, utils.ToCmdLine("SET", key, value, "KEEPTTL"))
+	actual = testDB.Exec(nil, utils.ToCmdLine("TTL", key))
+	asserts.AssertIntReplyGreaterThan(t, actual, 990)
+	testDB.Exec(nil, utils.ToCmdLine("SET", key, value))
+	actual = testDB.Exec(nil, utils.ToCmdLine("TTL", key))
+	asserts.AssertIntReply(t, actual, -1)
+
+	// EXAT & PXAT
+	expireAt := time.Now().Add(100 * time.Second)
+	testDB.Exec(nil, utils.ToCmdLine("SET", key, value, "EXAT", strconv.FormatInt(expireAt.Unix(), 10)))
+	actual = testDB.Exec(nil, utils.ToCmdLine("TTL", key))
+	asserts.AssertIntReplyGreaterThan(t, actual, 90)
+	testDB.Exec(nil, utils.ToCmdLine("SET", key, value, "PXAT", strconv.FormatInt(expireAt.UnixMilli(), 10)))
+	actual = testDB.Exec(nil, utils.ToCmdLine("TTL", key))
+	asserts.AssertIntReplyGreaterThan(t, actual, 90)
+	testDB.Exec(nil, utils.ToCmdLine("SET", key, value, "PXAT", "1"))
+	actual = testDB.Exec(nil, utils.ToCmdLine("GET", key))
+	asserts.AssertNullBulk(t, actual)
+
+	// errors
+	actual = testDB.Exec(nil, utils.ToCmdLine("SET", key, value, "EX", "10", "KEEPTTL"))
+	asserts.AssertErrReply(t, actual, "Err syntax error")
+	actual = testDB.Exec(nil, utils.ToCmdLine("SET", key, value, "EX", "10", "PXAT", "10"))
+	asserts.AssertErrReply(t, actual, "Err syntax error")
+	actual = testDB.Exec(nil, utils.ToCmdLine("SET", key, value, "NX", "XX"))
+	asserts.AssertErrReply(t, actual, "Err syntax error")
+	actual = testDB.Exec(nil, utils.ToCmdLine("SET", key, value, "EX", "a"))
+	asserts.AssertErrReply(t, actual, "ERR value is not an integer or out of range")
+	actual = testDB.Exec(nil, utils.ToCmdLine("SET", key, value, "EXAT", "0"))
+	asserts.AssertErrReply(t, actual, "ERR invalid expire time in 'set' command")
+	testDB.Exec(nil, utils.ToCmdLine("RPUSH", key, value))
+	actual = testDB.Exec(nil, utils.ToCmdLine("SET", key, value, "GET"))
+	asserts.AssertErrReply(t, actual, "WRONGTYPE Operation against a key holding the wrong kind of value")
+	actual = testDB.Exec(nil, utils.ToCmdLine("TYPE", key))
+	asserts.AssertStatusReply(t, actual, "list")
+}
+
 func TestSetNX(t *testing.T) {
 	testDB.Flush()
 	key := utils.RandString(10)
@@ -352,6 +407,30 @@ func TestGetEX(t *testing.T) {
 		t.Errorf("expected int between [0, 1000000], actually %d", intResult.Code)
 		return
 	}
+
+	// Test GetEX Key EXAT/PXAT Timestamp
+	expireAt := time.Now().Add(100 * time.Second)
+	actual = testDB.Exec(nil, utils.ToCmdLine("GETEX", key, "EXAT", strconv.FormatInt(expireAt.Unix(), 10)))
+	asserts.AssertBulkReply(t, actual, value)
+	actual = testDB.Exec(nil, utils.ToCmdLine("TTL", key))
+	asserts.AssertIntReplyGreaterThan(t, actual, 90)
+	actual = testDB.Exec(nil, utils.ToCmdLine("GETEX", key, "PXAT", "1"))
+	asserts.AssertBulkReply(t, actual, value)
+	actual = testDB.Exec(nil, utils.ToCmdLine("GET", key))
+	asserts.AssertNullBulk(t, actual)
+
+	// invalid options leave key untouched
+	testDB.Exec(nil, utils.ToCmdLine("SET", key, value, "EX", ttl))
+	actual = testDB.Exec(nil, utils.ToCmdLine("GETEX", key, "PERSIST", "EX", "10"))
+	asserts.AssertErrReply(t, actual, "Err syntax error")
+	actual = testDB.Exec(nil, utils.ToCmdLine("GETEX", key, "PERSIST", "foo"))
+	asserts.AssertErrReply(t, actual, "Err syntax error")
+	actual = testDB.Exec(nil, utils.ToCmdLine("GETEX", key, "EX", "-1"))
+	asserts.AssertErrReply(t, actual, "ERR invalid expire time in 'getex' command")
+	actual = testDB.Exec(nil, utils.ToCmdLine("TTL", key))
+	asserts.AssertIntReplyGreaterThan(t, actual, 0)
+	actual = testDB.Exec(nil, utils.ToCmdLine("GETEX", utils.RandString(10), "PERSIST"))
+	asserts.AssertNullBulk(t, actual)
 }
 
 func TestGetSet(t *testing.T) {
@@ -503,6 +582,43 @@ func TestSetRange_StringNotExist(t *testing.T) {
 	asserts.AssertIntReply(t, val, len(key))
 }
 
+func TestSetRange_Padding(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+
+	actual := testDB.Exec(nil, utils.ToCmdLine("SetRange", key, "3", "abc"))
+	asserts.AssertIntReply(t, actual, 6)
+	actual = testDB.Exec(nil, utils.ToCmdLine("GET", key))
+	asserts.AssertBulkReply(t, actual, "\x00\x00\x00abc")
+	actual = testDB.Exec(nil, utils.ToCmdLine("SetRange", key, "1", "x"))
+	asserts.AssertIntReply(t, actual, 6)
+	actual = testDB.Exec(nil, utils.ToCmdLine("GET", key))
+	asserts.AssertBulkReply(t, actual, "\x00x\x00abc")
+
+	// empty value neither creates key nor pads string
+	actual = testDB.Exec(nil, utils.ToCmdLine("SetRange", key, "100", ""))
+	asserts.AssertIntReply(t, actual, 6)
+	key2 := utils.RandString(10)
+	actual = testDB.Exec(nil, utils.ToCmdLine("SetRange", key2, "1", ""))
+	asserts.AssertIntReply(t, actual, 0)
+	actual = testDB.Exec(nil, utils.ToCmdLine("EXISTS", key2))
+	asserts.AssertIntReply(t, actual, 0)
+
+	actual = testDB.Exec(nil, utils.ToCmdLine("SetRange", key, "-1", "x"))
+	asserts.AssertErrReply(t, actual, "ERR offset is out of range")
+	actual = testDB.Exec(nil, utils.ToCmdLine("SetRange", key, "536870911", "xx"))
+	asserts.AssertErrReply(t, actual, "ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+
+	// undo log keeps the original value
+	undoLogs := testDB.GetUndoLogs(utils.ToCmdLine("SetRange", key, "0", "yy"))
+	testDB.Exec(nil, utils.ToCmdLine("SetRange", key, "0", "yy"))
+	for _, cmdLine := range undoLogs {
+		testDB.Exec(nil, cmdLine)
+	}
+	actual = testDB.Exec(nil, utils.ToCmdLine("GET", key))
+	asserts.AssertBulkReply(t, actual, "\x00x\x00abc")
+}
+
 func TestGetRange_StringExist(t *testing.T) {
 	testDB.Flush()
 	key := utils.RandString(10)
@@ -657,14 +773,9 @@ func TestGetRange_StringExist_StartIsOutOfRange(t *testing.T) {
 	key := utils.RandString(10)
 	testDB.Exec(nil, utils.ToCmdLine2("SET", key, key))
 
+	// start is clamped to the beginning of string
 	actual := testDB.Exec(nil, utils.ToCmdLine("GetRange", key, fmt.Sprint(-len(key)-3), fmt.Sprint(len(key))))
-	val, ok := actual.(*protocol.NullBulkReply)
-	if !ok {
-		t.Errorf("expect bulk protocol, get: %s", string(actual.ToBytes()))
-		return
-	}
-
-	asserts.AssertNullBulk(t, val)
+	asserts.AssertBulkReply(t, actual, key)
 }
 
 func TestGetRange_StringExist_EndIdxIsOutOfRange(t *testing.T) {
@@ -812,4 +923,61 @@ func TestRandomkey(t *testing.T) {
 	}
 	actual := testDB.Exec(nil, utils.ToCmdLine("Randomkey"))
 	asserts.AssertNotError(t, actual)
+
+	// expired keys are skipped
+	testDB.Flush()
+	for i := 0; i < 10; i++ {
+		key := utils.RandString(10)
+		testDB.Exec(nil, utils.ToCmdLine("SET", key, key, "PX", "1"))
+	}
+	testDB.Exec(nil, utils.ToCmdLine("SET", "alive", "1"))
+	time.Sleep(5 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		actual = testDB.Exec(nil, utils.ToCmdLine("Randomkey"))
+		asserts.AssertBulkReply(t, actual, "alive")
+	}
+
+	testDB.Flush()
+	actual = testDB.Exec(nil, utils.ToCmdLine("Randomkey"))
+	asserts.AssertNullBulk(t, actual)
+}
+
+func TestLCS(t *testing.T) {
+	testDB.Flush()
+	key1, key2 := utils.RandString(10), utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("MSET", key1, "ohmytext", key2, "mynewtext"))
+
+	actual := testDB.Exec(nil, utils.ToCmdLine("LCS", key1, key2))
+	asserts.AssertBulkReply(t, actual, "mytext")
+	actual = testDB.Exec(nil, utils.ToCmdLine("LCS", key1, key2, "LEN"))
+	asserts.AssertIntReply(t, actual, 6)
+
+	actual = testDB.Exec(nil, utils.ToCmdLine("LCS", key1, key2, "IDX"))
+	expected := "*4\r\n$7\r\nmatches\r\n*2\r\n" +
+		"*2\r\n*2\r\n:4\r\n:7\r\n*2\r\n:5\r\n:8\r\n" +
+		"*2\r\n*2\r\n:2\r\n:3\r\n*2\r\n:0\r\n:1\r\n" +
+		"$3\r\nlen\r\n:6\r\n"
+	if string(actual.ToBytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, actual.ToBytes())
+	}
+	actual = testDB.Exec(nil, utils.ToCmdLine("LCS", key1, key2, "IDX", "MINMATCHLEN", "4", "WITHMATCHLEN"))
+	expected = "*4\r\n$7\r\nmatches\r\n*1\r\n" +
+		"*3\r\n*2\r\n:4\r\n:7\r\n*2\r\n:5\r\n:8\r\n:4\r\n" +
+		"$3\r\nlen\r\n:6\r\n"
+	if string(actual.ToBytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, actual.ToBytes())
+	}
+
+	// missing key is treated as empty string
+	actual = testDB.Exec(nil, utils.ToCmdLine("LCS", key1, utils.RandString(10)))
+	asserts.AssertBulkReply(t, actual, "")
+
+	actual = testDB.Exec(nil, utils.ToCmdLine("LCS", key1, key2, "LEN", "IDX"))
+	asserts.AssertErrReply(t, actual, "ERR If you want both the length and indexes, please just use IDX.")
+	actual = testDB.Exec(nil, utils.ToCmdLine("LCS", key1, key2, "MINMATCHLEN"))
+	asserts.AssertErrReply(t, actual, "Err syntax error")
+	testDB.Exec(nil, utils.ToCmdLine("DEL", key2))
+	testDB.Exec(nil, utils.ToCmdLine("RPUSH", key2, "a"))
+	actual = testDB.Exec(nil, utils.ToCmdLine("LCS", key1, key2))
+	asserts.AssertErrReply(t, actual, "ERR The specified keys must contain string values")
 }
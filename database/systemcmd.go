@@ -4,11 +4,13 @@ import (
 	"fmt"
 	"github.com/hdt3213/godis/config"
 	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/utils"
 	"github.com/hdt3213/godis/redis/protocol"
 	"github.com/hdt3213/godis/tcp"
 	"os"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,32 +25,48 @@ func Ping(c redis.Connection, args [][]byte) redis.Reply {
 	}
 }
 
+// defaultInfoSections are returned by INFO without arguments, same as redis
+var defaultInfoSections = []string{"server", "clients", "memory", "persistence", "stats", "replication", "cpu", "cluster", "keyspace"}
+
+// allInfoSections are returned by INFO ALL, sections which may be long are excluded from default
+var allInfoSections = append(append([]string{}, defaultInfoSections...), "commandstats", "latencystats")
+
+var infoSections = map[string]bool{
+	"client": true, // compatible with earlier godis
+}
+
+func init() {
+	for _, section := range allInfoSections {
+		infoSections[section] = true
+	}
+}
+
 // Info the information of the godis server returned by the INFO command
 func Info(db *Server, args [][]byte) redis.Reply {
-	if len(args) == 0 {
-		infoCommandList := [...]string{"server", "client", "cluster", "keyspace"}
-		var allSection []byte
-		for _, s := range infoCommandList {
-			allSection = append(allSection, GenGodisInfoString(s, db)...)
-		}
-		return protocol.MakeBulkReply(allSection)
-	} else if len(args) == 1 {
+	if len(args) > 1 {
+		return protocol.MakeArgNumErrReply("info")
+	}
+	sections := defaultInfoSections
+	if len(args) == 1 {
 		section := strings.ToLower(string(args[0]))
-		switch section {
-		case "server":
-			reply := GenGodisInfoString("server", db)
-			return protocol.MakeBulkReply(reply)
-		case "client":
-			return protocol.MakeBulkReply(GenGodisInfoString("client", db))
-		case "cluster":
-			return protocol.MakeBulkReply(GenGodisInfoString("cluster", db))
-		case "keyspace":
-			return protocol.MakeBulkReply(GenGodisInfoString("keyspace", db))
+		switch {
+		case section == "default":
+		case section == "all" || section == "everything":
+			sections = allInfoSections
+		case infoSections[section]:
+			sections = []string{section}
 		default:
 			return protocol.MakeErrReply("Invalid section for 'info' command")
 		}
 	}
-	return protocol.MakeArgNumErrReply("info")
+	var result []byte
+	for i, section := range sections {
+		if i > 0 {
+			result = append(result, "\r\n"...)
+		}
+		result = append(result, GenGodisInfoString(section, db)...)
+	}
+	return protocol.MakeBulkReply(result)
 }
 
 // Auth validate client's password
@@ -61,7 +79,7 @@ func Auth(c redis.Connection, args [][]byte) redis.Reply {
 	}
 	passwd := string(args[0])
 	c.SetPassword(passwd)
-	if config.Properties.RequirePass != passwd {
+	if !utils.SecureCompare(passwd, config.Properties.RequirePass) {
 		return protocol.MakeErrReply("ERR invalid password")
 	}
 	return &protocol.OkReply{}
@@ -71,7 +89,7 @@ func isAuthenticated(c redis.Connection) bool {
 	if config.Properties.RequirePass == "" {
 		return true
 	}
-	return c.GetPassword() == config.Properties.RequirePass
+	return utils.SecureCompare(c.GetPassword(), config.Properties.RequirePass)
 }
 
 func DbSize(c redis.Connection, db *Server) redis.Reply {
@@ -119,18 +137,22 @@ func GenGodisInfoString(section string, db *Server) []byte {
 			//TODO,
 			config.GetConfigFilePath())
 		return []byte(s)
-	case "client":
-		s := fmt.Sprintf("# Clients\r\n"+
-			"connected_clients:%d\r\n",
-			//"client_recent_max_input_buffer:%d\r\n"+
-			//"client_recent_max_output_buffer:%d\r\n"+
-			//"blocked_clients:%d\n",
-			tcp.ClientCounter,
-			//TODO,
-			//TODO,
-			//TODO,
-		)
-		return []byte(s)
+	case "client", "clients":
+		return getClientsInfo(db)
+	case "memory":
+		return getMemoryInfo(db)
+	case "stats":
+		return getStatsInfo(db)
+	case "cpu":
+		return getCPUInfo()
+	case "commandstats":
+		return getCommandStatsInfo(db)
+	case "latencystats":
+		return getLatencyStatsInfo(db)
+	case "persistence":
+		return getPersistenceInfo(db)
+	case "replication":
+		return getReplicationInfo(db)
 	case "cluster":
 		if getGodisRunningMode() == config.ClusterMode {
 			s := fmt.Sprintf("# Cluster\r\n"+
@@ -146,7 +168,7 @@ func GenGodisInfoString(section string, db *Server) []byte {
 			return []byte(s)
 		}
 	case "keyspace":
-		dbCount := config.Properties.Databases
+		dbCount := len(db.dbSet)
 		var serv []byte
 		for i := 0; i < dbCount; i++ {
 			keys, expiresKeys := db.GetDBSize(i)
@@ -162,6 +184,261 @@ func GenGodisInfoString(section string, db *Server) []byte {
 	return []byte("")
 }
 
+// getClientsInfo returns the clients section of INFO
+func getClientsInfo(db *Server) []byte {
+	blocked := 0
+	db.blockedConns.Range(func(key, value interface{}) bool {
+		blocked++
+		return true
+	})
+	var tracking int32
+	if db.tracking != nil {
+		tracking = atomic.LoadInt32(&db.tracking.count)
+	}
+	s := fmt.Sprintf("# Clients\r\n"+
+		"connected_clients:%d\r\n"+
+		"blocked_clients:%d\r\n"+
+		"tracking_clients:%d\r\n",
+		atomic.LoadInt32(&tcp.ClientCounter),
+		blocked,
+		tracking,
+	)
+	return []byte(s)
+}
+
+// humanBytes formats bytes like redis, such as 1.50M
+func humanBytes(n uint64) string {
+	units := []string{"B", "K", "M", "G", "T"}
+	f := float64(n)
+	i := 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+	return fmt.Sprintf("%.2f%s", f, units[i])
+}
+
+// getMemoryInfo returns the memory section of INFO, memory is measured by go runtime
+func getMemoryInfo(db *Server) []byte {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	peak := db.stats.updateMemoryPeak(mem.HeapAlloc)
+	s := fmt.Sprintf("# Memory\r\n"+
+		"used_memory:%d\r\n"+
+		"used_memory_human:%s\r\n"+
+		"used_memory_rss:%d\r\n"+
+		"used_memory_rss_human:%s\r\n"+
+		"used_memory_peak:%d\r\n"+
+		"used_memory_peak_human:%s\r\n"+
+		"heap_objects:%d\r\n"+
+		"gc_count:%d\r\n"+
+		"gc_pause_total_ms:%d\r\n"+
+		"maxmemory:%d\r\n"+
+		"maxmemory_human:%s\r\n"+
+		"maxmemory_policy:%s\r\n"+
+		"mem_allocator:go\r\n",
+		mem.HeapAlloc,
+		humanBytes(mem.HeapAlloc),
+		mem.Sys,
+		humanBytes(mem.Sys),
+		peak,
+		humanBytes(peak),
+		mem.HeapObjects,
+		mem.NumGC,
+		mem.PauseTotalNs/uint64(time.Millisecond),
+		config.Properties.MaxMemory,
+		humanBytes(uint64(config.Properties.MaxMemory)),
+		config.Properties.MaxMemoryPolicy,
+	)
+	return []byte(s)
+}
+
+// getStatsInfo returns the stats section of INFO
+func getStatsInfo(db *Server) []byte {
+	stats := &db.stats
+	s := fmt.Sprintf("# Stats\r\n"+
+		"total_connections_received:%d\r\n"+
+		"total_commands_processed:%d\r\n"+
+		"instantaneous_ops_per_sec:%d\r\n"+
+		"expired_keys:%d\r\n"+
+		"keyspace_hits:%d\r\n"+
+		"keyspace_misses:%d\r\n",
+		stats.connectionsReceived.Load(),
+		stats.commandsProcessed.Load(),
+		stats.instantaneousOps(),
+		stats.expiredKeys.Load(),
+		stats.keyspaceHits.Load(),
+		stats.keyspaceMisses.Load(),
+	)
+	return []byte(s)
+}
+
+// getCPUInfo returns the cpu section of INFO
+func getCPUInfo() []byte {
+	sys, user := getCPUUsage()
+	s := fmt.Sprintf("# CPU\r\n"+
+		"used_cpu_sys:%.6f\r\n"+
+		"used_cpu_user:%.6f\r\n"+
+		"goroutines:%d\r\n",
+		sys.Seconds(),
+		user.Seconds(),
+		runtime.NumGoroutine(),
+	)
+	return []byte(s)
+}
+
+// getCommandStatsInfo returns the commandstats section of INFO
+func getCommandStatsInfo(db *Server) []byte {
+	s := "# Commandstats\r\n"
+	names, stats := db.stats.sortedCommands()
+	for i, name := range names {
+		calls := stats[i].calls.Load()
+		usec := stats[i].usec.Load()
+		perCall := 0.0
+		if calls > 0 {
+			perCall = float64(usec) / float64(calls)
+		}
+		s += fmt.Sprintf("cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,failed_calls=%d,usec_max=%d\r\n",
+			name, calls, usec, perCall, stats[i].failed.Load(), stats[i].maxUsec.Load())
+	}
+	return []byte(s)
+}
+
+// getLatencyStatsInfo returns the latencystats section of INFO, percentiles are estimated by histogram
+func getLatencyStatsInfo(db *Server) []byte {
+	s := "# Latencystats\r\n"
+	names, stats := db.stats.sortedCommands()
+	for i, name := range names {
+		s += "latency_percentiles_usec_" + name +
+			":p50=" + formatFloat(stats[i].percentile(50)) +
+			",p99=" + formatFloat(stats[i].percentile(99)) +
+			",p99.9=" + formatFloat(stats[i].percentile(99.9)) + "\r\n"
+	}
+	return []byte(s)
+}
+
+// getPersistenceInfo returns the persistence section of INFO
+func getPersistenceInfo(db *Server) []byte {
+	bgSaveStatus := "ok"
+	if atomic.LoadInt32(&db.rdbSaveFailed) == 1 {
+		bgSaveStatus = "err"
+	}
+	s := fmt.Sprintf("# Persistence\r\n"+
+		"rdb_bgsave_in_progress:%d\r\n"+
+		"rdb_last_save_time:%d\r\n"+
+		"rdb_last_bgsave_status:%s\r\n",
+		atomic.LoadInt32(&db.rdbSaving),
+		atomic.LoadInt64(&db.lastSaveTime),
+		bgSaveStatus,
+	)
+	if db.persister == nil {
+		return []byte(s + "aof_enabled:0\r\n")
+	}
+	stats := db.persister.Stats()
+	writeStatus, rewriteStatus := "ok", "ok"
+	if !stats.LastWriteOK {
+		writeStatus = "err"
+	}
+	if !stats.LastRewriteOK {
+		rewriteStatus = "err"
+	}
+	rewriting := 0
+	if stats.RewriteInProgress {
+		rewriting = 1
+	}
+	s += fmt.Sprintf("aof_enabled:1\r\n"+
+		"aof_fsync:%s\r\n"+
+		"aof_rewrite_in_progress:%d\r\n"+
+		"aof_last_bgrewrite_status:%s\r\n"+
+		"aof_last_write_status:%s\r\n"+
+		"aof_current_size:%d\r\n"+
+		"aof_base_size:%d\r\n"+
+		"aof_buffer_length:%d\r\n",
+		stats.Fsync,
+		rewriting,
+		rewriteStatus,
+		writeStatus,
+		stats.CurrentSize,
+		stats.BaseSize,
+		stats.BufferLength,
+	)
+	return []byte(s)
+}
+
+// getReplicationInfo returns the replication section of INFO
+func getReplicationInfo(db *Server) []byte {
+	var s string
+	failoverState := db.getFailoverState()
+	if atomic.LoadInt32(&db.role) == slaveRole {
+		repl := db.slaveStatus
+		repl.mutex.Lock()
+		linkStatus := "down"
+		if repl.masterConn != nil {
+			linkStatus = "up"
+		}
+		lastIO := -1
+		if !repl.lastRecvTime.IsZero() {
+			lastIO = int(time.Since(repl.lastRecvTime) / time.Second)
+		}
+		s = fmt.Sprintf("# Replication\r\n"+
+			"role:slave\r\n"+
+			"master_failover_state:%s\r\n"+
+			"master_host:%s\r\n"+
+			"master_port:%d\r\n"+
+			"master_link_status:%s\r\n"+
+			"master_last_io_seconds_ago:%d\r\n"+
+			"slave_repl_offset:%d\r\n"+
+			"master_replid:%s\r\n",
+			failoverState,
+			repl.masterHost,
+			repl.masterPort,
+			linkStatus,
+			lastIO,
+			repl.replOffset,
+			repl.replId,
+		)
+		repl.mutex.Unlock()
+		return []byte(s)
+	}
+	master := db.masterStatus
+	master.mu.RLock()
+	defer master.mu.RUnlock()
+	s = fmt.Sprintf("# Replication\r\n"+
+		"role:master\r\n"+
+		"master_failover_state:%s\r\n"+
+		"connected_slaves:%d\r\n",
+		failoverState,
+		len(master.onlineSlaves),
+	)
+	i := 0
+	for slave := range master.onlineSlaves {
+		s += fmt.Sprintf("slave%d:addr=%s,state=online,offset=%d\r\n", i, slave.conn.RemoteAddr(), slave.ackOffset)
+		i++
+	}
+	backlog := master.backlog
+	backlogActive := 0
+	if backlog.buf != nil {
+		backlogActive = 1
+	}
+	s += fmt.Sprintf("master_replid:%s\r\n"+
+		"master_repl_offset:%d\r\n"+
+		"repl_backlog_active:%d\r\n"+
+		"repl_backlog_size:%d\r\n"+
+		"repl_backlog_first_byte_offset:%d\r\n"+
+		"repl_backlog_histlen:%d\r\n",
+		master.replId,
+		backlog.currentOffset,
+		backlogActive,
+		backlog.size,
+		backlog.beginOffset,
+		backlog.currentOffset-backlog.beginOffset,
+	)
+	return []byte(s)
+}
+
 // getGodisRunningMode return godis running mode
 func getGodisRunningMode() string {
 	if config.Properties.ClusterEnable {
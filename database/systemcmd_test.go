@@ -4,8 +4,10 @@ import (
 	"github.com/hdt3213/godis/config"
 	"github.com/hdt3213/godis/lib/utils"
 	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol"
 	"github.com/hdt3213/godis/redis/protocol/asserts"
 	"math/rand"
+	"strings"
 	"testing"
 	"time"
 )
@@ -50,6 +52,8 @@ func TestInfo(t *testing.T) {
 	asserts.AssertNotError(t, ret)
 	ret = testServer.Exec(c, utils.ToCmdLine("INFO", "client"))
 	asserts.AssertNotError(t, ret)
+	ret = testServer.Exec(c, utils.ToCmdLine("INFO", "persistence"))
+	asserts.AssertNotError(t, ret)
 	ret = testServer.Exec(c, utils.ToCmdLine("INFO", "cluster"))
 	asserts.AssertNotError(t, ret)
 	ret = testServer.Exec(c, utils.ToCmdLine("iNFO", "SeRvEr"))
@@ -60,6 +64,68 @@ func TestInfo(t *testing.T) {
 	asserts.AssertErrReply(t, ret, "ERR wrong number of arguments for 'info' command")
 	ret = testServer.Exec(c, utils.ToCmdLine("INFO", "abc"))
 	asserts.AssertErrReply(t, ret, "Invalid section for 'info' command")
+	for _, section := range []string{"clients", "memory", "stats", "cpu", "commandstats", "latencystats", "all", "everything", "default"} {
+		ret = testServer.Exec(c, utils.ToCmdLine("INFO", section))
+		asserts.AssertNotError(t, ret)
+	}
+}
+
+func TestInfoStats(t *testing.T) {
+	c := connection.NewFakeConn()
+	defer testServer.AfterClientClose(c)
+	key := utils.RandString(10)
+	defer testServer.Exec(c, utils.ToCmdLine("DEL", key))
+	testServer.Exec(c, utils.ToCmdLine("SET", key, "v"))
+	testServer.Exec(c, utils.ToCmdLine("GET", key))
+	testServer.Exec(c, utils.ToCmdLine("GET", key+"x"))
+	testServer.Exec(c, utils.ToCmdLine("INCR", key))
+
+	info := string(testServer.Exec(c, utils.ToCmdLine("INFO", "commandstats")).(*protocol.BulkReply).Arg)
+	if !strings.HasPrefix(info, "# Commandstats\r\n") || !strings.Contains(info, "cmdstat_get:calls=") {
+		t.Errorf("wrong commandstats: %s", info)
+	}
+	if !strings.Contains(info, "cmdstat_incr:calls=") || !strings.Contains(info, "failed_calls=1") {
+		t.Errorf("failed INCR should be counted: %s", info)
+	}
+	if !strings.Contains(info, ",usec_max=") {
+		t.Errorf("expect max latency: %s", info)
+	}
+	info = string(testServer.Exec(c, utils.ToCmdLine("INFO", "latencystats")).(*protocol.BulkReply).Arg)
+	if !strings.Contains(info, "latency_percentiles_usec_get:p50=") {
+		t.Errorf("wrong latencystats: %s", info)
+	}
+	info = string(testServer.Exec(c, utils.ToCmdLine("INFO", "stats")).(*protocol.BulkReply).Arg)
+	for _, field := range []string{"total_commands_processed:", "keyspace_hits:", "keyspace_misses:", "instantaneous_ops_per_sec:"} {
+		if !strings.Contains(info, field) {
+			t.Errorf("expect %s in stats: %s", field, info)
+		}
+	}
+	info = string(testServer.Exec(c, utils.ToCmdLine("INFO")).(*protocol.BulkReply).Arg)
+	if !strings.Contains(info, "# Memory\r\n") || strings.Contains(info, "# Commandstats") {
+		t.Errorf("wrong default sections: %s", info)
+	}
+	info = string(testServer.Exec(c, utils.ToCmdLine("INFO", "all")).(*protocol.BulkReply).Arg)
+	if !strings.Contains(info, "# Commandstats") {
+		t.Errorf("commandstats should be in all sections")
+	}
+}
+
+func TestLatencyPercentile(t *testing.T) {
+	stats := &commandStats{}
+	for i := 0; i < 99; i++ {
+		stats.histogram[3]++ // [4, 8) usec
+	}
+	stats.histogram[10]++ // [512, 1024) usec
+	if p := stats.percentile(50); p != 8 {
+		t.Errorf("expect p50 8, actual %f", p)
+	}
+	if p := stats.percentile(99.9); p != 1024 {
+		t.Errorf("expect p99.9 1024, actual %f", p)
+	}
+	bounds, counts := stats.cumulativeHistogram()
+	if len(bounds) != 2 || bounds[0] != 8 || bounds[1] != 1024 || counts[0] != 99 || counts[1] != 100 {
+		t.Errorf("unexpected histogram %v %v", bounds, counts)
+	}
 }
 
 func TestDbSize(t *testing.T) {
@@ -0,0 +1,210 @@
+package database
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/hdt3213/godis/datastruct/tdigest"
+	"github.com/hdt3213/godis/interface/database"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+// getAsTDigest returns t-digest bound to the given key, the t-digest is stored as a string payload
+func (db *DB) getAsTDigest(key string) (*tdigest.TDigest, protocol.ErrorReply) {
+	payload, errReply := db.getAsString(key)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if payload == nil {
+		return nil, nil
+	}
+	td, err := tdigest.FromBytes(payload)
+	if err != nil {
+		return nil, &protocol.WrongTypeErrReply{}
+	}
+	return td, nil
+}
+
+func (db *DB) mustGetTDigest(key string) (*tdigest.TDigest, protocol.ErrorReply) {
+	td, errReply := db.getAsTDigest(key)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if td == nil {
+		return nil, protocol.MakeErrReply("ERR T-Digest: key does not exist")
+	}
+	return td, nil
+}
+
+func parseCompression(arg []byte) (float64, protocol.ErrorReply) {
+	compression, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || compression <= 0 || math.IsInf(compression, 0) {
+		return 0, protocol.MakeErrReply("ERR T-Digest: error parsing compression parameter")
+	}
+	return compression, nil
+}
+
+func formatQuantile(v float64) []byte {
+	if math.IsNaN(v) {
+		return []byte("nan")
+	}
+	if math.IsInf(v, 1) {
+		return []byte("inf")
+	}
+	if math.IsInf(v, -1) {
+		return []byte("-inf")
+	}
+	return []byte(strconv.FormatFloat(v, 'f', -1, 64))
+}
+
+// execTDigestCreate creates an empty t-digest
+func execTDigestCreate(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	var compression float64 = tdigest.DefaultCompression
+	if len(args) == 3 && strings.ToUpper(string(args[1])) == "COMPRESSION" {
+		var errReply protocol.ErrorReply
+		compression, errReply = parseCompression(args[2])
+		if errReply != nil {
+			return errReply
+		}
+	} else if len(args) != 1 {
+		return protocol.MakeSyntaxErrReply()
+	}
+	_, exists := db.GetEntity(key)
+	if exists {
+		return protocol.MakeErrReply("ERR T-Digest: key already exists")
+	}
+	db.PutEntity(key, &database.DataEntity{Data: tdigest.Make(compression).ToBytes()})
+	db.addAof(utils.ToCmdLine3("tdigest.create", args...))
+	return protocol.MakeOkReply()
+}
+
+// execTDigestAdd adds observations into t-digest
+func execTDigestAdd(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	values := make([]float64, len(args)-1)
+	for i, arg := range args[1:] {
+		v, err := strconv.ParseFloat(string(arg), 64)
+		if err != nil || math.IsNaN(v) {
+			return protocol.MakeErrReply("ERR T-Digest: error parsing val parameter")
+		}
+		values[i] = v
+	}
+	td, errReply := db.mustGetTDigest(key)
+	if errReply != nil {
+		return errReply
+	}
+	td.Add(values...)
+	db.PutEntity(key, &database.DataEntity{Data: td.ToBytes()})
+	db.addAof(utils.ToCmdLine3("tdigest.add", args...))
+	return protocol.MakeOkReply()
+}
+
+// execTDigestQuantile estimates values at given quantiles
+func execTDigestQuantile(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	quantiles := make([]float64, len(args)-1)
+	for i, arg := range args[1:] {
+		q, err := strconv.ParseFloat(string(arg), 64)
+		if err != nil {
+			return protocol.MakeErrReply("ERR T-Digest: error parsing quantile")
+		}
+		if q < 0 || q > 1 {
+			return protocol.MakeErrReply("ERR T-Digest: quantile should be in [0,1]")
+		}
+		quantiles[i] = q
+	}
+	td, errReply := db.mustGetTDigest(key)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([][]byte, len(quantiles))
+	for i, q := range quantiles {
+		result[i] = formatQuantile(td.Quantile(q))
+	}
+	return protocol.MakeMultiBulkReply(result)
+}
+
+// parseTDigestMerge parses `destination numkeys source [source ...] [COMPRESSION compression] [OVERRIDE]`
+func parseTDigestMerge(args [][]byte) (sources []string, compression float64, override bool, errReply protocol.ErrorReply) {
+	numKeys, err := strconv.Atoi(string(args[1]))
+	if err != nil || numKeys <= 0 {
+		return nil, 0, false, protocol.MakeErrReply("ERR T-Digest: error parsing numkeys")
+	}
+	if len(args) < 2+numKeys {
+		return nil, 0, false, protocol.MakeErrReply("ERR T-Digest: wrong number of keys")
+	}
+	for _, arg := range args[2 : 2+numKeys] {
+		sources = append(sources, string(arg))
+	}
+	for i := 2 + numKeys; i < len(args); i++ {
+		arg := strings.ToUpper(string(args[i]))
+		if arg == "OVERRIDE" {
+			override = true
+		} else if arg == "COMPRESSION" && i+1 < len(args) {
+			compression, errReply = parseCompression(args[i+1])
+			if errReply != nil {
+				return nil, 0, false, errReply
+			}
+			i++
+		} else {
+			return nil, 0, false, protocol.MakeSyntaxErrReply()
+		}
+	}
+	return sources, compression, override, nil
+}
+
+func prepareTDigestMerge(args [][]byte) ([]string, []string) {
+	sources, _, _, errReply := parseTDigestMerge(args)
+	if errReply != nil {
+		return []string{string(args[0])}, nil
+	}
+	return []string{string(args[0])}, sources
+}
+
+// execTDigestMerge merges sources into destination
+func execTDigestMerge(db *DB, args [][]byte) redis.Reply {
+	dest := string(args[0])
+	sourceKeys, compression, override, errReply := parseTDigestMerge(args)
+	if errReply != nil {
+		return errReply
+	}
+	sources := make([]*tdigest.TDigest, len(sourceKeys))
+	var maxCompression float64
+	for i, key := range sourceKeys {
+		sources[i], errReply = db.mustGetTDigest(key)
+		if errReply != nil {
+			return errReply
+		}
+		maxCompression = math.Max(maxCompression, sources[i].Compression())
+	}
+	destTD, errReply := db.getAsTDigest(dest)
+	if errReply != nil {
+		return errReply
+	}
+	if destTD == nil || override {
+		// use the largest compression of sources unless specified
+		if compression == 0 {
+			compression = maxCompression
+		}
+		destTD = tdigest.Make(compression)
+	}
+	destTD.Merge(sources...)
+	db.PutEntity(dest, &database.DataEntity{Data: destTD.ToBytes()})
+	db.addAof(utils.ToCmdLine3("tdigest.merge", args...))
+	return protocol.MakeOkReply()
+}
+
+func init() {
+	registerCommand("TDigest.Create", execTDigestCreate, writeFirstKey, rollbackFirstKey, -2, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+	registerCommand("TDigest.Add", execTDigestAdd, writeFirstKey, rollbackFirstKey, -3, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+	registerCommand("TDigest.Quantile", execTDigestQuantile, readFirstKey, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
+	registerCommand("TDigest.Merge", execTDigestMerge, prepareTDigestMerge, rollbackFirstKey, -4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagMovableKeys}, 1, 1, 1)
+}
@@ -0,0 +1,42 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestTDigest(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	result := testDB.Exec(nil, utils.ToCmdLine("TDIGEST.ADD", key, "1"))
+	asserts.AssertErrReply(t, result, "ERR T-Digest: key does not exist")
+	result = testDB.Exec(nil, utils.ToCmdLine("TDIGEST.CREATE", key, "COMPRESSION", "100"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testDB.Exec(nil, utils.ToCmdLine("TDIGEST.CREATE", key))
+	asserts.AssertErrReply(t, result, "ERR T-Digest: key already exists")
+	result = testDB.Exec(nil, utils.ToCmdLine("TDIGEST.QUANTILE", key, "0.5"))
+	asserts.AssertMultiBulkReply(t, result, []string{"nan"})
+	result = testDB.Exec(nil, utils.ToCmdLine("TDIGEST.ADD", key, "1", "2", "3", "4", "5"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testDB.Exec(nil, utils.ToCmdLine("TDIGEST.QUANTILE", key, "0", "0.5", "1"))
+	asserts.AssertMultiBulkReply(t, result, []string{"1", "3", "5"})
+	result = testDB.Exec(nil, utils.ToCmdLine("TDIGEST.QUANTILE", key, "2"))
+	asserts.AssertErrReply(t, result, "ERR T-Digest: quantile should be in [0,1]")
+	result = testDB.Exec(nil, utils.ToCmdLine("TDIGEST.ADD", key, "abc"))
+	asserts.AssertErrReply(t, result, "ERR T-Digest: error parsing val parameter")
+
+	key2 := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("TDIGEST.CREATE", key2))
+	testDB.Exec(nil, utils.ToCmdLine("TDIGEST.ADD", key2, "6", "7", "8", "9", "10"))
+	dest := utils.RandString(10)
+	result = testDB.Exec(nil, utils.ToCmdLine("TDIGEST.MERGE", dest, "2", key, key2))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testDB.Exec(nil, utils.ToCmdLine("TDIGEST.QUANTILE", dest, "0", "1"))
+	asserts.AssertMultiBulkReply(t, result, []string{"1", "10"})
+	result = testDB.Exec(nil, utils.ToCmdLine("TDIGEST.MERGE", dest, "1", key2, "OVERRIDE"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testDB.Exec(nil, utils.ToCmdLine("TDIGEST.QUANTILE", dest, "0"))
+	asserts.AssertMultiBulkReply(t, result, []string{"6"})
+}
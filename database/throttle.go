@@ -0,0 +1,46 @@
+package database
+
+import (
+	"sync"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/ratelimit"
+)
+
+// commandThrottle limits commands per second of each client, see client-command-rate.
+// Master, replicas and internal connections without remote address (such as aof loading) are never throttled
+type commandThrottle struct {
+	// client id -> *ratelimit.Bucket
+	buckets sync.Map
+}
+
+// allow returns false if the client has sent too many commands
+func (t *commandThrottle) allow(c redis.Connection) bool {
+	rate := config.Properties.ClientCommandRate
+	if rate <= 0 || c.IsMaster() || c.IsSlave() || c.RemoteAddr() == "" {
+		return true
+	}
+	raw, ok := t.buckets.Load(c.ID())
+	if !ok {
+		// clients could send a second of commands at once
+		raw, _ = t.buckets.LoadOrStore(c.ID(), ratelimit.NewBucket(float64(rate), rate))
+	}
+	return raw.(*ratelimit.Bucket).Allow()
+}
+
+// setRate applies new client-command-rate to connected clients
+func (t *commandThrottle) setRate(rate int) {
+	t.buckets.Range(func(key, value interface{}) bool {
+		if rate <= 0 {
+			t.buckets.Delete(key)
+		} else {
+			value.(*ratelimit.Bucket).SetRate(float64(rate), rate)
+		}
+		return true
+	})
+}
+
+func (t *commandThrottle) remove(c redis.Connection) {
+	t.buckets.Delete(c.ID())
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+// remoteFakeConn is a fake connection with remote address, internal connections are not throttled
+type remoteFakeConn struct {
+	*connection.FakeConn
+}
+
+func (c remoteFakeConn) RemoteAddr() string {
+	return "127.0.0.1:50000"
+}
+
+func TestClientCommandRate(t *testing.T) {
+	fake := useFakeClock(t)
+	admin := connection.NewFakeConn()
+	defer testServer.AfterClientClose(admin)
+	c := remoteFakeConn{connection.NewFakeConn()}
+	defer testServer.AfterClientClose(c)
+
+	result := testServer.Exec(admin, utils.ToCmdLine("config", "set", "client-command-rate", "3"))
+	asserts.AssertStatusReply(t, result, "OK")
+	defer testServer.Exec(admin, utils.ToCmdLine("config", "set", "client-command-rate", "0"))
+	for i := 0; i < 3; i++ {
+		result = testServer.Exec(c, utils.ToCmdLine("ping"))
+		asserts.AssertStatusReply(t, result, "PONG")
+	}
+	result = testServer.Exec(c, utils.ToCmdLine("ping"))
+	asserts.AssertErrReply(t, result, "THROTTLED client command rate limit exceeded")
+	// internal connections are not limited
+	for i := 0; i < 5; i++ {
+		result = testServer.Exec(admin, utils.ToCmdLine("ping"))
+		asserts.AssertStatusReply(t, result, "PONG")
+	}
+
+	fake.Advance(time.Second)
+	result = testServer.Exec(c, utils.ToCmdLine("ping"))
+	asserts.AssertStatusReply(t, result, "PONG")
+
+	result = testServer.Exec(admin, utils.ToCmdLine("config", "set", "client-command-rate", "0"))
+	asserts.AssertStatusReply(t, result, "OK")
+	for i := 0; i < 5; i++ {
+		result = testServer.Exec(c, utils.ToCmdLine("ping"))
+		asserts.AssertStatusReply(t, result, "PONG")
+	}
+}
@@ -0,0 +1,49 @@
+package database
+
+import (
+	"time"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/logger"
+)
+
+// clientsCronInterval is the interval of checking idle clients
+var clientsCronInterval = time.Second
+
+// closeIdleClients closes clients idle for more than timeout config.
+// Master, replicas, pubsub clients and clients blocked by blocking commands are never closed for idle.
+// Connections which have not sent any command are unknown to server, so they are not checked
+func (server *Server) closeIdleClients(now time.Time) {
+	timeout := time.Duration(config.Properties.Timeout) * time.Second
+	if timeout <= 0 {
+		return
+	}
+	server.clients.Range(func(key, value interface{}) bool {
+		c := value.(redis.Connection)
+		if clientType(c) != "normal" {
+			return true
+		}
+		if _, blocked := server.blockedConns.Load(c); blocked {
+			return true
+		}
+		if _, lastInteraction := c.GetLastCmd(); now.Sub(lastInteraction) > timeout {
+			logger.Info("closing idle client " + c.RemoteAddr())
+			c.Kill(false)
+		}
+		return true
+	})
+}
+
+func (server *Server) clientsCron() {
+	ticker := time.NewTicker(clientsCronInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			server.closeIdleClients(now)
+		case <-server.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+)
+
+func TestCloseIdleClients(t *testing.T) {
+	timeout := config.Properties.Timeout
+	config.Properties.Timeout = 10
+	defer func() {
+		config.Properties.Timeout = timeout
+	}()
+	idle := connection.NewFakeConn()
+	defer testServer.AfterClientClose(idle)
+	sub := connection.NewFakeConn()
+	defer testServer.AfterClientClose(sub)
+	testServer.Exec(idle, utils.ToCmdLine("ping"))
+	testServer.Exec(sub, utils.ToCmdLine("subscribe", "idle-channel"))
+
+	testServer.closeIdleClients(time.Now().Add(5 * time.Second))
+	if _, err := idle.Write(nil); err != nil {
+		t.Error("client should not be closed before timeout")
+	}
+	testServer.closeIdleClients(time.Now().Add(11 * time.Second))
+	if _, err := idle.Write(nil); err == nil {
+		t.Error("idle client should be closed")
+	}
+	if _, err := sub.Write(nil); err != nil {
+		t.Error("pubsub client should not be closed")
+	}
+}
@@ -0,0 +1,193 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/hdt3213/godis/datastruct/topk"
+	"github.com/hdt3213/godis/interface/database"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+// getAsTopK returns top-k bound to the given key, the top-k is stored as a string payload.
+// If forUpdate is true, the top-k is restored from a copy of payload so that it could be modified
+func (db *DB) getAsTopK(key string, forUpdate bool) (*topk.TopK, protocol.ErrorReply) {
+	getPayload := db.getAsString
+	if forUpdate {
+		getPayload = db.getAsStringForUpdate
+	}
+	payload, errReply := getPayload(key)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if payload == nil {
+		return nil, protocol.MakeErrReply("TopK: key does not exist")
+	}
+	t, err := topk.FromBytes(payload)
+	if err != nil {
+		return nil, &protocol.WrongTypeErrReply{}
+	}
+	return t, nil
+}
+
+// execTopKReserve creates an empty top-k
+func execTopKReserve(db *DB, args [][]byte) redis.Reply {
+	if len(args) != 2 && len(args) != 5 {
+		return protocol.MakeArgNumErrReply("topk.reserve")
+	}
+	key := string(args[0])
+	k, err := strconv.ParseUint(string(args[1]), 10, 32)
+	if err != nil || k == 0 {
+		return protocol.MakeErrReply("TopK: invalid k")
+	}
+	var width, depth uint64 = topk.DefaultWidth, topk.DefaultDepth
+	decay := topk.DefaultDecay
+	if len(args) == 5 {
+		width, err = strconv.ParseUint(string(args[2]), 10, 32)
+		if err != nil || width == 0 {
+			return protocol.MakeErrReply("TopK: invalid width")
+		}
+		depth, err = strconv.ParseUint(string(args[3]), 10, 32)
+		if err != nil || depth == 0 {
+			return protocol.MakeErrReply("TopK: invalid depth")
+		}
+		decay, err = strconv.ParseFloat(string(args[4]), 64)
+		if err != nil || decay <= 0 || decay > 1 {
+			return protocol.MakeErrReply("TopK: invalid decay value. must be '<= 1' & '> 0'")
+		}
+	}
+	_, exists := db.GetEntity(key)
+	if exists {
+		return protocol.MakeErrReply("TopK: key already exists")
+	}
+	t, err := topk.Make(uint32(k), uint32(width), uint32(depth), decay)
+	if err != nil {
+		return protocol.MakeErrReply(err.Error())
+	}
+	db.PutEntity(key, &database.DataEntity{Data: t.ToBytes()})
+	db.addAof(utils.ToCmdLine3("topk.reserve", args...))
+	return protocol.MakeOkReply()
+}
+
+func incrTopK(db *DB, key string, items [][]byte, increments []uint32) redis.Reply {
+	t, errReply := db.getAsTopK(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([]redis.Reply, len(items))
+	for i, item := range items {
+		expelled, ok := t.IncrBy(item, increments[i])
+		if ok {
+			result[i] = protocol.MakeBulkReply([]byte(expelled))
+		} else {
+			result[i] = protocol.MakeNullBulkReply()
+		}
+	}
+	db.PutEntity(key, &database.DataEntity{Data: t.ToBytes()})
+	return protocol.MakeMultiRawReply(result)
+}
+
+// execTopKAdd adds items into top-k, returns items expelled from top-k
+func execTopKAdd(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	items := args[1:]
+	increments := make([]uint32, len(items))
+	for i := range increments {
+		increments[i] = 1
+	}
+	result := incrTopK(db, key, items, increments)
+	if !protocol.IsErrorReply(result) {
+		db.addAof(utils.ToCmdLine3("topk.add", args...))
+	}
+	return result
+}
+
+// execTopKIncrBy increases count of items, returns items expelled from top-k
+func execTopKIncrBy(db *DB, args [][]byte) redis.Reply {
+	if len(args)%2 != 1 {
+		return protocol.MakeArgNumErrReply("topk.incrby")
+	}
+	key := string(args[0])
+	size := (len(args) - 1) / 2
+	items := make([][]byte, size)
+	increments := make([]uint32, size)
+	for i := 0; i < size; i++ {
+		items[i] = args[2*i+1]
+		increment, err := strconv.ParseUint(string(args[2*i+2]), 10, 32)
+		if err != nil || increment == 0 || increment > 100000 {
+			return protocol.MakeErrReply("TopK: increment must be an integer between 1 and 100000")
+		}
+		increments[i] = uint32(increment)
+	}
+	result := incrTopK(db, key, items, increments)
+	if !protocol.IsErrorReply(result) {
+		db.addAof(utils.ToCmdLine3("topk.incrby", args...))
+	}
+	return result
+}
+
+// execTopKQuery checks whether items are in top-k
+func execTopKQuery(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	t, errReply := db.getAsTopK(key, false)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([]redis.Reply, len(args)-1)
+	for i, item := range args[1:] {
+		if t.Query(item) {
+			result[i] = protocol.MakeIntReply(1)
+		} else {
+			result[i] = protocol.MakeIntReply(0)
+		}
+	}
+	return protocol.MakeMultiRawReply(result)
+}
+
+// execTopKList returns items in top-k, sorted by count in descending order
+func execTopKList(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	withCount := false
+	if len(args) == 2 {
+		if strings.ToUpper(string(args[1])) != "WITHCOUNT" {
+			return protocol.MakeSyntaxErrReply()
+		}
+		withCount = true
+	} else if len(args) > 2 {
+		return protocol.MakeArgNumErrReply("topk.list")
+	}
+	t, errReply := db.getAsTopK(key, false)
+	if errReply != nil {
+		return errReply
+	}
+	items := t.List()
+	if !withCount {
+		result := make([][]byte, len(items))
+		for i, item := range items {
+			result[i] = []byte(item.Item)
+		}
+		return protocol.MakeMultiBulkReply(result)
+	}
+	result := make([]redis.Reply, 0, len(items)*2)
+	for _, item := range items {
+		result = append(result,
+			protocol.MakeBulkReply([]byte(item.Item)),
+			protocol.MakeIntReply(int64(item.Count)))
+	}
+	return protocol.MakeMultiRawReply(result)
+}
+
+func init() {
+	registerCommand("TopK.Reserve", execTopKReserve, writeFirstKey, rollbackFirstKey, -3, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+	registerCommand("TopK.Add", execTopKAdd, writeFirstKey, rollbackInPlaceString, -3, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+	registerCommand("TopK.IncrBy", execTopKIncrBy, writeFirstKey, rollbackInPlaceString, -4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+	registerCommand("TopK.Query", execTopKQuery, readFirstKey, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
+	registerCommand("TopK.List", execTopKList, readFirstKey, nil, -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
+}
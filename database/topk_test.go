@@ -0,0 +1,56 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestTopK(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	result := testDB.Exec(nil, utils.ToCmdLine("TOPK.ADD", key, "a"))
+	asserts.AssertErrReply(t, result, "TopK: key does not exist")
+	result = testDB.Exec(nil, utils.ToCmdLine("TOPK.RESERVE", key, "2"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testDB.Exec(nil, utils.ToCmdLine("TOPK.RESERVE", key, "2"))
+	asserts.AssertErrReply(t, result, "TopK: key already exists")
+	result = testDB.Exec(nil, utils.ToCmdLine("TOPK.ADD", key, "a", "b", "a"))
+	if string(result.ToBytes()) != "*3\r\n$-1\r\n$-1\r\n$-1\r\n" {
+		t.Errorf("unexpected reply: %s", result.ToBytes())
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("TOPK.INCRBY", key, "c", "10"))
+	if string(result.ToBytes()) != "*1\r\n$1\r\nb\r\n" {
+		t.Errorf("unexpected reply: %s", result.ToBytes())
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("TOPK.LIST", key))
+	asserts.AssertMultiBulkReply(t, result, []string{"c", "a"})
+	result = testDB.Exec(nil, utils.ToCmdLine("TOPK.LIST", key, "WITHCOUNT"))
+	if string(result.ToBytes()) != "*4\r\n$1\r\nc\r\n:10\r\n$1\r\na\r\n:2\r\n" {
+		t.Errorf("unexpected reply: %s", result.ToBytes())
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("TOPK.QUERY", key, "a", "b"))
+	if string(result.ToBytes()) != "*2\r\n:1\r\n:0\r\n" {
+		t.Errorf("unexpected reply: %s", result.ToBytes())
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("TOPK.RESERVE", utils.RandString(10), "2", "8", "7", "2"))
+	asserts.AssertErrReply(t, result, "TopK: invalid decay value. must be '<= 1' & '> 0'")
+}
+
+func TestTopKAddAfterCopy(t *testing.T) {
+	c := connection.NewFakeConn()
+	src, dest := utils.RandString(10), utils.RandString(10)
+	testServer.Exec(c, utils.ToCmdLine("TOPK.RESERVE", src, "2"))
+	result := testServer.Exec(c, utils.ToCmdLine("COPY", src, dest))
+	asserts.AssertIntReply(t, result, 1)
+	testServer.Exec(c, utils.ToCmdLine("TOPK.ADD", dest, "a"))
+	// top-k share nothing after COPY, counters of src are untouched
+	testServer.Exec(c, utils.ToCmdLine("TOPK.ADD", src, "a"))
+	result = testServer.Exec(c, utils.ToCmdLine("TOPK.LIST", src, "WITHCOUNT"))
+	if string(result.ToBytes()) != "*2\r\n$1\r\na\r\n:1\r\n" {
+		t.Errorf("unexpected reply: %s", result.ToBytes())
+	}
+	testServer.Exec(c, utils.ToCmdLine("DEL", src, dest))
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/hdt3213/godis/config"
+	idatabase "github.com/hdt3213/godis/interface/database"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/tracing"
+	"github.com/hdt3213/godis/redis/protocol"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedDB creates a span for each command if tracing is enabled, and unwraps commands relayed with trace context
+// by other nodes no matter tracing is enabled or not
+type tracedDB struct {
+	idatabase.DB
+}
+
+// WithTracing wraps db to trace commands, it should be the outermost wrapper so that spans cover the whole dispatch
+func WithTracing(db idatabase.DB) idatabase.DB {
+	return &tracedDB{
+		DB: db,
+	}
+}
+
+func (db *tracedDB) Exec(c redis.Connection, cmdLine [][]byte) redis.Reply {
+	origin := c.Context()
+	parent, cmdLine := tracing.UnwrapCmdLine(origin, cmdLine)
+	if !config.Properties.Tracing || len(cmdLine) == 0 {
+		return db.DB.Exec(c, cmdLine)
+	}
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	write, read := GetRelatedKeys(cmdLine)
+	ctx, span := tracing.Tracer().Start(parent, cmdName,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("db.system", "redis"),
+			attribute.String("db.operation", cmdName),
+			attribute.Int("db.redis.database_index", c.GetDBIndex()),
+			attribute.Int("godis.keys", len(write)+len(read)),
+			attribute.Int("godis.bytes_in", requestSize(cmdLine)),
+		))
+	c.SetContext(ctx)
+	defer c.SetContext(origin)
+	result := db.DB.Exec(c, cmdLine)
+	if result != nil {
+		span.SetAttributes(attribute.Int("godis.bytes_out", len(result.ToBytes())))
+	}
+	if errReply, ok := result.(protocol.ErrorReply); ok {
+		span.SetStatus(codes.Error, errReply.Error())
+	}
+	span.End()
+	return result
+}
+
+// requestSize returns the size of cmdLine in RESP
+func requestSize(cmdLine [][]byte) int {
+	size := 1 + len(strconv.Itoa(len(cmdLine))) + 2
+	for _, arg := range cmdLine {
+		size += 1 + len(strconv.Itoa(len(arg))) + 2 + len(arg) + 2
+	}
+	return size
+}
+
+// Shutdown implements idatabase.Shutdowner if the wrapped db does
+func (db *tracedDB) Shutdown() {
+	if s, ok := db.DB.(idatabase.Shutdowner); ok {
+		s.Shutdown()
+	} else {
+		db.DB.Close()
+	}
+}
+
+// ShutdownChan implements idatabase.Shutdowner if the wrapped db does
+func (db *tracedDB) ShutdownChan() <-chan struct{} {
+	if s, ok := db.DB.(idatabase.Shutdowner); ok {
+		return s.ShutdownChan()
+	}
+	return nil
+}
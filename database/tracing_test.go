@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/lib/tracing"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func spanAttr(span sdktrace.ReadOnlySpan, key string) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	otel.SetTracerProvider(provider)
+	config.Properties.Tracing = true
+	defer func() {
+		config.Properties.Tracing = false
+	}()
+
+	db := WithTracing(testServer)
+	c := connection.NewFakeConn()
+	defer testServer.AfterClientClose(c)
+	c.SelectDB(2)
+	key := utils.RandString(10)
+	result := db.Exec(c, utils.ToCmdLine("set", key, "v"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = db.Exec(c, utils.ToCmdLine("lpush", key, "v"))
+	asserts.AssertErrReply(t, result, "WRONGTYPE Operation against a key holding the wrong kind of value")
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expect 2 spans, actual %d", len(spans))
+	}
+	set := spans[0]
+	if set.Name() != "set" || set.SpanKind() != trace.SpanKindServer {
+		t.Errorf("unexpected span %s", set.Name())
+	}
+	if v := spanAttr(set, "db.redis.database_index").AsInt64(); v != 2 {
+		t.Errorf("expect db 2, actual %d", v)
+	}
+	if v := spanAttr(set, "godis.keys").AsInt64(); v != 1 {
+		t.Errorf("expect 1 key, actual %d", v)
+	}
+	if v := spanAttr(set, "godis.bytes_in").AsInt64(); v != int64(27+len(key)) {
+		t.Errorf("unexpected bytes in %d", v)
+	}
+	if v := spanAttr(set, "godis.bytes_out").AsInt64(); v != 5 {
+		t.Errorf("unexpected bytes out %d", v)
+	}
+	if set.Status().Code != codes.Unset {
+		t.Errorf("unexpected status %v", set.Status())
+	}
+	if spans[1].Status().Code != codes.Error {
+		t.Errorf("expect error status, actual %v", spans[1].Status())
+	}
+
+	// relayed by other nodes
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "relay")
+	wrapped := tracing.WrapCmdLine(ctx, utils.ToCmdLine("get", key))
+	result = db.Exec(c, wrapped)
+	asserts.AssertBulkReply(t, result, "v")
+	parent.End()
+	spans = recorder.Ended()
+	get := spans[2]
+	if get.Name() != "get" || get.Parent().SpanID() != parent.SpanContext().SpanID() ||
+		get.SpanContext().TraceID() != parent.SpanContext().TraceID() {
+		t.Error("span should be a child of the relaying span")
+	}
+	if trace.SpanContextFromContext(c.Context()).IsValid() {
+		t.Error("context of connection should be restored")
+	}
+
+	// commands relayed with trace context are executed even if tracing is disabled
+	config.Properties.Tracing = false
+	result = db.Exec(c, wrapped)
+	asserts.AssertBulkReply(t, result, "v")
+	if len(recorder.Ended()) != 4 {
+		t.Error("no span should be created if tracing is disabled")
+	}
+}
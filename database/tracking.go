@@ -0,0 +1,304 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+/*
+ * trackingTable implements server-assisted client side caching, see CLIENT TRACKING.
+ * In default mode, it remembers keys read by each tracking client, and sends invalidation message once a key is
+ * modified. A key is forgotten after invalidated, until the client reads it again.
+ * In BCAST mode, clients receive invalidation messages of all keys matching their prefixes regardless of reading.
+ * Godis speaks RESP2 only, so invalidation messages are published to the redirection client in the form of
+ * pub/sub messages of channel __redis__:invalidate.
+ */
+type trackingTable struct {
+	mu sync.Mutex
+	// key -> set of id of clients which may cache the key
+	keys map[string]map[uint64]struct{}
+	// id -> *trackingClient
+	clients map[uint64]*trackingClient
+	// number of tracking clients, checked without lock before every write
+	count int32
+	// id -> redis.Connection, used to find redirection clients
+	conns *sync.Map
+}
+
+type trackingClient struct {
+	conn     redis.Connection
+	redirect uint64
+	bcast    bool
+	prefixes []string
+	optIn    bool
+	optOut   bool
+	noLoop   bool
+	// caching is set by CLIENT CACHING, and affects the next command only
+	caching *bool
+}
+
+const invalidateChannel = "__redis__:invalidate"
+
+var invalidateMsgHeader = []byte("*3\r\n$7\r\nmessage\r\n$" + strconv.Itoa(len(invalidateChannel)) + "\r\n" +
+	invalidateChannel + "\r\n")
+
+func makeTrackingTable(conns *sync.Map) *trackingTable {
+	return &trackingTable{
+		keys:    make(map[string]map[uint64]struct{}),
+		clients: make(map[uint64]*trackingClient),
+		conns:   conns,
+	}
+}
+
+func (t *trackingTable) active() bool {
+	return t != nil && atomic.LoadInt32(&t.count) > 0
+}
+
+func (t *trackingTable) enable(client *trackingClient) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.clients[client.conn.ID()]; !ok {
+		atomic.AddInt32(&t.count, 1)
+	}
+	t.clients[client.conn.ID()] = client
+}
+
+// disable stops tracking the client, its id in t.keys will be cleaned while invalidating
+func (t *trackingTable) disable(c redis.Connection) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.clients[c.ID()]; ok {
+		delete(t.clients, c.ID())
+		atomic.AddInt32(&t.count, -1)
+	}
+}
+
+func (t *trackingTable) getClient(c redis.Connection) *trackingClient {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.clients[c.ID()]
+}
+
+// trackRead remembers keys read by c
+func (t *trackingTable) trackRead(c redis.Connection, keys []string, result redis.Reply) {
+	if !t.active() || c == nil || len(keys) == 0 || protocol.IsErrorReply(result) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	client := t.clients[c.ID()]
+	if client == nil || client.bcast {
+		return
+	}
+	if client.optIn && (client.caching == nil || !*client.caching) {
+		return
+	}
+	if client.optOut && client.caching != nil && !*client.caching {
+		return
+	}
+	for _, key := range keys {
+		ids := t.keys[key]
+		if ids == nil {
+			ids = make(map[uint64]struct{})
+			t.keys[key] = ids
+		}
+		ids[c.ID()] = struct{}{}
+	}
+}
+
+// afterCommand resets CLIENT CACHING which only affects one command
+func (t *trackingTable) afterCommand(c redis.Connection) {
+	if !t.active() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if client := t.clients[c.ID()]; client != nil {
+		client.caching = nil
+	}
+}
+
+// invalidate sends invalidation messages of modified keys, modifier is nil if keys are not modified by a client
+func (t *trackingTable) invalidate(modifier redis.Connection, keys []string) {
+	if !t.active() || len(keys) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	targets := make(map[uint64][]string)
+	for _, key := range keys {
+		for id := range t.keys[key] {
+			client := t.clients[id]
+			if client == nil || client.bcast || (client.noLoop && client.conn == modifier) {
+				continue
+			}
+			targets[id] = append(targets[id], key)
+		}
+		delete(t.keys, key)
+	}
+	for id, client := range t.clients {
+		if !client.bcast || (client.noLoop && client.conn == modifier) {
+			continue
+		}
+		for _, key := range keys {
+			if matchPrefixes(client.prefixes, key) {
+				targets[id] = append(targets[id], key)
+			}
+		}
+	}
+	for id, keys := range targets {
+		t.send(t.clients[id], protocol.MakeMultiBulkReply(toBulks(keys)).ToBytes())
+	}
+}
+
+// invalidateAll notifies all tracking clients to flush their caches, after FLUSHDB or FLUSHALL
+func (t *trackingTable) invalidateAll() {
+	if !t.active() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.keys = make(map[string]map[uint64]struct{})
+	for _, client := range t.clients {
+		t.send(client, (&protocol.NullMultiBulkReply{}).ToBytes())
+	}
+}
+
+func (t *trackingTable) send(client *trackingClient, payload []byte) {
+	raw, ok := t.conns.Load(client.redirect)
+	if !ok {
+		// redirection client has gone
+		return
+	}
+	target, _ := raw.(redis.Connection)
+	_, _ = target.Write(append(append([]byte{}, invalidateMsgHeader...), payload...))
+}
+
+func matchPrefixes(prefixes []string, key string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func toBulks(keys []string) [][]byte {
+	bulks := make([][]byte, len(keys))
+	for i, key := range keys {
+		bulks[i] = []byte(key)
+	}
+	return bulks
+}
+
+// execClientTracking handles CLIENT TRACKING ON|OFF [REDIRECT id] [PREFIX prefix ...] [BCAST] [OPTIN] [OPTOUT] [NOLOOP]
+func (server *Server) execClientTracking(c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.MakeArgNumErrReply("client|tracking")
+	}
+	switch strings.ToLower(string(args[0])) {
+	case "off":
+		server.tracking.disable(c)
+		return protocol.MakeOkReply()
+	case "on":
+	default:
+		return &protocol.SyntaxErrReply{}
+	}
+	client := &trackingClient{conn: c}
+	for i := 1; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "redirect":
+			if i+1 >= len(args) {
+				return &protocol.SyntaxErrReply{}
+			}
+			id, err := strconv.ParseUint(string(args[i+1]), 10, 64)
+			if err != nil {
+				return protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if _, ok := server.clients.Load(id); !ok {
+				return protocol.MakeErrReply("ERR The client ID you want redirect to does not exist")
+			}
+			client.redirect = id
+			i++
+		case "prefix":
+			if i+1 >= len(args) {
+				return &protocol.SyntaxErrReply{}
+			}
+			client.prefixes = append(client.prefixes, string(args[i+1]))
+			i++
+		case "bcast":
+			client.bcast = true
+		case "optin":
+			client.optIn = true
+		case "optout":
+			client.optOut = true
+		case "noloop":
+			client.noLoop = true
+		default:
+			return &protocol.SyntaxErrReply{}
+		}
+	}
+	if len(client.prefixes) > 0 && !client.bcast {
+		return protocol.MakeErrReply("ERR PREFIX option requires BCAST mode to be enabled")
+	}
+	if client.optIn && client.optOut {
+		return protocol.MakeErrReply("ERR You can't use both OPTIN and OPTOUT")
+	}
+	if client.bcast && (client.optIn || client.optOut) {
+		return protocol.MakeErrReply("ERR OPTIN and OPTOUT are not compatible with BCAST")
+	}
+	if client.redirect == 0 {
+		// invalidation messages cannot be pushed to the client itself without RESP3
+		return protocol.MakeErrReply("ERR REDIRECT is required since RESP3 is not supported")
+	}
+	server.tracking.enable(client)
+	return protocol.MakeOkReply()
+}
+
+// execClientCaching handles CLIENT CACHING YES|NO
+func (server *Server) execClientCaching(c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return protocol.MakeArgNumErrReply("client|caching")
+	}
+	var caching bool
+	switch strings.ToLower(string(args[0])) {
+	case "yes":
+		caching = true
+	case "no":
+		caching = false
+	default:
+		return &protocol.SyntaxErrReply{}
+	}
+	t := server.tracking
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	client := t.clients[c.ID()]
+	if client == nil || (!client.optIn && !client.optOut) {
+		return protocol.MakeErrReply("ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled")
+	}
+	if client.optIn && !caching {
+		return protocol.MakeErrReply("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+	}
+	if client.optOut && caching {
+		return protocol.MakeErrReply("ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+	}
+	client.caching = &caching
+	return protocol.MakeOkReply()
+}
+
+// execClientGetRedir handles CLIENT GETREDIR, returns -1 if tracking is off
+func (server *Server) execClientGetRedir(c redis.Connection) redis.Reply {
+	client := server.tracking.getClient(c)
+	if client == nil {
+		return protocol.MakeIntReply(-1)
+	}
+	return protocol.MakeIntReply(int64(client.redirect))
+}
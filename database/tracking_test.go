@@ -0,0 +1,130 @@
+package database
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/parser"
+	"github.com/hdt3213/godis/redis/protocol"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+// readInvalidation returns keys in the invalidation message written to conn, nil if no message
+func readInvalidation(t *testing.T, conn *connection.FakeConn) []string {
+	t.Helper()
+	data := conn.Bytes()
+	conn.Clean()
+	if len(data) == 0 {
+		return nil
+	}
+	header := string(invalidateMsgHeader)
+	if len(data) < len(header) || string(data[:len(header)]) != header {
+		t.Fatalf("illegal invalidation message: %q", data)
+	}
+	keys := make([]string, 0)
+	if string(data[len(header):]) == "*-1\r\n" {
+		// all keys are invalidated
+		return keys
+	}
+	reply, err := parser.ParseOne(data[len(header):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := reply.(*protocol.MultiBulkReply); ok {
+		for _, arg := range r.Args {
+			keys = append(keys, string(arg))
+		}
+	}
+	return keys
+}
+
+func assertKeys(t *testing.T, actual []string, expected ...string) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Errorf("expect invalidated keys %v, actual %v", expected, actual)
+		return
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("expect invalidated keys %v, actual %v", expected, actual)
+			return
+		}
+	}
+}
+
+func TestClientTracking(t *testing.T) {
+	redir := connection.NewFakeConn()
+	testServer.Exec(redir, utils.ToCmdLine("subscribe", invalidateChannel))
+	redir.Clean()
+	c := connection.NewFakeConn()
+	defer testServer.AfterClientClose(c)
+	defer testServer.AfterClientClose(redir)
+	redirID := strconv.FormatUint(redir.ID(), 10)
+
+	result := testServer.Exec(c, utils.ToCmdLine("client", "tracking", "on"))
+	asserts.AssertErrReply(t, result, "ERR REDIRECT is required since RESP3 is not supported")
+	result = testServer.Exec(c, utils.ToCmdLine("client", "tracking", "on", "redirect", "0"))
+	asserts.AssertErrReply(t, result, "ERR The client ID you want redirect to does not exist")
+	result = testServer.Exec(c, utils.ToCmdLine("client", "tracking", "on", "redirect", redirID, "prefix", "a"))
+	asserts.AssertErrReply(t, result, "ERR PREFIX option requires BCAST mode to be enabled")
+	result = testServer.Exec(c, utils.ToCmdLine("client", "tracking", "on", "redirect", redirID))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testServer.Exec(c, utils.ToCmdLine("client", "getredir"))
+	asserts.AssertIntReply(t, result, int(redir.ID()))
+
+	key := utils.RandString(10)
+	other := connection.NewFakeConn()
+	testServer.Exec(other, utils.ToCmdLine("set", key, "1"))
+	if keys := readInvalidation(t, redir); keys != nil {
+		t.Errorf("unexpected invalidation before reading: %v", keys)
+	}
+	testServer.Exec(c, utils.ToCmdLine("get", key))
+	testServer.Exec(other, utils.ToCmdLine("set", key, "2"))
+	assertKeys(t, readInvalidation(t, redir), key)
+	// key is forgotten after invalidated
+	testServer.Exec(other, utils.ToCmdLine("set", key, "3"))
+	if keys := readInvalidation(t, redir); keys != nil {
+		t.Errorf("unexpected invalidation: %v", keys)
+	}
+
+	// flushdb invalidates all keys
+	testServer.Exec(c, utils.ToCmdLine("get", key))
+	testServer.Exec(other, utils.ToCmdLine("flushdb"))
+	if keys := readInvalidation(t, redir); len(keys) != 0 {
+		t.Errorf("expect null invalidation message, actual %v", keys)
+	}
+
+	// bcast
+	result = testServer.Exec(c, utils.ToCmdLine("client", "tracking", "on", "redirect", redirID, "bcast", "prefix", "user:"))
+	asserts.AssertStatusReply(t, result, "OK")
+	testServer.Exec(other, utils.ToCmdLine("set", "user:"+key, "1"))
+	testServer.Exec(other, utils.ToCmdLine("set", key, "1"))
+	assertKeys(t, readInvalidation(t, redir), "user:"+key)
+	testServer.Exec(other, utils.ToCmdLine("del", "user:"+key, key))
+	assertKeys(t, readInvalidation(t, redir), "user:"+key)
+
+	// optin
+	result = testServer.Exec(c, utils.ToCmdLine("client", "tracking", "on", "redirect", redirID, "optin", "noloop"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testServer.Exec(c, utils.ToCmdLine("client", "caching", "no"))
+	asserts.AssertErrReply(t, result, "ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+	testServer.Exec(c, utils.ToCmdLine("get", key))
+	testServer.Exec(other, utils.ToCmdLine("set", key, "1"))
+	if keys := readInvalidation(t, redir); keys != nil {
+		t.Errorf("unexpected invalidation of key not cached: %v", keys)
+	}
+	testServer.Exec(c, utils.ToCmdLine("client", "caching", "yes"))
+	testServer.Exec(c, utils.ToCmdLine("get", key))
+	// noloop
+	testServer.Exec(c, utils.ToCmdLine("set", key, "2"))
+	if keys := readInvalidation(t, redir); keys != nil {
+		t.Errorf("unexpected invalidation of modification by itself: %v", keys)
+	}
+
+	testServer.Exec(c, utils.ToCmdLine("client", "tracking", "off"))
+	result = testServer.Exec(c, utils.ToCmdLine("client", "getredir"))
+	asserts.AssertIntReply(t, result, -1)
+	testServer.Exec(other, utils.ToCmdLine("del", key))
+}
@@ -6,8 +6,11 @@ import (
 	"strings"
 )
 
-// Watch set watching keys
+// Watch set watching keys, EXEC will be aborted if any of them changed before it
 func Watch(db *DB, conn redis.Connection, args [][]byte) redis.Reply {
+	if conn.InMultiState() {
+		return protocol.MakeErrReply("ERR WATCH inside MULTI is not allowed")
+	}
 	watching := conn.GetWatching()
 	for _, bkey := range args {
 		key := string(bkey)
@@ -16,6 +19,20 @@ func Watch(db *DB, conn redis.Connection, args [][]byte) redis.Reply {
 	return protocol.MakeOkReply()
 }
 
+// UnWatch forgets all watched keys
+func UnWatch(conn redis.Connection) redis.Reply {
+	watching := conn.GetWatching()
+	for key := range watching {
+		delete(watching, key)
+	}
+	return protocol.MakeOkReply()
+}
+
+// execQueuedUnWatch executes UNWATCH queued in MULTI, it does nothing since EXEC releases watched keys anyway
+func execQueuedUnWatch(db *DB, args [][]byte) redis.Reply {
+	return protocol.MakeOkReply()
+}
+
 func execGetVersion(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	ver := db.GetVersion(key)
@@ -24,6 +41,8 @@ func execGetVersion(db *DB, args [][]byte) redis.Reply {
 
 func init() {
 	registerCommand("GetVer", execGetVersion, readAllKeys, nil, 2, flagReadOnly)
+	registerCommand("UnWatch", execQueuedUnWatch, noPrepare, nil, 1, flagReadOnly).
+		attachCommandExtra([]string{redisFlagNoScript, redisFlagFast}, 0, 0, 0)
 }
 
 // invoker should lock watching keys
@@ -104,7 +123,7 @@ func (db *DB) ExecMulti(conn redis.Connection, watching map[string]uint32, cmdLi
 	defer db.RWUnLocks(writeKeys, readKeys)
 
 	if isWatchingChanged(db, watching) { // watching keys changed, abort
-		return protocol.MakeEmptyMultiBulkReply()
+		return &protocol.NullMultiBulkReply{}
 	}
 	// execute
 	results := make([]redis.Reply, 0, len(cmdLines))
@@ -164,11 +183,11 @@ func (db *DB) GetUndoLogs(cmdLine [][]byte) []CmdLine {
 }
 
 // GetRelatedKeys analysis related keys
-// returns related write keys and read keys
+// returns related write keys and read keys, or nil if cmdLine has wrong number of arguments
 func GetRelatedKeys(cmdLine [][]byte) ([]string, []string) {
 	cmdName := strings.ToLower(string(cmdLine[0]))
 	cmd, ok := cmdTable[cmdName]
-	if !ok {
+	if !ok || !validateArity(cmd.arity, cmdLine) {
 		return nil, nil
 	}
 	prepare := cmd.prepare
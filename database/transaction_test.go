@@ -5,6 +5,7 @@ import (
 	"github.com/hdt3213/godis/redis/connection"
 	"github.com/hdt3213/godis/redis/protocol/asserts"
 	"testing"
+	"time"
 )
 
 func TestMulti(t *testing.T) {
@@ -115,3 +116,55 @@ func TestWatch(t *testing.T) {
 		}
 	}
 }
+
+func TestWatchAbort(t *testing.T) {
+	conn := new(connection.FakeConn)
+	other := new(connection.FakeConn)
+	testServer.Exec(conn, utils.ToCmdLine("FLUSHALL"))
+	key := utils.RandString(10)
+	key2 := utils.RandString(10)
+
+	// modified by another client
+	testServer.Exec(conn, utils.ToCmdLine("watch", key))
+	testServer.Exec(other, utils.ToCmdLine("set", key, "1"))
+	testServer.Exec(conn, utils.ToCmdLine("multi"))
+	testServer.Exec(conn, utils.ToCmdLine("set", key2, "1"))
+	result := testServer.Exec(conn, utils.ToCmdLine("exec"))
+	asserts.AssertNullMultiBulk(t, result)
+	result = testServer.Exec(conn, utils.ToCmdLine("exists", key2))
+	asserts.AssertIntReply(t, result, 0)
+
+	// unwatch forgets watched keys
+	testServer.Exec(conn, utils.ToCmdLine("watch", key))
+	testServer.Exec(other, utils.ToCmdLine("set", key, "2"))
+	result = testServer.Exec(conn, utils.ToCmdLine("unwatch"))
+	asserts.AssertStatusReply(t, result, "OK")
+	testServer.Exec(conn, utils.ToCmdLine("multi"))
+	result = testServer.Exec(conn, utils.ToCmdLine("watch", key))
+	asserts.AssertErrReply(t, result, "ERR WATCH inside MULTI is not allowed")
+	result = testServer.Exec(conn, utils.ToCmdLine("unwatch"))
+	asserts.AssertStatusReply(t, result, "QUEUED")
+	testServer.Exec(conn, utils.ToCmdLine("set", key2, "1"))
+	result = testServer.Exec(conn, utils.ToCmdLine("exec"))
+	asserts.AssertNotError(t, result)
+	result = testServer.Exec(conn, utils.ToCmdLine("get", key2))
+	asserts.AssertBulkReply(t, result, "1")
+
+	// flushdb touches all keys
+	testServer.Exec(conn, utils.ToCmdLine("watch", key))
+	testServer.Exec(other, utils.ToCmdLine("flushdb"))
+	testServer.Exec(conn, utils.ToCmdLine("multi"))
+	testServer.Exec(conn, utils.ToCmdLine("set", key2, "2"))
+	result = testServer.Exec(conn, utils.ToCmdLine("exec"))
+	asserts.AssertNullMultiBulk(t, result)
+
+	// expiration touches the key
+	testServer.Exec(conn, utils.ToCmdLine("set", key, "1", "px", "10"))
+	testServer.Exec(conn, utils.ToCmdLine("watch", key))
+	time.Sleep(20 * time.Millisecond)
+	testServer.Exec(other, utils.ToCmdLine("get", key))
+	testServer.Exec(conn, utils.ToCmdLine("multi"))
+	testServer.Exec(conn, utils.ToCmdLine("set", key2, "3"))
+	result = testServer.Exec(conn, utils.ToCmdLine("exec"))
+	asserts.AssertNullMultiBulk(t, result)
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"github.com/hdt3213/godis/aof"
+	Dict "github.com/hdt3213/godis/datastruct/dict"
 	"github.com/hdt3213/godis/lib/utils"
 	"strconv"
 )
@@ -56,11 +57,30 @@ func rollbackGivenKeys(db *DB, keys ...string) []CmdLine {
 				aof.EntityToCmd(key, entity).Args,
 				toTTLCmd(db, key).Args,
 			)
+			for _, cmd := range aof.MakeFieldExpireCmds(key, entity) {
+				undoCmdLines = append(undoCmdLines, cmd.Args)
+			}
 		}
 	}
 	return undoCmdLines
 }
 
+// rollbackInPlaceString keeps a copy of string payload, for values modified in place such as bloom filters
+func rollbackInPlaceString(db *DB, args [][]byte) []CmdLine {
+	key := string(args[0])
+	payload, errReply := db.getAsString(key)
+	if errReply != nil || payload == nil {
+		return rollbackFirstKey(db, args)
+	}
+	snapshot := make([]byte, len(payload))
+	copy(snapshot, payload)
+	return []CmdLine{
+		utils.ToCmdLine("DEL", key),
+		utils.ToCmdLine3("SET", []byte(key), snapshot),
+		toTTLCmd(db, key).Args,
+	}
+}
+
 func rollbackHashFields(db *DB, key string, fields ...string) []CmdLine {
 	var undoCmdLines [][][]byte
 	dict, errReply := db.getAsDict(key)
@@ -84,6 +104,11 @@ func rollbackHashFields(db *DB, key string, fields ...string) []CmdLine {
 			undoCmdLines = append(undoCmdLines,
 				utils.ToCmdLine("HSET", key, field, string(value)),
 			)
+			if hash, ok := dict.(*Dict.TTLDict); ok {
+				if expireAt, ok := hash.ExpireTime(field); ok {
+					undoCmdLines = append(undoCmdLines, aof.MakeFieldExpireCmd(key, expireAt, field).Args)
+				}
+			}
 		}
 	}
 	return undoCmdLines
@@ -0,0 +1,264 @@
+// Package bloom implements a scalable bloom filter which is stored as a plain string payload
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+)
+
+/*
+ * payload layout (big endian):
+ *   header: magic(4) | expansion(4) | nonScaling(1) | filterCount(4)
+ *   each sub-filter: capacity(8) | count(8) | errorRate(8) | hashes(4) | bitSize(8) | bits(bitSize/8)
+ * sub-filters are appended when the latest one is full, every new one has `expansion` times capacity
+ * and a tighter error rate so that the compound error rate stays below the requested one
+ */
+
+const (
+	headerSize      = 13
+	filterMetaSize  = 36
+	tighteningRatio = 0.5
+	// DefaultErrorRate is used when BF.ADD creates a filter implicitly
+	DefaultErrorRate = 0.01
+	// DefaultCapacity is used when BF.ADD creates a filter implicitly
+	DefaultCapacity = 100
+	// DefaultExpansion is the growth factor of sub-filters
+	DefaultExpansion = 2
+	maxBitSize       = 1 << 35 // 512MiB of bits per sub-filter
+	maxHashes        = 64
+)
+
+var magic = []byte("GBF1")
+
+var (
+	// ErrNotBloom means payload is not a serialized bloom filter
+	ErrNotBloom = errors.New("not a bloom filter")
+	// ErrFull means the filter is non-scaling and has reached its capacity
+	ErrFull = errors.New("ERR non scaling filter is full")
+	// ErrTooLarge means the requested capacity and error rate need too much memory
+	ErrTooLarge = errors.New("ERR capacity is too large")
+)
+
+// ScalableFilter is a chain of bloom filters which grows when the latest one is full
+type ScalableFilter struct {
+	buf     []byte
+	filters []*filter
+}
+
+// filter is a view of one sub-filter inside ScalableFilter.buf
+type filter struct {
+	offset int // offset of sub-filter meta in buf
+}
+
+// Make creates a new scalable bloom filter
+func Make(errorRate float64, capacity uint64, expansion uint32, nonScaling bool) (*ScalableFilter, error) {
+	if expansion == 0 {
+		expansion = DefaultExpansion
+	}
+	sf := &ScalableFilter{
+		buf: make([]byte, headerSize),
+	}
+	copy(sf.buf, magic)
+	binary.BigEndian.PutUint32(sf.buf[4:], expansion)
+	if nonScaling {
+		sf.buf[8] = 1
+	}
+	err := sf.appendFilter(capacity, errorRate)
+	if err != nil {
+		return nil, err
+	}
+	return sf, nil
+}
+
+// IsBloom checks whether the given payload is a serialized bloom filter
+func IsBloom(payload []byte) bool {
+	return len(payload) >= headerSize && string(payload[:4]) == string(magic)
+}
+
+// FromBytes restores a filter from payload, the returned filter shares memory with payload
+func FromBytes(payload []byte) (*ScalableFilter, error) {
+	if !IsBloom(payload) {
+		return nil, ErrNotBloom
+	}
+	sf := &ScalableFilter{
+		buf: payload,
+	}
+	// header comes from user supplied strings, validate it before any hashing or scaling relies on it
+	n := int(binary.BigEndian.Uint32(payload[9:]))
+	if n == 0 || sf.expansion() == 0 {
+		return nil, ErrNotBloom
+	}
+	offset := headerSize
+	for i := 0; i < n; i++ {
+		if offset+filterMetaSize > len(payload) {
+			return nil, ErrNotBloom
+		}
+		f := &filter{offset: offset}
+		bitSize := f.bitSize(sf.buf)
+		hashes := f.hashes(sf.buf)
+		if bitSize == 0 || bitSize > maxBitSize || hashes == 0 || hashes > maxHashes {
+			return nil, ErrNotBloom
+		}
+		offset += filterMetaSize + int(toByteSize(bitSize))
+		if offset > len(payload) {
+			return nil, ErrNotBloom
+		}
+		sf.filters = append(sf.filters, f)
+	}
+	if offset != len(payload) {
+		return nil, ErrNotBloom
+	}
+	return sf, nil
+}
+
+// ToBytes returns serialized filter
+func (sf *ScalableFilter) ToBytes() []byte {
+	return sf.buf
+}
+
+func toByteSize(bitSize uint64) uint64 {
+	return (bitSize + 7) / 8
+}
+
+func (sf *ScalableFilter) expansion() uint32 {
+	return binary.BigEndian.Uint32(sf.buf[4:])
+}
+
+func (sf *ScalableFilter) nonScaling() bool {
+	return sf.buf[8] == 1
+}
+
+func (sf *ScalableFilter) appendFilter(capacity uint64, errorRate float64) error {
+	// optimal bits: -n*ln(p)/(ln2)^2, optimal hash functions: -log2(p)
+	bitSize := uint64(math.Ceil(-float64(capacity) * math.Log(errorRate) / (math.Ln2 * math.Ln2)))
+	if bitSize > maxBitSize {
+		return ErrTooLarge
+	}
+	hashes := uint32(math.Ceil(-math.Log2(errorRate)))
+	if hashes == 0 {
+		hashes = 1
+	} else if hashes > maxHashes {
+		hashes = maxHashes
+	}
+	f := &filter{offset: len(sf.buf)}
+	meta := make([]byte, filterMetaSize)
+	binary.BigEndian.PutUint64(meta[0:], capacity)
+	binary.BigEndian.PutUint64(meta[16:], math.Float64bits(errorRate))
+	binary.BigEndian.PutUint32(meta[24:], hashes)
+	binary.BigEndian.PutUint64(meta[28:], bitSize)
+	sf.buf = append(sf.buf, meta...)
+	sf.buf = append(sf.buf, make([]byte, toByteSize(bitSize))...)
+	sf.filters = append(sf.filters, f)
+	binary.BigEndian.PutUint32(sf.buf[9:], uint32(len(sf.filters)))
+	return nil
+}
+
+func (f *filter) capacity(buf []byte) uint64 {
+	return binary.BigEndian.Uint64(buf[f.offset:])
+}
+
+func (f *filter) count(buf []byte) uint64 {
+	return binary.BigEndian.Uint64(buf[f.offset+8:])
+}
+
+func (f *filter) errorRate(buf []byte) float64 {
+	return math.Float64frombits(binary.BigEndian.Uint64(buf[f.offset+16:]))
+}
+
+func (f *filter) hashes(buf []byte) uint32 {
+	return binary.BigEndian.Uint32(buf[f.offset+24:])
+}
+
+func (f *filter) bitSize(buf []byte) uint64 {
+	return binary.BigEndian.Uint64(buf[f.offset+28:])
+}
+
+// locations uses double hashing to simulate k independent hash functions
+func locations(item []byte, k uint32, m uint64) []uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(item)
+	h1 := h.Sum64()
+	h2 := h1>>32 | h1<<32 | 1
+	result := make([]uint64, k)
+	for i := uint32(0); i < k; i++ {
+		result[i] = (h1 + uint64(i)*h2) % m
+	}
+	return result
+}
+
+func (f *filter) test(buf []byte, item []byte) bool {
+	bits := buf[f.offset+filterMetaSize:]
+	for _, loc := range locations(item, f.hashes(buf), f.bitSize(buf)) {
+		if bits[loc/8]&(1<<(loc%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *filter) add(buf []byte, item []byte) {
+	bits := buf[f.offset+filterMetaSize:]
+	for _, loc := range locations(item, f.hashes(buf), f.bitSize(buf)) {
+		bits[loc/8] |= 1 << (loc % 8)
+	}
+	binary.BigEndian.PutUint64(buf[f.offset+8:], f.count(buf)+1)
+}
+
+// Exists returns whether item may have been added
+func (sf *ScalableFilter) Exists(item []byte) bool {
+	for i := len(sf.filters) - 1; i >= 0; i-- {
+		if sf.filters[i].test(sf.buf, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add puts item into filter, returns false if the item may exist already
+func (sf *ScalableFilter) Add(item []byte) (bool, error) {
+	if sf.Exists(item) {
+		return false, nil
+	}
+	last := sf.filters[len(sf.filters)-1]
+	if last.count(sf.buf) >= last.capacity(sf.buf) {
+		if sf.nonScaling() {
+			return false, ErrFull
+		}
+		capacity := last.capacity(sf.buf) * uint64(sf.expansion())
+		if capacity/uint64(sf.expansion()) != last.capacity(sf.buf) {
+			return false, ErrTooLarge
+		}
+		err := sf.appendFilter(capacity, last.errorRate(sf.buf)*tighteningRatio)
+		if err != nil {
+			return false, err
+		}
+		last = sf.filters[len(sf.filters)-1]
+	}
+	last.add(sf.buf, item)
+	return true, nil
+}
+
+// Count returns number of items added
+func (sf *ScalableFilter) Count() uint64 {
+	var count uint64
+	for _, f := range sf.filters {
+		count += f.count(sf.buf)
+	}
+	return count
+}
+
+// Capacity returns number of items the filter can hold before scaling
+func (sf *ScalableFilter) Capacity() uint64 {
+	var capacity uint64
+	for _, f := range sf.filters {
+		capacity += f.capacity(sf.buf)
+	}
+	return capacity
+}
+
+// FilterNum returns number of sub-filters
+func (sf *ScalableFilter) FilterNum() int {
+	return len(sf.filters)
+}
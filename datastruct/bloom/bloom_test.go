@@ -0,0 +1,100 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestScalableFilter(t *testing.T) {
+	sf, err := Make(0.01, 100, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := 1000
+	for i := 0; i < size; i++ {
+		_, err := sf.Add([]byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sf.FilterNum() < 2 {
+		t.Error("filter should be scaled")
+	}
+	for i := 0; i < size; i++ {
+		if !sf.Exists([]byte(strconv.Itoa(i))) {
+			t.Errorf("false negative: %d", i)
+		}
+	}
+	falsePositive := 0
+	for i := size; i < size*2; i++ {
+		if sf.Exists([]byte(strconv.Itoa(i))) {
+			falsePositive++
+		}
+	}
+	if falsePositive > size/20 {
+		t.Errorf("too many false positives: %d", falsePositive)
+	}
+
+	restored, err := FromBytes(sf.ToBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Count() != sf.Count() || restored.FilterNum() != sf.FilterNum() {
+		t.Error("restored filter mismatch")
+	}
+	if !restored.Exists([]byte("0")) {
+		t.Error("restored filter lost item")
+	}
+}
+
+func TestNonScaling(t *testing.T) {
+	sf, _ := Make(0.01, 10, 2, true)
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		_, err = sf.Add([]byte(strconv.Itoa(i)))
+	}
+	if err != ErrFull {
+		t.Error("expect filter full")
+	}
+	if _, err := FromBytes([]byte("hello")); err != ErrNotBloom {
+		t.Error("expect ErrNotBloom")
+	}
+}
+
+func TestCorruptedPayload(t *testing.T) {
+	sf, _ := Make(0.01, 100, 2, false)
+	payload := sf.ToBytes()
+	corrupt := func(modify func(buf []byte) []byte) {
+		buf := make([]byte, len(payload))
+		copy(buf, payload)
+		if _, err := FromBytes(modify(buf)); err != ErrNotBloom {
+			t.Error("expect ErrNotBloom")
+		}
+	}
+	// zero bit size
+	corrupt(func(buf []byte) []byte {
+		binary.BigEndian.PutUint64(buf[headerSize+28:], 0)
+		return buf
+	})
+	// bit size overflows byte size
+	corrupt(func(buf []byte) []byte {
+		binary.BigEndian.PutUint64(buf[headerSize+28:], math.MaxUint64)
+		return buf
+	})
+	// no hash functions
+	corrupt(func(buf []byte) []byte {
+		binary.BigEndian.PutUint32(buf[headerSize+24:], 0)
+		return buf
+	})
+	// no sub-filters
+	corrupt(func(buf []byte) []byte {
+		binary.BigEndian.PutUint32(buf[9:], 0)
+		return buf
+	})
+	// trailing bytes
+	corrupt(func(buf []byte) []byte {
+		return append(buf, 0)
+	})
+}
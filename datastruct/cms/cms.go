@@ -0,0 +1,195 @@
+// Package cms implements count-min sketch which is stored as a plain string payload
+package cms
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+)
+
+/*
+ * payload layout (big endian):
+ *   magic(4) | width(4) | depth(4) | count(8) | counters(width * depth * 4)
+ * counters are stored row by row, each row uses an independent hash function
+ */
+
+const (
+	headerSize  = 20
+	counterSize = 4
+	maxCounters = 1 << 28
+)
+
+var magic = []byte("GCMS")
+
+var (
+	// ErrNotSketch means payload is not a serialized count-min sketch
+	ErrNotSketch = errors.New("not a count-min sketch")
+	// ErrOverflow means a counter would exceed uint32
+	ErrOverflow = errors.New("CMS: INCRBY overflow")
+	// ErrTooLarge means width * depth is too large
+	ErrTooLarge = errors.New("CMS: width * depth is too large")
+	// ErrDimMismatch means sketches to merge have different dimensions
+	ErrDimMismatch = errors.New("CMS: width/depth is not equal")
+)
+
+// Sketch is a count-min sketch sharing memory with its payload
+type Sketch struct {
+	buf []byte
+}
+
+// Make creates a sketch with the given dimensions
+func Make(width uint32, depth uint32) (*Sketch, error) {
+	if uint64(width)*uint64(depth) > maxCounters {
+		return nil, ErrTooLarge
+	}
+	s := &Sketch{
+		buf: make([]byte, headerSize+int(width)*int(depth)*counterSize),
+	}
+	copy(s.buf, magic)
+	binary.BigEndian.PutUint32(s.buf[4:], width)
+	binary.BigEndian.PutUint32(s.buf[8:], depth)
+	return s, nil
+}
+
+// MakeByProb creates a sketch whose estimation overshoots at most error * total count with the given probability
+func MakeByProb(errorRate float64, probability float64) (*Sketch, error) {
+	width := math.Ceil(2 / errorRate)
+	depth := math.Ceil(math.Log10(probability) / math.Log10(0.5))
+	if width*depth > maxCounters {
+		return nil, ErrTooLarge
+	}
+	return Make(uint32(width), uint32(depth))
+}
+
+// IsSketch checks whether the given payload is a serialized count-min sketch
+func IsSketch(payload []byte) bool {
+	return len(payload) >= headerSize && string(payload[:4]) == string(magic)
+}
+
+// FromBytes restores a sketch from payload, the returned sketch shares memory with payload
+func FromBytes(payload []byte) (*Sketch, error) {
+	if !IsSketch(payload) {
+		return nil, ErrNotSketch
+	}
+	s := &Sketch{buf: payload}
+	width, depth := uint64(s.Width()), uint64(s.Depth())
+	if width == 0 || depth == 0 || width*depth > maxCounters {
+		return nil, ErrNotSketch
+	}
+	if len(payload) != headerSize+int(width*depth)*counterSize {
+		return nil, ErrNotSketch
+	}
+	return s, nil
+}
+
+// ToBytes returns serialized sketch
+func (s *Sketch) ToBytes() []byte {
+	return s.buf
+}
+
+// Width returns number of counters per row
+func (s *Sketch) Width() uint32 {
+	return binary.BigEndian.Uint32(s.buf[4:])
+}
+
+// Depth returns number of rows
+func (s *Sketch) Depth() uint32 {
+	return binary.BigEndian.Uint32(s.buf[8:])
+}
+
+// Count returns sum of all increments
+func (s *Sketch) Count() uint64 {
+	return binary.BigEndian.Uint64(s.buf[12:])
+}
+
+func (s *Sketch) counterOffset(row uint32, col uint32) int {
+	return headerSize + (int(row)*int(s.Width())+int(col))*counterSize
+}
+
+func (s *Sketch) counter(row uint32, col uint32) uint32 {
+	return binary.BigEndian.Uint32(s.buf[s.counterOffset(row, col):])
+}
+
+func (s *Sketch) setCounter(row uint32, col uint32, val uint32) {
+	binary.BigEndian.PutUint32(s.buf[s.counterOffset(row, col):], val)
+}
+
+// columns returns the counter index of item in each row, using double hashing
+func (s *Sketch) columns(item []byte) []uint32 {
+	h := fnv.New64a()
+	_, _ = h.Write(item)
+	h1 := h.Sum64()
+	h2 := h1>>32 | h1<<32 | 1
+	width := uint64(s.Width())
+	result := make([]uint32, s.Depth())
+	for i := range result {
+		result[i] = uint32((h1 + uint64(i)*h2) % width)
+	}
+	return result
+}
+
+// IncrBy increases the counters of item and returns its estimated count
+func (s *Sketch) IncrBy(item []byte, increment uint32) (uint32, error) {
+	cols := s.columns(item)
+	for row, col := range cols {
+		if uint64(s.counter(uint32(row), col))+uint64(increment) > math.MaxUint32 {
+			return 0, ErrOverflow
+		}
+	}
+	min := uint32(math.MaxUint32)
+	for row, col := range cols {
+		val := s.counter(uint32(row), col) + increment
+		s.setCounter(uint32(row), col, val)
+		if val < min {
+			min = val
+		}
+	}
+	binary.BigEndian.PutUint64(s.buf[12:], s.Count()+uint64(increment))
+	return min, nil
+}
+
+// Query returns estimated count of item
+func (s *Sketch) Query(item []byte) uint32 {
+	min := uint32(math.MaxUint32)
+	for row, col := range s.columns(item) {
+		if val := s.counter(uint32(row), col); val < min {
+			min = val
+		}
+	}
+	return min
+}
+
+// Merge overwrites s with the weighted sum of sources, s itself may be one of sources
+func (s *Sketch) Merge(sources []*Sketch, weights []int64) error {
+	for _, src := range sources {
+		if src.Width() != s.Width() || src.Depth() != s.Depth() {
+			return ErrDimMismatch
+		}
+	}
+	width, depth := s.Width(), s.Depth()
+	counters := make([]uint32, int(width)*int(depth))
+	var count int64
+	for row := uint32(0); row < depth; row++ {
+		for col := uint32(0); col < width; col++ {
+			var sum int64
+			for i, src := range sources {
+				sum += int64(src.counter(row, col)) * weights[i]
+			}
+			if sum < 0 || sum > math.MaxUint32 {
+				return ErrOverflow
+			}
+			counters[int(row)*int(width)+int(col)] = uint32(sum)
+		}
+	}
+	for i, src := range sources {
+		count += int64(src.Count()) * weights[i]
+	}
+	for row := uint32(0); row < depth; row++ {
+		for col := uint32(0); col < width; col++ {
+			s.setCounter(row, col, counters[int(row)*int(width)+int(col)])
+		}
+	}
+	binary.BigEndian.PutUint64(s.buf[12:], uint64(count))
+	return nil
+}
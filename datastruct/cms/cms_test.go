@@ -0,0 +1,70 @@
+package cms
+
+import (
+	"encoding/binary"
+	"strconv"
+	"testing"
+)
+
+func TestSketch(t *testing.T) {
+	s, err := Make(1000, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		for j := 0; j <= i; j++ {
+			_, _ = s.IncrBy([]byte(strconv.Itoa(i)), 1)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		// count-min sketch never underestimates
+		if count := s.Query([]byte(strconv.Itoa(i))); count < uint32(i+1) {
+			t.Errorf("underestimated %d: %d", i, count)
+		}
+	}
+	if s.Count() != 5050 {
+		t.Errorf("expect count 5050, actual %d", s.Count())
+	}
+
+	restored, err := FromBytes(s.ToBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Width() != 1000 || restored.Depth() != 5 || restored.Query([]byte("99")) < 100 {
+		t.Error("restored sketch mismatch")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a, _ := Make(100, 3)
+	b, _ := Make(100, 3)
+	_, _ = a.IncrBy([]byte("x"), 3)
+	_, _ = b.IncrBy([]byte("x"), 5)
+	dest, _ := Make(100, 3)
+	err := dest.Merge([]*Sketch{a, b}, []int64{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := dest.Query([]byte("x")); count != 13 {
+		t.Errorf("expect 13, actual %d", count)
+	}
+	c, _ := Make(10, 3)
+	if err := dest.Merge([]*Sketch{c}, []int64{1}); err != ErrDimMismatch {
+		t.Error("expect ErrDimMismatch")
+	}
+	if _, err := a.IncrBy([]byte("x"), 1<<32-1); err != ErrOverflow {
+		t.Error("expect ErrOverflow")
+	}
+}
+
+func TestCorruptedPayload(t *testing.T) {
+	for _, dim := range [][2]uint32{{0, 4}, {4, 0}, {4, 4}} {
+		payload := make([]byte, headerSize)
+		copy(payload, magic)
+		binary.BigEndian.PutUint32(payload[4:], dim[0])
+		binary.BigEndian.PutUint32(payload[8:], dim[1])
+		if _, err := FromBytes(payload); err != ErrNotSketch {
+			t.Errorf("expect ErrNotSketch for %dx%d", dim[0], dim[1])
+		}
+	}
+}
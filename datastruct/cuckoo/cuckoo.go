@@ -0,0 +1,194 @@
+// Package cuckoo implements a cuckoo filter which supports deletion and is stored as a plain string payload
+package cuckoo
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+)
+
+/*
+ * payload layout (big endian):
+ *   magic(4) | bucketNum(4) | count(8) | buckets(bucketNum * bucketSize)
+ * every slot holds an 8-bit fingerprint, 0 means empty slot
+ */
+
+const (
+	headerSize = 16
+	bucketSize = 4
+	maxKicks   = 500
+	// DefaultCapacity is used when CF.ADD creates a filter implicitly
+	DefaultCapacity = 1024
+	maxBucketNum    = 1 << 30
+)
+
+var magic = []byte("GCF1")
+
+var (
+	// ErrNotCuckoo means payload is not a serialized cuckoo filter
+	ErrNotCuckoo = errors.New("not a cuckoo filter")
+	// ErrFull means no slot could be found for the new item
+	ErrFull = errors.New("ERR Filter is full")
+	// ErrTooLarge means the requested capacity needs too much memory
+	ErrTooLarge = errors.New("ERR capacity is too large")
+)
+
+// Filter is a cuckoo filter sharing memory with its payload
+type Filter struct {
+	buf []byte
+}
+
+// Make creates a cuckoo filter which can hold at least capacity items
+func Make(capacity uint64) (*Filter, error) {
+	bucketNum := uint64(1)
+	for bucketNum*bucketSize < capacity {
+		bucketNum <<= 1
+	}
+	if bucketNum > maxBucketNum {
+		return nil, ErrTooLarge
+	}
+	f := &Filter{
+		buf: make([]byte, headerSize+bucketNum*bucketSize),
+	}
+	copy(f.buf, magic)
+	binary.BigEndian.PutUint32(f.buf[4:], uint32(bucketNum))
+	return f, nil
+}
+
+// IsCuckoo checks whether the given payload is a serialized cuckoo filter
+func IsCuckoo(payload []byte) bool {
+	return len(payload) >= headerSize && string(payload[:4]) == string(magic)
+}
+
+// FromBytes restores a filter from payload, the returned filter shares memory with payload
+func FromBytes(payload []byte) (*Filter, error) {
+	if !IsCuckoo(payload) {
+		return nil, ErrNotCuckoo
+	}
+	f := &Filter{buf: payload}
+	// bucket index is masked by bucketNum-1, so it must be a non-zero power of two
+	n := f.bucketNum()
+	if n == 0 || n > maxBucketNum || n&(n-1) != 0 {
+		return nil, ErrNotCuckoo
+	}
+	if len(payload) != headerSize+int(n)*bucketSize {
+		return nil, ErrNotCuckoo
+	}
+	return f, nil
+}
+
+// ToBytes returns serialized filter
+func (f *Filter) ToBytes() []byte {
+	return f.buf
+}
+
+func (f *Filter) bucketNum() uint32 {
+	return binary.BigEndian.Uint32(f.buf[4:])
+}
+
+// Count returns number of items in filter
+func (f *Filter) Count() uint64 {
+	return binary.BigEndian.Uint64(f.buf[8:])
+}
+
+func (f *Filter) setCount(count uint64) {
+	binary.BigEndian.PutUint64(f.buf[8:], count)
+}
+
+func (f *Filter) bucket(i uint32) []byte {
+	start := headerSize + int(i)*bucketSize
+	return f.buf[start : start+bucketSize]
+}
+
+// indexes returns fingerprint and the two candidate buckets of item
+func (f *Filter) indexes(item []byte) (byte, uint32, uint32) {
+	h := fnv.New64a()
+	_, _ = h.Write(item)
+	sum := h.Sum64()
+	fp := byte(sum >> 56)
+	if fp == 0 {
+		fp = 1
+	}
+	i1 := uint32(sum) & (f.bucketNum() - 1)
+	return fp, i1, f.altIndex(i1, fp)
+}
+
+// altIndex is symmetric: altIndex(altIndex(i, fp), fp) == i
+func (f *Filter) altIndex(i uint32, fp byte) uint32 {
+	return (i ^ (uint32(fp) * 0x5bd1e995)) & (f.bucketNum() - 1)
+}
+
+func (f *Filter) insertInto(i uint32, fp byte) bool {
+	bucket := f.bucket(i)
+	for j := range bucket {
+		if bucket[j] == 0 {
+			bucket[j] = fp
+			return true
+		}
+	}
+	return false
+}
+
+type kick struct {
+	bucket uint32
+	slot   int
+	fp     byte
+}
+
+// Add puts item into filter, an item can be added multiple times
+func (f *Filter) Add(item []byte) error {
+	fp, i1, i2 := f.indexes(item)
+	if f.insertInto(i1, fp) || f.insertInto(i2, fp) {
+		f.setCount(f.Count() + 1)
+		return nil
+	}
+	// relocate existing fingerprints, slots are chosen deterministically so that aof replay gets the same layout
+	history := make([]kick, 0, maxKicks)
+	i := i2
+	for n := 0; n < maxKicks; n++ {
+		slot := (int(fp) + n) % bucketSize
+		bucket := f.bucket(i)
+		history = append(history, kick{bucket: i, slot: slot, fp: bucket[slot]})
+		fp, bucket[slot] = bucket[slot], fp
+		i = f.altIndex(i, fp)
+		if f.insertInto(i, fp) {
+			f.setCount(f.Count() + 1)
+			return nil
+		}
+	}
+	// undo relocations to keep the filter unchanged
+	for n := len(history) - 1; n >= 0; n-- {
+		k := history[n]
+		f.bucket(k.bucket)[k.slot] = k.fp
+	}
+	return ErrFull
+}
+
+// Exists returns whether item may exist in filter
+func (f *Filter) Exists(item []byte) bool {
+	fp, i1, i2 := f.indexes(item)
+	for _, i := range []uint32{i1, i2} {
+		for _, v := range f.bucket(i) {
+			if v == fp {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Remove deletes one occurrence of item, returns false if not found
+func (f *Filter) Remove(item []byte) bool {
+	fp, i1, i2 := f.indexes(item)
+	for _, i := range []uint32{i1, i2} {
+		bucket := f.bucket(i)
+		for j, v := range bucket {
+			if v == fp {
+				bucket[j] = 0
+				f.setCount(f.Count() - 1)
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package cuckoo
+
+import (
+	"encoding/binary"
+	"strconv"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	f, err := Make(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := 900
+	for i := 0; i < size; i++ {
+		if err := f.Add([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if f.Count() != uint64(size) {
+		t.Errorf("expect count %d, actual %d", size, f.Count())
+	}
+	for i := 0; i < size; i++ {
+		if !f.Exists([]byte(strconv.Itoa(i))) {
+			t.Errorf("false negative: %d", i)
+		}
+	}
+	for i := 0; i < size; i += 2 {
+		if !f.Remove([]byte(strconv.Itoa(i))) {
+			t.Errorf("remove failed: %d", i)
+		}
+	}
+	for i := 1; i < size; i += 2 {
+		if !f.Exists([]byte(strconv.Itoa(i))) {
+			t.Errorf("false negative after remove: %d", i)
+		}
+	}
+
+	restored, err := FromBytes(f.ToBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Count() != f.Count() || !restored.Exists([]byte("1")) {
+		t.Error("restored filter mismatch")
+	}
+}
+
+func TestFull(t *testing.T) {
+	f, _ := Make(8)
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		err = f.Add([]byte(strconv.Itoa(i)))
+	}
+	if err != ErrFull {
+		t.Fatal("expect filter full")
+	}
+	// a failed insertion must leave existing items in place
+	for i := 0; i < int(f.Count()); i++ {
+		if !f.Exists([]byte(strconv.Itoa(i))) {
+			t.Errorf("false negative: %d", i)
+		}
+	}
+}
+
+func TestCorruptedPayload(t *testing.T) {
+	for _, bucketNum := range []uint32{0, 3} {
+		payload := make([]byte, headerSize+int(bucketNum)*bucketSize)
+		copy(payload, magic)
+		binary.BigEndian.PutUint32(payload[4:], bucketNum)
+		if _, err := FromBytes(payload); err != ErrNotCuckoo {
+			t.Errorf("expect ErrNotCuckoo for %d buckets", bucketNum)
+		}
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"sort"
 	"sync"
 	"sync/atomic"
-	"time"
 )
 
 // ConcurrentDict is thread safe map using sharding lock
@@ -309,18 +308,83 @@ func (dict *ConcurrentDict) Keys() []string {
 	return keys
 }
 
-// RandomKey returns a key randomly
-func (shard *shard) RandomKey() string {
-	if shard == nil {
-		panic("shard is nil")
-	}
+// randomKey returns a random key of shard, the chance of each key is roughly equal
+func (shard *shard) randomKey() (string, int) {
 	shard.mutex.RLock()
 	defer shard.mutex.RUnlock()
-
+	n := len(shard.m)
+	if n == 0 {
+		return "", 0
+	}
+	// the iteration order of go map is not uniformly random, so skip a random number of keys
+	offset := rand.Intn(n)
 	for key := range shard.m {
-		return key
+		if offset == 0 {
+			return key, n
+		}
+		offset--
 	}
-	return ""
+	return "", n
+}
+
+// sampleKeys returns keys at the given ranks (in ascending order) of a traversal, used when the dict is sparse
+func (dict *ConcurrentDict) sampleKeys(ranks []int) []string {
+	result := make([]string, 0, len(ranks))
+	i := 0
+	dict.ForEach(func(key string, val interface{}) bool {
+		for len(result) < len(ranks) && ranks[len(result)] == i {
+			result = append(result, key)
+		}
+		i++
+		return len(result) < len(ranks)
+	})
+	return result
+}
+
+// randomKey picks a key uniformly across shards.
+// It picks a random shard then accepts it with probability proportional to its size (rejection sampling),
+// so that keys in small shards would not be chosen more often than keys in large shards.
+// It returns false if dict has been emptied by others
+func (dict *ConcurrentDict) randomKey(size int) (string, bool) {
+	shardCount := len(dict.table)
+	// shards with more keys than bound are always accepted, it is very rare since keys are spread by hash
+	bound := 2*size/shardCount + 1
+	for tries := 1; ; tries++ {
+		key, n := dict.table[rand.Intn(shardCount)].randomKey()
+		if n > 0 && (n >= bound || rand.Intn(bound) < n) {
+			return key, true
+		}
+		if tries%shardCount == 0 && dict.Len() == 0 {
+			return "", false
+		}
+	}
+}
+
+// isSparse returns whether most shards are empty, sampling shards randomly is inefficient for a sparse dict
+func (dict *ConcurrentDict) isSparse(size int) bool {
+	return size*8 < len(dict.table)
+}
+
+// distinctRanks chooses limit distinct ranks in [0, size) by partial Fisher-Yates shuffle,
+// displaced positions are kept in a map so that it takes O(limit) instead of O(size) memory
+func distinctRanks(size int, limit int) []int {
+	displaced := make(map[int]int, limit)
+	ranks := make([]int, limit)
+	for i := 0; i < limit; i++ {
+		j := i + rand.Intn(size-i)
+		vi, ok := displaced[i]
+		if !ok {
+			vi = i
+		}
+		vj, ok := displaced[j]
+		if !ok {
+			vj = j
+		}
+		ranks[i] = vj
+		displaced[j] = vi
+	}
+	sort.Ints(ranks)
+	return ranks
 }
 
 // RandomKeys randomly returns keys of the given number, may contain duplicated key
@@ -329,20 +393,26 @@ func (dict *ConcurrentDict) RandomKeys(limit int) []string {
 	if limit >= size {
 		return dict.Keys()
 	}
-	shardCount := len(dict.table)
-
-	result := make([]string, limit)
-	nR := rand.New(rand.NewSource(time.Now().UnixNano()))
-	for i := 0; i < limit; {
-		s := dict.getShard(uint32(nR.Intn(shardCount)))
-		if s == nil {
-			continue
+	result := make([]string, 0, limit)
+	if dict.isSparse(size) {
+		// keys may be removed during traversal, so sample again until enough keys are collected
+		for len(result) < limit && size > 0 {
+			ranks := make([]int, limit-len(result))
+			for i := range ranks {
+				ranks[i] = rand.Intn(size)
+			}
+			sort.Ints(ranks)
+			result = append(result, dict.sampleKeys(ranks)...)
+			size = dict.Len()
 		}
-		key := s.RandomKey()
-		if key != "" {
-			result[i] = key
-			i++
+		return result
+	}
+	for len(result) < limit {
+		key, ok := dict.randomKey(size)
+		if !ok {
+			break
 		}
+		result = append(result, key)
 	}
 	return result
 }
@@ -353,28 +423,43 @@ func (dict *ConcurrentDict) RandomDistinctKeys(limit int) []string {
 	if limit >= size {
 		return dict.Keys()
 	}
-
-	shardCount := len(dict.table)
-	result := make(map[string]struct{})
-	nR := rand.New(rand.NewSource(time.Now().UnixNano()))
-	for len(result) < limit {
-		shardIndex := uint32(nR.Intn(shardCount))
-		s := dict.getShard(shardIndex)
-		if s == nil {
-			continue
-		}
-		key := s.RandomKey()
-		if key != "" {
-			if _, exists := result[key]; !exists {
-				result[key] = struct{}{}
+	if dict.isSparse(size) || limit*2 > size {
+		// keys may be removed during traversal, so sample again until enough keys are collected
+		result := make([]string, 0, limit)
+		seen := make(map[string]struct{}, limit)
+		for {
+			keys := dict.sampleKeys(distinctRanks(size, limit))
+			// keys are in traversal order, shuffle them so that keys of a resampling are kept fairly
+			rand.Shuffle(len(keys), func(i, j int) {
+				keys[i], keys[j] = keys[j], keys[i]
+			})
+			for _, key := range keys {
+				if _, ok := seen[key]; ok || len(result) == limit {
+					continue
+				}
+				seen[key] = struct{}{}
+				result = append(result, key)
+			}
+			if len(result) == limit {
+				return result
+			}
+			size = dict.Len()
+			if limit >= size {
+				return dict.Keys()
 			}
 		}
 	}
-	arr := make([]string, limit)
-	i := 0
+	result := make(map[string]struct{}, limit)
+	for len(result) < limit {
+		key, ok := dict.randomKey(size)
+		if !ok {
+			break
+		}
+		result[key] = struct{}{}
+	}
+	arr := make([]string, 0, limit)
 	for k := range result {
-		arr[i] = k
-		i++
+		arr = append(arr, k)
 	}
 	return arr
 }
@@ -451,38 +536,48 @@ func stringsToBytes(strSlice []string) [][]byte {
 	return byteSlice
 }
 
+// DictScan iterates shards in reverse binary order of shard index like redis does, the returned cursor is the
+// next shard index to visit. A scan starting from cursor 0 returns every key which exists during the whole scan
+// at least once, even if the shard table has been resized between calls.
+// count is the number of keys to visit, keys of a shard are always visited together
 func (dict *ConcurrentDict) DictScan(cursor int, count int, pattern string) ([][]byte, int) {
-	size := dict.Len()
 	result := make([][]byte, 0)
-
-	if pattern == "*" && count >= size {
+	if cursor == 0 && pattern == "*" && count >= dict.Len() {
 		return stringsToBytes(dict.Keys()), 0
 	}
-
 	matchKey, err := wildcard.CompilePattern(pattern)
 	if err != nil {
 		return result, -1
 	}
 
-	shardCount := len(dict.table)
-	shardIndex := cursor
-
-	for shardIndex < shardCount {
-		shard := dict.table[shardIndex]
+	mask := uint64(len(dict.table) - 1)
+	v := uint64(cursor)
+	visited := 0
+	for {
+		shard := dict.table[v&mask]
 		shard.mutex.RLock()
-		if len(result)+len(shard.m) > count && shardIndex > cursor {
-			shard.mutex.RUnlock()
-			return result, shardIndex
-		}
-
 		for key := range shard.m {
 			if pattern == "*" || matchKey.IsMatch(key) {
 				result = append(result, []byte(key))
 			}
 		}
+		visited += len(shard.m)
 		shard.mutex.RUnlock()
-		shardIndex++
+
+		v = nextScanCursor(v, mask)
+		if v == 0 || visited >= count {
+			break
+		}
 	}
+	return result, int(v)
+}
+
+// KeyScan is the same as DictScan since values of ConcurrentDict are not always []byte
+func (dict *ConcurrentDict) KeyScan(cursor int, count int, pattern string) ([][]byte, int) {
+	return dict.DictScan(cursor, count, pattern)
+}
 
-	return result, 0
+// Encoding returns name of the internal representation
+func (dict *ConcurrentDict) Encoding() string {
+	return "hashtable"
 }
@@ -572,3 +572,115 @@ func TestDictScan(t *testing.T) {
 		t.Errorf("returnKeys should be empty")
 	}
 }
+
+func TestDictScanWithModification(t *testing.T) {
+	d := MakeConcurrent(16)
+	count := 1000
+	for i := 0; i < count; i++ {
+		d.Put("stable"+strconv.Itoa(i), i)
+	}
+	seen := make(map[string]struct{})
+	cursor := 0
+	round := 0
+	for {
+		var keys [][]byte
+		keys, cursor = d.DictScan(cursor, 10, "*")
+		for _, key := range keys {
+			seen[string(key)] = struct{}{}
+		}
+		// keys inserted or removed during the scan may or may not be returned
+		d.Put("volatile"+strconv.Itoa(round), round)
+		d.Remove("volatile" + strconv.Itoa(round-1))
+		round++
+		if cursor == 0 {
+			break
+		}
+	}
+	for i := 0; i < count; i++ {
+		if _, ok := seen["stable"+strconv.Itoa(i)]; !ok {
+			t.Errorf("key stable%d is not returned", i)
+		}
+	}
+	if round < 2 {
+		t.Errorf("scan should take multiple rounds")
+	}
+}
+
+func TestConcurrentRandomKeyUniform(t *testing.T) {
+	d := MakeConcurrent(16)
+	for i := 0; i < 1600; i++ {
+		d.Put("k"+strconv.Itoa(i), i)
+	}
+	// leave only one key in shard 0, so that it would be chosen too often if shards were sampled evenly
+	var lonely string
+	for _, key := range d.Keys() {
+		if d.spread(key) != 0 {
+			continue
+		}
+		if lonely == "" {
+			lonely = key
+		} else {
+			d.Remove(key)
+		}
+	}
+	samples := 20000
+	hits := 0
+	for i := 0; i < samples/10; i++ {
+		for _, key := range d.RandomKeys(10) {
+			if key == lonely {
+				hits++
+			}
+		}
+	}
+	// expected hits is about samples / d.Len() which is less than 20
+	if hits > 200 {
+		t.Errorf("key in small shard is chosen %d times in %d samples", hits, samples)
+	}
+
+	// sparse dict
+	d = MakeConcurrent(1024)
+	for i := 0; i < 10; i++ {
+		d.Put("k"+strconv.Itoa(i), i)
+	}
+	result := d.RandomDistinctKeys(5)
+	if len(result) != 5 || len(utils.RemoveDuplicates(stringsToBytes(result))) != 5 {
+		t.Errorf("expect 5 distinct keys, actually %v", result)
+	}
+	result = d.RandomKeys(5)
+	if len(result) != 5 {
+		t.Errorf("expect 5 keys, actually %v", result)
+	}
+}
+
+func TestDistinctRanks(t *testing.T) {
+	for _, size := range []int{1, 10, 1000} {
+		ranks := distinctRanks(size, size/2+1)
+		for i, rank := range ranks {
+			if rank < 0 || rank >= size || (i > 0 && rank <= ranks[i-1]) {
+				t.Errorf("ranks should be distinct, sorted and in range: %v", ranks)
+				break
+			}
+		}
+	}
+}
+
+func TestConcurrentRandomDistinctKeysWhileRemoving(t *testing.T) {
+	d := MakeConcurrent(1024)
+	for i := 0; i < 100; i++ {
+		d.Put("k"+strconv.Itoa(i), i)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			d.Remove("k" + strconv.Itoa(i))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		result := d.RandomDistinctKeys(10)
+		if len(result) != 10 || len(utils.RemoveDuplicates(stringsToBytes(result))) != 10 {
+			t.Fatalf("expect 10 distinct keys, actually %v", result)
+		}
+	}
+	<-done
+}
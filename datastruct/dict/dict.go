@@ -1,5 +1,7 @@
 package dict
 
+import "math/bits"
+
 // Consumer is used to traversal dict, if it returns false the traversal will be break
 type Consumer func(key string, val interface{}) bool
 
@@ -17,4 +19,17 @@ type Dict interface {
 	RandomDistinctKeys(limit int) []string
 	Clear()
 	DictScan(cursor int, count int, pattern string) ([][]byte, int)
+	// KeyScan is like DictScan but returns keys only
+	KeyScan(cursor int, count int, pattern string) ([][]byte, int)
+	// Encoding returns name of the internal representation, reported by OBJECT ENCODING
+	Encoding() string
+}
+
+// nextScanCursor increases the reversed bits of cursor under mask,
+// so that buckets split from visited buckets are skipped after the bucket table grows
+func nextScanCursor(v uint64, mask uint64) uint64 {
+	v |= ^mask
+	v = bits.Reverse64(v)
+	v++
+	return bits.Reverse64(v)
 }
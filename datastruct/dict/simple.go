@@ -1,60 +1,97 @@
 package dict
 
 import (
+	"math/rand"
+
 	"github.com/hdt3213/godis/lib/wildcard"
 )
 
-// SimpleDict wraps a map, it is not thread safe
+// SimpleDict is a hash table, it is not thread safe.
+// Keys are spread into buckets by hash code and the bucket table doubles when buckets are crowded,
+// so that a scan could iterate buckets with a cursor instead of traversing the whole dict
 type SimpleDict struct {
-	m map[string]interface{}
+	table []map[string]interface{}
+	count int
 }
 
+// simpleBucketLoad is the average bucket size which triggers growing of the bucket table
+const simpleBucketLoad = 32
+
 // MakeSimple makes a new map
 func MakeSimple() *SimpleDict {
 	return &SimpleDict{
-		m: make(map[string]interface{}),
+		table: []map[string]interface{}{make(map[string]interface{})},
+	}
+}
+
+func (dict *SimpleDict) bucket(key string) map[string]interface{} {
+	if len(dict.table) == 1 {
+		return dict.table[0]
+	}
+	return dict.table[fnv32(key)&uint32(len(dict.table)-1)]
+}
+
+// grow doubles bucket table if it is too crowded, every bucket splits into bucket i and i + len(table)
+func (dict *SimpleDict) grow() {
+	if dict.count <= len(dict.table)*simpleBucketLoad {
+		return
+	}
+	table := make([]map[string]interface{}, len(dict.table)*2)
+	for i := range table {
+		table[i] = make(map[string]interface{})
+	}
+	mask := uint32(len(table) - 1)
+	for _, b := range dict.table {
+		for k, v := range b {
+			table[fnv32(k)&mask][k] = v
+		}
 	}
+	dict.table = table
 }
 
 // Get returns the binding value and whether the key is exist
 func (dict *SimpleDict) Get(key string) (val interface{}, exists bool) {
-	val, ok := dict.m[key]
+	val, ok := dict.bucket(key)[key]
 	return val, ok
 }
 
 // Len returns the number of dict
 func (dict *SimpleDict) Len() int {
-	if dict.m == nil {
-		panic("m is nil")
-	}
-	return len(dict.m)
+	return dict.count
 }
 
 // Put puts key value into dict and returns the number of new inserted key-value
 func (dict *SimpleDict) Put(key string, val interface{}) (result int) {
-	_, existed := dict.m[key]
-	dict.m[key] = val
+	b := dict.bucket(key)
+	_, existed := b[key]
+	b[key] = val
 	if existed {
 		return 0
 	}
+	dict.count++
+	dict.grow()
 	return 1
 }
 
 // PutIfAbsent puts value if the key is not exists and returns the number of updated key-value
 func (dict *SimpleDict) PutIfAbsent(key string, val interface{}) (result int) {
-	_, existed := dict.m[key]
+	b := dict.bucket(key)
+	_, existed := b[key]
 	if existed {
 		return 0
 	}
-	dict.m[key] = val
+	b[key] = val
+	dict.count++
+	dict.grow()
 	return 1
 }
 
 // PutIfExists puts value if the key is existed and returns the number of inserted key-value
 func (dict *SimpleDict) PutIfExists(key string, val interface{}) (result int) {
-	_, existed := dict.m[key]
+	b := dict.bucket(key)
+	_, existed := b[key]
 	if existed {
-		dict.m[key] = val
+		b[key] = val
 		return 1
 	}
 	return 0
@@ -62,9 +99,11 @@ func (dict *SimpleDict) PutIfExists(key string, val interface{}) (result int) {
 
 // Remove removes the key and return the number of deleted key-value
 func (dict *SimpleDict) Remove(key string) (val interface{}, result int) {
-	val, existed := dict.m[key]
-	delete(dict.m, key)
+	b := dict.bucket(key)
+	val, existed := b[key]
 	if existed {
+		delete(b, key)
+		dict.count--
 		return val, 1
 	}
 	return nil, 0
@@ -72,50 +111,78 @@ func (dict *SimpleDict) Remove(key string) (val interface{}, result int) {
 
 // Keys returns all keys in dict
 func (dict *SimpleDict) Keys() []string {
-	result := make([]string, len(dict.m))
-	i := 0
-	for k := range dict.m {
-		result[i] = k
-		i++
+	result := make([]string, 0, dict.count)
+	for _, b := range dict.table {
+		for k := range b {
+			result = append(result, k)
+		}
 	}
 	return result
 }
 
 // ForEach traversal the dict
 func (dict *SimpleDict) ForEach(consumer Consumer) {
-	for k, v := range dict.m {
-		if !consumer(k, v) {
-			break
+	for _, b := range dict.table {
+		for k, v := range b {
+			if !consumer(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// randomKey picks a non-empty bucket randomly then returns a random key in it, dict must not be empty
+func (dict *SimpleDict) randomKey() string {
+	for {
+		b := dict.table[rand.Intn(len(dict.table))]
+		if len(b) == 0 {
+			continue
+		}
+		n := rand.Intn(len(b))
+		for k := range b {
+			if n == 0 {
+				return k
+			}
+			n--
 		}
 	}
 }
 
 // RandomKeys randomly returns keys of the given number, may contain duplicated key
 func (dict *SimpleDict) RandomKeys(limit int) []string {
+	if dict.count == 0 {
+		return nil
+	}
 	result := make([]string, limit)
-	for i := 0; i < limit; i++ {
-		for k := range dict.m {
-			result[i] = k
-			break
-		}
+	for i := range result {
+		result[i] = dict.randomKey()
 	}
 	return result
 }
 
 // RandomDistinctKeys randomly returns keys of the given number, won't contain duplicated key
 func (dict *SimpleDict) RandomDistinctKeys(limit int) []string {
-	size := limit
-	if size > len(dict.m) {
-		size = len(dict.m)
-	}
-	result := make([]string, size)
-	i := 0
-	for k := range dict.m {
-		if i == size {
-			break
+	if limit >= dict.count {
+		return dict.Keys()
+	}
+	if limit*3 > dict.count {
+		// picking randomly would meet too many duplicates, shuffle all keys instead
+		keys := dict.Keys()
+		for i := 0; i < limit; i++ {
+			j := i + rand.Intn(len(keys)-i)
+			keys[i], keys[j] = keys[j], keys[i]
 		}
-		result[i] = k
-		i++
+		return keys[:limit]
+	}
+	picked := make(map[string]struct{}, limit)
+	result := make([]string, 0, limit)
+	for len(result) < limit {
+		key := dict.randomKey()
+		if _, ok := picked[key]; ok {
+			continue
+		}
+		picked[key] = struct{}{}
+		result = append(result, key)
 	}
 	return result
 }
@@ -125,21 +192,55 @@ func (dict *SimpleDict) Clear() {
 	*dict = *MakeSimple()
 }
 
-func (dict *SimpleDict) DictScan(cursor int, count int, pattern string) ([][]byte, int) {
-	result := make([][]byte, 0)
+// ScanEach visits buckets in reverse binary order from cursor until at least count keys have been visited,
+// consumer is called for each key matching pattern. It returns the next cursor, 0 means the scan is finished.
+// -1 will be returned if pattern is illegal. Like redis, keys existing during the whole scan will be visited
+// at least once even if the bucket table grows between calls
+func (dict *SimpleDict) ScanEach(cursor int, count int, pattern string, consumer Consumer) int {
 	matchKey, err := wildcard.CompilePattern(pattern)
 	if err != nil {
-		return result, -1
+		return -1
 	}
-	for k := range dict.m {
-		if pattern == "*" || matchKey.IsMatch(k) {
-			raw, exists := dict.Get(k)
-			if !exists {
-				continue
+	mask := uint64(len(dict.table) - 1)
+	v := uint64(cursor)
+	visited := 0
+	for {
+		b := dict.table[v&mask]
+		for k, val := range b {
+			if pattern == "*" || matchKey.IsMatch(k) {
+				consumer(k, val)
 			}
-			result = append(result, []byte(k))
-			result = append(result, raw.([]byte))
+		}
+		visited += len(b)
+		v = nextScanCursor(v, mask)
+		if v == 0 || visited >= count {
+			break
 		}
 	}
-	return result, 0
+	return int(v)
+}
+
+// DictScan returns field and value pairs of a hash, values must be []byte
+func (dict *SimpleDict) DictScan(cursor int, count int, pattern string) ([][]byte, int) {
+	result := make([][]byte, 0)
+	nextCursor := dict.ScanEach(cursor, count, pattern, func(key string, val interface{}) bool {
+		result = append(result, []byte(key), val.([]byte))
+		return true
+	})
+	return result, nextCursor
+}
+
+// KeyScan returns keys only
+func (dict *SimpleDict) KeyScan(cursor int, count int, pattern string) ([][]byte, int) {
+	result := make([][]byte, 0)
+	nextCursor := dict.ScanEach(cursor, count, pattern, func(key string, val interface{}) bool {
+		result = append(result, []byte(key))
+		return true
+	})
+	return result, nextCursor
+}
+
+// Encoding returns name of the internal representation
+func (dict *SimpleDict) Encoding() string {
+	return "hashtable"
 }
@@ -3,6 +3,7 @@ package dict
 import (
 	"github.com/hdt3213/godis/lib/utils"
 	"sort"
+	"strconv"
 	"testing"
 )
 
@@ -80,3 +81,67 @@ func TestSimpleDict_Scan(t *testing.T) {
 		return
 	}
 }
+
+func TestSimpleDict_ScanWhileGrowing(t *testing.T) {
+	d := MakeSimple()
+	for i := 0; i < 100; i++ {
+		d.Put("stable"+strconv.Itoa(i), i)
+	}
+	seen := make(map[string]struct{})
+	cursor := 0
+	round := 0
+	for {
+		var keys [][]byte
+		keys, cursor = d.KeyScan(cursor, 10, "*")
+		for _, key := range keys {
+			seen[string(key)] = struct{}{}
+		}
+		// insert a lot of keys so that the bucket table grows during scan
+		for i := 0; i < 50; i++ {
+			d.Put("new"+strconv.Itoa(round)+":"+strconv.Itoa(i), i)
+		}
+		round++
+		if cursor == 0 {
+			break
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if _, ok := seen["stable"+strconv.Itoa(i)]; !ok {
+			t.Errorf("key stable%d is not returned", i)
+		}
+	}
+	if d.Len() != 100+round*50 {
+		t.Errorf("expect len %d, actually %d", 100+round*50, d.Len())
+	}
+}
+
+func TestSimpleDict_RandomKeys(t *testing.T) {
+	d := MakeSimple()
+	for i := 0; i < 1000; i++ {
+		d.Put(strconv.Itoa(i), i)
+	}
+	for _, limit := range []int{1, 10, 500, 999, 1000, 2000} {
+		keys := d.RandomDistinctKeys(limit)
+		expected := limit
+		if expected > d.Len() {
+			expected = d.Len()
+		}
+		if len(keys) != expected {
+			t.Errorf("expect %d keys, actual %d", expected, len(keys))
+		}
+		distinct := make(map[string]struct{})
+		for _, k := range keys {
+			if _, ok := d.Get(k); !ok {
+				t.Errorf("unexpected key %s", k)
+			}
+			distinct[k] = struct{}{}
+		}
+		if len(distinct) != len(keys) {
+			t.Errorf("duplicated keys in result of RandomDistinctKeys(%d)", limit)
+		}
+		keys = d.RandomKeys(limit)
+		if len(keys) != limit {
+			t.Errorf("expect %d keys, actual %d", limit, len(keys))
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package dict
+
+import "time"
+
+// TTLDict is a Dict whose keys may have expiration time, hashes with field ttl are stored in it.
+// Expired keys are not removed automatically, the owner should call RemoveExpired before writing, and readers
+// should access through Live.
+// Removing a key clears its expiration time, while overwriting keeps it
+type TTLDict struct {
+	Dict
+	expires map[string]time.Time
+}
+
+// MakeTTL wraps the given dict to support expiration of keys
+func MakeTTL(d Dict) *TTLDict {
+	return &TTLDict{
+		Dict:    d,
+		expires: make(map[string]time.Time),
+	}
+}
+
+// Remove removes the key and its expiration time
+func (dict *TTLDict) Remove(key string) (val interface{}, result int) {
+	delete(dict.expires, key)
+	return dict.Dict.Remove(key)
+}
+
+// Clear removes all keys and expiration times
+func (dict *TTLDict) Clear() {
+	dict.Dict.Clear()
+	dict.expires = make(map[string]time.Time)
+}
+
+// Expire sets expiration time of the given key, returns false if the key does not exist
+func (dict *TTLDict) Expire(key string, expireAt time.Time) bool {
+	if _, ok := dict.Dict.Get(key); !ok {
+		return false
+	}
+	dict.expires[key] = expireAt
+	return true
+}
+
+// Persist removes expiration time of the given key, returns whether the key had one
+func (dict *TTLDict) Persist(key string) bool {
+	if _, ok := dict.expires[key]; !ok {
+		return false
+	}
+	delete(dict.expires, key)
+	return true
+}
+
+// ExpireTime returns expiration time of the given key
+func (dict *TTLDict) ExpireTime(key string) (time.Time, bool) {
+	expireAt, ok := dict.expires[key]
+	return expireAt, ok
+}
+
+// ExpireCount returns the number of keys with expiration time
+func (dict *TTLDict) ExpireCount() int {
+	return len(dict.expires)
+}
+
+// ForEachExpire visits keys with expiration time, if the consumer returns false the traversal will be break
+func (dict *TTLDict) ForEachExpire(consumer func(key string, expireAt time.Time) bool) {
+	for key, expireAt := range dict.expires {
+		if !consumer(key, expireAt) {
+			return
+		}
+	}
+}
+
+// NextExpireTime returns the earliest expiration time of keys
+func (dict *TTLDict) NextExpireTime() (time.Time, bool) {
+	var next time.Time
+	found := false
+	for _, expireAt := range dict.expires {
+		if !found || expireAt.Before(next) {
+			next = expireAt
+			found = true
+		}
+	}
+	return next, found
+}
+
+// RemoveExpired removes keys expired at the given time, and returns them
+func (dict *TTLDict) RemoveExpired(now time.Time) []string {
+	var removed []string
+	for key, expireAt := range dict.expires {
+		if now.After(expireAt) {
+			removed = append(removed, key)
+		}
+	}
+	for _, key := range removed {
+		dict.Remove(key)
+	}
+	return removed
+}
+
+// Live returns a read-only view hiding keys expired at the given time, so that readers holding a shared lock
+// need not to remove them. It returns the dict itself if no key has expired
+func (dict *TTLDict) Live(now time.Time) Dict {
+	var expired map[string]struct{}
+	for key, expireAt := range dict.expires {
+		if now.After(expireAt) {
+			if expired == nil {
+				expired = make(map[string]struct{})
+			}
+			expired[key] = struct{}{}
+		}
+	}
+	if len(expired) == 0 {
+		return dict
+	}
+	return &liveDict{TTLDict: dict, expired: expired}
+}
+
+// liveDict is the view returned by TTLDict.Live, writing through it is not supported
+type liveDict struct {
+	*TTLDict
+	expired map[string]struct{}
+}
+
+func (view *liveDict) isExpired(key string) bool {
+	_, ok := view.expired[key]
+	return ok
+}
+
+func (view *liveDict) Get(key string) (val interface{}, exists bool) {
+	if view.isExpired(key) {
+		return nil, false
+	}
+	return view.TTLDict.Get(key)
+}
+
+func (view *liveDict) Len() int {
+	return view.TTLDict.Len() - len(view.expired)
+}
+
+func (view *liveDict) ForEach(consumer Consumer) {
+	view.TTLDict.ForEach(func(key string, val interface{}) bool {
+		if view.isExpired(key) {
+			return true
+		}
+		return consumer(key, val)
+	})
+}
+
+func (view *liveDict) Keys() []string {
+	keys := make([]string, 0, view.Len())
+	view.ForEach(func(key string, val interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+func (view *liveDict) RandomKeys(limit int) []string {
+	if view.Len() == 0 {
+		return nil
+	}
+	keys := make([]string, 0, limit)
+	for len(keys) < limit {
+		for _, key := range view.TTLDict.RandomKeys(limit - len(keys)) {
+			if !view.isExpired(key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+func (view *liveDict) RandomDistinctKeys(limit int) []string {
+	if limit >= view.Len() {
+		return view.Keys()
+	}
+	// expired keys are at most len(expired) of the sample, sampling more keeps enough live ones
+	keys := make([]string, 0, limit)
+	for _, key := range view.TTLDict.RandomDistinctKeys(limit + len(view.expired)) {
+		if len(keys) == limit {
+			break
+		}
+		if !view.isExpired(key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (view *liveDict) DictScan(cursor int, count int, pattern string) ([][]byte, int) {
+	result, next := view.TTLDict.DictScan(cursor, count, pattern)
+	filtered := result[:0]
+	for i := 0; i+1 < len(result); i += 2 {
+		if !view.isExpired(string(result[i])) {
+			filtered = append(filtered, result[i], result[i+1])
+		}
+	}
+	return filtered, next
+}
+
+func (view *liveDict) KeyScan(cursor int, count int, pattern string) ([][]byte, int) {
+	result, next := view.TTLDict.KeyScan(cursor, count, pattern)
+	filtered := result[:0]
+	for _, key := range result {
+		if !view.isExpired(string(key)) {
+			filtered = append(filtered, key)
+		}
+	}
+	return filtered, next
+}
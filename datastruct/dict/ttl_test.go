@@ -0,0 +1,104 @@
+package dict
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTTLDict(t *testing.T) {
+	d := MakeTTL(MakeSimple())
+	d.Put("a", 1)
+	d.Put("b", 2)
+	d.Put("c", 3)
+	if d.Expire("nosuchkey", time.Now()) {
+		t.Error("expect failure when expiring a missing key")
+	}
+	now := time.Now()
+	d.Expire("a", now.Add(-time.Second))
+	d.Expire("b", now.Add(time.Hour))
+	d.Expire("c", now.Add(time.Minute))
+	if next, ok := d.NextExpireTime(); !ok || !next.Equal(now.Add(-time.Second)) {
+		t.Errorf("wrong next expire time %v", next)
+	}
+	removed := d.RemoveExpired(now)
+	if len(removed) != 1 || removed[0] != "a" {
+		t.Errorf("expect a to be removed, actually %v", removed)
+	}
+	if _, ok := d.Get("a"); ok {
+		t.Error("expired key is not removed")
+	}
+	// overwriting keeps ttl while removing clears it
+	d.Put("b", 4)
+	if _, ok := d.ExpireTime("b"); !ok {
+		t.Error("expect ttl of b to be kept")
+	}
+	d.Remove("b")
+	d.Put("b", 5)
+	if _, ok := d.ExpireTime("b"); ok {
+		t.Error("expect ttl of b to be cleared")
+	}
+	if !d.Persist("c") || d.Persist("c") {
+		t.Error("wrong result of Persist")
+	}
+	if d.ExpireCount() != 0 {
+		t.Errorf("expect no ttl, actually %d", d.ExpireCount())
+	}
+}
+
+func TestTTLDictLive(t *testing.T) {
+	d := MakeTTL(MakeSimple())
+	for i := 0; i < 10; i++ {
+		d.Put(strconv.Itoa(i), []byte(strconv.Itoa(i)))
+	}
+	now := time.Now()
+	if d.Live(now) != Dict(d) {
+		t.Error("expect the dict itself if no key expired")
+	}
+	d.Expire("0", now.Add(-time.Second))
+	d.Expire("1", now.Add(-time.Second))
+	d.Expire("2", now.Add(time.Hour))
+	view := d.Live(now)
+	if view.Len() != 8 {
+		t.Errorf("expect 8 live keys, actually %d", view.Len())
+	}
+	if _, ok := view.Get("0"); ok {
+		t.Error("expired key is visible")
+	}
+	if _, ok := view.Get("2"); !ok {
+		t.Error("key not expired yet is hidden")
+	}
+	if d.Len() != 10 {
+		t.Error("view should not remove expired keys")
+	}
+	isLive := func(key string) bool {
+		return key != "0" && key != "1"
+	}
+	for _, key := range view.Keys() {
+		if !isLive(key) {
+			t.Errorf("expired key %s in Keys", key)
+		}
+	}
+	for _, key := range view.RandomKeys(20) {
+		if !isLive(key) {
+			t.Errorf("expired key %s in RandomKeys", key)
+		}
+	}
+	keys := view.RandomDistinctKeys(5)
+	if len(keys) != 5 {
+		t.Errorf("expect 5 distinct keys, actually %d", len(keys))
+	}
+	for _, key := range keys {
+		if !isLive(key) {
+			t.Errorf("expired key %s in RandomDistinctKeys", key)
+		}
+	}
+	result, _ := view.DictScan(0, 100, "*")
+	if len(result) != 16 {
+		t.Errorf("expect 8 pairs in DictScan, actually %d", len(result)/2)
+	}
+	keysScanned, _ := view.KeyScan(0, 100, "*")
+	if len(keysScanned) != 8 {
+		t.Errorf("expect 8 keys in KeyScan, actually %d", len(keysScanned))
+	}
+}
@@ -19,6 +19,10 @@ type List interface {
 	ReverseRemoveByVal(expected Expected, count int) int
 	Len() int
 	ForEach(consumer Consumer)
+	// ReverseForEach visits elements from tail to head, index passed to consumer is counted from head
+	ReverseForEach(consumer Consumer)
 	Contains(expected Expected) bool
 	Range(start int, stop int) []interface{}
+	// Encoding returns name of the internal representation, reported by OBJECT ENCODING
+	Encoding() string
 }
@@ -238,6 +238,24 @@ func (list *LinkedList) ForEach(consumer Consumer) {
 	}
 }
 
+// ReverseForEach visits each element in the list from tail to head
+// if the consumer returns false, the loop will be break
+func (list *LinkedList) ReverseForEach(consumer Consumer) {
+	if list == nil {
+		panic("list is nil")
+	}
+	n := list.last
+	i := list.size - 1
+	for n != nil {
+		goNext := consumer(i, n.val)
+		if !goNext {
+			break
+		}
+		i--
+		n = n.prev
+	}
+}
+
 // Contains returns whether the given value exist in the list
 func (list *LinkedList) Contains(expected Expected) bool {
 	contains := false
@@ -289,3 +307,8 @@ func Make(vals ...interface{}) *LinkedList {
 	}
 	return &list
 }
+
+// Encoding returns name of the internal representation
+func (list *LinkedList) Encoding() string {
+	return "linkedlist"
+}
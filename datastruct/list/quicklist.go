@@ -357,6 +357,29 @@ func (ql *QuickList) ForEach(consumer Consumer) {
 	}
 }
 
+// ReverseForEach visits each element in the list from tail to head
+// if the consumer returns false, the loop will be break
+func (ql *QuickList) ReverseForEach(consumer Consumer) {
+	if ql == nil {
+		panic("list is nil")
+	}
+	if ql.Len() == 0 {
+		return
+	}
+	iter := ql.find(ql.size - 1)
+	i := ql.size - 1
+	for {
+		goNext := consumer(i, iter.get())
+		if !goNext {
+			break
+		}
+		i--
+		if !iter.prev() {
+			break
+		}
+	}
+}
+
 func (ql *QuickList) Contains(expected Expected) bool {
 	contains := false
 	ql.ForEach(func(i int, actual interface{}) bool {
@@ -388,3 +411,8 @@ func (ql *QuickList) Range(start int, stop int) []interface{} {
 	}
 	return slice
 }
+
+// Encoding returns name of the internal representation
+func (ql *QuickList) Encoding() string {
+	return "quicklist"
+}
@@ -25,6 +25,24 @@ func TestQuickList_Add(t *testing.T) {
 	})
 }
 
+func TestQuickList_ReverseForEach(t *testing.T) {
+	list := NewQuickList()
+	for i := 0; i < pageSize*10; i++ {
+		list.Add(i)
+	}
+	expected := pageSize*10 - 1
+	list.ReverseForEach(func(i int, v interface{}) bool {
+		if v != i || i != expected {
+			t.Errorf("wrong value at: %d", i)
+		}
+		expected--
+		return true
+	})
+	if expected != -1 {
+		t.Errorf("expect visiting all elements, stopped at %d", expected)
+	}
+}
+
 func BenchmarkQuickList_Add(b *testing.B) {
 	list := NewQuickList()
 	for i := 0; i < pageSize*10; i++ {
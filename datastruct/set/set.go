@@ -2,7 +2,6 @@ package set
 
 import (
 	"github.com/hdt3213/godis/datastruct/dict"
-	"github.com/hdt3213/godis/lib/wildcard"
 )
 
 // Set is a set of elements based on hash table
@@ -162,19 +161,12 @@ func (set *Set) RandomDistinctMembers(limit int) []string {
 	return set.dict.RandomDistinctKeys(limit)
 }
 
-// Scan set with cursor and pattern
+// SetScan returns members visited by a scan starting from cursor
 func (set *Set) SetScan(cursor int, count int, pattern string) ([][]byte, int) {
-	result := make([][]byte, 0)
-	matchKey, err := wildcard.CompilePattern(pattern)
-	if err != nil {
-		return result, -1
-	}
-	set.ForEach(func(member string) bool {
-		if pattern == "*" || matchKey.IsMatch(member) {
-			result = append(result, []byte(member))
-		}
-		return true
-	})
+	return set.dict.KeyScan(cursor, count, pattern)
+}
 
-	return result, 0
+// Encoding returns name of the internal representation
+func (set *Set) Encoding() string {
+	return set.dict.Encoding()
 }
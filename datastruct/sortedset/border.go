@@ -110,8 +110,15 @@ func ParseScoreBorder(s string) (Border, error) {
 }
 
 func (border *ScoreBorder) isIntersected(max Border) bool {
+	maxBorder := max.(*ScoreBorder)
+	if border.Inf == scoreNegativeInf || maxBorder.Inf == scorePositiveInf {
+		return border.Inf == scorePositiveInf || maxBorder.Inf == scoreNegativeInf
+	}
+	if border.Inf == scorePositiveInf || maxBorder.Inf == scoreNegativeInf {
+		return true
+	}
 	minValue := border.Value
-	maxValue := max.(*ScoreBorder).Value
+	maxValue := maxBorder.Value
 	return minValue > maxValue || (minValue == maxValue && (border.getExclude() || max.getExclude()))
 }
 
@@ -168,6 +175,9 @@ var lexNegativeInfBorder = &LexBorder{
 
 // ParseLexBorder creates LexBorder from redis arguments
 func ParseLexBorder(s string) (Border, error) {
+	if len(s) == 0 {
+		return nil, errors.New("ERR min or max not valid string range item")
+	}
 	if s == "+" {
 		return lexPositiveInfBorder, nil
 	}
@@ -194,7 +204,14 @@ func ParseLexBorder(s string) (Border, error) {
 }
 
 func (border *LexBorder) isIntersected(max Border) bool {
+	maxBorder := max.(*LexBorder)
+	if border.Inf == lexNegativeInf || maxBorder.Inf == lexPositiveInf {
+		return border.Inf == lexPositiveInf || maxBorder.Inf == lexNegativeInf
+	}
+	if border.Inf == lexPositiveInf || maxBorder.Inf == lexNegativeInf {
+		return true
+	}
 	minValue := border.Value
-	maxValue := max.(*LexBorder).Value
-	return border.Inf == '+' || minValue > maxValue || (minValue == maxValue && (border.getExclude() || max.getExclude()))
+	maxValue := maxBorder.Value
+	return minValue > maxValue || (minValue == maxValue && (border.getExclude() || max.getExclude()))
 }
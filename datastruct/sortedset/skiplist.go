@@ -221,6 +221,14 @@ func (skiplist *skiplist) getByRank(rank int64) *node {
 	return nil
 }
 
+// getRandom returns a node picked uniformly at random, returns nil if skiplist is empty
+func (skiplist *skiplist) getRandom() *node {
+	if skiplist.length == 0 {
+		return nil
+	}
+	return skiplist.getByRank(rand.Int63n(skiplist.length) + 1)
+}
+
 func (skiplist *skiplist) hasInRange(min Border, max Border) bool {
 	if min.isIntersected(max) { //是有交集的，则返回false
 		return false
@@ -276,6 +284,64 @@ func (skiplist *skiplist) getLastInRange(min Border, max Border) *node {
 	return n
 }
 
+/*
+ * lexicographical range lookups, only meaningful when all members share the same score
+ * members are compared by their byte-wise value just like memcmp in redis
+ */
+func (skiplist *skiplist) hasInLexRange(min *LexBorder, max *LexBorder) bool {
+	if min.isIntersected(max) {
+		return false
+	}
+	// min > tail
+	n := skiplist.tail
+	if n == nil || !min.less(&n.Element) {
+		return false
+	}
+	// max < head
+	n = skiplist.header.level[0].forward
+	if n == nil || !max.greater(&n.Element) {
+		return false
+	}
+	return true
+}
+
+func (skiplist *skiplist) getFirstInLexRange(min *LexBorder, max *LexBorder) *node {
+	if !skiplist.hasInLexRange(min, max) {
+		return nil
+	}
+	n := skiplist.header
+	// scan from top level
+	for level := skiplist.level - 1; level >= 0; level-- {
+		// if forward is not in range than move forward
+		for n.level[level].forward != nil && !min.less(&n.level[level].forward.Element) {
+			n = n.level[level].forward
+		}
+	}
+	/* This is an inner range, so the next node cannot be NULL. */
+	n = n.level[0].forward
+	if !max.greater(&n.Element) {
+		return nil
+	}
+	return n
+}
+
+func (skiplist *skiplist) getLastInLexRange(min *LexBorder, max *LexBorder) *node {
+	if !skiplist.hasInLexRange(min, max) {
+		return nil
+	}
+	n := skiplist.header
+	// scan from top level
+	for level := skiplist.level - 1; level >= 0; level-- {
+		for n.level[level].forward != nil && max.greater(&n.level[level].forward.Element) {
+			n = n.level[level].forward
+		}
+	}
+	if !min.less(&n.Element) {
+		return nil
+	}
+	return n
+}
+
 /*
  * return removed elements
  */
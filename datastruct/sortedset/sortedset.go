@@ -1,33 +1,35 @@
 package sortedset
 
 import (
+	"math/rand"
 	"strconv"
 
-	"github.com/hdt3213/godis/lib/wildcard"
+	"github.com/hdt3213/godis/datastruct/dict"
 )
 
 // SortedSet is a set which keys sorted by bound score
 type SortedSet struct {
-	dict     map[string]*Element
+	dict     *dict.SimpleDict // member -> *Element
 	skiplist *skiplist
 }
 
 // Make makes a new SortedSet
 func Make() *SortedSet {
 	return &SortedSet{
-		dict:     make(map[string]*Element),
+		dict:     dict.MakeSimple(),
 		skiplist: makeSkiplist(),
 	}
 }
 
 // Add puts member into set,  and returns whether it has inserted new node
 func (sortedSet *SortedSet) Add(member string, score float64) bool {
-	element, ok := sortedSet.dict[member]
-	sortedSet.dict[member] = &Element{
+	raw, ok := sortedSet.dict.Get(member)
+	sortedSet.dict.Put(member, &Element{
 		Member: member,
 		Score:  score,
-	}
+	})
 	if ok {
+		element := raw.(*Element)
 		if score != element.Score {
 			sortedSet.skiplist.remove(member, element.Score)
 			sortedSet.skiplist.insert(member, score)
@@ -40,24 +42,24 @@ func (sortedSet *SortedSet) Add(member string, score float64) bool {
 
 // Len returns number of members in set
 func (sortedSet *SortedSet) Len() int64 {
-	return int64(len(sortedSet.dict))
+	return int64(sortedSet.dict.Len())
 }
 
 // Get returns the given member
 func (sortedSet *SortedSet) Get(member string) (element *Element, ok bool) {
-	element, ok = sortedSet.dict[member]
+	raw, ok := sortedSet.dict.Get(member)
 	if !ok {
 		return nil, false
 	}
-	return element, true
+	return raw.(*Element), true
 }
 
 // Remove removes the given member from set
 func (sortedSet *SortedSet) Remove(member string) bool {
-	v, ok := sortedSet.dict[member]
+	v, ok := sortedSet.Get(member)
 	if ok {
 		sortedSet.skiplist.remove(member, v.Score)
-		delete(sortedSet.dict, member)
+		sortedSet.dict.Remove(member)
 		return true
 	}
 	return false
@@ -65,7 +67,7 @@ func (sortedSet *SortedSet) Remove(member string) bool {
 
 // GetRank returns the rank of the given member, sort by ascending order, rank starts from 0
 func (sortedSet *SortedSet) GetRank(member string, desc bool) (rank int64) {
-	element, ok := sortedSet.dict[member]
+	element, ok := sortedSet.Get(member)
 	if !ok {
 		return -1
 	}
@@ -128,6 +130,49 @@ func (sortedSet *SortedSet) RangeByRank(start int64, stop int64, desc bool) []*E
 	return slice
 }
 
+// RandomElements returns elements picked randomly, the same element may be returned more than once
+func (sortedSet *SortedSet) RandomElements(limit int) []*Element {
+	if sortedSet.Len() == 0 {
+		return nil
+	}
+	result := make([]*Element, limit)
+	for i := range result {
+		result[i] = &sortedSet.skiplist.getRandom().Element
+	}
+	return result
+}
+
+// RandomDistinctElements returns at most limit distinct elements picked randomly
+func (sortedSet *SortedSet) RandomDistinctElements(limit int) []*Element {
+	size := sortedSet.Len()
+	if size == 0 {
+		return nil
+	}
+	if int64(limit) >= size {
+		return sortedSet.RangeByRank(0, size, false)
+	}
+	if int64(limit)*3 > size {
+		// picking randomly would meet too many duplicates, shuffle all elements instead
+		elements := sortedSet.RangeByRank(0, size, false)
+		for i := 0; i < limit; i++ {
+			j := i + rand.Intn(len(elements)-i)
+			elements[i], elements[j] = elements[j], elements[i]
+		}
+		return elements[:limit]
+	}
+	picked := make(map[string]struct{}, limit)
+	result := make([]*Element, 0, limit)
+	for len(result) < limit {
+		element := &sortedSet.skiplist.getRandom().Element
+		if _, ok := picked[element.Member]; ok {
+			continue
+		}
+		picked[element.Member] = struct{}{}
+		result = append(result, element)
+	}
+	return result
+}
+
 // RangeCount returns the number of  members which score or member within the given border
 func (sortedSet *SortedSet) RangeCount(min Border, max Border) int64 {
 	var i int64 = 0
@@ -154,7 +199,15 @@ func (sortedSet *SortedSet) RangeCount(min Border, max Border) int64 {
 func (sortedSet *SortedSet) ForEach(min Border, max Border, offset int64, limit int64, desc bool, consumer func(element *Element) bool) {
 	// find start node
 	var node *node
-	if desc {
+	lexMin, isLexMin := min.(*LexBorder)
+	lexMax, isLexMax := max.(*LexBorder)
+	if isLexMin && isLexMax {
+		if desc {
+			node = sortedSet.skiplist.getLastInLexRange(lexMin, lexMax)
+		} else {
+			node = sortedSet.skiplist.getFirstInLexRange(lexMin, lexMax)
+		}
+	} else if desc {
 		node = sortedSet.skiplist.getLastInRange(min, max)
 	} else {
 		node = sortedSet.skiplist.getFirstInRange(min, max)
@@ -168,6 +221,9 @@ func (sortedSet *SortedSet) ForEach(min Border, max Border, offset int64, limit
 		}
 		offset--
 	}
+	if node != nil && (!min.less(&node.Element) || !max.greater(&node.Element)) {
+		return // offset skipped over the whole range
+	}
 
 	// A negative limit returns all elements from the offset
 	for i := 0; (i < int(limit) || limit < 0) && node != nil; i++ {
@@ -208,7 +264,7 @@ func (sortedSet *SortedSet) Range(min Border, max Border, offset int64, limit in
 func (sortedSet *SortedSet) RemoveRange(min Border, max Border) int64 {
 	removed := sortedSet.skiplist.RemoveRange(min, max, 0)
 	for _, element := range removed {
-		delete(sortedSet.dict, element.Member)
+		sortedSet.dict.Remove(element.Member)
 	}
 	return int64(len(removed))
 }
@@ -224,7 +280,7 @@ func (sortedSet *SortedSet) PopMin(count int) []*Element {
 	}
 	removed := sortedSet.skiplist.RemoveRange(border, scorePositiveInfBorder, count)
 	for _, element := range removed {
-		delete(sortedSet.dict, element.Member)
+		sortedSet.dict.Remove(element.Member)
 	}
 	return removed
 }
@@ -234,26 +290,23 @@ func (sortedSet *SortedSet) PopMin(count int) []*Element {
 func (sortedSet *SortedSet) RemoveByRank(start int64, stop int64) int64 {
 	removed := sortedSet.skiplist.RemoveRangeByRank(start+1, stop+1)
 	for _, element := range removed {
-		delete(sortedSet.dict, element.Member)
+		sortedSet.dict.Remove(element.Member)
 	}
 	return int64(len(removed))
 }
 
+// ZSetScan returns member and score pairs visited by a scan starting from cursor, see dict.SimpleDict.ScanEach
 func (sortedSet *SortedSet) ZSetScan(cursor int, count int, pattern string) ([][]byte, int) {
 	result := make([][]byte, 0)
-	matchKey, err := wildcard.CompilePattern(pattern)
-	if err != nil {
-		return result, -1
-	}
-	for k := range sortedSet.dict {
-		if pattern == "*" || matchKey.IsMatch(k) {
-			elem, exists := sortedSet.dict[k]
-			if !exists {
-				continue
-			}
-			result = append(result, []byte(k))
-			result = append(result, []byte(strconv.FormatFloat(elem.Score, 'f', 10, 64)))
-		}
-	}
-	return result, 0
+	nextCursor := sortedSet.dict.ScanEach(cursor, count, pattern, func(member string, val interface{}) bool {
+		element := val.(*Element)
+		result = append(result, []byte(member), []byte(strconv.FormatFloat(element.Score, 'f', -1, 64)))
+		return true
+	})
+	return result, nextCursor
+}
+
+// Encoding returns name of the internal representation
+func (sortedSet *SortedSet) Encoding() string {
+	return "skiplist"
 }
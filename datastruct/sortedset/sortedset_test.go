@@ -1,6 +1,7 @@
 package sortedset
 
 import (
+	"strconv"
 	"testing"
 
 	"github.com/hdt3213/godis/lib/utils"
@@ -19,6 +20,31 @@ func TestSortedSet_PopMin(t *testing.T) {
 	}
 }
 
+func TestSortedSet_RangeByLex(t *testing.T) {
+	set := Make()
+	for _, member := range []string{"a", "b", "c", "d", "e"} {
+		set.Add(member, 0)
+	}
+	min, _ := ParseLexBorder("[b")
+	max, _ := ParseLexBorder("+")
+	results := set.Range(min, max, 0, -1, false)
+	if len(results) != 4 || results[0].Member != "b" || results[3].Member != "e" {
+		t.Error("wrong range result")
+	}
+	results = set.Range(min, max, 0, -1, true)
+	if len(results) != 4 || results[0].Member != "e" || results[3].Member != "b" {
+		t.Error("wrong reverse range result")
+	}
+	min, _ = ParseLexBorder("(c")
+	max, _ = ParseLexBorder("[c")
+	if set.skiplist.getFirstInLexRange(min.(*LexBorder), max.(*LexBorder)) != nil {
+		t.Error("expect empty range")
+	}
+	if _, err := ParseLexBorder("c"); err == nil {
+		t.Error("expect error")
+	}
+}
+
 func TestSetScan(t *testing.T) {
 	set := Make()
 	size := 10
@@ -45,3 +71,56 @@ func TestSetScan(t *testing.T) {
 		return
 	}
 }
+
+func TestRangeInfBorder(t *testing.T) {
+	set := Make()
+	for i := 1; i <= 3; i++ {
+		set.Add(strconv.Itoa(i), float64(i))
+	}
+	min, _ := ParseScoreBorder("2")
+	elements := set.Range(min, scorePositiveInfBorder, 0, -1, true)
+	if len(elements) != 2 || elements[0].Member != "3" {
+		t.Errorf("expect [3 2], actual: %v", elements)
+	}
+	max, _ := ParseScoreBorder("-2")
+	if count := set.RangeCount(scoreNegativeInfBorder, max); count != 0 {
+		t.Errorf("expect 0, actual: %d", count)
+	}
+	if count := set.RangeCount(scorePositiveInfBorder, scoreNegativeInfBorder); count != 0 {
+		t.Errorf("expect 0, actual: %d", count)
+	}
+}
+
+func TestRandomElements(t *testing.T) {
+	set := Make()
+	if len(set.RandomElements(3)) != 0 || len(set.RandomDistinctElements(3)) != 0 {
+		t.Error("expect no elements from empty set")
+	}
+	size := 100
+	for i := 0; i < size; i++ {
+		set.Add(strconv.Itoa(i), float64(i))
+	}
+	if elements := set.RandomElements(size * 2); len(elements) != size*2 {
+		t.Errorf("expect %d elements, actual: %d", size*2, len(elements))
+	}
+	for _, limit := range []int{10, 50, 100, 200} {
+		elements := set.RandomDistinctElements(limit)
+		expected := limit
+		if expected > size {
+			expected = size
+		}
+		if len(elements) != expected {
+			t.Errorf("expect %d elements, actual: %d", expected, len(elements))
+		}
+		seen := make(map[string]struct{})
+		for _, element := range elements {
+			if _, ok := seen[element.Member]; ok {
+				t.Errorf("duplicated member %s", element.Member)
+			}
+			seen[element.Member] = struct{}{}
+			if _, ok := set.Get(element.Member); !ok {
+				t.Errorf("unknown member %s", element.Member)
+			}
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Package tdigest implements merging t-digest for streaming quantile estimation, stored as a plain string payload
+package tdigest
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+)
+
+/*
+ * payload layout (big endian):
+ *   magic(4) | compression(8) | min(8) | max(8) | centroidNum(4) | centroids: mean(8) | weight(8)
+ * centroids are always compressed and sorted by mean in payload
+ */
+
+const (
+	headerSize   = 32
+	centroidSize = 16
+	// DefaultCompression is the default accuracy / size trade-off
+	DefaultCompression = 100
+)
+
+var magic = []byte("GTDG")
+
+// ErrNotTDigest means payload is not a serialized t-digest
+var ErrNotTDigest = errors.New("not a t-digest")
+
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+// TDigest is a sketch of a distribution
+type TDigest struct {
+	compression float64
+	min         float64
+	max         float64
+	centroids   []centroid
+}
+
+// Make creates an empty t-digest
+func Make(compression float64) *TDigest {
+	return &TDigest{
+		compression: compression,
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+// IsTDigest checks whether the given payload is a serialized t-digest
+func IsTDigest(payload []byte) bool {
+	return len(payload) >= headerSize && string(payload[:4]) == string(magic)
+}
+
+// FromBytes restores a t-digest from payload
+func FromBytes(payload []byte) (*TDigest, error) {
+	if !IsTDigest(payload) {
+		return nil, ErrNotTDigest
+	}
+	td := &TDigest{
+		compression: math.Float64frombits(binary.BigEndian.Uint64(payload[4:])),
+		min:         math.Float64frombits(binary.BigEndian.Uint64(payload[12:])),
+		max:         math.Float64frombits(binary.BigEndian.Uint64(payload[20:])),
+	}
+	n := int(binary.BigEndian.Uint32(payload[28:]))
+	if len(payload) != headerSize+n*centroidSize {
+		return nil, ErrNotTDigest
+	}
+	td.centroids = make([]centroid, n)
+	for i := range td.centroids {
+		offset := headerSize + i*centroidSize
+		td.centroids[i].mean = math.Float64frombits(binary.BigEndian.Uint64(payload[offset:]))
+		td.centroids[i].weight = math.Float64frombits(binary.BigEndian.Uint64(payload[offset+8:]))
+	}
+	return td, nil
+}
+
+// ToBytes returns serialized t-digest
+func (td *TDigest) ToBytes() []byte {
+	buf := make([]byte, headerSize+len(td.centroids)*centroidSize)
+	copy(buf, magic)
+	binary.BigEndian.PutUint64(buf[4:], math.Float64bits(td.compression))
+	binary.BigEndian.PutUint64(buf[12:], math.Float64bits(td.min))
+	binary.BigEndian.PutUint64(buf[20:], math.Float64bits(td.max))
+	binary.BigEndian.PutUint32(buf[28:], uint32(len(td.centroids)))
+	for i, c := range td.centroids {
+		offset := headerSize + i*centroidSize
+		binary.BigEndian.PutUint64(buf[offset:], math.Float64bits(c.mean))
+		binary.BigEndian.PutUint64(buf[offset+8:], math.Float64bits(c.weight))
+	}
+	return buf
+}
+
+// Compression returns compression parameter
+func (td *TDigest) Compression() float64 {
+	return td.compression
+}
+
+// Count returns total weight of observations
+func (td *TDigest) Count() float64 {
+	var total float64
+	for _, c := range td.centroids {
+		total += c.weight
+	}
+	return total
+}
+
+// Add puts observations into t-digest
+func (td *TDigest) Add(values ...float64) {
+	points := make([]centroid, len(values))
+	for i, v := range values {
+		points[i] = centroid{mean: v, weight: 1}
+		td.min = math.Min(td.min, v)
+		td.max = math.Max(td.max, v)
+	}
+	td.compress(points)
+}
+
+// Merge puts all observations of other t-digests into td
+func (td *TDigest) Merge(others ...*TDigest) {
+	var points []centroid
+	for _, other := range others {
+		points = append(points, other.centroids...)
+		td.min = math.Min(td.min, other.min)
+		td.max = math.Max(td.max, other.max)
+	}
+	td.compress(points)
+}
+
+// compress merges adjacent centroids as long as they stay within the size bound of the scale function
+func (td *TDigest) compress(points []centroid) {
+	all := append(points, td.centroids...)
+	if len(all) == 0 {
+		return
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].mean < all[j].mean
+	})
+	var total float64
+	for _, c := range all {
+		total += c.weight
+	}
+	result := make([]centroid, 0, len(td.centroids))
+	current := all[0]
+	var weightSoFar float64
+	for _, next := range all[1:] {
+		proposed := current.weight + next.weight
+		q0 := weightSoFar / total
+		q2 := (weightSoFar + proposed) / total
+		if proposed <= total*math.Min(td.maxSize(q0), td.maxSize(q2)) {
+			current.mean += (next.mean - current.mean) * next.weight / proposed
+			current.weight = proposed
+		} else {
+			weightSoFar += current.weight
+			result = append(result, current)
+			current = next
+		}
+	}
+	td.centroids = append(result, current)
+}
+
+// maxSize is the max relative weight of a centroid at quantile q, centroids near tails are small
+func (td *TDigest) maxSize(q float64) float64 {
+	return 4 * q * (1 - q) / td.compression
+}
+
+// Quantile estimates the value at quantile q, returns NaN if t-digest is empty
+func (td *TDigest) Quantile(q float64) float64 {
+	n := len(td.centroids)
+	if n == 0 {
+		return math.NaN()
+	}
+	if q <= 0 {
+		return td.min
+	}
+	if q >= 1 {
+		return td.max
+	}
+	if n == 1 {
+		return td.centroids[0].mean
+	}
+	index := q * td.Count()
+	first := td.centroids[0]
+	if index < first.weight/2 {
+		// interpolate between min and center of the first centroid
+		return td.min + index/(first.weight/2)*(first.mean-td.min)
+	}
+	weightSoFar := first.weight / 2
+	for i := 0; i < n-1; i++ {
+		dw := (td.centroids[i].weight + td.centroids[i+1].weight) / 2
+		if weightSoFar+dw > index {
+			z := (index - weightSoFar) / dw
+			return td.centroids[i].mean + z*(td.centroids[i+1].mean-td.centroids[i].mean)
+		}
+		weightSoFar += dw
+	}
+	// interpolate between center of the last centroid and max
+	last := td.centroids[n-1]
+	z := (index - weightSoFar) / (last.weight / 2)
+	return last.mean + z*(td.max-last.mean)
+}
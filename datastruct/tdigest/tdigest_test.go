@@ -0,0 +1,60 @@
+package tdigest
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestQuantile(t *testing.T) {
+	td := Make(DefaultCompression)
+	if !math.IsNaN(td.Quantile(0.5)) {
+		t.Error("expect NaN for empty t-digest")
+	}
+	size := 100000
+	values := make([]float64, 0, 1000)
+	for i := 0; i < size; i++ {
+		values = append(values, rand.Float64()*1000)
+		if len(values) == cap(values) {
+			td.Add(values...)
+			values = values[:0]
+		}
+	}
+	for _, q := range []float64{0.01, 0.1, 0.5, 0.9, 0.99} {
+		actual := td.Quantile(q)
+		if math.Abs(actual-q*1000) > 10 {
+			t.Errorf("quantile %f: expect about %f, actual %f", q, q*1000, actual)
+		}
+	}
+	if len(td.centroids) > 10*DefaultCompression {
+		t.Errorf("too many centroids: %d", len(td.centroids))
+	}
+
+	restored, err := FromBytes(td.ToBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Quantile(0.5) != td.Quantile(0.5) || restored.Count() != float64(size) {
+		t.Error("restored t-digest mismatch")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a := Make(DefaultCompression)
+	b := Make(DefaultCompression)
+	for i := 1; i <= 50; i++ {
+		a.Add(float64(i))
+		b.Add(float64(i + 50))
+	}
+	dest := Make(DefaultCompression)
+	dest.Merge(a, b)
+	if dest.Count() != 100 {
+		t.Errorf("expect count 100, actual %f", dest.Count())
+	}
+	if dest.Quantile(0) != 1 || dest.Quantile(1) != 100 {
+		t.Error("wrong min or max")
+	}
+	if median := dest.Quantile(0.5); math.Abs(median-50.5) > 1 {
+		t.Errorf("wrong median %f", median)
+	}
+}
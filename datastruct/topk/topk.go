@@ -0,0 +1,279 @@
+// Package topk implements HeavyKeeper top-k which is stored as a plain string payload
+package topk
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+/*
+ * payload layout (big endian):
+ *   magic(4) | k(4) | width(4) | depth(4) | decay(8)
+ *   buckets(width * depth * 8): fingerprint(4) | count(4)
+ *   heap: size(4) | entries: count(4) | len(4) | item
+ * the heap section has variable length, so the payload is re-encoded after each modification
+ */
+
+const (
+	headerSize = 24
+	bucketSize = 8
+	maxBuckets = 1 << 26
+	// DefaultWidth is the default number of buckets per row
+	DefaultWidth = 8
+	// DefaultDepth is the default number of rows
+	DefaultDepth = 7
+	// DefaultDecay is the default probability base of decreasing a foreign bucket
+	DefaultDecay = 0.9
+)
+
+var magic = []byte("GTPK")
+
+var (
+	// ErrNotTopK means payload is not a serialized top-k
+	ErrNotTopK = errors.New("not a top-k")
+	// ErrTooLarge means width * depth is too large
+	ErrTooLarge = errors.New("TopK: width * depth is too large")
+)
+
+// Item is a heavy hitter and its estimated count
+type Item struct {
+	Item  string
+	Count uint32
+}
+
+// TopK tracks the k most frequent items
+type TopK struct {
+	buf  []byte
+	heap []*Item // unordered, k is small enough for linear scan
+}
+
+// Make creates an empty top-k
+func Make(k uint32, width uint32, depth uint32, decay float64) (*TopK, error) {
+	if uint64(width)*uint64(depth) > maxBuckets {
+		return nil, ErrTooLarge
+	}
+	t := &TopK{
+		buf: make([]byte, headerSize+int(width)*int(depth)*bucketSize),
+	}
+	copy(t.buf, magic)
+	binary.BigEndian.PutUint32(t.buf[4:], k)
+	binary.BigEndian.PutUint32(t.buf[8:], width)
+	binary.BigEndian.PutUint32(t.buf[12:], depth)
+	binary.BigEndian.PutUint64(t.buf[16:], math.Float64bits(decay))
+	t.encodeHeap()
+	return t, nil
+}
+
+// IsTopK checks whether the given payload is a serialized top-k
+func IsTopK(payload []byte) bool {
+	return len(payload) >= headerSize && string(payload[:4]) == string(magic)
+}
+
+// FromBytes restores a top-k from payload, buckets share memory with payload
+func FromBytes(payload []byte) (*TopK, error) {
+	if !IsTopK(payload) {
+		return nil, ErrNotTopK
+	}
+	t := &TopK{buf: payload}
+	width, depth, decay := uint64(t.width()), uint64(t.depth()), t.decay()
+	if t.K() == 0 || width == 0 || depth == 0 || width*depth > maxBuckets {
+		return nil, ErrNotTopK
+	}
+	if !(decay > 0 && decay <= 1) { // also rejects NaN
+		return nil, ErrNotTopK
+	}
+	offset := t.heapOffset()
+	if offset+4 > len(payload) {
+		return nil, ErrNotTopK
+	}
+	size := binary.BigEndian.Uint32(payload[offset:])
+	if size > t.K() {
+		return nil, ErrNotTopK
+	}
+	offset += 4
+	for i := uint32(0); i < size; i++ {
+		if offset+8 > len(payload) {
+			return nil, ErrNotTopK
+		}
+		count := binary.BigEndian.Uint32(payload[offset:])
+		n := int(binary.BigEndian.Uint32(payload[offset+4:]))
+		offset += 8
+		if offset+n > len(payload) {
+			return nil, ErrNotTopK
+		}
+		t.heap = append(t.heap, &Item{
+			Item:  string(payload[offset : offset+n]),
+			Count: count,
+		})
+		offset += n
+	}
+	if offset != len(payload) {
+		return nil, ErrNotTopK
+	}
+	return t, nil
+}
+
+// ToBytes returns serialized top-k
+func (t *TopK) ToBytes() []byte {
+	return t.buf
+}
+
+// K returns number of items to track
+func (t *TopK) K() uint32 {
+	return binary.BigEndian.Uint32(t.buf[4:])
+}
+
+func (t *TopK) width() uint32 {
+	return binary.BigEndian.Uint32(t.buf[8:])
+}
+
+func (t *TopK) depth() uint32 {
+	return binary.BigEndian.Uint32(t.buf[12:])
+}
+
+func (t *TopK) decay() float64 {
+	return math.Float64frombits(binary.BigEndian.Uint64(t.buf[16:]))
+}
+
+func (t *TopK) heapOffset() int {
+	return headerSize + int(t.width())*int(t.depth())*bucketSize
+}
+
+func (t *TopK) encodeHeap() {
+	size := 4
+	for _, item := range t.heap {
+		size += 8 + len(item.Item)
+	}
+	offset := t.heapOffset()
+	buf := make([]byte, offset+size)
+	copy(buf, t.buf[:offset])
+	binary.BigEndian.PutUint32(buf[offset:], uint32(len(t.heap)))
+	offset += 4
+	for _, item := range t.heap {
+		binary.BigEndian.PutUint32(buf[offset:], item.Count)
+		binary.BigEndian.PutUint32(buf[offset+4:], uint32(len(item.Item)))
+		offset += 8
+		offset += copy(buf[offset:], item.Item)
+	}
+	t.buf = buf
+}
+
+func (t *TopK) bucket(row uint32, col uint32) []byte {
+	start := headerSize + (int(row)*int(t.width())+int(col))*bucketSize
+	return t.buf[start : start+bucketSize]
+}
+
+// random returns a deterministic pseudo random number in [0, 1), so that aof replay gets the same result
+func random(fp uint32, count uint32, row uint32) float64 {
+	// splitmix64
+	x := uint64(fp)<<32 | uint64(count)
+	x ^= uint64(row) * 0x9e3779b97f4a7c15
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	x ^= x >> 31
+	return float64(x>>11) / (1 << 53)
+}
+
+func hashItem(item []byte) (fp uint32, h1 uint64, h2 uint64) {
+	h32 := fnv.New32a()
+	_, _ = h32.Write(item)
+	h64 := fnv.New64a()
+	_, _ = h64.Write(item)
+	h1 = h64.Sum64()
+	return h32.Sum32(), h1, h1>>32 | h1<<32 | 1
+}
+
+func (t *TopK) findInHeap(item string) int {
+	for i, entry := range t.heap {
+		if entry.Item == item {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *TopK) minInHeap() int {
+	min := 0
+	for i, entry := range t.heap {
+		if entry.Count < t.heap[min].Count {
+			min = i
+		}
+	}
+	return min
+}
+
+// IncrBy increases count of item, returns the item expelled from top-k if any
+func (t *TopK) IncrBy(item []byte, increment uint32) (expelled string, hasExpelled bool) {
+	fp, h1, h2 := hashItem(item)
+	width := uint64(t.width())
+	decay := t.decay()
+	var maxCount uint32
+	for row := uint32(0); row < t.depth(); row++ {
+		bucket := t.bucket(row, uint32((h1+uint64(row)*h2)%width))
+		bucketFp := binary.BigEndian.Uint32(bucket)
+		count := binary.BigEndian.Uint32(bucket[4:])
+		if count == 0 || bucketFp == fp {
+			bucketFp = fp
+			if count > math.MaxUint32-increment {
+				count = math.MaxUint32
+			} else {
+				count += increment
+			}
+		} else {
+			// decay the foreign fingerprint, take over the bucket once it reaches zero
+			for remain := increment; remain > 0; remain-- {
+				if random(bucketFp, count, row) < math.Pow(decay, float64(count)) {
+					count--
+					if count == 0 {
+						bucketFp = fp
+						count = remain
+						break
+					}
+				}
+			}
+		}
+		binary.BigEndian.PutUint32(bucket, bucketFp)
+		binary.BigEndian.PutUint32(bucket[4:], count)
+		if bucketFp == fp && count > maxCount {
+			maxCount = count
+		}
+	}
+
+	member := string(item)
+	if i := t.findInHeap(member); i >= 0 {
+		if maxCount > t.heap[i].Count {
+			t.heap[i].Count = maxCount
+		}
+	} else if uint32(len(t.heap)) < t.K() {
+		if maxCount > 0 {
+			t.heap = append(t.heap, &Item{Item: member, Count: maxCount})
+		}
+	} else if min := t.minInHeap(); maxCount > t.heap[min].Count {
+		expelled, hasExpelled = t.heap[min].Item, true
+		t.heap[min] = &Item{Item: member, Count: maxCount}
+	}
+	t.encodeHeap()
+	return expelled, hasExpelled
+}
+
+// Query returns whether item is in top-k
+func (t *TopK) Query(item []byte) bool {
+	return t.findInHeap(string(item)) >= 0
+}
+
+// List returns items in top-k, sorted by count in descending order
+func (t *TopK) List() []*Item {
+	items := make([]*Item, len(t.heap))
+	copy(items, t.heap)
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Item < items[j].Item
+	})
+	return items
+}
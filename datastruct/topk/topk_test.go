@@ -0,0 +1,108 @@
+package topk
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestTopK(t *testing.T) {
+	tk, err := Make(5, 50, 5, 0.9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// heavy hitters h0 ~ h4 appear 1000 times, 200 light items appear 10 times
+	for round := 0; round < 1000; round++ {
+		for i := 0; i < 5; i++ {
+			tk.IncrBy([]byte("h"+strconv.Itoa(i)), 1)
+		}
+		if round%100 == 0 {
+			for i := 0; i < 200; i++ {
+				tk.IncrBy([]byte("l"+strconv.Itoa(i)), 1)
+			}
+		}
+	}
+	items := tk.List()
+	if len(items) != 5 {
+		t.Fatalf("expect 5 items, actual %d", len(items))
+	}
+	for _, item := range items {
+		if item.Item[0] != 'h' {
+			t.Errorf("unexpected heavy hitter: %s", item.Item)
+		}
+	}
+	for i := 1; i < len(items); i++ {
+		if items[i].Count > items[i-1].Count {
+			t.Error("list is not sorted")
+		}
+	}
+
+	restored, err := FromBytes(tk.ToBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restored.Query([]byte(items[0].Item)) || restored.K() != 5 {
+		t.Error("restored top-k mismatch")
+	}
+}
+
+func TestExpel(t *testing.T) {
+	tk, _ := Make(1, 8, 7, 0.9)
+	tk.IncrBy([]byte("a"), 1)
+	expelled, ok := tk.IncrBy([]byte("b"), 10)
+	if !ok || expelled != "a" {
+		t.Error("expect a to be expelled")
+	}
+}
+
+func TestCorruptedPayload(t *testing.T) {
+	tk, _ := Make(2, 4, 4, 0.9)
+	tk.IncrBy([]byte("a"), 1)
+	valid := tk.ToBytes()
+	if _, err := FromBytes(valid); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := func(modify func(payload []byte) []byte) []byte {
+		return modify(append([]byte(nil), valid...))
+	}
+	heapOffset := headerSize + 4*4*bucketSize
+	cases := map[string][]byte{
+		"zero k": corrupt(func(payload []byte) []byte {
+			binary.BigEndian.PutUint32(payload[4:], 0)
+			return payload
+		}),
+		"zero width": corrupt(func(payload []byte) []byte {
+			binary.BigEndian.PutUint32(payload[8:], 0)
+			return payload
+		}),
+		"zero depth": corrupt(func(payload []byte) []byte {
+			binary.BigEndian.PutUint32(payload[12:], 0)
+			return payload
+		}),
+		"too many buckets": corrupt(func(payload []byte) []byte {
+			binary.BigEndian.PutUint32(payload[8:], math.MaxUint32)
+			binary.BigEndian.PutUint32(payload[12:], math.MaxUint32)
+			return payload
+		}),
+		"invalid decay": corrupt(func(payload []byte) []byte {
+			binary.BigEndian.PutUint64(payload[16:], math.Float64bits(math.NaN()))
+			return payload
+		}),
+		"heap larger than k": corrupt(func(payload []byte) []byte {
+			binary.BigEndian.PutUint32(payload[heapOffset:], 3)
+			return payload
+		}),
+		"truncated": corrupt(func(payload []byte) []byte {
+			return payload[:len(payload)-1]
+		}),
+		"trailing bytes": corrupt(func(payload []byte) []byte {
+			return append(payload, 0)
+		}),
+	}
+	for name, payload := range cases {
+		if _, err := FromBytes(payload); err != ErrNotTopK {
+			t.Errorf("expect ErrNotTopK for %s", name)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Package embedded runs godis inside a go process, so that applications and tests could use it as an in-memory
+// redis without spawning a process. Commands could be executed directly by Exec, or through the redis protocol if
+// Options.Addr is set.
+//
+// Since config.Properties is global, only one embedded server should run in a process at the same time.
+package embedded
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/database"
+	idatabase "github.com/hdt3213/godis/interface/database"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/connection"
+	"github.com/hdt3213/godis/redis/server/std"
+	"github.com/hdt3213/godis/tcp"
+)
+
+var (
+	// ErrNotStarted is returned by Exec before Start or after Stop
+	ErrNotStarted = errors.New("server is not running")
+	// ErrStarted is returned by Start if the server has been started
+	ErrStarted = errors.New("server has been started")
+)
+
+// Options configures an embedded server
+type Options struct {
+	// Properties replaces config.Properties on Start if it is not nil.
+	// The default properties keep data in memory only and have 16 databases
+	Properties *config.ServerProperties
+	// Addr accepts redis clients on the address if it is not empty, such as "127.0.0.1:0" for a random port
+	Addr string
+	// Hooks are callbacks of commands and key events, they could be replaced by SetHooks after Start
+	Hooks *database.Hooks
+}
+
+// Server is a standalone godis server running in process
+type Server struct {
+	opts *Options
+
+	mu        sync.Mutex
+	db        idatabase.DB
+	server    *database.Server
+	listener  net.Listener
+	closeChan chan struct{}
+	// served is closed after the listener stopped serving
+	served chan struct{}
+}
+
+// NewServer creates a server, it does nothing until Start
+func NewServer(opts *Options) *Server {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return &Server{
+		opts: opts,
+	}
+}
+
+// Start creates databases, loads persisted data if configured and starts listening if Options.Addr is set
+func (s *Server) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db != nil {
+		return ErrStarted
+	}
+	if s.opts.Properties != nil {
+		config.Properties = s.opts.Properties
+	}
+	if config.Properties.Databases == 0 {
+		config.Properties.Databases = 16
+	}
+	server := database.NewStandaloneServer()
+	if s.opts.Hooks != nil {
+		server.SetHooks(s.opts.Hooks)
+	}
+	db, err := database.WithRenamedCommands(server, config.Properties.RenameCommands)
+	if err != nil {
+		return err
+	}
+	db = database.WithTracing(db)
+	if s.opts.Addr != "" {
+		listener, err := net.Listen("tcp", s.opts.Addr)
+		if err != nil {
+			db.Close()
+			return err
+		}
+		s.listener = listener
+		s.closeChan = make(chan struct{})
+		s.served = make(chan struct{})
+		handler := std.NewHandler(db)
+		go func() {
+			defer close(s.served)
+			tcp.ListenAndServe(listener, handler, s.closeChan)
+		}()
+	}
+	s.db = db
+	s.server = server
+	return nil
+}
+
+// Addr returns the address accepting redis clients, such as the random port chosen for "127.0.0.1:0".
+// It returns empty string if the server is not listening
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
+
+// Stop disconnects clients and closes databases, data is saved if persistence is configured like SHUTDOWN
+func (s *Server) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db == nil {
+		return ErrNotStarted
+	}
+	if s.listener != nil {
+		// the handler closes db after listener closed
+		close(s.closeChan)
+		<-s.served
+		s.listener = nil
+	} else if shutdowner, ok := s.db.(idatabase.Shutdowner); ok {
+		shutdowner.Shutdown()
+	} else {
+		s.db.Close()
+	}
+	s.db = nil
+	s.server = nil
+	return nil
+}
+
+// SetHooks replaces hooks of the running server, nil removes all hooks
+func (s *Server) SetHooks(hooks *database.Hooks) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.server == nil {
+		return ErrNotStarted
+	}
+	s.server.SetHooks(hooks)
+	return nil
+}
+
+func (s *Server) getDB() idatabase.DB {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db
+}
+
+// Exec executes a command on the given database directly, bypassing network, protocol and authentication.
+// Error replies such as WRONGTYPE are returned as replies, error is returned only if the server is not running
+// or ctx is done before the command finished, such as a blocking command timed out by ctx
+func (s *Server) Exec(ctx context.Context, dbIndex int, cmdLine [][]byte) (redis.Reply, error) {
+	db := s.getDB()
+	if db == nil {
+		return nil, ErrNotStarted
+	}
+	if len(cmdLine) == 0 {
+		return nil, errors.New("empty command")
+	}
+	conn := connection.NewFakeConn()
+	conn.SelectDB(dbIndex)
+	conn.SetPassword(config.Properties.RequirePass) // in process callers are trusted
+	result := make(chan redis.Reply, 1)
+	go func() {
+		result <- db.Exec(conn, cmdLine)
+	}()
+	select {
+	case reply := <-result:
+		db.AfterClientClose(conn)
+		return reply, nil
+	case <-ctx.Done():
+		// unblocks blocking commands of the connection
+		db.AfterClientClose(conn)
+		return nil, ctx.Err()
+	}
+}
+
+// Do is like Exec with command line in strings, such as Do(ctx, 0, "SET", "key", "value")
+func (s *Server) Do(ctx context.Context, dbIndex int, args ...string) (redis.Reply, error) {
+	return s.Exec(ctx, dbIndex, utils.ToCmdLine(args...))
+}
@@ -0,0 +1,81 @@
+package embedded
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func TestExec(t *testing.T) {
+	server := NewServer(&Options{
+		Properties: &config.ServerProperties{},
+	})
+	ctx := context.Background()
+	if _, err := server.Do(ctx, 0, "PING"); err != ErrNotStarted {
+		t.Errorf("expect not started, actual %v", err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != ErrStarted {
+		t.Errorf("expect started, actual %v", err)
+	}
+	reply, err := server.Do(ctx, 1, "SET", "a", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.AssertStatusReply(t, reply, "OK")
+	reply, _ = server.Do(ctx, 1, "GET", "a")
+	asserts.AssertBulkReply(t, reply, "1")
+	reply, _ = server.Do(ctx, 0, "GET", "a")
+	asserts.AssertNullBulk(t, reply)
+	reply, _ = server.Do(ctx, 1, "LPUSH", "a", "1")
+	asserts.AssertErrReply(t, reply, "WRONGTYPE Operation against a key holding the wrong kind of value")
+
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err = server.Do(timeout, 0, "BLPOP", "list", "0"); err != context.DeadlineExceeded {
+		t.Errorf("expect deadline exceeded, actual %v", err)
+	}
+
+	if err = server.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = server.Do(ctx, 0, "PING"); err != ErrNotStarted {
+		t.Errorf("expect not started, actual %v", err)
+	}
+}
+
+func TestListen(t *testing.T) {
+	server := NewServer(&Options{
+		Properties: &config.ServerProperties{},
+		Addr:       "127.0.0.1:0",
+	})
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = server.Do(context.Background(), 0, "SET", "a", "hello")
+	conn, err := net.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = conn.Write([]byte("*2\r\n$3\r\nGET\r\n$1\r\na\r\n"))
+	reader := bufio.NewReader(conn)
+	header, _ := reader.ReadString('\n')
+	value, _ := reader.ReadString('\n')
+	if header != "$5\r\n" || value != "hello\r\n" {
+		t.Errorf("unexpected reply %q %q", header, value)
+	}
+	_ = conn.Close()
+	if err = server.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if server.Addr() != "" {
+		t.Error("server should stop listening")
+	}
+}
@@ -1,9 +1,12 @@
 package database
 
 import (
+	"math/rand"
+	"sync/atomic"
 	"time"
 
 	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/clock"
 	"github.com/hdt3213/rdb/core"
 )
 
@@ -17,6 +20,20 @@ type DB interface {
 	Close()
 }
 
+// Shutdowner is implemented by DB supporting SHUTDOWN command
+type Shutdowner interface {
+	// Shutdown saves data if needed and closes DB, the network server calls it when stopped by signals or SHUTDOWN
+	Shutdown()
+	// ShutdownChan is closed after SHUTDOWN succeeded, the network server should stop then
+	ShutdownChan() <-chan struct{}
+}
+
+// FunctionDumper is implemented by DB supporting FUNCTION LOAD, libraries are persisted along with dataset
+type FunctionDumper interface {
+	// DumpFunctions returns code of all libraries which could be loaded by FUNCTION LOAD
+	DumpFunctions() []string
+}
+
 // KeyEventCallback will be called back on key event, such as key inserted or deleted
 // may be called concurrently
 type KeyEventCallback func(dbIndex int, key string, entity *DataEntity)
@@ -41,4 +58,112 @@ type DBEngine interface {
 // DataEntity stores data bound to a key, including a string, list, hash, set and so on
 type DataEntity struct {
 	Data interface{}
+	// lru is a 24-bit clock (in seconds) of last access, it wraps around every 194 days like redis.
+	// In lfu mode, the high 16 bits are last decrement time in minutes and the low 8 bits are a logarithmic counter
+	lru uint32
+}
+
+const (
+	lruClockMax        = 1<<24 - 1
+	lruClockResolution = time.Second
+	// LFUInitVal is the counter of new keys, so that they have a chance to accumulate hits before being evicted
+	LFUInitVal = 5
+)
+
+// LRUClock returns current 24-bit lru clock
+func LRUClock() uint32 {
+	return uint32(clock.Now().UnixNano()/int64(lruClockResolution)) & lruClockMax
+}
+
+// Touch updates last access time of entity, it may be called concurrently by readers
+func (entity *DataEntity) Touch() {
+	atomic.StoreUint32(&entity.lru, LRUClock())
+}
+
+// IdleTime returns time elapsed since last access of entity
+func (entity *DataEntity) IdleTime() time.Duration {
+	now := LRUClock()
+	lru := atomic.LoadUint32(&entity.lru)
+	var idle uint32
+	if now >= lru {
+		idle = now - lru
+	} else {
+		// clock wrapped around
+		idle = lruClockMax - lru + now
+	}
+	return time.Duration(idle) * lruClockResolution
+}
+
+// lfuTimeInMinutes returns current 16-bit clock in minutes
+func lfuTimeInMinutes() uint32 {
+	return uint32(clock.Now().Unix()/60) & 0xffff
+}
+
+// lfuTimeElapsed returns minutes elapsed since ldt, handles wraparound
+func lfuTimeElapsed(ldt uint32) uint32 {
+	now := lfuTimeInMinutes()
+	if now >= ldt {
+		return now - ldt
+	}
+	return 65535 - ldt + now
+}
+
+// InitLFU resets access frequency of a new entity
+func (entity *DataEntity) InitLFU() {
+	atomic.StoreUint32(&entity.lru, lfuTimeInMinutes()<<8|LFUInitVal)
+}
+
+// LFUCounter returns access frequency counter decayed by elapsed periods (in minutes) without updating entity
+func (entity *DataEntity) LFUCounter(decayTime int) uint8 {
+	lru := atomic.LoadUint32(&entity.lru)
+	ldt := lru >> 8
+	counter := lru & 0xff
+	if decayTime > 0 {
+		periods := lfuTimeElapsed(ldt) / uint32(decayTime)
+		if periods > counter {
+			counter = 0
+		} else {
+			counter -= periods
+		}
+	}
+	return uint8(counter)
+}
+
+// TouchLFU decays and then increases access frequency counter logarithmically,
+// the greater logFactor is, the more accesses are needed to saturate the counter
+func (entity *DataEntity) TouchLFU(logFactor int, decayTime int) {
+	counter := entity.LFUCounter(decayTime)
+	if counter < 255 {
+		base := float64(counter) - LFUInitVal
+		if base < 0 {
+			base = 0
+		}
+		if rand.Float64() < 1/(base*float64(logFactor)+1) {
+			counter++
+		}
+	}
+	atomic.StoreUint32(&entity.lru, lfuTimeInMinutes()<<8|uint32(counter))
+}
+
+// InheritAccess copies access metadata of old entity which is being overwritten by entity
+func (entity *DataEntity) InheritAccess(old *DataEntity) {
+	if entity != old {
+		atomic.StoreUint32(&entity.lru, atomic.LoadUint32(&old.lru))
+	}
+}
+
+// SetIdleTime pretends the entity was last accessed idle ago
+func (entity *DataEntity) SetIdleTime(idle time.Duration) {
+	ticks := uint32(int64(idle/lruClockResolution) % (lruClockMax + 1))
+	now := LRUClock()
+	if now >= ticks {
+		atomic.StoreUint32(&entity.lru, now-ticks)
+	} else {
+		atomic.StoreUint32(&entity.lru, lruClockMax-ticks+now)
+	}
+}
+
+// SetLFUCounter overwrites access frequency counter of entity
+func (entity *DataEntity) SetLFUCounter(counter uint8) {
+	atomic.StoreUint32(&entity.lru, lfuTimeInMinutes()<<8|uint32(counter))
 }
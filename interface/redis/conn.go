@@ -1,5 +1,10 @@
 package redis
 
+import (
+	"context"
+	"time"
+)
+
 // Connection represents a connection with redis client
 type Connection interface {
 	Write([]byte) (int, error)
@@ -12,8 +17,16 @@ type Connection interface {
 	// client should keep its subscribing channels
 	Subscribe(channel string)
 	UnSubscribe(channel string)
+	PSubscribe(pattern string)
+	PUnSubscribe(pattern string)
+	// SubsCount returns the number of subscribing channels and patterns
 	SubsCount() int
 	GetChannels() []string
+	GetPatterns() []string
+	// shard channels are counted separately
+	SSubscribe(channel string)
+	SUnSubscribe(channel string)
+	GetShardChannels() []string
 
 	InMultiState() bool
 	SetMultiState(bool)
@@ -33,5 +46,30 @@ type Connection interface {
 	SetMaster()
 	IsMaster() bool
 
+	// SetReadOnly and IsReadOnly are used by READONLY and READWRITE in cluster mode
+	SetReadOnly(bool)
+	IsReadOnly() bool
+	// SetAsking and IsAsking are used by ASKING in cluster mode
+	SetAsking(bool)
+	IsAsking() bool
+	// SetContext and Context carry the span of executing command when tracing is enabled,
+	// so that it is propagated to requests relayed to other nodes
+	SetContext(ctx context.Context)
+	Context() context.Context
+
 	Name() string
+	// ID returns the unique id of connection, see CLIENT ID
+	ID() uint64
+
+	// LocalAddr returns the server address which client connected to
+	LocalAddr() string
+	// SetName and GetName are used by CLIENT SETNAME and CLIENT GETNAME
+	SetName(string)
+	GetName() string
+	// SetLastCmd and GetLastCmd record the latest command and its time, see CLIENT LIST
+	SetLastCmd(string)
+	GetLastCmd() (string, time.Time)
+	CreatedAt() time.Time
+	// Kill disconnects the client, see CLIENT KILL
+	Kill(afterReply bool)
 }
@@ -0,0 +1,97 @@
+package clock
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Clock provides current time and timers, time-based code gets time from it so that tests could replace it
+// with a fake clock instead of sleeping
+type Clock interface {
+	Now() time.Time
+	// After returns a channel which receives current time after duration d
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks at intervals like time.Ticker
+type Ticker interface {
+	Chan() <-chan time.Time
+	Stop()
+}
+
+type realClock struct{}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) Chan() <-chan time.Time {
+	return t.C
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+// Real is the clock backed by package time
+var Real Clock = realClock{}
+
+type holder struct {
+	clock Clock
+}
+
+var current atomic.Value
+
+func init() {
+	current.Store(holder{Real})
+}
+
+// Default returns the clock used by package level functions
+func Default() Clock {
+	return current.Load().(holder).clock
+}
+
+// Set replaces the default clock, it returns a function to restore the previous one.
+// A fake clock should start from the current time, since jobs of a time wheel following the default clock
+// wait for the clock to reach their deadlines
+func Set(c Clock) (restore func()) {
+	prev := Default()
+	current.Store(holder{c})
+	return func() {
+		current.Store(holder{prev})
+	}
+}
+
+// Now returns current time of the default clock
+func Now() time.Time {
+	return Default().Now()
+}
+
+// Since returns the time elapsed since t according to the default clock
+func Since(t time.Time) time.Duration {
+	return Now().Sub(t)
+}
+
+// Until returns the duration until t according to the default clock
+func Until(t time.Time) time.Duration {
+	return t.Sub(Now())
+}
+
+// After waits for the duration to elapse on the default clock
+func After(d time.Duration) <-chan time.Time {
+	return Default().After(d)
+}
+
+// NewTicker returns a ticker of the default clock
+func NewTicker(d time.Duration) Ticker {
+	return Default().NewTicker(d)
+}
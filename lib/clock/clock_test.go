@@ -0,0 +1,73 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Now()
+	fake := NewFake(start)
+	after := fake.After(time.Second)
+	ticker := fake.NewTicker(time.Second)
+
+	fake.Advance(500 * time.Millisecond)
+	if !fake.Now().Equal(start.Add(500 * time.Millisecond)) {
+		t.Error("wrong time after advancing")
+	}
+	select {
+	case <-after:
+		t.Error("timer fired too early")
+	case <-ticker.Chan():
+		t.Error("ticker fired too early")
+	default:
+	}
+
+	fake.Advance(500 * time.Millisecond)
+	select {
+	case now := <-after:
+		if !now.Equal(start.Add(time.Second)) {
+			t.Error("wrong time received from timer")
+		}
+	default:
+		t.Error("timer should fire")
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-ticker.Chan():
+		default:
+			t.Errorf("ticker should fire %d", i)
+		}
+		fake.Advance(time.Second)
+	}
+
+	ticker.Stop()
+	<-ticker.Chan() // drop the tick sent before stopping
+	fake.Advance(time.Second)
+	select {
+	case <-ticker.Chan():
+		t.Error("stopped ticker should not fire")
+	default:
+	}
+
+	fake.SetTime(start)
+	if !fake.Now().Equal(start.Add(5 * time.Second)) {
+		t.Error("fake clock should not go back")
+	}
+}
+
+func TestSet(t *testing.T) {
+	fake := NewFake(time.Unix(1000, 0))
+	restore := Set(fake)
+	if !Now().Equal(time.Unix(1000, 0)) {
+		t.Error("default clock should be replaced")
+	}
+	fake.Advance(time.Minute)
+	if Since(time.Unix(1000, 0)) != time.Minute || Until(time.Unix(1000, 0)) != -time.Minute {
+		t.Error("wrong duration from default clock")
+	}
+	restore()
+	if Default() != Real {
+		t.Error("default clock should be restored")
+	}
+}
@@ -0,0 +1,119 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a clock which only moves when Advance or SetTime is called
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+type fakeWaiter struct {
+	at       time.Time
+	interval time.Duration // 0 for one-shot waiters created by After
+	ch       chan time.Time
+	stopped  bool
+}
+
+type fakeTicker struct {
+	clock  *Fake
+	waiter *fakeWaiter
+}
+
+// NewFake creates a fake clock starting at now
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := &fakeWaiter{
+		at: f.now.Add(d),
+		ch: make(chan time.Time, 1),
+	}
+	if d <= 0 {
+		w.ch <- f.now
+		return w.ch
+	}
+	f.waiters = append(f.waiters, w)
+	return w.ch
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := &fakeWaiter{
+		at:       f.now.Add(d),
+		interval: d,
+		ch:       make(chan time.Time, 1),
+	}
+	f.waiters = append(f.waiters, w)
+	return &fakeTicker{clock: f, waiter: w}
+}
+
+// Advance moves the clock by d and fires timers and tickers reached, a negative d moves the clock back.
+// Like time.Ticker, a ticker drops ticks if its receiver falls behind
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.setTime(f.now.Add(d))
+}
+
+// SetTime moves the clock to t, the clock never goes back
+func (f *Fake) SetTime(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if t.After(f.now) {
+		f.setTime(t)
+	}
+}
+
+func (f *Fake) setTime(t time.Time) {
+	f.now = t
+	remains := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.stopped {
+			continue
+		}
+		if w.at.After(t) {
+			remains = append(remains, w)
+			continue
+		}
+		select {
+		case w.ch <- t:
+		default:
+		}
+		if w.interval == 0 {
+			continue
+		}
+		for !w.at.After(t) {
+			w.at = w.at.Add(w.interval)
+		}
+		remains = append(remains, w)
+	}
+	f.waiters = remains
+}
+
+func (t *fakeTicker) Chan() <-chan time.Time {
+	return t.waiter.ch
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.waiter.stopped = true
+}
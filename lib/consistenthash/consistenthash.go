@@ -16,6 +16,7 @@ type Map struct {
 	replicas int
 	keys     []int // sorted
 	hashMap  map[int]string
+	weights  map[string]int // node -> weight, a node has replicas*weight virtual nodes
 }
 
 // New creates a new Map
@@ -24,6 +25,7 @@ func New(replicas int, fn HashFunc) *Map {
 		replicas: replicas,
 		hashFunc: fn,
 		hashMap:  make(map[int]string),
+		weights:  make(map[string]int),
 	}
 	if m.hashFunc == nil {
 		m.hashFunc = crc32.ChecksumIEEE
@@ -39,15 +41,55 @@ func (m *Map) IsEmpty() bool {
 // AddNode add the given nodes into consistent hash circle
 func (m *Map) AddNode(keys ...string) {
 	for _, key := range keys {
-		if key == "" {
+		m.addNode(key, 1)
+	}
+	sort.Ints(m.keys)
+}
+
+// AddWeightedNode adds a node which takes keys in proportion to its weight,
+// nodes added by AddNode have weight 1
+func (m *Map) AddWeightedNode(key string, weight int) {
+	m.addNode(key, weight)
+	sort.Ints(m.keys)
+}
+
+func (m *Map) addNode(key string, weight int) {
+	if key == "" || weight <= 0 {
+		return
+	}
+	for i := 0; i < m.replicas*weight; i++ {
+		hash := int(m.hashFunc([]byte(strconv.Itoa(i) + key)))
+		m.keys = append(m.keys, hash)
+		m.hashMap[hash] = key
+	}
+	m.weights[key] = weight
+}
+
+// RemoveNode removes the given nodes and their virtual nodes from consistent hash circle
+func (m *Map) RemoveNode(keys ...string) {
+	removed := false
+	for _, key := range keys {
+		weight, ok := m.weights[key]
+		if !ok {
 			continue
 		}
-		for i := 0; i < m.replicas; i++ {
+		delete(m.weights, key)
+		for i := 0; i < m.replicas*weight; i++ {
 			hash := int(m.hashFunc([]byte(strconv.Itoa(i) + key)))
-			m.keys = append(m.keys, hash)
-			m.hashMap[hash] = key
+			// the virtual node may be taken by another node if hash collided
+			if m.hashMap[hash] == key {
+				delete(m.hashMap, hash)
+				removed = true
+			}
 		}
 	}
+	if !removed {
+		return
+	}
+	m.keys = make([]int, 0, len(m.hashMap))
+	for hash := range m.hashMap {
+		m.keys = append(m.keys, hash)
+	}
 	sort.Ints(m.keys)
 }
 
@@ -83,3 +125,19 @@ func (m *Map) PickNode(key string) string {
 
 	return m.hashMap[m.keys[idx]]
 }
+
+// GetDistribution returns the proportion of hash space taken by each node, the proportions sum to 1
+func (m *Map) GetDistribution() map[string]float64 {
+	result := make(map[string]float64)
+	if m.IsEmpty() {
+		return result
+	}
+	const hashSpace = float64(1 << 32)
+	// the virtual node takes hashes from its predecessor (exclusive) to itself (inclusive)
+	prev := int64(m.keys[len(m.keys)-1]) - 1<<32
+	for _, hash := range m.keys {
+		result[m.hashMap[hash]] += float64(int64(hash)-prev) / hashSpace
+		prev = int64(hash)
+	}
+	return result
+}
@@ -15,3 +15,47 @@ func TestHash(t *testing.T) {
 		t.Error("wrong answer")
 	}
 }
+
+func TestRemoveNode(t *testing.T) {
+	m := New(3, nil)
+	m.AddNode("a", "b", "c", "d")
+	m.RemoveNode("b", "x")
+	for _, key := range []string{"zxc", "123{abc}", "abc", "foo", "bar"} {
+		if node := m.PickNode(key); node == "b" || node == "" {
+			t.Errorf("key %s is routed to %s", key, node)
+		}
+	}
+	expected := New(3, nil)
+	expected.AddNode("a", "c", "d")
+	for _, key := range []string{"zxc", "123{abc}", "abc", "foo", "bar"} {
+		if m.PickNode(key) != expected.PickNode(key) {
+			t.Errorf("key %s: expect %s, actual %s", key, expected.PickNode(key), m.PickNode(key))
+		}
+	}
+	m.RemoveNode("a", "c", "d")
+	if !m.IsEmpty() || m.PickNode("zxc") != "" {
+		t.Error("expect empty map")
+	}
+}
+
+func TestWeightedNode(t *testing.T) {
+	m := New(100, nil)
+	m.AddNode("a")
+	m.AddWeightedNode("b", 3)
+	dist := m.GetDistribution()
+	if len(dist) != 2 {
+		t.Errorf("expect 2 nodes, actual %v", dist)
+	}
+	if d := dist["a"] + dist["b"]; d < 0.999 || d > 1.001 {
+		t.Errorf("expect sum of distribution is 1, actual %f", d)
+	}
+	if dist["b"] < 0.65 || dist["b"] > 0.85 {
+		t.Errorf("expect b takes about 75%% keys, actual %f", dist["b"])
+	}
+
+	m.RemoveNode("b")
+	dist = m.GetDistribution()
+	if len(dist) != 1 || dist["a"] < 0.999 {
+		t.Errorf("expect a takes all keys, actual %v", dist)
+	}
+}
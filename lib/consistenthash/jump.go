@@ -0,0 +1,70 @@
+package consistenthash
+
+import "hash/crc64"
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// Jump picks node by jump consistent hash (https://arxiv.org/abs/1406.2294),
+// which distributes keys evenly without virtual nodes.
+// Nodes are numbered by the order of adding, only keys of the last node are moved while it is removed,
+// removing other nodes moves more keys.
+type Jump struct {
+	nodes []string
+}
+
+// NewJump creates a Jump picker
+func NewJump() *Jump {
+	return &Jump{}
+}
+
+// IsEmpty returns if there is no node
+func (j *Jump) IsEmpty() bool {
+	return len(j.nodes) == 0
+}
+
+// AddNode appends the given nodes
+func (j *Jump) AddNode(keys ...string) {
+	for _, key := range keys {
+		if key == "" || j.indexOf(key) >= 0 {
+			continue
+		}
+		j.nodes = append(j.nodes, key)
+	}
+}
+
+// RemoveNode removes the given nodes
+func (j *Jump) RemoveNode(keys ...string) {
+	for _, key := range keys {
+		if i := j.indexOf(key); i >= 0 {
+			j.nodes = append(j.nodes[:i], j.nodes[i+1:]...)
+		}
+	}
+}
+
+func (j *Jump) indexOf(node string) int {
+	for i, n := range j.nodes {
+		if n == node {
+			return i
+		}
+	}
+	return -1
+}
+
+// PickNode returns the bucket of key computed by jump hash
+func (j *Jump) PickNode(key string) string {
+	if j.IsEmpty() {
+		return ""
+	}
+	hash := crc64.Checksum([]byte(getPartitionKey(key)), crc64Table)
+	return j.nodes[jumpHash(hash, len(j.nodes))]
+}
+
+func jumpHash(key uint64, buckets int) int {
+	var b, i int64 = -1, 0
+	for i < int64(buckets) {
+		b = i
+		key = key*2862933555777941757 + 1
+		i = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,32 @@
+package consistenthash
+
+import "errors"
+
+// PeerPicker picks the node responsible for a key, keys with the same hashtag are picked to the same node
+type PeerPicker interface {
+	IsEmpty() bool
+	AddNode(keys ...string)
+	RemoveNode(keys ...string)
+	PickNode(key string) string
+}
+
+// Supported strategies of NewPeerPicker
+const (
+	StrategyConsistentHash = "consistent"
+	StrategyRendezvous     = "rendezvous"
+	StrategyJump           = "jump"
+)
+
+// NewPeerPicker creates a PeerPicker of the given strategy, replicas is only used by consistent hash.
+// Empty strategy means consistent hash.
+func NewPeerPicker(strategy string, replicas int) (PeerPicker, error) {
+	switch strategy {
+	case "", StrategyConsistentHash:
+		return New(replicas, nil), nil
+	case StrategyRendezvous:
+		return NewRendezvous(nil), nil
+	case StrategyJump:
+		return NewJump(), nil
+	}
+	return nil, errors.New("unknown peer picker strategy: " + strategy)
+}
@@ -0,0 +1,49 @@
+package consistenthash
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestPeerPicker(t *testing.T) {
+	nodes := []string{"a", "b", "c", "d"}
+	for _, strategy := range []string{StrategyConsistentHash, StrategyRendezvous, StrategyJump} {
+		picker, err := NewPeerPicker(strategy, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if picker.PickNode("foo") != "" {
+			t.Errorf("%s: expect empty node", strategy)
+		}
+		picker.AddNode(nodes...)
+		counts := make(map[string]int)
+		picked := make(map[string]string)
+		for i := 0; i < 10000; i++ {
+			key := strconv.Itoa(i)
+			node := picker.PickNode(key)
+			counts[node]++
+			picked[key] = node
+		}
+		for _, node := range nodes {
+			if counts[node] < 1500 || counts[node] > 3500 {
+				t.Errorf("%s: unbalanced distribution %v", strategy, counts)
+			}
+		}
+		if picker.PickNode("{1}a") != picker.PickNode("1") {
+			t.Errorf("%s: hashtag is not supported", strategy)
+		}
+
+		// only keys of removed node are moved
+		picker.RemoveNode("d")
+		for key, node := range picked {
+			current := picker.PickNode(key)
+			if current == "d" || (node != "d" && current != node) {
+				t.Errorf("%s: key %s is moved from %s to %s", strategy, key, node, current)
+				break
+			}
+		}
+	}
+	if _, err := NewPeerPicker("nosuch", 1); err == nil {
+		t.Error("expect error for unknown strategy")
+	}
+}
@@ -0,0 +1,80 @@
+package consistenthash
+
+import "hash/crc32"
+
+// Rendezvous picks node by highest random weight (HRW) hashing.
+// Only keys of the removed node are moved when a node is removed, and no virtual node is needed.
+// It takes O(n) time to pick a node, so it fits for small clusters.
+type Rendezvous struct {
+	hashFunc HashFunc
+	nodes    []string
+}
+
+// NewRendezvous creates a Rendezvous picker, crc32 is used if fn is nil
+func NewRendezvous(fn HashFunc) *Rendezvous {
+	if fn == nil {
+		fn = crc32.ChecksumIEEE
+	}
+	return &Rendezvous{
+		hashFunc: fn,
+	}
+}
+
+// IsEmpty returns if there is no node
+func (r *Rendezvous) IsEmpty() bool {
+	return len(r.nodes) == 0
+}
+
+// AddNode adds the given nodes
+func (r *Rendezvous) AddNode(keys ...string) {
+	for _, key := range keys {
+		if key == "" || r.indexOf(key) >= 0 {
+			continue
+		}
+		r.nodes = append(r.nodes, key)
+	}
+}
+
+// RemoveNode removes the given nodes
+func (r *Rendezvous) RemoveNode(keys ...string) {
+	for _, key := range keys {
+		if i := r.indexOf(key); i >= 0 {
+			r.nodes = append(r.nodes[:i], r.nodes[i+1:]...)
+		}
+	}
+}
+
+func (r *Rendezvous) indexOf(node string) int {
+	for i, n := range r.nodes {
+		if n == node {
+			return i
+		}
+	}
+	return -1
+}
+
+// PickNode returns the node with highest weight of hash(node, key)
+func (r *Rendezvous) PickNode(key string) string {
+	keyHash := uint64(r.hashFunc([]byte(getPartitionKey(key))))
+	var picked string
+	var maxWeight uint64
+	for _, node := range r.nodes {
+		weight := mix64(uint64(r.hashFunc([]byte(node)))<<32 | keyHash)
+		if picked == "" || weight > maxWeight || (weight == maxWeight && node < picked) {
+			picked = node
+			maxWeight = weight
+		}
+	}
+	return picked
+}
+
+// mix64 is the finalizer of splitmix64, hash functions like crc32 are linear and
+// do not mix node and key well by themselves
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,16 @@
+package crc16
+
+import "testing"
+
+func TestChecksum(t *testing.T) {
+	// test vectors from redis cluster specification
+	if sum := Checksum([]byte("123456789")); sum != 0x31C3 {
+		t.Errorf("expect 0x31C3, actual %X", sum)
+	}
+	if sum := Checksum(nil); sum != 0 {
+		t.Errorf("expect 0, actual %X", sum)
+	}
+	if slot := Checksum([]byte("foo")) % 16384; slot != 12182 {
+		t.Errorf("expect slot 12182, actual %d", slot)
+	}
+}
@@ -0,0 +1,59 @@
+// Package debughttp serves net/http/pprof and expvar, so that profiles of a running server could be captured by
+// commands like `go tool pprof http://<addr>/debug/pprof/heap`
+package debughttp
+
+import (
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/hdt3213/godis/lib/logger"
+)
+
+// Server serves /debug/pprof/ and /debug/vars
+type Server struct {
+	listener net.Listener
+	srv      *http.Server
+}
+
+// Start listens on addr and serves in background
+func Start(addr string) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	s := &Server{
+		listener: listener,
+		srv: &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}
+	go func() {
+		err := s.srv.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			logger.Error("debug http server stopped: " + err.Error())
+		}
+	}()
+	logger.Info("debug http server listening on " + s.Addr())
+	return s, nil
+}
+
+// Addr returns the listening address, such as the random port chosen for "127.0.0.1:0"
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops listening and closes connections, profiles being captured are interrupted
+func (s *Server) Close() error {
+	return s.srv.Close()
+}
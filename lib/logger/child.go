@@ -0,0 +1,255 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Hook receives messages output by a logger, such as mirroring errors into metrics.
+// Hooks are called synchronously by the goroutine logging the message, so they should return quickly and never log.
+type Hook func(level LogLevel, msg string)
+
+// hookSet is a list of hooks which could be added at runtime
+type hookSet struct {
+	mu    sync.RWMutex
+	hooks []Hook
+}
+
+func (hs *hookSet) add(hook Hook) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.hooks = append(hs.hooks, hook)
+}
+
+// fire calls hooks with msg followed by key/value fields, the message is formatted only if there is any hook
+func (hs *hookSet) fire(level LogLevel, msg string, keysAndValues []interface{}) {
+	hs.mu.RLock()
+	hooks := hs.hooks
+	hs.mu.RUnlock()
+	if len(hooks) == 0 {
+		return
+	}
+	msg = strings.TrimSuffix(textMessage(msg, keysAndValues), "\n")
+	for _, hook := range hooks {
+		hook(level, msg)
+	}
+}
+
+// AddHook registers a hook receiving messages of the logger and its child loggers
+func (logger *Logger) AddHook(hook Hook) {
+	logger.hooks.add(hook)
+}
+
+// Named returns a child logger whose messages are tagged with the component name, such as Named("aof")
+func (logger *Logger) Named(name string) *Child {
+	return &Child{root: logger, name: name}
+}
+
+// WithFields returns a child logger which appends key/value fields to every message
+func (logger *Logger) WithFields(keysAndValues ...interface{}) *Child {
+	return &Child{root: logger, fields: keysAndValues}
+}
+
+// Child is a logger of a component, it writes messages through its root logger with component name and fields.
+// Hooks added to a child receive messages of the child and its descendants only.
+type Child struct {
+	// root is the logger to write, nil means DefaultLogger at the time of logging,
+	// so that children created during initialization follow the logger set up later
+	root   ILogger
+	parent *Child
+	name   string
+	fields []interface{}
+	hooks  hookSet
+}
+
+// Named returns a child logger of the component, such as Named("replication") for DefaultLogger
+func Named(name string) *Child {
+	return &Child{name: name}
+}
+
+// WithFields returns a child logger of DefaultLogger which appends key/value fields to every message
+func WithFields(keysAndValues ...interface{}) *Child {
+	return &Child{fields: keysAndValues}
+}
+
+// AddHook registers a hook for messages of DefaultLogger and its child loggers
+func AddHook(hook Hook) {
+	if l, ok := DefaultLogger.(*Logger); ok {
+		l.AddHook(hook)
+	}
+}
+
+// Named returns a descendant whose component name is joined by dot, such as "replication.slave"
+func (c *Child) Named(name string) *Child {
+	if c.name != "" {
+		name = c.name + "." + name
+	}
+	return &Child{root: c.root, parent: c, name: name, fields: c.fields}
+}
+
+// WithFields returns a descendant which appends more key/value fields to every message
+func (c *Child) WithFields(keysAndValues ...interface{}) *Child {
+	fields := make([]interface{}, 0, len(c.fields)+len(keysAndValues))
+	fields = append(fields, c.fields...)
+	fields = append(fields, keysAndValues...)
+	return &Child{root: c.root, parent: c, name: c.name, fields: fields}
+}
+
+// AddHook registers a hook receiving messages of the child logger and its descendants
+func (c *Child) AddHook(hook Hook) {
+	c.hooks.add(hook)
+}
+
+func (c *Child) getRoot() ILogger {
+	if c.root != nil {
+		return c.root
+	}
+	return DefaultLogger
+}
+
+// Enabled returns whether messages of the given level would be output by the root logger
+func (c *Child) Enabled(level LogLevel) bool {
+	if l, ok := c.getRoot().(leveledLogger); ok {
+		return l.Enabled(level)
+	}
+	return true
+}
+
+// Output sends a msg to the root logger, so that a child could be used as an ILogger
+func (c *Child) Output(level LogLevel, callerDepth int, msg string) {
+	c.output(level, callerDepth+1, msg, nil)
+}
+
+// OutputFields sends a msg with key/value fields to the root logger
+func (c *Child) OutputFields(level LogLevel, callerDepth int, msg string, keysAndValues []interface{}) {
+	c.output(level, callerDepth+1, msg, keysAndValues)
+}
+
+func (c *Child) output(level LogLevel, callerDepth int, msg string, keysAndValues []interface{}) {
+	root := c.getRoot()
+	if l, ok := root.(leveledLogger); ok && !l.Enabled(level) {
+		return
+	}
+	fields := c.fields
+	if len(keysAndValues) > 0 {
+		fields = make([]interface{}, 0, len(c.fields)+len(keysAndValues))
+		fields = append(fields, c.fields...)
+		fields = append(fields, keysAndValues...)
+	}
+	for node := c; node != nil; node = node.parent {
+		node.hooks.fire(level, msg, fields)
+	}
+	switch l := root.(type) {
+	case *Logger:
+		l.output(level, callerDepth+1, c.name, msg, fields)
+	case fieldLogger:
+		l.OutputFields(level, callerDepth+1, c.prefix()+msg, fields)
+	default:
+		root.Output(level, callerDepth+1, c.prefix()+textMessage(msg, fields))
+	}
+}
+
+// prefix tags messages with component name for loggers unaware of child loggers
+func (c *Child) prefix() string {
+	if c.name == "" {
+		return ""
+	}
+	return "[" + c.name + "] "
+}
+
+// Debug logs debug message
+func (c *Child) Debug(v ...interface{}) {
+	if !c.Enabled(DEBUG) {
+		return
+	}
+	c.output(DEBUG, defaultCallerDepth, fmt.Sprintln(v...), nil)
+}
+
+// Debugf logs debug message
+func (c *Child) Debugf(format string, v ...interface{}) {
+	if !c.Enabled(DEBUG) {
+		return
+	}
+	c.output(DEBUG, defaultCallerDepth, fmt.Sprintf(format, v...), nil)
+}
+
+// Info logs message
+func (c *Child) Info(v ...interface{}) {
+	if !c.Enabled(INFO) {
+		return
+	}
+	c.output(INFO, defaultCallerDepth, fmt.Sprintln(v...), nil)
+}
+
+// Infof logs message
+func (c *Child) Infof(format string, v ...interface{}) {
+	if !c.Enabled(INFO) {
+		return
+	}
+	c.output(INFO, defaultCallerDepth, fmt.Sprintf(format, v...), nil)
+}
+
+// Warn logs warning message
+func (c *Child) Warn(v ...interface{}) {
+	if !c.Enabled(WARNING) {
+		return
+	}
+	c.output(WARNING, defaultCallerDepth, fmt.Sprintln(v...), nil)
+}
+
+// Warnf logs warning message
+func (c *Child) Warnf(format string, v ...interface{}) {
+	if !c.Enabled(WARNING) {
+		return
+	}
+	c.output(WARNING, defaultCallerDepth, fmt.Sprintf(format, v...), nil)
+}
+
+// Error logs error message
+func (c *Child) Error(v ...interface{}) {
+	if !c.Enabled(ERROR) {
+		return
+	}
+	c.output(ERROR, defaultCallerDepth, fmt.Sprintln(v...), nil)
+}
+
+// Errorf logs error message
+func (c *Child) Errorf(format string, v ...interface{}) {
+	if !c.Enabled(ERROR) {
+		return
+	}
+	c.output(ERROR, defaultCallerDepth, fmt.Sprintf(format, v...), nil)
+}
+
+// Debugw logs debug message with key/value fields
+func (c *Child) Debugw(msg string, keysAndValues ...interface{}) {
+	if !c.Enabled(DEBUG) {
+		return
+	}
+	c.output(DEBUG, defaultCallerDepth, msg, keysAndValues)
+}
+
+// Infow logs message with key/value fields
+func (c *Child) Infow(msg string, keysAndValues ...interface{}) {
+	if !c.Enabled(INFO) {
+		return
+	}
+	c.output(INFO, defaultCallerDepth, msg, keysAndValues)
+}
+
+// Warnw logs warning message with key/value fields
+func (c *Child) Warnw(msg string, keysAndValues ...interface{}) {
+	if !c.Enabled(WARNING) {
+		return
+	}
+	c.output(WARNING, defaultCallerDepth, msg, keysAndValues)
+}
+
+// Errorw logs error message with key/value fields
+func (c *Child) Errorw(msg string, keysAndValues ...interface{}) {
+	if !c.Enabled(ERROR) {
+		return
+	}
+	c.output(ERROR, defaultCallerDepth, msg, keysAndValues)
+}
@@ -0,0 +1,77 @@
+package logger
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChildLogger(t *testing.T) {
+	l := makeTestLogger()
+	aof := l.Named("aof").WithFields("file", "appendonly.aof")
+	aof.Infow("loaded", "keys", 10)
+	e := <-l.entryChan
+	if e.name != "aof" {
+		t.Errorf("expect name aof, actual %s", e.name)
+	}
+	if !strings.HasPrefix(e.caller, "child_test.go:") {
+		t.Errorf("wrong caller: %s", e.caller)
+	}
+	if text := formatText(e); !strings.HasSuffix(text, "[aof]["+e.caller+"] loaded file=appendonly.aof keys=10") {
+		t.Errorf("wrong text: %s", text)
+	}
+	if js := string(encodeJSON(e)); !strings.Contains(js, `"logger":"aof"`) || !strings.Contains(js, `"file":"appendonly.aof"`) {
+		t.Errorf("wrong json: %s", js)
+	}
+
+	aof.Named("rewrite").Error("failed")
+	if e := <-l.entryChan; e.name != "aof.rewrite" || len(e.fields) != 2 {
+		t.Errorf("wrong descendant entry: %s %v", e.name, e.fields)
+	}
+
+	l.SetLevel(WARNING)
+	aof.Info("dropped")
+	if len(l.entryChan) != 0 {
+		t.Error("child should follow level of root")
+	}
+}
+
+func TestChildOfDefaultLogger(t *testing.T) {
+	child := Named("repl") // created before DefaultLogger is replaced
+	l := makeTestLogger()
+	defaultLogger := DefaultLogger
+	DefaultLogger = l
+	defer func() {
+		DefaultLogger = defaultLogger
+	}()
+	child.Errorf("%s", "lost connection")
+	if e := <-l.entryChan; e.name != "repl" || e.msg != "lost connection" {
+		t.Errorf("wrong entry: %s %s", e.name, e.msg)
+	}
+}
+
+func TestHook(t *testing.T) {
+	l := makeTestLogger()
+	var rootMsgs, replMsgs []string
+	l.AddHook(func(level LogLevel, msg string) {
+		rootMsgs = append(rootMsgs, levelFlags[level]+" "+msg)
+	})
+	repl := l.Named("repl")
+	repl.AddHook(func(level LogLevel, msg string) {
+		if level >= ERROR {
+			replMsgs = append(replMsgs, msg)
+		}
+	})
+	l.Output(INFO, 1, "root\n")
+	repl.Named("slave").Error("sync failed")
+	repl.Warnw("slow", "ms", 120)
+	for len(l.entryChan) > 0 {
+		<-l.entryChan
+	}
+	expected := []string{"INFO root", "ERROR sync failed", "WARN slow ms=120"}
+	if strings.Join(rootMsgs, ",") != strings.Join(expected, ",") {
+		t.Errorf("wrong messages of root hook: %v", rootMsgs)
+	}
+	if len(replMsgs) != 1 || replMsgs[0] != "sync failed" {
+		t.Errorf("wrong messages of child hook: %v", replMsgs)
+	}
+}
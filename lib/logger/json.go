@@ -0,0 +1,70 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// fieldKey returns the key of the field at index i of keysAndValues
+func fieldKey(keysAndValues []interface{}, i int) string {
+	if key, ok := keysAndValues[i].(string); ok {
+		return key
+	}
+	return fmt.Sprint(keysAndValues[i])
+}
+
+// fieldValue returns the value of the field at index i of keysAndValues, nil if the key has no value
+func fieldValue(keysAndValues []interface{}, i int) interface{} {
+	if i+1 >= len(keysAndValues) {
+		return nil
+	}
+	return keysAndValues[i+1]
+}
+
+// encodeJSON encodes entry as a json object in one line, such as
+// {"time":"2006-01-02T15:04:05.000Z07:00","level":"INFO","logger":"aof","caller":"server.go:12","msg":"saved","keys":10}
+func encodeJSON(e *logEntry) []byte {
+	buf := make([]byte, 0, 128)
+	buf = append(buf, `{"time":`...)
+	buf = appendJSONValue(buf, e.time.Format(time.RFC3339Nano))
+	buf = append(buf, `,"level":`...)
+	buf = appendJSONValue(buf, levelFlags[e.level])
+	if e.name != "" {
+		buf = append(buf, `,"logger":`...)
+		buf = appendJSONValue(buf, e.name)
+	}
+	if e.caller != "" {
+		buf = append(buf, `,"caller":`...)
+		buf = appendJSONValue(buf, e.caller)
+	}
+	buf = append(buf, `,"msg":`...)
+	buf = appendJSONValue(buf, strings.TrimSuffix(e.msg, "\n"))
+	for i := 0; i < len(e.fields); i += 2 {
+		buf = append(buf, ',')
+		buf = appendJSONValue(buf, fieldKey(e.fields, i))
+		buf = append(buf, ':')
+		buf = appendJSONValue(buf, fieldValue(e.fields, i))
+	}
+	buf = append(buf, '}', '\n')
+	return buf
+}
+
+// appendJSONValue appends encoded value to buf, values which could not be encoded are written as strings
+func appendJSONValue(buf []byte, value interface{}) []byte {
+	switch v := value.(type) {
+	case json.Marshaler:
+	case error:
+		value = v.Error()
+	case fmt.Stringer:
+		value = v.String()
+	case []byte:
+		value = string(v)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(value))
+	}
+	return append(buf, encoded...)
+}
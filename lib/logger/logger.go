@@ -5,10 +5,12 @@ import (
 	"io"
 	"log"
 	"os"
-	"path"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,6 +20,18 @@ type Settings struct {
 	Name       string `yaml:"name"`
 	Ext        string `yaml:"ext"`
 	TimeFormat string `yaml:"time-format"`
+	// Level is the minimum level of messages to output, such as "info" or "warning", all messages are output if it is empty
+	Level string `yaml:"level"`
+	// Format is "json" or "text", text is used if it is empty
+	Format string `yaml:"format"`
+	// MaxSizeMB rotates the active log file once it exceeds the size, 0 means no limit
+	MaxSizeMB int `yaml:"max-size-mb"`
+	// MaxBackups is the max number of log files other than the active one to keep, 0 means keeping all of them
+	MaxBackups int `yaml:"max-backups"`
+	// MaxAgeDays removes log files modified more than MaxAgeDays days ago, 0 means never
+	MaxAgeDays int `yaml:"max-age-days"`
+	// Compress compresses log files other than the active one with gzip
+	Compress bool `yaml:"compress"`
 }
 
 type LogLevel int
@@ -38,25 +52,79 @@ const (
 )
 
 type logEntry struct {
-	msg   string
-	level LogLevel
+	msg    string
+	level  LogLevel
+	time   time.Time
+	caller string // file:line, empty if unknown
+	name   string // component name of child logger, empty for messages of root logger
+	fields []interface{}
+	// flushed is not nil if the entry is a mark sent by Flush, it receives the result of syncing
+	flushed chan error
 }
 
 var (
 	levelFlags = []string{"DEBUG", "INFO", "WARN", "ERROR", "FATAL"}
 )
 
+// ParseLevel converts level name to LogLevel, names of redis loglevel such as "notice" are accepted too
+func ParseLevel(name string) (LogLevel, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return DEBUG, nil
+	case "info", "verbose", "notice":
+		return INFO, nil
+	case "warn", "warning":
+		return WARNING, nil
+	case "error":
+		return ERROR, nil
+	case "fatal":
+		return FATAL, nil
+	}
+	return DEBUG, fmt.Errorf("unknown log level: %s", name)
+}
+
 // ILogger defines the methods that any logger should implement
 type ILogger interface {
 	Output(level LogLevel, callerDepth int, msg string)
 }
 
+// fieldLogger is implemented by loggers which accept key/value fields along with the message
+type fieldLogger interface {
+	OutputFields(level LogLevel, callerDepth int, msg string, keysAndValues []interface{})
+}
+
+// closableLogger is implemented by loggers which write messages asynchronously
+type closableLogger interface {
+	Flush() error
+	Close() error
+}
+
+// leveledLogger is implemented by loggers which filter messages by level,
+// messages below the level are dropped before formatting
+type leveledLogger interface {
+	Enabled(level LogLevel) bool
+	SetLevel(level LogLevel)
+}
+
 // Logger is Logger
 type Logger struct {
-	logFile   *os.File
+	logFile   *rotatingFile
 	logger    *log.Logger
 	entryChan chan *logEntry
 	entryPool *sync.Pool
+	// level is the minimum LogLevel to output, accessed atomically
+	level int32
+	// json encodes entries as json objects instead of text lines
+	json bool
+
+	// closeMu protects entryChan from sending after closed
+	closeMu sync.RWMutex
+	closed  bool
+	// done is closed after all entries are written
+	done chan struct{}
+
+	// hooks receive every message output by the logger and its child loggers
+	hooks hookSet
 }
 
 var DefaultLogger ILogger = NewStdoutLogger()
@@ -67,60 +135,49 @@ func NewStdoutLogger() *Logger {
 		logFile:   nil,
 		logger:    log.New(os.Stdout, "", flags),
 		entryChan: make(chan *logEntry, bufferSize),
+		done:      make(chan struct{}),
 		entryPool: &sync.Pool{
 			New: func() interface{} {
 				return &logEntry{}
 			},
 		},
 	}
-	go func() {
-		for e := range logger.entryChan {
-			_ = logger.logger.Output(0, e.msg) // msg includes call stack, no need for calldepth
-			logger.entryPool.Put(e)
-		}
-	}()
+	go logger.consume()
 	return logger
 }
 
 // NewFileLogger creates a logger which print msg to stdout and log file
 func NewFileLogger(settings *Settings) (*Logger, error) {
-	fileName := fmt.Sprintf("%s-%s.%s",
-		settings.Name,
-		time.Now().Format(settings.TimeFormat),
-		settings.Ext)
-	logFile, err := mustOpen(fileName, settings.Path)
+	level := DEBUG
+	if settings.Level != "" {
+		var err error
+		level, err = ParseLevel(settings.Level)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if settings.Format != "" && settings.Format != "text" && settings.Format != "json" {
+		return nil, fmt.Errorf("unknown log format: %s", settings.Format)
+	}
+	logFile, err := openRotatingFile(settings)
 	if err != nil {
-		return nil, fmt.Errorf("logging.Join err: %s", err)
+		return nil, err
 	}
 	mw := io.MultiWriter(os.Stdout, logFile)
 	logger := &Logger{
 		logFile:   logFile,
 		logger:    log.New(mw, "", flags),
 		entryChan: make(chan *logEntry, bufferSize),
+		done:      make(chan struct{}),
 		entryPool: &sync.Pool{
 			New: func() interface{} {
 				return &logEntry{}
 			},
 		},
+		level: int32(level),
+		json:  settings.Format == "json",
 	}
-	go func() {
-		for e := range logger.entryChan {
-			logFilename := fmt.Sprintf("%s-%s.%s",
-				settings.Name,
-				time.Now().Format(settings.TimeFormat),
-				settings.Ext)
-			if path.Join(settings.Path, logFilename) != logger.logFile.Name() {
-				logFile, err := mustOpen(logFilename, settings.Path)
-				if err != nil {
-					panic("open log " + logFilename + " failed: " + err.Error())
-				}
-				logger.logFile = logFile
-				logger.logger = log.New(io.MultiWriter(os.Stdout, logFile), "", flags)
-			}
-			_ = logger.logger.Output(0, e.msg) // msg includes call stack, no need for calldepth
-			logger.entryPool.Put(e)
-		}
-	}()
+	go logger.consume()
 	return logger, nil
 }
 
@@ -133,65 +190,283 @@ func Setup(settings *Settings) {
 	DefaultLogger = logger
 }
 
+// SetLevel changes the minimum level of messages to output, it is safe to call at runtime
+func (logger *Logger) SetLevel(level LogLevel) {
+	atomic.StoreInt32(&logger.level, int32(level))
+}
+
+// Enabled returns whether messages of the given level would be output
+func (logger *Logger) Enabled(level LogLevel) bool {
+	return int32(level) >= atomic.LoadInt32(&logger.level)
+}
+
 // Output sends a msg to logger
 func (logger *Logger) Output(level LogLevel, callerDepth int, msg string) {
-	var formattedMsg string
-	_, file, line, ok := runtime.Caller(callerDepth)
-	if ok {
-		formattedMsg = fmt.Sprintf("[%s][%s:%d] %s", levelFlags[level], filepath.Base(file), line, msg)
-	} else {
-		formattedMsg = fmt.Sprintf("[%s] %s", levelFlags[level], msg)
+	logger.OutputFields(level, callerDepth+1, msg, nil)
+}
+
+// OutputFields sends a msg with key/value fields to logger, such as OutputFields(INFO, 1, "saved", []interface{}{"keys", 10})
+func (logger *Logger) OutputFields(level LogLevel, callerDepth int, msg string, keysAndValues []interface{}) {
+	logger.output(level, callerDepth+1, "", msg, keysAndValues)
+}
+
+// output sends a msg of the named component to logger, name is empty for messages of root logger
+func (logger *Logger) output(level LogLevel, callerDepth int, name string, msg string, keysAndValues []interface{}) {
+	if !logger.Enabled(level) {
+		return
 	}
+	logger.hooks.fire(level, msg, keysAndValues)
 	entry := logger.entryPool.Get().(*logEntry)
-	entry.msg = formattedMsg
+	entry.caller = ""
+	if _, file, line, ok := runtime.Caller(callerDepth); ok {
+		entry.caller = filepath.Base(file) + ":" + strconv.Itoa(line)
+	}
+	entry.msg = msg
+	entry.name = name
 	entry.level = level
+	entry.time = time.Now()
+	entry.fields = keysAndValues
+	logger.closeMu.RLock()
+	defer logger.closeMu.RUnlock()
+	if logger.closed {
+		return
+	}
 	logger.entryChan <- entry
 }
 
+// Flush waits for messages sent before it being written, then commits the log file to disk
+func (logger *Logger) Flush() error {
+	flushed := make(chan error, 1)
+	logger.closeMu.RLock()
+	if logger.closed {
+		logger.closeMu.RUnlock()
+		return nil
+	}
+	logger.entryChan <- &logEntry{flushed: flushed}
+	logger.closeMu.RUnlock()
+	return <-flushed
+}
+
+// Close writes pending messages and closes the log file, messages sent after Close are dropped
+func (logger *Logger) Close() error {
+	logger.closeMu.Lock()
+	if logger.closed {
+		logger.closeMu.Unlock()
+		return nil
+	}
+	logger.closed = true
+	close(logger.entryChan)
+	logger.closeMu.Unlock()
+	<-logger.done
+	if logger.logFile == nil {
+		return nil
+	}
+	if err := logger.logFile.Sync(); err != nil {
+		_ = logger.logFile.Close()
+		return err
+	}
+	return logger.logFile.Close()
+}
+
+// consume writes entries until entryChan is closed
+func (logger *Logger) consume() {
+	defer close(logger.done)
+	for e := range logger.entryChan {
+		if e.flushed != nil {
+			e.flushed <- logger.sync()
+			continue
+		}
+		logger.write(e)
+	}
+}
+
+func (logger *Logger) sync() error {
+	if logger.logFile == nil {
+		return nil // stdout is not buffered
+	}
+	return logger.logFile.Sync()
+}
+
+// write encodes entry and writes it, it is called by the goroutine consuming entryChan only
+func (logger *Logger) write(e *logEntry) {
+	if logger.json {
+		_, _ = logger.logger.Writer().Write(encodeJSON(e))
+	} else {
+		_ = logger.logger.Output(0, formatText(e)) // msg includes call stack, no need for calldepth
+	}
+	e.fields = nil
+	logger.entryPool.Put(e)
+}
+
+// formatText formats entry like "[INFO][aof][server.go:12] msg key=value", time is prepended by log.Logger
+func formatText(e *logEntry) string {
+	var sb strings.Builder
+	sb.WriteString("[" + levelFlags[e.level] + "]")
+	if e.name != "" {
+		sb.WriteString("[" + e.name + "]")
+	}
+	if e.caller != "" {
+		sb.WriteString("[" + e.caller + "]")
+	}
+	sb.WriteString(" ")
+	sb.WriteString(textMessage(e.msg, e.fields))
+	return sb.String()
+}
+
+// textMessage appends key/value fields to msg
+func textMessage(msg string, keysAndValues []interface{}) string {
+	if len(keysAndValues) == 0 {
+		return msg
+	}
+	var sb strings.Builder
+	sb.WriteString(strings.TrimSuffix(msg, "\n"))
+	for i := 0; i < len(keysAndValues); i += 2 {
+		sb.WriteString(" " + fieldKey(keysAndValues, i) + "=" + fmt.Sprint(fieldValue(keysAndValues, i)))
+	}
+	return sb.String()
+}
+
+// SetLevel changes the minimum level of messages output by DefaultLogger
+func SetLevel(level LogLevel) {
+	if l, ok := DefaultLogger.(leveledLogger); ok {
+		l.SetLevel(level)
+	}
+}
+
+// enabled returns whether DefaultLogger outputs messages of the given level, so that dropped messages skip formatting
+func enabled(level LogLevel) bool {
+	if l, ok := DefaultLogger.(leveledLogger); ok {
+		return l.Enabled(level)
+	}
+	return true
+}
+
 // Debug logs debug message through DefaultLogger
 func Debug(v ...interface{}) {
+	if !enabled(DEBUG) {
+		return
+	}
 	msg := fmt.Sprintln(v...)
 	DefaultLogger.Output(DEBUG, defaultCallerDepth, msg)
 }
 
 // Debugf logs debug message through DefaultLogger
 func Debugf(format string, v ...interface{}) {
+	if !enabled(DEBUG) {
+		return
+	}
 	msg := fmt.Sprintf(format, v...)
 	DefaultLogger.Output(DEBUG, defaultCallerDepth, msg)
 }
 
 // Info logs message through DefaultLogger
 func Info(v ...interface{}) {
+	if !enabled(INFO) {
+		return
+	}
 	msg := fmt.Sprintln(v...)
 	DefaultLogger.Output(INFO, defaultCallerDepth, msg)
 }
 
 // Infof logs message through DefaultLogger
 func Infof(format string, v ...interface{}) {
+	if !enabled(INFO) {
+		return
+	}
 	msg := fmt.Sprintf(format, v...)
 	DefaultLogger.Output(INFO, defaultCallerDepth, msg)
 }
 
 // Warn logs warning message through DefaultLogger
 func Warn(v ...interface{}) {
+	if !enabled(WARNING) {
+		return
+	}
 	msg := fmt.Sprintln(v...)
 	DefaultLogger.Output(WARNING, defaultCallerDepth, msg)
 }
 
 // Error logs error message through DefaultLogger
 func Error(v ...interface{}) {
+	if !enabled(ERROR) {
+		return
+	}
 	msg := fmt.Sprintln(v...)
 	DefaultLogger.Output(ERROR, defaultCallerDepth, msg)
 }
 
 // Errorf logs error message through DefaultLogger
 func Errorf(format string, v ...interface{}) {
+	if !enabled(ERROR) {
+		return
+	}
 	msg := fmt.Sprintf(format, v...)
 	DefaultLogger.Output(ERROR, defaultCallerDepth, msg)
 }
 
-// Fatal prints error message then stop the program
+// outputw logs message with key/value fields through DefaultLogger
+func outputw(level LogLevel, msg string, keysAndValues []interface{}) {
+	if l, ok := DefaultLogger.(fieldLogger); ok {
+		l.OutputFields(level, defaultCallerDepth+1, msg, keysAndValues)
+		return
+	}
+	DefaultLogger.Output(level, defaultCallerDepth+1, textMessage(msg, keysAndValues))
+}
+
+// Debugw logs debug message with key/value fields through DefaultLogger, such as Debugw("loaded", "keys", 10)
+func Debugw(msg string, keysAndValues ...interface{}) {
+	if !enabled(DEBUG) {
+		return
+	}
+	outputw(DEBUG, msg, keysAndValues)
+}
+
+// Infow logs message with key/value fields through DefaultLogger
+func Infow(msg string, keysAndValues ...interface{}) {
+	if !enabled(INFO) {
+		return
+	}
+	outputw(INFO, msg, keysAndValues)
+}
+
+// Warnw logs warning message with key/value fields through DefaultLogger
+func Warnw(msg string, keysAndValues ...interface{}) {
+	if !enabled(WARNING) {
+		return
+	}
+	outputw(WARNING, msg, keysAndValues)
+}
+
+// Errorw logs error message with key/value fields through DefaultLogger
+func Errorw(msg string, keysAndValues ...interface{}) {
+	if !enabled(ERROR) {
+		return
+	}
+	outputw(ERROR, msg, keysAndValues)
+}
+
+// Fatal prints error message then stop the program, the message is flushed before returning
 func Fatal(v ...interface{}) {
+	if !enabled(FATAL) {
+		return
+	}
 	msg := fmt.Sprintln(v...)
 	DefaultLogger.Output(FATAL, defaultCallerDepth, msg)
+	_ = Flush()
+}
+
+// Flush waits for messages of DefaultLogger being written
+func Flush() error {
+	if l, ok := DefaultLogger.(closableLogger); ok {
+		return l.Flush()
+	}
+	return nil
+}
+
+// Close writes pending messages of DefaultLogger and closes it, it should be called before exiting
+func Close() error {
+	if l, ok := DefaultLogger.(closableLogger); ok {
+		return l.Close()
+	}
+	return nil
 }
@@ -0,0 +1,164 @@
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// makeTestLogger creates a logger without consumer, so that tests could inspect queued entries
+func makeTestLogger() *Logger {
+	return &Logger{
+		entryChan: make(chan *logEntry, 10),
+		entryPool: &sync.Pool{
+			New: func() interface{} {
+				return &logEntry{}
+			},
+		},
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for name, expected := range map[string]LogLevel{
+		"debug":   DEBUG,
+		"notice":  INFO,
+		"INFO":    INFO,
+		"warning": WARNING,
+		"error":   ERROR,
+	} {
+		level, err := ParseLevel(name)
+		if err != nil || level != expected {
+			t.Errorf("%s: expect %d, actual %d", name, expected, level)
+		}
+	}
+	if _, err := ParseLevel("foo"); err == nil {
+		t.Error("expect error")
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	l := makeTestLogger()
+	defaultLogger := DefaultLogger
+	DefaultLogger = l
+	defer func() {
+		DefaultLogger = defaultLogger
+	}()
+
+	Debug("debug")
+	if len(l.entryChan) != 1 {
+		t.Error("all levels are enabled by default")
+	}
+	<-l.entryChan
+
+	SetLevel(WARNING)
+	Debugf("%s", "debug")
+	Info("info")
+	if len(l.entryChan) != 0 {
+		t.Error("messages below level should be dropped")
+	}
+	Warn("warn")
+	Error("error")
+	if len(l.entryChan) != 2 {
+		t.Errorf("expect 2 entries, actual %d", len(l.entryChan))
+	}
+	if e := <-l.entryChan; e.level != WARNING {
+		t.Errorf("expect warning entry, actual %s", levelFlags[e.level])
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	l := makeTestLogger()
+	l.json = true
+	defaultLogger := DefaultLogger
+	DefaultLogger = l
+	defer func() {
+		DefaultLogger = defaultLogger
+	}()
+
+	Infow("saved", "keys", 10, "err", errors.New("oops"), "dangling")
+	e := <-l.entryChan
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(encodeJSON(e), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"level":    "INFO",
+		"msg":      "saved",
+		"keys":     float64(10),
+		"err":      "oops",
+		"dangling": nil,
+	}
+	for key, value := range expected {
+		if decoded[key] != value {
+			t.Errorf("%s: expect %v, actual %v", key, value, decoded[key])
+		}
+	}
+	if caller, _ := decoded["caller"].(string); !strings.HasPrefix(caller, "logger_test.go:") {
+		t.Errorf("wrong caller %v", decoded["caller"])
+	}
+	if _, err := time.Parse(time.RFC3339Nano, decoded["time"].(string)); err != nil {
+		t.Error(err)
+	}
+
+	Info("plain")
+	e = <-l.entryChan
+	if err := json.Unmarshal(encodeJSON(e), &decoded); err != nil || decoded["msg"] != "plain" {
+		t.Errorf("wrong message %v", decoded["msg"])
+	}
+}
+
+func TestTextFields(t *testing.T) {
+	e := &logEntry{msg: "saved", level: WARNING, caller: "a.go:1", fields: []interface{}{"keys", 10, "db", "0"}}
+	if actual := formatText(e); actual != "[WARN][a.go:1] saved keys=10 db=0" {
+		t.Errorf("wrong text %s", actual)
+	}
+}
+
+func TestFlushAndClose(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewFileLogger(&Settings{
+		Path:       dir,
+		Name:       "godis",
+		Ext:        "log",
+		TimeFormat: "2006-01-02",
+		Level:      "info",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	countLines := func() int {
+		content, err := os.ReadFile(l.logFile.file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Count(string(content), "\n")
+	}
+	for i := 0; i < 100; i++ {
+		l.Output(INFO, 1, "flush")
+	}
+	if err := l.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countLines(); n != 100 {
+		t.Errorf("expect 100 lines after flush, actual %d", n)
+	}
+	l.Output(ERROR, 1, "close")
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countLines(); n != 101 {
+		t.Errorf("expect 101 lines after close, actual %d", n)
+	}
+	// dropped after closed
+	l.Output(ERROR, 1, "closed")
+	if err := l.Flush(); err != nil {
+		t.Error(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Error(err)
+	}
+}
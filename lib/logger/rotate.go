@@ -0,0 +1,238 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	compressSuffix   = ".gz"
+	backupTimeFormat = "20060102T150405.000"
+)
+
+// rotatingFile writes logs into <name>-<time>.<ext> under settings.Path.
+// It switches to a new file once the time formatted name changes, and renames the active file to
+// <name>-<time>.<backup time>.<ext> once it exceeds MaxSizeMB. Files other than the active one are backups,
+// which are compressed and pruned in background according to settings.
+// It is not safe for concurrent use, the goroutine consuming entries of Logger is the only writer.
+type rotatingFile struct {
+	settings *Settings
+	file     *os.File
+	size     int64
+	// millCh notifies the background goroutine to compress and prune backups
+	millCh chan struct{}
+}
+
+func openRotatingFile(settings *Settings) (*rotatingFile, error) {
+	f := &rotatingFile{
+		settings: settings,
+	}
+	if err := f.open(f.filename()); err != nil {
+		return nil, err
+	}
+	if settings.MaxBackups > 0 || settings.MaxAgeDays > 0 || settings.Compress {
+		f.millCh = make(chan struct{}, 1)
+		go f.millRun()
+		f.mill()
+	}
+	return f, nil
+}
+
+// filename returns name of the active file at present
+func (f *rotatingFile) filename() string {
+	return fmt.Sprintf("%s-%s.%s", f.settings.Name, time.Now().Format(f.settings.TimeFormat), f.settings.Ext)
+}
+
+func (f *rotatingFile) open(filename string) error {
+	file, err := mustOpen(filename, f.settings.Path)
+	if err != nil {
+		return fmt.Errorf("logging.Join err: %s", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	if f.file != nil {
+		_ = f.file.Close()
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	filename := f.filename()
+	if filepath.Join(f.settings.Path, filename) != filepath.Clean(f.file.Name()) {
+		if err := f.open(filename); err != nil {
+			return 0, err
+		}
+		f.mill()
+	} else if maxSize := int64(f.settings.MaxSizeMB) * 1024 * 1024; maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate renames the active file to a backup and opens a new one
+func (f *rotatingFile) rotate() error {
+	name := f.file.Name()
+	ext := filepath.Ext(name)
+	backup := strings.TrimSuffix(name, ext) + "." + time.Now().Format(backupTimeFormat) + ext
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	if err := os.Rename(name, backup); err != nil {
+		return err
+	}
+	if err := f.open(filepath.Base(name)); err != nil {
+		return err
+	}
+	f.mill()
+	return nil
+}
+
+// Sync commits the active file to disk
+func (f *rotatingFile) Sync() error {
+	return f.file.Sync()
+}
+
+func (f *rotatingFile) Close() error {
+	if f.millCh != nil {
+		close(f.millCh)
+	}
+	return f.file.Close()
+}
+
+// mill notifies the background goroutine to process backups, it never blocks
+func (f *rotatingFile) mill() {
+	if f.millCh == nil {
+		return
+	}
+	select {
+	case f.millCh <- struct{}{}:
+	default:
+	}
+}
+
+func (f *rotatingFile) millRun() {
+	for range f.millCh {
+		if err := f.millRunOnce(); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "process log backups failed: "+err.Error())
+		}
+	}
+}
+
+type backupInfo struct {
+	path    string
+	modTime time.Time
+}
+
+// backups returns backups of log files, the latest goes first
+func (f *rotatingFile) backups() ([]backupInfo, error) {
+	entries, err := os.ReadDir(f.settings.Path)
+	if err != nil {
+		return nil, err
+	}
+	active := f.filename()
+	prefix := f.settings.Name + "-"
+	suffix := "." + f.settings.Ext
+	var backups []backupInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == active || !strings.HasPrefix(name, prefix) ||
+			!strings.HasSuffix(strings.TrimSuffix(name, compressSuffix), suffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // removed
+		}
+		backups = append(backups, backupInfo{
+			path:    filepath.Join(f.settings.Path, name),
+			modTime: info.ModTime(),
+		})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].modTime.After(backups[j].modTime)
+	})
+	return backups, nil
+}
+
+// millRunOnce removes backups beyond MaxBackups or older than MaxAgeDays, then compresses the rest if Compress is set
+func (f *rotatingFile) millRunOnce() error {
+	backups, err := f.backups()
+	if err != nil {
+		return err
+	}
+	var cutoff time.Time
+	if f.settings.MaxAgeDays > 0 {
+		cutoff = time.Now().Add(-time.Duration(f.settings.MaxAgeDays) * 24 * time.Hour)
+	}
+	for i, backup := range backups {
+		expired := !cutoff.IsZero() && backup.modTime.Before(cutoff)
+		if expired || (f.settings.MaxBackups > 0 && i >= f.settings.MaxBackups) {
+			if err := os.Remove(backup.path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if f.settings.Compress && !strings.HasSuffix(backup.path, compressSuffix) {
+			if err := compressFile(backup.path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compressFile replaces src with src.gz, modification time is kept for pruning
+func compressFile(src string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	dst := src + compressSuffix
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = out.Close()
+			_ = os.Remove(dst)
+		}
+	}()
+	gz := gzip.NewWriter(out)
+	if _, err = io.Copy(gz, in); err != nil {
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if err = os.Chtimes(dst, info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
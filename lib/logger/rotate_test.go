@@ -0,0 +1,104 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotateBySize(t *testing.T) {
+	dir := t.TempDir()
+	settings := &Settings{
+		Path:       dir,
+		Name:       "godis",
+		Ext:        "log",
+		TimeFormat: "2006-01-02",
+		MaxSizeMB:  1,
+	}
+	f, err := openRotatingFile(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	line := []byte(strings.Repeat("a", 1023) + "\n")
+	for i := 0; i < 1024; i++ {
+		if _, err := f.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backups, _ := f.backups()
+	if len(backups) != 0 {
+		t.Error("file should not be rotated before exceeding max size")
+	}
+	if _, err := f.Write(line); err != nil {
+		t.Fatal(err)
+	}
+	backups, _ = f.backups()
+	if len(backups) != 1 {
+		t.Fatalf("expect 1 backup, actual %d", len(backups))
+	}
+	info, err := os.Stat(backups[0].path)
+	if err != nil || info.Size() != 1024*1024 {
+		t.Error("backup should hold content before rotating")
+	}
+	if f.size != int64(len(line)) {
+		t.Errorf("active file should be new, size %d", f.size)
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	settings := &Settings{
+		Path:       dir,
+		Name:       "godis",
+		Ext:        "log",
+		TimeFormat: "2006-01-02",
+		MaxBackups: 2,
+		MaxAgeDays: 7,
+		Compress:   true,
+	}
+	now := time.Now()
+	for i, age := range []time.Duration{time.Hour, 2 * time.Hour, 3 * time.Hour, 10 * 24 * time.Hour} {
+		name := filepath.Join(dir, "godis-2000-01-0"+string(rune('1'+i))+".log")
+		if err := os.WriteFile(name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = os.WriteFile(filepath.Join(dir, "other.log"), nil, 0644)
+
+	// process backups in the test goroutine instead of background
+	f := &rotatingFile{settings: settings}
+	if err := f.open(f.filename()); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := f.millRunOnce(); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	expected := []string{"godis-2000-01-01.log.gz", "godis-2000-01-02.log.gz", f.filename(), "other.log"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expect %v, actual %v", expected, names)
+	}
+	gzFile, _ := os.Open(filepath.Join(dir, "godis-2000-01-01.log.gz"))
+	defer gzFile.Close()
+	reader, err := gzip.NewReader(gzFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(reader)
+	if string(content) != filepath.Join(dir, "godis-2000-01-01.log") {
+		t.Error("wrong content after compressing")
+	}
+}
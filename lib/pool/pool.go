@@ -1,8 +1,12 @@
 package pool
 
 import (
+	"context"
 	"errors"
 	"sync"
+	"time"
+
+	"github.com/hdt3213/godis/lib/clock"
 )
 
 var (
@@ -15,6 +19,17 @@ type request chan interface{}
 type Config struct {
 	MaxIdle   uint
 	MaxActive uint
+	// IdleTimeout destroys items which stay idle longer than it, 0 means never.
+	// Expired items are evicted by Get, CheckIdles and a background goroutine of the pool
+	IdleTimeout time.Duration
+	// TestOnBorrow checks an idle item before Get returns it, unhealthy items are discarded. It is optional
+	TestOnBorrow func(x interface{}) bool
+}
+
+// idleItem is an item waiting in pool and the time it was returned
+type idleItem struct {
+	x     interface{}
+	since time.Time
 }
 
 // Pool stores object for reusing, such as redis connection
@@ -22,37 +37,60 @@ type Pool struct {
 	Config
 	factory     func() (interface{}, error)
 	finalizer   func(x interface{})
-	idles       chan interface{}
+	idles       chan *idleItem
 	waitingReqs []request
 	activeCount uint // increases during creating connection, decrease during destroying connection
 	mu          sync.Mutex
 	closed      bool
+	closeChan   chan struct{}
 }
 
 func New(factory func() (interface{}, error), finalizer func(x interface{}), cfg Config) *Pool {
-	return &Pool{
+	pool := &Pool{
 		factory:     factory,
 		finalizer:   finalizer,
-		idles:       make(chan interface{}, cfg.MaxIdle),
+		idles:       make(chan *idleItem, cfg.MaxIdle),
 		waitingReqs: make([]request, 0),
 		Config:      cfg,
+		closeChan:   make(chan struct{}),
 	}
+	if cfg.IdleTimeout > 0 {
+		interval := cfg.IdleTimeout / 2
+		if interval < time.Second {
+			interval = time.Second
+		}
+		go pool.evictLoop(clock.NewTicker(interval))
+	}
+	return pool
 }
 
 // getOnNoIdle try to create a new connection or waiting for connection being returned
 // invoker should have pool.mu
-func (pool *Pool) getOnNoIdle() (interface{}, error) {
+func (pool *Pool) getOnNoIdle(ctx context.Context) (interface{}, error) {
 	pool.mu.Lock()
 	if pool.activeCount >= pool.MaxActive {
 		// waiting for connection being returned
 		req := make(chan interface{}, 1)
 		pool.waitingReqs = append(pool.waitingReqs, req)
 		pool.mu.Unlock()
-		x, ok := <-req
-		if !ok {
-			return nil, ErrMax
+		select {
+		case x, ok := <-req:
+			if !ok {
+				return nil, ErrMax
+			}
+			return x, nil
+		case <-ctx.Done():
+			pool.mu.Lock()
+			removed := pool.removeWaitingReq(req)
+			pool.mu.Unlock()
+			if !removed {
+				// an item has been handed to the request, give it back
+				if x, ok := <-req; ok {
+					pool.Put(x)
+				}
+			}
+			return nil, ctx.Err()
 		}
-		return x, nil
 	}
 
 	// create a new connection
@@ -69,50 +107,157 @@ func (pool *Pool) getOnNoIdle() (interface{}, error) {
 	return x, nil
 }
 
+// removeWaitingReq removes req from waiting queue, returns false if it has been served
+// invoker should have pool.mu
+func (pool *Pool) removeWaitingReq(req request) bool {
+	for i, r := range pool.waitingReqs {
+		if r == req {
+			pool.waitingReqs = append(pool.waitingReqs[:i], pool.waitingReqs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// popWaitingReq removes and returns the earliest waiting request, returns nil if there is none
+// invoker should have pool.mu
+func (pool *Pool) popWaitingReq() request {
+	if len(pool.waitingReqs) == 0 {
+		return nil
+	}
+	req := pool.waitingReqs[0]
+	copy(pool.waitingReqs, pool.waitingReqs[1:])
+	pool.waitingReqs = pool.waitingReqs[:len(pool.waitingReqs)-1]
+	return req
+}
+
 func (pool *Pool) Get() (interface{}, error) {
-	pool.mu.Lock()
-	if pool.closed {
+	return pool.GetContext(context.Background())
+}
+
+// GetContext is like Get, but gives up waiting for an item being returned once ctx is done
+func (pool *Pool) GetContext(ctx context.Context) (interface{}, error) {
+	for {
+		pool.mu.Lock()
+		if pool.closed {
+			pool.mu.Unlock()
+			return nil, ErrClosed
+		}
 		pool.mu.Unlock()
-		return nil, ErrClosed
-	}
-	pool.mu.Unlock()
 
-	select {
-	case item := <-pool.idles:
-		return item, nil
-	default:
-		// no pooled item, create one
-		return pool.getOnNoIdle()
+		select {
+		case item, ok := <-pool.idles:
+			if !ok {
+				return nil, ErrClosed
+			}
+			if pool.isExpired(item, clock.Now()) || (pool.TestOnBorrow != nil && !pool.TestOnBorrow(item.x)) {
+				pool.Discard(item.x)
+				continue
+			}
+			return item.x, nil
+		default:
+			// no pooled item, create one
+			return pool.getOnNoIdle(ctx)
+		}
 	}
 }
 
+func (pool *Pool) isExpired(item *idleItem, now time.Time) bool {
+	return pool.IdleTimeout > 0 && now.Sub(item.since) >= pool.IdleTimeout
+}
+
 func (pool *Pool) Put(x interface{}) {
+	pool.put(&idleItem{x: x, since: clock.Now()})
+}
+
+// put returns item to pool, the idle time of item is kept if it goes back to idles
+func (pool *Pool) put(item *idleItem) {
 	pool.mu.Lock()
 
 	if pool.closed {
 		pool.mu.Unlock()
-		pool.finalizer(x)
+		pool.finalizer(item.x)
 		return
 	}
 
-	if len(pool.waitingReqs) > 0 {
-		req := pool.waitingReqs[0]
-		copy(pool.waitingReqs, pool.waitingReqs[1:])
-		pool.waitingReqs = pool.waitingReqs[:len(pool.waitingReqs)-1]
-		req <- x
+	if req := pool.popWaitingReq(); req != nil {
+		req <- item.x
 		pool.mu.Unlock()
 		return
 	}
 
 	select {
-	case pool.idles <- x:
+	case pool.idles <- item:
 		pool.mu.Unlock()
 		return
 	default:
 		// reach max idle, destroy redundant item
+		pool.activeCount--
 		pool.mu.Unlock()
+		pool.finalizer(item.x)
+	}
+}
+
+// Discard destroys an item got from pool instead of returning it, such as a broken connection
+func (pool *Pool) Discard(x interface{}) {
+	pool.finalizer(x)
+	pool.mu.Lock()
+	if pool.closed || len(pool.waitingReqs) == 0 {
 		pool.activeCount--
-		pool.finalizer(x)
+		pool.mu.Unlock()
+		return
+	}
+	// create a new item for the waiting request, the place of discarded item is taken by it
+	req := pool.popWaitingReq()
+	pool.mu.Unlock()
+	go func() {
+		x, err := pool.factory()
+		if err != nil {
+			pool.mu.Lock()
+			pool.activeCount--
+			pool.mu.Unlock()
+			close(req)
+			return
+		}
+		req <- x
+	}()
+}
+
+// CheckIdles checks items not in use, expired or unhealthy items are discarded.
+// isHealthy may be nil to evict expired items only
+func (pool *Pool) CheckIdles(isHealthy func(x interface{}) bool) {
+	n := len(pool.idles)
+	now := clock.Now()
+	for i := 0; i < n; i++ {
+		var item *idleItem
+		select {
+		case it, ok := <-pool.idles:
+			if !ok {
+				// pool closed
+				return
+			}
+			item = it
+		default:
+			return
+		}
+		if pool.isExpired(item, now) || (isHealthy != nil && !isHealthy(item.x)) {
+			pool.Discard(item.x)
+		} else {
+			pool.put(item)
+		}
+	}
+}
+
+// evictLoop evicts expired idle items periodically until pool closed
+func (pool *Pool) evictLoop(ticker clock.Ticker) {
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.Chan():
+			pool.CheckIdles(nil)
+		case <-pool.closeChan:
+			return
+		}
 	}
 }
 
@@ -124,9 +269,10 @@ func (pool *Pool) Close() {
 	}
 	pool.closed = true
 	close(pool.idles)
+	close(pool.closeChan)
 	pool.mu.Unlock()
 
-	for x := range pool.idles {
-		pool.finalizer(x)
+	for item := range pool.idles {
+		pool.finalizer(item.x)
 	}
 }
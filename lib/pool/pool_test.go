@@ -1,9 +1,13 @@
 package pool
 
 import (
+	"context"
 	"errors"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/hdt3213/godis/lib/clock"
 )
 
 type mockConn struct {
@@ -167,3 +171,148 @@ func TestPool_CreateErr(t *testing.T) {
 	}
 
 }
+
+func TestPool_Discard(t *testing.T) {
+	factory := func() (interface{}, error) {
+		return &mockConn{
+			open: true,
+		}, nil
+	}
+	finalizer := func(x interface{}) {
+		c := x.(*mockConn)
+		c.open = false
+	}
+	cfg := Config{
+		MaxIdle:   2,
+		MaxActive: 2,
+	}
+	pool := New(factory, finalizer, cfg)
+	x1, _ := pool.Get()
+	x2, _ := pool.Get()
+	getResult := make(chan *mockConn, 1)
+	go func() {
+		x, err := pool.Get()
+		if err != nil {
+			t.Error(err)
+		}
+		c, _ := x.(*mockConn)
+		getResult <- c
+	}()
+	time.Sleep(100 * time.Millisecond)
+	// waiting request gets a new connection after the broken one discarded
+	pool.Discard(x1)
+	if x1.(*mockConn).open {
+		t.Error("discarded conn is not closed")
+	}
+	c := <-getResult
+	if c == nil || !c.open || c == x1 {
+		t.Error("expect a new conn")
+	}
+	pool.Put(c)
+
+	// unhealthy idle conn is discarded
+	x2.(*mockConn).open = false
+	pool.Put(x2)
+	pool.CheckIdles(func(x interface{}) bool {
+		return x.(*mockConn).open
+	})
+	if len(pool.idles) != 1 || pool.activeCount != 1 {
+		t.Errorf("expect 1 idle conn, actual idles %d, active %d", len(pool.idles), pool.activeCount)
+	}
+}
+
+func TestPool_GetContext(t *testing.T) {
+	factory := func() (interface{}, error) {
+		return &mockConn{
+			open: true,
+		}, nil
+	}
+	finalizer := func(x interface{}) {
+		x.(*mockConn).open = false
+	}
+	pool := New(factory, finalizer, Config{
+		MaxIdle:   1,
+		MaxActive: 1,
+	})
+	x, _ := pool.Get()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := pool.GetContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expect deadline exceeded, actual %v", err)
+	}
+	if len(pool.waitingReqs) != 0 {
+		t.Error("canceled request should be removed")
+	}
+	pool.Put(x)
+	if y, err := pool.Get(); err != nil || y != x {
+		t.Error("expect the returned conn")
+	}
+}
+
+func TestPool_IdleTimeout(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	defer clock.Set(fake)()
+	var connNum int32 // finalizer is called by background goroutine
+	factory := func() (interface{}, error) {
+		atomic.AddInt32(&connNum, 1)
+		return &mockConn{
+			open: true,
+		}, nil
+	}
+	finalizer := func(x interface{}) {
+		atomic.AddInt32(&connNum, -1)
+		x.(*mockConn).open = false
+	}
+	pool := New(factory, finalizer, Config{
+		MaxIdle:     2,
+		MaxActive:   2,
+		IdleTimeout: time.Minute,
+	})
+	defer pool.Close()
+	x1, _ := pool.Get()
+	x2, _ := pool.Get()
+	pool.Put(x1)
+	fake.Advance(30 * time.Second)
+	pool.Put(x2)
+
+	// x1 expires, x2 is reused
+	fake.Advance(40 * time.Second)
+	x, _ := pool.Get()
+	if x != x2 || x1.(*mockConn).open {
+		t.Error("expired conn should be evicted")
+	}
+	pool.Put(x)
+	fake.Advance(2 * time.Minute)
+	// evicted by background goroutine
+	for i := 0; i < 100 && atomic.LoadInt32(&connNum) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&connNum); n != 0 {
+		t.Errorf("expect all conns evicted, actual %d", n)
+	}
+}
+
+func TestPool_TestOnBorrow(t *testing.T) {
+	factory := func() (interface{}, error) {
+		return &mockConn{
+			open: true,
+		}, nil
+	}
+	finalizer := func(x interface{}) {
+		x.(*mockConn).open = false
+	}
+	pool := New(factory, finalizer, Config{
+		MaxIdle:   1,
+		MaxActive: 1,
+		TestOnBorrow: func(x interface{}) bool {
+			return x.(*mockConn).open
+		},
+	})
+	x1, _ := pool.Get()
+	x1.(*mockConn).open = false // broken while idle
+	pool.Put(x1)
+	x2, err := pool.Get()
+	if err != nil || x2 == x1 || !x2.(*mockConn).open {
+		t.Error("unhealthy conn should be replaced")
+	}
+}
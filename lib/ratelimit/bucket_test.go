@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hdt3213/godis/lib/clock"
+)
+
+func TestBucket(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	defer clock.Set(fake)()
+	b := NewBucket(10, 5)
+	for i := 0; i < 5; i++ {
+		if !b.Allow() {
+			t.Fatalf("burst %d should be allowed", i)
+		}
+	}
+	if b.Allow() {
+		t.Error("empty bucket should throttle")
+	}
+
+	fake.Advance(200 * time.Millisecond)
+	if !b.AllowN(2) || b.Allow() {
+		t.Error("expect 2 tokens after 200ms")
+	}
+
+	fake.Advance(time.Hour)
+	if tokens := b.Tokens(); tokens != 5 {
+		t.Errorf("tokens should not exceed burst, actual %f", tokens)
+	}
+	if b.AllowN(6) || b.Tokens() != 5 {
+		t.Error("tokens should be kept if not enough")
+	}
+
+	b.SetRate(1, 2)
+	if tokens := b.Tokens(); tokens != 2 {
+		t.Errorf("tokens should be limited by new burst, actual %f", tokens)
+	}
+	b.AllowN(2)
+	fake.Advance(time.Second)
+	if !b.Allow() || b.Allow() {
+		t.Error("expect 1 token after 1s at new rate")
+	}
+}
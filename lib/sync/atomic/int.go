@@ -0,0 +1,87 @@
+package atomic
+
+import "sync/atomic"
+
+// Int32 is an int32 value, all actions of it is atomic
+type Int32 int32
+
+// Load reads the value atomically
+func (i *Int32) Load() int32 {
+	return atomic.LoadInt32((*int32)(i))
+}
+
+// Store writes the value atomically
+func (i *Int32) Store(v int32) {
+	atomic.StoreInt32((*int32)(i), v)
+}
+
+// Add adds delta to the value and returns the new value
+func (i *Int32) Add(delta int32) int32 {
+	return atomic.AddInt32((*int32)(i), delta)
+}
+
+// CompareAndSwap sets the value to new if it equals old, returns whether swapped
+func (i *Int32) CompareAndSwap(old, new int32) bool {
+	return atomic.CompareAndSwapInt32((*int32)(i), old, new)
+}
+
+// Int64 is an int64 value, all actions of it is atomic.
+// On 32-bit platforms it must be 64-bit aligned, e.g. be the first field of a struct
+type Int64 int64
+
+// Load reads the value atomically
+func (i *Int64) Load() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
+// Store writes the value atomically
+func (i *Int64) Store(v int64) {
+	atomic.StoreInt64((*int64)(i), v)
+}
+
+// Add adds delta to the value and returns the new value
+func (i *Int64) Add(delta int64) int64 {
+	return atomic.AddInt64((*int64)(i), delta)
+}
+
+// CompareAndSwap sets the value to new if it equals old, returns whether swapped
+func (i *Int64) CompareAndSwap(old, new int64) bool {
+	return atomic.CompareAndSwapInt64((*int64)(i), old, new)
+}
+
+// Uint64 is an uint64 value, all actions of it is atomic.
+// On 32-bit platforms it must be 64-bit aligned, e.g. be the first field of a struct
+type Uint64 uint64
+
+// Load reads the value atomically
+func (u *Uint64) Load() uint64 {
+	return atomic.LoadUint64((*uint64)(u))
+}
+
+// Store writes the value atomically
+func (u *Uint64) Store(v uint64) {
+	atomic.StoreUint64((*uint64)(u), v)
+}
+
+// Add adds delta to the value and returns the new value
+func (u *Uint64) Add(delta uint64) uint64 {
+	return atomic.AddUint64((*uint64)(u), delta)
+}
+
+// CompareAndSwap sets the value to new if it equals old, returns whether swapped
+func (u *Uint64) CompareAndSwap(old, new uint64) bool {
+	return atomic.CompareAndSwapUint64((*uint64)(u), old, new)
+}
+
+// StoreMax sets the value to v if v is greater, returns the max value
+func (u *Uint64) StoreMax(v uint64) uint64 {
+	for {
+		current := u.Load()
+		if v <= current {
+			return current
+		}
+		if u.CompareAndSwap(current, v) {
+			return v
+		}
+	}
+}
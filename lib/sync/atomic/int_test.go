@@ -0,0 +1,47 @@
+package atomic
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestInt64(t *testing.T) {
+	var i Int64
+	var wg sync.WaitGroup
+	for j := 0; j < 100; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			i.Add(2)
+		}()
+	}
+	wg.Wait()
+	if i.Load() != 200 {
+		t.Errorf("expect 200, actual %d", i.Load())
+	}
+	if i.CompareAndSwap(1, 2) || !i.CompareAndSwap(200, -1) || i.Load() != -1 {
+		t.Error("wrong CompareAndSwap")
+	}
+	i.Store(5)
+	if i.Add(-10) != -5 {
+		t.Error("wrong Add")
+	}
+}
+
+func TestInt32(t *testing.T) {
+	var i Int32
+	if i.Add(3) != 3 || !i.CompareAndSwap(3, 0) || i.Load() != 0 {
+		t.Error("wrong Int32")
+	}
+}
+
+func TestUint64StoreMax(t *testing.T) {
+	var u Uint64
+	u.Store(10)
+	if u.StoreMax(5) != 10 || u.Load() != 10 {
+		t.Error("smaller value should be ignored")
+	}
+	if u.StoreMax(20) != 20 || u.Load() != 20 {
+		t.Error("greater value should be stored")
+	}
+}
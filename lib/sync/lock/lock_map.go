@@ -9,13 +9,16 @@ const (
 	prime32 = uint32(16777619)
 )
 
-// Locks provides rw locks for key
+// Locks provides rw locks for string keys, keys are hashed into a fixed table of locks (striped lock),
+// so that memory usage does not grow with keys. Keys sharing a lock block each other.
+// Use Locks/UnLocks, RLocks/RUnLocks or RWLocks/RWUnLocks to lock multiple keys, they acquire locks in order to avoid deadlock.
 type Locks struct {
 	table []*sync.RWMutex
 }
 
-// Make creates a new lock map
+// Make creates a new lock map, tableSize is rounded up to a power of 2
 func Make(tableSize int) *Locks {
+	tableSize = computeCapacity(tableSize)
 	table := make([]*sync.RWMutex, tableSize)
 	for i := 0; i < tableSize; i++ {
 		table[i] = &sync.RWMutex{}
@@ -25,6 +28,14 @@ func Make(tableSize int) *Locks {
 	}
 }
 
+func computeCapacity(param int) int {
+	size := 1
+	for size < param && size < 1<<30 {
+		size <<= 1
+	}
+	return size
+}
+
 func fnv32(key string) uint32 {
 	hash := uint32(2166136261)
 	for i := 0; i < len(key); i++ {
@@ -36,7 +47,7 @@ func fnv32(key string) uint32 {
 
 func (locks *Locks) spread(hashCode uint32) uint32 {
 	if locks == nil {
-		panic("dict is nil")
+		panic("locks is nil")
 	}
 	tableSize := uint32(len(locks.table))
 	return (tableSize - 1) & hashCode
@@ -129,7 +140,9 @@ func (locks *Locks) RUnLocks(keys ...string) {
 
 // RWLocks locks write keys and read keys together. allow duplicate keys
 func (locks *Locks) RWLocks(writeKeys []string, readKeys []string) {
-	keys := append(writeKeys, readKeys...)
+	keys := make([]string, 0, len(writeKeys)+len(readKeys))
+	keys = append(keys, writeKeys...)
+	keys = append(keys, readKeys...)
 	indices := locks.toLockIndices(keys, false)
 	writeIndexSet := make(map[uint32]struct{})
 	for _, wKey := range writeKeys {
@@ -149,7 +162,9 @@ func (locks *Locks) RWLocks(writeKeys []string, readKeys []string) {
 
 // RWUnLocks unlocks write keys and read keys together. allow duplicate keys
 func (locks *Locks) RWUnLocks(writeKeys []string, readKeys []string) {
-	keys := append(writeKeys, readKeys...)
+	keys := make([]string, 0, len(writeKeys)+len(readKeys))
+	keys = append(keys, writeKeys...)
+	keys = append(keys, readKeys...)
 	indices := locks.toLockIndices(keys, true)
 	writeIndexSet := make(map[uint32]struct{})
 	for _, wKey := range writeKeys {
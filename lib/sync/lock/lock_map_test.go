@@ -0,0 +1,71 @@
+package lock
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMake(t *testing.T) {
+	for param, expected := range map[int]int{0: 1, 1: 1, 3: 4, 16: 16, 17: 32} {
+		if size := len(Make(param).table); size != expected {
+			t.Errorf("table size of %d: expect %d, actual %d", param, expected, size)
+		}
+	}
+}
+
+func TestLocksNoDeadlock(t *testing.T) {
+	locks := Make(8)
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	counter := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// lock overlapping keys in different orders
+			writeKeys := []string{keys[i%20], keys[(i*7)%20]}
+			readKeys := []string{keys[(i+3)%20], keys[i%20]}
+			locks.RWLocks(writeKeys, readKeys)
+			locks.RWUnLocks(writeKeys, readKeys)
+			// keys[0] is shared by all goroutines to protect counter
+			reversed := []string{keys[19-i%20], keys[i%20], keys[0]}
+			locks.Locks(reversed...)
+			counter++
+			locks.UnLocks(reversed...)
+		}(i)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("deadlock")
+	}
+	if counter != 50 {
+		t.Errorf("expect 50, actual %d", counter)
+	}
+}
+
+func TestRWLocksKeepsArgs(t *testing.T) {
+	locks := Make(16)
+	writeKeys := make([]string, 1, 4)
+	writeKeys[0] = "a"
+	readKeys := []string{"b"}
+	locks.RWLocks(writeKeys, readKeys)
+	locks.RWUnLocks(writeKeys, readKeys)
+	if writeKeys[:2][1] != "" {
+		t.Error("write keys should not be modified")
+	}
+	locks.RLock("a")
+	locks.RLock("a")
+	locks.RUnLock("a")
+	locks.RUnLock("a")
+}
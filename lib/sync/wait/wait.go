@@ -1,6 +1,7 @@
 package wait
 
 import (
+	"context"
 	"sync"
 	"time"
 )
@@ -28,16 +29,23 @@ func (w *Wait) Wait() {
 // WaitWithTimeout blocks until the WaitGroup counter is zero or timeout
 // returns true if timeout
 func (w *Wait) WaitWithTimeout(timeout time.Duration) bool {
-	c := make(chan struct{}, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return w.WaitWithContext(ctx) != nil
+}
+
+// WaitWithContext blocks until the WaitGroup counter is zero or ctx is done
+// returns ctx.Err() if ctx is done before the counter reaches zero
+func (w *Wait) WaitWithContext(ctx context.Context) error {
+	c := make(chan struct{})
 	go func() {
 		defer close(c)
 		w.Wait()
-		c <- struct{}{}
 	}()
 	select {
 	case <-c:
-		return false // completed normally
-	case <-time.After(timeout):
-		return true // timed out
+		return nil // completed normally
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
@@ -0,0 +1,31 @@
+package wait
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWaitWithContext(t *testing.T) {
+	w := &Wait{}
+	w.Add(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := w.WaitWithContext(ctx); err != context.Canceled {
+		t.Errorf("expect canceled, actual %v", err)
+	}
+	if !w.WaitWithTimeout(10 * time.Millisecond) {
+		t.Error("expect timeout")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		w.Done()
+	}()
+	if err := w.WaitWithContext(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if w.WaitWithTimeout(time.Second) {
+		t.Error("expect no timeout")
+	}
+}
@@ -1,24 +1,52 @@
 package timewheel
 
-import "time"
+import (
+	"sync/atomic"
+	"time"
 
-var tw = New(time.Second, 3600)
+	"github.com/hdt3213/godis/lib/clock"
+)
+
+// tw is shared by key expiration and blocking command timeouts, 600 slots of level 0 cover a minute.
+// It follows the real clock, tests driven by a fake clock replace it by Set with a wheel of their own
+var tw atomic.Value
+
+type holder struct {
+	tw *TimeWheel
+}
 
 func init() {
-	tw.Start()
+	w := NewWithClock(100*time.Millisecond, 600, clock.Real)
+	w.Start()
+	tw.Store(holder{w})
+}
+
+func current() *TimeWheel {
+	return tw.Load().(holder).tw
+}
+
+// Set replaces the time wheel used by package level functions, it returns a function to restore the previous one.
+// The given wheel should be started by caller, and jobs pending in it are abandoned after restoring
+func Set(w *TimeWheel) (restore func()) {
+	prev := current()
+	tw.Store(holder{w})
+	return func() {
+		tw.Store(holder{prev})
+	}
 }
 
 // Delay executes job after waiting the given duration
 func Delay(duration time.Duration, key string, job func()) {
-	tw.AddJob(duration, key, job)
+	current().AddJob(duration, key, job)
 }
 
 // At executes job at given time
 func At(at time.Time, key string, job func()) {
-	tw.AddJob(at.Sub(time.Now()), key, job)
+	w := current()
+	w.AddJob(at.Sub(w.now()), key, job)
 }
 
 // Cancel stops a pending job
 func Cancel(key string) {
-	tw.RemoveJob(key)
+	current().RemoveJob(key)
 }
@@ -2,67 +2,122 @@ package timewheel
 
 import (
 	"container/list"
-	"github.com/hdt3213/godis/lib/logger"
-	"sync"
 	"time"
+
+	"github.com/hdt3213/godis/lib/clock"
+	"github.com/hdt3213/godis/lib/logger"
 )
 
 type location struct {
+	level int
 	slot  int
 	etask *list.Element
 }
 
-// TimeWheel can execute jobs after a given delay
+// TimeWheel can execute jobs after a given delay.
+// It is a hierarchical timing wheel: slots of level 0 last one interval, and each slot of level n lasts as long as
+// a whole round of level n-1. Jobs far in the future are kept in upper levels and cascaded down when their slot
+// comes, so that a tick only touches jobs which are due instead of scanning every pending job.
+// Ticks are counted by the clock given to NewWithClock, or by the default clock if created by New.
 type TimeWheel struct {
 	interval time.Duration
-	ticker   *time.Ticker
-	slots    []*list.List
+	clock    clock.Clock // nil means the default clock
+	ticker   clock.Ticker
+	levels   []*level
+	slotNum  int
+	// ticks is the last handled tick, deadlines of tasks are counted in ticks since unix epoch
+	ticks int64
 
 	timer             map[string]*location
-	currentPos        int
-	slotNum           int
 	addTaskChannel    chan task
 	removeTaskChannel chan string
 	stopChannel       chan bool
+}
 
-	mu sync.RWMutex
+type level struct {
+	// span is the number of ticks covered by a slot of this level
+	span  int64
+	slots []*list.List
 }
 
 type task struct {
-	delay  time.Duration
-	circle int
-	key    string
-	job    func()
+	delay    time.Duration
+	deadline int64
+	key      string
+	job      func()
 }
 
-// New creates a new time wheel
+// New creates a new time wheel following the default clock
 func New(interval time.Duration, slotNum int) *TimeWheel {
-	if interval <= 0 || slotNum <= 0 {
+	return NewWithClock(interval, slotNum, nil)
+}
+
+// NewWithClock creates a new time wheel driven by the given clock, tests use it with a fake clock
+// so that jobs run when the fake clock is advanced
+func NewWithClock(interval time.Duration, slotNum int, c clock.Clock) *TimeWheel {
+	if interval <= 0 || slotNum <= 1 {
 		return nil
 	}
 	tw := &TimeWheel{
 		interval:          interval,
-		slots:             make([]*list.List, slotNum),
-		timer:             make(map[string]*location),
-		currentPos:        0,
+		clock:             c,
 		slotNum:           slotNum,
+		timer:             make(map[string]*location),
 		addTaskChannel:    make(chan task),
 		removeTaskChannel: make(chan string),
 		stopChannel:       make(chan bool),
 	}
-	tw.initSlots()
+	tw.ticks = tw.currentTick()
+	tw.addLevel()
 	return tw
 }
 
-func (tw *TimeWheel) initSlots() {
-	for i := 0; i < tw.slotNum; i++ {
-		tw.slots[i] = list.New()
+func (tw *TimeWheel) now() time.Time {
+	if tw.clock != nil {
+		return tw.clock.Now()
+	}
+	return clock.Now()
+}
+
+// currentTick returns the tick of now, which is counted since unix epoch
+func (tw *TimeWheel) currentTick() int64 {
+	return tw.now().UnixNano() / int64(tw.interval)
+}
+
+// resync moves ticks back if the clock went back, e.g. a fake clock is restored,
+// otherwise new tasks would be scheduled against the stale ticks and wait for the lost time.
+// Pending jobs wait until the clock reaches their deadlines again.
+func (tw *TimeWheel) resync() int64 {
+	current := tw.currentTick()
+	if current < tw.ticks {
+		tw.ticks = current
 	}
+	return current
+}
+
+// addLevel appends a level above the current top level
+func (tw *TimeWheel) addLevel() {
+	span := int64(1)
+	if n := len(tw.levels); n > 0 {
+		span = tw.levels[n-1].span * int64(tw.slotNum)
+	}
+	l := &level{
+		span:  span,
+		slots: make([]*list.List, tw.slotNum),
+	}
+	for i := range l.slots {
+		l.slots[i] = list.New()
+	}
+	tw.levels = append(tw.levels, l)
 }
 
 // Start starts the time wheel
 func (tw *TimeWheel) Start() {
-	tw.ticker = time.NewTicker(tw.interval)
+	if tw.clock != nil {
+		tw.ticker = tw.clock.NewTicker(tw.interval)
+	} else {
+		tw.ticker = clock.Real.NewTicker(tw.interval)
+	}
 	go tw.start()
 }
 
@@ -91,7 +146,7 @@ func (tw *TimeWheel) RemoveJob(key string) {
 func (tw *TimeWheel) start() {
 	for {
 		select {
-		case <-tw.ticker.C:
+		case <-tw.ticker.Chan():
 			tw.tickHandler()
 		case task := <-tw.addTaskChannel:
 			tw.addTask(&task)
@@ -104,97 +159,102 @@ func (tw *TimeWheel) start() {
 	}
 }
 
+// tickHandler catches up with the clock, a tick of the ticker may be dropped or the clock may jump forward
 func (tw *TimeWheel) tickHandler() {
-	tw.mu.Lock()
-	l := tw.slots[tw.currentPos]
-	if tw.currentPos == tw.slotNum-1 {
-		tw.currentPos = 0
-	} else {
-		tw.currentPos++
+	current := tw.resync()
+	for tw.ticks < current {
+		tw.tick()
 	}
-	tw.mu.Unlock()
-
-	go tw.scanAndRunTask(l)
 }
 
-func (tw *TimeWheel) scanAndRunTask(l *list.List) {
-	var tasksToRemove []string
-	tw.mu.RLock() // Read lock for accessing the list
-	for e := l.Front(); e != nil; {
-		task := e.Value.(*task)
-		if task.circle > 0 {
-			task.circle--
-			e = e.Next()
+func (tw *TimeWheel) tick() {
+	tw.ticks++
+	// cascade from the top, so that tasks moved down could be cascaded again by lower levels in the same tick
+	for i := len(tw.levels) - 1; i > 0; i-- {
+		l := tw.levels[i]
+		if tw.ticks%l.span != 0 {
 			continue
 		}
-
-		go func(job func()) {
-			defer func() {
-				if err := recover(); err != nil {
-					logger.Error(err)
-				}
-			}()
-			job()
-		}(task.job)
-
-		if task.key != "" {
-			tasksToRemove = append(tasksToRemove, task.key)
+		slot := l.slots[tw.slotIndex(l, tw.ticks)]
+		for e := slot.Front(); e != nil; e = slot.Front() {
+			slot.Remove(e)
+			tw.place(e.Value.(*task))
 		}
-		next := e.Next()
-		l.Remove(e) // Safe as this is a local operation
-		e = next
 	}
-	tw.mu.RUnlock()
-
-	// Remove tasks from the timer after the scan
-	tw.mu.Lock()
-	for _, key := range tasksToRemove {
-		delete(tw.timer, key)
+	slot := tw.levels[0].slots[tw.slotIndex(tw.levels[0], tw.ticks)]
+	for e := slot.Front(); e != nil; e = slot.Front() {
+		slot.Remove(e)
+		t := e.Value.(*task)
+		if t.deadline > tw.ticks {
+			// the slot comes earlier than the deadline after the clock went back
+			tw.place(t)
+			continue
+		}
+		if t.key != "" {
+			delete(tw.timer, t.key)
+		}
+		go runJob(t.job)
 	}
-	tw.mu.Unlock()
 }
 
-func (tw *TimeWheel) addTask(task *task) {
-	pos, circle := tw.getPositionAndCircle(task.delay)
-	task.circle = circle
+func runJob(job func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(err)
+		}
+	}()
+	job()
+}
 
-	tw.mu.Lock()
-	defer tw.mu.Unlock()
+func (tw *TimeWheel) slotIndex(l *level, deadline int64) int {
+	return int(deadline / l.span % int64(tw.slotNum))
+}
 
+func (tw *TimeWheel) addTask(task *task) {
 	if task.key != "" {
-		if _, ok := tw.timer[task.key]; ok {
-			tw.removeTaskInternal(task.key) // Internal version avoids double lock
-		}
+		tw.removeTask(task.key)
 	}
-
-	e := tw.slots[pos].PushBack(task)
-	loc := &location{
-		slot:  pos,
-		etask: e,
+	tw.resync()
+	// a tick is handled once the clock reaches its end, so that the job never runs before delay
+	at := tw.now().Add(task.delay).UnixNano()
+	task.deadline = (at + int64(tw.interval) - 1) / int64(tw.interval)
+	if task.deadline <= tw.ticks {
+		task.deadline = tw.ticks + 1
 	}
-	tw.timer[task.key] = loc
+	tw.place(task)
 }
 
-func (tw *TimeWheel) getPositionAndCircle(d time.Duration) (pos int, circle int) {
-	delaySeconds := int(d.Seconds())
-	intervalSeconds := int(tw.interval.Seconds())
-	circle = delaySeconds / intervalSeconds / tw.slotNum
-	pos = (tw.currentPos + delaySeconds/intervalSeconds) % tw.slotNum
-	return
+// place puts task into the lowest level which could hold its deadline
+func (tw *TimeWheel) place(task *task) {
+	i := 0
+	for {
+		if i == len(tw.levels) {
+			tw.addLevel()
+		}
+		l := tw.levels[i]
+		// a task whose deadline has come is kept in the current slot of level 0, which runs right after cascading
+		if task.deadline/l.span-tw.ticks/l.span < int64(tw.slotNum) {
+			break
+		}
+		i++
+	}
+	l := tw.levels[i]
+	slot := tw.slotIndex(l, task.deadline)
+	e := l.slots[slot].PushBack(task)
+	if task.key != "" {
+		tw.timer[task.key] = &location{
+			level: i,
+			slot:  slot,
+			etask: e,
+		}
+	}
 }
 
 func (tw *TimeWheel) removeTask(key string) {
-	tw.mu.Lock()
-	defer tw.mu.Unlock()
-	tw.removeTaskInternal(key)
-}
-
-func (tw *TimeWheel) removeTaskInternal(key string) {
-	pos, ok := tw.timer[key]
+	loc, ok := tw.timer[key]
 	if !ok {
 		return
 	}
-	l := tw.slots[pos.slot]
-	l.Remove(pos.etask)
+	tw.levels[loc.level].slots[loc.slot].Remove(loc.etask)
 	delete(tw.timer, key)
 }
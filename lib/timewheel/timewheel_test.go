@@ -5,6 +5,8 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/hdt3213/godis/lib/clock"
 )
 
 func TestTimeWheelConcurrency(t *testing.T) {
@@ -120,3 +122,122 @@ func TestTimeWheelConcurrentAddRunRemove(t *testing.T) {
 	wg.Wait()
 	fmt.Println("Concurrent Add, Run, and Remove Test completed successfully.")
 }
+
+func TestTimeWheelCascade(t *testing.T) {
+	// drive ticks by a fake clock, level 0 covers 4 ticks and level 1 covers 16 ticks
+	fake := clock.NewFake(time.Unix(1<<20, 0))
+	defer clock.Set(fake)()
+	tw := New(time.Second, 4)
+	fired := make(chan string, 10)
+	for _, delay := range []int{0, 3, 5, 17, 40} {
+		key := fmt.Sprintf("job-%d", delay)
+		tw.addTask(&task{delay: time.Duration(delay) * time.Second, key: key, job: func() { fired <- key }})
+	}
+	tw.removeTask("job-17")
+	if len(tw.levels) != 3 {
+		t.Errorf("expect 3 levels, actual %d", len(tw.levels))
+	}
+	expected := map[int]string{1: "job-0", 3: "job-3", 5: "job-5", 40: "job-40"}
+	for i := 1; i < 50; i++ {
+		fake.Advance(time.Second)
+		tw.tickHandler()
+		key, ok := expected[i]
+		if !ok {
+			continue
+		}
+		select {
+		case actual := <-fired:
+			if actual != key {
+				t.Errorf("expect %s at second %d, actual %s", key, i, actual)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("expect %s at second %d", key, i)
+		}
+	}
+	if len(fired) != 0 || len(tw.timer) != 0 {
+		t.Error("unexpected jobs")
+	}
+}
+
+func TestTimeWheelCatchUp(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	defer clock.Set(fake)()
+	tw := New(100*time.Millisecond, 10)
+	fired := make(chan struct{})
+	tw.addTask(&task{delay: time.Hour, key: "job", job: func() { close(fired) }})
+	fake.Advance(time.Hour - time.Second)
+	tw.tickHandler()
+	if _, ok := tw.timer["job"]; !ok {
+		t.Error("job should not run before delay")
+	}
+	// the deadline is rounded up to a tick
+	fake.Advance(time.Second + 100*time.Millisecond)
+	tw.tickHandler()
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Error("job should run once the clock jumps over its deadline")
+	}
+}
+
+func TestTimeWheelClockGoesBack(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	restore := clock.Set(fake)
+	defer restore()
+	tw := New(time.Second, 4)
+	fired := make(chan struct{})
+	tw.addTask(&task{delay: 10 * time.Second, key: "job", job: func() { close(fired) }})
+
+	// go back by 2 seconds, level 0 slot of the job comes 2 seconds before its deadline
+	fake.Advance(-2 * time.Second)
+	for i := 0; i < 11; i++ {
+		fake.Advance(time.Second)
+		tw.tickHandler()
+	}
+	if _, ok := tw.timer["job"]; !ok {
+		t.Error("job should not run before deadline")
+	}
+	fake.Advance(2 * time.Second)
+	tw.tickHandler()
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Error("job should run at deadline")
+	}
+}
+
+func TestTimeWheelResyncOnAdd(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	tw := NewWithClock(time.Second, 4, fake)
+	fake.Advance(100 * time.Second)
+	tw.tickHandler()
+	// the clock is restored before next tick, new tasks must not be scheduled against stale ticks
+	fake.Advance(-100 * time.Second)
+	fired := make(chan struct{})
+	tw.addTask(&task{delay: 2 * time.Second, key: "job", job: func() { close(fired) }})
+	// the deadline is rounded up to a tick
+	fake.Advance(3 * time.Second)
+	tw.tickHandler()
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Error("job should run after its delay")
+	}
+}
+
+func TestTimeWheelWithFakeClock(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	tw := NewWithClock(time.Second, 60, fake)
+	tw.Start()
+	defer tw.Stop()
+	defer Set(tw)()
+	fired := make(chan struct{})
+	Delay(time.Hour, "job", func() { close(fired) })
+	// ticker of the fake clock drives the wheel
+	fake.Advance(time.Hour + time.Second)
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Error("job should run once the fake clock passes its deadline")
+	}
+}
@@ -0,0 +1,51 @@
+// Package tracing creates OpenTelemetry spans for commands. Spans are exported by the global TracerProvider, which
+// does nothing unless applications embedding godis install one by otel.SetTracerProvider
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/hdt3213/godis"
+
+// RelayCommand wraps commands relayed to other nodes in cluster: `trace.relay <traceparent> <command> [args...]`,
+// so that spans of peers are children of the relaying span
+const RelayCommand = "trace.relay"
+
+var propagator = propagation.TraceContext{}
+
+// Tracer returns the tracer of godis from the global TracerProvider
+func Tracer() trace.Tracer {
+	return otel.GetTracerProvider().Tracer(instrumentationName)
+}
+
+// WrapCmdLine wraps cmdLine with traceparent of the span in ctx, cmdLine is returned as is if ctx has no span
+func WrapCmdLine(ctx context.Context, cmdLine [][]byte) [][]byte {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return cmdLine
+	}
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	traceParent := carrier.Get("traceparent")
+	if traceParent == "" {
+		return cmdLine
+	}
+	wrapped := make([][]byte, 0, len(cmdLine)+2)
+	wrapped = append(wrapped, []byte(RelayCommand), []byte(traceParent))
+	return append(wrapped, cmdLine...)
+}
+
+// UnwrapCmdLine returns the relayed command and ctx with the remote span as parent if cmdLine is wrapped by WrapCmdLine,
+// otherwise ctx and cmdLine are returned as is
+func UnwrapCmdLine(ctx context.Context, cmdLine [][]byte) (context.Context, [][]byte) {
+	if len(cmdLine) < 3 || !strings.EqualFold(string(cmdLine[0]), RelayCommand) {
+		return ctx, cmdLine
+	}
+	carrier := propagation.MapCarrier{"traceparent": string(cmdLine[1])}
+	return propagator.Extract(ctx, carrier), cmdLine[2:]
+}
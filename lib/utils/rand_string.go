@@ -1,11 +1,48 @@
 package utils
 
 import (
+	crand "crypto/rand"
+	"encoding/binary"
 	"math/rand"
-	"time"
+	"sync"
 )
 
-var r = rand.New(rand.NewSource(time.Now().UnixNano()))
+// lockedSource makes rand.Source safe for concurrent use, rand.New does not lock its source
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source64
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
+
+// newSeed returns a seed from crypto/rand, so that processes (such as parallel tests) started at the same time
+// do not share the random sequence
+func newSeed() int64 {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return int64(binary.LittleEndian.Uint64(b[:]))
+}
+
+// r is the fast source for non-security uses, it is seeded independently from the global source of math/rand
+var r = rand.New(&lockedSource{src: rand.NewSource(newSeed()).(rand.Source64)})
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
 
 // RandString create a random string no longer than n
@@ -27,13 +64,47 @@ func RandHexString(n int) string {
 	return string(b)
 }
 
+// RandSecureString creates a random string of letters and digits using crypto/rand,
+// it should be used for ids and secrets which must not be predicted, such as run id and node id
+func RandSecureString(n int) string {
+	return randSecure(n, letters)
+}
+
+// RandSecureHexString creates a random hex string using crypto/rand, such as replication id
+func RandSecureHexString(n int) string {
+	return randSecure(n, hexLetters)
+}
+
+// randSecure picks n runes from alphabet uniformly, random bytes out of the largest multiple of len(alphabet)
+// are dropped to avoid modulo bias. len(alphabet) must be no more than 256
+func randSecure(n int, alphabet []rune) string {
+	limit := 256 - 256%len(alphabet)
+	result := make([]rune, 0, n)
+	buf := make([]byte, n+n/4+1)
+	for len(result) < n {
+		if _, err := crand.Read(buf); err != nil {
+			panic(err) // crypto/rand never fails on supported platforms
+		}
+		for _, b := range buf {
+			if int(b) >= limit {
+				continue
+			}
+			result = append(result, alphabet[int(b)%len(alphabet)])
+			if len(result) == n {
+				break
+			}
+		}
+	}
+	return string(result)
+}
+
 // RandIndex returns random indexes to random pick elements from slice
 func RandIndex(size int) []int {
 	result := make([]int, size)
 	for i := range result {
 		result[i] = i
 	}
-	rand.Shuffle(size, func(i, j int) {
+	r.Shuffle(size, func(i, j int) {
 		result[i], result[j] = result[j], result[i]
 	})
 	return result
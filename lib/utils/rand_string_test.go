@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRandSecureString(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		s := RandSecureString(20)
+		if len(s) != 20 {
+			t.Fatalf("expect length 20, actual %d", len(s))
+		}
+		if seen[s] {
+			t.Fatalf("duplicated string %s", s)
+		}
+		seen[s] = true
+	}
+	hex := RandSecureHexString(40)
+	if len(hex) != 40 || strings.Trim(hex, "0123456789abcdef") != "" {
+		t.Errorf("invalid hex string %s", hex)
+	}
+	if RandSecureString(0) != "" {
+		t.Error("expect empty string")
+	}
+}
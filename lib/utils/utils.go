@@ -1,5 +1,10 @@
 package utils
 
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+)
+
 // ToCmdLine convert strings to [][]byte
 func ToCmdLine(cmd ...string) [][]byte {
 	args := make([][]byte, len(cmd))
@@ -58,6 +63,14 @@ func BytesEquals(a []byte, b []byte) bool {
 	return true
 }
 
+// SecureCompare checks whether the given secrets are equal in constant time, so that passwords could not be
+// guessed by timing. Secrets are hashed first, so that the time does not depend on their length either
+func SecureCompare(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
 // ConvertRange converts redis index to go slice index
 // -1 => size-1
 // both inclusive [0, 10] => left inclusive right exclusive [0, 9)
@@ -85,6 +98,31 @@ func ConvertRange(start int64, end int64, size int64) (int, int) {
 	return int(start), int(end)
 }
 
+// ConvertStringRange converts redis index of string to go slice index like GETRANGE does
+// negative index counts from the end, out of bound start is clamped to 0 and end is clamped to size-1
+// returns an empty range [0, 0) if nothing is selected
+func ConvertStringRange(start int64, end int64, size int64) (int, int) {
+	if start < 0 {
+		start = size + start
+	}
+	if end < 0 {
+		end = size + end
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		return 0, 0
+	}
+	if end >= size {
+		end = size - 1
+	}
+	if start > end || size == 0 {
+		return 0, 0
+	}
+	return int(start), int(end + 1)
+}
+
 // RemoveDuplicates removes duplicate byte slices from a 2D byte slice
 func RemoveDuplicates(input [][]byte) [][]byte {
 	uniqueMap := make(map[string]struct{})
@@ -0,0 +1,12 @@
+package utils
+
+import "testing"
+
+func TestSecureCompare(t *testing.T) {
+	if !SecureCompare("secret", "secret") || !SecureCompare("", "") {
+		t.Error("equal secrets should match")
+	}
+	if SecureCompare("secret", "secreT") || SecureCompare("secret", "secret1") || SecureCompare("", "secret") {
+		t.Error("different secrets should not match")
+	}
+}
@@ -2,76 +2,147 @@ package wildcard
 
 import (
 	"errors"
-	"regexp"
-	"strings"
 )
 
-// Pattern represents a wildcard pattern
+// Pattern represents a redis style glob pattern, supports:
+//   - `?` matches any single character
+//   - `*` matches any sequence of characters, including empty sequence
+//   - `[abc]`, `[a-z]` matches a character in the set or range, `[^abc]` matches a character not in it
+//   - `\x` matches character x literally, works inside brackets as well
 type Pattern struct {
-	exp *regexp.Regexp
-}
-
-var replaceMap = map[byte]string{
-	// characters in the wildcard that must be escaped in the regexp
-	'+': `\+`,
-	')': `\)`,
-	'$': `\$`,
-	'.': `\.`,
-	'{': `\{`,
-	'}': `\}`,
-	'|': `\|`,
-	'*': ".*",
-	'?': ".",
+	src    string
+	nocase bool
 }
 
 var errEndWithEscape = "end with escape \\"
 
 // CompilePattern convert wildcard string to Pattern
 func CompilePattern(src string) (*Pattern, error) {
-	regexSrc := strings.Builder{}
-	regexSrc.WriteByte('^')
-	for i := 0; i < len(src); i++ {
-		ch := src[i]
-		if ch == '\\' {
-			if i == len(src)-1 {
-				return nil, errors.New(errEndWithEscape)
-			}
-			regexSrc.WriteByte(ch)
-			regexSrc.WriteByte(src[i+1])
-			i++ // skip escaped character
-		} else if ch == '^' {
-			if i == 0 {
-				regexSrc.WriteString(`\^`)
-			} else if i == 1 {
-				if src[i-1] == '[' {
-					regexSrc.WriteString(`^`) // src is: [^
-				} else {
-					regexSrc.WriteString(`\^`)
-				}
-			} else {
-				if src[i-1] == '[' && src[i-2] != '\\' {
-					regexSrc.WriteString(`^`) // src is: [^, except \[^
-				} else {
-					regexSrc.WriteString(`\^`)
-				}
-			}
-		} else if escaped, toEscape := replaceMap[ch]; toEscape {
-			regexSrc.WriteString(escaped)
-		} else {
-			regexSrc.WriteByte(ch)
-		}
+	// count trailing backslashes, an odd number means the last one escapes nothing
+	n := 0
+	for i := len(src) - 1; i >= 0 && src[i] == '\\'; i-- {
+		n++
 	}
-	regexSrc.WriteByte('$')
-	re, err := regexp.Compile(regexSrc.String())
-	if err != nil {
-		return nil, err
+	if n%2 == 1 {
+		return nil, errors.New(errEndWithEscape)
 	}
 	return &Pattern{
-		exp: re,
+		src: src,
 	}, nil
 }
 
+// CompilePatternNoCase is like CompilePattern but matches case-insensitively
+func CompilePatternNoCase(src string) (*Pattern, error) {
+	p, err := CompilePattern(src)
+	if err != nil {
+		return nil, err
+	}
+	p.nocase = true
+	return p, nil
+}
+
 // IsMatch returns whether the given string matches pattern
 func (p *Pattern) IsMatch(s string) bool {
-	return p.exp.Match([]byte(s))
+	pattern := p.src
+	pi, si := 0, 0
+	// position to retry after the latest star, only the latest one needs backtracking
+	// since other tokens always match exactly one character
+	starPi, starSi := -1, 0
+	for si < len(s) {
+		if pi < len(pattern) && pattern[pi] == '*' {
+			for pi < len(pattern) && pattern[pi] == '*' {
+				pi++
+			}
+			if pi == len(pattern) {
+				return true
+			}
+			starPi, starSi = pi, si
+			continue
+		}
+		if pi < len(pattern) {
+			if ok, next := p.matchToken(pi, s[si]); ok {
+				pi = next
+				si++
+				continue
+			}
+		}
+		if starPi < 0 {
+			return false
+		}
+		// let the star consume one more character
+		starSi++
+		pi, si = starPi, starSi
+	}
+	for pi < len(pattern) && pattern[pi] == '*' {
+		pi++
+	}
+	return pi == len(pattern)
+}
+
+func (p *Pattern) equal(a, b byte) bool {
+	if p.nocase {
+		return toLower(a) == toLower(b)
+	}
+	return a == b
+}
+
+func toLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// matchToken returns whether c matches the token (a character, `?`, escape sequence or bracket expression)
+// starting at pi, and the position of next token
+func (p *Pattern) matchToken(pi int, c byte) (bool, int) {
+	pattern := p.src
+	switch pattern[pi] {
+	case '?':
+		return true, pi + 1
+	case '\\':
+		if pi+1 < len(pattern) {
+			return p.equal(pattern[pi+1], c), pi + 2
+		}
+		return p.equal('\\', c), pi + 1
+	case '[':
+		i := pi + 1
+		not := false
+		if i < len(pattern) && pattern[i] == '^' {
+			not = true
+			i++
+		}
+		matched := false
+		// like redis, an unterminated bracket expression ends at the end of pattern
+		for ; i < len(pattern) && pattern[i] != ']'; i++ {
+			if pattern[i] == '\\' && i+1 < len(pattern) {
+				i++
+				if p.equal(pattern[i], c) {
+					matched = true
+				}
+			} else if i+2 < len(pattern) && pattern[i+1] == '-' {
+				start, end := pattern[i], pattern[i+2]
+				if start > end {
+					start, end = end, start
+				}
+				i += 2
+				if p.nocase {
+					lc := toLower(c)
+					if toLower(start) <= lc && lc <= toLower(end) {
+						matched = true
+					}
+				}
+				if start <= c && c <= end {
+					matched = true
+				}
+			} else if p.equal(pattern[i], c) {
+				matched = true
+			}
+		}
+		if i < len(pattern) {
+			i++ // skip ']'
+		}
+		return matched != not, i
+	}
+	return p.equal(pattern[pi], c), pi + 1
 }
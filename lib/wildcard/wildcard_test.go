@@ -188,3 +188,52 @@ func TestWildCard(t *testing.T) {
 		return
 	}
 }
+
+func TestRedisGlob(t *testing.T) {
+	cases := []struct {
+		pattern string
+		str     string
+		match   bool
+	}{
+		{"*", "", true},
+		{"**a**", "bab", true},
+		{"a*b*c", "aXXbYYc", true},
+		{"a*b*c", "aXXbYY", false},
+		{"*.txt", "a.b.txt", true},
+		{"h?llo", "hllo", false},
+		{"h[z-a]llo", "hbllo", true}, // reversed range
+		{`h[\]]llo`, "h]llo", true},
+		{`h[\-]llo`, "h-llo", true},
+		{`h[\-]llo`, "hallo", false},
+		{"h[^a-c]llo", "hdllo", true},
+		{"h[^a-c]llo", "hbllo", false},
+		{"h[ab", "ha", true}, // unterminated bracket
+		{`a\?`, "a?", true},
+		{`a\?`, "ab", false},
+		{"user:*:name", "user:1:2:name", true},
+		{"user:[0-9]*", "user:x", false},
+		{"*a*a*a*a*a*a*a*a*a*a*b", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", false},
+	}
+	for _, c := range cases {
+		p, err := CompilePattern(c.pattern)
+		if err != nil {
+			t.Errorf("compile %s failed: %v", c.pattern, err)
+			continue
+		}
+		if p.IsMatch(c.str) != c.match {
+			t.Errorf("pattern %s with %s: expect %v", c.pattern, c.str, c.match)
+		}
+	}
+
+	p, err := CompilePatternNoCase("Max*[A-C]")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !p.IsMatch("maxmemory-b") {
+		t.Error("expect true actually false")
+	}
+	if p.IsMatch("maxmemory-d") {
+		t.Error("expect false actually true")
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"os"
 
@@ -28,7 +29,9 @@ var defaultProperties = &config.ServerProperties{
 	AppendOnly:     false,
 	AppendFilename: "",
 	MaxClients:     1000,
-	RunID:          utils.RandString(40),
+	TCPKeepalive:   300,
+	LogLevel:       "notice",
+	RunID:          utils.RandSecureHexString(40),
 }
 
 func fileExists(filename string) bool {
@@ -54,6 +57,15 @@ func main() {
 	} else {
 		config.SetupConfig(configFilename)
 	}
+	if level, err := logger.ParseLevel(config.Properties.LogLevel); err == nil {
+		logger.SetLevel(level)
+	} else {
+		logger.Warn(err)
+	}
+	if err := database.LoadModules(config.Properties.LoadModules); err != nil {
+		logger.Error(err)
+		exit(1)
+	}
 	listenAddr := fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.Port)
 	
 	var err error
@@ -64,13 +76,43 @@ func main() {
 		} else {
 			db = database.NewStandaloneServer()
 		}
-		server := gnet.NewGnetServer(db)
+		db, err = database.WithRenamedCommands(db, config.Properties.RenameCommands)
+		if err != nil {
+			logger.Errorf("rename command failed: %v", err)
+			exit(1)
+		}
+		if config.Properties.TLSPort != 0 {
+			logger.Warn("tls-port is not supported by gnet server, ignored")
+		}
+		server := gnet.NewGnetServer(database.WithTracing(db))
 		err = server.Run(listenAddr)
+	} else if config.Properties.TLSPort != 0 {
+		var tlsConfig *tls.Config
+		tlsConfig, err = config.Properties.ServerTLSConfig()
+		if err != nil {
+			logger.Errorf("load tls config failed: %v", err)
+			exit(1)
+		}
+		if config.Properties.Port == 0 {
+			listenAddr = "" // only tls connections are accepted
+		}
+		tlsAddr := fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.TLSPort)
+		handler := stdserver.MakeHandler()
+		err = stdserver.ServeTLS(listenAddr, tlsAddr, tlsConfig, handler)
 	} else {
 		handler := stdserver.MakeHandler()
 		err = stdserver.Serve(listenAddr, handler)
 	}
 	if err != nil {
 		logger.Errorf("start server failed: %v", err)
+		exit(1)
 	}
+	logger.Info("godis is now ready to exit, bye bye...")
+	_ = logger.Close()
+}
+
+// exit writes pending logs before exiting, since logs are written asynchronously
+func exit(code int) {
+	_ = logger.Close()
+	os.Exit(code)
 }
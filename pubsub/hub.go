@@ -1,8 +1,12 @@
 package pubsub
 
 import (
+	"sync"
+
 	"github.com/hdt3213/godis/datastruct/dict"
-	"github.com/hdt3213/godis/datastruct/lock"
+	"github.com/hdt3213/godis/datastruct/list"
+	"github.com/hdt3213/godis/lib/sync/lock"
+	"github.com/hdt3213/godis/lib/wildcard"
 )
 
 // Hub stores all subscribe relations
@@ -11,6 +15,19 @@ type Hub struct {
 	subs dict.Dict
 	// lock channel
 	subsLocker *lock.Locks
+
+	// shard channel -> list(*Client), locked by subsLocker as well
+	shardSubs dict.Dict
+
+	// pattern -> *patternSubs
+	// publish has to match every pattern, so patterns are stored in a plain map guarded by patternsMu
+	patterns   map[string]*patternSubs
+	patternsMu sync.RWMutex
+}
+
+type patternSubs struct {
+	matcher     *wildcard.Pattern
+	subscribers *list.LinkedList
 }
 
 // MakeHub creates new hub
@@ -18,5 +35,7 @@ func MakeHub() *Hub {
 	return &Hub{
 		subs:       dict.MakeConcurrent(4),
 		subsLocker: lock.Make(16),
+		shardSubs:  dict.MakeConcurrent(4),
+		patterns:   make(map[string]*patternSubs),
 	}
 }
@@ -0,0 +1,80 @@
+package pubsub
+
+import (
+	"strings"
+
+	"github.com/hdt3213/godis/datastruct/dict"
+	"github.com/hdt3213/godis/datastruct/list"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/wildcard"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+// Introspect executes PUBSUB subcommands: CHANNELS, NUMSUB, NUMPAT, SHARDCHANNELS and SHARDNUMSUB
+func Introspect(hub *Hub, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.MakeArgNumErrReply("pubsub")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "channels":
+		if len(args) > 2 {
+			return protocol.MakeArgNumErrReply("pubsub|channels")
+		}
+		return listChannels(hub.subs, args[1:])
+	case "shardchannels":
+		if len(args) > 2 {
+			return protocol.MakeArgNumErrReply("pubsub|shardchannels")
+		}
+		return listChannels(hub.shardSubs, args[1:])
+	case "numsub":
+		return countSubscribers(hub, hub.subs, args[1:])
+	case "shardnumsub":
+		return countSubscribers(hub, hub.shardSubs, args[1:])
+	case "numpat":
+		if len(args) != 1 {
+			return protocol.MakeArgNumErrReply("pubsub|numpat")
+		}
+		hub.patternsMu.RLock()
+		defer hub.patternsMu.RUnlock()
+		return protocol.MakeIntReply(int64(len(hub.patterns)))
+	}
+	return protocol.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try PUBSUB HELP.")
+}
+
+// listChannels returns active channels, channel without subscriber has been removed from subs
+func listChannels(subs dict.Dict, args [][]byte) redis.Reply {
+	var matcher *wildcard.Pattern
+	if len(args) > 0 {
+		var err error
+		matcher, err = wildcard.CompilePattern(string(args[0]))
+		if err != nil {
+			return protocol.MakeEmptyMultiBulkReply()
+		}
+	}
+	channels := make([][]byte, 0)
+	subs.ForEach(func(channel string, val interface{}) bool {
+		if matcher == nil || matcher.IsMatch(channel) {
+			channels = append(channels, []byte(channel))
+		}
+		return true
+	})
+	return protocol.MakeMultiBulkReply(channels)
+}
+
+// countSubscribers returns channels and count of their subscribers in turn
+func countSubscribers(hub *Hub, subs dict.Dict, args [][]byte) redis.Reply {
+	result := make([]redis.Reply, 0, len(args)*2)
+	for _, arg := range args {
+		channel := string(arg)
+		count := 0
+		hub.subsLocker.RLock(channel)
+		if raw, ok := subs.Get(channel); ok {
+			subscribers, _ := raw.(*list.LinkedList)
+			count = subscribers.Len()
+		}
+		hub.subsLocker.RUnLock(channel)
+		result = append(result, protocol.MakeBulkReply(arg), protocol.MakeIntReply(int64(count)))
+	}
+	return protocol.MakeMultiRawReply(result)
+}
@@ -1,18 +1,24 @@
 package pubsub
 
 import (
+	"github.com/hdt3213/godis/datastruct/dict"
 	"github.com/hdt3213/godis/datastruct/list"
 	"github.com/hdt3213/godis/interface/redis"
 	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/lib/wildcard"
 	"github.com/hdt3213/godis/redis/protocol"
 	"strconv"
 )
 
 var (
-	_subscribe         = "subscribe"
-	_unsubscribe       = "unsubscribe"
-	messageBytes       = []byte("message")
-	unSubscribeNothing = []byte("*3\r\n$11\r\nunsubscribe\r\n$-1\n:0\r\n")
+	_subscribe          = "subscribe"
+	_unsubscribe        = "unsubscribe"
+	_psubscribe         = "psubscribe"
+	_punsubscribe       = "punsubscribe"
+	messageBytes        = []byte("message")
+	pmessageBytes       = []byte("pmessage")
+	unSubscribeNothing  = []byte("*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n")
+	punSubscribeNothing = []byte("*3\r\n$12\r\npunsubscribe\r\n$-1\r\n:0\r\n")
 )
 
 func makeMsg(t string, channel string, code int64) []byte {
@@ -27,15 +33,27 @@ func makeMsg(t string, channel string, code int64) []byte {
  */
 func subscribe0(hub *Hub, channel string, client redis.Connection) bool {
 	client.Subscribe(channel)
+	return addSubscriber(hub.subs, channel, client)
+}
 
-	// add into hub.subs
-	raw, ok := hub.subs.Get(channel)
+/*
+ * invoker should lock channel
+ * return: is actually un-subscribe
+ */
+func unsubscribe0(hub *Hub, channel string, client redis.Connection) bool {
+	client.UnSubscribe(channel)
+	return removeSubscriber(hub.subs, channel, client)
+}
+
+// addSubscriber adds client into subscribers of channel, returns false if it has subscribed
+func addSubscriber(subs dict.Dict, channel string, client redis.Connection) bool {
+	raw, ok := subs.Get(channel)
 	var subscribers *list.LinkedList
 	if ok {
 		subscribers, _ = raw.(*list.LinkedList)
 	} else {
 		subscribers = list.Make()
-		hub.subs.Put(channel, subscribers)
+		subs.Put(channel, subscribers)
 	}
 	if subscribers.Contains(func(a interface{}) bool {
 		return a == client
@@ -46,15 +64,9 @@ func subscribe0(hub *Hub, channel string, client redis.Connection) bool {
 	return true
 }
 
-/*
- * invoker should lock channel
- * return: is actually un-subscribe
- */
-func unsubscribe0(hub *Hub, channel string, client redis.Connection) bool {
-	client.UnSubscribe(channel)
-
-	// remove from hub.subs
-	raw, ok := hub.subs.Get(channel)
+// removeSubscriber removes client from subscribers of channel, returns false if the channel has no subscriber
+func removeSubscriber(subs dict.Dict, channel string, client redis.Connection) bool {
+	raw, ok := subs.Get(channel)
 	if ok {
 		subscribers, _ := raw.(*list.LinkedList)
 		subscribers.RemoveAllByVal(func(a interface{}) bool {
@@ -63,13 +75,79 @@ func unsubscribe0(hub *Hub, channel string, client redis.Connection) bool {
 
 		if subscribers.Len() == 0 {
 			// clean
-			hub.subs.Remove(channel)
+			subs.Remove(channel)
 		}
 		return true
 	}
 	return false
 }
 
+// publish0 sends message to subscribers of channel, returns the number of receivers
+func publish0(subs dict.Dict, msgType []byte, channel string, message []byte) int {
+	raw, ok := subs.Get(channel)
+	if !ok {
+		return 0
+	}
+	subscribers, _ := raw.(*list.LinkedList)
+	msg := protocol.MakeMultiBulkReply([][]byte{
+		msgType,
+		[]byte(channel),
+		message,
+	}).ToBytes()
+	subscribers.ForEach(func(i int, c interface{}) bool {
+		client, _ := c.(redis.Connection)
+		_, _ = client.Write(msg)
+		return true
+	})
+	return subscribers.Len()
+}
+
+/*
+ * invoker should hold hub.patternsMu
+ * return: is new subscribed
+ */
+func psubscribe0(hub *Hub, pattern string, client redis.Connection) bool {
+	client.PSubscribe(pattern)
+
+	subs, ok := hub.patterns[pattern]
+	if !ok {
+		// illegal pattern gets a nil matcher and matches nothing, but the subscription is still recorded like redis does
+		matcher, _ := wildcard.CompilePattern(pattern)
+		subs = &patternSubs{
+			matcher:     matcher,
+			subscribers: list.Make(),
+		}
+		hub.patterns[pattern] = subs
+	}
+	if subs.subscribers.Contains(func(a interface{}) bool {
+		return a == client
+	}) {
+		return false
+	}
+	subs.subscribers.Add(client)
+	return true
+}
+
+/*
+ * invoker should hold hub.patternsMu
+ * return: is actually un-subscribe
+ */
+func punsubscribe0(hub *Hub, pattern string, client redis.Connection) bool {
+	client.PUnSubscribe(pattern)
+
+	subs, ok := hub.patterns[pattern]
+	if !ok {
+		return false
+	}
+	subs.subscribers.RemoveAllByVal(func(a interface{}) bool {
+		return utils.Equals(a, client)
+	})
+	if subs.subscribers.Len() == 0 {
+		delete(hub.patterns, pattern)
+	}
+	return true
+}
+
 // Subscribe puts the given connection into the given channel
 func Subscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
 	channels := make([]string, len(args))
@@ -88,8 +166,10 @@ func Subscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
 	return &protocol.NoReply{}
 }
 
-// UnsubscribeAll removes the given connection from all subscribing channel
+// UnsubscribeAll removes the given connection from all subscribing channels, patterns and shard channels
 func UnsubscribeAll(hub *Hub, c redis.Connection) {
+	sUnsubscribeAll(hub, c)
+
 	channels := c.GetChannels()
 
 	hub.subsLocker.Locks(channels...)
@@ -99,6 +179,56 @@ func UnsubscribeAll(hub *Hub, c redis.Connection) {
 		unsubscribe0(hub, channel, c)
 	}
 
+	patterns := c.GetPatterns()
+	if len(patterns) == 0 {
+		return
+	}
+	hub.patternsMu.Lock()
+	defer hub.patternsMu.Unlock()
+	for _, pattern := range patterns {
+		punsubscribe0(hub, pattern, c)
+	}
+}
+
+// PSubscribe puts the given connection into subscribers of the given patterns
+func PSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	hub.patternsMu.Lock()
+	defer hub.patternsMu.Unlock()
+
+	for _, arg := range args {
+		pattern := string(arg)
+		if psubscribe0(hub, pattern, c) {
+			_, _ = c.Write(makeMsg(_psubscribe, pattern, int64(c.SubsCount())))
+		}
+	}
+	return &protocol.NoReply{}
+}
+
+// PUnSubscribe removes the given connection from subscribers of the given patterns, or all patterns if args is empty
+func PUnSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	var patterns []string
+	if len(args) > 0 {
+		patterns = make([]string, len(args))
+		for i, b := range args {
+			patterns[i] = string(b)
+		}
+	} else {
+		patterns = c.GetPatterns()
+	}
+
+	if len(patterns) == 0 {
+		_, _ = c.Write(punSubscribeNothing)
+		return &protocol.NoReply{}
+	}
+
+	hub.patternsMu.Lock()
+	defer hub.patternsMu.Unlock()
+	for _, pattern := range patterns {
+		if punsubscribe0(hub, pattern, c) {
+			_, _ = c.Write(makeMsg(_punsubscribe, pattern, int64(c.SubsCount())))
+		}
+	}
+	return &protocol.NoReply{}
 }
 
 // UnSubscribe removes the given connection from the given channel
@@ -129,7 +259,7 @@ func UnSubscribe(db *Hub, c redis.Connection, args [][]byte) redis.Reply {
 	return &protocol.NoReply{}
 }
 
-// Publish send msg to all subscribing client
+// Publish send msg to all subscribing client, including clients subscribing matched patterns
 func Publish(hub *Hub, args [][]byte) redis.Reply {
 	if len(args) != 2 {
 		return &protocol.ArgNumErrReply{Cmd: "publish"}
@@ -140,19 +270,26 @@ func Publish(hub *Hub, args [][]byte) redis.Reply {
 	hub.subsLocker.Lock(channel)
 	defer hub.subsLocker.UnLock(channel)
 
-	raw, ok := hub.subs.Get(channel)
-	if !ok {
-		return protocol.MakeIntReply(0)
+	receivers := publish0(hub.subs, messageBytes, channel, message)
+
+	hub.patternsMu.RLock()
+	defer hub.patternsMu.RUnlock()
+	for pattern, subs := range hub.patterns {
+		if subs.matcher == nil || !subs.matcher.IsMatch(channel) {
+			continue
+		}
+		msg := protocol.MakeMultiBulkReply([][]byte{
+			pmessageBytes,
+			[]byte(pattern),
+			[]byte(channel),
+			message,
+		}).ToBytes()
+		subs.subscribers.ForEach(func(i int, c interface{}) bool {
+			client, _ := c.(redis.Connection)
+			_, _ = client.Write(msg)
+			return true
+		})
+		receivers += subs.subscribers.Len()
 	}
-	subscribers, _ := raw.(*list.LinkedList)
-	subscribers.ForEach(func(i int, c interface{}) bool {
-		client, _ := c.(redis.Connection)
-		replyArgs := make([][]byte, 3)
-		replyArgs[0] = messageBytes
-		replyArgs[1] = []byte(channel)
-		replyArgs[2] = message
-		_, _ = client.Write(protocol.MakeMultiBulkReply(replyArgs).ToBytes())
-		return true
-	})
-	return protocol.MakeIntReply(int64(subscribers.Len()))
+	return protocol.MakeIntReply(int64(receivers))
 }
@@ -0,0 +1,90 @@
+package pubsub
+
+import (
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+// Shard channels are independent of normal channels. In cluster mode, a shard channel belongs to the slot
+// of its name, so that messages are only published in the node serving the slot.
+
+var (
+	_ssubscribe         = "ssubscribe"
+	_sunsubscribe       = "sunsubscribe"
+	smessageBytes       = []byte("smessage")
+	sunSubscribeNothing = []byte("*3\r\n$12\r\nsunsubscribe\r\n$-1\r\n:0\r\n")
+)
+
+// SSubscribe puts the given connection into subscribers of the given shard channels
+func SSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	channels := make([]string, len(args))
+	for i, b := range args {
+		channels[i] = string(b)
+	}
+
+	hub.subsLocker.Locks(channels...)
+	defer hub.subsLocker.UnLocks(channels...)
+
+	for _, channel := range channels {
+		c.SSubscribe(channel)
+		if addSubscriber(hub.shardSubs, channel, c) {
+			_, _ = c.Write(makeMsg(_ssubscribe, channel, int64(len(c.GetShardChannels()))))
+		}
+	}
+	return &protocol.NoReply{}
+}
+
+// SUnSubscribe removes the given connection from the given shard channels, or all shard channels if args is empty
+func SUnSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	var channels []string
+	if len(args) > 0 {
+		channels = make([]string, len(args))
+		for i, b := range args {
+			channels[i] = string(b)
+		}
+	} else {
+		channels = c.GetShardChannels()
+	}
+
+	if len(channels) == 0 {
+		_, _ = c.Write(sunSubscribeNothing)
+		return &protocol.NoReply{}
+	}
+
+	hub.subsLocker.Locks(channels...)
+	defer hub.subsLocker.UnLocks(channels...)
+
+	for _, channel := range channels {
+		c.SUnSubscribe(channel)
+		if removeSubscriber(hub.shardSubs, channel, c) {
+			_, _ = c.Write(makeMsg(_sunsubscribe, channel, int64(len(c.GetShardChannels()))))
+		}
+	}
+	return &protocol.NoReply{}
+}
+
+func sUnsubscribeAll(hub *Hub, c redis.Connection) {
+	channels := c.GetShardChannels()
+
+	hub.subsLocker.Locks(channels...)
+	defer hub.subsLocker.UnLocks(channels...)
+
+	for _, channel := range channels {
+		c.SUnSubscribe(channel)
+		removeSubscriber(hub.shardSubs, channel, c)
+	}
+}
+
+// SPublish sends message to all clients subscribing the given shard channel
+func SPublish(hub *Hub, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return &protocol.ArgNumErrReply{Cmd: "spublish"}
+	}
+	channel := string(args[0])
+
+	hub.subsLocker.Lock(channel)
+	defer hub.subsLocker.UnLock(channel)
+
+	receivers := publish0(hub.shardSubs, smessageBytes, channel, args[1])
+	return protocol.MakeIntReply(int64(receivers))
+}
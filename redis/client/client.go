@@ -1,6 +1,7 @@
 package client
 
 import (
+	"crypto/tls"
 	"errors"
 	"github.com/hdt3213/godis/interface/redis"
 	"github.com/hdt3213/godis/lib/logger"
@@ -28,6 +29,8 @@ type Client struct {
 	waitingReqs chan *request // waiting response
 	ticker      *time.Ticker
 	addr        string
+	tlsConfig   *tls.Config // nil if tls is disabled
+	opts        *Options    // handshake after reconnected, nil if the client is not created by Dial
 
 	status  int32
 	working *sync.WaitGroup // its counter presents unfinished requests(pending and waiting)
@@ -50,17 +53,28 @@ const (
 
 // MakeClient creates a new client
 func MakeClient(addr string) (*Client, error) {
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		return nil, err
-	}
-	return &Client{
+	return MakeTLSClient(addr, nil)
+}
+
+// MakeTLSClient creates a new client connecting server through TLS, tls is disabled if tlsConfig is nil
+func MakeTLSClient(addr string, tlsConfig *tls.Config) (*Client, error) {
+	client := &Client{
 		addr:        addr,
-		conn:        conn,
+		tlsConfig:   tlsConfig,
 		pendingReqs: make(chan *request, chanSize),
 		waitingReqs: make(chan *request, chanSize),
 		working:     &sync.WaitGroup{},
-	}, nil
+	}
+	conn, err := client.dial()
+	if err != nil {
+		return nil, err
+	}
+	client.conn = conn
+	return client, nil
+}
+
+func (client *Client) dial() (net.Conn, error) {
+	return dial(client.addr, client.tlsConfig)
 }
 
 func (client *Client) RemoteAddress() string {
@@ -91,6 +105,11 @@ func (client *Client) Close() {
 	close(client.waitingReqs)
 }
 
+// IsClosed returns whether the client is closed, it is closed after reconnecting failed
+func (client *Client) IsClosed() bool {
+	return atomic.LoadInt32(&client.status) == closed
+}
+
 func (client *Client) reconnect() {
 	logger.Info("reconnect with: " + client.addr)
 	_ = client.conn.Close() // ignore possible errors from repeated closes
@@ -98,7 +117,7 @@ func (client *Client) reconnect() {
 	var conn net.Conn
 	for i := 0; i < 3; i++ {
 		var err error
-		conn, err = net.Dial("tcp", client.addr)
+		conn, err = client.dial()
 		if err != nil {
 			logger.Error("reconnect error: " + err.Error())
 			time.Sleep(time.Second)
@@ -111,14 +130,25 @@ func (client *Client) reconnect() {
 		client.Close()
 		return
 	}
-	client.conn = conn
 
 	close(client.waitingReqs)
 	for req := range client.waitingReqs {
 		req.err = errors.New("connection closed")
-		req.waiting.Done()
+		if req.waiting != nil {
+			req.waiting.Done()
+		}
 	}
 	client.waitingReqs = make(chan *request, chanSize)
+	// handshake before new requests, its replies are dropped by handleRead
+	for _, cmdLine := range client.opts.handshake() {
+		_, err := conn.Write(protocol.MakeMultiBulkReply(cmdLine).ToBytes())
+		if err != nil {
+			logger.Error("handshake error: " + err.Error())
+			break
+		}
+		client.waitingReqs <- &request{args: cmdLine, heartbeat: true}
+	}
+	client.conn = conn
 	// restart handle read
 	go client.handleRead()
 }
@@ -137,8 +167,21 @@ func (client *Client) handleWrite() {
 
 // Send sends a request to redis server
 func (client *Client) Send(args [][]byte) redis.Reply {
+	reply, err := client.SendWithTimeout(args, maxWait)
+	if err != nil {
+		return protocol.MakeErrReply(err.Error())
+	}
+	return reply
+}
+
+// ErrTimeout is returned when server does not reply in time
+var ErrTimeout = errors.New("server time out")
+
+// SendWithTimeout sends a request to redis server, error will be returned if the request failed
+// or server does not reply in time. Error replies from server are returned as reply.
+func (client *Client) SendWithTimeout(args [][]byte, timeout time.Duration) (redis.Reply, error) {
 	if atomic.LoadInt32(&client.status) != running {
-		return protocol.MakeErrReply("client closed")
+		return nil, errors.New("client closed")
 	}
 	req := &request{
 		args:      args,
@@ -149,14 +192,13 @@ func (client *Client) Send(args [][]byte) redis.Reply {
 	client.working.Add(1)
 	defer client.working.Done()
 	client.pendingReqs <- req
-	timeout := req.waiting.WaitWithTimeout(maxWait)
-	if timeout {
-		return protocol.MakeErrReply("server time out")
+	if req.waiting.WaitWithTimeout(timeout) {
+		return nil, ErrTimeout
 	}
 	if req.err != nil {
-		return protocol.MakeErrReply("request failed " + req.err.Error())
+		return nil, errors.New("request failed " + req.err.Error())
 	}
-	return req.reply
+	return req.reply, nil
 }
 
 func (client *Client) doHeartbeat() {
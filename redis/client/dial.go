@@ -0,0 +1,99 @@
+package client
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/sync/wait"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+// Dial creates a started client, AUTH and SELECT are sent according to opts after connected and reconnected.
+// opts could be nil
+func Dial(addr string, opts *Options) (*Client, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	client, err := MakeTLSClient(addr, opts.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	client.opts = opts
+	client.Start()
+	for _, cmdLine := range opts.handshake() {
+		reply, err := client.SendWithTimeout(cmdLine, maxWait)
+		if err == nil {
+			err = protocol.Try2ErrorReply(reply)
+		}
+		if err != nil {
+			client.Close()
+			return nil, errors.New(string(cmdLine[0]) + " failed: " + err.Error())
+		}
+	}
+	return client, nil
+}
+
+// Do sends a command like Do("SET", "key", "value") and waits for its reply.
+// Error replies from server are returned as reply, use protocol.Try2ErrorReply to convert them
+func (client *Client) Do(args ...string) (redis.Reply, error) {
+	return client.SendWithTimeout(utils.ToCmdLine(args...), maxWait)
+}
+
+// Pipeline queues commands and sends them together by Exec, it is not safe for concurrent use
+type Pipeline struct {
+	client   *Client
+	cmdLines [][][]byte
+}
+
+// Pipeline creates an empty pipeline
+func (client *Client) Pipeline() *Pipeline {
+	return &Pipeline{
+		client: client,
+	}
+}
+
+// Queue appends a command to pipeline
+func (p *Pipeline) Queue(args ...string) {
+	p.cmdLines = append(p.cmdLines, utils.ToCmdLine(args...))
+}
+
+// Len returns count of queued commands
+func (p *Pipeline) Len() int {
+	return len(p.cmdLines)
+}
+
+// Exec sends queued commands without waiting for replies in between, then returns replies in order and
+// clears the pipeline. Error is returned if any command is not replied in time
+func (p *Pipeline) Exec() ([]redis.Reply, error) {
+	client := p.client
+	if atomic.LoadInt32(&client.status) != running {
+		return nil, errors.New("client closed")
+	}
+	cmdLines := p.cmdLines
+	p.cmdLines = nil
+	reqs := make([]*request, len(cmdLines))
+	client.working.Add(len(cmdLines))
+	defer client.working.Add(-len(cmdLines))
+	for i, cmdLine := range cmdLines {
+		req := &request{
+			args:    cmdLine,
+			waiting: &wait.Wait{},
+		}
+		req.waiting.Add(1)
+		reqs[i] = req
+		client.pendingReqs <- req
+	}
+	replies := make([]redis.Reply, len(reqs))
+	for i, req := range reqs {
+		if req.waiting.WaitWithTimeout(maxWait) {
+			return nil, ErrTimeout
+		}
+		if req.err != nil {
+			return nil, errors.New("request failed " + req.err.Error())
+		}
+		replies[i] = req.reply
+	}
+	return replies, nil
+}
@@ -0,0 +1,121 @@
+package client_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/embedded"
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/redis/client"
+	"github.com/hdt3213/godis/redis/protocol"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+)
+
+func startServer(t *testing.T) *embedded.Server {
+	server := embedded.NewServer(&embedded.Options{
+		Properties: &config.ServerProperties{RequirePass: "pass"},
+		Addr:       "127.0.0.1:0",
+	})
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestDial(t *testing.T) {
+	server := startServer(t)
+	defer server.Stop()
+
+	if _, err := client.Dial(server.Addr(), &client.Options{Password: "wrong"}); err == nil {
+		t.Error("expect auth error")
+	}
+	cli, err := client.Dial(server.Addr(), &client.Options{Password: "pass", DB: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	reply, err := cli.Do("SET", "a", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.AssertStatusReply(t, reply, "OK")
+	reply, _ = server.Do(context.Background(), 1, "GET", "a")
+	asserts.AssertBulkReply(t, reply, "1")
+
+	pipeline := cli.Pipeline()
+	pipeline.Queue("MULTI")
+	pipeline.Queue("INCR", "a")
+	pipeline.Queue("LRANGE", "list", "0", "-1")
+	pipeline.Queue("EXEC")
+	pipeline.Queue("MGET", "a", "b")
+	replies, err := pipeline.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 5 || pipeline.Len() != 0 {
+		t.Fatalf("unexpected replies %d", len(replies))
+	}
+	asserts.AssertStatusReply(t, replies[0], "OK")
+	asserts.AssertStatusReply(t, replies[1], "QUEUED")
+	execReply, ok := replies[3].(*protocol.MultiRawReply)
+	if !ok || len(execReply.Replies) != 2 {
+		t.Fatalf("unexpected exec reply %q", replies[3].ToBytes())
+	}
+	asserts.AssertIntReply(t, execReply.Replies[0], 2)
+	asserts.AssertMultiBulkReplySize(t, execReply.Replies[1], 0)
+	mget, ok := replies[4].(*protocol.MultiBulkReply)
+	if !ok || string(mget.Args[0]) != "2" || mget.Args[1] != nil {
+		t.Errorf("unexpected mget reply %q", replies[4].ToBytes())
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	server := startServer(t)
+	defer server.Stop()
+
+	sub, err := client.Subscribe(server.Addr(), &client.Options{Password: "pass"}, "ch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sub.PSubscribe("news.*"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []*client.Message{
+		{Channel: "ch", Payload: []byte("hello")},
+		{Pattern: "news.*", Channel: "news.sports", Payload: []byte("goal")},
+	}
+	// wait until subscribed
+	deadline := time.Now().Add(3 * time.Second)
+	for _, msg := range expected {
+		var reply redis.Reply
+		for {
+			reply, _ = server.Do(context.Background(), 0, "PUBLISH", msg.Channel, string(msg.Payload))
+			if r, ok := reply.(*protocol.IntReply); (ok && r.Code > 0) || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		asserts.AssertIntReply(t, reply, 1)
+	}
+	for _, msg := range expected {
+		select {
+		case actual := <-sub.Messages():
+			if actual.Pattern != msg.Pattern || actual.Channel != msg.Channel || string(actual.Payload) != string(msg.Payload) {
+				t.Errorf("expect %+v, actual %+v", msg, actual)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("message timeout")
+		}
+	}
+	_ = sub.Close()
+	select {
+	case _, ok := <-sub.Messages():
+		if ok {
+			t.Error("messages should be closed")
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("close timeout")
+	}
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"strconv"
+
+	"github.com/hdt3213/godis/interface/redis"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/parser"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+// ErrClosed is returned when the connection has been closed by either side
+var ErrClosed = errors.New("connection closed")
+
+// Options configures connections created by Dial, DialStream and Subscribe
+type Options struct {
+	// TLSConfig enables tls if it is not nil
+	TLSConfig *tls.Config
+	// Password is sent by AUTH after connected if it is not empty
+	Password string
+	// DB is selected after connected if it is not 0
+	DB int
+}
+
+// handshake returns commands sent after connected, nil options means no handshake
+func (opts *Options) handshake() [][][]byte {
+	if opts == nil {
+		return nil
+	}
+	var cmdLines [][][]byte
+	if opts.Password != "" {
+		cmdLines = append(cmdLines, utils.ToCmdLine("AUTH", opts.Password))
+	}
+	if opts.DB != 0 {
+		cmdLines = append(cmdLines, utils.ToCmdLine("SELECT", strconv.Itoa(opts.DB)))
+	}
+	return cmdLines
+}
+
+func dial(addr string, tlsConfig *tls.Config) (net.Conn, error) {
+	if tlsConfig != nil {
+		return tls.Dial("tcp", addr, tlsConfig)
+	}
+	return net.Dial("tcp", addr)
+}
+
+// Stream is a connection without pipelining, the caller reads every reply in order from Stream().
+// It is used when server pushes data continuously, such as pubsub messages or replication traffic
+type Stream struct {
+	conn net.Conn
+	ch   <-chan *parser.Payload
+}
+
+// DialStream connects to addr and finishes handshake according to opts, opts could be nil
+func DialStream(addr string, opts *Options) (*Stream, error) {
+	var tlsConfig *tls.Config
+	if opts != nil {
+		tlsConfig = opts.TLSConfig
+	}
+	conn, err := dial(addr, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	stream := &Stream{
+		conn: conn,
+		ch:   parser.ParseStream(conn),
+	}
+	for _, cmdLine := range opts.handshake() {
+		reply, err := stream.Do(cmdLine)
+		if err == nil {
+			err = protocol.Try2ErrorReply(reply)
+		}
+		if err != nil {
+			_ = stream.Close()
+			return nil, errors.New(string(cmdLine[0]) + " failed: " + err.Error())
+		}
+	}
+	return stream, nil
+}
+
+// Conn returns the underlying connection
+func (s *Stream) Conn() net.Conn {
+	return s.conn
+}
+
+// Stream returns replies received from server, the channel is closed after an error payload
+func (s *Stream) Stream() <-chan *parser.Payload {
+	return s.ch
+}
+
+// Send writes a command to server without waiting for reply
+func (s *Stream) Send(cmdLine [][]byte) error {
+	_, err := s.conn.Write(protocol.MakeMultiBulkReply(cmdLine).ToBytes())
+	return err
+}
+
+// Do sends a command and reads the next reply, it should not be used if server may push other data before the reply.
+// Error replies from server are returned as reply
+func (s *Stream) Do(cmdLine [][]byte) (redis.Reply, error) {
+	if err := s.Send(cmdLine); err != nil {
+		return nil, err
+	}
+	payload, ok := <-s.ch
+	if !ok {
+		return nil, ErrClosed
+	}
+	if payload.Err != nil {
+		return nil, payload.Err
+	}
+	return payload.Data, nil
+}
+
+// Close closes the connection, Stream() will be closed after the pending replies are consumed
+func (s *Stream) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/protocol"
+)
+
+// Message is published to a channel subscribed by Subscriber
+type Message struct {
+	// Pattern matched by channel, it is empty if the channel is subscribed by SUBSCRIBE
+	Pattern string
+	Channel string
+	Payload []byte
+}
+
+// Subscriber receives messages on a dedicated connection, since server pushes messages to subscribed connections
+type Subscriber struct {
+	stream   *Stream
+	messages chan *Message
+	mu       sync.Mutex // serializes writing commands
+}
+
+// Subscribe connects to addr and subscribes the given channels, opts could be nil
+func Subscribe(addr string, opts *Options, channels ...string) (*Subscriber, error) {
+	stream, err := DialStream(addr, opts)
+	if err != nil {
+		return nil, err
+	}
+	sub := &Subscriber{
+		stream:   stream,
+		messages: make(chan *Message, chanSize),
+	}
+	go sub.receive()
+	if len(channels) > 0 {
+		if err := sub.Subscribe(channels...); err != nil {
+			_ = sub.Close()
+			return nil, err
+		}
+	}
+	return sub, nil
+}
+
+func (sub *Subscriber) send(cmd string, args ...string) error {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.stream.Send(utils.ToCmdLine2(cmd, args...))
+}
+
+// Subscribe subscribes more channels
+func (sub *Subscriber) Subscribe(channels ...string) error {
+	return sub.send("SUBSCRIBE", channels...)
+}
+
+// PSubscribe subscribes channels matching the given patterns
+func (sub *Subscriber) PSubscribe(patterns ...string) error {
+	return sub.send("PSUBSCRIBE", patterns...)
+}
+
+// Unsubscribe unsubscribes the given channels, or all channels if none is given
+func (sub *Subscriber) Unsubscribe(channels ...string) error {
+	return sub.send("UNSUBSCRIBE", channels...)
+}
+
+// PUnsubscribe unsubscribes the given patterns, or all patterns if none is given
+func (sub *Subscriber) PUnsubscribe(patterns ...string) error {
+	return sub.send("PUNSUBSCRIBE", patterns...)
+}
+
+// Messages returns received messages, it is closed after the connection closed
+func (sub *Subscriber) Messages() <-chan *Message {
+	return sub.messages
+}
+
+// Close closes the connection
+func (sub *Subscriber) Close() error {
+	return sub.stream.Close()
+}
+
+func (sub *Subscriber) receive() {
+	defer close(sub.messages)
+	for payload := range sub.stream.Stream() {
+		if payload.Err != nil {
+			return
+		}
+		// replies of SUBSCRIBE are *protocol.MultiRawReply and ignored
+		reply, ok := payload.Data.(*protocol.MultiBulkReply)
+		if !ok || len(reply.Args) < 3 {
+			continue
+		}
+		switch strings.ToLower(string(reply.Args[0])) {
+		case "message":
+			sub.messages <- &Message{
+				Channel: string(reply.Args[1]),
+				Payload: reply.Args[2],
+			}
+		case "pmessage":
+			if len(reply.Args) == 4 {
+				sub.messages <- &Message{
+					Pattern: string(reply.Args[1]),
+					Channel: string(reply.Args[2]),
+					Payload: reply.Args[3],
+				}
+			}
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package connection
 
 import (
+	"context"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hdt3213/godis/lib/logger"
@@ -16,11 +18,19 @@ const (
 	flagMaster
 	// flagMulti means this connection is within a transaction
 	flagMulti
+	// flagReadOnly means client allows to read from replicas in cluster mode, see READONLY
+	flagReadOnly
+	// flagAsking means the next command is allowed to access importing slot in cluster mode, see ASKING
+	flagAsking
+	// flagCloseAfterReply means the connection should be closed after sending next reply, see CLIENT KILL
+	flagCloseAfterReply
 )
 
 // Connection represents a connection with a redis-cli
 type Connection struct {
 	conn net.Conn
+	// id is unique during the lifetime of server, see CLIENT ID
+	id uint64
 
 	// wait until finish sending data, used for graceful shutdown
 	sendingData wait.Wait
@@ -31,6 +41,10 @@ type Connection struct {
 
 	// subscribing channels
 	subs map[string]bool
+	// subscribing patterns
+	psubs map[string]bool
+	// subscribing shard channels
+	ssubs map[string]bool
 
 	// password may be changed by CONFIG command during runtime, so store the password
 	password string
@@ -42,6 +56,23 @@ type Connection struct {
 
 	// selected db
 	selectedDB int
+
+	// name is set by CLIENT SETNAME, protected by mu
+	name string
+	// lastCmd is the latest command executed by client, protected by mu
+	lastCmd   string
+	createdAt time.Time
+	// lastInteraction is the unix nano time of latest command
+	lastInteraction int64
+	// ctx carries the span of executing command if tracing is enabled
+	ctx context.Context
+}
+
+// lastID is the last assigned connection id, ids start from 1
+var lastID uint64
+
+func nextID() uint64 {
+	return atomic.AddUint64(&lastID, 1)
 }
 
 var connPool = sync.Pool{
@@ -55,6 +86,14 @@ func (c *Connection) RemoteAddr() string {
 	return c.conn.RemoteAddr().String()
 }
 
+// LocalAddr returns the local network address which client connected to
+func (c *Connection) LocalAddr() string {
+	if c.conn == nil {
+		return ""
+	}
+	return c.conn.LocalAddr().String()
+}
+
 // Close disconnect with the client
 func (c *Connection) Close() error {
 	c.sendingData.WaitWithTimeout(10 * time.Second)
@@ -62,11 +101,17 @@ func (c *Connection) Close() error {
 		_ = c.conn.Close()
 	}
 	c.subs = nil
+	c.psubs = nil
+	c.ssubs = nil
 	c.password = ""
 	c.queue = nil
 	c.watching = nil
 	c.txErrors = nil
 	c.selectedDB = 0
+	c.flags = 0
+	c.name = ""
+	c.lastCmd = ""
+	c.ctx = nil
 	connPool.Put(c)
 	return nil
 }
@@ -76,14 +121,26 @@ func NewConn(conn net.Conn) *Connection {
 	c, ok := connPool.Get().(*Connection)
 	if !ok {
 		logger.Error("connection pool make wrong type")
+		now := time.Now()
 		return &Connection{
-			conn: conn,
+			conn:            conn,
+			id:              nextID(),
+			createdAt:       now,
+			lastInteraction: now.UnixNano(),
 		}
 	}
 	c.conn = conn
+	c.id = nextID()
+	c.createdAt = time.Now()
+	atomic.StoreInt64(&c.lastInteraction, c.createdAt.UnixNano())
 	return c
 }
 
+// ID returns the unique id of connection
+func (c *Connection) ID() uint64 {
+	return c.id
+}
+
 
 // Write sends response to client over tcp connection
 func (c *Connection) Write(b []byte) (int, error) {
@@ -95,7 +152,58 @@ func (c *Connection) Write(b []byte) (int, error) {
 		c.sendingData.Done()
 	}()
 
-	return c.conn.Write(b)
+	n, err := c.conn.Write(b)
+	if c.flags&flagCloseAfterReply > 0 {
+		_ = c.conn.Close()
+	}
+	return n, err
+}
+
+// Kill closes the network connection, and the server cleans the client up after its reading loop exits.
+// If afterReply is true, it is closed after sending the next reply, used by client killing itself.
+func (c *Connection) Kill(afterReply bool) {
+	if afterReply {
+		c.flags |= flagCloseAfterReply
+		return
+	}
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+}
+
+// SetName sets name of client, see CLIENT SETNAME
+func (c *Connection) SetName(name string) {
+	c.mu.Lock()
+	c.name = name
+	c.mu.Unlock()
+}
+
+// GetName returns name of client set by CLIENT SETNAME
+func (c *Connection) GetName() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.name
+}
+
+// SetLastCmd records the latest command and updates the last interaction time
+func (c *Connection) SetLastCmd(cmdName string) {
+	c.mu.Lock()
+	c.lastCmd = cmdName
+	c.mu.Unlock()
+	atomic.StoreInt64(&c.lastInteraction, time.Now().UnixNano())
+}
+
+// GetLastCmd returns the latest command and when it was executed
+func (c *Connection) GetLastCmd() (string, time.Time) {
+	c.mu.Lock()
+	cmdName := c.lastCmd
+	c.mu.Unlock()
+	return cmdName, time.Unix(0, atomic.LoadInt64(&c.lastInteraction))
+}
+
+// CreatedAt returns when the connection was established
+func (c *Connection) CreatedAt() time.Time {
+	return c.createdAt
 }
 
 func (c *Connection) Name() string {
@@ -127,13 +235,59 @@ func (c *Connection) UnSubscribe(channel string) {
 	delete(c.subs, channel)
 }
 
-// SubsCount returns the number of subscribing channels
+// PSubscribe add current connection into subscribers of the given pattern
+func (c *Connection) PSubscribe(pattern string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.psubs == nil {
+		c.psubs = make(map[string]bool)
+	}
+	c.psubs[pattern] = true
+}
+
+// PUnSubscribe removes current connection into subscribers of the given pattern
+func (c *Connection) PUnSubscribe(pattern string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.psubs) == 0 {
+		return
+	}
+	delete(c.psubs, pattern)
+}
+
+// SSubscribe add current connection into subscribers of the given shard channel
+func (c *Connection) SSubscribe(channel string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ssubs == nil {
+		c.ssubs = make(map[string]bool)
+	}
+	c.ssubs[channel] = true
+}
+
+// SUnSubscribe removes current connection into subscribers of the given shard channel
+func (c *Connection) SUnSubscribe(channel string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.ssubs) == 0 {
+		return
+	}
+	delete(c.ssubs, channel)
+}
+
+// SubsCount returns the number of subscribing channels and patterns
 func (c *Connection) SubsCount() int {
-	return len(c.subs)
+	return len(c.subs) + len(c.psubs)
 }
 
 // GetChannels returns all subscribing channels
 func (c *Connection) GetChannels() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.subs == nil {
 		return make([]string, 0)
 	}
@@ -146,6 +300,28 @@ func (c *Connection) GetChannels() []string {
 	return channels
 }
 
+// GetShardChannels returns all subscribing shard channels
+func (c *Connection) GetShardChannels() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	channels := make([]string, 0, len(c.ssubs))
+	for channel := range c.ssubs {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+// GetPatterns returns all subscribing patterns
+func (c *Connection) GetPatterns() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	patterns := make([]string, 0, len(c.psubs))
+	for pattern := range c.psubs {
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
 // SetPassword stores password for authentication
 func (c *Connection) SetPassword(password string) {
 	c.password = password
@@ -231,3 +407,44 @@ func (c *Connection) SetMaster() {
 func (c *Connection) IsMaster() bool {
 	return c.flags&flagMaster > 0
 }
+
+// SetReadOnly sets whether client allows to read from replicas, see READONLY and READWRITE
+func (c *Connection) SetReadOnly(readOnly bool) {
+	if readOnly {
+		c.flags |= flagReadOnly
+	} else {
+		c.flags &= ^flagReadOnly
+	}
+}
+
+// IsReadOnly returns whether client allows to read from replicas
+func (c *Connection) IsReadOnly() bool {
+	return c.flags&flagReadOnly > 0
+}
+
+// SetAsking sets or clears the one-shot ASKING flag
+func (c *Connection) SetAsking(asking bool) {
+	if asking {
+		c.flags |= flagAsking
+	} else {
+		c.flags &= ^flagAsking
+	}
+}
+
+// IsAsking returns whether client has sent ASKING before current command
+func (c *Connection) IsAsking() bool {
+	return c.flags&flagAsking > 0
+}
+
+// SetContext sets the context of executing command
+func (c *Connection) SetContext(ctx context.Context) {
+	c.ctx = ctx
+}
+
+// Context returns the context of executing command, it is never nil
+func (c *Connection) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
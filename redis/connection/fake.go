@@ -5,6 +5,7 @@ import (
 	"github.com/hdt3213/godis/lib/logger"
 	"io"
 	"sync"
+	"time"
 )
 
 // FakeConn implements redis.Connection for test
@@ -19,6 +20,9 @@ type FakeConn struct {
 
 func NewFakeConn() *FakeConn {
 	c := &FakeConn{}
+	c.id = nextID()
+	c.createdAt = time.Now()
+	c.lastInteraction = c.createdAt.UnixNano()
 	return c
 }
 
@@ -108,3 +112,8 @@ func (c *FakeConn) Close() error {
 func (c *FakeConn) RemoteAddr() string {
 	return ""
 }
+
+// Kill closes the fake connection
+func (c *FakeConn) Kill(afterReply bool) {
+	_ = c.Close()
+}
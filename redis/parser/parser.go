@@ -20,10 +20,35 @@ type Payload struct {
 	Err  error
 }
 
+// Limits restricts size of requests, so that a malicious client cannot make server allocate unbounded memory.
+// Zero means unlimited
+type Limits struct {
+	MaxMultiBulkLen int64 // max count of arguments of a request
+	MaxBulkLen      int64 // max length of an argument
+	MaxInlineLen    int   // max length of an inline request or a header line
+	BulkArrayOnly   bool  // arrays must consist of bulk strings, as requests do
+}
+
+// RequestLimits is used to parse requests from clients, same as the defaults of redis
+var RequestLimits = &Limits{
+	MaxMultiBulkLen: 1024 * 1024,
+	MaxBulkLen:      512 * 1024 * 1024,
+	MaxInlineLen:    64 * 1024,
+	BulkArrayOnly:   true,
+}
+
 // ParseStream reads data from io.Reader and send payloads through channel
 func ParseStream(reader io.Reader) <-chan *Payload {
 	ch := make(chan *Payload)
-	go parse0(reader, ch)
+	go parse0(reader, ch, &Limits{})
+	return ch
+}
+
+// ParseRequestStream is the same as ParseStream but enforces RequestLimits.
+// It sends a *protocol.ProtocolErrReply as Err and closes the channel if the request is malformed or too big
+func ParseRequestStream(reader io.Reader) <-chan *Payload {
+	ch := make(chan *Payload)
+	go parse0(reader, ch, RequestLimits)
 	return ch
 }
 
@@ -31,7 +56,7 @@ func ParseStream(reader io.Reader) <-chan *Payload {
 func ParseBytes(data []byte) ([]redis.Reply, error) {
 	ch := make(chan *Payload)
 	reader := bytes.NewReader(data)
-	go parse0(reader, ch)
+	go parse0(reader, ch, &Limits{})
 	var results []redis.Reply
 	for payload := range ch {
 		if payload == nil {
@@ -52,7 +77,7 @@ func ParseBytes(data []byte) ([]redis.Reply, error) {
 func ParseOne(data []byte) (redis.Reply, error) {
 	ch := make(chan *Payload, 1)
 	reader := bytes.NewReader(data)
-	go parse0(reader, ch)
+	go parse0(reader, ch, &Limits{})
 	payload := <-ch // parse0 will close the channel
 	if payload == nil {
 		return nil, errors.New("no protocol")
@@ -60,7 +85,62 @@ func ParseOne(data []byte) (redis.Reply, error) {
 	return payload.Data, payload.Err
 }
 
-func parse0(rawReader io.Reader, ch chan<- *Payload) {
+var errLineTooLong = errors.New("line too long")
+
+// lengths in headers are declared by clients, preallocate no more than these sizes and grow as data actually arrives,
+// so that a client cannot make server allocate huge memory by sending headers only
+const (
+	maxBulkPrealloc  = 4 * 1024
+	maxArrayPrealloc = 1024
+)
+
+// readBulkBody reads n bytes from reader
+func readBulkBody(reader io.Reader, n int64) ([]byte, error) {
+	if n <= maxBulkPrealloc {
+		body := make([]byte, n)
+		_, err := io.ReadFull(reader, body)
+		if err != nil {
+			return nil, err
+		}
+		return body, nil
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, maxBulkPrealloc))
+	read, err := io.CopyN(buf, reader, n)
+	if err != nil {
+		if err == io.EOF && read > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func arrayPrealloc(n int64) int64 {
+	if n > maxArrayPrealloc {
+		return maxArrayPrealloc
+	}
+	return n
+}
+
+// readLineLimited reads a line ending with '\n', returns errLineTooLong and the read part if it is longer than maxLen
+func readLineLimited(reader *bufio.Reader, maxLen int) ([]byte, error) {
+	if maxLen <= 0 {
+		return reader.ReadBytes('\n')
+	}
+	var line []byte
+	for {
+		fragment, err := reader.ReadSlice('\n')
+		line = append(line, fragment...)
+		if len(line) > maxLen+2 { // 2 for CRLF
+			return line, errLineTooLong
+		}
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+func parse0(rawReader io.Reader, ch chan<- *Payload, limits *Limits) {
 	defer func() {
 		if err := recover(); err != nil {
 			logger.Error(err, string(debug.Stack()))
@@ -68,7 +148,14 @@ func parse0(rawReader io.Reader, ch chan<- *Payload) {
 	}()
 	reader := bufio.NewReader(rawReader)
 	for {
-		line, err := reader.ReadBytes('\n')
+		line, err := readLineLimited(reader, limits.MaxInlineLen)
+		if err == errLineTooLong {
+			if line[0] == '*' {
+				err = protocol.MakeProtocolErrReply("too big mbulk count string")
+			} else {
+				err = protocol.MakeProtocolErrReply("too big inline request")
+			}
+		}
 		if err != nil {
 			ch <- &Payload{Err: err}
 			close(ch)
@@ -109,14 +196,14 @@ func parse0(rawReader io.Reader, ch chan<- *Payload) {
 				Data: protocol.MakeIntReply(value),
 			}
 		case '$':
-			err = parseBulkString(line, reader, ch)
+			err = parseBulkString(line, reader, ch, limits)
 			if err != nil {
 				ch <- &Payload{Err: err}
 				close(ch)
 				return
 			}
 		case '*':
-			err = parseArray(line, reader, ch)
+			err = parseArray(line, reader, ch, limits)
 			if err != nil {
 				ch <- &Payload{Err: err}
 				close(ch)
@@ -131,19 +218,26 @@ func parse0(rawReader io.Reader, ch chan<- *Payload) {
 	}
 }
 
-func parseBulkString(header []byte, reader *bufio.Reader, ch chan<- *Payload) error {
+// parseBulkLen parses header of bulk string like "$3", -1 means null bulk string
+func parseBulkLen(header []byte, limits *Limits) (int64, error) {
 	strLen, err := strconv.ParseInt(string(header[1:]), 10, 64)
-	if err != nil || strLen < -1 {
-		protocolError(ch, "illegal bulk string header: "+string(header))
-		return nil
+	if err != nil || strLen < -1 || (limits.MaxBulkLen > 0 && strLen > limits.MaxBulkLen) {
+		return 0, protocol.MakeProtocolErrReply("invalid bulk length")
+	}
+	return strLen, nil
+}
+
+func parseBulkString(header []byte, reader *bufio.Reader, ch chan<- *Payload, limits *Limits) error {
+	strLen, err := parseBulkLen(header, limits)
+	if err != nil {
+		return err
 	} else if strLen == -1 {
 		ch <- &Payload{
 			Data: protocol.MakeNullBulkReply(),
 		}
 		return nil
 	}
-	body := make([]byte, strLen+2)
-	_, err = io.ReadFull(reader, body)
+	body, err := readBulkBody(reader, strLen+2)
 	if err != nil {
 		return err
 	}
@@ -167,8 +261,7 @@ func parseRDBBulkString(reader *bufio.Reader, ch chan<- *Payload) error {
 	if err != nil || strLen <= 0 {
 		return errors.New("illegal bulk header: " + string(header))
 	}
-	body := make([]byte, strLen)
-	_, err = io.ReadFull(reader, body)
+	body, err := readBulkBody(reader, strLen)
 	if err != nil {
 		return err
 	}
@@ -178,48 +271,108 @@ func parseRDBBulkString(reader *bufio.Reader, ch chan<- *Payload) error {
 	return nil
 }
 
-func parseArray(header []byte, reader *bufio.Reader, ch chan<- *Payload) error {
+func parseArray(header []byte, reader *bufio.Reader, ch chan<- *Payload, limits *Limits) error {
+	reply, err := readArray(header, reader, limits)
+	if err != nil {
+		return err
+	}
+	ch <- &Payload{
+		Data: reply,
+	}
+	return nil
+}
+
+// readArray returns *protocol.MultiBulkReply if all elements are bulk strings, otherwise *protocol.MultiRawReply
+func readArray(header []byte, reader *bufio.Reader, limits *Limits) (redis.Reply, error) {
 	nStrs, err := strconv.ParseInt(string(header[1:]), 10, 64)
-	if err != nil || nStrs < 0 {
-		protocolError(ch, "illegal array header "+string(header[1:]))
-		return nil
+	if nStrs == -1 && !limits.BulkArrayOnly {
+		return protocol.MakeNullMultiBulkReply(), nil
+	}
+	if err != nil || nStrs < 0 || (limits.MaxMultiBulkLen > 0 && nStrs > limits.MaxMultiBulkLen) {
+		return nil, protocol.MakeProtocolErrReply("invalid multibulk length")
 	} else if nStrs == 0 {
-		ch <- &Payload{
-			Data: protocol.MakeEmptyMultiBulkReply(),
-		}
-		return nil
+		return protocol.MakeEmptyMultiBulkReply(), nil
 	}
-	lines := make([][]byte, 0, nStrs)
+	lines := make([][]byte, 0, arrayPrealloc(nStrs))
+	var replies []redis.Reply // not nil after an element other than bulk string
 	for i := int64(0); i < nStrs; i++ {
 		var line []byte
-		line, err = reader.ReadBytes('\n')
-		if err != nil {
-			return err
+		line, err = readLineLimited(reader, limits.MaxInlineLen)
+		if err == errLineTooLong {
+			return nil, protocol.MakeProtocolErrReply("too big bulk count string")
+		} else if err != nil {
+			return nil, err
 		}
 		length := len(line)
-		if length < 4 || line[length-2] != '\r' || line[0] != '$' {
-			protocolError(ch, "illegal bulk string header "+string(line))
-			break
-		}
-		strLen, err := strconv.ParseInt(string(line[1:length-2]), 10, 64)
-		if err != nil || strLen < -1 {
-			protocolError(ch, "illegal bulk string length "+string(line))
-			break
-		} else if strLen == -1 {
-			lines = append(lines, []byte{})
+		if length < 3 || line[length-2] != '\r' || (line[0] != '$' && limits.BulkArrayOnly) {
+			return nil, protocol.MakeProtocolErrReply("expected '$', got '" + string(line[0]) + "'")
+		}
+		if line[0] != '$' {
+			if replies == nil {
+				replies = make([]redis.Reply, 0, arrayPrealloc(nStrs))
+				for _, line := range lines {
+					if line == nil {
+						replies = append(replies, protocol.MakeNullBulkReply())
+					} else {
+						replies = append(replies, protocol.MakeBulkReply(line))
+					}
+				}
+			}
+			reply, err := readElement(line[:length-2], reader, limits)
+			if err != nil {
+				return nil, err
+			}
+			replies = append(replies, reply)
+			continue
+		}
+		strLen, err := parseBulkLen(line[:length-2], limits)
+		if err != nil {
+			return nil, err
+		}
+		var body []byte // nil for null bulk string of replies
+		if strLen == -1 {
+			if limits.BulkArrayOnly {
+				body = []byte{}
+			}
 		} else {
-			body := make([]byte, strLen+2)
-			_, err := io.ReadFull(reader, body)
+			body, err = readBulkBody(reader, strLen+2)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			lines = append(lines, body[:len(body)-2])
+			body = body[:len(body)-2]
+		}
+		if replies == nil {
+			lines = append(lines, body)
+		} else if body == nil {
+			replies = append(replies, protocol.MakeNullBulkReply())
+		} else {
+			replies = append(replies, protocol.MakeBulkReply(body))
 		}
 	}
-	ch <- &Payload{
-		Data: protocol.MakeMultiBulkReply(lines),
+	if replies != nil {
+		return protocol.MakeMultiRawReply(replies), nil
 	}
-	return nil
+	return protocol.MakeMultiBulkReply(lines), nil
+}
+
+// readElement reads an element other than bulk string of array, such as integers in replies of SUBSCRIBE
+// and nested arrays in replies of EXEC
+func readElement(line []byte, reader *bufio.Reader, limits *Limits) (redis.Reply, error) {
+	switch line[0] {
+	case '+':
+		return protocol.MakeStatusReply(string(line[1:])), nil
+	case '-':
+		return protocol.MakeErrReply(string(line[1:])), nil
+	case ':':
+		value, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil {
+			return nil, protocol.MakeProtocolErrReply("illegal number " + string(line[1:]))
+		}
+		return protocol.MakeIntReply(value), nil
+	case '*':
+		return readArray(line, reader, limits)
+	}
+	return nil, protocol.MakeProtocolErrReply("unexpected '" + string(line[0]) + "' in array")
 }
 
 func protocolError(ch chan<- *Payload, msg string) {
@@ -6,6 +6,8 @@ import (
 	"github.com/hdt3213/godis/lib/utils"
 	"github.com/hdt3213/godis/redis/protocol"
 	"io"
+	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -21,6 +23,23 @@ func TestParseStream(t *testing.T) {
 			[]byte("\r\n"),
 		}),
 		protocol.MakeEmptyMultiBulkReply(),
+		protocol.MakeNullMultiBulkReply(),
+		protocol.MakeMultiBulkReply([][]byte{[]byte("a"), nil}),
+		protocol.MakeMultiRawReply([]redis.Reply{
+			protocol.MakeBulkReply([]byte("subscribe")),
+			protocol.MakeNullBulkReply(),
+			protocol.MakeIntReply(1),
+		}),
+		protocol.MakeMultiRawReply([]redis.Reply{
+			protocol.MakeStatusReply("OK"),
+			protocol.MakeErrReply("ERR unknown"),
+			protocol.MakeMultiBulkReply([][]byte{[]byte("a")}),
+			protocol.MakeMultiRawReply([]redis.Reply{
+				protocol.MakeIntReply(2),
+				protocol.MakeEmptyMultiBulkReply(),
+			}),
+			protocol.MakeBulkReply([]byte("b")),
+		}),
 	}
 	reqs := bytes.Buffer{}
 	for _, re := range replies {
@@ -79,3 +98,58 @@ func TestParseOne(t *testing.T) {
 		}
 	}
 }
+
+func TestParseRequestLimits(t *testing.T) {
+	cases := map[string]string{
+		"*2000000\r\n":                      "ERR Protocol error: invalid multibulk length",
+		"*x\r\n":                            "ERR Protocol error: invalid multibulk length",
+		"*1\r\n$600000000\r\n":              "ERR Protocol error: invalid bulk length",
+		"*1\r\n+OK\r\n":                     "ERR Protocol error: expected '$', got '+'",
+		strings.Repeat("a", 70000) + "\r\n": "ERR Protocol error: too big inline request",
+		"*" + strings.Repeat("1", 70000) + "\r\n": "ERR Protocol error: too big mbulk count string",
+	}
+	for req, expected := range cases {
+		ch := ParseRequestStream(strings.NewReader(req + "PING\r\n"))
+		payload := <-ch
+		if _, ok := payload.Err.(*protocol.ProtocolErrReply); !ok {
+			t.Errorf("expect protocol error, actual: %v", payload.Err)
+			continue
+		}
+		if payload.Err.Error() != expected {
+			t.Errorf("expect %s, actual: %s", expected, payload.Err.Error())
+		}
+		if _, ok := <-ch; ok {
+			t.Error("channel should be closed after protocol error")
+		}
+	}
+}
+
+func TestParseDeclaredSizePrealloc(t *testing.T) {
+	reqs := []string{
+		"*1\r\n$536870912\r\nabc",
+		"*1048576\r\n$3\r\nabc\r\n",
+	}
+	for _, req := range reqs {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		ch := ParseRequestStream(strings.NewReader(req))
+		payload := <-ch
+		runtime.ReadMemStats(&after)
+		if payload.Err == nil {
+			t.Errorf("expect error for truncated request %q", req)
+		}
+		if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1024*1024 {
+			t.Errorf("allocated %d bytes for request %q", alloc, req)
+		}
+	}
+	body := strings.Repeat("a", 10000)
+	ch := ParseRequestStream(strings.NewReader("*1\r\n$10000\r\n" + body + "\r\n"))
+	payload := <-ch
+	if payload.Err != nil {
+		t.Error(payload.Err)
+		return
+	}
+	if !utils.BytesEquals(payload.Data.(*protocol.MultiBulkReply).Args[0], []byte(body)) {
+		t.Error("wrong bulk string larger than preallocation")
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"io"
 	"strconv"
 	"strings"
+
+	"github.com/hdt3213/godis/redis/protocol"
 )
 
 func ParseV2(r io.Reader) ([][]byte, error) {
@@ -17,7 +19,9 @@ func ParseV2(r io.Reader) ([][]byte, error) {
 	if buf[0] != '*' {
 		// try text protocol
 		buf2, err := readLine(r)
-		if err != nil {
+		if err == errLineTooLong {
+			return nil, protocol.MakeProtocolErrReply("too big inline request")
+		} else if err != nil {
 			return nil, err
 		}
 		buf = append(buf, buf2...)
@@ -31,7 +35,11 @@ func ParseV2(r io.Reader) ([][]byte, error) {
 
 	// 读取参数数量
 	count, err := readInteger(r)
-	if err != nil {
+	if err == errLineTooLong {
+		return nil, protocol.MakeProtocolErrReply("too big mbulk count string")
+	} else if err == errInvalidInteger || count > int(RequestLimits.MaxMultiBulkLen) {
+		return nil, protocol.MakeProtocolErrReply("invalid multibulk length")
+	} else if err != nil {
 		return nil, err
 	}
 	if count < 0 {
@@ -39,7 +47,7 @@ func ParseV2(r io.Reader) ([][]byte, error) {
 	}
 
 	// 读取每个参数
-	result := make([][]byte, count)
+	result := make([][]byte, 0, arrayPrealloc(int64(count)))
 	for i := 0; i < count; i++ {
 		// 读取类型前缀
 		_, err := io.ReadFull(r, buf)
@@ -50,20 +58,23 @@ func ParseV2(r io.Reader) ([][]byte, error) {
 		switch buf[0] {
 		case '$': // Bulk String
 			strLen, err := readInteger(r)
-			if err != nil {
+			if err == errLineTooLong {
+				return nil, protocol.MakeProtocolErrReply("too big bulk count string")
+			} else if err == errInvalidInteger || strLen > int(RequestLimits.MaxBulkLen) {
+				return nil, protocol.MakeProtocolErrReply("invalid bulk length")
+			} else if err != nil {
 				return nil, err
 			}
 			if strLen < 0 {
-				result[i] = nil // Null Bulk String
+				result = append(result, nil) // Null Bulk String
 				continue
 			}
 
-			data := make([]byte, strLen+2)
-			_, err = io.ReadFull(r, data)
+			data, err := readBulkBody(r, int64(strLen)+2)
 			if err != nil {
 				return nil, err
 			}
-			result[i] = data[:strLen]
+			result = append(result, data[:strLen])
 
 		// case '+', ':': // Simple String or Integer
 		// 	simpleStr, err := readLine(r)
@@ -80,19 +91,25 @@ func ParseV2(r io.Reader) ([][]byte, error) {
 		// 	result[i] = []byte(errStr)
 
 		default:
-			return nil, errors.New("unsupported RESP type")
+			return nil, protocol.MakeProtocolErrReply("expected '$', got '" + string(buf[0]) + "'")
 		}
 	}
 
 	return result, nil
 }
 
+var errInvalidInteger = errors.New("invalid integer")
+
 func readInteger(r io.Reader) (int, error) {
 	line, err := readLine(r)
 	if err != nil {
 		return 0, err
 	}
-	return strconv.Atoi(string(line))
+	n, err := strconv.Atoi(string(line))
+	if err != nil {
+		return 0, errInvalidInteger
+	}
+	return n, nil
 }
 
 func readLine(r io.Reader) ([]byte, error) {
@@ -116,6 +133,9 @@ func readLine(r io.Reader) ([]byte, error) {
 			}
 			return line, nil
 		default:
+			if len(line) >= RequestLimits.MaxInlineLen {
+				return nil, errLineTooLong
+			}
 			line = append(line, buf[0])
 		}
 	}
@@ -110,6 +110,18 @@ func AssertNullBulk(t *testing.T, result redis.Reply) {
 	}
 }
 
+// AssertNullMultiBulk checks if the given redis.Reply is protocol.NullMultiBulkReply
+func AssertNullMultiBulk(t *testing.T, result redis.Reply) {
+	if result == nil {
+		t.Errorf("result is nil %s", printStack())
+		return
+	}
+	expect := (&protocol.NullMultiBulkReply{}).ToBytes()
+	if !utils.BytesEquals(expect, result.ToBytes()) {
+		t.Errorf("result is not null-multi-bulk-protocol %s", printStack())
+	}
+}
+
 // AssertMultiBulkReply checks if the given redis.Reply has the expected content
 func AssertMultiBulkReply(t *testing.T, actual redis.Reply, expected []string) {
 	multiBulk, ok := actual.(*protocol.MultiBulkReply)
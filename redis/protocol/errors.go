@@ -71,16 +71,22 @@ func (r *WrongTypeErrReply) Error() string {
 
 // ProtocolErr
 
-// ProtocolErrReply represents meeting unexpected byte during parse requests
+// ProtocolErrReply represents meeting unexpected byte during parse requests,
+// server should close the connection after sending it, same as redis
 type ProtocolErrReply struct {
 	Msg string
 }
 
+// MakeProtocolErrReply creates ProtocolErrReply
+func MakeProtocolErrReply(msg string) *ProtocolErrReply {
+	return &ProtocolErrReply{Msg: msg}
+}
+
 // ToBytes marshals redis.Reply
 func (r *ProtocolErrReply) ToBytes() []byte {
-	return []byte("-ERR Protocol error: '" + r.Msg + "'\r\n")
+	return []byte("-" + r.Error() + CRLF)
 }
 
 func (r *ProtocolErrReply) Error() string {
-	return "ERR Protocol error '" + r.Msg + "' command"
+	return "ERR Protocol error: " + r.Msg
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"strconv"
+	"strings"
 
 	"github.com/hdt3213/godis/interface/redis"
 )
@@ -175,7 +176,8 @@ func MakeErrReply(status string) *StandardErrReply {
 
 // IsErrorReply returns true if the given protocol is error
 func IsErrorReply(reply redis.Reply) bool {
-	return reply.ToBytes()[0] == '-'
+	bs := reply.ToBytes()
+	return len(bs) > 0 && bs[0] == '-'
 }
 
 func Try2ErrorReply(reply redis.Reply) error {
@@ -186,7 +188,7 @@ func Try2ErrorReply(reply redis.Reply) error {
 	if str[0] != '-' {
 		return nil
 	}
-	return errors.New(str[1:])
+	return errors.New(strings.TrimSuffix(str[1:], CRLF))
 }
 
 // ToBytes marshal redis.Reply
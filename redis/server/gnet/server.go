@@ -2,13 +2,17 @@ package gnet
 
 import (
 	"context"
+	"os"
+	"os/signal"
 	"sync/atomic"
+	"syscall"
 
 	"github.com/hdt3213/godis/interface/database"
 	"github.com/hdt3213/godis/interface/redis"
 	"github.com/hdt3213/godis/lib/logger"
 	"github.com/hdt3213/godis/redis/connection"
 	"github.com/hdt3213/godis/redis/parser"
+	"github.com/hdt3213/godis/redis/protocol"
 	"github.com/panjf2000/gnet/v2"
 )
 
@@ -17,11 +21,14 @@ type GnetServer struct {
 	eng       gnet.Engine
 	connected int32
 	db        database.DB
+	// closed by OnShutdown
+	stopped chan struct{}
 }
 
 func NewGnetServer(db database.DB) *GnetServer {
 	return &GnetServer{
-		db: db,
+		db:      db,
+		stopped: make(chan struct{}),
 	}
 }
 
@@ -31,9 +38,39 @@ func (s *GnetServer) Run(listenAddr string) error {
 
 func (s *GnetServer) OnBoot(eng gnet.Engine) (action gnet.Action) {
 	s.eng = eng
+	go s.waitStop()
 	return
 }
 
+// waitStop stops the engine on signals or after SHUTDOWN succeeded
+func (s *GnetServer) waitStop() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sigCh)
+	var shutdownChan <-chan struct{}
+	if sd, ok := s.db.(database.Shutdowner); ok {
+		shutdownChan = sd.ShutdownChan()
+	}
+	select {
+	case <-sigCh:
+	case <-shutdownChan:
+	case <-s.stopped:
+		return
+	}
+	logger.Info("shutting down...")
+	s.Close()
+}
+
+// OnShutdown saves data if needed and closes db
+func (s *GnetServer) OnShutdown(eng gnet.Engine) {
+	close(s.stopped)
+	if sd, ok := s.db.(database.Shutdowner); ok {
+		sd.Shutdown()
+	} else {
+		s.db.Close()
+	}
+}
+
 func (s *GnetServer) OnOpen(c gnet.Conn) (out []byte, action gnet.Action) {
 	client := connection.NewConn(c)
 	c.SetContext(client)
@@ -55,6 +92,9 @@ func (s *GnetServer) OnTraffic(c gnet.Conn) (action gnet.Action) {
 	conn := c.Context().(redis.Connection)
 	cmdLine, err := parser.ParseV2(c)
 	if err != nil {
+		if protocolErr, ok := err.(*protocol.ProtocolErrReply); ok {
+			c.Write(protocolErr.ToBytes())
+		}
 		logger.Infof("parse command line failed: %v", err)
 		return gnet.Close
 	}
@@ -1,6 +1,7 @@
 package std
 
 import (
+	"bytes"
 	"github.com/hdt3213/godis/lib/utils"
 	"github.com/hdt3213/godis/pubsub"
 	"github.com/hdt3213/godis/redis/connection"
@@ -48,3 +49,123 @@ func TestPublish(t *testing.T) {
 		t.Error("expect no msg")
 	}
 }
+
+func TestPSubscribe(t *testing.T) {
+	hub := pubsub.MakeHub()
+	conn := connection.NewFakeConn()
+	pubsub.Subscribe(hub, conn, utils.ToCmdLine("news.tech"))
+	conn.Clean()
+	pubsub.PSubscribe(hub, conn, utils.ToCmdLine("news.*", "sport.*"))
+	expected := "*3\r\n$10\r\npsubscribe\r\n$6\r\nnews.*\r\n:2\r\n" +
+		"*3\r\n$10\r\npsubscribe\r\n$7\r\nsport.*\r\n:3\r\n"
+	if string(conn.Bytes()) != expected {
+		t.Errorf("wrong psubscribe reply: %q", conn.Bytes())
+	}
+	conn.Clean()
+
+	// both channel and pattern subscription receive the message
+	reply := pubsub.Publish(hub, utils.ToCmdLine("news.tech", "hello"))
+	asserts.AssertIntReply(t, reply, 2)
+	ch := parser.ParseStream(bytes.NewReader(conn.Bytes()))
+	asserts.AssertMultiBulkReply(t, (<-ch).Data, []string{"message", "news.tech", "hello"})
+	asserts.AssertMultiBulkReply(t, (<-ch).Data, []string{"pmessage", "news.*", "news.tech", "hello"})
+	conn.Clean()
+
+	reply = pubsub.Publish(hub, utils.ToCmdLine("weather", "sunny"))
+	asserts.AssertIntReply(t, reply, 0)
+	if len(conn.Bytes()) > 0 {
+		t.Error("expect no msg")
+	}
+
+	pubsub.PUnSubscribe(hub, conn, utils.ToCmdLine("news.*"))
+	expected = "*3\r\n$12\r\npunsubscribe\r\n$6\r\nnews.*\r\n:2\r\n"
+	if string(conn.Bytes()) != expected {
+		t.Errorf("wrong punsubscribe reply: %q", conn.Bytes())
+	}
+	reply = pubsub.Publish(hub, utils.ToCmdLine("news.sport", "goal"))
+	asserts.AssertIntReply(t, reply, 0)
+
+	// unsubscribe all patterns
+	pubsub.PUnSubscribe(hub, conn, utils.ToCmdLine())
+	reply = pubsub.Publish(hub, utils.ToCmdLine("sport.ball", "goal"))
+	asserts.AssertIntReply(t, reply, 0)
+	conn.Clean()
+	pubsub.PUnSubscribe(hub, conn, utils.ToCmdLine())
+	if string(conn.Bytes()) != "*3\r\n$12\r\npunsubscribe\r\n$-1\r\n:0\r\n" {
+		t.Errorf("wrong punsubscribe reply: %q", conn.Bytes())
+	}
+}
+
+func TestShardPubSub(t *testing.T) {
+	hub := pubsub.MakeHub()
+	conn := connection.NewFakeConn()
+	pubsub.SSubscribe(hub, conn, utils.ToCmdLine("a", "b"))
+	expected := "*3\r\n$10\r\nssubscribe\r\n$1\r\na\r\n:1\r\n" +
+		"*3\r\n$10\r\nssubscribe\r\n$1\r\nb\r\n:2\r\n"
+	if string(conn.Bytes()) != expected {
+		t.Errorf("wrong ssubscribe reply: %q", conn.Bytes())
+	}
+	conn.Clean()
+
+	// shard channels are independent of normal channels
+	reply := pubsub.Publish(hub, utils.ToCmdLine("a", "hello"))
+	asserts.AssertIntReply(t, reply, 0)
+	reply = pubsub.SPublish(hub, utils.ToCmdLine("a", "hello"))
+	asserts.AssertIntReply(t, reply, 1)
+	ret, err := parser.ParseOne(conn.Bytes())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	asserts.AssertMultiBulkReply(t, ret, []string{"smessage", "a", "hello"})
+	conn.Clean()
+
+	pubsub.SUnSubscribe(hub, conn, utils.ToCmdLine("a"))
+	expected = "*3\r\n$12\r\nsunsubscribe\r\n$1\r\na\r\n:1\r\n"
+	if string(conn.Bytes()) != expected {
+		t.Errorf("wrong sunsubscribe reply: %q", conn.Bytes())
+	}
+	reply = pubsub.SPublish(hub, utils.ToCmdLine("a", "hello"))
+	asserts.AssertIntReply(t, reply, 0)
+
+	pubsub.UnsubscribeAll(hub, conn)
+	reply = pubsub.SPublish(hub, utils.ToCmdLine("b", "hello"))
+	asserts.AssertIntReply(t, reply, 0)
+}
+
+func TestPubSubIntrospect(t *testing.T) {
+	hub := pubsub.MakeHub()
+	conn1 := connection.NewFakeConn()
+	conn2 := connection.NewFakeConn()
+	pubsub.Subscribe(hub, conn1, utils.ToCmdLine("news.tech", "news.sport"))
+	pubsub.Subscribe(hub, conn2, utils.ToCmdLine("news.tech", "weather"))
+	pubsub.PSubscribe(hub, conn1, utils.ToCmdLine("news.*"))
+	pubsub.PSubscribe(hub, conn2, utils.ToCmdLine("news.*", "weather.*"))
+	pubsub.SSubscribe(hub, conn1, utils.ToCmdLine("orders"))
+
+	reply := pubsub.Introspect(hub, utils.ToCmdLine("channels"))
+	asserts.AssertMultiBulkReplySize(t, reply, 3)
+	reply = pubsub.Introspect(hub, utils.ToCmdLine("channels", "news.*"))
+	asserts.AssertMultiBulkReplySize(t, reply, 2)
+	reply = pubsub.Introspect(hub, utils.ToCmdLine("shardchannels"))
+	asserts.AssertMultiBulkReply(t, reply, []string{"orders"})
+
+	reply = pubsub.Introspect(hub, utils.ToCmdLine("numsub", "news.tech", "weather", "none"))
+	expected := "*6\r\n$9\r\nnews.tech\r\n:2\r\n$7\r\nweather\r\n:1\r\n$4\r\nnone\r\n:0\r\n"
+	if string(reply.ToBytes()) != expected {
+		t.Errorf("wrong numsub reply: %q", reply.ToBytes())
+	}
+	reply = pubsub.Introspect(hub, utils.ToCmdLine("numpat"))
+	asserts.AssertIntReply(t, reply, 2)
+
+	pubsub.UnsubscribeAll(hub, conn2)
+	reply = pubsub.Introspect(hub, utils.ToCmdLine("channels"))
+	asserts.AssertMultiBulkReplySize(t, reply, 2)
+	reply = pubsub.Introspect(hub, utils.ToCmdLine("numpat"))
+	asserts.AssertIntReply(t, reply, 1)
+
+	reply = pubsub.Introspect(hub, utils.ToCmdLine("numpat", "x"))
+	asserts.AssertErrReply(t, reply, "ERR wrong number of arguments for 'pubsub|numpat' command")
+	reply = pubsub.Introspect(hub, utils.ToCmdLine("foo"))
+	asserts.AssertErrReply(t, reply, "ERR unknown subcommand 'foo'. Try PUBSUB HELP.")
+}
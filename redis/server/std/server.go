@@ -6,10 +6,15 @@ package std
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"io"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
+	syncatomic "sync/atomic"
+	"time"
 
 	"github.com/hdt3213/godis/cluster"
 	"github.com/hdt3213/godis/config"
@@ -17,6 +22,7 @@ import (
 	idatabase "github.com/hdt3213/godis/interface/database"
 	"github.com/hdt3213/godis/lib/logger"
 	"github.com/hdt3213/godis/lib/sync/atomic"
+	"github.com/hdt3213/godis/lib/sync/wait"
 	"github.com/hdt3213/godis/redis/connection"
 	"github.com/hdt3213/godis/redis/parser"
 	"github.com/hdt3213/godis/redis/protocol"
@@ -27,11 +33,15 @@ var (
 	unknownErrReplyBytes = []byte("-ERR unknown\r\n")
 )
 
+// closeTimeout is the max duration waiting for in-flight commands during shutdown
+const closeTimeout = 10 * time.Second
+
 // Handler implements tcp.Handler and serves as a redis server
 type Handler struct {
 	activeConn sync.Map // *client -> placeholder
 	db         idatabase.DB
 	closing    atomic.Boolean // refusing new client and new request
+	inflight   wait.Wait      // commands being executed
 }
 
 // MakeHandler creates a Handler instance
@@ -42,17 +52,63 @@ func MakeHandler() *Handler {
 	} else {
 		db = database.NewStandaloneServer()
 	}
+	db, err := database.WithRenamedCommands(db, config.Properties.RenameCommands)
+	if err != nil {
+		panic(err)
+	}
+	return NewHandler(database.WithTracing(db))
+}
+
+// NewHandler creates a Handler serving the given db, the db is closed with handler
+func NewHandler(db idatabase.DB) *Handler {
 	return &Handler{
 		db: db,
 	}
 }
 
 func Serve(addr string, handler *Handler) error {
-	return tcp.ListenAndServeWithSignal(&tcp.Config{
+	return serve(&tcp.Config{
 		Address: addr,
 	}, handler)
 }
 
+// ServeTLS serves plain connections on addr and TLS connections on tlsAddr, no plain listener if addr is empty
+func ServeTLS(addr string, tlsAddr string, tlsConfig *tls.Config, handler *Handler) error {
+	return serve(&tcp.Config{
+		Address:    addr,
+		TLSAddress: tlsAddr,
+		TLSConfig:  tlsConfig,
+	}, handler)
+}
+
+func serve(cfg *tcp.Config, handler *Handler) error {
+	cfg.StopChan = handler.shutdownChan()
+	cfg.MaxConnect = uint32(config.Properties.MaxClients)
+	cfg.KeepAlive = -1 // disabled
+	if config.Properties.TCPKeepalive > 0 {
+		cfg.KeepAlive = time.Duration(config.Properties.TCPKeepalive) * time.Second
+	}
+	// maxclients could be changed by CONFIG SET
+	unregister := config.RegisterCallback("maxclients", func(value string) error {
+		maxClients, err := strconv.Atoi(value)
+		if err != nil || maxClients < 0 {
+			return errors.New("argument must be a non-negative integer")
+		}
+		syncatomic.StoreUint32(&cfg.MaxConnect, uint32(maxClients))
+		return nil
+	})
+	defer unregister()
+	return tcp.ListenAndServeWithSignal(cfg, handler)
+}
+
+// shutdownChan returns a channel closed after SHUTDOWN succeeded
+func (h *Handler) shutdownChan() <-chan struct{} {
+	if s, ok := h.db.(idatabase.Shutdowner); ok {
+		return s.ShutdownChan()
+	}
+	return nil
+}
+
 func (h *Handler) closeClient(client *connection.Connection) {
 	_ = client.Close()
 	h.db.AfterClientClose(client)
@@ -71,7 +127,7 @@ func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
 	client := connection.NewConn(conn)
 	h.activeConn.Store(client, struct{}{})
 
-	ch := parser.ParseStream(conn)
+	ch := parser.ParseRequestStream(conn)
 	for payload := range ch {
 		if payload.Err != nil {
 			if payload.Err == io.EOF ||
@@ -82,6 +138,13 @@ func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
 				logger.Info("connection closed: " + client.RemoteAddr())
 				return
 			}
+			if protocolErr, ok := payload.Err.(*protocol.ProtocolErrReply); ok {
+				// the stream cannot be recovered, reply and close connection like redis
+				_, _ = client.Write(protocolErr.ToBytes())
+				h.closeClient(client)
+				logger.Info("connection closed for protocol error: " + client.RemoteAddr())
+				return
+			}
 			// protocol err
 			errReply := protocol.MakeErrReply(payload.Err.Error())
 			_, err := client.Write(errReply.ToBytes())
@@ -101,12 +164,18 @@ func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
 			logger.Error("require multi bulk protocol")
 			continue
 		}
+		if h.closing.Get() {
+			// refuse new request during shutdown
+			break
+		}
+		h.inflight.Add(1)
 		result := h.db.Exec(client, r.Args)
 		if result != nil {
 			_, _ = client.Write(result.ToBytes())
 		} else {
 			_, _ = client.Write(unknownErrReplyBytes)
 		}
+		h.inflight.Done()
 	}
 }
 
@@ -114,12 +183,19 @@ func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
 func (h *Handler) Close() error {
 	logger.Info("handler shutting down...")
 	h.closing.Set(true)
-	// TODO: concurrent wait
+	// finish in-flight commands, blocking commands may not return in time
+	if h.inflight.WaitWithTimeout(closeTimeout) {
+		logger.Warn("timeout waiting for in-flight commands")
+	}
 	h.activeConn.Range(func(key interface{}, val interface{}) bool {
 		client := key.(*connection.Connection)
 		_ = client.Close()
 		return true
 	})
-	h.db.Close()
+	if s, ok := h.db.(idatabase.Shutdowner); ok {
+		s.Shutdown()
+	} else {
+		h.db.Close()
+	}
 	return nil
 }
@@ -42,3 +42,88 @@ func TestListenAndServe(t *testing.T) {
 	closeChan <- struct{}{}
 	time.Sleep(time.Second)
 }
+
+func TestProtocolError(t *testing.T) {
+	closeChan := make(chan struct{})
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	go tcp.ListenAndServe(listener, MakeHandler(), closeChan)
+	defer func() {
+		closeChan <- struct{}{}
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = conn.Write([]byte("*2000000\r\n"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	bufReader := bufio.NewReader(conn)
+	line, _, err := bufReader.ReadLine()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(line) != "-ERR Protocol error: invalid multibulk length" {
+		t.Error("get wrong response: " + string(line))
+		return
+	}
+	_, _, err = bufReader.ReadLine()
+	if err == nil {
+		t.Error("connection should be closed")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+	handler := MakeHandler()
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(addr, handler)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	bufReader := bufio.NewReader(conn)
+	_, _ = conn.Write([]byte("SHUTDOWN NOSAVE SAVE\r\n"))
+	line, _, err := bufReader.ReadLine()
+	if err != nil || string(line) != "-Err syntax error" {
+		t.Errorf("expect syntax error, actual: %s %v", line, err)
+		return
+	}
+	_, _ = conn.Write([]byte("SHUTDOWN NOSAVE\r\n"))
+	line, _, err = bufReader.ReadLine()
+	if err != nil || string(line) != "+OK" {
+		t.Errorf("expect OK, actual: %s %v", line, err)
+		return
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("server is still running after SHUTDOWN")
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Error("server should stop accepting connections")
+	}
+}
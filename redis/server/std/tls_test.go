@@ -0,0 +1,110 @@
+package std
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/godis/config"
+	"github.com/hdt3213/godis/lib/utils"
+	"github.com/hdt3213/godis/redis/client"
+	"github.com/hdt3213/godis/redis/protocol/asserts"
+	"github.com/hdt3213/godis/tcp"
+)
+
+// writeSelfSignedCert writes a self-signed certificate for 127.0.0.1, it is also used as CA
+func writeSelfSignedCert(t *testing.T, dir string) (certFile string, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "godis"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, "godis.crt")
+	keyFile = filepath.Join(dir, "godis.key")
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLS(t *testing.T) {
+	certFile, keyFile := writeSelfSignedCert(t, t.TempDir())
+	properties := &config.ServerProperties{
+		TLSCertFile:    certFile,
+		TLSKeyFile:     keyFile,
+		TLSCACertFile:  certFile,
+		TLSAuthClients: true,
+	}
+	serverConfig, err := properties.ServerTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeChan := make(chan struct{})
+	go tcp.ListenAndServe(listener, MakeHandler(), closeChan)
+	defer func() {
+		closeChan <- struct{}{}
+	}()
+	addr := listener.Addr().String()
+
+	clientConfig, err := properties.ClientTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := client.MakeTLSClient(addr, clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Start()
+	asserts.AssertStatusReply(t, c.Send(utils.ToCmdLine("ping")), "PONG")
+	c.Close()
+
+	// client without certificate is rejected if tls-auth-clients is enabled
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: clientConfig.RootCAs})
+	if err == nil {
+		_, err = conn.Write([]byte("PING\r\n"))
+		if err == nil {
+			_, err = conn.Read(make([]byte, 16))
+		}
+		_ = conn.Close()
+	}
+	if err == nil {
+		t.Error("expect handshake error")
+	}
+}
@@ -86,3 +86,53 @@ func TestClientCounter(t *testing.T) {
 		t.Errorf("client counter error: %d", ClientCounter)
 	}
 }
+
+func TestMaxConnect(t *testing.T) {
+	closeChan := make(chan struct{})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	addr := listener.Addr().String()
+	go serve(listener, MakeEchoHandler(), closeChan, &Config{MaxConnect: 1})
+	defer func() {
+		closeChan <- struct{}{}
+	}()
+
+	// connections from localhost could use reserved slots
+	for i := 0; i < 1+reservedLocalSlots; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte("a\n"))
+		line, _, err := bufio.NewReader(conn).ReadLine()
+		if err != nil || string(line) != "a" {
+			t.Errorf("connection %d should be served", i)
+			return
+		}
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	line, _, err := bufio.NewReader(conn).ReadLine()
+	if err != nil || string(line) != "-ERR max number of clients reached" {
+		t.Errorf("expect refused, actual: %s %v", line, err)
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	remote, server := net.Pipe()
+	defer remote.Close()
+	defer server.Close()
+	// pipe has no tcp address, so it is treated as a remote connection
+	if !admit(server, 0, 100) || !admit(server, 2, 1) || admit(server, 2, 2) {
+		t.Error("wrong admission")
+	}
+}
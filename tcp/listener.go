@@ -0,0 +1,80 @@
+package tcp
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// multiListener accepts connections from several listeners, such as the listeners of port and tls-port
+type multiListener struct {
+	listeners []net.Listener
+	connChan  chan net.Conn
+	errChan   chan error
+	closeChan chan struct{}
+	closeOnce sync.Once
+}
+
+func newMultiListener(listeners []net.Listener) *multiListener {
+	ml := &multiListener{
+		listeners: listeners,
+		connChan:  make(chan net.Conn),
+		errChan:   make(chan error, len(listeners)),
+		closeChan: make(chan struct{}),
+	}
+	for _, listener := range listeners {
+		go ml.serve(listener)
+	}
+	return ml
+}
+
+func (ml *multiListener) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			ml.errChan <- err
+			return
+		}
+		select {
+		case ml.connChan <- conn:
+		case <-ml.closeChan:
+			_ = conn.Close()
+			return
+		}
+	}
+}
+
+// Accept waits for the next connection from any of the listeners
+func (ml *multiListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ml.connChan:
+		return conn, nil
+	case err := <-ml.errChan:
+		return nil, err
+	case <-ml.closeChan:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes all the listeners
+func (ml *multiListener) Close() error {
+	var err error
+	ml.closeOnce.Do(func() {
+		close(ml.closeChan)
+		for _, listener := range ml.listeners {
+			if e := listener.Close(); e != nil {
+				err = e
+			}
+		}
+	})
+	return err
+}
+
+// Addr returns address of the first listener
+func (ml *multiListener) Addr() net.Addr {
+	return ml.listeners[0].Addr()
+}
@@ -0,0 +1,39 @@
+package tcp
+
+import (
+	"bufio"
+	"net"
+	"testing"
+)
+
+func TestMultiListener(t *testing.T) {
+	var listeners []net.Listener
+	for i := 0; i < 2; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners = append(listeners, listener)
+	}
+	closeChan := make(chan struct{})
+	go ListenAndServe(newMultiListener(listeners), MakeEchoHandler(), closeChan)
+	for _, listener := range listeners {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = conn.Write([]byte("hello\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		line, _, err := bufio.NewReader(conn).ReadLine()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(line) != "hello" {
+			t.Error("get wrong response")
+		}
+		_ = conn.Close()
+	}
+	closeChan <- struct{}{}
+}
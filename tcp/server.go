@@ -6,6 +6,8 @@ package tcp
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -21,38 +23,100 @@ import (
 
 // Config stores tcp server properties
 type Config struct {
-	Address    string        `yaml:"address"`
+	Address string `yaml:"address"` // no plain listener if it is empty
+	// MaxConnect limits live connections, 0 means no limit. It is accessed atomically so it could be changed while serving
 	MaxConnect uint32        `yaml:"max-connect"`
 	Timeout    time.Duration `yaml:"timeout"`
+	// TLSAddress accepts TLS connections using TLSConfig if TLSConfig is not nil
+	TLSAddress string
+	TLSConfig  *tls.Config
+	// StopChan stops the server like signals once it is closed, such as by SHUTDOWN. nil means never
+	StopChan <-chan struct{}
+	// KeepAlive is the keep-alive period of accepted connections, same as net.ListenConfig:
+	// 0 means the default period of go, negative disables keep-alive
+	KeepAlive time.Duration
 }
 
 // ClientCounter Record the number of clients in the current Godis server
 var ClientCounter int32
 
+// reservedLocalSlots is the count of connections from localhost accepted beyond MaxConnect, for admin rescue
+const reservedLocalSlots = 4
+
+var maxClientsErrBytes = []byte("-ERR max number of clients reached\r\n")
+
 // ListenAndServeWithSignal binds port and handle requests, blocking until receive stop signal
 func ListenAndServeWithSignal(cfg *Config, handler tcp.Handler) error {
 	closeChan := make(chan struct{})
-	sigCh := make(chan os.Signal)
+	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
 	go func() {
-		sig := <-sigCh
-		switch sig {
-		case syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
+		select {
+		case sig := <-sigCh:
+			switch sig {
+			case syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
+				closeChan <- struct{}{}
+			}
+		case <-cfg.StopChan:
 			closeChan <- struct{}{}
 		}
 	}()
-	listener, err := net.Listen("tcp", cfg.Address)
-	if err != nil {
-		return err
+	var listeners []net.Listener
+	listenConfig := &net.ListenConfig{KeepAlive: cfg.KeepAlive}
+	if cfg.Address != "" {
+		listener, err := listenConfig.Listen(context.Background(), "tcp", cfg.Address)
+		if err != nil {
+			return err
+		}
+		//cfg.Address = listener.Addr().String()
+		logger.Info(fmt.Sprintf("bind: %s, start listening...", cfg.Address))
+		listeners = append(listeners, listener)
+	}
+	if cfg.TLSConfig != nil {
+		listener, err := listenConfig.Listen(context.Background(), "tcp", cfg.TLSAddress)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return err
+		}
+		listener = tls.NewListener(listener, cfg.TLSConfig)
+		logger.Info(fmt.Sprintf("bind tls: %s, start listening...", cfg.TLSAddress))
+		listeners = append(listeners, listener)
+	}
+	if len(listeners) == 0 {
+		return errors.New("no address to listen")
+	}
+	if len(listeners) == 1 {
+		serve(listeners[0], handler, closeChan, cfg)
+	} else {
+		serve(newMultiListener(listeners), handler, closeChan, cfg)
 	}
-	//cfg.Address = listener.Addr().String()
-	logger.Info(fmt.Sprintf("bind: %s, start listening...", cfg.Address))
-	ListenAndServe(listener, handler, closeChan)
 	return nil
 }
 
 // ListenAndServe binds port and handle requests, blocking until close
 func ListenAndServe(listener net.Listener, handler tcp.Handler, closeChan <-chan struct{}) {
+	serve(listener, handler, closeChan, &Config{})
+}
+
+// admit returns whether a new connection could be served, live is the count of connections being served
+func admit(conn net.Conn, maxConnect uint32, live int32) bool {
+	if maxConnect == 0 || int64(live) < int64(maxConnect) {
+		return true
+	}
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	return ok && addr.IP.IsLoopback() && int64(live) < int64(maxConnect)+reservedLocalSlots
+}
+
+// reject replies error to the connection refused by admit then closes it
+func reject(conn net.Conn) {
+	_ = conn.SetWriteDeadline(time.Now().Add(time.Second))
+	_, _ = conn.Write(maxClientsErrBytes)
+	_ = conn.Close()
+}
+
+func serve(listener net.Listener, handler tcp.Handler, closeChan <-chan struct{}, cfg *Config) {
 	// listen signal
 	errCh := make(chan error, 1)
 	defer close(errCh)
@@ -70,6 +134,8 @@ func ListenAndServe(listener net.Listener, handler tcp.Handler, closeChan <-chan
 
 	ctx := context.Background()
 	var waitDone sync.WaitGroup
+	// count of connections served by this listener, ClientCounter counts all listeners
+	var live int32
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
@@ -82,13 +148,20 @@ func ListenAndServe(listener net.Listener, handler tcp.Handler, closeChan <-chan
 			errCh <- err
 			break
 		}
+		if !admit(conn, atomic.LoadUint32(&cfg.MaxConnect), atomic.LoadInt32(&live)) {
+			logger.Warn("max number of clients reached, refuse " + conn.RemoteAddr().String())
+			go reject(conn)
+			continue
+		}
 		// handle
 		// logger.Info("accept link")
-		ClientCounter++
+		atomic.AddInt32(&live, 1)
+		atomic.AddInt32(&ClientCounter, 1)
 		waitDone.Add(1)
 		go func() {
 			defer func() {
 				waitDone.Done()
+				atomic.AddInt32(&live, -1)
 				atomic.AddInt32(&ClientCounter, -1)
 			}()
 			handler.Handle(ctx, conn)